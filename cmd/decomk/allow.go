@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/expand"
+	"github.com/stevegt/decomk/recipetmpl"
+	"github.com/stevegt/decomk/resolve"
+	"github.com/stevegt/decomk/toolchain"
+)
+
+// makefileTupleNames are the tuples that pull whole makefiles, and so any
+// targets they define, into a run: decomk's own DECOMK_MAKEFILES and GNU
+// make's MAKEFILES.
+var makefileTupleNames = []string{makefilesTuple, "MAKEFILES"}
+
+// checkLayerAllowed returns an error naming the first key in defs or recipe in
+// recipes, from the config layer described by layer, that the root config's
+// DECOMK_ALLOW patterns (allow) do not match. That includes keys and recipes
+// the root config defines itself (rootDefs, rootRecipes): a lower layer that
+// redefines one can append to or replace what the root set.
+//
+// Intent: Reject out-of-bounds additions from lower-precedence layers while
+// resolving, so a plan never includes targets the root config did not permit.
+// Source: DI-jajoh (TODO-jirin)
+func checkLayerAllowed(allow []string, layer string, defs contexts.Defs, recipes contexts.Recipes, rootDefs contexts.Defs, rootRecipes contexts.Recipes) error {
+	for _, name := range sortedDefKeys(defs) {
+		if contexts.IsDirective(name) || contexts.Allowed(allow, name) {
+			continue
+		}
+		if _, ok := rootDefs[name]; ok {
+			return fmt.Errorf("%s: key %q redefines a root config key and is not matched by its %s list (%s)", layer, name, contexts.AllowKey, strings.Join(allow, " "))
+		}
+		return fmt.Errorf("%s: key %q is not defined by the root config or matched by its %s list (%s)", layer, name, contexts.AllowKey, strings.Join(allow, " "))
+	}
+	names := make([]string, 0, len(recipes))
+	for name := range recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if contexts.Allowed(allow, name) {
+			continue
+		}
+		if _, ok := rootRecipes[name]; ok {
+			return fmt.Errorf("%s: recipe %q redefines a root config recipe and is not matched by its %s list (%s)", layer, name, contexts.AllowKey, strings.Join(allow, " "))
+		}
+		return fmt.Errorf("%s: recipe %q is not defined by the root config or matched by its %s list (%s)", layer, name, contexts.AllowKey, strings.Join(allow, " "))
+	}
+	return nil
+}
+
+// checkLayerTokensAllowed returns an error naming the first token in defs,
+// from the config layer described by layer, that introduces a make target
+// the root config's DECOMK_ALLOW patterns (allow) do not match, unless
+// rootDefs uses the same token itself:
+//   - `mise:`/`asdf:` tokens introduce their generated install target,
+//   - `tmpl:` tokens introduce their generated template target,
+//   - DECOMK_MAKEFILES and MAKEFILES tuples introduce every target of the
+//     makefiles they list, and are matched by the tuple name.
+//
+// Malformed tokens are skipped; contexts.ValidateRefs reports them.
+//
+// Intent: Bound the targets a lower layer can add through tokens as well as
+// through key and recipe names, so the allow-list cannot be sidestepped by
+// generated targets or extra makefiles.
+// Source: DI-jajoh (TODO-jirin)
+func checkLayerTokensAllowed(allow []string, layer string, defs contexts.Defs, rootDefs contexts.Defs) error {
+	rootTokens := make(map[string]bool)
+	for _, tokens := range rootDefs {
+		for _, token := range tokens {
+			rootTokens[unguarded(token)] = true
+		}
+	}
+	for _, name := range sortedDefKeys(defs) {
+		for _, token := range defs[name] {
+			target, ok := tokenTarget(token)
+			if !ok || rootTokens[unguarded(token)] || contexts.Allowed(allow, target) {
+				continue
+			}
+			return fmt.Errorf("%s: token %q in key %q introduces %q, which is not matched by the root config's %s list (%s)", layer, token, name, target, contexts.AllowKey, strings.Join(allow, " "))
+		}
+	}
+	return nil
+}
+
+// tokenTarget returns the name checkLayerTokensAllowed matches token by, and
+// whether token introduces targets at all.
+func tokenTarget(token string) (string, bool) {
+	token = unguarded(token)
+	if toolchain.IsToken(token) {
+		spec, err := toolchain.Parse(token)
+		if err != nil {
+			return "", false
+		}
+		return spec.Target(), true
+	}
+	if recipetmpl.IsToken(token) {
+		call, err := recipetmpl.Parse(token)
+		if err != nil {
+			return "", false
+		}
+		return call.Target(), true
+	}
+	if name, _, ok := tokenTuple(token); ok {
+		for _, makefiles := range makefileTupleNames {
+			if name == makefiles {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// lowerTuples maps each tuple name a token in defs (a lower config layer)
+// assigns to the target words the root config's own assignments of that name,
+// in rootDefs, hold. It is only meaningful while the root sets DECOMK_ALLOW.
+func lowerTuples(defs contexts.Defs, rootDefs contexts.Defs) map[string][]string {
+	rootWords := make(map[string][]string)
+	for _, tokens := range rootDefs {
+		for _, token := range tokens {
+			if name, value, ok := tokenTuple(token); ok {
+				rootWords[name] = append(rootWords[name], splitTargetList(value)...)
+			}
+		}
+	}
+	out := make(map[string][]string)
+	for _, tokens := range defs {
+		for _, token := range tokens {
+			if name, _, ok := tokenTuple(token); ok {
+				out[name] = rootWords[name]
+			}
+		}
+	}
+	return out
+}
+
+// mergeLowerTuples adds src's entries to dst, keeping dst's entry for a name
+// both have, and returns dst.
+func mergeLowerTuples(dst, src map[string][]string) map[string][]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string][]string, len(src))
+	}
+	for name, words := range src {
+		if _, ok := dst[name]; !ok {
+			dst[name] = words
+		}
+	}
+	return dst
+}
+
+// checkSelectedTargetsAllowed returns an error naming the first target an
+// action arg selects through a tuple in lower (see lowerTuples) that is
+// neither one of the root config's own words for that tuple nor matched by
+// the root config's DECOMK_ALLOW patterns (allow). Targets named literally on
+// the command line are not checked.
+//
+// Intent: Keep a lower layer from choosing what runs by assigning an action
+// tuple (for example `INSTALL=any-target`), which key, recipe, and token
+// checks alone cannot see.
+// Source: DI-bojin (TODO-jirin)
+func checkSelectedTargetsAllowed(allow []string, lower map[string][]string, tuples, actionArgs []string) error {
+	if len(allow) == 0 || len(lower) == 0 {
+		return nil
+	}
+	values := effectiveTupleValues(tuples)
+	for _, arg := range actionArgs {
+		rootWords, ok := lower[arg]
+		value, isTuple := values[arg]
+		if !ok || !isTuple {
+			continue
+		}
+		for _, target := range splitTargetList(value) {
+			if slices.Contains(rootWords, target) || contexts.Allowed(allow, target) {
+				continue
+			}
+			return fmt.Errorf("target %q selected through %s, which a lower config layer sets, is not matched by the root config's %s list (%s)", target, arg, contexts.AllowKey, strings.Join(allow, " "))
+		}
+	}
+	return nil
+}
+
+// tokenTuple splits token, ignoring any condition guard and class prefix, into
+// a tuple name and value.
+func tokenTuple(token string) (name, value string, ok bool) {
+	_, tuple := resolve.SplitClass(unguarded(token))
+	return resolve.SplitTuple(tuple)
+}
+
+// unguarded returns the token a conditional token guards, or token itself.
+func unguarded(token string) string {
+	if cond, ok := expand.ParseCondition(token); ok {
+		return cond.Then
+	}
+	return token
+}
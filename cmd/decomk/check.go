@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/stevegt/decomk/makeexec"
+	"github.com/stevegt/decomk/state"
+)
+
+// cmdCheck validates a config repo checkout for CI.
+//
+// It loads decomk.conf, then resolves the plan and runs make -n for ARGS once
+// per defined context (each with DEFAULT), and once more for the synthetic
+// -workspace-list when given. All state goes to a throwaway DECOMK_HOME, so
+// check needs neither root nor an existing decomk install. It exits 1 if any
+// step fails.
+//
+// Intent: Catch config and Makefile regressions in config-repo CI before
+// containers pull them.
+// Source: DI-marim (TODO-jirin)
+//
+// With -nagios, check runs `healthz -nagios` instead, with healthz's flags.
+func cmdCheck(args []string, stdout, stderr io.Writer) (code int, retErr error) {
+	// Intent: Offer the monitoring plugin under the `check` name Nagios,
+	// Icinga, and Sensu setups expect, without duplicating healthz's
+	// evaluation.
+	// Source: DI-kulah (TODO-jirin)
+	if nagiosRequested(args) {
+		return cmdHealthz(args, stdout, stderr)
+	}
+	fs := flag.NewFlagSet("decomk check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var confRoot, confPath, makefile, workspaceList, colorMode string
+	// Only listed for -help: nagiosRequested hands -nagios runs to healthz
+	// before parsing.
+	fs.Bool("nagios", false, "run the healthz -nagios monitoring plugin check instead (with healthz's -home, -max-age, -crit-age and -skip-pending flags)")
+	fs.StringVar(&confRoot, "conf-dir", ".", "config repo checkout to validate")
+	fs.StringVar(&confPath, "conf-path", "", "relative subdirectory of the config repo holding decomk.conf and Makefile (also DECOMK_CONF_PATH)")
+	fs.StringVar(&makefile, "makefile", "", "makefile path or pinned https URL override")
+	fs.StringVar(&workspaceList, "workspace-list", "", "comma-separated owner/repo names to also resolve together, as if checked out under /workspaces")
+	addColorFlag(fs, &colorMode)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	actionArgs := fs.Args()
+	if len(actionArgs) == 0 {
+		return 2, fmt.Errorf("decomk check requires at least one action arg")
+	}
+	colors, err := newPalette(colorMode, stdout)
+	if err != nil {
+		return 2, err
+	}
+	confRoot, err = filepath.Abs(confRoot)
+	if err != nil {
+		return 1, fmt.Errorf("abs config repo path: %w", err)
+	}
+	repos, err := parseWorkspaceList(workspaceList)
+	if err != nil {
+		return 2, err
+	}
+
+	home, err := os.MkdirTemp("", "decomk-check-*")
+	if err != nil {
+		return 1, fmt.Errorf("create check home: %w", err)
+	}
+	defer func() {
+		if rmErr := os.RemoveAll(home); rmErr != nil {
+			wrapped := fmt.Errorf("remove check home %s: %w", home, rmErr)
+			if retErr == nil {
+				code, retErr = 1, wrapped
+				return
+			}
+			retErr = errors.Join(retErr, wrapped)
+		}
+	}()
+
+	confDir, err := resolveConfDirIn(confRoot, confPath)
+	if err != nil {
+		return 2, err
+	}
+	cfg, err := loadDefs(confDir, "")
+	if err != nil {
+		if err := writeLine(stdout, colors.red("FAIL  config:"), err.Error()); err != nil {
+			return 1, err
+		}
+		return 1, fmt.Errorf("check failed: invalid config")
+	}
+	keys := make([]string, 0, len(cfg.Defs))
+	for key := range cfg.Defs {
+		if key != "DEFAULT" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := cfg.Defs["DEFAULT"]; ok {
+		keys = append([]string{"DEFAULT"}, keys...)
+	}
+
+	base := commonFlags{home: home, confRoot: confRoot, confPath: confPath, makefile: makefile}
+	type checkCase struct {
+		label string
+		flags commonFlags
+	}
+	var cases []checkCase
+	for _, key := range keys {
+		f := base
+		f.context = key
+		cases = append(cases, checkCase{label: "context " + key, flags: f})
+	}
+	if repos != nil {
+		f := base
+		f.workspaceList = repos
+		cases = append(cases, checkCase{label: "workspaces " + workspaceList, flags: f})
+	}
+
+	failed := 0
+	for _, c := range cases {
+		summary, warnings, err := checkPlan(c.flags, actionArgs)
+		for _, warning := range warnings {
+			if err := writeLine(stdout, colors.yellow("warn  "+c.label+":"), warning); err != nil {
+				return 1, err
+			}
+		}
+		if err != nil {
+			failed++
+			if err := writeLine(stdout, colors.red("FAIL  "+c.label+":"), indentLines(err.Error(), "      ")); err != nil {
+				return 1, err
+			}
+			continue
+		}
+		if err := writeLine(stdout, colors.green("ok    "+c.label+":"), summary); err != nil {
+			return 1, err
+		}
+	}
+	if err := writeFormat(stdout, "check: %d checked, %d failed\n", len(cases), failed); err != nil {
+		return 1, err
+	}
+	if failed > 0 {
+		return 1, fmt.Errorf("check failed: %d of %d failed", failed, len(cases))
+	}
+	return 0, nil
+}
+
+// parseWorkspaceList turns "owner/repo,repo" into synthetic workspaces; it
+// returns nil for an empty list.
+func parseWorkspaceList(raw string) ([]workspaceRepo, error) {
+	var repos []workspaceRepo
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		owner, name, hasOwner := strings.Cut(entry, "/")
+		if !hasOwner {
+			owner, name = "", entry
+		}
+		if name == "" || strings.Contains(name, "/") || (hasOwner && owner == "") {
+			return nil, fmt.Errorf("invalid -workspace-list entry %q (expected owner/repo or repo)", entry)
+		}
+		repo := workspaceRepo{Name: name, RepoName: name}
+		if hasOwner {
+			repo.OwnerRepo = entry
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// nagiosRequested reports whether args, up to a "--" terminator, set the
+// -nagios flag.
+func nagiosRequested(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		name, ok := strings.CutPrefix(arg, "-")
+		if !ok {
+			continue
+		}
+		name = strings.TrimPrefix(name, "-")
+		name, value, hasValue := strings.Cut(name, "=")
+		if name != "nagios" {
+			continue
+		}
+		if !hasValue {
+			return true
+		}
+		set, err := strconv.ParseBool(value)
+		return err == nil && set
+	}
+	return false
+}
+
+// checkPlan resolves one plan and runs make -n for actionArgs, returning a
+// one-line summary and the plan's warnings. make's output is included in the
+// error when it fails.
+func checkPlan(f commonFlags, actionArgs []string) (string, []string, error) {
+	plan, err := resolvePlanFromFlags(f)
+	if err != nil {
+		return "", nil, err
+	}
+	warnings := append(plan.Warnings, wellKnownVarWarnings(plan.Tuples, plan.TupleClasses)...)
+	if len(plan.Makefiles) == 0 {
+		return "", warnings, fmt.Errorf("no Makefile found; use -makefile or DECOMK_MAKEFILES to set explicit paths")
+	}
+	incomingEnvList := os.Environ()
+	incomingEnv := envMapFromList(incomingEnvList)
+	plan.Tuples, err = resolveRuntimeTuples(plan.Tuples, incomingEnv)
+	if err != nil {
+		return "", warnings, err
+	}
+	if err := checkSelectedTargetsAllowed(plan.Allow, plan.LowerTuples, plan.Tuples, actionArgs); err != nil {
+		return "", warnings, err
+	}
+	targets, _, _ := selectTargets(plan.Tuples, actionArgs, nil)
+	cookedTuples := canonicalEnvTuples(plan, targets, incomingEnv)
+	if err := state.EnsureDir(plan.StampDir); err != nil {
+		return "", warnings, err
+	}
+	if err := writeGeneratedMakefiles(plan); err != nil {
+		return "", warnings, err
+	}
+	makeTuples, makeEnv := makeInvocation(incomingEnvList, cookedTuples, plan.TupleClasses)
+
+	var output bytes.Buffer
+	if _, err := makeexec.RunMakefilesCommand(plan.StampDir, planMakefiles(plan), []string{"make"}, []string{"-n"}, makeTuples, targets, makeEnv, &output, &output); err != nil {
+		return "", warnings, fmt.Errorf("make -n %s: %w\n%s", strings.Join(targets, " "), err, strings.TrimRight(output.String(), "\n"))
+	}
+	return fmt.Sprintf("make -n %s (contexts: %s)", strings.Join(targets, " "), strings.Join(plan.ContextKeys, " ")), warnings, nil
+}
+
+// indentLines prefixes every line after the first with indent.
+func indentLines(text, indent string) string {
+	return strings.ReplaceAll(text, "\n", "\n"+indent)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWorkspaceList(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseWorkspaceList(" acme/app , tools ,")
+	if err != nil {
+		t.Fatalf("parseWorkspaceList(): %v", err)
+	}
+	want := []workspaceRepo{
+		{Name: "app", RepoName: "app", OwnerRepo: "acme/app"},
+		{Name: "tools", RepoName: "tools"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseWorkspaceList(): got %+v want %+v", got, want)
+	}
+	if got, err := parseWorkspaceList(""); err != nil || got != nil {
+		t.Fatalf("parseWorkspaceList(empty): got %+v, %v", got, err)
+	}
+	for _, bad := range []string{"/app", "acme/", "a/b/c"} {
+		if _, err := parseWorkspaceList(bad); err == nil {
+			t.Fatalf("parseWorkspaceList(%q): expected error", bad)
+		}
+	}
+}
+
+func TestCmdCheck_Nagios(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	for _, args := range [][]string{
+		{"-nagios", "-home", home},
+		{"-home", home, "--nagios=true", "-max-age", "1h"},
+	} {
+		var stdout, stderr bytes.Buffer
+		code, err := cmdCheck(args, &stdout, &stderr)
+		if err != nil || code != nagiosUnknown {
+			t.Fatalf("cmdCheck(%q): got code=%d err=%v want %d, nil (stderr=%q)", args, code, err, nagiosUnknown, stderr.String())
+		}
+		if got, want := stdout.String(), "DECOMK UNKNOWN - no recorded run\n"; got != want {
+			t.Fatalf("cmdCheck(%q) stdout: got %q want %q", args, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"-nagios"}, true},
+		{[]string{"-conf-dir", ".", "--nagios", "INSTALL"}, true},
+		{[]string{"-nagios=false", "INSTALL"}, false},
+		{[]string{"INSTALL", "--", "-nagios"}, false},
+		{[]string{"-nagiosx"}, false},
+	} {
+		if got := nagiosRequested(tc.args); got != tc.want {
+			t.Fatalf("nagiosRequested(%q): got %v want %v", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestCmdCheck(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	confDir := t.TempDir()
+	config := strings.Join([]string{
+		"DEFAULT: INSTALL='base'",
+		"acme/app: INSTALL='base app'",
+		"broken: INSTALL='base missing'",
+		"",
+	}, "\n")
+	if err := os.WriteFile(filepath.Join(confDir, "decomk.conf"), []byte(config), 0o600); err != nil {
+		t.Fatalf("WriteFile(decomk.conf): %v", err)
+	}
+	makefile := "base:\n\ttouch $@\napp:\n\ttouch $@\n"
+	if err := os.WriteFile(filepath.Join(confDir, "Makefile"), []byte(makefile), 0o600); err != nil {
+		t.Fatalf("WriteFile(Makefile): %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdCheck([]string{"-conf-dir", confDir, "-workspace-list", "acme/app", "INSTALL"}, &stdout, &stderr)
+	if code != 1 || err == nil || !strings.Contains(err.Error(), "1 of 4 failed") {
+		t.Fatalf("cmdCheck(): got %d, %v (stdout=%q)", code, err, stdout.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"ok    context DEFAULT: make -n base (contexts: DEFAULT)\n",
+		"ok    context acme/app: make -n base app (contexts: DEFAULT acme/app)\n",
+		"FAIL  context broken: make -n base missing:",
+		"      make: *** No rule to make target",
+		"ok    workspaces acme/app: make -n base app (contexts: DEFAULT acme/app)\n",
+		"check: 4 checked, 1 failed\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("cmdCheck() stdout missing %q:\n%s", want, out)
+		}
+	}
+	for _, name := range []string{"base", "app"} {
+		if _, err := os.Stat(filepath.Join(confDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("cmdCheck() created %s in the config repo (err=%v)", name, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(confDir, "decomk.conf"), []byte("DEFAULT: INSTALL='base'\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(decomk.conf): %v", err)
+	}
+	stdout.Reset()
+	code, err = cmdCheck([]string{"-conf-dir", confDir, "INSTALL"}, &stdout, &stderr)
+	if code != 0 || err != nil {
+		t.Fatalf("cmdCheck(valid): got %d, %v (stdout=%q)", code, err, stdout.String())
+	}
+
+	if err := os.WriteFile(filepath.Join(confDir, "decomk.conf"), []byte("DEFAULT: UNDEFINED\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(decomk.conf): %v", err)
+	}
+	stdout.Reset()
+	code, err = cmdCheck([]string{"-conf-dir", confDir, "INSTALL"}, &stdout, &stderr)
+	if code != 1 || err == nil || !strings.HasPrefix(stdout.String(), "FAIL  config:") {
+		t.Fatalf("cmdCheck(invalid config): got %d, %v (stdout=%q)", code, err, stdout.String())
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// collapseKeep is how many consecutive similar lines the console shows before
+// hiding the rest of the run.
+const collapseKeep = 3
+
+// consoleCollapser filters make output for the terminal: after collapseKeep
+// consecutive lines with the same shape (see collapseKey) it hides the rest,
+// and when the run ends it writes how many lines it hid and the last of them.
+// A line redrawn with carriage returns (progress bars, spinners) shows only
+// its final state. Flush ends the current run and writes a trailing partial
+// line.
+//
+// Intent: Keep the console readable during spinner- or compiler-heavy recipes
+// without losing anything from the run log, which is written unfiltered.
+// Source: DI-lutag (TODO-jirin)
+type consoleCollapser struct {
+	w       io.Writer
+	pending []byte
+	// shown is the part of the current line already written through, so a
+	// prompt without a newline is visible before the user answers it.
+	shown []byte
+
+	runKey     string
+	runLen     int
+	hidden     int
+	lastHidden string
+}
+
+// newConsoleCollapser returns a consoleCollapser writing to w.
+func newConsoleCollapser(w io.Writer) *consoleCollapser {
+	return &consoleCollapser{w: w}
+}
+
+func (c *consoleCollapser) Write(p []byte) (int, error) {
+	c.pending = append(c.pending, p...)
+	for {
+		i := bytes.IndexByte(c.pending, '\n')
+		if i < 0 {
+			break
+		}
+		rest := string(c.pending[:i])
+		c.pending = c.pending[i+1:]
+		if err := c.completeLine(rest); err != nil {
+			return 0, err
+		}
+	}
+	// Pass a partial line through unless it is a redraw or would be hidden.
+	if len(c.pending) > 0 && !bytes.ContainsRune(c.pending, '\r') && c.hidden == 0 && c.runLen < collapseKeep {
+		if _, err := c.w.Write(c.pending); err != nil {
+			return 0, err
+		}
+		c.shown = append(c.shown, c.pending...)
+		c.pending = nil
+	}
+	return len(p), nil
+}
+
+// Flush ends the current run of similar lines and writes a trailing partial
+// line.
+func (c *consoleCollapser) Flush() error {
+	if len(c.pending) > 0 || len(c.shown) > 0 {
+		rest := string(c.pending)
+		c.pending = nil
+		if err := c.completeLine(rest); err != nil {
+			return err
+		}
+	}
+	return c.endRun()
+}
+
+// completeLine handles a line whose newline arrived; rest is the part not
+// already written through.
+func (c *consoleCollapser) completeLine(rest string) error {
+	if len(c.shown) > 0 {
+		line := string(c.shown) + rest
+		c.shown = nil
+		if _, err := io.WriteString(c.w, rest+"\n"); err != nil {
+			return err
+		}
+		c.count(finalRedraw(line))
+		return nil
+	}
+	return c.line(finalRedraw(rest))
+}
+
+// line shows or hides one complete line.
+func (c *consoleCollapser) line(line string) error {
+	key, ok := collapseKey(line)
+	if ok && key == c.runKey && c.runLen >= collapseKeep {
+		c.runLen++
+		c.hidden++
+		c.lastHidden = line
+		return nil
+	}
+	if !ok || key != c.runKey {
+		if err := c.endRun(); err != nil {
+			return err
+		}
+	}
+	c.count(line)
+	_, err := io.WriteString(c.w, line+"\n")
+	return err
+}
+
+// count records a shown line in the current run.
+func (c *consoleCollapser) count(line string) {
+	key, ok := collapseKey(line)
+	if ok && key == c.runKey {
+		c.runLen++
+		return
+	}
+	c.runKey, c.runLen = key, 1
+	if !ok {
+		c.runKey, c.runLen = "", 0
+	}
+}
+
+// endRun writes the summary of the lines the current run hid.
+func (c *consoleCollapser) endRun() error {
+	hidden, last := c.hidden, c.lastHidden
+	c.runKey, c.runLen, c.hidden, c.lastHidden = "", 0, 0, ""
+	switch hidden {
+	case 0:
+		return nil
+	case 1:
+		_, err := io.WriteString(c.w, last+"\n")
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "  ... %d similar lines hidden (full output in make.log; -full-output shows all)\n", hidden-1); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.w, last+"\n")
+	return err
+}
+
+// finalRedraw returns what a terminal would show for line: the text after its
+// last carriage return.
+func finalRedraw(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+	if i := strings.LastIndexByte(line, '\r'); i >= 0 {
+		return line[i+1:]
+	}
+	return line
+}
+
+// collapseKey returns the shape similar lines share: the first word with
+// digit runs replaced by '#'. ok is false for lines that are never hidden:
+// blank lines, decomk's and make's own messages, and anything mentioning an
+// error, warning, or failure.
+func collapseKey(line string) (key string, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+	first := fields[0]
+	if strings.HasPrefix(first, "decomk:") || strings.HasPrefix(first, "make:") || strings.HasPrefix(first, "make[") {
+		return "", false
+	}
+	lower := strings.ToLower(line)
+	for _, word := range []string{"error", "warning", "fail", "fatal"} {
+		if strings.Contains(lower, word) {
+			return "", false
+		}
+	}
+	var b strings.Builder
+	digits := false
+	for _, r := range first {
+		if unicode.IsDigit(r) {
+			if !digits {
+				b.WriteByte('#')
+			}
+			digits = true
+			continue
+		}
+		digits = false
+		b.WriteRune(r)
+	}
+	return b.String(), true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestConsoleCollapser(t *testing.T) {
+	t.Parallel()
+
+	var input strings.Builder
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&input, "CC src/file%d.o\n", i)
+	}
+	input.WriteString("CC src/broken.c: error: expected ';'\n")
+	input.WriteString("Downloading 10%\rDownloading 55%\rDownloading 100%\n")
+	input.WriteString("done\n")
+
+	var out bytes.Buffer
+	c := newConsoleCollapser(&out)
+	if _, err := c.Write([]byte(input.String())); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush(): %v", err)
+	}
+	want := "CC src/file1.o\nCC src/file2.o\nCC src/file3.o\n" +
+		"  ... 6 similar lines hidden (full output in make.log; -full-output shows all)\n" +
+		"CC src/file10.o\n" +
+		"CC src/broken.c: error: expected ';'\n" +
+		"Downloading 100%\n" +
+		"done\n"
+	if got := out.String(); got != want {
+		t.Fatalf("consoleCollapser output:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestConsoleCollapser_PartialLinePassesThrough(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	c := newConsoleCollapser(&out)
+	if _, err := c.Write([]byte("Continue? [y/N] ")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	if got, want := out.String(), "Continue? [y/N] "; got != want {
+		t.Fatalf("prompt output: got %q want %q", got, want)
+	}
+	if _, err := c.Write([]byte("y\nok\n")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush(): %v", err)
+	}
+	if got, want := out.String(), "Continue? [y/N] y\nok\n"; got != want {
+		t.Fatalf("output: got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// -color values.
+const (
+	colorNever  = "never"
+	colorAuto   = "auto"
+	colorAlways = "always"
+)
+
+// ANSI SGR sequences used by palette.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// addColorFlag defines the -color flag shared by commands with status output.
+func addColorFlag(fs *flag.FlagSet, mode *string) {
+	fs.StringVar(mode, "color", colorAuto, "colorize terminal output: never, auto (only on a terminal, unless NO_COLOR is set), or always")
+}
+
+// palette colors text written to one terminal; the zero value is plain.
+type palette struct {
+	enabled bool
+}
+
+// newPalette decides whether output to w is colored under mode.
+//
+// Intent: Make failures and pending env changes stand out in interactive use
+// without changing scripted output; logs and generated files stay plain.
+// Source: DI-mofur (TODO-jirin)
+func newPalette(mode string, w io.Writer) (palette, error) {
+	switch mode {
+	case colorNever:
+		return palette{}, nil
+	case colorAlways:
+		return palette{enabled: true}, nil
+	case colorAuto:
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return palette{}, nil
+		}
+		return palette{enabled: isTerminal(w)}, nil
+	default:
+		return palette{}, fmt.Errorf("invalid -color %q (expected never, auto, or always)", mode)
+	}
+}
+
+// isTerminal reports whether w is a character device such as a tty.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p palette) wrap(code, text string) string {
+	if !p.enabled || text == "" {
+		return text
+	}
+	return code + text + ansiReset
+}
+
+func (p palette) bold(text string) string   { return p.wrap(ansiBold, text) }
+func (p palette) red(text string) string    { return p.wrap(ansiRed, text) }
+func (p palette) green(text string) string  { return p.wrap(ansiGreen, text) }
+func (p palette) yellow(text string) string { return p.wrap(ansiYellow, text) }
+
+// statusOutput writes decomk's own status lines to the terminal, colored, and
+// to the run log (when set), plain.
+type statusOutput struct {
+	term   io.Writer
+	log    io.Writer
+	colors palette
+}
+
+// line writes text followed by a newline; color is one of the palette
+// methods, or nil for plain text.
+func (s statusOutput) line(color func(palette, string) string, text string) error {
+	colored := text
+	if color != nil {
+		colored = color(s.colors, text)
+	}
+	if err := writeLine(s.term, colored); err != nil {
+		return err
+	}
+	if s.log != nil {
+		return writeLine(s.log, text)
+	}
+	return nil
+}
+
+// writePlanEnvExport writes the env exports plan would write, coloring export
+// lines that are new (green) or changed (yellow) relative to the env export
+// file at currentPath.
+func writePlanEnvExport(w io.Writer, plan *resolvedPlan, cookedTuples []string, currentPath string, colors palette) error {
+	if !colors.enabled {
+		return writeEnvExport(w, plan, cookedTuples)
+	}
+	var rendered bytes.Buffer
+	if err := writeEnvExport(&rendered, plan, cookedTuples); err != nil {
+		return err
+	}
+	current := map[string]string{}
+	if content, err := os.ReadFile(currentPath); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			if name, ok := exportLineName(line); ok {
+				current[name] = line
+			}
+		}
+	}
+	scanner := bufio.NewScanner(&rendered)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := exportLineName(line); ok {
+			previous, existed := current[name]
+			switch {
+			case !existed:
+				line = colors.green(line)
+			case previous != line:
+				line = colors.yellow(line)
+			}
+		}
+		if err := writeLine(w, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// exportLineName returns NAME from an "export NAME=value" line.
+func exportLineName(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, "export ")
+	if !ok {
+		return "", false
+	}
+	name, _, ok := strings.Cut(rest, "=")
+	return name, ok
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewPalette(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	for mode, want := range map[string]bool{colorNever: false, colorAuto: false, colorAlways: true} {
+		colors, err := newPalette(mode, &buf)
+		if err != nil {
+			t.Fatalf("newPalette(%q): %v", mode, err)
+		}
+		if colors.enabled != want {
+			t.Fatalf("newPalette(%q).enabled: got %v want %v", mode, colors.enabled, want)
+		}
+	}
+	if _, err := newPalette("sometimes", &buf); err == nil {
+		t.Fatalf("newPalette(sometimes): expected error")
+	}
+	if got := (palette{}).red("failed"); got != "failed" {
+		t.Fatalf("palette{}.red(): got %q want plain", got)
+	}
+	if got, want := (palette{enabled: true}).red("failed"), ansiRed+"failed"+ansiReset; got != want {
+		t.Fatalf("palette.red(): got %q want %q", got, want)
+	}
+}
+
+func TestWritePlanEnvExport_HighlightsChanges(t *testing.T) {
+	t.Parallel()
+
+	currentPath := filepath.Join(t.TempDir(), "env.sh")
+	current := "# generated by decomk; do not edit\nexport SAME='1'\nexport CHANGED='old'\n"
+	if err := os.WriteFile(currentPath, []byte(current), 0o644); err != nil {
+		t.Fatalf("WriteFile(env.sh): %v", err)
+	}
+	plan := &resolvedPlan{}
+	cooked := []string{"SAME=1", "CHANGED=new", "ADDED=x"}
+
+	var out bytes.Buffer
+	if err := writePlanEnvExport(&out, plan, cooked, currentPath, palette{enabled: true}); err != nil {
+		t.Fatalf("writePlanEnvExport(): %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"\nexport SAME='1'\n",
+		"\n" + ansiYellow + "export CHANGED='new'" + ansiReset + "\n",
+		"\n" + ansiGreen + "export ADDED='x'" + ansiReset + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("writePlanEnvExport() missing %q:\n%q", want, got)
+		}
+	}
+
+	out.Reset()
+	if err := writePlanEnvExport(&out, plan, cooked, currentPath, palette{}); err != nil {
+		t.Fatalf("writePlanEnvExport(plain): %v", err)
+	}
+	if strings.Contains(out.String(), "\x1b") {
+		t.Fatalf("writePlanEnvExport(plain): got escape sequences:\n%q", out.String())
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/resolve"
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	configSubcommandGet   = "get"
+	configSubcommandSet   = "set"
+	configSubcommandUnset = "unset"
+)
+
+// localConfigHeader starts a local override file decomk creates.
+const localConfigHeader = `# decomk local overrides: personal tweaks layered between the config repo
+# and -config. Managed by "decomk config set/unset"; other keys may be added
+# by hand.
+`
+
+// cmdConfig dispatches `decomk config` subcommands.
+func cmdConfig(args []string, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		return 2, fmt.Errorf("config subcommand required\n\n%s", configUsage())
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		if err := writeLine(stdout, configUsage()); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	case configSubcommandGet:
+		return cmdConfigGet(args[1:], stdout, stderr)
+	case configSubcommandSet:
+		return cmdConfigEdit(configSubcommandSet, args[1:], stderr)
+	case configSubcommandUnset:
+		return cmdConfigEdit(configSubcommandUnset, args[1:], stderr)
+	default:
+		return 2, fmt.Errorf("unknown config subcommand: %s\n\n%s", args[0], configUsage())
+	}
+}
+
+func configUsage() string {
+	return `decomk config - read and write local overrides in <DECOMK_HOME>/local.conf
+
+Usage:
+  decomk config get [-home <dir>] [NAME...]
+  decomk config set [-home <dir>] NAME=value...
+  decomk config unset [-home <dir>] NAME...
+
+Subcommands:
+  get
+      Print the local value of each NAME, or every local NAME=value tuple
+      when no NAME is given. Exits 1 if a NAME is not set locally.
+  set
+      Set tuples in local.conf's DEFAULT key, replacing earlier local
+      assignments of the same names.
+  unset
+      Remove every local assignment of each NAME.
+
+local.conf uses decomk.conf syntax. It is layered above the config repo and
+below -config/DECOMK_CONFIG; its keys append to the config repo's keys, so
+its tuples win over shared values of the same names.
+`
+}
+
+// cmdConfigGet prints local tuple values.
+func cmdConfigGet(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk config get", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	path := state.LocalConfigPath(home)
+	_, tokens, _, err := readLocalConfig(path)
+	if err != nil {
+		return 1, err
+	}
+	if fs.NArg() == 0 {
+		for _, token := range tokens {
+			if _, tuple := resolve.SplitClass(token); isTupleToken(tuple) {
+				if err := writeLine(stdout, tuple); err != nil {
+					return 1, err
+				}
+			}
+		}
+		return 0, nil
+	}
+	values := localTupleValues(tokens)
+	for _, name := range fs.Args() {
+		value, ok := values[name]
+		if !ok {
+			return 1, fmt.Errorf("%s is not set in %s", name, path)
+		}
+		if err := writeLine(stdout, value); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// cmdConfigEdit runs `config set` or `config unset` (per sub) on local.conf.
+func cmdConfigEdit(sub string, args []string, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk config "+sub, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() == 0 {
+		return 2, fmt.Errorf("config %s requires at least one argument\n\n%s", sub, configUsage())
+	}
+	var names, tuples []string
+	for _, arg := range fs.Args() {
+		if sub == configSubcommandUnset {
+			names = append(names, arg)
+			continue
+		}
+		name, _, ok := resolve.SplitTuple(arg)
+		if !ok {
+			return 2, fmt.Errorf("config set: %q is not a NAME=value tuple", arg)
+		}
+		names = append(names, name)
+		tuples = append(tuples, arg)
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	if err := editLocalConfig(state.LocalConfigPath(home), names, tuples); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// readLocalConfig returns the content of the local override file at path and
+// the tokens and merge mode of its DEFAULT key. A missing file is empty.
+func readLocalConfig(path string) (content []byte, tokens []string, mode contexts.MergeMode, err error) {
+	content, err = os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, "", nil
+		}
+		return nil, nil, "", fmt.Errorf("read %s: %w", path, err)
+	}
+	cfg, err := contexts.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return content, cfg.Defs["DEFAULT"], cfg.Merges["DEFAULT"], nil
+}
+
+// localTupleValues returns the last value each tuple in tokens assigns.
+func localTupleValues(tokens []string) map[string]string {
+	values := make(map[string]string)
+	for _, token := range tokens {
+		_, tuple := resolve.SplitClass(token)
+		if name, value, ok := resolve.SplitTuple(tuple); ok {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// isTupleToken reports whether token is a NAME=value tuple.
+func isTupleToken(token string) bool {
+	_, _, ok := resolve.SplitTuple(token)
+	return ok
+}
+
+// editLocalConfig removes every assignment of names from the DEFAULT key of
+// the local override file at path, appends tuples, and rewrites the file.
+//
+// Only the DEFAULT key line is rewritten; comments and other keys are kept.
+// A DEFAULT spread over continuation lines is refused rather than mangled.
+func editLocalConfig(path string, names, tuples []string) error {
+	content, tokens, mode, err := readLocalConfig(path)
+	if err != nil {
+		return err
+	}
+	drop := make(map[string]bool, len(names))
+	for _, name := range names {
+		drop[name] = true
+	}
+	var kept []string
+	for _, token := range tokens {
+		_, tuple := resolve.SplitClass(token)
+		if name, _, ok := resolve.SplitTuple(tuple); ok && drop[name] {
+			continue
+		}
+		kept = append(kept, token)
+	}
+	kept = append(kept, tuples...)
+
+	var line string
+	if len(kept) > 0 {
+		var b strings.Builder
+		b.WriteString("DEFAULT: ")
+		if mode != "" {
+			fmt.Fprintf(&b, "merge %s; ", mode)
+		}
+		for i, token := range kept {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(renderConfigToken(token))
+		}
+		line = b.String()
+	}
+
+	if content == nil {
+		content = []byte(localConfigHeader)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	at := -1
+	for i, l := range lines {
+		if strings.HasPrefix(strings.TrimSpace(l), "DEFAULT:") {
+			at = i
+		}
+	}
+	switch {
+	case at >= 0 && line != "":
+		lines[at] = line
+	case at >= 0:
+		lines = append(lines[:at], lines[at+1:]...)
+	case line != "":
+		lines = append(lines, line)
+	}
+	updated := []byte(strings.Join(lines, "\n") + "\n")
+
+	cfg, err := contexts.Parse(bytes.NewReader(updated))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if got := cfg.Defs["DEFAULT"]; !reflect.DeepEqual(got, kept) && (len(got) > 0 || len(kept) > 0) {
+		return fmt.Errorf("cannot update %s: its DEFAULT key spans continuation lines; edit it by hand", path)
+	}
+	return state.AtomicWrite(path, updated, 0o644)
+}
+
+// renderConfigToken renders token in decomk.conf syntax, single-quoting tuple
+// values that would not survive as one bare word.
+func renderConfigToken(token string) string {
+	class, tuple := resolve.SplitClass(token)
+	name, value, ok := resolve.SplitTuple(tuple)
+	if !ok {
+		return token
+	}
+	rendered := name + "=" + configQuote(value)
+	if class != "" {
+		rendered = class + " " + rendered
+	}
+	return rendered
+}
+
+// configQuote returns s as decomk.conf token text: unchanged when it has no
+// whitespace, quotes, backslashes, or leading '#', else single-quoted with
+// each embedded quote closed, backslash-escaped, and reopened.
+func configQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t'\\") && !strings.HasPrefix(s, "#") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
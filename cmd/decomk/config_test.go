@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestCmdConfig_SetGetUnset(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	run := func(args ...string) (int, string, error) {
+		var stdout, stderr bytes.Buffer
+		code, err := cmdConfig(append(append([]string{}, args[0], "-home", home), args[1:]...), &stdout, &stderr)
+		return code, stdout.String(), err
+	}
+
+	if code, _, err := run("set", "EDITOR=nvim", "GREETING=it's a test", "EMPTY="); code != 0 || err != nil {
+		t.Fatalf("config set: code=%d err=%v", code, err)
+	}
+	if code, out, err := run("get", "GREETING", "EMPTY"); code != 0 || err != nil || out != "it's a test\n\n" {
+		t.Fatalf("config get: code=%d out=%q err=%v", code, out, err)
+	}
+	if code, _, err := run("set", "EDITOR=vim"); code != 0 || err != nil {
+		t.Fatalf("config set (replace): code=%d err=%v", code, err)
+	}
+	if code, out, err := run("get"); code != 0 || err != nil || out != "GREETING=it's a test\nEMPTY=\nEDITOR=vim\n" {
+		t.Fatalf("config get (all): code=%d out=%q err=%v", code, out, err)
+	}
+	if code, _, err := run("unset", "GREETING", "EMPTY"); code != 0 || err != nil {
+		t.Fatalf("config unset: code=%d err=%v", code, err)
+	}
+	if code, _, err := run("get", "GREETING"); code != 1 || err == nil || !strings.Contains(err.Error(), "GREETING is not set") {
+		t.Fatalf("config get (unset): code=%d err=%v", code, err)
+	}
+	if code, _, err := run("set", "NOT-A-TUPLE"); code != 2 || err == nil {
+		t.Fatalf("config set (bad arg): code=%d err=%v", code, err)
+	}
+
+	content, err := os.ReadFile(state.LocalConfigPath(home))
+	if err != nil {
+		t.Fatalf("ReadFile(local.conf): %v", err)
+	}
+	if !strings.HasPrefix(string(content), localConfigHeader) || !strings.HasSuffix(string(content), "\nDEFAULT: EDITOR=vim\n") {
+		t.Fatalf("local.conf: got %q", content)
+	}
+}
+
+func TestEditLocalConfig_KeepsHandEditsAndRefusesContinuations(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "local.conf")
+	hand := "# mine\nTOOLS: JQ=1\nDEFAULT: merge prepend; TOOLS A=1\n"
+	if err := os.WriteFile(path, []byte(hand), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := editLocalConfig(path, []string{"B"}, []string{"B=two words"}); err != nil {
+		t.Fatalf("editLocalConfig() error: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if want := "# mine\nTOOLS: JQ=1\nDEFAULT: merge prepend; TOOLS A=1 B='two words'\n"; string(content) != want {
+		t.Fatalf("local.conf: got %q want %q", content, want)
+	}
+
+	if err := os.WriteFile(path, []byte("DEFAULT: A=1\n  C=3\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	err = editLocalConfig(path, []string{"A"}, []string{"A=2"})
+	if err == nil || !strings.Contains(err.Error(), "continuation lines") {
+		t.Fatalf("editLocalConfig(continuation) error: got %v", err)
+	}
+}
+
+func TestLoadDefsWithLocal_Precedence(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	configRepo := filepath.Join(home, "conf", "decomk.conf")
+	if err := os.MkdirAll(filepath.Dir(configRepo), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(configRepo, []byte("DEFAULT: EDITOR=vi SHELLX=bash\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	local := state.LocalConfigPath(home)
+	if err := editLocalConfig(local, []string{"EDITOR"}, []string{"EDITOR=nvim"}); err != nil {
+		t.Fatalf("editLocalConfig: %v", err)
+	}
+
+	cfg, err := loadDefsWithLocal(state.ConfDir(home), local, "")
+	if err != nil {
+		t.Fatalf("loadDefsWithLocal() error: %v", err)
+	}
+	if got, want := cfg.Defs["DEFAULT"], []string{"EDITOR=vi", "SHELLX=bash", "EDITOR=nvim"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("DEFAULT: got %q want %q", got, want)
+	}
+	if want := []string{configRepo, local}; !reflect.DeepEqual(cfg.Paths, want) {
+		t.Fatalf("paths: got %q want %q", cfg.Paths, want)
+	}
+
+	// An explicit config still has the last word.
+	explicit := filepath.Join(t.TempDir(), "decomk.conf")
+	if err := os.WriteFile(explicit, []byte("DEFAULT: EDITOR=emacs\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err = loadDefsWithLocal(state.ConfDir(home), local, explicit)
+	if err != nil {
+		t.Fatalf("loadDefsWithLocal(explicit) error: %v", err)
+	}
+	if got, want := cfg.Defs["DEFAULT"], []string{"EDITOR=emacs"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("DEFAULT with -config: got %q want %q", got, want)
+	}
+}
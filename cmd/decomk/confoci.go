@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	// ociManifestMaxBytes caps a config artifact manifest download.
+	ociManifestMaxBytes = 4 << 20
+
+	// dockerHubAPIHost serves the registry API for docker.io references.
+	dockerHubAPIHost = "registry-1.docker.io"
+
+	// dockerHubAuthKey is the docker config key holding docker.io credentials.
+	dockerHubAuthKey = "https://index.docker.io/v1/"
+)
+
+// ociManifestMediaTypes are the manifest formats decomk reads.
+var ociManifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ociRef is a parsed `registry/repository[:tag]@sha256:<hex>` reference.
+type ociRef struct {
+	Registry   string
+	Repository string
+	Tag        string
+	// Digest is the pinned manifest digest, "sha256:<hex>", or "" when
+	// unpinned.
+	Digest string
+}
+
+// parseOCIRef parses ref the way docker does: a first path component
+// containing '.' or ':' (or "localhost") names the registry, and anything else
+// is a docker.io repository.
+func parseOCIRef(ref string) (ociRef, error) {
+	var r ociRef
+	name := ref
+	if before, digest, ok := strings.Cut(ref, "@"); ok {
+		name = before
+		hexDigest, found := strings.CutPrefix(digest, "sha256:")
+		if !found || len(hexDigest) != sha256.Size*2 {
+			return r, fmt.Errorf("OCI reference %q has invalid digest %q (expected sha256:<hex>)", ref, digest)
+		}
+		if _, err := hex.DecodeString(hexDigest); err != nil {
+			return r, fmt.Errorf("OCI reference %q has invalid digest %q (expected sha256:<hex>)", ref, digest)
+		}
+		r.Digest = "sha256:" + strings.ToLower(hexDigest)
+	}
+	if i := strings.LastIndexByte(name, ':'); i > strings.LastIndexByte(name, '/') {
+		name, r.Tag = name[:i], name[i+1:]
+		if r.Tag == "" {
+			return r, fmt.Errorf("OCI reference %q has an empty tag", ref)
+		}
+	}
+	first, rest, ok := strings.Cut(name, "/")
+	switch {
+	case ok && (strings.ContainsAny(first, ".:") || first == "localhost"):
+		r.Registry, r.Repository = first, rest
+	case ok:
+		r.Registry, r.Repository = "docker.io", name
+	default:
+		r.Registry, r.Repository = "docker.io", "library/"+name
+	}
+	if r.Repository == "" || strings.HasSuffix(r.Repository, "/") {
+		return r, fmt.Errorf("OCI reference %q is missing a repository", ref)
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// apiHost returns the host serving the registry API for r.
+func (r ociRef) apiHost() string {
+	if r.Registry == "docker.io" {
+		return dockerHubAPIHost
+	}
+	return r.Registry
+}
+
+// installConfOCI makes state.ConfDir(home) hold the config artifact ref
+// (`registry/repository[:tag]@sha256:<hex>`): it pulls the pinned manifest,
+// downloads its single tar+gzip layer into the config tarball cache, and
+// unpacks it the way installConfTarball does. Registry credentials come from
+// the docker config (see dockerCredentials).
+//
+// Intent: Distribute config through existing container registries and their
+// auth instead of git hosting, keeping runs reproducible with a mandatory
+// manifest digest pin.
+// Source: DI-vukom (TODO-jirin)
+func installConfOCI(client *http.Client, home, ref string) error {
+	r, err := parseOCIRef(ref)
+	if err != nil {
+		return err
+	}
+	puller := &ociPuller{
+		client: client,
+		ref:    r,
+		creds:  func() (string, string, bool, error) { return dockerCredentials(r.Registry) },
+	}
+	return installConfArchive(home, r.Digest, func() (string, error) {
+		return puller.fetchConfLayer(ref, state.ConfTarballCacheDir(home))
+	})
+}
+
+// ociPuller reads one repository through the registry HTTP API, answering
+// Bearer and Basic auth challenges.
+type ociPuller struct {
+	client *http.Client
+	ref    ociRef
+	// creds returns registry credentials, or ok false for anonymous pulls.
+	creds func() (user, secret string, ok bool, err error)
+
+	authorization string
+	// credsErr is a credential lookup failure; pulls continue anonymously and
+	// report it if the registry refuses them.
+	credsErr error
+}
+
+// fetchConfLayer returns the cached path of the config layer of the manifest
+// p.ref names, downloading and verifying the manifest and layer as needed.
+// ref is the user's reference, for messages.
+func (p *ociPuller) fetchConfLayer(ref, cacheDir string) (string, error) {
+	manifestRef := p.ref.Digest
+	if manifestRef == "" {
+		manifestRef = p.ref.Tag
+	}
+	body, err := p.getBytes("/manifests/"+manifestRef, ociManifestMediaTypes, ociManifestMaxBytes)
+	if err != nil {
+		return "", fmt.Errorf("pull %s manifest: %w", ref, err)
+	}
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if p.ref.Digest == "" {
+		return "", fmt.Errorf("OCI reference %s is not pinned; append @%s after verifying its contents", ref, digest)
+	}
+	if digest != p.ref.Digest {
+		return "", fmt.Errorf("OCI reference %s: manifest digest mismatch: pinned %s, downloaded %s", ref, p.ref.Digest, digest)
+	}
+
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Layers    []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", fmt.Errorf("parse %s manifest: %w", ref, err)
+	}
+	var layers []string
+	for _, layer := range manifest.Layers {
+		if strings.HasSuffix(layer.MediaType, "tar+gzip") || layer.MediaType == "application/vnd.docker.image.rootfs.diff.tar.gzip" {
+			layers = append(layers, layer.Digest)
+		}
+	}
+	if len(layers) != 1 {
+		return "", fmt.Errorf("OCI reference %s has %d tar+gzip layers (expected exactly one holding the config files)", ref, len(layers))
+	}
+	layerHex, ok := strings.CutPrefix(layers[0], "sha256:")
+	if !ok || len(layerHex) != sha256.Size*2 {
+		return "", fmt.Errorf("OCI reference %s: unsupported layer digest %q", ref, layers[0])
+	}
+
+	cached := filepath.Join(cacheDir, layerHex+".tar.gz")
+	ok, err = cachedDigestMatches(cached, layerHex)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return cached, nil
+	}
+	resp, err := p.get("/blobs/"+layers[0], nil)
+	if err != nil {
+		return "", fmt.Errorf("pull %s layer: %w", ref, err)
+	}
+	got, err := cacheTarball(resp.Body, cacheDir, layerHex)
+	if closeErr := resp.Body.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("pull %s layer: %w", ref, err)
+	}
+	if got != layerHex {
+		return "", fmt.Errorf("OCI reference %s: layer digest mismatch: manifest %s, downloaded sha256:%s", ref, layers[0], got)
+	}
+	return cached, nil
+}
+
+// getBytes is get for a small response, read whole up to limit bytes.
+func (p *ociPuller) getBytes(path string, accept []string, limit int64) ([]byte, error) {
+	resp, err := p.get(path, accept)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if closeErr := resp.Body.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+	return body, nil
+}
+
+// get requests path under /v2/<repository>, authorizing and retrying once
+// when the registry answers 401. It returns only 2xx responses.
+func (p *ociPuller) get(path string, accept []string) (*http.Response, error) {
+	target := "https://" + p.ref.apiHost() + "/v2/" + p.ref.Repository + path
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if p.authorization != "" {
+			req.Header.Set("Authorization", p.authorization)
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if err := resp.Body.Close(); err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			err := fmt.Errorf("GET %s: HTTP %s", target, resp.Status)
+			if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+				err = errors.Join(err, p.credsErr)
+			}
+			return nil, err
+		}
+		if err := p.authorize(challenge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authorize sets p.authorization to answer the WWW-Authenticate challenge.
+func (p *ociPuller) authorize(challenge string) error {
+	user, secret, ok, err := p.creds()
+	if err != nil {
+		p.credsErr = err
+		ok = false
+	}
+	scheme, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !ok {
+			return errors.Join(fmt.Errorf("registry %s requires credentials and the docker config has none", p.ref.Registry), p.credsErr)
+		}
+		p.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+secret))
+		return nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || realm.Scheme == "" {
+			return fmt.Errorf("registry %s sent an invalid token realm %q", p.ref.Registry, params["realm"])
+		}
+		query := realm.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		scope := params["scope"]
+		if scope == "" {
+			scope = "repository:" + p.ref.Repository + ":pull"
+		}
+		query.Set("scope", scope)
+		realm.RawQuery = query.Encode()
+		req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			req.SetBasicAuth(user, secret)
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("registry token %s: %w", realm.Redacted(), err)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		decodeErr := json.NewDecoder(io.LimitReader(resp.Body, ociManifestMaxBytes)).Decode(&token)
+		if closeErr := resp.Body.Close(); decodeErr == nil {
+			decodeErr = closeErr
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return errors.Join(fmt.Errorf("registry token %s: HTTP %s", realm.Redacted(), resp.Status), p.credsErr)
+		}
+		if decodeErr != nil {
+			return fmt.Errorf("registry token %s: %w", realm.Redacted(), decodeErr)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		p.authorization = "Bearer " + token.Token
+		return nil
+	default:
+		return fmt.Errorf("registry %s requires unsupported auth scheme %q", p.ref.Registry, scheme)
+	}
+}
+
+// parseAuthChallenge splits a WWW-Authenticate value such as
+// `Bearer realm="https://r/token",service="r"` into its scheme and
+// parameters.
+func parseAuthChallenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+// dockerCredentials returns the credentials the docker CLI would use for
+// registry, from $DOCKER_CONFIG/config.json (default ~/.docker/config.json):
+// a credential helper (credHelpers, then credsStore) when one is configured,
+// otherwise a base64 user:password `auth` entry. ok is false when there are
+// none.
+func dockerCredentials(registry string) (user, secret string, ok bool, err error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false, err
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return "", "", false, fmt.Errorf("parse docker config %s: %w", filepath.Join(dir, "config.json"), err)
+	}
+	keys := []string{registry, "https://" + registry, "http://" + registry}
+	if registry == "docker.io" {
+		keys = []string{dockerHubAuthKey, "index.docker.io", "docker.io"}
+	}
+
+	helper := config.CredHelpers[registry]
+	if helper == "" {
+		helper = config.CredsStore
+	}
+	if helper != "" {
+		return dockerCredentialHelper(helper, keys[0])
+	}
+	for _, key := range keys {
+		entry, found := config.Auths[key]
+		if !found || entry.Auth == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", "", false, fmt.Errorf("docker config auth for %s: %w", key, err)
+		}
+		user, secret, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return "", "", false, fmt.Errorf("docker config auth for %s is not user:password", key)
+		}
+		return user, secret, true, nil
+	}
+	return "", "", false, nil
+}
+
+// dockerCredentialHelper asks docker-credential-<helper> for the credentials
+// of server.
+func dockerCredentialHelper(helper, server string) (user, secret string, ok bool, err error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", false, fmt.Errorf("docker-credential-%s get %s: %w: %s", helper, server, err, strings.TrimSpace(stderr.String()+string(out)))
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", "", false, fmt.Errorf("docker-credential-%s get %s: %w", helper, server, err)
+	}
+	return creds.Username, creds.Secret, creds.Secret != "", nil
+}
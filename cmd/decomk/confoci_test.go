@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestParseOCIRef(t *testing.T) {
+	t.Parallel()
+
+	pin := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		ref  string
+		want ociRef
+	}{
+		{ref: "ghcr.io/org/decomk-config:stable@" + pin, want: ociRef{Registry: "ghcr.io", Repository: "org/decomk-config", Tag: "stable", Digest: pin}},
+		{ref: "ghcr.io/org/decomk-config@" + pin, want: ociRef{Registry: "ghcr.io", Repository: "org/decomk-config", Digest: pin}},
+		{ref: "localhost:5000/cfg:v1", want: ociRef{Registry: "localhost:5000", Repository: "cfg", Tag: "v1"}},
+		{ref: "org/cfg", want: ociRef{Registry: "docker.io", Repository: "org/cfg", Tag: "latest"}},
+		{ref: "cfg", want: ociRef{Registry: "docker.io", Repository: "library/cfg", Tag: "latest"}},
+	}
+	for _, tc := range tests {
+		got, err := parseOCIRef(tc.ref)
+		if err != nil || got != tc.want {
+			t.Fatalf("parseOCIRef(%q): got %+v, %v want %+v", tc.ref, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"ghcr.io/org/cfg@sha256:abc", "ghcr.io/org/cfg@md5:" + strings.Repeat("ab", 32), "ghcr.io/org/cfg:", "ghcr.io/"} {
+		if _, err := parseOCIRef(bad); err == nil {
+			t.Fatalf("parseOCIRef(%q): got nil want error", bad)
+		}
+	}
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	t.Parallel()
+
+	scheme, params := parseAuthChallenge(`Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:org/cfg:pull"`)
+	if scheme != "Bearer" || params["realm"] != "https://ghcr.io/token" || params["service"] != "ghcr.io" || params["scope"] != "repository:org/cfg:pull" {
+		t.Fatalf("parseAuthChallenge(): got %q %v", scheme, params)
+	}
+	scheme, params = parseAuthChallenge(`Basic realm=registry`)
+	if scheme != "Basic" || params["realm"] != "registry" {
+		t.Fatalf("parseAuthChallenge(basic): got %q %v", scheme, params)
+	}
+}
+
+func TestInstallConfOCI(t *testing.T) {
+	layer, layerHex := makeTarball(t, []tarEntry{
+		{Name: "conf/decomk.conf", Body: "DEFAULT: A='oci'\n"},
+		{Name: "conf/Makefile", Body: "a:\n"},
+	})
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"sha256:%s","size":%d}]}`, layerHex, len(layer)))
+	sum := sha256.Sum256(manifest)
+	manifestDigest := "sha256:" + hex.EncodeToString(sum[:])
+
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "robot" || pass != "s3cret" || r.URL.Query().Get("scope") != "repository:org/cfg:pull" {
+				http.Error(w, "denied", http.StatusUnauthorized)
+				return
+			}
+			if _, err := w.Write([]byte(`{"token":"tok"}`)); err != nil {
+				t.Errorf("Write: %v", err)
+			}
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:org/cfg:pull"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// Every manifest path serves the same manifest, so a wrong pin
+		// exercises the digest check.
+		var body []byte
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v2/org/cfg/manifests/"):
+			body = manifest
+		case r.URL.Path == "/v2/org/cfg/blobs/sha256:"+layerHex:
+			body = layer
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if _, err := w.Write(body); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	dockerConfig := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:s3cret"))
+	if err := os.WriteFile(filepath.Join(dockerConfig, "config.json"), []byte(`{"auths":{"`+host+`":{"auth":"`+auth+`"}}}`), 0o600); err != nil {
+		t.Fatalf("WriteFile(config.json): %v", err)
+	}
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+	home := t.TempDir()
+
+	err := installConfOCI(server.Client(), home, host+"/org/cfg:stable")
+	if err == nil || !strings.Contains(err.Error(), "append @"+manifestDigest) {
+		t.Fatalf("installConfOCI(unpinned): got %v want the digest to pin", err)
+	}
+	err = installConfOCI(server.Client(), home, host+"/org/cfg:stable@sha256:"+strings.Repeat("0", 64))
+	if err == nil || !strings.Contains(err.Error(), "manifest digest mismatch") {
+		t.Fatalf("installConfOCI(wrong pin): got %v want digest mismatch", err)
+	}
+	if err := installConfOCI(server.Client(), home, host+"/org/cfg:stable@"+manifestDigest); err != nil {
+		t.Fatalf("installConfOCI(): %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(state.ConfDir(home), "decomk.conf")); err != nil || string(got) != "DEFAULT: A='oci'\n" {
+		t.Fatalf("decomk.conf: got %q, %v", got, err)
+	}
+	if got, err := os.ReadFile(state.ConfSrcPath(home)); err != nil || strings.TrimSpace(string(got)) != manifestDigest {
+		t.Fatalf("ConfSrcPath: got %q, %v want %s", got, err, manifestDigest)
+	}
+
+	// Without credentials the token request is refused.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	err = installConfOCI(server.Client(), t.TempDir(), host+"/org/cfg@"+manifestDigest)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("installConfOCI(no credentials): got %v want HTTP 401", err)
+	}
+}
+
+func TestInstallConfOCI_RejectsSymlinkEscapes(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	for name, entries := range map[string][]tarEntry{
+		"link chain": {
+			{Name: "conf/decomk.conf", Body: "DEFAULT: A=1\n"},
+			{Name: "conf/a/b", Link: ".."},
+			{Name: "conf/q", Link: "a/b/../../../x"},
+		},
+		"write through link": {
+			{Name: "decomk.conf", Body: "DEFAULT: A=1\n"},
+			{Name: "q", Link: "a/b/../.."},
+			{Name: "a/keep", Body: "x"},
+			{Name: "a/b", Link: "."},
+			{Name: "q/evil", Body: "x"},
+		},
+		// Inside the archive, but outside conf/ once it is stripped.
+		"stripped root": {
+			{Name: "conf/decomk.conf", Body: "DEFAULT: A=1\n"},
+			{Name: "conf/q", Link: "../x"},
+		},
+	} {
+		layer, layerHex := makeTarball(t, entries)
+		manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"sha256:%s","size":%d}]}`, layerHex, len(layer)))
+		sum := sha256.Sum256(manifest)
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := layer
+			if strings.HasPrefix(r.URL.Path, "/v2/org/cfg/manifests/") {
+				body = manifest
+			}
+			if _, err := w.Write(body); err != nil {
+				t.Errorf("Write: %v", err)
+			}
+		}))
+		host := strings.TrimPrefix(server.URL, "https://")
+		home := t.TempDir()
+		err := installConfOCI(server.Client(), home, host+"/org/cfg@sha256:"+hex.EncodeToString(sum[:]))
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), "symlink") {
+			t.Fatalf("installConfOCI(%s): got %v want a symlink error", name, err)
+		}
+		if dirExists(state.ConfDir(home)) {
+			t.Fatalf("installConfOCI(%s): ConfDir was installed", name)
+		}
+	}
+}
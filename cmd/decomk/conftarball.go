@@ -0,0 +1,379 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	// confTarballMaxBytes caps both a config tarball download and the total
+	// size of the files it unpacks.
+	confTarballMaxBytes = 256 << 20
+
+	// confTarballTimeout bounds one config tarball download.
+	confTarballTimeout = 5 * time.Minute
+)
+
+// installConfTarball makes state.ConfDir(home) hold the contents of the
+// config tarball ref (`https://...tar.gz#sha256=<hex>`). It downloads the
+// tarball only when no verified cached copy exists, and unpacks it only when
+// ConfDir does not already hold it (state.ConfSrcPath records the pin). A
+// single top-level directory in the archive is stripped.
+//
+// Intent: Let consumers that publish config as release artifacts skip the git
+// clone flow, while the mandatory pin keeps runs reproducible.
+// Source: DI-kijof (TODO-jirin)
+func installConfTarball(client *http.Client, home, ref string) error {
+	downloadURL, pin, err := parsePinnedURL("config tarball", ref)
+	if err != nil {
+		return err
+	}
+	return installConfArchive(home, pin, func() (string, error) {
+		return fetchConfTarball(client, state.ConfTarballCacheDir(home), downloadURL, pin)
+	})
+}
+
+// installConfArchive makes state.ConfDir(home) hold the contents of the
+// gzip-compressed tar archive that fetch returns the path of. id names the
+// archive's content (a sha256 pin); when ConfDir already holds id, neither
+// fetch nor unpacking runs.
+func installConfArchive(home, id string, fetch func() (string, error)) (err error) {
+	if err := state.EnsureDir(home); err != nil {
+		return err
+	}
+	lock, err := state.LockFile(state.ConfLockPath(home))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, lock.Close())
+	}()
+
+	confDir := state.ConfDir(home)
+	if id != "" {
+		current, err := os.ReadFile(state.ConfSrcPath(home))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if strings.TrimSpace(string(current)) == id && dirExists(confDir) {
+			return nil
+		}
+	}
+	if dirExists(filepath.Join(confDir, ".git")) {
+		return fmt.Errorf("config tarball: %s is a git clone; remove it to switch to -conf-src", confDir)
+	}
+
+	archive, err := fetch()
+	if err != nil {
+		return err
+	}
+	unpackDir, err := os.MkdirTemp(home, ".conf-unpack-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, os.RemoveAll(unpackDir))
+	}()
+	root := filepath.Join(unpackDir, "conf")
+	if err := unpackTarball(archive, root); err != nil {
+		return fmt.Errorf("unpack config archive %s: %w", archive, err)
+	}
+	if entries, err := os.ReadDir(root); err == nil && len(entries) == 1 && entries[0].IsDir() && !fileExists(filepath.Join(root, "decomk.conf")) {
+		root = filepath.Join(root, entries[0].Name())
+		// A link that stayed inside the archive may leave the stripped
+		// directory.
+		if err := checkSymlinksInRoot(root); err != nil {
+			return fmt.Errorf("unpack config archive %s: %w", archive, err)
+		}
+	}
+
+	// Move the old ConfDir aside first so a failed swap can be undone.
+	previous := filepath.Join(unpackDir, "previous")
+	if err := os.Rename(confDir, previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("replace %s: %w", confDir, err)
+	}
+	if err := os.Rename(root, confDir); err != nil {
+		if restoreErr := os.Rename(previous, confDir); restoreErr != nil && !errors.Is(restoreErr, os.ErrNotExist) {
+			err = errors.Join(err, restoreErr)
+		}
+		return fmt.Errorf("replace %s: %w", confDir, err)
+	}
+	return state.AtomicWrite(state.ConfSrcPath(home), []byte(id+"\n"), 0o644)
+}
+
+// fetchConfTarball returns the cached copy of the tarball pinned to pin,
+// downloading and verifying it first when needed. An unpinned URL is
+// downloaded only to report its digest.
+func fetchConfTarball(client *http.Client, cacheDir, downloadURL, pin string) (path string, err error) {
+	cached := filepath.Join(cacheDir, pin+".tar.gz")
+	if pin != "" {
+		ok, err := cachedDigestMatches(cached, pin)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return cached, nil
+		}
+	}
+
+	resp, err := client.Get(downloadURL)
+	if err != nil {
+		return "", fmt.Errorf("fetch config tarball %s: %w", downloadURL, err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close config tarball response %s: %w", downloadURL, closeErr))
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("fetch config tarball %s: HTTP %s", downloadURL, resp.Status)
+	}
+	digest, err := cacheTarball(resp.Body, cacheDir, pin)
+	if err != nil {
+		return "", fmt.Errorf("fetch config tarball %s: %w", downloadURL, err)
+	}
+	if pin == "" {
+		return "", fmt.Errorf("config tarball URL %s is not pinned; append #sha256=%s after verifying its contents", downloadURL, digest)
+	}
+	if digest != pin {
+		return "", fmt.Errorf("config tarball URL %s: sha256 mismatch: pinned %s, downloaded %s", downloadURL, pin, digest)
+	}
+	return cached, nil
+}
+
+// cacheTarball copies r, up to confTarballMaxBytes, to cacheDir/<pin>.tar.gz
+// when its sha256 is pin, and returns the sha256 it read either way.
+func cacheTarball(r io.Reader, cacheDir, pin string) (digest string, err error) {
+	if err := state.EnsureDir(cacheDir); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(cacheDir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if removeErr := os.Remove(tmp.Name()); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			err = errors.Join(err, removeErr)
+		}
+	}()
+	hash := sha256.New()
+	n, copyErr := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, confTarballMaxBytes+1))
+	if closeErr := tmp.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr != nil {
+		return "", copyErr
+	}
+	if n > confTarballMaxBytes {
+		return "", fmt.Errorf("larger than %d bytes", confTarballMaxBytes)
+	}
+	digest = hex.EncodeToString(hash.Sum(nil))
+	if pin == "" || digest != pin {
+		return digest, nil
+	}
+	cached := filepath.Join(cacheDir, pin+".tar.gz")
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return "", fmt.Errorf("cache %s: %w", cached, err)
+	}
+	return digest, nil
+}
+
+// unpackTarball extracts the gzip-compressed tar archive into dir, which must
+// not exist. Entries must stay inside dir: absolute or escaping paths and
+// symlinks are rejected, as are hard links and device files. No entry is
+// written beneath a symlink, and every link is resolved through the links
+// it passes once extraction ends.
+func unpackTarball(archive, dir string) (err error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return err
+	}
+	reader := tar.NewReader(gz)
+	var total int64
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			// Links are checked once all exist: a later entry can change
+			// where an earlier link resolves.
+			return checkSymlinksInRoot(dir)
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if name == "." || header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("entry %q escapes the archive root", header.Name)
+		}
+		path := filepath.Join(dir, name)
+		if err := checkNoSymlinkParents(dir, name); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			total += header.Size
+			if total > confTarballMaxBytes {
+				return fmt.Errorf("unpacks to more than %d bytes", confTarballMaxBytes)
+			}
+			if err := writeTarFile(reader, path, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(header.Linkname) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), header.Linkname)) {
+				return fmt.Errorf("symlink %q points outside the archive root (%q)", header.Name, header.Linkname)
+			}
+			if err := state.EnsureParentDir(path); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("entry %q has unsupported type %q", header.Name, string(header.Typeflag))
+		}
+	}
+}
+
+// checkNoSymlinkParents fails when a directory on the way from root to the
+// entry name is a symlink, so nothing is ever written through a link.
+//
+// Intent: Keep a config archive from writing outside its extraction root by
+// planting a directory symlink and then an entry beneath it.
+// Source: DI-pubod (TODO-jirin)
+func checkNoSymlinkParents(root, name string) error {
+	parent := root
+	parts := strings.Split(name, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("entry %q is beneath symlink %q", name, strings.TrimPrefix(parent, root+string(filepath.Separator)))
+		}
+	}
+	return nil
+}
+
+// checkSymlinksInRoot fails when any symlink under root resolves, through any
+// chain of links, to a path outside root (see resolveInRoot).
+func checkSymlinksInRoot(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if err := resolveInRoot(root, rel); err != nil {
+			target, readErr := os.Readlink(path)
+			if readErr != nil {
+				return errors.Join(fmt.Errorf("symlink %q points outside the archive root: %w", filepath.ToSlash(rel), err), readErr)
+			}
+			return fmt.Errorf("symlink %q points outside the archive root (%q): %w", filepath.ToSlash(rel), target, err)
+		}
+		return nil
+	})
+}
+
+// maxSymlinkHops bounds link chains like the kernel's ELOOP limit.
+const maxSymlinkHops = 40
+
+// resolveInRoot walks rel from root one component at a time, following each
+// symlink it meets as the kernel would, and fails if any step leaves root.
+// Components that do not exist are taken literally.
+func resolveInRoot(root, rel string) error {
+	pending := strings.Split(filepath.ToSlash(rel), "/")
+	var current []string
+	for hops := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(current) == 0 {
+				return errors.New("resolves above the root")
+			}
+			current = current[:len(current)-1]
+			continue
+		}
+		path := filepath.Join(append([]string{root}, append(current, part)...)...)
+		info, err := os.Lstat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = append(current, part)
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return errors.New("too many levels of symbolic links")
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if filepath.IsAbs(target) {
+			return fmt.Errorf("link %q is absolute", target)
+		}
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+	return nil
+}
+
+// writeTarFile writes the current entry of reader to path with mode.
+func writeTarFile(reader io.Reader, path string, mode os.FileMode) (err error) {
+	if err := state.EnsureParentDir(path); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, out.Close())
+	}()
+	_, err = io.Copy(out, reader)
+	return err
+}
+
+// dirExists reports whether path is a directory.
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
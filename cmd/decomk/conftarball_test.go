@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// tarEntry is one file (or, with Link set, symlink) in a test tarball.
+type tarEntry struct {
+	Name, Body, Link string
+}
+
+// makeTarball returns a gzip-compressed tar of entries and its sha256.
+func makeTarball(t *testing.T, entries []tarEntry) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Mode: 0o644, Size: int64(len(entry.Body)), Typeflag: tar.TypeReg}
+		if entry.Link != "" {
+			header = &tar.Header{Name: entry.Name, Linkname: entry.Link, Mode: 0o777, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("WriteHeader(%s): %v", entry.Name, err)
+		}
+		if _, err := tw.Write([]byte(entry.Body)); err != nil {
+			t.Fatalf("Write(%s): %v", entry.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar Close(): %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip Close(): %v", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:])
+}
+
+func TestInstallConfTarball(t *testing.T) {
+	t.Parallel()
+
+	v1, pin1 := makeTarball(t, []tarEntry{
+		{Name: "config-v1/decomk.conf", Body: "DEFAULT: A='1'\n"},
+		{Name: "config-v1/Makefile", Body: "a:\n\ttouch $@\n"},
+	})
+	v2, pin2 := makeTarball(t, []tarEntry{
+		{Name: "decomk.conf", Body: "DEFAULT: A='2'\n"},
+		{Name: "mk/base.mk", Body: "b:\n"},
+		{Name: "Makefile", Link: "mk/base.mk"},
+	})
+	var downloads atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		body := map[string][]byte{"/v1.tar.gz": v1, "/v2.tar.gz": v2}[r.URL.Path]
+		if body == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if _, err := w.Write(body); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}))
+	defer server.Close()
+	client := server.Client()
+	home := t.TempDir()
+	confDir := state.ConfDir(home)
+
+	err := installConfTarball(client, home, server.URL+"/v1.tar.gz")
+	if err == nil || !strings.Contains(err.Error(), "#sha256="+pin1) {
+		t.Fatalf("installConfTarball(unpinned): got %v want the digest to pin", err)
+	}
+	err = installConfTarball(client, home, server.URL+"/v1.tar.gz#sha256="+pin2)
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("installConfTarball(wrong pin): got %v want sha256 mismatch", err)
+	}
+
+	// The single top-level directory is stripped; a repeat install of the
+	// same pin neither downloads nor unpacks again.
+	for range 2 {
+		if err := installConfTarball(client, home, server.URL+"/v1.tar.gz#sha256="+pin1); err != nil {
+			t.Fatalf("installConfTarball(v1): %v", err)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(confDir, "decomk.conf")); err != nil || string(got) != "DEFAULT: A='1'\n" {
+		t.Fatalf("decomk.conf after v1: got %q, %v", got, err)
+	}
+	if got := downloads.Load(); got != 3 {
+		t.Fatalf("downloads: got %d want 3", got)
+	}
+
+	if err := installConfTarball(client, home, server.URL+"/v2.tar.gz#sha256="+pin2); err != nil {
+		t.Fatalf("installConfTarball(v2): %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(confDir, "Makefile")); err != nil || string(got) != "b:\n" {
+		t.Fatalf("Makefile symlink after v2: got %q, %v", got, err)
+	}
+	if got, err := os.ReadFile(state.ConfSrcPath(home)); err != nil || strings.TrimSpace(string(got)) != pin2 {
+		t.Fatalf("ConfSrcPath after v2: got %q, %v want %s", got, err, pin2)
+	}
+
+	// Switching back to v1 reuses the cached download.
+	server.Close()
+	if err := installConfTarball(client, home, server.URL+"/v1.tar.gz#sha256="+pin1); err != nil {
+		t.Fatalf("installConfTarball(cached v1): %v", err)
+	}
+	if fileExists(filepath.Join(confDir, "mk", "base.mk")) {
+		t.Fatalf("v2 files left in %s after switching back to v1", confDir)
+	}
+}
+
+func TestInstallConfTarball_RefusesGitClone(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(state.ConfDir(home), ".git"), 0o755); err != nil {
+		t.Fatalf("MkdirAll(.git): %v", err)
+	}
+	err := installConfTarball(http.DefaultClient, home, "https://example.invalid/c.tar.gz#sha256="+strings.Repeat("0", 64))
+	if err == nil || !strings.Contains(err.Error(), "is a git clone") {
+		t.Fatalf("installConfTarball(): got %v want git clone refusal", err)
+	}
+}
+
+func TestUnpackTarball_RejectsEscapes(t *testing.T) {
+	t.Parallel()
+
+	for _, entries := range [][]tarEntry{
+		{{Name: "../evil", Body: "x"}},
+		{{Name: "/etc/evil", Body: "x"}},
+		{{Name: "link", Link: "../../etc/passwd"}},
+		{{Name: "link", Link: "/etc/passwd"}},
+		// Each link is local on its own; the chain leaves the root.
+		{{Name: "a/keep", Body: "x"}, {Name: "a/b", Link: ".."}, {Name: "q", Link: "a/b/../../x"}},
+		// The link only escapes once a later link changes what it passes.
+		{{Name: "q", Link: "a/b/../../x"}, {Name: "a/keep", Body: "x"}, {Name: "a/b", Link: "."}},
+		// A file written through a link that escapes by then.
+		{{Name: "q", Link: "a/b/../.."}, {Name: "a/keep", Body: "x"}, {Name: "a/b", Link: "."}, {Name: "q/evil", Body: "x"}},
+	} {
+		body, _ := makeTarball(t, entries)
+		root := t.TempDir()
+		archive := filepath.Join(root, "c.tar.gz")
+		if err := os.WriteFile(archive, body, 0o644); err != nil {
+			t.Fatalf("WriteFile(): %v", err)
+		}
+		if err := unpackTarball(archive, filepath.Join(root, "out")); err == nil {
+			t.Fatalf("unpackTarball(%+v): got nil want error", entries)
+		}
+	}
+}
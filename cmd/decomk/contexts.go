@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+)
+
+// cmdContexts lists the keys of the loaded config with their doc comments,
+// marking the keys selected for the current workspaces with '*'.
+//
+// Intent: Make config repos self-documenting so users can learn what a context
+// or macro is for from the CLI.
+// Source: DI-zinug (TODO-jirin)
+func cmdContexts(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk contexts", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+	addCommonFlags(fs, &f)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("contexts does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if f.profile != "" {
+		return 2, fmt.Errorf("contexts cannot use -profile (a profile stores no config keys)")
+	}
+	if err := applyStartDir(f.startDir); err != nil {
+		return 1, err
+	}
+	plan, err := resolvePlanFromFlags(f)
+	if err != nil {
+		return 1, err
+	}
+	return 0, writeContexts(stdout, plan)
+}
+
+// writeContexts prints one line per key, followed by its doc indented.
+func writeContexts(w io.Writer, plan *resolvedPlan) error {
+	selected := make(map[string]bool, len(plan.ContextKeys))
+	for _, key := range plan.ContextKeys {
+		selected[key] = true
+	}
+	for _, key := range sortedDefKeys(plan.Defs) {
+		if contexts.IsDirective(key) {
+			continue
+		}
+		mark := " "
+		if selected[key] {
+			mark = "*"
+		}
+		if err := writeFormat(w, "%s %s\n", mark, key); err != nil {
+			return err
+		}
+		if doc := plan.Docs[key]; doc != "" {
+			for _, line := range strings.Split(doc, "\n") {
+				if err := writeLine(w, strings.TrimRight("    "+line, " ")); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
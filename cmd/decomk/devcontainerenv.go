@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/resolve"
+)
+
+// devcontainerEnvAll selects every containerEnv/remoteEnv variable.
+const devcontainerEnvAll = "*"
+
+// devcontainerConfigCandidates are the devcontainer.json paths checked, in
+// order, relative to a workspace root.
+var devcontainerConfigCandidates = []string{
+	filepath.Join(".devcontainer", "devcontainer.json"),
+	".devcontainer.json",
+}
+
+// devcontainerVarPattern matches one ${...} substitution in a devcontainer.json
+// env value.
+var devcontainerVarPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// resolveDevcontainerEnvNames returns the variable names to merge.
+//
+// Precedence: flagOverride (if non-empty), then DECOMK_DEVCONTAINER_ENV. The
+// value is a comma- or space-separated list of names, or "*" for all. An
+// empty list disables the layer.
+func resolveDevcontainerEnvNames(flagOverride string) []string {
+	raw := flagOverride
+	if raw == "" {
+		raw = os.Getenv("DECOMK_DEVCONTAINER_ENV")
+	}
+	return strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// loadDevcontainerEnvTuples reads containerEnv and remoteEnv from each
+// workspace's devcontainer.json and returns the selected variables as envonly
+// tuple tokens, with the files read and warnings for values it could not
+// resolve.
+//
+// remoteEnv overrides containerEnv, as in the IDE terminal, and later
+// workspaces override earlier ones. env resolves ${containerEnv:NAME}.
+//
+// Intent: Let recipes see the same environment the IDE terminal does, which
+// includes remoteEnv that a lifecycle-launched decomk may not inherit.
+// Source: DI-gimon (TODO-jirin)
+func loadDevcontainerEnvTuples(repos []workspaceRepo, names []string, env map[string]string) (tokens, paths, warnings []string, err error) {
+	if len(names) == 0 {
+		return nil, nil, nil, nil
+	}
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+	for _, repo := range repos {
+		path, ok := findDevcontainerConfig(repo.Root)
+		if !ok {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		stripped, err := stripJSONCLineCommentsForInit(content)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("strip jsonc line comments from %s: %w", path, err)
+		}
+		var parsed struct {
+			ContainerEnv map[string]*string `json:"containerEnv"`
+			RemoteEnv    map[string]*string `json:"remoteEnv"`
+		}
+		if err := json.Unmarshal(stripped, &parsed); err != nil {
+			return nil, nil, nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		paths = append(paths, path)
+
+		merged := make(map[string]*string)
+		for _, layer := range []map[string]*string{parsed.ContainerEnv, parsed.RemoteEnv} {
+			for name, value := range layer {
+				merged[name] = value
+			}
+		}
+		keys := make([]string, 0, len(merged))
+		for name := range merged {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		for _, name := range keys {
+			// A null remoteEnv value unsets the variable; DECOMK_* variables
+			// already reach make through decomk's own passthrough.
+			if merged[name] == nil || strings.HasPrefix(name, autoPassThroughPrefix) || (!selected[devcontainerEnvAll] && !selected[name]) {
+				continue
+			}
+			if _, _, ok := resolve.SplitTuple(name + "="); !ok {
+				warnings = append(warnings, fmt.Sprintf("%s: skipping %s: not a valid tuple name", path, name))
+				continue
+			}
+			value, err := devcontainerEnvValue(*merged[name], repo, env)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: skipping %s: %v", path, name, err))
+				continue
+			}
+			tokens = append(tokens, "envonly "+name+"="+value)
+		}
+	}
+	return tokens, paths, warnings, nil
+}
+
+// findDevcontainerConfig returns the first devcontainer.json candidate present
+// in root.
+func findDevcontainerConfig(root string) (string, bool) {
+	for _, candidate := range devcontainerConfigCandidates {
+		path := filepath.Join(root, candidate)
+		if fileExists(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// devcontainerEnvValue resolves the ${...} substitutions that make sense
+// inside the container: ${containerEnv:NAME[:default]},
+// ${containerWorkspaceFolder}, and ${containerWorkspaceFolderBasename}.
+// Anything else (for example ${localEnv:...}, which only the host knows) is
+// an error.
+func devcontainerEnvValue(value string, repo workspaceRepo, env map[string]string) (string, error) {
+	var unresolved string
+	out := devcontainerVarPattern.ReplaceAllStringFunc(value, func(match string) string {
+		expr := match[2 : len(match)-1]
+		switch {
+		case expr == "containerWorkspaceFolder":
+			return repo.Root
+		case expr == "containerWorkspaceFolderBasename":
+			return filepath.Base(repo.Root)
+		case strings.HasPrefix(expr, "containerEnv:"):
+			name, fallback, _ := strings.Cut(strings.TrimPrefix(expr, "containerEnv:"), ":")
+			if v, ok := env[name]; ok {
+				return v
+			}
+			return fallback
+		}
+		if unresolved == "" {
+			unresolved = match
+		}
+		return match
+	})
+	if unresolved != "" {
+		return "", fmt.Errorf("cannot resolve %s inside the container", unresolved)
+	}
+	return out, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDevcontainerEnvValue(t *testing.T) {
+	t.Parallel()
+
+	repo := workspaceRepo{Root: "/workspaces/app"}
+	env := map[string]string{"PATH": "/usr/bin"}
+	cases := map[string]string{
+		"plain":                                   "plain",
+		"${containerEnv:PATH}:/extra":             "/usr/bin:/extra",
+		"${containerEnv:MISSING:fallback}":        "fallback",
+		"${containerEnv:MISSING}":                 "",
+		"${containerWorkspaceFolder}/bin":         "/workspaces/app/bin",
+		"${containerWorkspaceFolderBasename}-dev": "app-dev",
+	}
+	for value, want := range cases {
+		got, err := devcontainerEnvValue(value, repo, env)
+		if err != nil || got != want {
+			t.Fatalf("devcontainerEnvValue(%q): got %q, %v want %q", value, got, err, want)
+		}
+	}
+	if _, err := devcontainerEnvValue("${localEnv:HOME}", repo, env); err == nil || !strings.Contains(err.Error(), "${localEnv:HOME}") {
+		t.Fatalf("devcontainerEnvValue(localEnv) error: got %v", err)
+	}
+}
+
+func TestCmdPlan_DevcontainerEnvBelowDotenvAndConfig(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	workspaces := t.TempDir()
+	app := filepath.Join(workspaces, "app")
+	if err := os.MkdirAll(filepath.Join(app, ".devcontainer"), 0o755); err != nil {
+		t.Fatalf("MkdirAll(.devcontainer): %v", err)
+	}
+	devcontainerPath := filepath.Join(app, ".devcontainer", "devcontainer.json")
+	files := map[string]string{
+		configPath:                         "DEFAULT: FOO=config\n",
+		filepath.Join(confDir, "Makefile"): "all:\n\t@echo all\n",
+		filepath.Join(app, ".env"):         "BAR=dotenv\n",
+		devcontainerPath: `{
+  // comments are allowed
+  "containerEnv": {"FOO": "container", "BAR": "container", "REGION": "eu", "GONE": "x"},
+  "remoteEnv": {"REGION": "${containerWorkspaceFolderBasename}-us", "GONE": null, "HOST_HOME": "${localEnv:HOME}"}
+}
+`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdPlan([]string{
+		"-home", t.TempDir(),
+		"-workspaces", workspaces,
+		"-config", configPath,
+		"-dotenv", "workspaces",
+		"-devcontainer-env", "*",
+		"all",
+	}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	outText := stdout.String()
+	for _, needle := range []string{
+		"devcontainerEnv: " + devcontainerPath + "\n",
+		"  FOO=config\n",
+		"  BAR=dotenv  (envonly)\n",
+		"  REGION=app-us  (envonly)\n",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+	if strings.Contains(outText, "GONE=") || strings.Contains(outText, "HOST_HOME=") {
+		t.Fatalf("null or unresolvable devcontainer variable was merged:\n%s", outText)
+	}
+	if !strings.Contains(stderr.String(), "skipping HOST_HOME") {
+		t.Fatalf("stderr missing HOST_HOME warning: %q", stderr.String())
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+const (
+	// digestLimit is how many matches the failure digest prints.
+	digestLimit = 10
+	// digestLineMax truncates long matched lines in the digest.
+	digestLineMax = 200
+)
+
+// errorSignatures match make output lines worth listing in the failure
+// digest. They are unanchored so they still match lines rendered through
+// -log-format.
+var errorSignatures = []*regexp.Regexp{
+	regexp.MustCompile(`make(\[\d+\])?: \*\*\* `),
+	regexp.MustCompile(`No rule to make target`),
+	regexp.MustCompile(`(?i)\berror\b\s*[:\]]`),
+	regexp.MustCompile(`\bexit status \d+`),
+	regexp.MustCompile(`\bE: `),
+	regexp.MustCompile(`(?i)\bfatal:`),
+	regexp.MustCompile(`command not found`),
+	regexp.MustCompile(`Permission denied`),
+}
+
+// digestEntry is one log line matching an error signature.
+type digestEntry struct {
+	Path string
+	// Line is 1-based; Offset is the byte offset of the line's start.
+	Line   int
+	Offset int64
+	Text   string
+}
+
+// scanErrorLines returns the first limit lines of path matching an error
+// signature, and how many lines match in total.
+func scanErrorLines(path string, limit int) ([]digestEntry, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, total, scanErr := scanErrorReader(path, f, limit)
+	if closeErr := f.Close(); closeErr != nil && scanErr == nil {
+		scanErr = closeErr
+	}
+	return entries, total, scanErr
+}
+
+// scanErrorReader is scanErrorLines on r, reporting matches against path.
+func scanErrorReader(path string, r io.Reader, limit int) ([]digestEntry, int, error) {
+	reader := bufio.NewReader(r)
+	var entries []digestEntry
+	var offset int64
+	total := 0
+	for lineNum := 1; ; lineNum++ {
+		raw, err := reader.ReadString('\n')
+		if len(raw) > 0 {
+			text := trimLine(raw)
+			for _, signature := range errorSignatures {
+				if signature.MatchString(text) {
+					total++
+					if len(entries) < limit {
+						if len(text) > digestLineMax {
+							text = text[:digestLineMax] + "..."
+						}
+						entries = append(entries, digestEntry{Path: path, Line: lineNum, Offset: offset, Text: text})
+					}
+					break
+				}
+			}
+			offset += int64(len(raw))
+		}
+		if err == io.EOF {
+			return entries, total, nil
+		}
+		if err != nil {
+			return entries, total, err
+		}
+	}
+}
+
+// trimLine drops a line's trailing newline and carriage return.
+func trimLine(raw string) string {
+	for len(raw) > 0 && (raw[len(raw)-1] == '\n' || raw[len(raw)-1] == '\r') {
+		raw = raw[:len(raw)-1]
+	}
+	return raw
+}
+
+// writeErrorDigest scans logPaths for error signatures and writes the first
+// digestLimit matches, with their log positions, to w. It writes nothing when
+// no line matches.
+//
+// Intent: Point users at the first failure without scrolling thousands of log
+// lines.
+// Source: DI-golih (TODO-jirin)
+func writeErrorDigest(w io.Writer, logPaths []string) error {
+	var entries []digestEntry
+	total := 0
+	var scanErr error
+	for _, path := range logPaths {
+		found, count, err := scanErrorLines(path, digestLimit-len(entries))
+		if err != nil {
+			scanErr = err
+			continue
+		}
+		entries = append(entries, found...)
+		total += count
+	}
+	if len(entries) > 0 {
+		header := fmt.Sprintf("decomk: error digest (%d matching lines):", total)
+		if total > len(entries) {
+			header = fmt.Sprintf("decomk: error digest (first %d of %d matching lines):", len(entries), total)
+		}
+		if err := writeLine(w, header); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := writeFormat(w, "  %s:%d (byte %d): %s\n", entry.Path, entry.Line, entry.Offset, entry.Text); err != nil {
+				return err
+			}
+		}
+	}
+	if scanErr != nil {
+		return fmt.Errorf("error digest: %w", scanErr)
+	}
+	return nil
+}
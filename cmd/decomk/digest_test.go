@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanErrorReader(t *testing.T) {
+	t.Parallel()
+
+	log := "" +
+		"CC main.o\n" +
+		"main.c:3:1: error: expected ';'\r\n" +
+		"compiled 12 files\n" +
+		"E: Unable to locate package jq\n" +
+		"make: *** [Makefile:4: Block10] Error 1\n"
+	entries, total, err := scanErrorReader("make.log", strings.NewReader(log), 2)
+	if err != nil {
+		t.Fatalf("scanErrorReader(): %v", err)
+	}
+	want := []digestEntry{
+		{Path: "make.log", Line: 2, Offset: 10, Text: "main.c:3:1: error: expected ';'"},
+		{Path: "make.log", Line: 4, Offset: 61, Text: "E: Unable to locate package jq"},
+	}
+	if total != 3 || !reflect.DeepEqual(entries, want) {
+		t.Fatalf("scanErrorReader(): got %+v, %d want %+v, 3", entries, total, want)
+	}
+}
+
+func TestWriteErrorDigest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "clean.log")
+	failed := filepath.Join(dir, "make.log")
+	var content strings.Builder
+	for i := 1; i <= 12; i++ {
+		fmt.Fprintf(&content, "step %d: command not found\n", i)
+	}
+	for path, text := range map[string]string{clean: "all good\n", failed: content.String()} {
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeErrorDigest(&out, []string{clean}); err != nil || out.Len() != 0 {
+		t.Fatalf("writeErrorDigest(clean): got %q, %v want no output", out.String(), err)
+	}
+	if err := writeErrorDigest(&out, []string{clean, failed}); err != nil {
+		t.Fatalf("writeErrorDigest(): %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if got, want := lines[0], "decomk: error digest (first 10 of 12 matching lines):"; got != want {
+		t.Fatalf("digest header: got %q want %q", got, want)
+	}
+	if got, want := lines[1], "  "+failed+":1 (byte 0): step 1: command not found"; got != want || len(lines) != 11 {
+		t.Fatalf("digest first entry: got %q (%d lines) want %q (11 lines)", got, len(lines), want)
+	}
+}
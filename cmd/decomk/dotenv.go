@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/resolve"
+)
+
+// dotenvWorkspaces is the -dotenv entry that loads .env from every
+// discovered workspace root.
+const dotenvWorkspaces = "workspaces"
+
+// resolveDotenvPaths returns the .env files to load, in order.
+//
+// Precedence for the list: flagOverride (if non-empty), then DECOMK_DOTENV. It
+// is comma- or space-separated; the entry "workspaces" expands to
+// <root>/.env for each workspace that has one, and other entries are paths
+// that must exist.
+func resolveDotenvPaths(flagOverride string, repos []workspaceRepo) ([]string, error) {
+	raw := flagOverride
+	if raw == "" {
+		raw = os.Getenv("DECOMK_DOTENV")
+	}
+	var paths []string
+	for _, entry := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if entry == dotenvWorkspaces {
+			for _, repo := range repos {
+				if path := filepath.Join(repo.Root, ".env"); fileExists(path) {
+					paths = append(paths, path)
+				}
+			}
+			continue
+		}
+		abs, err := filepath.Abs(entry)
+		if err != nil {
+			return nil, fmt.Errorf("abs dotenv path %q: %w", entry, err)
+		}
+		if !fileExists(abs) {
+			return nil, fmt.Errorf("dotenv file not found: %s", abs)
+		}
+		paths = append(paths, abs)
+	}
+	return paths, nil
+}
+
+// loadDotenvTuples reads paths and returns their assignments as envonly tuple
+// tokens, in file order.
+//
+// Intent: Let per-developer values (tokens, feature flags) flow into
+// resolution without editing shared config; callers place these tokens before
+// every config tuple so any decomk.conf assignment wins, and envonly keeps
+// them off make's argv and the logged make command.
+// Source: DI-rifad (TODO-jirin)
+func loadDotenvTuples(paths []string) ([]string, error) {
+	var tokens []string
+	for _, path := range paths {
+		tuples, err := parseDotenvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, tuple := range tuples {
+			tokens = append(tokens, "envonly "+tuple)
+		}
+	}
+	return tokens, nil
+}
+
+// parseDotenvFile parses one .env file into NAME=value tuples.
+//
+// Supported syntax: blank lines, whole-line '#' comments, an optional
+// "export " prefix, and NAME=value where value is bare (trailing " #..."
+// comments and surrounding space are dropped), single-quoted (literal), or
+// double-quoted (\n, \", \\ and \$ escapes). Variables are not interpolated.
+func parseDotenvFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read dotenv file: %w", err)
+	}
+	var tuples []string
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		name, raw, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if _, _, valid := resolve.SplitTuple(name + "="); !ok || !valid {
+			return nil, fmt.Errorf("%s:%d: expected NAME=value", path, lineNum)
+		}
+		for _, computed := range contexts.ComputedTupleNames {
+			if name == computed {
+				return nil, fmt.Errorf("%s:%d: %s is computed by decomk and cannot be set", path, lineNum, name)
+			}
+		}
+		value, err := dotenvValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, lineNum, name, err)
+		}
+		tuples = append(tuples, name+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read dotenv file %s: %w", path, err)
+	}
+	return tuples, nil
+}
+
+// dotenvValue decodes the value part of one .env assignment.
+func dotenvValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, "'"):
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single-quoted value")
+		}
+		return raw[1 : end+1], nil
+	case strings.HasPrefix(raw, `"`):
+		var b strings.Builder
+		for i := 1; i < len(raw); i++ {
+			switch c := raw[i]; {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case '"', '\\', '$':
+					b.WriteByte(raw[i])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(raw[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated double-quoted value")
+	}
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
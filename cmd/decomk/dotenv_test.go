@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenvFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+		return path
+	}
+
+	path := write("ok.env", "# comment\n\nTOKEN=abc123\nexport FLAG = on # trailing\nSINGLE='a $b # c'\nDOUBLE=\"x\\ny \\\"q\\\" \\$HOME\"\nEMPTY=\n")
+	got, err := parseDotenvFile(path)
+	if err != nil {
+		t.Fatalf("parseDotenvFile() error: %v", err)
+	}
+	want := []string{"TOKEN=abc123", "FLAG=on", "SINGLE=a $b # c", "DOUBLE=x\ny \"q\" $HOME", "EMPTY="}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseDotenvFile(): got %q want %q", got, want)
+	}
+
+	for content, wantErr := range map[string]string{
+		"ok=1\nnot an assignment\n": "bad.env:2: expected NAME=value",
+		"1BAD=x\n":                  "bad.env:1: expected NAME=value",
+		"DECOMK_HOME=/tmp\n":        "bad.env:1: DECOMK_HOME is computed by decomk",
+		"X='open\n":                 "bad.env:1: X: unterminated single-quoted value",
+	} {
+		path := write("bad.env", content)
+		if _, err := parseDotenvFile(path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("parseDotenvFile(%q) error: got %v want substring %q", content, err, wantErr)
+		}
+	}
+}
+
+func TestCmdPlan_DotenvTuplesHaveLowestPrecedence(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	makefilePath := filepath.Join(confDir, "Makefile")
+	workspaces := t.TempDir()
+	app := filepath.Join(workspaces, "app")
+	if err := os.Mkdir(app, 0o755); err != nil {
+		t.Fatalf("Mkdir(app): %v", err)
+	}
+	files := map[string]string{
+		configPath:                      "DEFAULT: FOO=config\n",
+		makefilePath:                    "all:\n\t@echo all\n",
+		filepath.Join(app, ".env"):      "FOO=dotenv\nAPI_TOKEN=secret\n",
+		filepath.Join(confDir, "x.env"): "EXTRA=1\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdPlan([]string{
+		"-home", t.TempDir(),
+		"-workspaces", workspaces,
+		"-config", configPath,
+		"-dotenv", "workspaces," + filepath.Join(confDir, "x.env"),
+		"all",
+	}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	outText := stdout.String()
+	for _, needle := range []string{
+		"dotenv: " + filepath.Join(app, ".env") + "\n",
+		"dotenv: " + filepath.Join(confDir, "x.env") + "\n",
+		"  API_TOKEN=secret  (envonly)\n",
+		"  FOO=config\n",
+		"export API_TOKEN='secret'\n",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+	_, makeCommand, _ := strings.Cut(outText, "make command:")
+	makeCommand, _, _ = strings.Cut(makeCommand, "\n")
+	if strings.Contains(outText, "FOO=dotenv") || strings.Contains(makeCommand, "API_TOKEN") {
+		t.Fatalf("dotenv value overrode config or reached make argv:\n%s", outText)
+	}
+
+	_, err = cmdPlan([]string{"-home", t.TempDir(), "-workspaces", workspaces, "-config", configPath, "-dotenv", filepath.Join(confDir, "missing.env"), "all"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "dotenv file not found") {
+		t.Fatalf("cmdPlan(missing dotenv) error: got %v", err)
+	}
+}
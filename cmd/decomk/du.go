@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// defaultDUTop is how many of the largest entries `decomk du` lists.
+const defaultDUTop = 10
+
+// duArea is one decomk state area measured by `decomk du`.
+type duArea struct {
+	Label string
+	Path  string
+	Bytes int64
+}
+
+// duEntry is one immediate child of an area, ranked for the top offenders.
+type duEntry struct {
+	Area  string
+	Path  string
+	Bytes int64
+}
+
+// cmdDU reports disk usage per decomk state area, with totals and the largest
+// entries across areas.
+//
+// Intent: Let users see why a container image or volume is ballooning without
+// knowing decomk's directory layout.
+// Source: DI-ludat (TODO-jirin)
+func cmdDU(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk du", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag, logDirFlag string
+	var top int
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	fs.StringVar(&logDirFlag, "log-dir", "", "per-run log root directory (absolute path; overrides DECOMK_LOG_DIR; default /var/log/decomk)")
+	fs.IntVar(&top, "top", defaultDUTop, "number of largest entries to list (0 disables the list)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("du does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if top < 0 {
+		return 2, fmt.Errorf("invalid -top %d (expected a non-negative integer)", top)
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	logRoot, _, err := resolveLogRoot(logDirFlag)
+	if err != nil {
+		return 1, err
+	}
+
+	areas, entries, total, err := measureDiskUsage(home, logRoot)
+	if err != nil {
+		return 1, err
+	}
+
+	if err := writeFormat(stdout, "decomk disk usage (DECOMK_HOME=%s):\n", home); err != nil {
+		return 1, err
+	}
+	for _, area := range areas {
+		path := area.Path
+		if path == "" {
+			path = "(everything else under DECOMK_HOME)"
+		}
+		if err := writeFormat(stdout, "  %-16s %10s  %s\n", area.Label, humanBytes(area.Bytes), path); err != nil {
+			return 1, err
+		}
+	}
+	if err := writeFormat(stdout, "  %-16s %10s\n", "total", humanBytes(total)); err != nil {
+		return 1, err
+	}
+	if top == 0 || len(entries) == 0 {
+		return 0, nil
+	}
+	if err := writeFormat(stdout, "largest entries:\n"); err != nil {
+		return 1, err
+	}
+	for _, entry := range entries[:min(top, len(entries))] {
+		if err := writeFormat(stdout, "  %10s  %-16s %s\n", humanBytes(entry.Bytes), entry.Area, entry.Path); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// measureDiskUsage sizes each decomk state area and its immediate children.
+//
+// Areas are reported in a fixed order. "other" is whatever else lives under
+// home; the log root counts toward the total even when it is outside home.
+// Entries are returned largest first.
+func measureDiskUsage(home, logRoot string) ([]duArea, []duEntry, int64, error) {
+	areas := []duArea{
+		{Label: "tool clone", Path: state.ToolDir(home)},
+		{Label: "tool source", Path: state.ToolSrcDir(home)},
+		{Label: "config clone", Path: state.ConfDir(home)},
+		{Label: "stamps", Path: state.StampsDir(home)},
+		{Label: "caches", Path: state.CacheDir(home)},
+		{Label: "toolchains", Path: filepath.Join(home, "toolchains")},
+		{Label: "generated", Path: state.GeneratedDir(home)},
+		{Label: "profiles", Path: state.ProfilesDir(home)},
+		{Label: "run tmp", Path: state.RunTmpRoot(home)},
+		{Label: "run logs", Path: logRoot},
+	}
+	if fallback := state.LogDir(home); fallback != logRoot {
+		areas = append(areas, duArea{Label: "run logs", Path: fallback})
+	}
+
+	var entries []duEntry
+	var inHome, outsideHome int64
+	for i := range areas {
+		area := &areas[i]
+		dirEntries, err := os.ReadDir(area.Path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, 0, fmt.Errorf("read %s: %w", area.Path, err)
+		}
+		for _, dirEntry := range dirEntries {
+			path := filepath.Join(area.Path, dirEntry.Name())
+			size, err := dirSize(path)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			area.Bytes += size
+			entries = append(entries, duEntry{Area: area.Label, Path: path, Bytes: size})
+		}
+		if pathWithin(home, area.Path) {
+			inHome += area.Bytes
+		} else {
+			outsideHome += area.Bytes
+		}
+	}
+
+	homeBytes := int64(0)
+	if _, err := os.Stat(home); err == nil {
+		size, err := dirSize(home)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		homeBytes = size
+	} else if !os.IsNotExist(err) {
+		return nil, nil, 0, fmt.Errorf("stat %s: %w", home, err)
+	}
+	areas = append(areas, duArea{Label: "other", Bytes: max(homeBytes-inHome, 0)})
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Bytes > entries[j].Bytes })
+	return areas, entries, homeBytes + outsideHome, nil
+}
+
+// pathWithin reports whether path is root or lies below it.
+func pathWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// humanBytes formats n with a binary unit suffix (B, KiB, MiB, ...).
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
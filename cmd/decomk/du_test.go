@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestHumanBytes(t *testing.T) {
+	t.Parallel()
+
+	for n, want := range map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	} {
+		if got := humanBytes(n); got != want {
+			t.Fatalf("humanBytes(%d): got %q want %q", n, got, want)
+		}
+	}
+}
+
+func TestCmdDU_AreasTotalsAndTop(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	logRoot := t.TempDir()
+	files := map[string]int{
+		filepath.Join(state.StampsDir(home), "Block00"):          10,
+		filepath.Join(state.MakefileCacheDir(home), "abc.mk"):    2000,
+		filepath.Join(state.ConfDir(home), "decomk.conf"):        300,
+		filepath.Join(home, "stray.txt"):                         40,
+		filepath.Join(logRoot, "20260101T000000Z-1", "make.log"): 5000,
+	}
+	for path, size := range files {
+		if err := state.EnsureParentDir(path); err != nil {
+			t.Fatalf("EnsureParentDir(%s): %v", path, err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0o644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdDU([]string{"-home", home, "-log-dir", logRoot, "-top", "2"}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdDU() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdDU() code: got %d want 0", code)
+	}
+	outText := stdout.String()
+	largest, _, _ := strings.Cut(strings.SplitN(outText, "largest entries:\n", 2)[1], "\n")
+	var normalized []string
+	for _, line := range strings.Split(outText, "\n") {
+		normalized = append(normalized, strings.Join(strings.Fields(line), " "))
+	}
+	for _, want := range []string{
+		"stamps 10 B " + state.StampsDir(home),
+		"caches 2.0 KiB " + state.CacheDir(home),
+		"run logs 4.9 KiB " + logRoot,
+		"other 40 B (everything else under DECOMK_HOME)",
+		"total 7.2 KiB",
+	} {
+		if !slices.Contains(normalized, want) {
+			t.Fatalf("cmdDU() stdout missing line %q:\n%s", want, outText)
+		}
+	}
+	if want := filepath.Join(logRoot, "20260101T000000Z-1"); !strings.HasSuffix(largest, want) {
+		t.Fatalf("cmdDU() largest entry: got %q want suffix %q", largest, want)
+	}
+	if got := strings.Count(outText[strings.Index(outText, "largest entries:"):], "\n"); got != 3 {
+		t.Fatalf("cmdDU() -top 2: got %d lines want 3:\n%s", got, outText)
+	}
+}
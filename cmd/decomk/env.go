@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// cmdEnv prints the env export file the last run wrote, for
+// `eval "$(decomk env)"`.
+//
+// Unlike plan, it does not resolve config: it reads the file run replaces
+// atomically and takes no lock, so it never waits for a run in progress
+// (see cmdStatus).
+func cmdEnv(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk env", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("env does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	path := state.EnvFile(home)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 1, fmt.Errorf("no env export file at %s (run decomk run first)", path)
+		}
+		return 1, err
+	}
+	if _, err := stdout.Write(content); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
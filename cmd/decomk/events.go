@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// eventWriteTimeout bounds how long one slow subscriber can delay a run.
+const eventWriteTimeout = time.Second
+
+// Run event names.
+const (
+	eventRunStarted     = "run-started"
+	eventTargetStarted  = "target-started"
+	eventTargetFinished = "target-finished"
+	eventRunFinished    = "run-finished"
+)
+
+// runEvent is one NDJSON line on the events socket.
+type runEvent struct {
+	Time   string `json:"time"`
+	Event  string `json:"event"`
+	RunID  string `json:"runId"`
+	Target string `json:"target,omitempty"`
+	// Status is ok, failed, or skipped for target-finished events.
+	Status         string  `json:"status,omitempty"`
+	ExitCode       *int    `json:"exitCode,omitempty"`
+	ElapsedSeconds float64 `json:"elapsedSeconds,omitempty"`
+	Done           int     `json:"done"`
+	Total          int     `json:"total"`
+	Percent        float64 `json:"percent"`
+}
+
+// eventBroker fans run events out to clients of a unix socket. A nil
+// *eventBroker discards events.
+type eventBroker struct {
+	path     string
+	runID    string
+	listener net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	last  []byte
+	done  int
+	total int
+	// dropErr collects close errors of dropped subscribers for Close.
+	dropErr error
+	closed  bool
+}
+
+// startEventBroker listens on path and accepts subscribers until Close.
+//
+// Intent: Let IDE status bars and other UIs follow a run without tailing
+// logs.
+// Source: DI-basaf (TODO-jirin)
+func startEventBroker(path, runID string, total int) (*eventBroker, error) {
+	if err := state.EnsureParentDir(path); err != nil {
+		return nil, err
+	}
+	// Runs on disjoint targets can overlap; leave a live socket to its run
+	// and only replace a stale one.
+	if conn, err := net.Dial("unix", path); err == nil {
+		if err := conn.Close(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("events socket %s is in use by another run; not streaming this run's events", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("remove stale events socket %s: %w", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on events socket %s: %w", path, err)
+	}
+	// Subscribers usually run as the container user, not root; the socket
+	// only sends.
+	if err := os.Chmod(path, 0o666); err != nil {
+		return nil, errors.Join(fmt.Errorf("chmod events socket %s: %w", path, err), listener.Close())
+	}
+	b := &eventBroker{path: path, runID: runID, listener: listener, conns: map[net.Conn]bool{}, total: total}
+	go b.accept()
+	return b, nil
+}
+
+func (b *eventBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.dropErr = errors.Join(b.dropErr, conn.Close())
+			b.mu.Unlock()
+			return
+		}
+		b.conns[conn] = true
+		if b.last != nil {
+			b.send(conn, b.last)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// send writes line to conn, dropping conn on failure. b.mu must be held.
+func (b *eventBroker) send(conn net.Conn, line []byte) {
+	if err := conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout)); err == nil {
+		if _, err := conn.Write(line); err == nil {
+			return
+		}
+	}
+	delete(b.conns, conn)
+	b.dropErr = errors.Join(b.dropErr, conn.Close())
+}
+
+// publish stamps ev with the run ID, time, and progress, and sends it to
+// every subscriber. target-finished events count toward done.
+func (b *eventBroker) publish(ev runEvent) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ev.Event == eventTargetFinished {
+		b.done++
+	}
+	if ev.Event == eventRunFinished {
+		b.done = b.total
+	}
+	ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	ev.RunID = b.runID
+	ev.Done, ev.Total = b.done, b.total
+	if b.total > 0 {
+		ev.Percent = float64(b.done) * 100 / float64(b.total)
+	} else if ev.Event == eventRunFinished {
+		ev.Percent = 100
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	b.last = append(line, '\n')
+	for conn := range b.conns {
+		b.send(conn, b.last)
+	}
+}
+
+// Close stops accepting subscribers, disconnects the current ones (ending
+// their stream), and removes the socket. Closing twice is a no-op.
+func (b *eventBroker) Close() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	err := errors.Join(b.listener.Close(), b.dropErr)
+	for conn := range b.conns {
+		err = errors.Join(err, conn.Close())
+	}
+	b.conns = map[net.Conn]bool{}
+	b.mu.Unlock()
+	if rmErr := os.Remove(b.path); rmErr != nil && !os.IsNotExist(rmErr) {
+		err = errors.Join(err, rmErr)
+	}
+	if err != nil {
+		return fmt.Errorf("close events socket %s: %w", b.path, err)
+	}
+	return nil
+}
+
+// cmdEvents prints the event stream of the run in progress until it ends.
+func cmdEvents(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk events", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("events does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	path := state.EventsSocketPath(home)
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return 1, fmt.Errorf("no run in progress (%s): %w", path, err)
+	}
+	_, copyErr := io.Copy(stdout, conn)
+	if closeErr := conn.Close(); closeErr != nil && copyErr == nil {
+		return 1, fmt.Errorf("close events socket: %w", closeErr)
+	}
+	if copyErr != nil {
+		return 1, copyErr
+	}
+	return 0, nil
+}
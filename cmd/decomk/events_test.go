@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestEventBroker(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	path := state.EventsSocketPath(home)
+	broker, err := startEventBroker(path, "run-1", 2)
+	if err != nil {
+		t.Fatalf("startEventBroker(): %v", err)
+	}
+	broker.publish(runEvent{Event: eventRunStarted})
+
+	// A late subscriber first receives the latest event.
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("Close(conn): %v", err)
+		}
+	}()
+	reader := bufio.NewReader(conn)
+	next := func() runEvent {
+		t.Helper()
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("ReadBytes(): %v", err)
+		}
+		var ev runEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", line, err)
+		}
+		return ev
+	}
+	if ev := next(); ev.Event != eventRunStarted || ev.RunID != "run-1" || ev.Total != 2 || ev.Percent != 0 {
+		t.Fatalf("first event: got %+v", ev)
+	}
+
+	broker.publish(runEvent{Event: eventTargetStarted, Target: "a"})
+	broker.publish(runEvent{Event: eventTargetFinished, Target: "a", Status: timingStatusOK})
+	exitCode := 0
+	broker.publish(runEvent{Event: eventRunFinished, ExitCode: &exitCode})
+	if ev := next(); ev.Event != eventTargetStarted || ev.Target != "a" || ev.Done != 0 {
+		t.Fatalf("target-started: got %+v", ev)
+	}
+	if ev := next(); ev.Event != eventTargetFinished || ev.Status != timingStatusOK || ev.Done != 1 || ev.Percent != 50 {
+		t.Fatalf("target-finished: got %+v", ev)
+	}
+	if ev := next(); ev.Event != eventRunFinished || ev.ExitCode == nil || *ev.ExitCode != 0 || ev.Percent != 100 {
+		t.Fatalf("run-finished: got %+v", ev)
+	}
+
+	if err := broker.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	if err := broker.Close(); err != nil {
+		t.Fatalf("Close(again): %v", err)
+	}
+	if _, err := reader.ReadBytes('\n'); err == nil {
+		t.Fatalf("ReadBytes() after Close: expected end of stream")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket %s still exists after Close (err=%v)", path, err)
+	}
+}
+
+func TestCmdEvents(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdEvents([]string{"-home", home}, &stdout, &stderr); code != 1 || err == nil {
+		t.Fatalf("cmdEvents(no run): got %d, %v want 1 and an error", code, err)
+	}
+
+	broker, err := startEventBroker(filepath.Join(home, "events.sock"), "run-2", 1)
+	if err != nil {
+		t.Fatalf("startEventBroker(): %v", err)
+	}
+	broker.publish(runEvent{Event: eventRunStarted})
+	done := make(chan error, 1)
+	go func() {
+		_, err := cmdEvents([]string{"-home", home}, &stdout, &stderr)
+		done <- err
+	}()
+	// cmdEvents receives the latest event on connect; wait for it so the
+	// close below ends a stream that has started.
+	for {
+		broker.mu.Lock()
+		subscribed := len(broker.conns) > 0
+		broker.mu.Unlock()
+		if subscribed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := broker.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("cmdEvents(): %v", err)
+	}
+	var ev runEvent
+	if err := json.Unmarshal(stdout.Bytes(), &ev); err != nil || ev.Event != eventRunStarted || ev.RunID != "run-2" {
+		t.Fatalf("cmdEvents() output: got %q (%v)", stdout.String(), err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/stevegt/decomk/resolve"
+)
+
+// execTuple records one $(exec:...) command run while resolving a tuple.
+type execTuple struct {
+	Name    string
+	Command string
+}
+
+// execTuplesDisabled reports whether $(exec:...) tuple values are refused.
+//
+// Precedence: the -no-exec-tuples flag, then a non-empty
+// DECOMK_NO_EXEC_TUPLES.
+func execTuplesDisabled(flagValue bool) bool {
+	return flagValue || os.Getenv("DECOMK_NO_EXEC_TUPLES") != ""
+}
+
+// evalExecTuples replaces every $(exec:CMD) group in the tuples' values with
+// CMD's stdout (trailing newlines trimmed, as in shell command substitution).
+//
+// CMD runs with /bin/sh -c in the current directory and environment. When
+// disabled is true, any group is an error instead.
+//
+// Intent: Compute values that must come from the machine (tool versions,
+// architecture) while resolving, so they can feed other tuples and decomk's
+// own settings, but only where config spells out $(exec:...).
+// Source: DI-nofav (TODO-jirin)
+func evalExecTuples(tuples []string, disabled bool) ([]string, []execTuple, error) {
+	out := make([]string, 0, len(tuples))
+	var ran []execTuple
+	for _, tuple := range tuples {
+		name, value, ok := resolve.SplitTuple(tuple)
+		if !ok || !strings.Contains(value, resolve.ExecPrefix) {
+			out = append(out, tuple)
+			continue
+		}
+		if disabled {
+			return nil, nil, fmt.Errorf("%s: %s...) values are disabled by -no-exec-tuples/DECOMK_NO_EXEC_TUPLES", name, resolve.ExecPrefix)
+		}
+		value, _, err := resolve.SubstituteExec(value, func(command string) (string, error) {
+			ran = append(ran, execTuple{Name: name, Command: command})
+			return runExecTupleCommand(command)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		out = append(out, name+"="+value)
+	}
+	return out, ran, nil
+}
+
+// runExecTupleCommand runs one $(exec:...) command and returns its stdout.
+func runExecTupleCommand(command string) (string, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("exec %q: %w: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("exec %q: %w", command, err)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
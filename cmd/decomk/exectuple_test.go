@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCmdPlan_ExecTuples(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	marker := filepath.Join(t.TempDir(), "overridden-ran")
+	conf := "base: SKIPPED=$(exec:touch " + marker + ")\n" +
+		"DEFAULT: base SKIPPED=kept ARCH=$(exec:printf 'x86_64\\n\\n') TAG=v-$(exec:echo mid)-end\n"
+	files := map[string]string{
+		configPath:                         conf,
+		filepath.Join(confDir, "Makefile"): "all:\n\t@echo $(ARCH)\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath, "all"}
+	var stdout, stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	for _, needle := range []string{
+		"exec (ARCH): printf 'x86_64\\n\\n'\n",
+		"  ARCH=x86_64\n",
+		"  TAG=v-mid-end\n",
+		"  SKIPPED=kept\n",
+	} {
+		if !strings.Contains(stdout.String(), needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, stdout.String())
+		}
+	}
+	if fileExists(marker) {
+		t.Fatalf("overridden $(exec:...) assignment ran")
+	}
+
+	stdout.Reset()
+	_, err = cmdPlan(append([]string{"-no-exec-tuples"}, args...), &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "ARCH: $(exec:...) values are disabled") {
+		t.Fatalf("cmdPlan(-no-exec-tuples) error: got %v", err)
+	}
+
+	if err := os.WriteFile(configPath, []byte("DEFAULT: BAD=$(exec:echo oops >&2; exit 3)\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	_, err = cmdPlan(args, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3: oops") {
+		t.Fatalf("cmdPlan(failing exec) error: got %v", err)
+	}
+}
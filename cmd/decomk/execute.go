@@ -0,0 +1,969 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/stevegt/decomk/makeexec"
+	"github.com/stevegt/decomk/state"
+)
+
+// executeFlags are the parsed plan/run command line.
+type executeFlags struct {
+	common commonFlags
+
+	autoUpdate       bool
+	keepRunTmp       bool
+	traceShell       bool
+	rerunInterrupted bool
+	fullOutput       bool
+	bootstrapOnly    bool
+	convergeOnly     bool
+
+	// autoStamp and nonInteractive include their environment switches.
+	autoStamp      bool
+	nonInteractive bool
+
+	force    string
+	forceAll bool
+	skip     stringsFlag
+	tagExpr  string
+	parallel int
+
+	colors      palette
+	output      string
+	logTemplate *template.Template
+
+	actionArgs []string
+}
+
+// parseExecuteFlags parses and validates the plan/run command line. It
+// returns flag.ErrHelp for -h; every other error is a usage error.
+func parseExecuteFlags(args []string, stdout, stderr io.Writer, mode executionMode) (executeFlags, error) {
+	fs := flag.NewFlagSet("decomk "+mode.Name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f executeFlags
+	var autoStampFlag, nonInteractiveFlag bool
+	var colorMode, logFormat string
+
+	addCommonFlags(fs, &f.common)
+	addColorFlag(fs, &colorMode)
+	if mode.DryRun {
+		addOutputFlag(fs, &f.output)
+	}
+	fs.BoolVar(&f.autoUpdate, "auto-update", false, "update decomk from DECOMK_TOOL_URI first and re-exec if the binary changed (see decomk self-update)")
+	fs.StringVar(&logFormat, "log-format", "", "Go template applied to each run log line, with .Time .RunID .Target .Stream .Line (also DECOMK_LOG_FORMAT; default raw lines; run only)")
+	fs.BoolVar(&f.keepRunTmp, "keep-run-tmp", false, "keep the per-run DECOMK_RUN_TMP directory when make fails (run only)")
+	fs.Var(&f.skip, "skip", "drop targets matching this name or glob from the resolved targets; repeatable")
+	fs.StringVar(&f.tagExpr, "tags", "", "keep only resolved targets with these config tags, comma-separated; !tag drops targets with that tag")
+	fs.StringVar(&f.force, "force", "", "comma-separated targets whose stamps are deleted before make runs, so they rebuild (run only)")
+	fs.BoolVar(&f.forceAll, "force-all", false, "delete the stamps of the resolved targets and everything they depend on before make runs, like make -B (run only)")
+	fs.BoolVar(&f.rerunInterrupted, "rerun-interrupted", false, "delete the stamps of targets an earlier run was interrupted in, so make rebuilds them (run only)")
+	fs.BoolVar(&f.fullOutput, "full-output", false, "show every line of make output on the terminal instead of collapsing runs of similar lines (make.log always has everything; run only)")
+	fs.BoolVar(&f.traceShell, "trace-shell", false, "run recipe shells with -x and write their trace to trace.log in the run log dir instead of stderr (run only)")
+	fs.IntVar(&f.parallel, "parallel", 0, "run top-level targets as separate, timed make invocations, up to N at a time, each with its own log; 0 uses one make invocation (run only)")
+	fs.BoolVar(&autoStampFlag, "auto-stamp", false, "run make once per target and touch each successful target's stamp, so recipes need no trailing touch $@ (also DECOMK_AUTO_STAMP; run only)")
+	fs.BoolVar(&nonInteractiveFlag, "non-interactive", false, "run make with DEBIAN_FRONTEND=noninteractive and no controlling terminal, so recipes that prompt fail fast instead of hanging (also DECOMK_NON_INTERACTIVE)")
+	fs.BoolVar(&f.bootstrapOnly, "bootstrap-only", false, "do nothing if this container already completed a successful run")
+	fs.BoolVar(&f.convergeOnly, "converge-only", false, "do nothing until this container has completed a successful run")
+	if err := fs.Parse(args); err != nil {
+		return f, err
+	}
+	if f.parallel < 0 {
+		return f, fmt.Errorf("invalid -parallel %d (expected a non-negative integer)", f.parallel)
+	}
+	if err := checkSkipPatterns(f.skip); err != nil {
+		return f, err
+	}
+	f.autoStamp = autoStampFlag || os.Getenv("DECOMK_AUTO_STAMP") != ""
+	f.nonInteractive = nonInteractiveFlag || os.Getenv(nonInteractiveEnv) != ""
+	if f.autoStamp && f.parallel == 0 {
+		f.parallel = 1
+	}
+	var err error
+	if f.colors, err = newPalette(colorMode, stdout); err != nil {
+		return f, err
+	}
+	if f.output == "" {
+		f.output = outputText
+	}
+	if err := checkOutputFormat(f.output); err != nil {
+		return f, err
+	}
+	if f.logTemplate, err = parseLogFormat(logFormat); err != nil {
+		return f, err
+	}
+	f.actionArgs = fs.Args()
+	// Intent: Require explicit action selection for both plan and run so decomk
+	// does not silently fall back to config-derived/no-arg target behavior.
+	// Source: DI-gusab (TODO-takoh)
+	if len(f.actionArgs) == 0 && f.common.profile == "" && len(mode.DefaultActionArgs) == 0 {
+		return f, fmt.Errorf("decomk %s requires at least one action arg", mode.Name)
+	}
+	if f.bootstrapOnly && f.convergeOnly {
+		return f, fmt.Errorf("-bootstrap-only and -converge-only are mutually exclusive")
+	}
+	return f, nil
+}
+
+// execution is one plan or run invocation, carried between its phases:
+// resolving the plan and targets, locking, logging, the event stream,
+// running make, resource accounting, and recording the run's summary.
+type execution struct {
+	mode   executionMode
+	flags  executeFlags
+	stdout io.Writer
+	stderr io.Writer
+
+	plan       *resolvedPlan
+	actionArgs []string
+	// targets are the make goals after -skip, -tags, and target guards.
+	targets      []string
+	incomingEnv  []string
+	cookedTuples []string
+	makeTuples   []string
+	makeEnv      []string
+	makefiles    []string
+	extraFiles   []*os.File
+	retry        retryPolicy
+	heartbeat    heartbeatPolicy
+	telemetryURL string
+	command      string
+	runID        string
+	runTmp       string
+	graph        *targetGraph
+	hookEnv      []string
+
+	// lock is the stamps lock while it is held, and held the target locks.
+	lock *state.Lock
+	held *runLocks
+
+	// out and errOut receive make output: the terminal side (termOut,
+	// termErr) and, in run mode, the run log (logOut, logErr).
+	out, errOut      io.Writer
+	termOut, termErr io.Writer
+	logOut, logErr   io.Writer
+	runLogDir        string
+	runLogPath       string
+	logFile          *os.File
+	traceFile        *os.File
+	events           *eventBroker
+	makeExitCode     int
+	makeErr          error
+	targetRuns       []targetRun
+	makeElapsed      time.Duration
+	usage            *resourceUsage
+	usageStart       usageSnapshot
+	usageStartErr    error
+	makeStart        time.Time
+}
+
+// cmdExecute is the shared implementation for plan/run.
+//
+// Both commands:
+//   - parse flags and action args
+//   - apply -C (starting directory) for relative path resolution
+//   - resolve config, expand macros, and partition tokens
+//   - select targets (isconf-style action args)
+//   - invoke make (real or -n)
+//
+// The executionMode controls whether env.sh is written, whether stamp state is
+// locked/touched, and whether output is captured to a per-run log file.
+func cmdExecute(args []string, stdout, stderr io.Writer, mode executionMode) (exitCode int, retErr error) {
+	flags, err := parseExecuteFlags(args, stdout, stderr, mode)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+
+	// Intent: Keep privilege escalation out of decomk core by requiring run mode
+	// to already execute as root (stage-0 performs any needed sudo re-exec).
+	// Source: DI-kataj (TODO-jirin)
+	if !mode.DryRun && os.Geteuid() != 0 {
+		return 1, fmt.Errorf("decomk run must execute as root; rerun via stage-0 bootstrap or root shell")
+	}
+
+	// Root-run behavior is an execution concern (how decomk invokes make), not part
+	// of the resolved config/expansion model. Keep it out of resolvedPlan so plan
+	// resolution stays purely about "which tokens/tuples/targets" rather than
+	// "which user launches make".
+	//
+	// Intent: Keep plan resolution deterministic and reusable (plan/run) while
+	// stage-0 owns root escalation policy.
+	// Source: DI-kataj (TODO-jirin)
+
+	// Intent: Re-exec into an updated binary only on explicit opt-in, so plan
+	// and run never change the installed tool as a side effect. This runs
+	// before -C so the re-exec'd binary resolves relative paths from the same
+	// working directory.
+	// Source: DI-bovit (TODO-jirin)
+	if flags.autoUpdate {
+		home, err := state.Home(flags.common.home)
+		if err != nil {
+			return 1, err
+		}
+		if err := autoUpdateAndReexec(home, stdout, stderr); err != nil {
+			return 1, err
+		}
+	}
+
+	if err := applyStartDir(flags.common.startDir); err != nil {
+		return 1, err
+	}
+	if skip, err := flags.skipForBootstrap(mode, stdout); err != nil || skip {
+		if err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
+	x := &execution{mode: mode, flags: flags, stdout: stdout, stderr: stderr}
+	defer func() {
+		if closeErr := x.close(); closeErr != nil {
+			if retErr == nil {
+				retErr = closeErr
+				if exitCode == 0 {
+					exitCode = 1
+				}
+				return
+			}
+			retErr = errors.Join(retErr, closeErr)
+		}
+	}()
+
+	if exitCode, err := x.resolvePlan(); err != nil {
+		return exitCode, err
+	}
+	if done, exitCode, err := x.resolveTargets(); done || err != nil {
+		return exitCode, err
+	}
+	if err := x.prepareMake(); err != nil {
+		return 1, err
+	}
+	if mode.LockStamps {
+		if done, err := x.lockTargets(); done || err != nil {
+			if err != nil {
+				return 1, err
+			}
+			return 0, nil
+		}
+	}
+	if mode.WriteEnv {
+		if err := writeEnvFile(x.plan.EnvFile, x.plan, x.cookedTuples); err != nil {
+			return 1, err
+		}
+		if err := writeSecretsEnvFile(x.plan, x.cookedTuples); err != nil {
+			return 1, err
+		}
+	}
+	if err := x.openRunLog(); err != nil {
+		return 1, err
+	}
+	if err := x.prepareRun(); err != nil {
+		return 1, err
+	}
+	if !mode.DryRun {
+		if err := x.startEvents(); err != nil {
+			return 1, err
+		}
+		if err := runPluginHooks(x.plan.Plugins, pluginHookPreRun, x.hookEnv, x.out, x.errOut); err != nil {
+			return 1, err
+		}
+	}
+	if err := x.runMake(); err != nil {
+		return 1, err
+	}
+	if err := x.finishMake(); err != nil {
+		return 1, err
+	}
+	if !mode.DryRun {
+		if err := x.recordRun(); err != nil {
+			return 1, err
+		}
+	}
+	return x.result()
+}
+
+// skipForBootstrap applies -bootstrap-only and -converge-only, reporting on
+// stdout when they skip this invocation.
+func (f executeFlags) skipForBootstrap(mode executionMode, stdout io.Writer) (bool, error) {
+	if !f.bootstrapOnly && !f.convergeOnly {
+		return false, nil
+	}
+	home, err := state.Home(f.common.home)
+	if err != nil {
+		return false, err
+	}
+	skip, reason := skipForBootstrapState(state.BootstrapMarkerPath(home), f.bootstrapOnly)
+	if !skip {
+		return false, nil
+	}
+	return true, writeLine(stdout, "decomk: skipping "+mode.Name+": "+reason)
+}
+
+// resolvePlan resolves the plan, reports its warnings, and settles the action
+// args.
+func (x *execution) resolvePlan() (int, error) {
+	plan, err := resolvePlanFromFlags(x.flags.common)
+	if err != nil {
+		return 1, err
+	}
+	if plan == nil {
+		return 1, fmt.Errorf("internal error: resolvePlanFromFlags returned nil plan")
+	}
+	x.plan = plan
+	for _, warning := range plan.Warnings {
+		if err := writeLine(x.stderr, "decomk: warning:", warning); err != nil {
+			return 1, err
+		}
+	}
+	if len(plan.Makefiles) == 0 {
+		return 1, fmt.Errorf("no Makefile found; use -makefile or DECOMK_MAKEFILES to set explicit paths")
+	}
+	if !x.flags.rerunInterrupted || x.mode.DryRun {
+		marked, err := readInProgress(state.InProgressPath(plan.Home))
+		if err != nil {
+			if err := writeLine(x.stderr, "decomk: warning:", err.Error()); err != nil {
+				return 1, err
+			}
+		}
+		for _, warning := range inProgressWarnings(marked) {
+			if err := writeLine(x.stderr, "decomk: warning:", warning); err != nil {
+				return 1, err
+			}
+		}
+	}
+	// Intent: A profile replays its saved action args unless the operator
+	// overrides them, so `decomk run -profile NAME` reproduces the saved run.
+	// Source: DI-gohig (TODO-jirin)
+	x.actionArgs = x.flags.actionArgs
+	if len(x.actionArgs) == 0 {
+		x.actionArgs = plan.ProfileActionArgs
+	}
+	if len(x.actionArgs) == 0 {
+		x.actionArgs = x.mode.DefaultActionArgs
+	}
+	if len(x.actionArgs) == 0 {
+		return 2, fmt.Errorf("decomk %s requires at least one action arg (profile %q stores none)", x.mode.Name, plan.Profile)
+	}
+
+	if x.mode.DryRun {
+		for _, warning := range wellKnownVarWarnings(plan.Tuples, plan.TupleClasses) {
+			if err := writeLine(x.stderr, "decomk: warning:", warning); err != nil {
+				return 1, err
+			}
+		}
+	}
+	return 0, nil
+}
+
+// resolveTargets selects the make goals from the action args, applies -skip,
+// -tags, and target guards, and prints what was dropped along with the dry-run
+// plan. done reports that structured plan output was written and there is
+// nothing more to do.
+func (x *execution) resolveTargets() (done bool, exitCode int, err error) {
+	plan := x.plan
+	// Intent: Resolve passthrough tuples and build one canonical env tuple stream
+	// once per invocation so env.sh and make receive the same effective values.
+	// Source: DI-vojik (TODO-jirin)
+	x.incomingEnv = os.Environ()
+	incomingEnv := envMapFromList(x.incomingEnv)
+	resolvedTuples, err := resolveRuntimeTuples(plan.Tuples, incomingEnv)
+	if err != nil {
+		return false, 1, err
+	}
+	plan.Tuples = resolvedTuples
+
+	targets, skippedTargets, targetSource := selectTargets(plan.Tuples, x.actionArgs, x.flags.skip)
+	if err := checkSelectedTargetsAllowed(plan.Allow, plan.LowerTuples, plan.Tuples, x.actionArgs); err != nil {
+		return false, 1, err
+	}
+	targets, untaggedTargets, err := filterTargetsByTags(targets, plan.Tags, x.flags.tagExpr)
+	if err != nil {
+		return false, 2, err
+	}
+	guards, err := parseTargetGuards(effectiveTupleValues(plan.Tuples)[targetGuardsTuple])
+	if err != nil {
+		return false, 1, err
+	}
+	guardValues := effectiveTupleValues(canonicalEnvTuples(plan, targets, incomingEnv))
+	targets, unguardedTargets, guardResults, err := filterTargetsByGuards(targets, guards, guardValues)
+	if err != nil {
+		return false, 1, err
+	}
+	if len(targets) == 0 && len(skippedTargets)+len(untaggedTargets)+len(unguardedTargets) > 0 {
+		var by []string
+		if len(skippedTargets) > 0 {
+			by = append(by, "-skip")
+		}
+		if len(untaggedTargets) > 0 {
+			by = append(by, "-tags")
+		}
+		if len(unguardedTargets) > 0 {
+			by = append(by, targetGuardsTuple)
+		}
+		removed := append(append(append([]string(nil), skippedTargets...), untaggedTargets...), unguardedTargets...)
+		return false, 2, fmt.Errorf("%s removed every target (%s); make would build its default goal instead", strings.Join(by, " and "), strings.Join(removed, " "))
+	}
+	x.targets = targets
+	x.cookedTuples = canonicalEnvTuples(plan, targets, incomingEnv)
+
+	stdout := x.stdout
+	if x.flags.output != outputText {
+		// Structured plan output is the resolved plan only; make -n output
+		// is not data.
+		if err := writeStructured(stdout, x.flags.output, newResolveResult(plan, x.actionArgs, targets)); err != nil {
+			return false, 1, err
+		}
+		return true, 0, nil
+	}
+	if len(skippedTargets) > 0 {
+		if err := writeLine(stdout, "skipped targets (-skip):", strings.Join(skippedTargets, " ")); err != nil {
+			return false, 1, err
+		}
+	}
+	if len(untaggedTargets) > 0 {
+		if err := writeLine(stdout, "skipped targets (-tags):", strings.Join(untaggedTargets, " ")); err != nil {
+			return false, 1, err
+		}
+	}
+	for _, result := range guardResults {
+		if err := writeFormat(stdout, "target guard (%s): %s => %t\n", result.Target, result.Expr, result.Passed); err != nil {
+			return false, 1, err
+		}
+	}
+	if len(unguardedTargets) > 0 {
+		if err := writeLine(stdout, "skipped targets (guards):", strings.Join(unguardedTargets, " ")); err != nil {
+			return false, 1, err
+		}
+	}
+	if x.mode.DryRun {
+		if err := printPlan(stdout, plan, x.actionArgs, targets, targetSource); err != nil {
+			return false, 1, err
+		}
+		if err := writeLine(stdout); err != nil {
+			return false, 1, err
+		}
+		if err := writeLine(stdout, "env exports (dry-run; not written):"); err != nil {
+			return false, 1, err
+		}
+		if err := writePlanEnvExport(stdout, plan, x.cookedTuples, plan.EnvFile, x.flags.colors); err != nil {
+			return false, 1, err
+		}
+	}
+	return false, 0, nil
+}
+
+// prepareMake writes what make reads (the stamp dir and generated
+// makefiles) and settles make's tuples, environment, and run policies.
+func (x *execution) prepareMake() error {
+	plan := x.plan
+	// Ensure the stamp dir exists so make can run. This does not touch any stamp
+	// files; it only ensures the directory exists.
+	if err := state.EnsureDir(plan.StampDir); err != nil {
+		return err
+	}
+	if err := writeGeneratedMakefiles(plan); err != nil {
+		return err
+	}
+
+	x.makeTuples, x.makeEnv = makeInvocation(x.incomingEnv, x.cookedTuples, plan.TupleClasses)
+	if x.flags.nonInteractive {
+		x.makeEnv = withNonInteractiveEnv(x.makeEnv)
+	}
+	var err error
+	if x.retry, err = parseRetryPolicy(x.cookedTuples); err != nil {
+		return err
+	}
+	if x.heartbeat, err = parseHeartbeatPolicy(x.cookedTuples); err != nil {
+		return err
+	}
+	if x.telemetryURL, err = telemetryEndpoint(effectiveTupleValues(plan.Tuples), os.Getenv); err != nil {
+		return err
+	}
+	x.command = strings.Join(append([]string{"decomk", x.mode.Name}, x.actionArgs...), " ")
+	// Include sub-second resolution and pid to avoid collisions when two runs start
+	// close together (otherwise one run can clobber the other's log output).
+	x.runID = time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + strconv.Itoa(os.Getpid())
+	return nil
+}
+
+// lockTargets takes the locks this run needs, prepares its stamps (touching,
+// -rerun-interrupted, -force), and then releases the stamps lock, keeping the
+// target locks until close. done reports that runs waited for already built
+// every target.
+func (x *execution) lockTargets() (done bool, err error) {
+	plan, stdout := x.plan, x.stdout
+	// Lock the targets this run may build (its make closure, plus the
+	// stamps it deletes), so runs on disjoint targets proceed concurrently.
+	extra := parseForceTargets(x.flags.force)
+	if x.flags.rerunInterrupted {
+		marked, err := readInProgress(state.InProgressPath(plan.Home))
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range marked {
+			extra = append(extra, entry.Target)
+		}
+	}
+	lockedTargets, lockErr := runLockTargets(plan, x.targets, extra, x.makeTuples, x.makeEnv)
+	if lockErr != nil {
+		if err := writeLine(x.stderr, "decomk: warning: locking the whole container:", lockErr.Error()); err != nil {
+			return false, err
+		}
+	}
+	self := state.NewLockHolder(x.command)
+	self.RunID = x.runID
+	x.lock, x.held, err = lockRun(plan.Home, self, lockedTargets, lockErr != nil, x.stderr)
+	if err != nil {
+		return false, fmt.Errorf("lock stamps: %w", err)
+	}
+
+	if x.flags.rerunInterrupted && !x.mode.DryRun {
+		if err := rerunInterruptedTargets(plan, stdout); err != nil {
+			return false, err
+		}
+	}
+
+	// Normalize mtime semantics once per invocation. Only this run's
+	// stamps are touched, since other runs may be building theirs.
+	now := time.Now()
+	if lockErr == nil {
+		if err := touchTargetStamps(plan.StampDir, lockedTargets, now); err != nil {
+			return false, fmt.Errorf("touch stamps: %w", err)
+		}
+	} else {
+		if err := state.TouchExistingStamps(plan.StampDir, now); err != nil {
+			return false, fmt.Errorf("touch stamps: %w", err)
+		}
+		for _, ns := range plan.Namespaces {
+			if err := state.TouchExistingStamps(filepath.Join(plan.StampDir, ns.Name), now); err != nil {
+				return false, fmt.Errorf("touch %s stamps: %w", ns.Name, err)
+			}
+		}
+	}
+
+	if (x.flags.force != "" || x.flags.forceAll) && !x.mode.DryRun {
+		if err := forceStamps(plan, parseForceTargets(x.flags.force), x.flags.forceAll, x.targets, x.makeTuples, x.makeEnv, stdout); err != nil {
+			return false, err
+		}
+	}
+
+	// From here on the target locks keep conflicting runs out; release
+	// the stamps lock so runs on other targets can start.
+	if err := x.releaseStampsLock(); err != nil {
+		return false, err
+	}
+
+	if len(x.held.Waited) > 0 && !x.mode.DryRun {
+		x.targets, err = dropSatisfiedTargets(plan, x.targets, x.held.Waited, x.makeTuples, x.makeEnv, stdout)
+		if err != nil {
+			return false, err
+		}
+		if len(x.targets) == 0 {
+			return true, writeLine(stdout, "decomk: every target was satisfied by the run(s) waited for; not running make")
+		}
+	}
+	return false, nil
+}
+
+// releaseStampsLock releases the stamps lock if it is held.
+func (x *execution) releaseStampsLock() error {
+	if x.lock == nil {
+		return nil
+	}
+	err := x.lock.Close()
+	x.lock = nil
+	if err != nil {
+		return fmt.Errorf("close stamps lock: %w", err)
+	}
+	return nil
+}
+
+// openRunLog points out and errOut at the terminal and, in run mode, at a
+// new per-run log under the run log dir.
+func (x *execution) openRunLog() error {
+	x.out, x.errOut = x.stdout, x.stderr
+	x.termOut, x.termErr = x.stdout, x.stderr
+	if !x.mode.Log {
+		return nil
+	}
+	var err error
+	x.runLogDir, err = createRunLogDir(x.plan, x.runID, x.stderr)
+	if err != nil {
+		return err
+	}
+	x.runLogPath = filepath.Join(x.runLogDir, "make.log")
+	if x.plan.ExpandTrace != nil {
+		if err := writeExpandTrace(filepath.Join(x.runLogDir, "trace.json"), x.plan.ExpandTrace); err != nil {
+			return err
+		}
+	}
+	x.logFile, err = os.OpenFile(x.runLogPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	label := strings.Join(x.targets, " ")
+	x.logOut = newLogLineWriter(x.logFile, x.flags.logTemplate, logLine{RunID: x.runID, Target: label, Stream: "stdout"})
+	x.logErr = newLogLineWriter(x.logFile, x.flags.logTemplate, logLine{RunID: x.runID, Target: label, Stream: "stderr"})
+	if !x.flags.fullOutput {
+		x.termOut, x.termErr = newConsoleCollapser(x.stdout), newConsoleCollapser(x.stderr)
+	}
+	x.out = io.MultiWriter(x.termOut, x.logOut)
+	x.errOut = io.MultiWriter(x.termErr, x.logErr)
+	return nil
+}
+
+// prepareRun sets up what the make invocation needs beyond the plan: the
+// shell trace, the per-run temp and artifacts dirs, and the target graph for
+// -parallel and -auto-stamp.
+func (x *execution) prepareRun() error {
+	// Makefile recipes that drop privileges (runuser/su) typically need a
+	// non-empty username. Warn early if we can't determine it so users aren't
+	// surprised by a confusing "unknown user" failure during make.
+	//
+	// Intent: Provide a clear, early signal when user-scoped recipe patterns are
+	// likely to fail in root-make mode.
+	// Source: DI-lafib (TODO-jirin)
+	if resolveRemoteUser() == "" {
+		if err := writeLine(x.errOut, "decomk: warning: DECOMK_REMOTE_USER is empty; Makefile recipes that drop privileges (runuser/su) may fail"); err != nil {
+			return err
+		}
+	}
+
+	if x.mode.DryRun {
+		if err := writeLine(x.stdout); err != nil {
+			return err
+		}
+		if err := writeLine(x.stdout, "make -n output:"); err != nil {
+			return err
+		}
+	}
+
+	x.makefiles = planMakefiles(x.plan)
+	if x.flags.traceShell && x.mode.Log {
+		fragment, traceFile, err := openShellTrace(x.plan.Home, x.runLogDir)
+		if err != nil {
+			return err
+		}
+		x.traceFile = traceFile
+		x.makefiles = append(x.makefiles, fragment)
+		x.extraFiles = []*os.File{traceFile}
+		x.makeEnv = withEnv(x.makeEnv, map[string]string{"BASH_XTRACEFD": shellTraceFD})
+		if err := writeLine(x.out, "shell trace:", traceFile.Name()); err != nil {
+			return err
+		}
+	}
+
+	if !x.mode.DryRun {
+		runTmp, err := createRunTmpDir(x.plan.Home)
+		if err != nil {
+			return err
+		}
+		x.runTmp = runTmp
+		x.makeTuples = append(x.makeTuples, runTmpVar+"="+runTmp)
+		x.makeEnv = withEnv(x.makeEnv, map[string]string{runTmpVar: runTmp})
+		if x.runLogDir != "" {
+			artifactsDir, err := createRunArtifactsDir(x.runLogDir)
+			if err != nil {
+				return err
+			}
+			x.makeTuples = append(x.makeTuples, runArtifactsVar+"="+artifactsDir)
+			x.makeEnv = withEnv(x.makeEnv, map[string]string{runArtifactsVar: artifactsDir})
+		}
+	}
+
+	goals := dedupeStrings(x.targets)
+	if x.flags.parallel > 0 && !x.mode.DryRun && (len(goals) > 1 || x.flags.autoStamp) {
+		graph, err := readTargetGraph(x.plan, x.makeTuples, x.makeEnv, goals)
+		if err != nil {
+			msg := "decomk: warning: -parallel: running targets in one make invocation:"
+			if x.flags.autoStamp {
+				msg = "decomk: warning: -auto-stamp: running targets in one make invocation without stamping them:"
+			}
+			if err := writeLine(x.errOut, msg, err.Error()); err != nil {
+				return err
+			}
+		}
+		x.graph = graph
+	}
+	x.hookEnv = withEnv(x.makeEnv, map[string]string{"DECOMK_RUN_ID": x.runID, "DECOMK_TARGETS": strings.Join(x.targets, " ")})
+	return nil
+}
+
+// startEvents starts the run's event stream and announces the run.
+//
+// Intent: Let IDE status bars and other UIs follow a run without tailing
+// logs; the stream is best effort and never fails the run.
+// Source: DI-basaf (TODO-jirin)
+func (x *execution) startEvents() error {
+	events, err := startEventBroker(state.EventsSocketPath(x.plan.Home), x.runID, len(dedupeStrings(x.targets)))
+	if err != nil {
+		if err := writeLine(x.errOut, "decomk: warning:", err.Error()); err != nil {
+			return err
+		}
+	}
+	x.events = events
+	x.events.publish(runEvent{Event: eventRunStarted})
+	return nil
+}
+
+// runMake runs make, per target under -parallel and -auto-stamp, or once for
+// every target. Make's own result is left in makeExitCode and makeErr; the
+// returned error is decomk's.
+func (x *execution) runMake() error {
+	makeCmd := []string{"make"}
+	x.usageStart, x.usageStartErr = takeUsageSnapshot()
+	x.makeStart = time.Now()
+	defer func() { x.makeElapsed = time.Since(x.makeStart) }()
+	if x.graph != nil {
+		for _, target := range x.graph.Order {
+			makeArgv := buildMakeArgv(makeCmd, x.mode.MakeFlags, x.makefiles, x.makeTuples, []string{target})
+			if err := writeLine(x.stdout, "make command:", shellJoinArgv(makeArgv)); err != nil {
+				return err
+			}
+		}
+		p := parallelMake{
+			Dir:       x.plan.StampDir,
+			Makefiles: x.makefiles,
+			Command:   makeCmd,
+			Flags:     x.mode.MakeFlags,
+			Tuples:    x.makeTuples,
+			Env:       x.makeEnv,
+			LogDir:    filepath.Join(x.runLogDir, "targets"),
+			Jobs:      x.flags.parallel,
+			LogFormat: x.flags.logTemplate,
+			RunID:     x.runID,
+			Events:    x.events,
+		}
+		p.ExtraFiles = x.extraFiles
+		p.AutoStamp = x.flags.autoStamp
+		p.Retry = x.retry
+		p.Heartbeat = x.heartbeat
+		p.NonInteractive = x.flags.nonInteractive
+		status := statusOutput{term: x.stdout, log: x.logOut, colors: x.flags.colors}
+		x.targetRuns, x.makeExitCode, x.makeErr = runTargetsParallel(p, *x.graph, status)
+		return nil
+	}
+
+	makeArgv := buildMakeArgv(makeCmd, x.mode.MakeFlags, x.makefiles, x.makeTuples, x.targets)
+	// Intent: Print the exact argv decomk is about to execute so operators can
+	// see/copy the concrete make invocation without reverse-engineering tuple and
+	// target ordering from code or logs.
+	// Source: DI-sugit (TODO-jirin)
+	if err := writeLine(x.stdout, "make command:", shellJoinArgv(makeArgv)); err != nil {
+		return err
+	}
+
+	label := strings.Join(x.targets, " ")
+	if label == "" {
+		label = "make"
+	}
+	nonInteractive := x.flags.nonInteractive
+	notify := func(text string) error { return writeLine(x.errOut, text) }
+	x.makeExitCode, x.makeErr = runWithRetry(x.retry, x.errOut, "make", func(output io.Writer) (int, error) {
+		return runWatched(x.heartbeat, label, notify, io.MultiWriter(x.out, output), io.MultiWriter(x.errOut, output), func(stdout, stderr io.Writer) (int, error) {
+			return guardPrompts(nonInteractive, label, stdout, stderr, func(stdout, stderr io.Writer) (int, error) {
+				if nonInteractive {
+					return makeexec.RunMakefilesCommandFilesNoTTY(x.plan.StampDir, x.makefiles, makeCmd, x.mode.MakeFlags, x.makeTuples, x.targets, x.makeEnv, x.extraFiles, stdout, stderr)
+				}
+				return makeexec.RunMakefilesCommandFiles(x.plan.StampDir, x.makefiles, makeCmd, x.mode.MakeFlags, x.makeTuples, x.targets, x.makeEnv, x.extraFiles, stdout, stderr)
+			})
+		})
+	})
+	return flushLogWriters(x.termOut, x.termErr)
+}
+
+// finishMake accounts for the resources make used, ends the event stream,
+// and cleans up the per-run temp dir.
+func (x *execution) finishMake() error {
+	if !x.mode.DryRun {
+		usage, usageErr := finishUsage(x.usageStart, x.usageStartErr)
+		if usageErr != nil {
+			if err := writeLine(x.errOut, "decomk: warning: resource usage:", usageErr.Error()); err != nil {
+				return err
+			}
+		}
+		if usage != nil {
+			if err := writeLine(x.out, "decomk: resources:", formatUsage(*usage)); err != nil {
+				return err
+			}
+		}
+		x.usage = usage
+	}
+	x.events.publish(runEvent{Event: eventRunFinished, ExitCode: &x.makeExitCode, ElapsedSeconds: x.makeElapsed.Seconds()})
+	if err := x.events.Close(); err != nil {
+		if warnErr := writeLine(x.errOut, "decomk: warning:", err.Error()); warnErr != nil {
+			return warnErr
+		}
+	}
+	if x.runTmp != "" {
+		if tmpErr := finishRunTmpDir(x.runTmp, x.flags.keepRunTmp && x.makeErr != nil, x.errOut); tmpErr != nil {
+			if warnErr := writeLine(x.errOut, "decomk: warning:", tmpErr.Error()); warnErr != nil {
+				return warnErr
+			}
+		}
+	}
+	return nil
+}
+
+// recordRun writes the run's summary: the last-run and in-progress records,
+// the status file, timings, and the MOTD, then sends telemetry and runs the
+// post-run hooks. Failures to record are warnings; the returned error is
+// only for output decomk could not write.
+func (x *execution) recordRun() error {
+	plan, errOut := x.plan, x.errOut
+	warn := func(err error, prefix ...any) error {
+		if err == nil {
+			return nil
+		}
+		return writeLine(errOut, append(append([]any{"decomk: warning:"}, prefix...), err.Error())...)
+	}
+	// Intent: Use DECOMK_STAGE0_PHASE as the single phase source and let
+	// DECOMK_MOTD_PHASES decide whether/how that phase maps to a MOTD file.
+	// Source: DI-tuhul (TODO-mirut)
+	phase := strings.TrimSpace(os.Getenv("DECOMK_STAGE0_PHASE"))
+	record := lastRun{
+		FinishedAt: time.Now().UTC(),
+		ExitCode:   x.makeExitCode,
+		ActionArgs: x.actionArgs,
+		Targets:    x.targets,
+		LogPath:    x.runLogPath,
+		StampDir:   plan.StampDir,
+		MakeArgs:   lastRunMakeArgs(planMakefiles(plan), x.makeTuples, x.targets),
+	}
+	record.Interrupted, record.InProgress = interruptedTargets(x.makeErr, x.targetRuns, plan.StampDir, x.targets)
+	if len(record.InProgress) > 0 {
+		if err := writeLine(errOut, "decomk: interrupted ("+record.Interrupted+") during", strings.Join(record.InProgress, " ")+"; it may be half-applied"); err != nil {
+			return err
+		}
+	}
+	completed := completedTargets(x.makeErr, x.targetRuns, x.targets)
+	unstamped, stampErr := unstampedTargets(plan, completed, x.makeTuples, x.makeEnv)
+	if err := warn(stampErr, "check stamps:"); err != nil {
+		return err
+	}
+	for _, target := range unstamped {
+		if err := writeLine(errOut, "decomk: warning: target", target, "succeeded without creating its stamp, so it reruns every time; end its recipe with $(DECOMK_BIN) stamp $@ (or touch $@), or declare it .PHONY"); err != nil {
+			return err
+		}
+	}
+	record.Unstamped = unstamped
+	if x.mode.LockStamps {
+		// The run records are shared by concurrent runs; update them
+		// one run at a time. close releases the lock on early returns.
+		var err error
+		x.lock, err = lockStamps(plan.Home, x.command, io.Discard)
+		if err != nil {
+			return fmt.Errorf("lock stamps: %w", err)
+		}
+	}
+	if err := warn(recordInProgress(state.InProgressPath(plan.Home), record, completed)); err != nil {
+		return err
+	}
+	if err := warn(writeLastRun(state.LastRunPath(plan.Home), record)); err != nil {
+		return err
+	}
+	pending := x.makeExitCode != 0
+	if err := warn(writeStatusFile(state.StatusPath(plan.Home), newStatusSummary(&record, &pending, record.FinishedAt))); err != nil {
+		return err
+	}
+	timing := newTimingRecord(record.FinishedAt, x.makeExitCode, x.makeElapsed, x.targets, x.targetRuns)
+	timing.Usage = x.usage
+	if err := warn(appendTimingRecord(state.TimingsPath(plan.Home), timing, timingHistory)); err != nil {
+		return err
+	}
+	if err := warn(writePhaseMotdSummary(plan, x.cookedTuples, x.targets, phase, x.makeExitCode, x.makeErr, x.runLogPath)); err != nil {
+		return err
+	}
+	// Post-run hooks may be slow; run them after other runs can record.
+	if err := x.releaseStampsLock(); err != nil {
+		return err
+	}
+	if x.telemetryURL != "" {
+		report := newTelemetryReport(timing, platformVars())
+		if err := warn(postTelemetry(&http.Client{Timeout: telemetryTimeout}, x.telemetryURL, report)); err != nil {
+			return err
+		}
+	}
+	postEnv := withEnv(x.hookEnv, map[string]string{"DECOMK_EXIT_CODE": strconv.Itoa(x.makeExitCode), "DECOMK_RUN_LOG": x.runLogPath})
+	return warn(runPluginHooks(plan.Plugins, pluginHookPostRun, postEnv, x.out, errOut))
+}
+
+// result returns the invocation's exit code and error from make's result,
+// printing an error digest from the logs when make failed, and records a
+// successful run's first boot.
+func (x *execution) result() (int, error) {
+	if x.makeErr != nil {
+		if x.runLogPath == "" {
+			return x.makeExitCode, fmt.Errorf("make failed (exit %d): %w", x.makeExitCode, x.makeErr)
+		}
+		logPaths := []string{x.runLogPath}
+		for _, run := range x.targetRuns {
+			if run.Err != nil && run.LogPath != "" {
+				logPaths = append(logPaths, run.LogPath)
+			}
+		}
+		if digestErr := writeErrorDigest(x.stderr, logPaths); digestErr != nil {
+			if warnErr := writeLine(x.stderr, "decomk: warning:", digestErr.Error()); warnErr != nil {
+				return 1, warnErr
+			}
+		}
+		return x.makeExitCode, fmt.Errorf("make failed (exit %d); log: %s: %w", x.makeExitCode, x.runLogPath, x.makeErr)
+	}
+	if !x.mode.DryRun {
+		if err := writeBootstrapMarker(state.BootstrapMarkerPath(x.plan.Home), time.Now()); err != nil {
+			if warnErr := writeLine(x.errOut, "decomk: warning:", err.Error()); warnErr != nil {
+				return 1, warnErr
+			}
+		}
+	}
+	return 0, nil
+}
+
+// close releases what the invocation holds, innermost first: the event
+// stream, the shell trace, the run log, the stamps lock, and the target
+// locks. It is safe to call at any phase.
+func (x *execution) close() error {
+	var err error
+	if closeErr := x.events.Close(); closeErr != nil {
+		if warnErr := writeLine(x.stderr, "decomk: warning:", closeErr.Error()); warnErr != nil {
+			err = errors.Join(err, warnErr)
+		}
+	}
+	if x.traceFile != nil {
+		if closeErr := x.traceFile.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close shell trace log %s: %w", x.traceFile.Name(), closeErr))
+		}
+	}
+	// Intent: Preserve deferred log close failures so successful runs don't hide
+	// file descriptor or fs-sync problems in audit logs.
+	// Source: DI-golak (TODO-gamuz)
+	if x.logFile != nil {
+		if flushErr := flushLogWriters(x.logOut, x.logErr, x.termOut, x.termErr); flushErr != nil {
+			err = errors.Join(err, fmt.Errorf("flush run log file %s: %w", x.runLogPath, flushErr))
+		}
+		if closeErr := x.logFile.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close run log file %s: %w", x.runLogPath, closeErr))
+		}
+	}
+	// Intent: Preserve close errors from deferred lock release so decomk never
+	// drops lock lifecycle failures.
+	// Source: DI-golak (TODO-gamuz)
+	err = errors.Join(err, x.releaseStampsLock())
+	if closeErr := x.held.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("close target locks: %w", closeErr))
+	}
+	return err
+}
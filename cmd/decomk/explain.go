@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/stevegt/decomk/expand"
+	"github.com/stevegt/decomk/resolve"
+)
+
+// explainResult describes a context key or tuple name: its doc comment, its
+// effective value, and how it was produced, in expansion order (the last
+// assignment of a tuple wins).
+type explainResult struct {
+	Name        string              `json:"name"`
+	Doc         string              `json:"doc,omitempty"`
+	Value       *string             `json:"value,omitempty"`
+	Derivations []expand.TraceEntry `json:"derivations"`
+}
+
+// explainName collects what plan knows about name: a context key's doc and
+// the tokens expanded through it, or every assignment to a tuple name. plan
+// must have been resolved with -trace-expand. ok is false when name is
+// neither a loaded key nor a tuple name.
+func explainName(plan *resolvedPlan, name string) (result explainResult, ok bool) {
+	result = explainResult{Name: name, Doc: plan.Docs[name], Derivations: []expand.TraceEntry{}}
+	_, ok = plan.Defs[name]
+	for _, entry := range plan.ExpandTrace.Entries {
+		_, tuple := resolve.SplitClass(entry.Token)
+		tupleName, _, isTuple := resolve.SplitTuple(tuple)
+		viaKey := false
+		for _, key := range entry.Path {
+			if key == name {
+				viaKey = true
+				break
+			}
+		}
+		if (isTuple && tupleName == name) || viaKey {
+			result.Derivations = append(result.Derivations, entry)
+		}
+	}
+	if value, found := effectiveTupleValues(redactSecretTuples(plan.Tuples, plan.Secrets))[name]; found {
+		result.Value = &value
+		ok = true
+	}
+	return result, ok || len(result.Derivations) > 0
+}
+
+// cmdExplain prints the doc comment, effective value, and derivations of a
+// context key or tuple name.
+//
+// Intent: Make config repos self-documenting so users can learn what a context
+// or macro is for from the CLI.
+// Source: DI-zinug (TODO-jirin)
+func cmdExplain(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk explain", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+	addCommonFlags(fs, &f)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 1 {
+		return 2, fmt.Errorf("usage: decomk explain [flags] NAME")
+	}
+	if f.profile != "" {
+		return 2, fmt.Errorf("explain cannot use -profile (a profile stores already-expanded tokens)")
+	}
+	name := fs.Arg(0)
+	f.traceExpand = true
+	if err := applyStartDir(f.startDir); err != nil {
+		return 1, err
+	}
+	plan, err := resolvePlanFromFlags(f)
+	if err != nil {
+		return 1, err
+	}
+	if plan.Tuples, err = resolveRuntimeTuples(plan.Tuples, envMapFromList(os.Environ())); err != nil {
+		return 1, err
+	}
+	result, ok := explainName(plan, name)
+	if !ok {
+		return 1, fmt.Errorf("no context key or tuple named %q", name)
+	}
+	return 0, writeExplain(stdout, result)
+}
+
+// writeExplain prints result in the layout of the plan expansion trace.
+func writeExplain(w io.Writer, result explainResult) error {
+	if err := writeLine(w, result.Name+":"); err != nil {
+		return err
+	}
+	if result.Doc != "" {
+		for _, line := range strings.Split(result.Doc, "\n") {
+			if err := writeLine(w, strings.TrimRight("  ## "+line, " ")); err != nil {
+				return err
+			}
+		}
+	}
+	if result.Value != nil {
+		if err := writeFormat(w, "  value: %s\n", *result.Value); err != nil {
+			return err
+		}
+	}
+	if len(result.Derivations) == 0 {
+		return writeLine(w, "  not expanded for the selected contexts")
+	}
+	for _, entry := range result.Derivations {
+		path := "(seed)"
+		if len(entry.Path) > 0 {
+			path = strings.Join(entry.Path, " > ")
+		}
+		if err := writeFormat(w, "  %s <- %s\n", entry.Token, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCmdContextsAndExplain_ShowDocs(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	conf := "## Shared tools.\nTOOLS: INSTALL=hello\n## Every workspace.\n## Keep it small.\nDEFAULT: TOOLS FOO=bar\nUNUSED: FOO=baz\n"
+	if err := os.WriteFile(configPath, []byte(conf), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("hello:\n\t@true\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath, "-makefile", makefilePath}
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdContexts(args, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdContexts(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	want := "* DEFAULT\n    Every workspace.\n    Keep it small.\n  TOOLS\n    Shared tools.\n  UNUSED\n"
+	if got := stdout.String(); got != want {
+		t.Fatalf("cmdContexts(): got %q want %q", got, want)
+	}
+
+	stdout.Reset()
+	code, err = cmdExplain(append(args, "TOOLS"), &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdExplain(TOOLS): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	want = "TOOLS:\n  ## Shared tools.\n  INSTALL=hello <- DEFAULT > TOOLS\n"
+	if got := stdout.String(); got != want {
+		t.Fatalf("cmdExplain(TOOLS): got %q want %q", got, want)
+	}
+
+	stdout.Reset()
+	code, err = cmdExplain(append(args, "UNUSED"), &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdExplain(UNUSED): code=%d err=%v", code, err)
+	}
+	if got := stdout.String(); !strings.Contains(got, "not expanded for the selected contexts") {
+		t.Fatalf("cmdExplain(UNUSED): got %q", got)
+	}
+
+	if code, err := cmdExplain(append(args, "NOPE"), &stdout, &stderr); err == nil || code != 1 {
+		t.Fatalf("cmdExplain(NOPE): got code=%d err=%v want an error", code, err)
+	}
+}
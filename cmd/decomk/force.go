@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// parseForceTargets splits a -force value on commas and whitespace.
+func parseForceTargets(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+// forcedTargets returns the targets whose stamps -force and -force-all
+// delete: the force list, plus with forceAll every target with a recipe in
+// the make closure of targets (make -B semantics, confined to what this run
+// builds).
+func forcedTargets(plan *resolvedPlan, force []string, forceAll bool, targets, makeTuples, makeEnv []string) ([]string, error) {
+	if !forceAll {
+		return dedupeStrings(force), nil
+	}
+	rules, err := cachedMakeRules(plan.Home, plan.StampDir, planMakefiles(plan), makeTuples, makeEnv)
+	if err != nil {
+		return nil, err
+	}
+	all := recipeClosure(rules, targets)
+	return dedupeStrings(append(append([]string(nil), force...), all...)), nil
+}
+
+// recipeClosure returns, sorted, every target with a recipe in the make
+// closure of targets, leaving out special targets such as .PHONY.
+func recipeClosure(rules makeRules, targets []string) []string {
+	closure := make(map[string]bool)
+	for _, target := range targets {
+		for name := range rules.Closure(target) {
+			if !strings.HasPrefix(name, ".") && rules.hasRecipe(name) {
+				closure[name] = true
+			}
+		}
+	}
+	all := make([]string, 0, len(closure))
+	for name := range closure {
+		all = append(all, name)
+	}
+	sort.Strings(all)
+	return all
+}
+
+// forceStamps implements -force and -force-all: it deletes the forced
+// targets' stamps and reports each one removed. The caller holds the stamps
+// lock.
+//
+// Intent: Let users redo one block, or a whole run, without a separate clean
+// step or deleting stamp files by hand.
+// Source: DI-tuzuj (TODO-jirin)
+func forceStamps(plan *resolvedPlan, force []string, forceAll bool, targets, makeTuples, makeEnv []string, stdout io.Writer) error {
+	forced, err := forcedTargets(plan, force, forceAll, targets, makeTuples, makeEnv)
+	if err != nil {
+		return err
+	}
+	removed, err := removeStamps(plan.StampDir, forced)
+	for _, stamp := range removed {
+		if writeErr := writeLine(stdout, "decomk: forced: removed stamp", stamp); writeErr != nil {
+			return writeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseForceTargets(t *testing.T) {
+	t.Parallel()
+
+	got := parseForceTargets("Block00, Block10 tools/jq,")
+	want := []string{"Block00", "Block10", "tools/jq"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseForceTargets(): got %q want %q", got, want)
+	}
+}
+
+func TestForceStamps(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	makefile := filepath.Join(dir, "Makefile")
+	content := "" +
+		"top: dep input.txt\n\ttouch $@\n" +
+		"dep:\n\ttouch $@\n" +
+		"other:\n\ttouch $@\n"
+	if err := os.WriteFile(makefile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stampDir := filepath.Join(dir, "stamps")
+	if err := os.MkdirAll(stampDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"top", "dep", "other", "input.txt"} {
+		if err := os.WriteFile(filepath.Join(stampDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan := &resolvedPlan{Home: t.TempDir(), StampDir: stampDir, Makefiles: []string{makefile}}
+
+	// -force removes only the named stamp.
+	var stdout bytes.Buffer
+	if err := forceStamps(plan, []string{"dep"}, false, []string{"top"}, nil, os.Environ(), &stdout); err != nil {
+		t.Fatalf("forceStamps(-force dep): %v", err)
+	}
+	if fileExists(filepath.Join(stampDir, "dep")) || !fileExists(filepath.Join(stampDir, "top")) {
+		t.Fatalf("forceStamps(-force dep): want only dep removed")
+	}
+	if got, want := stdout.String(), "decomk: forced: removed stamp "+filepath.Join(stampDir, "dep")+"\n"; got != want {
+		t.Fatalf("forceStamps() output: got %q want %q", got, want)
+	}
+
+	// -force-all removes the closure's recipe targets, but not plain
+	// prerequisite files or unrelated stamps.
+	got, err := forcedTargets(plan, nil, true, []string{"top"}, nil, os.Environ())
+	if err != nil {
+		t.Fatalf("forcedTargets(-force-all): %v", err)
+	}
+	if want := []string{"dep", "top"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("forcedTargets(-force-all): got %q want %q", got, want)
+	}
+	if err := forceStamps(plan, nil, true, []string{"top"}, nil, os.Environ(), &stdout); err != nil {
+		t.Fatalf("forceStamps(-force-all): %v", err)
+	}
+	for name, want := range map[string]bool{"top": false, "input.txt": true, "other": true} {
+		if got := fileExists(filepath.Join(stampDir, name)); got != want {
+			t.Fatalf("after -force-all, %s exists: got %v want %v", name, got, want)
+		}
+	}
+}
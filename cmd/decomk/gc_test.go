@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestGCRunLogs_AgeKeepAndSize(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	now := time.Now()
+	// Newest first: run-0 .. run-4, one day apart, 100 bytes each.
+	for i := 0; i < 5; i++ {
+		dir := filepath.Join(root, "run-"+string(rune('0'+i)))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("MkdirAll(%s): %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "make.log"), bytes.Repeat([]byte("x"), 100), 0o644); err != nil {
+			t.Fatalf("WriteFile(make.log): %v", err)
+		}
+		modTime := now.Add(-time.Duration(i) * 24 * time.Hour)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatalf("Chtimes(%s): %v", dir, err)
+		}
+	}
+
+	policy := gcPolicy{Now: now, MaxAge: 3*24*time.Hour + time.Hour, KeepLogs: 1, MaxLogBytes: 250}
+	entries, err := gcRunLogs([]string{root, filepath.Join(root, "missing")}, policy)
+	if err != nil {
+		t.Fatalf("gcRunLogs() error: %v", err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, filepath.Base(entry.Paths[0]))
+	}
+	// run-4 is too old; run-3 then run-2 go to fit 250 bytes; run-0 is kept.
+	if want := []string{"run-4", "run-3", "run-2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("gcRunLogs(): got %q want %q", got, want)
+	}
+
+	policy.KeepLogs = 5
+	entries, err = gcRunLogs([]string{root}, policy)
+	if err != nil {
+		t.Fatalf("gcRunLogs() error: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("gcRunLogs() with KeepLogs=5: got %d entries want 0", len(entries))
+	}
+}
+
+func TestCmdGC_DryRunThenRemove(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	home := t.TempDir()
+	logRoot := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	conf := "DEFAULT: FOO=bar\nrecipe install-jq: touch $@\n"
+	if err := os.WriteFile(configPath, []byte(conf), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	makefile := "Block00:\n\ttouch $@\n\nstamp-%:\n\ttouch $@\n"
+	if err := os.WriteFile(makefilePath, []byte(makefile), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	stampDir := state.StampDir(home)
+	for _, name := range []string{"Block00", "install-jq", "stamp-foo", "removed-target", ".hidden"} {
+		path := filepath.Join(stampDir, name)
+		if err := state.EnsureParentDir(path); err != nil {
+			t.Fatalf("EnsureParentDir(%s): %v", path, err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+	for _, dir := range []string{filepath.Join(logRoot, "old-run"), filepath.Join(state.RunTmpRoot(home), "run-old")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("MkdirAll(%s): %v", dir, err)
+		}
+		if err := os.Chtimes(dir, old, old); err != nil {
+			t.Fatalf("Chtimes(%s): %v", dir, err)
+		}
+	}
+
+	args := []string{
+		"-home", home,
+		"-log-dir", logRoot,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"-max-age", "24h",
+		"-keep-logs", "0",
+	}
+	removed := []string{
+		filepath.Join(logRoot, "old-run"),
+		filepath.Join(state.RunTmpRoot(home), "run-old"),
+		filepath.Join(stampDir, "removed-target"),
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdGC(append([]string{"-dry-run"}, args...), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdGC(-dry-run) error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdGC(-dry-run) code: got %d want 0", code)
+	}
+	outText := stdout.String()
+	for _, path := range removed {
+		if !strings.Contains(outText, "would remove") || !strings.Contains(outText, path) {
+			t.Fatalf("cmdGC(-dry-run) stdout missing %q:\n%s", path, outText)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("cmdGC(-dry-run) removed %s: %v", path, err)
+		}
+	}
+	if got, want := strings.Count("\n"+outText, "\nwould remove "), 3; got != want {
+		t.Fatalf("cmdGC(-dry-run) entries: got %d want %d:\n%s", got, want, outText)
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	code, err = cmdGC(args, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdGC() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdGC() code: got %d want 0", code)
+	}
+	for _, path := range removed {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("cmdGC() kept %s (stat err=%v)", path, err)
+		}
+	}
+	for _, name := range []string{"Block00", "install-jq", "stamp-foo", ".hidden"} {
+		if _, err := os.Stat(filepath.Join(stampDir, name)); err != nil {
+			t.Fatalf("cmdGC() removed live stamp %s: %v", name, err)
+		}
+	}
+}
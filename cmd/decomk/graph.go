@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/expand"
+)
+
+// cmdGraph writes the config's context -> macro -> token structure as a
+// Graphviz DOT digraph.
+//
+// Intent: Let maintainers see how DEFAULT, repo stanzas, and blocks compose,
+// as opposed to the make target DAG.
+// Source: DI-rugos (TODO-jirin)
+func cmdGraph(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk graph", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+	addCommonFlags(fs, &f)
+	var noTokens bool
+	fs.BoolVar(&noTokens, "no-tokens", false, "draw only config keys, without their literal tuple tokens")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("graph does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if f.profile != "" {
+		return 2, fmt.Errorf("graph cannot use -profile (a profile stores no config keys)")
+	}
+	if err := applyStartDir(f.startDir); err != nil {
+		return 1, err
+	}
+	plan, err := resolvePlanFromFlags(f)
+	if err != nil {
+		return 1, err
+	}
+	return 0, writeConfigGraph(stdout, plan.Defs, plan.ContextKeys, !noTokens)
+}
+
+// writeConfigGraph renders defs as DOT.
+//
+// Each key is a box with an edge to every key its body references; an edge
+// reached through a conditional is labeled with the guard. Seed contexts
+// (contextSeedKeys) are bold, the keys in selected are filled, and keys no
+// seed reaches are dashed. With tokens, every distinct literal token is one
+// leaf shared by the keys that emit it. Keys are sorted and leaves numbered in
+// first-seen order so the output diffs cleanly.
+func writeConfigGraph(w io.Writer, defs contexts.Defs, selected []string, tokens bool) error {
+	seeds := contextSeedKeys(defs, selected)
+	reached := expand.Reachable(expand.Defs(defs), seeds)
+	isSeed := make(map[string]bool, len(seeds))
+	for _, key := range seeds {
+		isSeed[key] = true
+	}
+	isSelected := make(map[string]bool, len(selected))
+	for _, key := range selected {
+		isSelected[key] = true
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph decomk {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box];\n")
+	keys := sortedDefKeys(defs)
+	for _, key := range keys {
+		if contexts.IsDirective(key) {
+			continue
+		}
+		var style []string
+		if isSeed[key] {
+			style = append(style, "bold")
+		}
+		if isSelected[key] {
+			style = append(style, "filled")
+		}
+		if !reached[key] {
+			style = append(style, "dashed")
+		}
+		attrs := ""
+		if len(style) > 0 {
+			attrs = fmt.Sprintf(" [style=%s]", dotQuote(strings.Join(style, ",")))
+		}
+		fmt.Fprintf(&b, "\t%s%s;\n", dotQuote(key), attrs)
+	}
+
+	leaves := make(map[string]string)
+	for _, key := range keys {
+		if contexts.IsDirective(key) {
+			continue
+		}
+		for _, tok := range defs[key] {
+			label := ""
+			if cond, ok := expand.ParseCondition(tok); ok {
+				label = fmt.Sprintf(" [label=%s]", dotQuote("?"+cond.Guard))
+				tok = cond.Then
+			}
+			if _, isKey := defs[tok]; isKey {
+				fmt.Fprintf(&b, "\t%s -> %s%s;\n", dotQuote(key), dotQuote(tok), label)
+				continue
+			}
+			if !tokens {
+				continue
+			}
+			leaf, ok := leaves[tok]
+			if !ok {
+				leaf = fmt.Sprintf("t%d", len(leaves))
+				leaves[tok] = leaf
+				fmt.Fprintf(&b, "\t%s [shape=plaintext, label=%s];\n", leaf, dotQuote(tok))
+			}
+			fmt.Fprintf(&b, "\t%s -> %s%s;\n", dotQuote(key), leaf, label)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotQuote returns s as a DOT double-quoted ID.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
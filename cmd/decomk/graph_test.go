@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCmdGraph_WritesDOT(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	conf := "DECOMK_TOOL_REF: v1.0.0\n" +
+		"Block00: INSTALL=hello\n" +
+		"Block20: GPU_DRIVER=\"x\"\n" +
+		"acme/app: Block00 APP=1\n" +
+		"Dead: APP=1\n" +
+		"DEFAULT: Block00 ?GPU=1 -> Block20\n"
+	if err := os.WriteFile(configPath, []byte(conf), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("hello:\n\t@true\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath, "-makefile", makefilePath}
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdGraph(args, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdGraph(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	want := `digraph decomk {
+	rankdir=LR;
+	node [shape=box];
+	"Block00";
+	"Block20";
+	"DEFAULT" [style="bold,filled"];
+	"Dead" [style="dashed"];
+	"acme/app" [style="bold"];
+	t0 [shape=plaintext, label="INSTALL=hello"];
+	"Block00" -> t0;
+	t1 [shape=plaintext, label="GPU_DRIVER=\"x\""];
+	"Block20" -> t1;
+	"DEFAULT" -> "Block00";
+	"DEFAULT" -> "Block20" [label="?GPU=1"];
+	t2 [shape=plaintext, label="APP=1"];
+	"Dead" -> t2;
+	"acme/app" -> "Block00";
+	"acme/app" -> t2;
+}
+`
+	if got := stdout.String(); got != want {
+		t.Fatalf("cmdGraph(): got\n%s\nwant\n%s", got, want)
+	}
+
+	stdout.Reset()
+	if code, err := cmdGraph(append(args, "-no-tokens"), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdGraph(-no-tokens): code=%d err=%v", code, err)
+	}
+	if got := stdout.String(); strings.Contains(got, "plaintext") || !strings.Contains(got, `"acme/app" -> "Block00";`) {
+		t.Fatalf("cmdGraph(-no-tokens): got\n%s", got)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stevegt/decomk/expand"
+)
+
+// targetGuardsTuple is the tuple name attaching guard expressions to targets
+// (see parseTargetGuards).
+const targetGuardsTuple = "DECOMK_TARGET_GUARDS"
+
+// targetGuard is a target that is only run while Expr holds.
+type targetGuard struct {
+	Target string
+	Expr   *expand.Expr
+}
+
+// guardResult is one evaluation of a target guard.
+type guardResult struct {
+	Target string
+	Expr   string
+	Passed bool
+}
+
+// parseTargetGuards parses a DECOMK_TARGET_GUARDS value: ';'-separated
+// TARGET=EXPR entries, where EXPR is a guard expression (see expand.Expr).
+func parseTargetGuards(raw string) ([]targetGuard, error) {
+	var guards []targetGuard
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, src, ok := strings.Cut(entry, "=")
+		target = strings.TrimSpace(target)
+		if !ok || target == "" || strings.ContainsAny(target, " \t") || strings.TrimSpace(src) == "" {
+			return nil, fmt.Errorf("%s: entry %q must be TARGET=EXPR", targetGuardsTuple, entry)
+		}
+		if seen[target] {
+			return nil, fmt.Errorf("%s: target %q listed more than once", targetGuardsTuple, target)
+		}
+		seen[target] = true
+		expr, err := expand.ParseExpr(strings.TrimSpace(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", targetGuardsTuple, target, err)
+		}
+		guards = append(guards, targetGuard{Target: target, Expr: expr})
+	}
+	return guards, nil
+}
+
+// filterTargetsByGuards evaluates the guard of each guarded target in targets
+// against values and drops the targets whose guard does not hold. It returns
+// the kept and dropped targets and every evaluation, in target order.
+//
+// Intent: Keep config logic auditable and shell-free while allowing more than
+// one exact-match test per conditional.
+// Source: DI-mutif (TODO-jirin)
+func filterTargetsByGuards(targets []string, guards []targetGuard, values map[string]string) (kept, dropped []string, results []guardResult, err error) {
+	byTarget := make(map[string]*expand.Expr, len(guards))
+	for _, guard := range guards {
+		byTarget[guard.Target] = guard.Expr
+	}
+	evaluated := make(map[string]bool)
+	for _, target := range targets {
+		expr, ok := byTarget[target]
+		if !ok {
+			kept = append(kept, target)
+			continue
+		}
+		passed, err := expr.Eval(values)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %s: %w", targetGuardsTuple, target, err)
+		}
+		if !evaluated[target] {
+			evaluated[target] = true
+			results = append(results, guardResult{Target: target, Expr: expr.String(), Passed: passed})
+		}
+		if passed {
+			kept = append(kept, target)
+		} else {
+			dropped = append(dropped, target)
+		}
+	}
+	return kept, dropped, results, nil
+}
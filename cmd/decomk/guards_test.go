@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTargetGuards(t *testing.T) {
+	t.Parallel()
+
+	guards, err := parseTargetGuards(` Block10=DECOMK_OS == "linux" ; ; install-vnc = GUI && DECOMK_NPROC >= 4 `)
+	if err != nil {
+		t.Fatalf("parseTargetGuards(): %v", err)
+	}
+	var got []string
+	for _, guard := range guards {
+		got = append(got, guard.Target+"|"+guard.Expr.String())
+	}
+	want := []string{`Block10|DECOMK_OS == "linux"`, "install-vnc|GUI && DECOMK_NPROC >= 4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseTargetGuards(): got %q want %q", got, want)
+	}
+
+	for _, raw := range []string{"Block10", "=GUI", "Block10=", "a b=GUI", "Block10=GUI;Block10=X", "Block10=GUI &&"} {
+		if _, err := parseTargetGuards(raw); err == nil {
+			t.Fatalf("parseTargetGuards(%q): got nil want error", raw)
+		}
+	}
+}
+
+func TestFilterTargetsByGuards(t *testing.T) {
+	t.Parallel()
+
+	guards, err := parseTargetGuards(`Block10=DECOMK_ARCH == "arm64"; install-vnc=GUI; Block20=DECOMK_DISTRO_VERSION >= 22.04`)
+	if err != nil {
+		t.Fatalf("parseTargetGuards(): %v", err)
+	}
+	values := map[string]string{"DECOMK_ARCH": "amd64", "GUI": "1", "DECOMK_DISTRO_VERSION": "24.04"}
+	kept, dropped, results, err := filterTargetsByGuards([]string{"Block00", "Block10", "install-vnc", "Block20"}, guards, values)
+	if err != nil {
+		t.Fatalf("filterTargetsByGuards(): %v", err)
+	}
+	if want := []string{"Block00", "install-vnc", "Block20"}; !reflect.DeepEqual(kept, want) {
+		t.Fatalf("filterTargetsByGuards() kept: got %q want %q", kept, want)
+	}
+	if want := []string{"Block10"}; !reflect.DeepEqual(dropped, want) {
+		t.Fatalf("filterTargetsByGuards() dropped: got %q want %q", dropped, want)
+	}
+	wantResults := []guardResult{
+		{Target: "Block10", Expr: `DECOMK_ARCH == "arm64"`, Passed: false},
+		{Target: "install-vnc", Expr: "GUI", Passed: true},
+		{Target: "Block20", Expr: "DECOMK_DISTRO_VERSION >= 22.04", Passed: true},
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		t.Fatalf("filterTargetsByGuards() results: got %+v want %+v", results, wantResults)
+	}
+
+	values["DECOMK_DISTRO_VERSION"] = "rolling"
+	if _, _, _, err := filterTargetsByGuards([]string{"Block20"}, guards, values); err == nil {
+		t.Fatalf("filterTargetsByGuards(rolling): got nil want error")
+	}
+}
+
+func TestCmdPlan_TargetGuards(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	files := map[string]string{
+		configPath:                         "DEFAULT: INSTALL='Block00 Block10' DECOMK_TARGET_GUARDS='Block10=DECOMK_OS == \"plan9\"'\n",
+		filepath.Join(confDir, "Makefile"): "Block00 Block10:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	base := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath}
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdPlan(append(base, "INSTALL"), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	for _, want := range []string{
+		"target guard (Block10): DECOMK_OS == \"plan9\" => false\n",
+		"skipped targets (guards): Block10\n",
+		"targets:\n  Block00\n\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("cmdPlan() output missing %q:\n%s", want, stdout.String())
+		}
+	}
+
+	code, err := cmdPlan(append(base, "-skip", "Block00", "INSTALL"), &stdout, &stderr)
+	if err == nil || code != 2 || !strings.Contains(err.Error(), "-skip and DECOMK_TARGET_GUARDS removed every target") {
+		t.Fatalf("cmdPlan(-skip Block00): got code=%d err=%v want 2 and a removed-every-target error", code, err)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// defaultHealthzMaxAge is how recent the last successful run must be.
+const defaultHealthzMaxAge = 24 * time.Hour
+
+// lastRun is the on-disk record of the most recent `decomk run`.
+type lastRun struct {
+	FinishedAt time.Time `json:"finishedAt"`
+	ExitCode   int       `json:"exitCode"`
+	ActionArgs []string  `json:"actionArgs,omitempty"`
+	Targets    []string  `json:"targets,omitempty"`
+	LogPath    string    `json:"logPath,omitempty"`
+	StampDir   string    `json:"stampDir"`
+	// MakeArgs are the run's make arguments (makefiles, tuples, targets)
+	// without DECOMK_RUN_TMP, which no longer exists after the run.
+	MakeArgs []string `json:"makeArgs"`
+	// Interrupted is the signal that stopped make, if any, and InProgress
+	// the targets it caught mid-recipe.
+	Interrupted string   `json:"interrupted,omitempty"`
+	InProgress  []string `json:"inProgress,omitempty"`
+	// Unstamped are targets that succeeded without creating their stamp.
+	Unstamped []string `json:"unstamped,omitempty"`
+}
+
+// lastRunMakeArgs returns the make arguments healthz replays with -q.
+func lastRunMakeArgs(makefiles, tuples, targets []string) []string {
+	var args []string
+	for _, makefile := range makefiles {
+		args = append(args, "-f", makefile)
+	}
+	for _, tuple := range tuples {
+		if strings.HasPrefix(tuple, runTmpVar+"=") {
+			continue
+		}
+		args = append(args, tuple)
+	}
+	return append(args, targets...)
+}
+
+// writeLastRun records one run outcome at path.
+func writeLastRun(path string, record lastRun) error {
+	content, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode last run record: %w", err)
+	}
+	content = append(content, '\n')
+	if err := state.AtomicWrite(path, content, 0o644); err != nil {
+		return fmt.Errorf("write last run record %s: %w", path, err)
+	}
+	return nil
+}
+
+// readLastRun loads the run record at path.
+func readLastRun(path string) (*lastRun, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var record lastRun
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, fmt.Errorf("decode last run record %s: %w", path, err)
+	}
+	return &record, nil
+}
+
+// cmdHealthz exits 0 only when the last run succeeded recently and make has
+// nothing pending.
+//
+// decomk has no resident daemon, so -watch turns healthz itself into the
+// long-running process that keeps the -write status file current.
+//
+// Intent: Give Docker HEALTHCHECK and orchestrators a single command that
+// reflects whether provisioning converged.
+// Source: DI-gunaz (TODO-jirin)
+func cmdHealthz(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk healthz", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag, writePath string
+	var maxAge, critAge, watch time.Duration
+	var skipPending, nagios bool
+	var colorMode, output string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	fs.DurationVar(&maxAge, "max-age", defaultHealthzMaxAge, "the last successful run must have finished within this window (0 disables the window)")
+	fs.BoolVar(&skipPending, "skip-pending", false, "do not check for pending targets with make -q")
+	fs.StringVar(&writePath, "write", "", "also write the status line to this file (for example /healthz)")
+	fs.BoolVar(&nagios, "nagios", false, "print one monitoring plugin line (OK/WARNING/CRITICAL/UNKNOWN with perfdata) and exit 0-3")
+	fs.DurationVar(&critAge, "crit-age", 0, "with -nagios, a last success older than this is CRITICAL (0 disables)")
+	fs.DurationVar(&watch, "watch", 0, "re-check on this interval forever, rewriting -write (requires -write)")
+	addColorFlag(fs, &colorMode)
+	addOutputFlag(fs, &output)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("healthz does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if watch < 0 || (watch > 0 && writePath == "") {
+		return 2, fmt.Errorf("-watch requires a positive interval and -write")
+	}
+	if err := checkOutputFormat(output); err != nil {
+		return 2, err
+	}
+	if output != outputText && watch > 0 {
+		return 2, fmt.Errorf("-o %s cannot be combined with -watch", output)
+	}
+	if nagios && (output != outputText || watch > 0 || writePath != "") {
+		return 2, fmt.Errorf("-nagios cannot be combined with -o, -watch, or -write")
+	}
+	colors, err := newPalette(colorMode, stdout)
+	if err != nil {
+		return 2, err
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	if nagios {
+		// Monitoring plugins report every failure through the line and exit
+		// code, including decomk's own (UNKNOWN).
+		code, line := nagiosCheck(home, maxAge, critAge, !skipPending, time.Now())
+		if err := writeLine(stdout, line); err != nil {
+			return nagiosUnknown, err
+		}
+		return code, nil
+	}
+
+	for {
+		result := newStatusResult(home, maxAge, !skipPending)
+		status, healthy := result.Status, result.Healthy
+		if output != outputText {
+			if err := writeStructured(stdout, output, result); err != nil {
+				return 1, err
+			}
+		} else {
+			colored := colors.green(status)
+			if !healthy {
+				colored = colors.red(status)
+			}
+			if err := writeLine(stdout, colored); err != nil {
+				return 1, err
+			}
+		}
+		if result.LastRun != nil && result.Pending != nil {
+			if err := refreshStatusFile(state.StatusPath(home), result.LastRun, *result.Pending, time.Now()); err != nil {
+				if warnErr := writeLine(stderr, "decomk: warning:", err.Error()); warnErr != nil {
+					return 1, warnErr
+				}
+			}
+		}
+		if writePath != "" {
+			if err := state.AtomicWrite(writePath, []byte(status+"\n"), 0o644); err != nil {
+				return 1, fmt.Errorf("write health status %s: %w", writePath, err)
+			}
+		}
+		if watch == 0 {
+			if !healthy {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		time.Sleep(watch)
+	}
+}
+
+// checkHealth evaluates the run record at path and returns a one-line status
+// starting with "healthy:" or "unhealthy:".
+func checkHealth(path string, maxAge time.Duration, checkPending bool, now time.Time) (string, bool) {
+	status, healthy, _ := evaluateHealth(path, maxAge, checkPending, now)
+	return status, healthy
+}
+
+// evaluateHealth is checkHealth that also reports whether targets are
+// pending: true after a failed run or when make -q finds work, false when it
+// finds none, and nil when that was not checked.
+func evaluateHealth(path string, maxAge time.Duration, checkPending bool, now time.Time) (status string, healthy bool, pending *bool) {
+	record, err := readLastRun(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "unhealthy: no recorded run (" + path + ")", false, nil
+		}
+		return "unhealthy: " + err.Error(), false, nil
+	}
+	finished := record.FinishedAt.UTC().Format(time.RFC3339)
+	if record.ExitCode != 0 {
+		status := fmt.Sprintf("unhealthy: last run failed (exit %d) at %s", record.ExitCode, finished)
+		if record.LogPath != "" {
+			status += "; log: " + record.LogPath
+		}
+		failed := true
+		return status, false, &failed
+	}
+	if maxAge > 0 && now.Sub(record.FinishedAt) > maxAge {
+		return fmt.Sprintf("unhealthy: last successful run at %s is older than %s", finished, maxAge), false, nil
+	}
+	if checkPending {
+		pending, err := makeTargetsPending(record)
+		if err != nil {
+			return "unhealthy: " + err.Error(), false, nil
+		}
+		if pending {
+			return "unhealthy: targets pending (make -q): " + strings.Join(record.Targets, " "), false, &pending
+		}
+		return "healthy: last run succeeded at " + finished, true, &pending
+	}
+	return "healthy: last run succeeded at " + finished, true, nil
+}
+
+// makeTargetsPending replays the recorded make arguments with -q, which exits
+// 1 when any target is out of date. Recipe lines marked `+` still run under
+// -q, as with any make invocation.
+func makeTargetsPending(record *lastRun) (bool, error) {
+	return makeQuestion(record.StampDir, record.MakeArgs, nil)
+}
+
+// makeQuestion runs make -q with args in dir, under env (or decomk's own
+// environment when env is nil), and reports whether any target is out of
+// date.
+func makeQuestion(dir string, args, env []string) (bool, error) {
+	cmd := exec.Command("make", append([]string{"-q"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return false, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, nil
+	}
+	return false, fmt.Errorf("make -q: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestLastRunMakeArgs_DropsRunTmp(t *testing.T) {
+	t.Parallel()
+
+	got := lastRunMakeArgs([]string{"/conf/Makefile"}, []string{"FOO=bar", runTmpVar + "=/var/decomk/tmp/run-1"}, []string{"Block00"})
+	want := []string{"-f", "/conf/Makefile", "FOO=bar", "Block00"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("lastRunMakeArgs(): got %q want %q", got, want)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	stampDir := t.TempDir()
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(makefilePath, []byte("Block00:\n\ttouch $@\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	now := time.Now()
+	record := lastRun{
+		FinishedAt: now.Add(-time.Hour),
+		Targets:    []string{"Block00"},
+		StampDir:   stampDir,
+		MakeArgs:   lastRunMakeArgs([]string{makefilePath}, []string{"FOO=bar"}, []string{"Block00"}),
+	}
+	path := filepath.Join(t.TempDir(), "last-run.json")
+
+	status, healthy := checkHealth(path, time.Hour, true, now)
+	if healthy || !strings.HasPrefix(status, "unhealthy: no recorded run") {
+		t.Fatalf("checkHealth(missing): got %q, %v", status, healthy)
+	}
+
+	if err := writeLastRun(path, record); err != nil {
+		t.Fatalf("writeLastRun(): %v", err)
+	}
+	status, healthy = checkHealth(path, 2*time.Hour, true, now)
+	if healthy || status != "unhealthy: targets pending (make -q): Block00" {
+		t.Fatalf("checkHealth(pending): got %q, %v", status, healthy)
+	}
+	if err := os.WriteFile(filepath.Join(stampDir, "Block00"), nil, 0o644); err != nil {
+		t.Fatalf("WriteFile(stamp): %v", err)
+	}
+	status, healthy = checkHealth(path, 2*time.Hour, true, now)
+	if !healthy || !strings.HasPrefix(status, "healthy: last run succeeded at ") {
+		t.Fatalf("checkHealth(converged): got %q, %v", status, healthy)
+	}
+	status, healthy = checkHealth(path, 30*time.Minute, true, now)
+	if healthy || !strings.Contains(status, "is older than 30m0s") {
+		t.Fatalf("checkHealth(stale): got %q, %v", status, healthy)
+	}
+
+	record.ExitCode = 2
+	record.LogPath = "/var/log/decomk/run/make.log"
+	if err := writeLastRun(path, record); err != nil {
+		t.Fatalf("writeLastRun(): %v", err)
+	}
+	status, healthy = checkHealth(path, 0, false, now)
+	if healthy || !strings.Contains(status, "last run failed (exit 2)") || !strings.HasSuffix(status, "; log: /var/log/decomk/run/make.log") {
+		t.Fatalf("checkHealth(failed): got %q, %v", status, healthy)
+	}
+}
+
+func TestCmdHealthz_WritesStatusFile(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	if err := writeLastRun(state.LastRunPath(home), lastRun{FinishedAt: time.Now(), StampDir: t.TempDir()}); err != nil {
+		t.Fatalf("writeLastRun(): %v", err)
+	}
+	statusPath := filepath.Join(t.TempDir(), "healthz")
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdHealthz([]string{"-home", home, "-skip-pending", "-write", statusPath}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdHealthz() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdHealthz() code: got %d want 0 (stdout=%q)", code, stdout.String())
+	}
+	content, err := os.ReadFile(statusPath)
+	if err != nil {
+		t.Fatalf("ReadFile(statusPath): %v", err)
+	}
+	if got := string(content); got != stdout.String() || !strings.HasPrefix(got, "healthy: ") {
+		t.Fatalf("status file: got %q want stdout %q", got, stdout.String())
+	}
+
+	code, err = cmdHealthz([]string{"-home", t.TempDir()}, &stdout, &stderr)
+	if err != nil || code != 1 {
+		t.Fatalf("cmdHealthz(no record): got code %d err %v want 1 nil", code, err)
+	}
+	if code, err := cmdHealthz([]string{"-home", home, "-watch", "1s"}, &stdout, &stderr); code != 2 || err == nil {
+		t.Fatalf("cmdHealthz(-watch without -write): got code %d err %v want 2 non-nil", code, err)
+	}
+
+	stdout.Reset()
+	code, err = cmdHealthz([]string{"-home", home, "-skip-pending", "-o", "yaml"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdHealthz(-o yaml): got code %d err %v want 0 nil", code, err)
+	}
+	if got := stdout.String(); !strings.HasPrefix(got, "healthy: true\nstatus: \"healthy: ") || !strings.Contains(got, "lastRun:\n  finishedAt: ") {
+		t.Fatalf("cmdHealthz(-o yaml): got %q", got)
+	}
+	if code, err := cmdHealthz([]string{"-home", home, "-o", "json", "-write", statusPath, "-watch", "1s"}, &stdout, &stderr); code != 2 || err == nil {
+		t.Fatalf("cmdHealthz(-o json -watch): got code %d err %v want 2 non-nil", code, err)
+	}
+}
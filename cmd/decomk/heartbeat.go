@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// heartbeatTuple is how long make may be silent before decomk reports
+	// that it is still running, and how often it repeats the report.
+	heartbeatTuple = "DECOMK_HEARTBEAT"
+	// stallTuple is how long make may be silent before decomk reports a
+	// stall and runs stallActionTuple.
+	stallTuple = "DECOMK_STALL"
+	// stallActionTuple is a sh -c command run once per stall.
+	stallActionTuple = "DECOMK_STALL_ACTION"
+	// defaultHeartbeat applies when heartbeatTuple is unset.
+	defaultHeartbeat = 60 * time.Second
+)
+
+// heartbeatPolicy is when decomk reports silent make output.
+type heartbeatPolicy struct {
+	// Interval is the silence before, and between, still-running notices;
+	// zero disables them.
+	Interval time.Duration
+	// Stall is the silence that counts as a stall; zero disables detection.
+	Stall time.Duration
+	// Action, when set, is run with sh -c on each stall.
+	Action string
+}
+
+// parseHeartbeatPolicy reads DECOMK_HEARTBEAT, DECOMK_STALL, and
+// DECOMK_STALL_ACTION from tuples.
+func parseHeartbeatPolicy(tuples []string) (heartbeatPolicy, error) {
+	policy := heartbeatPolicy{Interval: defaultHeartbeat}
+	values := effectiveTupleValues(tuples)
+	for _, setting := range []struct {
+		name string
+		dst  *time.Duration
+	}{{heartbeatTuple, &policy.Interval}, {stallTuple, &policy.Stall}} {
+		raw, ok := values[setting.name]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil || d < 0 {
+			return policy, fmt.Errorf("invalid %s %q (expected a non-negative duration such as 30s or 5m)", setting.name, raw)
+		}
+		*setting.dst = d
+	}
+	policy.Action = strings.TrimSpace(values[stallActionTuple])
+	if policy.Action != "" && policy.Stall == 0 {
+		return policy, fmt.Errorf("%s requires %s", stallActionTuple, stallTuple)
+	}
+	return policy, nil
+}
+
+// enabled reports whether the policy watches output at all.
+func (p heartbeatPolicy) enabled() bool { return p.Interval > 0 || p.Stall > 0 }
+
+// tick is how often a watcher checks for silence: the shorter of the
+// enabled thresholds, capped so short thresholds are still met promptly.
+func (p heartbeatPolicy) tick() time.Duration {
+	tick := time.Second
+	for _, d := range []time.Duration{p.Interval, p.Stall} {
+		if d > 0 && d < tick {
+			tick = d
+		}
+	}
+	return tick
+}
+
+// outputWatch serializes the writers it wraps and records when any of them
+// was last written.
+type outputWatch struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// watchedWriter is one stream of an outputWatch.
+type watchedWriter struct {
+	watch *outputWatch
+	w     io.Writer
+}
+
+func (w watchedWriter) Write(p []byte) (int, error) {
+	w.watch.mu.Lock()
+	defer w.watch.mu.Unlock()
+	w.watch.last = time.Now()
+	return w.w.Write(p)
+}
+
+// runWatched calls run with stdout and stderr wrapped to track output, and
+// while it runs reports silences per policy: a "still running" notice after
+// each policy.Interval without output, and a stall notice (plus
+// policy.Action) once per silence of policy.Stall. Notices go to notify,
+// which is called while no wrapped writer is writing, so it may write to the
+// same streams. label names what is running.
+//
+// Intent: Make make invocations that hang silently (for example on an
+// interactive prompt) visible in lifecycle-hook logs, which otherwise show
+// nothing until the container start times out.
+// Source: DI-momat (TODO-jirin)
+func runWatched(policy heartbeatPolicy, label string, notify func(string) error, stdout, stderr io.Writer, run func(stdout, stderr io.Writer) (int, error)) (int, error) {
+	if !policy.enabled() {
+		return run(stdout, stderr)
+	}
+	watch := &outputWatch{last: time.Now()}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- watchSilence(policy, label, watch, notify, stop)
+	}()
+	exitCode, err := run(watchedWriter{watch, stdout}, watchedWriter{watch, stderr})
+	close(stop)
+	if notifyErr := <-done; notifyErr != nil {
+		if err == nil && exitCode == 0 {
+			exitCode = 1
+		}
+		return exitCode, errors.Join(err, notifyErr)
+	}
+	return exitCode, err
+}
+
+// watchSilence reports silences on watch until stop is closed, returning the
+// first notify error.
+func watchSilence(policy heartbeatPolicy, label string, watch *outputWatch, notify func(string) error, stop <-chan struct{}) error {
+	ticker := time.NewTicker(policy.tick())
+	defer ticker.Stop()
+	var lastNotice, stalledAt time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case now := <-ticker.C:
+			watch.mu.Lock()
+			last := watch.last
+			silent := now.Sub(last)
+			var notices []string
+			since := last
+			if lastNotice.After(since) {
+				since = lastNotice
+			}
+			if policy.Interval > 0 && now.Sub(since) >= policy.Interval {
+				notices = append(notices, fmt.Sprintf("decomk: still running %s, %s since last output", label, formatSilence(silent)))
+				lastNotice = now
+			}
+			// One stall per silence: output since the last stall rearms it.
+			stalled := policy.Stall > 0 && silent >= policy.Stall && stalledAt.Before(last)
+			if stalled {
+				notices = append(notices, fmt.Sprintf("decomk: warning: %s stalled: no output for %s", label, formatSilence(silent)))
+				stalledAt = now
+			}
+			err := notifyAll(notify, notices)
+			watch.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			if !stalled || policy.Action == "" {
+				continue
+			}
+			// The action runs unlocked so make output is not held up.
+			notices = stallAction(policy.Action, label, silent)
+			watch.mu.Lock()
+			err = notifyAll(notify, notices)
+			watch.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// notifyAll passes each notice to notify, stopping at the first error.
+func notifyAll(notify func(string) error, notices []string) error {
+	for _, notice := range notices {
+		if err := notify(notice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stallAction runs action with sh -c, passing the stalled label and silence
+// as DECOMK_STALL_TARGET and DECOMK_STALL_SECONDS, and returns notices for
+// its output and any failure.
+func stallAction(action, label string, silent time.Duration) []string {
+	cmd := exec.Command("sh", "-c", action)
+	cmd.Env = append(os.Environ(),
+		"DECOMK_STALL_TARGET="+label,
+		"DECOMK_STALL_SECONDS="+strconv.Itoa(int(silent.Seconds())),
+	)
+	output, err := cmd.CombinedOutput()
+	var notices []string
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if line != "" {
+			notices = append(notices, "decomk: stall action: "+line)
+		}
+	}
+	if err != nil {
+		notices = append(notices, fmt.Sprintf("decomk: warning: %s failed: %v", stallActionTuple, err))
+	}
+	return notices
+}
+
+// formatSilence renders d in whole seconds, as heartbeat notices report it.
+func formatSilence(d time.Duration) string {
+	return d.Truncate(time.Second).String()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHeartbeatPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := parseHeartbeatPolicy(nil)
+	if err != nil || policy != (heartbeatPolicy{Interval: defaultHeartbeat}) {
+		t.Fatalf("parseHeartbeatPolicy(nil): got %+v, %v want the defaults", policy, err)
+	}
+
+	policy, err = parseHeartbeatPolicy([]string{"DECOMK_HEARTBEAT=0", "DECOMK_STALL=5m", "DECOMK_STALL_ACTION=ps -ef"})
+	if err != nil {
+		t.Fatalf("parseHeartbeatPolicy(): %v", err)
+	}
+	if want := (heartbeatPolicy{Stall: 5 * time.Minute, Action: "ps -ef"}); policy != want {
+		t.Fatalf("parseHeartbeatPolicy(): got %+v want %+v", policy, want)
+	}
+
+	for _, tuples := range [][]string{{"DECOMK_HEARTBEAT=soon"}, {"DECOMK_STALL=-1s"}, {"DECOMK_STALL_ACTION=ps"}} {
+		if _, err := parseHeartbeatPolicy(tuples); err == nil {
+			t.Fatalf("parseHeartbeatPolicy(%q): got nil want error", tuples)
+		}
+	}
+}
+
+func TestRunWatched_ReportsSilenceAndStall(t *testing.T) {
+	t.Parallel()
+
+	var notices bytes.Buffer
+	notify := func(text string) error { return writeLine(&notices, text) }
+	policy := heartbeatPolicy{Interval: 20 * time.Millisecond, Stall: 60 * time.Millisecond, Action: `echo "$DECOMK_STALL_TARGET"`}
+	var stdout bytes.Buffer
+	code, err := runWatched(policy, "tools", notify, &stdout, io.Discard, func(stdout, stderr io.Writer) (int, error) {
+		if _, err := io.WriteString(stdout, "working\n"); err != nil {
+			return 1, err
+		}
+		time.Sleep(200 * time.Millisecond)
+		return 0, nil
+	})
+	if code != 0 || err != nil {
+		t.Fatalf("runWatched(): got %d, %v want 0, nil", code, err)
+	}
+	if got := stdout.String(); got != "working\n" {
+		t.Fatalf("stdout: got %q want %q", got, "working\n")
+	}
+	got := notices.String()
+	for _, want := range []string{"decomk: still running tools, ", "decomk: warning: tools stalled: no output for ", "decomk: stall action: tools\n"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("notices: got %q want it to contain %q", got, want)
+		}
+	}
+	if n := strings.Count(got, "stalled"); n != 1 {
+		t.Fatalf("notices: got %d stall warnings want 1 per silence\n%s", n, got)
+	}
+}
+
+func TestRunWatched_QuietWhileOutputFlows(t *testing.T) {
+	t.Parallel()
+
+	var notices bytes.Buffer
+	notify := func(text string) error { return writeLine(&notices, text) }
+	policy := heartbeatPolicy{Interval: 100 * time.Millisecond, Stall: 100 * time.Millisecond}
+	_, err := runWatched(policy, "tools", notify, io.Discard, io.Discard, func(stdout, stderr io.Writer) (int, error) {
+		for range 10 {
+			if _, err := io.WriteString(stderr, "."); err != nil {
+				return 1, err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return 0, nil
+	})
+	if err != nil {
+		t.Fatalf("runWatched(): %v", err)
+	}
+	if got := notices.String(); got != "" {
+		t.Fatalf("notices: got %q want none", got)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// defaultHistoryCount is how many runs `decomk history` lists by default.
+const defaultHistoryCount = 10
+
+// cmdHistory lists the most recent runs from the timing history, newest
+// first.
+//
+// It reads the append-only timings file and takes no lock, so it never waits
+// for a run in progress (see cmdStatus).
+func cmdHistory(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk history", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag, output string
+	var count int
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	fs.IntVar(&count, "n", defaultHistoryCount, "number of runs to list (0 for all)")
+	addOutputFlag(fs, &output)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("history does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if count < 0 {
+		return 2, fmt.Errorf("invalid -n %d (must be >= 0)", count)
+	}
+	if err := checkOutputFormat(output); err != nil {
+		return 2, err
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	records, err := readTimingRecords(state.TimingsPath(home))
+	if err != nil && !os.IsNotExist(err) {
+		return 1, err
+	}
+	runs := recentRuns(records, count)
+	if output != outputText {
+		if err := writeStructured(stdout, output, runs); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	for _, run := range runs {
+		if err := writeLine(stdout, formatHistoryLine(run)); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// recentRuns returns the last count records (all when count is 0), newest
+// first.
+func recentRuns(records []timingRecord, count int) []timingRecord {
+	if count > 0 && len(records) > count {
+		records = records[len(records)-count:]
+	}
+	runs := make([]timingRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		runs = append(runs, records[i])
+	}
+	return runs
+}
+
+// formatHistoryLine renders one run: finish time, result, duration, target
+// counts when the run timed targets individually, and resource usage when it
+// was recorded.
+func formatHistoryLine(run timingRecord) string {
+	result := "ok"
+	if run.ExitCode != 0 {
+		result = fmt.Sprintf("failed (exit %d)", run.ExitCode)
+	}
+	line := fmt.Sprintf("%s  %s  %s", run.FinishedAt.UTC().Format(time.RFC3339), formatSeconds(run.TotalSeconds), result)
+	if len(run.Targets) > 0 {
+		counts := map[string]int{}
+		for _, target := range run.Targets {
+			counts[target.Status]++
+		}
+		line = fmt.Sprintf("%s  targets: %d ok, %d failed, %d skipped", line, counts[timingStatusOK], counts[timingStatusFailed], counts[timingStatusSkipped])
+	}
+	if u := run.Usage; u != nil {
+		line = fmt.Sprintf("%s  cpu %s, peak rss %s, written %s", line, formatSeconds(u.UserSeconds+u.SystemSeconds), humanBytes(u.PeakRSSBytes), humanBytes(u.WrittenBytes))
+	}
+	return line
+}
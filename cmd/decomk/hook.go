@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// autoUpdatedEnv marks a process that auto-update re-exec'd into a freshly
+// built binary, so hook presets do not update a second time.
+const autoUpdatedEnv = "DECOMK_AUTO_UPDATED"
+
+// hookPreset is the behavior `decomk hook` encodes for one devcontainer
+// lifecycle phase.
+type hookPreset struct {
+	// Phase is exported as DECOMK_STAGE0_PHASE; empty leaves it unchanged.
+	Phase string
+	// ActionArgs are the run's default action args; nil means the preset
+	// reports status instead of running make.
+	ActionArgs []string
+	AutoUpdate bool
+	Summary    string
+}
+
+// hookPresets maps devcontainer lifecycle hook names to presets.
+var hookPresets = map[string]hookPreset{
+	"update-content": {
+		Phase:      "updateContent",
+		ActionArgs: []string{"updateContent"},
+		AutoUpdate: true,
+		Summary:    "self-update, then run the updateContent action (prebuild/checkpoint setup)",
+	},
+	"post-create": {
+		Phase:      "postCreate",
+		ActionArgs: []string{"postCreate"},
+		AutoUpdate: true,
+		Summary:    "self-update, then run the postCreate action (full provisioning)",
+	},
+	"post-start": {
+		ActionArgs: []string{"postCreate"},
+		Summary:    "re-run the postCreate action without self-update; stamps make it a no-op unless something was invalidated",
+	},
+	"post-attach": {
+		Summary: "print the healthz status (no make run); never fails the attach",
+	},
+}
+
+// cmdHook runs the preset for one devcontainer lifecycle phase.
+//
+// Extra flags and args pass through to `decomk run`; explicit ARGS replace the
+// preset's action.
+//
+// Intent: Replace copy-pasted flag lists in devcontainer.json with one command
+// per lifecycle phase that encodes sensible behavior.
+// Source: DI-luvum (TODO-jirin)
+func cmdHook(args []string, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		return 2, fmt.Errorf("hook phase required\n\n%s", hookUsage())
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		if err := writeLine(stdout, hookUsage()); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	name := args[0]
+	preset, ok := hookPresets[name]
+	if !ok {
+		return 2, fmt.Errorf("unknown hook phase: %s\n\n%s", name, hookUsage())
+	}
+
+	if preset.ActionArgs == nil {
+		return hookStatus(args[1:], stdout)
+	}
+
+	if preset.Phase != "" {
+		if err := os.Setenv("DECOMK_STAGE0_PHASE", preset.Phase); err != nil {
+			return 1, fmt.Errorf("set DECOMK_STAGE0_PHASE: %w", err)
+		}
+	}
+	var runArgs []string
+	if preset.AutoUpdate && os.Getenv(autoUpdatedEnv) == "" {
+		runArgs = append(runArgs, "-auto-update")
+	}
+	runArgs = append(runArgs, args[1:]...)
+	mode := execModeRun
+	mode.Name = "hook " + name
+	mode.DefaultActionArgs = preset.ActionArgs
+	return cmdExecute(runArgs, stdout, stderr, mode)
+}
+
+// hookStatus prints the healthz status line without failing: attach hooks
+// should inform, not block the editor from connecting.
+func hookStatus(args []string, stdout io.Writer) (int, error) {
+	if len(args) != 0 {
+		return 2, fmt.Errorf("hook post-attach does not accept args: %q", strings.Join(args, " "))
+	}
+	home, err := state.Home("")
+	if err != nil {
+		return 1, err
+	}
+	status, _ := checkHealth(state.LastRunPath(home), 0, true, time.Now())
+	if err := writeLine(stdout, "decomk:", status); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func hookUsage() string {
+	names := make([]string, 0, len(hookPresets))
+	for name := range hookPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(`decomk hook - run the preset for a devcontainer lifecycle phase
+
+Usage:
+  decomk hook PHASE [run flags] [ARGS...]
+
+Phases:
+`)
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-15s %s\n", name, hookPresets[name].Summary)
+	}
+	b.WriteString(`
+Run flags and ARGS pass through to decomk run; ARGS replace the preset action.
+Presets that run make must execute as root, like decomk run.
+`)
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestCmdHook_UsageAndUnknownPhase(t *testing.T) {
+	t.Parallel()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdHook([]string{"help"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdHook(help): got code %d err %v want 0 nil", code, err)
+	}
+	for name := range hookPresets {
+		if !strings.Contains(stdout.String(), "  "+name+" ") {
+			t.Fatalf("cmdHook(help) stdout missing %q:\n%s", name, stdout.String())
+		}
+	}
+
+	code, err = cmdHook([]string{"pre-destroy"}, &stdout, &stderr)
+	if code != 2 || err == nil || !strings.Contains(err.Error(), "unknown hook phase: pre-destroy") {
+		t.Fatalf("cmdHook(pre-destroy): got code %d err %v want 2 unknown hook phase", code, err)
+	}
+}
+
+func TestCmdHook_PostAttachReportsWithoutFailing(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("DECOMK_HOME", home)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdHook([]string{"post-attach"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdHook(post-attach): got code %d err %v want 0 nil", code, err)
+	}
+	if got, want := stdout.String(), "decomk: unhealthy: no recorded run"; !strings.HasPrefix(got, want) {
+		t.Fatalf("cmdHook(post-attach) stdout: got %q want prefix %q", got, want)
+	}
+}
+
+func TestCmdHook_PostStartRunsDefaultAction(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("hook presets run decomk run, which requires root")
+	}
+
+	origWD, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	t.Cleanup(func() {
+		if cleanupErr := os.Chdir(origWD); cleanupErr != nil {
+			t.Errorf("cleanup Chdir(origWD): %v", cleanupErr)
+		}
+	})
+
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT:\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("postCreate:\n\ttouch $@\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	args := []string{
+		"post-start",
+		"-C", origWD,
+		"-home", home,
+		"-log-dir", filepath.Join(t.TempDir(), "logs"),
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdHook(args, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdHook(post-start) error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdHook(post-start) code: got %d want 0", code)
+	}
+	if _, err := os.Stat(filepath.Join(state.StampDir(home), "postCreate")); err != nil {
+		t.Fatalf("Stat(postCreate stamp): %v", err)
+	}
+	if strings.Contains(stdout.String(), "auto-update") {
+		t.Fatalf("cmdHook(post-start) stdout mentions auto-update:\n%s", stdout.String())
+	}
+}
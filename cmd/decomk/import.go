@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/stage0"
+)
+
+const (
+	importSourceIsconf = "isconf"
+
+	// importIsconfDefaultActions are the action tuples rc.isconf conventionally
+	// invokes; their values seed the Makefile skeleton.
+	importIsconfDefaultActions = "INSTALL,BOOT,CRON"
+
+	// importWrapWidth is the soft line width for rendered decomk.conf stanzas.
+	importWrapWidth = 78
+)
+
+var (
+	// isconfKeyLinePattern matches expandmacro.pl's key-line rule: `^(\S+):(.*)`.
+	isconfKeyLinePattern = regexp.MustCompile(`^(\S+):(.*)$`)
+
+	// isconfTokenPattern matches one parseargs.pl token: a single-quoted tuple,
+	// an unquoted tuple, or a bare macro word.
+	isconfTokenPattern = regexp.MustCompile(`^(?:(\w+)='([^']*)'|(\w+)=(\S*)|(\S+))`)
+
+	// makeRulePattern matches a make rule line "targets: prerequisites".
+	makeRulePattern = regexp.MustCompile(`^([^\s:=#][^:=]*?)\s*::?\s*(.*)$`)
+)
+
+type importIsconfFlags struct {
+	outDir  string
+	actions string
+	force   bool
+}
+
+// isconfStanza is one hosts.conf key with its joined token list.
+type isconfStanza struct {
+	key    string
+	tokens []isconfToken
+}
+
+// isconfToken is one parsed hosts.conf RHS token.
+type isconfToken struct {
+	// name/value are set for tuples; word is set for bare macro references.
+	name  string
+	value string
+	word  string
+}
+
+// isconfItem is one hosts.conf element in file order: a comment/blank line or
+// a stanza.
+type isconfItem struct {
+	comment string
+	stanza  *isconfStanza
+}
+
+// cmdImport dispatches `decomk import <source>` subcommands.
+//
+// Intent: Give shops migrating from isconf a mechanical first conversion of
+// their hosts.conf/makefile tree, keeping the macro structure intact, instead
+// of hand-porting every stanza.
+// Source: DI-hizih (TODO-jirin)
+func cmdImport(args []string, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		return 2, fmt.Errorf("import source required\n\n%s", importUsage())
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		if err := writeLine(stdout, importUsage()); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	case importSourceIsconf:
+		return cmdImportIsconf(args[1:], stdout, stderr)
+	default:
+		return 2, fmt.Errorf("unknown import source: %s\n\n%s", args[0], importUsage())
+	}
+}
+
+func importUsage() string {
+	return `decomk import - convert configuration from other tools
+
+Usage:
+  decomk import isconf [flags] <isconf-dir>
+
+Sources:
+  isconf
+      Convert an isconf tree (<isconf-dir>/conf/hosts.conf and conf/*.mk, or a
+      conf directory itself) into decomk.conf contexts and a stamp-style
+      Makefile skeleton. Macro names, tuples, and stanza order are preserved.
+      Flags:
+        -out <dir>          output directory for decomk.conf and Makefile (default ".")
+        -actions <list>     comma-separated action tuples whose targets seed the Makefile skeleton (default "INSTALL,BOOT,CRON")
+        -f, -force          overwrite existing output files
+`
+}
+
+// cmdImportIsconf converts an isconf tree into decomk.conf plus a Makefile
+// skeleton.
+func cmdImportIsconf(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk import isconf", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	f := importIsconfFlags{outDir: ".", actions: importIsconfDefaultActions}
+	fs.StringVar(&f.outDir, "out", f.outDir, "output directory for decomk.conf and Makefile")
+	fs.StringVar(&f.actions, "actions", f.actions, "comma-separated action tuples whose targets seed the Makefile skeleton")
+	fs.BoolVar(&f.force, "force", false, "overwrite existing output files")
+	fs.BoolVar(&f.force, "f", false, "overwrite existing output files (shorthand)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 1 {
+		return 2, fmt.Errorf("import isconf requires exactly one <isconf-dir> argument\n\n%s", importUsage())
+	}
+
+	confDir, err := findIsconfConfDir(fs.Arg(0))
+	if err != nil {
+		return 1, err
+	}
+	hostsPath := filepath.Join(confDir, "hosts.conf")
+	hostsContent, err := os.ReadFile(hostsPath)
+	if err != nil {
+		return 1, fmt.Errorf("read %s: %w", hostsPath, err)
+	}
+	items, err := parseIsconfHosts(hostsContent)
+	if err != nil {
+		return 1, fmt.Errorf("%s: %w", hostsPath, err)
+	}
+
+	mkPaths, err := filepath.Glob(filepath.Join(confDir, "*.mk"))
+	if err != nil {
+		return 1, fmt.Errorf("glob isconf makefiles: %w", err)
+	}
+	sort.Strings(mkPaths)
+	rules := make(map[string][]string)
+	var mkNames []string
+	for _, path := range mkPaths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return 1, fmt.Errorf("read %s: %w", path, err)
+		}
+		parseMakeRules(content, rules)
+		mkNames = append(mkNames, filepath.Base(path))
+	}
+
+	confText := renderIsconfDecomkConf(hostsPath, items)
+	// Guard against rendering bugs: the output must be valid decomk.conf syntax.
+	if _, err := contexts.Parse(bytes.NewReader(confText)); err != nil {
+		return 1, fmt.Errorf("internal error: rendered decomk.conf does not parse: %w", err)
+	}
+	targets := isconfActionTargets(items, splitCommaList(f.actions))
+	makefileText := renderIsconfMakefileSkeleton(mkNames, targets, rules)
+
+	outConf := filepath.Join(f.outDir, "decomk.conf")
+	outMakefile := filepath.Join(f.outDir, "Makefile")
+	if !f.force {
+		var existing []string
+		for _, path := range []string{outConf, outMakefile} {
+			if fileExists(path) {
+				existing = append(existing, path)
+			}
+		}
+		if len(existing) > 0 {
+			return 1, fmt.Errorf("refusing to overwrite existing file(s) without -f/-force: %s", strings.Join(existing, ", "))
+		}
+	}
+	if err := os.MkdirAll(f.outDir, 0o755); err != nil {
+		return 1, fmt.Errorf("create output dir %s: %w", f.outDir, err)
+	}
+	for _, out := range []struct {
+		path    string
+		content []byte
+	}{
+		{path: outConf, content: confText},
+		{path: outMakefile, content: makefileText},
+	} {
+		if err := stage0.WriteFileAtomic(out.path, out.content, 0o644); err != nil {
+			return 1, fmt.Errorf("write %s: %w", out.path, err)
+		}
+		if err := writeFormat(stdout, "wrote %s\n", out.path); err != nil {
+			return 1, err
+		}
+	}
+
+	for _, warning := range isconfImportWarnings(items, targets, rules) {
+		if err := writeLine(stderr, "warning:", warning); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// findIsconfConfDir returns the directory holding hosts.conf: either
+// <dir>/conf (an ISCONFDIR) or dir itself.
+func findIsconfConfDir(dir string) (string, error) {
+	candidates := []string{filepath.Join(dir, "conf"), dir}
+	for _, candidate := range candidates {
+		if fileExists(filepath.Join(candidate, "hosts.conf")) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no isconf hosts.conf found; tried %s, %s", filepath.Join(candidates[0], "hosts.conf"), filepath.Join(candidates[1], "hosts.conf"))
+}
+
+// parseIsconfHosts parses hosts.conf with isconf's own rules (expandmacro.pl
+// for lines, parseargs.pl for tokens), keeping comments in file order.
+//
+// Comment lines inside a stanza's continuation block are kept, but are
+// emitted after the stanza because decomk.conf stanzas are re-rendered.
+func parseIsconfHosts(content []byte) ([]isconfItem, error) {
+	var (
+		items   []isconfItem
+		current *isconfStanza
+		raw     strings.Builder
+	)
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		tokens, err := tokenizeIsconf(raw.String())
+		if err != nil {
+			return fmt.Errorf("key %q: %w", current.key, err)
+		}
+		current.tokens = tokens
+		current = nil
+		raw.Reset()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			items = append(items, isconfItem{comment: trimmed})
+			continue
+		}
+		if m := isconfKeyLinePattern.FindStringSubmatch(line); m != nil {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			key := m[1]
+			if strings.ContainsAny(key, ":=") {
+				return nil, fmt.Errorf("line %d: key %q cannot be represented in decomk.conf (contains ':' or '=')", lineNum, key)
+			}
+			current = &isconfStanza{key: key}
+			items = append(items, isconfItem{stanza: current})
+			raw.WriteString(m[2])
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: continuation line without a preceding key", lineNum)
+		}
+		raw.WriteString(" ")
+		raw.WriteString(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// tokenizeIsconf splits a joined stanza body into parseargs.pl-style tokens.
+func tokenizeIsconf(s string) ([]isconfToken, error) {
+	var tokens []isconfToken
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return tokens, nil
+		}
+		m := isconfTokenPattern.FindStringSubmatchIndex(s)
+		if m == nil {
+			return nil, fmt.Errorf("cannot tokenize %q", s)
+		}
+		group := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return s[m[2*i]:m[2*i+1]]
+		}
+		switch {
+		case m[2] >= 0:
+			tokens = append(tokens, isconfToken{name: group(1), value: group(2)})
+		case m[6] >= 0:
+			tokens = append(tokens, isconfToken{name: group(3), value: group(4)})
+		default:
+			word := group(5)
+			if strings.Contains(word, "'") {
+				return nil, fmt.Errorf("unterminated or misplaced quote in %q", word)
+			}
+			tokens = append(tokens, isconfToken{word: word})
+		}
+		s = s[m[1]:]
+	}
+}
+
+// renderIsconfDecomkConf renders parsed hosts.conf items as decomk.conf.
+func renderIsconfDecomkConf(hostsPath string, items []isconfItem) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Imported from isconf " + hostsPath + " by `decomk import isconf`.\n")
+	b.WriteString("#\n")
+	b.WriteString("# isconf selected stanzas by hostname; decomk selects them by workspace repo\n")
+	b.WriteString("# name (owner/repo or repo) or by -context/DECOMK_CONTEXT. Rename host\n")
+	b.WriteString("# stanzas or select them explicitly.\n\n")
+
+	var pendingComments []string
+	for _, item := range items {
+		if item.stanza == nil {
+			pendingComments = append(pendingComments, item.comment)
+			continue
+		}
+		for _, comment := range pendingComments {
+			b.WriteString(comment)
+			b.WriteString("\n")
+		}
+		pendingComments = nil
+		renderDecomkStanza(&b, item.stanza)
+	}
+	for _, comment := range pendingComments {
+		b.WriteString(comment)
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
+
+// renderDecomkStanza writes one stanza, wrapping long token lists onto
+// indented continuation lines.
+func renderDecomkStanza(b *bytes.Buffer, stanza *isconfStanza) {
+	line := stanza.key + ":"
+	onLine := 0
+	for _, token := range stanza.tokens {
+		rendered := token.decomkToken()
+		if onLine > 0 && len(line)+1+len(rendered) > importWrapWidth {
+			b.WriteString(line)
+			b.WriteString("\n")
+			line = " "
+			onLine = 0
+		}
+		line += " " + rendered
+		onLine++
+	}
+	b.WriteString(line)
+	b.WriteString("\n")
+}
+
+// decomkToken renders t using decomk.conf quoting rules.
+func (t isconfToken) decomkToken() string {
+	if t.word != "" {
+		return quoteDecomkWord(t.word)
+	}
+	return t.name + "=" + quoteDecomkWord(t.value)
+}
+
+// quoteDecomkWord single-quotes s when decomk.conf's tokenizer would otherwise
+// split or unescape it.
+func quoteDecomkWord(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(s, " \t'\\") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// isconfActionTargets collects make targets named by the given action tuples
+// across all stanzas, in first-seen order.
+func isconfActionTargets(items []isconfItem, actions []string) []string {
+	wanted := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		wanted[action] = true
+	}
+	var targets []string
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if item.stanza == nil {
+			continue
+		}
+		for _, token := range item.stanza.tokens {
+			if token.word != "" || !wanted[token.name] {
+				continue
+			}
+			for _, target := range strings.Fields(token.value) {
+				if !seen[target] {
+					seen[target] = true
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// parseMakeRules adds explicit "target: prerequisites" rules from content to
+// rules. It skips recipes, assignments, special targets, and anything built
+// from variables or patterns, since the skeleton only needs the static graph.
+func parseMakeRules(content []byte, rules map[string][]string) {
+	lines := strings.Split(strings.ReplaceAll(string(content), "\\\n", " "), "\n")
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "\t") || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if strings.Contains(line, ":=") || strings.Contains(line, "?=") || strings.Contains(line, "+=") {
+			continue
+		}
+		m := makeRulePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		prereqText := m[2]
+		if cut := strings.IndexAny(prereqText, ";#"); cut >= 0 {
+			prereqText = prereqText[:cut]
+		}
+		var prereqs []string
+		for _, word := range strings.Fields(prereqText) {
+			if word == "|" || strings.ContainsAny(word, "$%") {
+				continue
+			}
+			prereqs = append(prereqs, word)
+		}
+		for _, target := range strings.Fields(m[1]) {
+			if strings.HasPrefix(target, ".") || strings.ContainsAny(target, "$%") {
+				continue
+			}
+			rules[target] = append(rules[target], prereqs...)
+		}
+	}
+}
+
+// renderIsconfMakefileSkeleton renders a stamp-style Makefile for targets and
+// their static prerequisites (depth-first, first-seen order).
+//
+// Every recipe fails until it is ported, so an unported target can never leave
+// a stamp behind that would mark it as done.
+func renderIsconfMakefileSkeleton(mkNames, targets []string, rules map[string][]string) []byte {
+	var ordered []string
+	seen := make(map[string]bool)
+	var visit func(target string)
+	visit = func(target string) {
+		if seen[target] {
+			return
+		}
+		seen[target] = true
+		ordered = append(ordered, target)
+		for _, prereq := range rules[target] {
+			visit(prereq)
+		}
+	}
+	for _, target := range targets {
+		visit(target)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("# Stamp-style Makefile skeleton imported from isconf by `decomk import isconf`.\n")
+	if len(mkNames) > 0 {
+		b.WriteString("# Source makefiles: " + strings.Join(mkNames, ", ") + "\n")
+	}
+	b.WriteString("#\n")
+	b.WriteString("# decomk runs make in its stamp directory, so each target should end with\n")
+	b.WriteString("# `touch $@`. Every recipe below fails until its isconf recipe is ported.\n")
+	for _, target := range ordered {
+		b.WriteString("\n")
+		b.WriteString(target)
+		b.WriteString(":")
+		for _, prereq := range dedupeStrings(rules[target]) {
+			b.WriteString(" ")
+			b.WriteString(prereq)
+		}
+		b.WriteString("\n")
+		b.WriteString("\t@echo \"TODO: port isconf recipe for $@\" >&2\n")
+		b.WriteString("\t@exit 1\n")
+		b.WriteString("\ttouch $@\n")
+	}
+	return b.Bytes()
+}
+
+// isconfImportWarnings reports conversion issues that need a human decision.
+func isconfImportWarnings(items []isconfItem, targets []string, rules map[string][]string) []string {
+	keys := make(map[string]bool)
+	for _, item := range items {
+		if item.stanza != nil {
+			keys[item.stanza.key] = true
+		}
+	}
+	var warnings []string
+	for _, item := range items {
+		if item.stanza == nil {
+			continue
+		}
+		for _, token := range item.stanza.tokens {
+			if token.word != "" && !keys[token.word] {
+				warnings = append(warnings, fmt.Sprintf("key %q references undefined macro %q; decomk rejects bare RHS tokens that are not defined keys", item.stanza.key, token.word))
+			}
+		}
+	}
+	if len(targets) == 0 {
+		warnings = append(warnings, "no action tuple targets found; the Makefile skeleton is empty (see -actions)")
+	}
+	for _, target := range targets {
+		if _, ok := rules[target]; !ok {
+			warnings = append(warnings, fmt.Sprintf("target %q has no rule in the isconf makefiles", target))
+		}
+	}
+	return warnings
+}
+
+// splitCommaList splits a comma-separated flag value, dropping empty entries.
+func splitCommaList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// dedupeStrings returns values without repeats, keeping first occurrences.
+func dedupeStrings(values []string) []string {
+	var out []string
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
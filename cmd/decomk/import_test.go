@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/contexts"
+)
+
+func writeIsconfFixture(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	confDir := filepath.Join(root, "conf")
+	if err := os.MkdirAll(confDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll(conf): %v", err)
+	}
+	hosts := `# site policy
+DEFAULT: BOOT='Block12 mkusers' CRON=cron INSTALL=Block00_install
+    NTP_MASTER=n
+HQFT: DOMAIN=hq.example.com NTP_MASTER=y
+hqftms01: HQFT BOOT='Block12 mkusers ntpd'
+kirk:TNG_FRONT
+`
+	if err := os.WriteFile(filepath.Join(confDir, "hosts.conf"), []byte(hosts), 0o600); err != nil {
+		t.Fatalf("WriteFile(hosts.conf): %v", err)
+	}
+	mainMk := `OS := $(shell uname)
+include $(ISCONFDIR)/conf/$(OS).mk
+
+.PHONY: cron
+Block12: Block10 \
+	mkusers
+	touch $@
+
+Block10:
+	touch $@
+
+Block00_install: Block10 $(EXTRA)
+	touch $@
+`
+	if err := os.WriteFile(filepath.Join(confDir, "main.mk"), []byte(mainMk), 0o600); err != nil {
+		t.Fatalf("WriteFile(main.mk): %v", err)
+	}
+	return root
+}
+
+func TestCmdImportIsconf_ConvertsHostsAndMakefile(t *testing.T) {
+	t.Parallel()
+
+	src := writeIsconfFixture(t)
+	outDir := filepath.Join(t.TempDir(), "out")
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdImport([]string{"isconf", "-out", outDir, src}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdImport() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdImport() code: got %d want 0", code)
+	}
+
+	cfg, err := contexts.LoadFile(filepath.Join(outDir, "decomk.conf"))
+	if err != nil {
+		t.Fatalf("LoadFile(decomk.conf): %v", err)
+	}
+	wantDefs := contexts.Defs{
+		"DEFAULT":  {"BOOT=Block12 mkusers", "CRON=cron", "INSTALL=Block00_install", "NTP_MASTER=n"},
+		"HQFT":     {"DOMAIN=hq.example.com", "NTP_MASTER=y"},
+		"hqftms01": {"HQFT", "BOOT=Block12 mkusers ntpd"},
+		"kirk":     {"TNG_FRONT"},
+	}
+	if !reflect.DeepEqual(cfg.Defs, wantDefs) {
+		t.Fatalf("imported defs:\n got %#v\nwant %#v", cfg.Defs, wantDefs)
+	}
+
+	makefile, err := os.ReadFile(filepath.Join(outDir, "Makefile"))
+	if err != nil {
+		t.Fatalf("ReadFile(Makefile): %v", err)
+	}
+	got := string(makefile)
+	for _, needle := range []string{
+		"# Source makefiles: main.mk\n",
+		"\nBlock12: Block10 mkusers\n\t@echo \"TODO: port isconf recipe for $@\" >&2\n\t@exit 1\n\ttouch $@\n",
+		"\nBlock10:\n",
+		"\nmkusers:\n",
+		"\nntpd:\n",
+		"\ncron:\n",
+		"\nBlock00_install: Block10\n",
+	} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("Makefile missing %q:\n%s", needle, got)
+		}
+	}
+	if strings.Contains(got, "\nOS") || strings.Contains(got, ".PHONY") {
+		t.Fatalf("Makefile includes non-rule lines:\n%s", got)
+	}
+
+	warnings := stderr.String()
+	for _, needle := range []string{
+		`key "kirk" references undefined macro "TNG_FRONT"`,
+		`target "ntpd" has no rule in the isconf makefiles`,
+	} {
+		if !strings.Contains(warnings, needle) {
+			t.Fatalf("stderr missing %q:\n%s", needle, warnings)
+		}
+	}
+}
+
+func TestCmdImportIsconf_RefusesOverwriteWithoutForce(t *testing.T) {
+	t.Parallel()
+
+	src := writeIsconfFixture(t)
+	outDir := t.TempDir()
+	existing := filepath.Join(outDir, "Makefile")
+	if err := os.WriteFile(existing, []byte("keep\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(Makefile): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	_, err := cmdImport([]string{"isconf", "-out", outDir, src}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "refusing to overwrite") {
+		t.Fatalf("cmdImport() error: got %v want overwrite refusal", err)
+	}
+	if fileExists(filepath.Join(outDir, "decomk.conf")) {
+		t.Fatalf("decomk.conf written despite overwrite refusal")
+	}
+
+	code, err := cmdImport([]string{"isconf", "-f", "-out", outDir, src}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdImport(-f): code=%d err=%v", code, err)
+	}
+}
+
+func TestTokenizeIsconf(t *testing.T) {
+	t.Parallel()
+
+	got, err := tokenizeIsconf(` HQFT  BOOT='a b' X=y EMPTY= it's`)
+	if err == nil {
+		t.Fatalf("tokenizeIsconf(stray quote): got %#v, want error", got)
+	}
+
+	got, err = tokenizeIsconf(`HQFT BOOT='a b' PATHS=/x:/y`)
+	if err != nil {
+		t.Fatalf("tokenizeIsconf() error: %v", err)
+	}
+	want := []isconfToken{{word: "HQFT"}, {name: "BOOT", value: "a b"}, {name: "PATHS", value: "/x:/y"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tokenizeIsconf(): got %#v want %#v", got, want)
+	}
+}
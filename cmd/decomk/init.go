@@ -770,6 +770,7 @@ func checkoutGitRef(repoDir, gitRef string) error {
 
 func runGitCommand(dir string, args ...string) error {
 	command := exec.Command("git", args...)
+	command.Env = append(os.Environ(), gitProxyEnvList(envMapFromList(os.Environ()))...)
 	if dir != "" {
 		command.Dir = dir
 	}
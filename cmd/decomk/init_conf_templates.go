@@ -36,4 +36,3 @@ var (
 	//go:embed templates/confrepo.Dockerfile.tmpl
 	initConfRepoDockerfileTemplate string
 )
-
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stevegt/decomk/makeexec"
+	"github.com/stevegt/decomk/state"
+)
+
+// inProgressTarget is one target whose recipe a signal interrupted.
+type inProgressTarget struct {
+	Target        string    `json:"target"`
+	InterruptedAt time.Time `json:"interruptedAt"`
+	Signal        string    `json:"signal"`
+	LogPath       string    `json:"logPath,omitempty"`
+}
+
+// readInProgress loads the marker at path; a missing marker is empty.
+func readInProgress(path string) ([]inProgressTarget, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var marked []inProgressTarget
+	if err := json.Unmarshal(content, &marked); err != nil {
+		return nil, fmt.Errorf("decode in-progress marker %s: %w", path, err)
+	}
+	return marked, nil
+}
+
+// writeInProgress replaces the marker at path with marked, or removes it when
+// marked is empty.
+func writeInProgress(path string, marked []inProgressTarget) error {
+	if len(marked) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove in-progress marker: %w", err)
+		}
+		return nil
+	}
+	content, err := json.MarshalIndent(marked, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode in-progress marker: %w", err)
+	}
+	content = append(content, '\n')
+	if err := state.AtomicWrite(path, content, 0o644); err != nil {
+		return fmt.Errorf("write in-progress marker %s: %w", path, err)
+	}
+	return nil
+}
+
+// interruptedTargets reports the signal that interrupted make, if any, and the
+// targets it caught mid-recipe.
+//
+// A parallel run (runs non-empty) knows each invocation's outcome. A single
+// invocation builds targets in order and stops at the interrupted one, so the
+// first requested target without a stamp in stampDir is taken to be it (or to
+// own the prerequisite that was).
+func interruptedTargets(runErr error, runs []targetRun, stampDir string, targets []string) (string, []string) {
+	var interrupt *makeexec.InterruptedError
+	if !errors.As(runErr, &interrupt) {
+		return "", nil
+	}
+	var inProgress []string
+	if len(runs) > 0 {
+		for _, run := range runs {
+			var targetInterrupt *makeexec.InterruptedError
+			if errors.As(run.Err, &targetInterrupt) {
+				inProgress = append(inProgress, run.Target)
+			}
+		}
+		return interrupt.Signal.String(), inProgress
+	}
+	for _, target := range dedupeStrings(targets) {
+		if !fileExists(filepath.Join(stampDir, target)) {
+			return interrupt.Signal.String(), []string{target}
+		}
+	}
+	return interrupt.Signal.String(), nil
+}
+
+// updateInProgress returns marked without the completed targets and with the
+// newly interrupted ones (replacing older entries for the same target).
+//
+// Intent: Make a Ctrl-C or docker stop mid-recipe visible on the next run,
+// since the interrupted target may be half-applied, and forget it once a
+// later run completes it.
+// Source: DI-virif (TODO-jirin)
+func updateInProgress(marked []inProgressTarget, completed []string, interrupted []inProgressTarget) []inProgressTarget {
+	drop := make(map[string]bool, len(completed)+len(interrupted))
+	for _, target := range completed {
+		drop[target] = true
+	}
+	for _, entry := range interrupted {
+		drop[entry.Target] = true
+	}
+	var out []inProgressTarget
+	for _, entry := range marked {
+		if !drop[entry.Target] {
+			out = append(out, entry)
+		}
+	}
+	return append(out, interrupted...)
+}
+
+// completedTargets returns the targets a finished run built successfully:
+// all of them when make exited 0, else the parallel runs that succeeded.
+func completedTargets(runErr error, runs []targetRun, targets []string) []string {
+	if runErr == nil {
+		return targets
+	}
+	var done []string
+	for _, run := range runs {
+		if !run.failed() {
+			done = append(done, run.Target)
+		}
+	}
+	return done
+}
+
+// inProgressWarnings describes each marked target for plan and run.
+func inProgressWarnings(marked []inProgressTarget) []string {
+	var warnings []string
+	for _, entry := range marked {
+		warning := fmt.Sprintf("target %s was interrupted (%s) at %s and may be half-applied; rerun with -rerun-interrupted to rebuild it", entry.Target, entry.Signal, entry.InterruptedAt.Format(time.RFC3339))
+		if entry.LogPath != "" {
+			warning += "; log: " + entry.LogPath
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
+
+// removeStamps deletes the stamps of targets so make rebuilds them, and
+// returns the stamp paths it removed. Targets that are not plain paths inside
+// stampDir, or have no stamp, are left alone.
+func removeStamps(stampDir string, targets []string) ([]string, error) {
+	var removed []string
+	for _, target := range targets {
+		if !filepath.IsLocal(target) {
+			continue
+		}
+		stamp := filepath.Join(stampDir, target)
+		if !fileExists(stamp) {
+			continue
+		}
+		if err := os.Remove(stamp); err != nil {
+			return removed, fmt.Errorf("remove stamp of %s: %w", target, err)
+		}
+		removed = append(removed, stamp)
+	}
+	return removed, nil
+}
+
+// recordInProgress updates the marker at path after a run described by
+// record, which completed the completed targets.
+func recordInProgress(path string, record lastRun, completed []string) error {
+	marked, err := readInProgress(path)
+	if err != nil {
+		return err
+	}
+	var interrupted []inProgressTarget
+	for _, target := range record.InProgress {
+		interrupted = append(interrupted, inProgressTarget{Target: target, InterruptedAt: record.FinishedAt, Signal: record.Interrupted, LogPath: record.LogPath})
+	}
+	return writeInProgress(path, updateInProgress(marked, completed, interrupted))
+}
+
+// rerunInterruptedTargets implements -rerun-interrupted: it removes the marked
+// targets' stamps and clears the marker. The caller holds the stamps lock.
+func rerunInterruptedTargets(plan *resolvedPlan, stdout io.Writer) error {
+	path := state.InProgressPath(plan.Home)
+	marked, err := readInProgress(path)
+	if err != nil {
+		return err
+	}
+	var targets []string
+	for _, entry := range marked {
+		targets = append(targets, entry.Target)
+	}
+	removed, err := removeStamps(plan.StampDir, targets)
+	for _, stamp := range removed {
+		if writeErr := writeLine(stdout, "decomk: removed stamp of interrupted target:", stamp); writeErr != nil {
+			return writeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return writeInProgress(path, nil)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/makeexec"
+	"github.com/stevegt/decomk/state"
+)
+
+func TestInterruptedTargets(t *testing.T) {
+	t.Parallel()
+
+	stampDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(stampDir, "Block00"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	interrupt := &makeexec.InterruptedError{Signal: syscall.SIGINT, Err: errors.New("exit status 2")}
+
+	signal, inProgress := interruptedTargets(interrupt, nil, stampDir, []string{"Block00", "Block10", "Block20"})
+	if signal != syscall.SIGINT.String() || !reflect.DeepEqual(inProgress, []string{"Block10"}) {
+		t.Fatalf("interruptedTargets(single): got %q %q want %q [Block10]", signal, inProgress, syscall.SIGINT.String())
+	}
+
+	runs := []targetRun{
+		{Target: "Block00"},
+		{Target: "Block10", Err: interrupt},
+		{Target: "Block20", SkippedFor: "Block10"},
+	}
+	signal, inProgress = interruptedTargets(errors.Join(errors.New("targets failed"), interrupt), runs, stampDir, nil)
+	if signal != syscall.SIGINT.String() || !reflect.DeepEqual(inProgress, []string{"Block10"}) {
+		t.Fatalf("interruptedTargets(parallel): got %q %q want %q [Block10]", signal, inProgress, syscall.SIGINT.String())
+	}
+
+	if signal, inProgress := interruptedTargets(errors.New("exit status 2"), nil, stampDir, []string{"Block10"}); signal != "" || inProgress != nil {
+		t.Fatalf("interruptedTargets(failure): got %q %q want no interruption", signal, inProgress)
+	}
+}
+
+func TestRecordInProgress(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), ".inprogress")
+	at := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	interrupted := lastRun{FinishedAt: at, ExitCode: 130, LogPath: "/var/log/decomk/run/make.log", Interrupted: "interrupt", InProgress: []string{"Block10", "Block20"}}
+	if err := recordInProgress(path, interrupted, nil); err != nil {
+		t.Fatalf("recordInProgress(): %v", err)
+	}
+
+	// A later run that completes Block10 forgets it but keeps Block20.
+	if err := recordInProgress(path, lastRun{FinishedAt: at.Add(time.Hour)}, []string{"Block10"}); err != nil {
+		t.Fatalf("recordInProgress(): %v", err)
+	}
+	marked, err := readInProgress(path)
+	if err != nil {
+		t.Fatalf("readInProgress(): %v", err)
+	}
+	want := []inProgressTarget{{Target: "Block20", InterruptedAt: at, Signal: "interrupt", LogPath: "/var/log/decomk/run/make.log"}}
+	if !reflect.DeepEqual(marked, want) {
+		t.Fatalf("readInProgress(): got %+v want %+v", marked, want)
+	}
+	warnings := inProgressWarnings(marked)
+	wantWarning := "target Block20 was interrupted (interrupt) at 2026-10-16T09:00:00Z and may be half-applied; rerun with -rerun-interrupted to rebuild it; log: /var/log/decomk/run/make.log"
+	if len(warnings) != 1 || warnings[0] != wantWarning {
+		t.Fatalf("inProgressWarnings(): got %q want [%q]", warnings, wantWarning)
+	}
+
+	if err := recordInProgress(path, lastRun{}, []string{"Block20"}); err != nil {
+		t.Fatalf("recordInProgress(): %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("marker after all targets completed: got err %v want not exist", err)
+	}
+}
+
+func TestRerunInterruptedTargets(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	plan := &resolvedPlan{Home: home, StampDir: state.StampsDir(home)}
+	stamp := filepath.Join(plan.StampDir, "Block10")
+	if err := state.EnsureParentDir(stamp); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Block00", "Block10"} {
+		if err := os.WriteFile(filepath.Join(plan.StampDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	marked := []inProgressTarget{{Target: "Block10", Signal: "terminated"}, {Target: "../escape", Signal: "terminated"}}
+	if err := writeInProgress(state.InProgressPath(home), marked); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := rerunInterruptedTargets(plan, &stdout); err != nil {
+		t.Fatalf("rerunInterruptedTargets(): %v", err)
+	}
+	if fileExists(stamp) {
+		t.Fatalf("stamp %s still exists", stamp)
+	}
+	if !fileExists(filepath.Join(plan.StampDir, "Block00")) {
+		t.Fatalf("unmarked stamp Block00 was removed")
+	}
+	if got, want := stdout.String(), "decomk: removed stamp of interrupted target: "+stamp+"\n"; got != want {
+		t.Fatalf("rerunInterruptedTargets() output: got %q want %q", got, want)
+	}
+	if fileExists(state.InProgressPath(home)) {
+		t.Fatalf("marker not cleared")
+	}
+}
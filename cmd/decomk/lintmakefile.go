@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// makeTouchStampPattern matches a recipe line that stamps its target, with
+// touch or the decomk stamp helper ($(DECOMK_BIN) stamp $@).
+var makeTouchStampPattern = regexp.MustCompile(`^(touch|\S*(decomk|DECOMK_BIN)\S*\s+stamp)\s+("?)\$(@|\(@\)|\{@\})("?)$`)
+
+// lintFinding is one lint-makefile problem. Location is "file:line", or a
+// bare file for makefile-wide problems.
+type lintFinding struct {
+	Location string
+	Target   string
+	Message  string
+}
+
+// cmdLintMakefile checks the selected Makefiles (the MAKEFILE args, or the
+// makefiles `decomk run` would use) for the stamp idiom decomk depends on.
+//
+// Intent: Catch the Makefile mistakes that otherwise surface as mysterious
+// non-idempotent or half-failed runs.
+// Source: DI-jador (TODO-jirin)
+func cmdLintMakefile(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk lint-makefile", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+	addCommonFlags(fs, &f)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if err := applyStartDir(f.startDir); err != nil {
+		return 1, err
+	}
+
+	makefiles := fs.Args()
+	var rules makeRules
+	var generated []string
+	if len(makefiles) > 0 {
+		_, env := makeInvocation(os.Environ(), nil, nil)
+		database, err := makeDatabase(".", makefiles, nil, env)
+		if err != nil {
+			return 1, err
+		}
+		rules = parseMakeDatabase(database)
+	} else {
+		plan, planRules, err := planMakeRules(f)
+		if err != nil {
+			return 1, err
+		}
+		makefiles, rules, generated = plan.Makefiles, planRules, plan.ExtraMakefiles
+	}
+
+	findings := lintMakeRules(rules, makefiles, generated)
+	for _, makefile := range makefiles {
+		shellFindings, err := lintMakeShellCalls(makefile)
+		if err != nil {
+			return 1, err
+		}
+		findings = append(findings, shellFindings...)
+	}
+	for _, finding := range findings {
+		line := finding.Location + ": " + finding.Message
+		if finding.Target != "" {
+			line = finding.Location + ": " + finding.Target + ": " + finding.Message
+		}
+		if err := writeLine(stdout, line); err != nil {
+			return 1, err
+		}
+	}
+	if len(findings) == 0 {
+		return 0, writeLine(stdout, "lint-makefile: no findings")
+	}
+	if err := writeFormat(stdout, "lint-makefile: %d findings\n", len(findings)); err != nil {
+		return 1, err
+	}
+	return 1, nil
+}
+
+// lintMakeRules checks the shell settings and every recipe except those read
+// from generated (decomk's own fragments).
+func lintMakeRules(rules makeRules, makefiles, generated []string) []lintFinding {
+	var findings []lintFinding
+	first := makefiles[0]
+	if _, ok := rules.Prereqs[".ONESHELL"]; !ok {
+		findings = append(findings, lintFinding{Location: first, Message: ".ONESHELL is not set; decomk recipes expect one shell per recipe (add .ONESHELL:)"})
+	}
+	flags, ok := rules.Vars[".SHELLFLAGS"]
+	if !ok {
+		flags = "-c" // make's default
+	}
+	if !shellFlagsFailFast(flags) {
+		findings = append(findings, lintFinding{Location: first, Message: fmt.Sprintf(".SHELLFLAGS is %q; without -e and -o pipefail a failed command does not fail the recipe (use .SHELLFLAGS := -euo pipefail -c)", flags)})
+	}
+
+	skip := map[string]bool{"-": true}
+	for _, path := range generated {
+		skip[path] = true
+	}
+	lintable := func(from string) bool {
+		i := strings.LastIndexByte(from, ':')
+		return i > 0 && !skip[from[:i]]
+	}
+
+	phony := make(map[string]bool)
+	for _, name := range rules.Prereqs[".PHONY"] {
+		phony[name] = true
+	}
+	targets := make([]string, 0, len(rules.Commands))
+	for name := range rules.Commands {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+	for _, name := range targets {
+		from := rules.RecipeFrom[name]
+		if strings.HasPrefix(name, ".") || !lintable(from) {
+			continue
+		}
+		touches := recipeTouchesStamp(rules.Commands[name])
+		switch {
+		case phony[name] && touches:
+			findings = append(findings, lintFinding{Location: from, Target: name, Message: "declared .PHONY but its recipe ends with touch $@; a phony target runs every time, so the stamp is never used (remove it from .PHONY)"})
+		case !phony[name] && !touches:
+			findings = append(findings, lintFinding{Location: from, Target: name, Message: "recipe does not end with touch $@, so it re-runs on every decomk run (end it with touch $@, or declare it .PHONY if it should always run)"})
+		}
+	}
+	for _, pattern := range rules.Patterns {
+		if !lintable(pattern.RecipeFrom) || len(pattern.Commands) == 0 || recipeTouchesStamp(pattern.Commands) {
+			continue
+		}
+		findings = append(findings, lintFinding{Location: pattern.RecipeFrom, Target: pattern.Target, Message: "recipe does not end with touch $@, so it re-runs on every decomk run"})
+	}
+	return findings
+}
+
+// shellFlagsFailFast reports whether flags enable both errexit and pipefail.
+func shellFlagsFailFast(flags string) bool {
+	errexit, pipefail := false, false
+	fields := strings.Fields(flags)
+	for i, field := range fields {
+		switch {
+		case field == "-o" && i+1 < len(fields) && fields[i+1] == "pipefail":
+			pipefail = true
+		case field == "pipefail" && i > 0 && strings.HasPrefix(fields[i-1], "-") && strings.HasSuffix(fields[i-1], "o"):
+			pipefail = true
+		case strings.HasPrefix(field, "-") && !strings.HasPrefix(field, "--") && strings.Contains(field, "e"):
+			errexit = true
+		}
+	}
+	return errexit && pipefail
+}
+
+// recipeTouchesStamp reports whether the last command of a recipe is
+// `touch $@` (possibly after `&&` or `;`).
+func recipeTouchesStamp(commands []string) bool {
+	for i := len(commands) - 1; i >= 0; i-- {
+		last := strings.TrimLeft(strings.TrimSpace(commands[i]), "@-+")
+		if last == "" {
+			continue
+		}
+		for _, sep := range []string{"&&", ";"} {
+			if j := strings.LastIndex(last, sep); j >= 0 {
+				last = last[j+len(sep):]
+			}
+		}
+		return makeTouchStampPattern.MatchString(strings.TrimSpace(last))
+	}
+	return false
+}
+
+// lintMakeShellCalls reports every $(shell ...) in makefile. make expands
+// them while parsing (and in recipes while expanding them), so they run even
+// under make -n, which is what decomk plan uses.
+func lintMakeShellCalls(makefile string) ([]lintFinding, error) {
+	content, err := os.ReadFile(makefile)
+	if err != nil {
+		return nil, fmt.Errorf("read makefile: %w", err)
+	}
+	var findings []lintFinding
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Contains(line, "$(shell ") || strings.Contains(line, "${shell ") {
+			findings = append(findings, lintFinding{
+				Location: fmt.Sprintf("%s:%d", makefile, lineNum),
+				Message:  "$(shell ...) runs even under make -n (decomk plan); move the command into a recipe",
+			})
+		}
+	}
+	return findings, scanner.Err()
+}
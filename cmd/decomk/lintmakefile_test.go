@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecipeTouchesStamp(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]bool{
+		"touch $@":                 true,
+		"@touch $@":                true,
+		`touch "$@"`:               true,
+		"make-thing && touch $(@)": true,
+		"echo done; touch ${@}":    true,
+		"$(DECOMK_BIN) stamp $@":   true,
+		`"$$DECOMK_BIN" stamp $@`:  true,
+		"decomk stamp $@":          true,
+		"decomk stamp other":       false,
+		"touch $@.tmp":             false,
+		"echo touch $@ later":      false,
+		"fi":                       false,
+	}
+	for last, want := range cases {
+		if got := recipeTouchesStamp([]string{"echo first", last, "  "}); got != want {
+			t.Fatalf("recipeTouchesStamp(%q): got %v want %v", last, got, want)
+		}
+	}
+	if recipeTouchesStamp(nil) {
+		t.Fatalf("recipeTouchesStamp(nil): got true want false")
+	}
+}
+
+func TestShellFlagsFailFast(t *testing.T) {
+	t.Parallel()
+
+	for flags, want := range map[string]bool{
+		"-euo pipefail -c":  true,
+		"-e -o pipefail -c": true,
+		"-ec -o pipefail":   true,
+		"-c":                false,
+		"-eu -c":            false,
+		"-o pipefail -c":    false,
+		"":                  false,
+	} {
+		if got := shellFlagsFailFast(flags); got != want {
+			t.Fatalf("shellFlagsFailFast(%q): got %v want %v", flags, got, want)
+		}
+	}
+}
+
+func TestCmdLintMakefile(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.mk")
+	goodText := "SHELL := /bin/bash\n.ONESHELL:\n.SHELLFLAGS := -euo pipefail -c\n.RECIPEPREFIX := >\n\n" +
+		".PHONY: all\nall: tool\n>@echo done\n\ntool:\n>echo 'a: b'\n>@touch $@\n"
+	if err := os.WriteFile(good, []byte(goodText), 0o600); err != nil {
+		t.Fatalf("WriteFile(good.mk): %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	code, err := cmdLintMakefile([]string{good}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdLintMakefile(good): code=%d err=%v stdout=%q", code, err, stdout.String())
+	}
+
+	bad := filepath.Join(dir, "bad.mk")
+	badText := "NOW := $(shell date)\n.PHONY: again\nall: tool again\n\techo all\ntool:\n\techo tool\nagain:\n\ttouch $@\n"
+	if err := os.WriteFile(bad, []byte(badText), 0o600); err != nil {
+		t.Fatalf("WriteFile(bad.mk): %v", err)
+	}
+	stdout.Reset()
+	code, err = cmdLintMakefile([]string{bad}, &stdout, &stderr)
+	if err != nil || code != 1 {
+		t.Fatalf("cmdLintMakefile(bad): code=%d err=%v want 1", code, err)
+	}
+	got := stdout.String()
+	for _, want := range []string{
+		bad + ": .ONESHELL is not set",
+		bad + `: .SHELLFLAGS is "-c"`,
+		bad + ":4: all: recipe does not end with touch $@",
+		bad + ":6: tool: recipe does not end with touch $@",
+		bad + ":8: again: declared .PHONY",
+		bad + ":1: $(shell ...) runs even under make -n",
+		"lint-makefile: 6 findings\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("cmdLintMakefile(bad) missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// stampsLockStatus is who holds the stamps lock and who waits for it.
+type stampsLockStatus struct {
+	Holder *state.LockHolder `json:"holder,omitempty"`
+	// Queued are the waiting invocations, longest-waiting first.
+	Queued []state.LockHolder `json:"queued,omitempty"`
+	// Running are the invocations holding target or run locks (see
+	// lockRun), one entry per process.
+	Running []state.LockHolder `json:"running,omitempty"`
+}
+
+// readStampsLockStatus reads the stamps lock holder, wait queue, and runs in
+// progress of home, ignoring entries whose process has exited.
+func readStampsLockStatus(home string) (stampsLockStatus, error) {
+	var status stampsLockStatus
+	holder, ok, err := state.ReadLockHolder(state.StampsLockPath(home))
+	if err != nil {
+		return status, err
+	}
+	if ok {
+		status.Holder = &holder
+	}
+	if status.Running, err = readRunningHolders(home); err != nil {
+		return status, err
+	}
+	entries, err := os.ReadDir(state.StampsLockQueueDir(home))
+	if errors.Is(err, os.ErrNotExist) {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(state.StampsLockQueueDir(home), entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			// The waiter got the lock between ReadDir and here.
+			continue
+		}
+		if err != nil {
+			return status, err
+		}
+		var waiter state.LockHolder
+		if json.Unmarshal(content, &waiter) != nil || waiter.Gone() != "" {
+			continue
+		}
+		status.Queued = append(status.Queued, waiter)
+	}
+	sort.SliceStable(status.Queued, func(i, j int) bool { return status.Queued[i].Since.Before(status.Queued[j].Since) })
+	return status, nil
+}
+
+// readRunningHolders returns the live holders of home's run lock and target
+// locks, one per process, oldest first.
+func readRunningHolders(home string) ([]state.LockHolder, error) {
+	paths := []string{state.RunLockPath(home)}
+	entries, err := os.ReadDir(state.TargetLockDir(home))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		paths = append(paths, filepath.Join(state.TargetLockDir(home), entry.Name()))
+	}
+	seen := make(map[int]bool)
+	var running []state.LockHolder
+	for _, path := range paths {
+		holder, ok, err := state.ReadLockHolder(path)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !seen[holder.PID] {
+			seen[holder.PID] = true
+			running = append(running, holder)
+		}
+	}
+	sort.SliceStable(running, func(i, j int) bool { return running[i].Since.Before(running[j].Since) })
+	return running, nil
+}
+
+// lockStamps takes the stamps lock of home for command (for example "decomk
+// run INSTALL") and records it as the holder. When another invocation holds
+// the lock, lockStamps joins the wait queue, writes who holds it, how many
+// runs are ahead, and an estimated wait to w, and then blocks.
+//
+// Intent: Tell a user whose run is blocked on another run what it is waiting
+// for and roughly how long, instead of hanging silently.
+// Source: DI-zokip (TODO-jirin)
+func lockStamps(home, command string, w io.Writer) (lock *state.Lock, retErr error) {
+	lockPath := state.StampsLockPath(home)
+	self := state.NewLockHolder(command)
+	self.Since = time.Now()
+	lock, ok, err := state.TryLockFile(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		entry := filepath.Join(state.StampsLockQueueDir(home), strconv.Itoa(self.PID))
+		if err := writeQueueEntry(entry, self); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := os.Remove(entry); err != nil && !errors.Is(err, os.ErrNotExist) {
+				retErr = errors.Join(retErr, fmt.Errorf("leave stamps lock queue: %w", err))
+			}
+		}()
+		status, err := readStampsLockStatus(home)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeLine(w, lockWaitMessage(status, self, lastRunSeconds(home))); err != nil {
+			return nil, err
+		}
+		if lock, err = state.LockFile(lockPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := reportReclaimed(w, lock); err != nil {
+		return nil, errors.Join(err, lock.Close())
+	}
+	self.Since = time.Now()
+	if err := lock.SetHolder(self); err != nil {
+		return nil, errors.Join(err, lock.Close())
+	}
+	return lock, nil
+}
+
+// writeQueueEntry registers waiter in the stamps lock queue at path.
+func writeQueueEntry(path string, waiter state.LockHolder) error {
+	content, err := json.Marshal(waiter)
+	if err != nil {
+		return fmt.Errorf("encode stamps lock queue entry: %w", err)
+	}
+	if err := state.AtomicWrite(path, append(content, '\n'), 0o644); err != nil {
+		return fmt.Errorf("join stamps lock queue: %w", err)
+	}
+	return nil
+}
+
+// lastRunSeconds returns the total time of the most recent recorded run, or
+// 0 when there is none to estimate from.
+func lastRunSeconds(home string) float64 {
+	records, err := readTimingRecords(state.TimingsPath(home))
+	if err != nil || len(records) == 0 {
+		return 0
+	}
+	return records[len(records)-1].TotalSeconds
+}
+
+// lockWaitMessage describes what self waits for: the holder and how long it
+// has run, the runs queued ahead of self, and, when typicalSeconds (one run's
+// usual length) is known, an estimated wait.
+func lockWaitMessage(status stampsLockStatus, self state.LockHolder, typicalSeconds float64) string {
+	parts := []string{"decomk: waiting for the stamps lock"}
+	remaining := 0.0
+	if holder := status.Holder; holder != nil {
+		elapsed := self.Since.Sub(holder.Since).Seconds()
+		parts = append(parts, fmt.Sprintf("held by %s (%s) for %s", holderPID(*holder), holder.Command, formatSeconds(elapsed)))
+		remaining = max(typicalSeconds-elapsed, 0)
+	}
+	ahead := 0
+	for _, waiter := range status.Queued {
+		if waiter.PID != self.PID && waiter.Since.Before(self.Since) {
+			ahead++
+		}
+	}
+	switch ahead {
+	case 0:
+		parts = append(parts, "next in queue")
+	case 1:
+		parts = append(parts, "1 run queued ahead")
+	default:
+		parts = append(parts, fmt.Sprintf("%d runs queued ahead", ahead))
+	}
+	if typicalSeconds > 0 {
+		eta := remaining + float64(ahead)*typicalSeconds
+		parts = append(parts, "estimated wait "+formatSeconds(eta)+" (from the last run's "+formatSeconds(typicalSeconds)+")")
+	}
+	return strings.Join(parts, "; ")
+}
+
+// holderPID names holder's process, with its host when that is not this one.
+func holderPID(holder state.LockHolder) string {
+	if holder.Remote() {
+		return fmt.Sprintf("pid %d on %s", holder.PID, holder.Host)
+	}
+	return fmt.Sprintf("pid %d", holder.PID)
+}
+
+// reportReclaimed tells w when lock was taken over from a holder that died
+// holding it.
+//
+// Intent: Reclaim and report locks left by a killed container or process,
+// without trusting a PID that a restart may have handed to another process.
+// Source: DI-romuj (TODO-jirin)
+func reportReclaimed(w io.Writer, lock *state.Lock) error {
+	stale, ok := lock.Reclaimed()
+	if !ok {
+		return nil
+	}
+	by := "a process"
+	if stale.Holder.PID > 0 {
+		by = holderPID(stale.Holder)
+		if stale.Holder.Command != "" {
+			by += " (" + stale.Holder.Command + ")"
+		}
+	}
+	return writeFormat(w, "decomk: reclaimed stale lock %s from %s: %s\n", stale.Path, by, stale.Reason)
+}
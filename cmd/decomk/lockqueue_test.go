@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestLockWaitMessage(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	self := state.LockHolder{PID: 30, Since: start.Add(2 * time.Minute)}
+	status := stampsLockStatus{
+		Holder: &state.LockHolder{PID: 10, Command: "decomk run INSTALL", Since: start},
+		Queued: []state.LockHolder{{PID: 20, Since: start.Add(time.Minute)}, self},
+	}
+	got := lockWaitMessage(status, self, 300)
+	want := "decomk: waiting for the stamps lock; held by pid 10 (decomk run INSTALL) for 2m0s; 1 run queued ahead; estimated wait 8m0s (from the last run's 5m0s)"
+	if got != want {
+		t.Fatalf("lockWaitMessage(): got %q want %q", got, want)
+	}
+
+	got = lockWaitMessage(stampsLockStatus{}, self, 0)
+	if want := "decomk: waiting for the stamps lock; next in queue"; got != want {
+		t.Fatalf("lockWaitMessage(no holder): got %q want %q", got, want)
+	}
+}
+
+func TestLockStamps_QueuesBehindHolder(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	holder, err := lockStamps(home, "decomk run INSTALL", &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("lockStamps(holder): %v", err)
+	}
+
+	var stderr bytes.Buffer
+	type result struct {
+		lock *state.Lock
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		lock, err := lockStamps(home, "decomk gc", &stderr)
+		done <- result{lock, err}
+	}()
+
+	entry := filepath.Join(state.StampsLockQueueDir(home), strconv.Itoa(os.Getpid()))
+	deadline := time.Now().Add(5 * time.Second)
+	for !fileExists(entry) {
+		if time.Now().After(deadline) {
+			t.Fatalf("waiter never joined the queue at %s", entry)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, err := readStampsLockStatus(home)
+	if err != nil {
+		t.Fatalf("readStampsLockStatus(): %v", err)
+	}
+	if status.Holder == nil || status.Holder.Command != "decomk run INSTALL" || len(status.Queued) != 1 || status.Queued[0].Command != "decomk gc" {
+		t.Fatalf("readStampsLockStatus(): got %+v want holder decomk run INSTALL and queued decomk gc", status)
+	}
+
+	if err := holder.Close(); err != nil {
+		t.Fatalf("Close(holder): %v", err)
+	}
+	waited := <-done
+	if waited.err != nil {
+		t.Fatalf("lockStamps(waiter): %v", waited.err)
+	}
+	defer func() {
+		if err := waited.lock.Close(); err != nil {
+			t.Errorf("Close(waiter): %v", err)
+		}
+	}()
+	if !strings.Contains(stderr.String(), "waiting for the stamps lock; held by pid "+strconv.Itoa(os.Getpid())+" (decomk run INSTALL)") {
+		t.Fatalf("lockStamps(waiter) output: got %q", stderr.String())
+	}
+	if fileExists(entry) {
+		t.Fatalf("queue entry %s not removed after acquiring the lock", entry)
+	}
+	status, err = readStampsLockStatus(home)
+	if err != nil || status.Holder == nil || status.Holder.Command != "decomk gc" || len(status.Queued) != 0 {
+		t.Fatalf("readStampsLockStatus(after): got %+v, %v want holder decomk gc", status, err)
+	}
+}
+
+func TestLockStamps_ReportsReclaimedLock(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	lockPath := state.StampsLockPath(home)
+	if err := state.EnsureParentDir(lockPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lockPath, []byte(`{"pid":999999999,"command":"decomk run INSTALL","host":"other"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	lock, err := lockStamps(home, "decomk run", &stderr)
+	if err != nil {
+		t.Fatalf("lockStamps(): %v", err)
+	}
+	defer func() {
+		if err := lock.Close(); err != nil {
+			t.Errorf("Close(): %v", err)
+		}
+	}()
+	// The flock was free, so its holder died even though its host differs.
+	want := "decomk: reclaimed stale lock " + lockPath + " from pid 999999999 on other (decomk run INSTALL): the lock was free but still named this holder\n"
+	if stderr.String() != want {
+		t.Fatalf("lockStamps() output: got %q want %q", stderr.String(), want)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseLogFormat(t *testing.T) {
+	t.Setenv(logFormatEnv, "")
+
+	if tmpl, err := parseLogFormat(""); err != nil || tmpl != nil {
+		t.Fatalf("parseLogFormat(empty): got %v, %v want nil, nil", tmpl, err)
+	}
+	for _, bad := range []string{"{{.Line", "{{.Host}} {{.Line}}"} {
+		if _, err := parseLogFormat(bad); err == nil {
+			t.Fatalf("parseLogFormat(%q): expected error", bad)
+		}
+	}
+	t.Setenv(logFormatEnv, "{{.Stream}}: {{.Line}}")
+	tmpl, err := parseLogFormat("")
+	if err != nil || tmpl == nil {
+		t.Fatalf("parseLogFormat(env): got %v, %v", tmpl, err)
+	}
+}
+
+func TestLogLineWriter(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := parseLogFormat("{{.Time}} {{.RunID}} [{{.Target}}] {{.Stream}}: {{.Line}}")
+	if err != nil {
+		t.Fatalf("parseLogFormat(): %v", err)
+	}
+	var out bytes.Buffer
+	w := newLogLineWriter(&out, tmpl, logLine{RunID: "run-1", Target: "Block00", Stream: "stderr"})
+	lw, ok := w.(*logLineWriter)
+	if !ok {
+		t.Fatalf("newLogLineWriter(): got %T want *logLineWriter", w)
+	}
+	lw.now = func() time.Time { return time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC) }
+
+	for _, chunk := range []string{"first li", "ne\r\nsecond line\npart", "ial"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write(%q): %v", chunk, err)
+		}
+	}
+	prefix := "2026-10-16T09:00:00.000Z run-1 [Block00] stderr: "
+	want := prefix + "first line\n" + prefix + "second line\n"
+	if got := out.String(); got != want {
+		t.Fatalf("logLineWriter before flush: got %q want %q", got, want)
+	}
+	if err := flushLogWriters(w); err != nil {
+		t.Fatalf("flushLogWriters(): %v", err)
+	}
+	want += prefix + "partial\n"
+	if got := out.String(); got != want {
+		t.Fatalf("logLineWriter after flush: got %q want %q", got, want)
+	}
+
+	if got := newLogLineWriter(&out, nil, logLine{}); got != &out {
+		t.Fatalf("newLogLineWriter(nil template): got %T want the underlying writer", got)
+	}
+}
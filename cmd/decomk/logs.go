@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	logsSubcommandList = "list"
+	logsSubcommandShow = "show"
+)
+
+// runArtifactsVar names the per-run artifacts directory decomk passes to
+// make.
+const runArtifactsVar = "DECOMK_ARTIFACTS_DIR"
+
+// runArtifactsDirName is the artifacts directory inside a run log dir.
+const runArtifactsDirName = "artifacts"
+
+// runLogFile is one file of a run log dir.
+type runLogFile struct {
+	// Path is relative to the run log dir.
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// runLogListing is what `decomk logs show` reports for one run.
+type runLogListing struct {
+	RunID     string       `json:"runId"`
+	Dir       string       `json:"dir"`
+	Files     []runLogFile `json:"files"`
+	Artifacts []runLogFile `json:"artifacts"`
+}
+
+// createRunArtifactsDir creates the artifacts directory of the run logged in
+// runLogDir.
+//
+// Like DECOMK_RUN_TMP, it is world-writable and sticky so recipes that drop
+// privileges to the remote user can still write to it.
+//
+// Intent: Give recipes one place to drop build reports, JUnit XML, and test
+// logs that CI can collect together with make.log.
+// Source: DI-jivuj (TODO-jirin)
+func createRunArtifactsDir(runLogDir string) (string, error) {
+	dir := filepath.Join(runLogDir, runArtifactsDirName)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create run artifacts dir: %w", err)
+	}
+	if err := os.Chmod(dir, 0o777|os.ModeSticky); err != nil {
+		return "", fmt.Errorf("chmod run artifacts dir %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// cmdLogs dispatches `decomk logs` subcommands.
+func cmdLogs(args []string, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		return 2, fmt.Errorf("logs subcommand required\n\n%s", logsUsage())
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		if err := writeLine(stdout, logsUsage()); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	case logsSubcommandList:
+		return cmdLogsList(args[1:], stdout, stderr)
+	case logsSubcommandShow:
+		return cmdLogsShow(args[1:], stdout, stderr)
+	default:
+		return 2, fmt.Errorf("unknown logs subcommand: %s\n\n%s", args[0], logsUsage())
+	}
+}
+
+func logsUsage() string {
+	return `decomk logs - find run logs and the artifacts recipes left with them
+
+Usage:
+  decomk logs list [-home <dir>] [-log-dir <dir>]
+  decomk logs show [-home <dir>] [-log-dir <dir>] [-o text|json|yaml] RUNID
+
+Subcommands:
+  list
+      List run IDs with their log dirs, oldest first.
+  show
+      List the files of one run (make.log, per-target logs, traces) and the
+      artifacts its recipes wrote to DECOMK_ARTIFACTS_DIR. RUNID may be any
+      unique prefix.
+`
+}
+
+// logsFlags parses the flags shared by the logs subcommands and returns the
+// log roots to search: the configured log root, then the <DECOMK_HOME>/log
+// fallback.
+func logsFlags(name string, args []string, stderr io.Writer, output *string) ([]string, []string, int, error) {
+	fs := flag.NewFlagSet("decomk logs "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag, logDirFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	fs.StringVar(&logDirFlag, "log-dir", "", "per-run log root directory (absolute path; overrides DECOMK_LOG_DIR; default /var/log/decomk)")
+	if output != nil {
+		addOutputFlag(fs, output)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, 0, err
+		}
+		return nil, nil, 2, err
+	}
+	if output != nil {
+		if err := checkOutputFormat(*output); err != nil {
+			return nil, nil, 2, err
+		}
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return nil, nil, 1, err
+	}
+	logRoot, _, err := resolveLogRoot(logDirFlag)
+	if err != nil {
+		return nil, nil, 1, err
+	}
+	roots := []string{logRoot}
+	if fallback := state.LogDir(home); fallback != logRoot {
+		roots = append(roots, fallback)
+	}
+	return roots, fs.Args(), 0, nil
+}
+
+// cmdLogsList lists the run log dirs under the log roots.
+func cmdLogsList(args []string, stdout, stderr io.Writer) (int, error) {
+	roots, rest, code, err := logsFlags(logsSubcommandList, args, stderr, nil)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return code, err
+	}
+	if len(rest) != 0 {
+		return 2, fmt.Errorf("logs list does not accept positional args: %q", strings.Join(rest, " "))
+	}
+	dirs, err := runLogDirs(roots)
+	if err != nil {
+		return 1, err
+	}
+	for _, dir := range dirs {
+		if err := writeFormat(stdout, "%s  %s\n", filepath.Base(dir), dir); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// cmdLogsShow lists one run's log files and artifacts.
+func cmdLogsShow(args []string, stdout, stderr io.Writer) (int, error) {
+	var output string
+	roots, rest, code, err := logsFlags(logsSubcommandShow, args, stderr, &output)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return code, err
+	}
+	if len(rest) != 1 {
+		return 2, fmt.Errorf("usage: decomk logs show [flags] RUNID")
+	}
+	dirs, err := runLogDirs(roots)
+	if err != nil {
+		return 1, err
+	}
+	dir, err := findRunLogDir(dirs, rest[0])
+	if err != nil {
+		return 1, err
+	}
+	listing, err := listRunLogDir(dir)
+	if err != nil {
+		return 1, err
+	}
+	if output != outputText {
+		if err := writeStructured(stdout, output, listing); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	if err := writeFormat(stdout, "run: %s\ndir: %s\n", listing.RunID, listing.Dir); err != nil {
+		return 1, err
+	}
+	for _, file := range listing.Files {
+		if err := writeFormat(stdout, "log: %s (%d bytes)\n", filepath.Join(dir, file.Path), file.Bytes); err != nil {
+			return 1, err
+		}
+	}
+	for _, file := range listing.Artifacts {
+		if err := writeFormat(stdout, "artifact: %s (%d bytes)\n", filepath.Join(dir, file.Path), file.Bytes); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// runLogDirs returns the run log dirs under roots, sorted by run ID (which
+// starts with the run's start time).
+func runLogDirs(roots []string) ([]string, error) {
+	var dirs []string
+	for _, root := range roots {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("read log dir %s: %w", root, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, filepath.Join(root, entry.Name()))
+			}
+		}
+	}
+	sort.SliceStable(dirs, func(i, j int) bool { return filepath.Base(dirs[i]) < filepath.Base(dirs[j]) })
+	return dirs, nil
+}
+
+// findRunLogDir returns the dir in dirs whose run ID is runID or, failing
+// that, the only one starting with runID.
+func findRunLogDir(dirs []string, runID string) (string, error) {
+	var matches []string
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		if name == runID {
+			return dir, nil
+		}
+		if strings.HasPrefix(name, runID) {
+			matches = append(matches, dir)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no run log for run ID %q (see decomk logs list)", runID)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("run ID %q is ambiguous: %d runs match (see decomk logs list)", runID, len(matches))
+	}
+}
+
+// listRunLogDir lists the regular files under dir, separating the artifacts.
+func listRunLogDir(dir string) (runLogListing, error) {
+	listing := runLogListing{RunID: filepath.Base(dir), Dir: dir, Files: []runLogFile{}, Artifacts: []runLogFile{}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file := runLogFile{Path: rel, Bytes: info.Size()}
+		if strings.HasPrefix(rel, runArtifactsDirName+string(filepath.Separator)) {
+			listing.Artifacts = append(listing.Artifacts, file)
+		} else {
+			listing.Files = append(listing.Files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return runLogListing{}, fmt.Errorf("list run log %s: %w", dir, err)
+	}
+	return listing, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCmdLogs(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	logRoot := filepath.Join(t.TempDir(), "logs")
+	files := map[string]string{
+		filepath.Join(logRoot, "20261015T090000.000000000Z-11", "make.log"):                    "old\n",
+		filepath.Join(logRoot, "20261016T090000.000000000Z-12", "make.log"):                    "new\n",
+		filepath.Join(logRoot, "20261016T090000.000000000Z-12", "artifacts", "junit.xml"):      "<testsuites/>\n",
+		filepath.Join(home, "log", "20261016T100000.000000000Z-13", "make.log"):                "fallback\n",
+		filepath.Join(logRoot, "20261016T090000.000000000Z-12", "targets", "Block00", "x.log"): "x\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll(%s): %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+	base := []string{"-home", home, "-log-dir", logRoot}
+
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdLogs(append([]string{"list"}, base...), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdLogs(list): code=%d err=%v", code, err)
+	}
+	wantList := "20261015T090000.000000000Z-11  " + filepath.Join(logRoot, "20261015T090000.000000000Z-11") + "\n" +
+		"20261016T090000.000000000Z-12  " + filepath.Join(logRoot, "20261016T090000.000000000Z-12") + "\n" +
+		"20261016T100000.000000000Z-13  " + filepath.Join(home, "log", "20261016T100000.000000000Z-13") + "\n"
+	if stdout.String() != wantList {
+		t.Fatalf("cmdLogs(list): got %q want %q", stdout.String(), wantList)
+	}
+
+	stdout.Reset()
+	if code, err := cmdLogs(append(append([]string{"show"}, base...), "-o", "json", "20261016T09"), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdLogs(show): code=%d err=%v", code, err)
+	}
+	var listing runLogListing
+	if err := json.Unmarshal(stdout.Bytes(), &listing); err != nil {
+		t.Fatalf("cmdLogs(show) output %q: %v", stdout.String(), err)
+	}
+	want := runLogListing{
+		RunID:     "20261016T090000.000000000Z-12",
+		Dir:       filepath.Join(logRoot, "20261016T090000.000000000Z-12"),
+		Files:     []runLogFile{{Path: "make.log", Bytes: 4}, {Path: filepath.Join("targets", "Block00", "x.log"), Bytes: 2}},
+		Artifacts: []runLogFile{{Path: filepath.Join("artifacts", "junit.xml"), Bytes: 14}},
+	}
+	if !reflect.DeepEqual(listing, want) {
+		t.Fatalf("cmdLogs(show): got %+v want %+v", listing, want)
+	}
+
+	for _, runID := range []string{"2026", "nosuch"} {
+		code, err := cmdLogs(append(append([]string{"show"}, base...), runID), &stdout, &stderr)
+		if code != 1 || err == nil {
+			t.Fatalf("cmdLogs(show %s): got code=%d err=%v want 1 and an error", runID, code, err)
+		}
+	}
+}
+
+func TestCmdRun_ArtifactsDir(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("decomk run requires root")
+	}
+
+	origWD, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	t.Cleanup(func() {
+		if cleanupErr := os.Chdir(origWD); cleanupErr != nil {
+			t.Errorf("cleanup Chdir(origWD): %v", cleanupErr)
+		}
+	})
+
+	home := t.TempDir()
+	logRoot := filepath.Join(t.TempDir(), "logs")
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT:\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("report:\n\techo ok > \"$$DECOMK_ARTIFACTS_DIR/report.txt\"\n\ttouch $@\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	args := []string{"-C", origWD, "-home", home, "-log-dir", logRoot, "-workspaces", t.TempDir(), "-config", configPath, "-makefile", makefilePath, "report"}
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdRun(args, &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdRun(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+
+	dirs, err := runLogDirs([]string{logRoot})
+	if err != nil || len(dirs) != 1 {
+		t.Fatalf("runLogDirs(): got %q, %v want one run", dirs, err)
+	}
+	stdout.Reset()
+	if code, err := cmdLogs([]string{"show", "-home", home, "-log-dir", logRoot, filepath.Base(dirs[0])}, &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdLogs(show): code=%d err=%v", code, err)
+	}
+	for _, want := range []string{"log: " + filepath.Join(dirs[0], "make.log"), "artifact: " + filepath.Join(dirs[0], "artifacts", "report.txt") + " (3 bytes)"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("cmdLogs(show) output missing %q:\n%s", want, stdout.String())
+		}
+	}
+}
@@ -8,10 +8,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
@@ -25,8 +28,10 @@ import (
 	"github.com/stevegt/decomk/contexts"
 	"github.com/stevegt/decomk/expand"
 	"github.com/stevegt/decomk/makeexec"
+	"github.com/stevegt/decomk/recipetmpl"
 	"github.com/stevegt/decomk/resolve"
 	"github.com/stevegt/decomk/state"
+	"github.com/stevegt/decomk/toolchain"
 )
 
 // decomkVersion is the CLI version string printed by `decomk version`.
@@ -59,82 +64,188 @@ func run(args []string, stdout, stderr io.Writer) int {
 			return 1
 		}
 		return 0
-	case "version":
-		code, err := cmdVersion(args[2:], stdout, stderr)
-		if err != nil {
-			if printErr := writeLine(stderr, err.Error()); printErr != nil {
-				return 1
-			}
-			return code
+	}
+	if command, ok := lookupCommand(args[1]); ok {
+		code, err := command(args[2:], stdout, stderr)
+		return reportCommandError(code, err, stderr)
+	}
+
+	// Intent: Let sites add subcommands as decomk-<name> executables
+	// instead of forking the tool repo.
+	// Source: DI-nobug (TODO-jirin)
+	if home, err := state.Home(""); err == nil && !strings.HasPrefix(args[1], "-") {
+		code, ok, err := runPluginCommand(home, args[1], args[2:], stdout, stderr)
+		if err != nil || ok {
+			return reportCommandError(code, err, stderr)
 		}
+	}
+	if err := writeLine(stderr, "unknown command:", args[1]); err != nil {
+		return 1
+	}
+	if err := writeLine(stderr, usage()); err != nil {
+		return 1
+	}
+	return 2
+}
+
+// reportCommandError prints a subcommand's error, if any, to stderr and
+// returns its exit code, or 1 when the error cannot be printed.
+func reportCommandError(code int, err error, stderr io.Writer) int {
+	if err == nil {
 		return code
+	}
+	if printErr := writeLine(stderr, err.Error()); printErr != nil {
+		return 1
+	}
+	return code
+}
+
+// subcommand is a subcommand implementation: it receives the arguments after
+// the subcommand name and returns an exit code and the error to print.
+type subcommand func(args []string, stdout, stderr io.Writer) (int, error)
+
+// lookupCommand returns the built-in subcommand called name.
+func lookupCommand(name string) (subcommand, bool) {
+	switch name {
+	case "--selfcheck", "-selfcheck":
+		// Hidden: stage-0 verifies freshly built binaries with this before
+		// promoting them (see cmdSelfcheck).
+		return cmdSelfcheck, true
+	case "version":
+		return cmdVersion, true
 	case "init":
 		// Intent: Make stage-0 devcontainer bootstrap scaffolding first-class in
 		// decomk so new repos can be bootstrapped consistently without manual file
 		// copy/paste.
 		// Source: DI-votit (TODO-jirin)
-		code, err := cmdInit(args[2:], stdout, stderr)
-		if err != nil {
-			if printErr := writeLine(stderr, err.Error()); printErr != nil {
-				return 1
-			}
-			return code
-		}
-		return code
+		return cmdInit, true
 	case "plan":
-		code, err := cmdPlan(args[2:], stdout, stderr)
-		if err != nil {
-			if printErr := writeLine(stderr, err.Error()); printErr != nil {
-				return 1
-			}
-			return code
-		}
-		return code
+		return cmdPlan, true
 	case "run":
-		code, err := cmdRun(args[2:], stdout, stderr)
-		if err != nil {
-			if printErr := writeLine(stderr, err.Error()); printErr != nil {
-				return 1
-			}
-			return code
-		}
-		return code
+		return cmdRun, true
+	case "contexts":
+		// Intent: Surface config key docs so config repos are self-documenting.
+		// Source: DI-zinug (TODO-jirin)
+		return cmdContexts, true
+	case "explain":
+		return cmdExplain, true
+	case "graph":
+		// Intent: Visualize how config keys compose, next to contexts/explain.
+		// Source: DI-rugos (TODO-jirin)
+		return cmdGraph, true
+	case "which":
+		// Intent: Locate the Makefile recipe and config keys behind a target.
+		// Source: DI-nonol (TODO-jirin)
+		return cmdWhich, true
+	case "targets":
+		// Intent: Back shell completion and IDE pickers without paying for
+		// full resolution on every keystroke.
+		// Source: DI-jajit (TODO-jirin)
+		return cmdTargets, true
+	case "status":
+		return cmdStatus, true
+	case "env":
+		return cmdEnv, true
+	case "history":
+		return cmdHistory, true
+	case "logs":
+		// Intent: Let CI collect a run's make.log and the artifacts its
+		// recipes wrote without knowing the log root.
+		// Source: DI-jivuj (TODO-jirin)
+		return cmdLogs, true
+	case "stamp":
+		// Intent: Give recipes a stamp helper whose use decomk can check.
+		// Source: DI-fupub (TODO-jirin)
+		return cmdStamp, true
+	case "lint-makefile":
+		// Intent: Check Makefiles for the stamp idiom decomk depends on.
+		// Source: DI-jador (TODO-jirin)
+		return cmdLintMakefile, true
+	case "new-target":
+		// Intent: Scaffold stamp targets for contributors new to make.
+		// Source: DI-fabak (TODO-jirin)
+		return cmdNewTarget, true
+	case "shell":
+		// Intent: Give operators an interactive shell under the same resolved
+		// environment make sees, for debugging recipes by hand.
+		// Source: DI-bumil (TODO-jirin)
+		return func(args []string, stdout, stderr io.Writer) (int, error) {
+			return cmdShell(args, os.Stdin, stdout, stderr)
+		}, true
 	case "checkpoint":
 		// Intent: Provide first-class checkpoint lifecycle commands (`build`,
 		// `push`, `tag`) directly in decomk so operators can run one canonical CLI
 		// flow instead of ad-hoc external scripts.
 		// Source: DI-kalab (TODO-luvov)
-		code, err := cmdCheckpoint(args[2:], stdout, stderr)
-		if err != nil {
-			if printErr := writeLine(stderr, err.Error()); printErr != nil {
-				return 1
-			}
-			return code
-		}
-		return code
+		return cmdCheckpoint, true
+	case "profile":
+		// Intent: Snapshot resolved plans for later replay when debugging
+		// regressions caused by workspace or config drift.
+		// Source: DI-gohig (TODO-jirin)
+		return cmdProfile, true
+	case "config":
+		// Intent: Persist small personal tweaks without editing shared repos
+		// or exporting env vars in every shell.
+		// Source: DI-fafuh (TODO-jirin)
+		return cmdConfig, true
+	case "self-update":
+		// Intent: Expose the stage-0 binary archive so operators can restore a
+		// last-known-good decomk build after a bad tool update.
+		// Source: DI-mozaf (TODO-jirin)
+		// Intent: Make tool updates an explicit operator command.
+		// Source: DI-bovit (TODO-jirin)
+		return cmdSelfUpdate, true
+	case "update":
+		// Intent: Review upstream config and tool changes before allowing
+		// the fast-forward.
+		// Source: DI-zodiz (TODO-jirin)
+		return cmdUpdate, true
+	case "hook":
+		// Intent: Give devcontainer.json one command per lifecycle phase.
+		// Source: DI-luvum (TODO-jirin)
+		return cmdHook, true
+	case "check":
+		// Intent: Validate config repos in CI before containers pull them.
+		// Source: DI-marim (TODO-jirin)
+		return cmdCheck, true
+	case "healthz":
+		// Intent: Expose provisioning convergence as an exit code for
+		// container health checks.
+		// Source: DI-gunaz (TODO-jirin)
+		return cmdHealthz, true
+	case "serve-stdio":
+		// Intent: Let editor extensions drive decomk over JSON-RPC.
+		// Source: DI-gozub (TODO-jirin)
+		return func(args []string, stdout, stderr io.Writer) (int, error) {
+			return cmdServeStdio(args, os.Stdin, stdout, stderr)
+		}, true
+	case "events":
+		// Intent: Stream run progress to IDEs and other UIs.
+		// Source: DI-basaf (TODO-jirin)
+		return cmdEvents, true
+	case "du":
+		// Intent: Show where decomk's disk usage goes before pruning it.
+		// Source: DI-ludat (TODO-jirin)
+		return cmdDU, true
+	case "gc":
+		// Intent: Bound the disk use of run logs, run tmp dirs, archived
+		// binaries, and stamps left behind by removed Makefile targets.
+		// Source: DI-jujit (TODO-jirin)
+		return cmdGC, true
+	case "import":
+		// Intent: Offer a mechanical isconf-to-decomk conversion so migrating
+		// shops start from their existing macro structure.
+		// Source: DI-hizih (TODO-jirin)
+		return cmdImport, true
 	case "branch":
 		// Intent: Keep branch/channel devcontainer rendering first-class in
 		// decomk so conf repos have one authoritative command for materializing
 		// build/image, conf-ref, and tool-ref channel policy before devcontainer
 		// startup reads `.devcontainer/devcontainer.json`.
 		// Source: DI-hokan (TODO-punab)
-		code, err := cmdBranch(args[2:], stdout, stderr)
-		if err != nil {
-			if printErr := writeLine(stderr, err.Error()); printErr != nil {
-				return 1
-			}
-			return code
-		}
-		return code
-	default:
-		if err := writeLine(stderr, "unknown command:", args[1]); err != nil {
-			return 1
-		}
-		if err := writeLine(stderr, usage()); err != nil {
-			return 1
-		}
-		return 2
+		return cmdBranch, true
 	}
+	return nil, false
 }
 
 // writeLine writes one line to w and reports any write failure.
@@ -165,8 +276,34 @@ Commands:
   init     Install .devcontainer templates for decomk stage-0 bootstrap; use -conf for shared conf-repo scaffolding
   plan    Print resolved tuples/targets + env exports; run make -n (dry-run); do not write env export file
   run     Resolve, write env export file, and run make in the stamp dir
+  contexts  List config keys with their ## doc comments; * marks the keys selected for this workspace
+  explain NAME  Show the doc, value, and expansion steps of a config key or tuple name
+  graph   Write the config key -> macro -> token structure as Graphviz DOT (-no-tokens for keys only)
+  which TARGET  Show the Makefile file:line of a target's recipe and the config keys whose tuples name it
+  targets  List Makefile targets and action tuple names for shell completion (-json; cached until inputs change)
+  stamp TARGET...  Create or touch stamp files; end recipes with $(DECOMK_BIN) stamp $@
+  lint-makefile  Check Makefiles for the stamp idiom (.ONESHELL, -e/pipefail, touch $@, .PHONY, $(shell))
+  new-target NAME  Append a stamp target (-template plain|apt|git-clone|download) to the config repo Makefile; -context KEY also adds it to a tuple
+  shell   Launch $SHELL in the stamp dir with the resolved env applied (args pass through to the shell)
   checkpoint  Build/push/tag checkpoint images for shared updateContent setup
   branch  Render/check branch-channel devcontainer config from .decomk/channels.json
+  import  Convert other tools' configuration (isconf) into decomk.conf + Makefile
+  check   Validate a config repo checkout for CI: resolve every context and run make -n for ARGS (-nagios: healthz -nagios)
+  profile Save/list/show resolved plan snapshots (replay with plan/run -profile NAME); report run timings
+  config  Get/set/unset personal tuple overrides in <DECOMK_HOME>/local.conf (above the config repo, below -config)
+  hook    Run the preset for a devcontainer lifecycle phase (update-content, post-create, post-start, post-attach)
+  healthz Exit 0 only if the last run succeeded recently and make -q reports nothing pending
+  serve-stdio  Serve JSON-RPC 2.0 (resolve, plan, run, status, explain) on stdin/stdout, one message per line
+  events  Print the NDJSON progress events of the run in progress until it finishes
+  status  Report the last run, whether targets are pending, and runs in progress (never waits for locks)
+  env     Print the env export file the last run wrote, for eval "$(decomk env)"
+  history List recent runs from the timing history, newest first (-n N)
+  logs    List run log dirs, or one run's logs and DECOMK_ARTIFACTS_DIR artifacts (logs show RUNID)
+  du      Summarize disk usage of decomk state and run logs, with the largest entries
+  gc      Prune old run logs, run tmp dirs, archived binaries, and orphaned stamps (-dry-run to only report)
+  self-update  Update decomk from DECOMK_TOOL_URI (-check to only report), list archived binaries, or roll back
+  update       Fast-forward the config repo and update decomk; -check fetches only and reports incoming commits and plan changes
+  NAME    Run the decomk-NAME plugin from <DECOMK_HOME>/plugins or PATH
 
 ARGS (required for plan/run):
   Positional args are interpreted isconf-style:
@@ -207,9 +344,48 @@ type commonFlags struct {
 	workspacesDir string
 	context       string
 	config        string
+	confPath      string
 	makefile      string
+	profile       string
+	// workspaceConfigOwners opts in to workspace-local decomk.conf overlays
+	// from the listed (trusted) owners.
+	workspaceConfigOwners string
+	// dotenv lists .env files loaded as low-precedence tuples.
+	dotenv        string
 	verbose       bool
 	maxExpDepth   int
+	maxExpTokens  int
+	warnExpTokens int
+	// traceExpand records how each expanded token was derived.
+	traceExpand bool
+
+	// devcontainerEnv selects devcontainer.json containerEnv/remoteEnv
+	// variables merged below dotenv tuples.
+	devcontainerEnv string
+	// noExecTuples refuses $(exec:...) tuple values.
+	noExecTuples bool
+	// ageIdentity is the age key file that decrypts ENC[age:...] values.
+	ageIdentity string
+	// reportUnused warns about config keys no seed context can reach.
+	reportUnused bool
+	// noDefault leaves DEFAULT out of the context seeds.
+	noDefault bool
+	// confSrc is a pinned config tarball unpacked into the config repo root.
+	confSrc string
+	// confOCI is a pinned OCI config artifact unpacked the same way.
+	confOCI string
+
+	// confRoot, when set, replaces <DECOMK_HOME>/conf as the config repo root
+	// (decomk check validates a checkout in place). It is not a flag.
+	confRoot string
+	// workspaceList, when non-nil, replaces workspace discovery (decomk check
+	// resolves against a synthetic list). It is not a flag.
+	workspaceList []workspaceRepo
+	// inert resolves without running, decrypting, or downloading anything:
+	// $(exec:...) values stay literal, ENC[age:...] values stay encrypted, var
+	// plugins do not run, and remote makefiles are not fetched (decomk update
+	// -check previews unreviewed config). It is not a flag.
+	inert bool
 }
 
 // addCommonFlags defines flags shared by plan/run.
@@ -220,10 +396,24 @@ func addCommonFlags(fs *flag.FlagSet, f *commonFlags) {
 	fs.StringVar(&f.workspacesDir, "workspaces", "", "workspaces root directory to scan (overrides DECOMK_WORKSPACES_DIR; default /workspaces)")
 	fs.StringVar(&f.context, "context", "", "context key override (also DECOMK_CONTEXT)")
 	fs.StringVar(&f.config, "config", "", "config file path override (also DECOMK_CONFIG)")
-	fs.StringVar(&f.makefile, "makefile", "", "makefile path override")
+	fs.StringVar(&f.confPath, "conf-path", "", "relative subdirectory of the config repo holding decomk.conf and Makefile (also DECOMK_CONF_PATH)")
+	fs.StringVar(&f.makefile, "makefile", "", "makefile path or pinned https URL override")
+	fs.StringVar(&f.confSrc, "conf-src", "", "pinned https config tarball (URL#sha256=<hex>) unpacked into <DECOMK_HOME>/conf instead of a git clone (also DECOMK_CONF_SRC)")
+	fs.StringVar(&f.confOCI, "conf-oci", "", "pinned OCI config artifact (registry/repo[:tag]@sha256:<hex>) unpacked into <DECOMK_HOME>/conf instead of a git clone (also DECOMK_CONF_OCI)")
+	fs.StringVar(&f.profile, "profile", "", "replay a saved profile (see decomk profile save) instead of resolving config")
+	fs.StringVar(&f.workspaceConfigOwners, "workspace-config-owners", "", "comma-separated GitHub owners whose workspace decomk.conf overlays are applied; * trusts all (also DECOMK_WORKSPACE_CONFIG_OWNERS)")
+	fs.StringVar(&f.devcontainerEnv, "devcontainer-env", "", "comma-separated variable names (or *) merged from each workspace devcontainer.json containerEnv/remoteEnv as envonly tuples (also DECOMK_DEVCONTAINER_ENV)")
+	fs.StringVar(&f.ageIdentity, "age-identity", "", "age identity file that decrypts ENC[age:...] tuple values (also DECOMK_AGE_IDENTITY, then SOPS_AGE_KEY_FILE)")
+	fs.BoolVar(&f.noExecTuples, "no-exec-tuples", false, "refuse $(exec:...) tuple values instead of running their commands (also DECOMK_NO_EXEC_TUPLES)")
+	fs.BoolVar(&f.reportUnused, "report-unused", false, "warn about config keys not reachable from DEFAULT, any owner/repo context, or the selected contexts (also DECOMK_REPORT_UNUSED)")
+	fs.BoolVar(&f.noDefault, "no-default", false, "do not seed the DEFAULT context; expand only the selected contexts (also DECOMK_NO_DEFAULT)")
+	fs.StringVar(&f.dotenv, "dotenv", "", "comma-separated .env files loaded as low-precedence envonly tuples; workspaces loads each workspace root's .env (also DECOMK_DOTENV)")
 	// Note: -v is reserved for future improvements (more logging and plan details).
 	fs.BoolVar(&f.verbose, "v", false, "verbose output")
 	fs.IntVar(&f.maxExpDepth, "max-expand-depth", 0, "macro expansion depth limit (default 64)")
+	fs.IntVar(&f.maxExpTokens, "max-expand-tokens", 0, "expanded token count limit (default 10000)")
+	fs.IntVar(&f.warnExpTokens, "warn-expand-tokens", 0, "expanded token count that triggers a warning (default 2000)")
+	fs.BoolVar(&f.traceExpand, "trace-expand", false, "record the derivation of every expanded token (plan prints it; run writes trace.json to the run log dir)")
 }
 
 type resolvedPlan struct {
@@ -255,15 +445,42 @@ type resolvedPlan struct {
 	// that decomk will read or write any repo-local state.
 	WorkspaceRepos []workspaceRepo
 
+	// Allow is the root config's DECOMK_ALLOW list, and LowerTuples the
+	// tuples lower config layers set under it (see contexts.Config), so
+	// target selection can check what those tuples select.
+	Allow       []string
+	LowerTuples map[string][]string
+
+	// WorkspaceConfigs are the workspace-local decomk.conf overlays found when
+	// -workspace-config-owners / DECOMK_WORKSPACE_CONFIG_OWNERS is set, applied
+	// or skipped by the owner trust gate.
+	WorkspaceConfigs []workspaceConfig
+
+	// DevcontainerFiles are the devcontainer.json files whose containerEnv
+	// and remoteEnv were merged (-devcontainer-env / DECOMK_DEVCONTAINER_ENV).
+	DevcontainerFiles []string
+
+	// DotenvPaths are the .env files whose assignments were loaded as
+	// low-precedence tuples (-dotenv / DECOMK_DOTENV).
+	DotenvPaths []string
+
 	// ContextKeys are the config keys seeded for expansion, in order.
 	//
 	// In the common case this is DEFAULT plus one key per discovered workspace
 	// (when that key exists in the loaded config).
 	ContextKeys []string
 
+	// Seeds say why each of ContextKeys was seeded, in the same order.
+	Seeds []contextSeed
+
 	// ConfigPaths are the config sources that were loaded (in precedence order).
 	ConfigPaths []string
 
+	// ConfDir is the directory inside the config repo clone that holds
+	// decomk.conf and the default Makefile (<DECOMK_HOME>/conf, or a
+	// subdirectory of it when -conf-path/DECOMK_CONF_PATH is set).
+	ConfDir string
+
 	// StampDir is decomk's global make working directory (the stamps directory).
 	//
 	// decomk uses a single stamp directory for the whole container because it is
@@ -271,13 +488,91 @@ type resolvedPlan struct {
 	// per-repo build artifacts.
 	StampDir string
 	// EnvFile is the shell-friendly env export file written for other processes to source.
-	EnvFile  string
-	Makefile string
+	EnvFile string
+
+	// Makefiles are the configured makefiles, in "-f" order: the -makefile
+	// override, the DECOMK_MAKEFILES tuple list, or the default Makefile.
+	Makefiles []string
+
+	// Namespaces are the DECOMK_MAKEFILES entries written NAMESPACE=path,
+	// whose targets make sees as NAMESPACE/TARGET.
+	Namespaces []makefileNamespace
+
+	// TargetDirs are the DECOMK_TARGET_DIRS entries: targets whose recipes run
+	// in a directory other than the stamp dir.
+	TargetDirs []targetDir
+
+	// ExecTuples are the $(exec:...) commands run while resolving tuple
+	// values, in order.
+	ExecTuples []execTuple
+
+	// Plugins are the decomk-<name> exec plugins found in
+	// <DECOMK_HOME>/plugins and on PATH (see discoverPlugins).
+	Plugins []execPlugin
+
+	// PluginVars are the variables var plugins printed (see runVarPlugins),
+	// in first-assignment order.
+	PluginVars []pluginVar
+
+	// FileTuples are the tuples whose values were read from @file: paths.
+	FileTuples []fileTuple
+
+	// EnvTuples are the tuples whose values were taken from @env: variables.
+	EnvTuples []envTuple
+
+	// Secrets maps each tuple name decrypted from an ENC[age:...] value to
+	// that ciphertext. Their plaintext tuples are envonly, kept out of env.sh
+	// (see writeSecretsEnvFile), and redacted in output.
+	Secrets map[string]string
+
+	// ExtraMakefiles are decomk-generated make fragments passed as additional
+	// "-f" flags after Makefiles (for example toolchain install targets).
+	ExtraMakefiles []string
+
+	// Toolchains are the version-manager requests extracted from the expanded
+	// tokens (mise:/asdf: tokens).
+	Toolchains []toolchain.Spec
+
+	// Templates are the recipe template calls extracted from the expanded
+	// tokens (tmpl: tokens), and TemplatesMakefile is their rendered fragment.
+	Templates         []recipetmpl.Call
+	TemplatesMakefile []byte
+
+	// Recipes are the inline `recipe name: command` targets from the loaded
+	// config files.
+	Recipes contexts.Recipes
+
+	// Defs are the loaded context definitions, including workspace overlays.
+	Defs contexts.Defs
+	// Docs are the "##" doc comments of the keys in the loaded config files.
+	Docs contexts.Docs
+	// Tags are the target tags from the loaded config files (see -tags).
+	Tags contexts.Tags
+
+	// Conditions are the conditional tokens evaluated during expansion, with
+	// their results.
+	Conditions []expand.ConditionResult
 
 	// Expanded is the flattened macro expansion result before partitioning.
 	Expanded []string
+	// Warnings are non-fatal resolution problems (for example a very large
+	// expansion) that plan/run print to stderr.
+	Warnings []string
+	// ExpandTrace is the derivation of each Expanded token; nil unless
+	// -trace-expand is set.
+	ExpandTrace *expand.Trace
 	// Tuples are the NAME=value entries passed on make's argv.
 	Tuples []string
+	// TupleClasses maps tuple names to the class of their last assignment (for
+	// example resolve.ClassMakeOnly); unclassed names are absent.
+	TupleClasses map[string]string
+
+	// Profile is the saved profile name when the plan was replayed with
+	// -profile instead of resolved from config.
+	Profile string
+	// ProfileActionArgs are the action args stored with the profile; they are
+	// used when the command line supplies none.
+	ProfileActionArgs []string
 }
 
 // cmdPlan resolves config and prints what decomk would do, without running real
@@ -334,6 +629,10 @@ type executionMode struct {
 
 	// Log controls whether decomk writes make output to a per-run log file.
 	Log bool
+
+	// DefaultActionArgs are used when neither the command line nor a profile
+	// supplies action args (decomk hook presets set them).
+	DefaultActionArgs []string
 }
 
 var (
@@ -355,237 +654,85 @@ var (
 	}
 )
 
-// cmdExecute is the shared implementation for plan/run.
+// skipForBootstrapState reports whether -bootstrap-only (bootstrapOnly) or
+// -converge-only (!bootstrapOnly) should skip this invocation, given the
+// first-boot marker at markerPath.
 //
-// Both commands:
-//   - parse flags and action args
-//   - apply -C (starting directory) for relative path resolution
-//   - resolve config, expand macros, and partition tokens
-//   - select targets (isconf-style action args)
-//   - invoke make (real or -n)
-//
-// The executionMode controls whether env.sh is written, whether stamp state is
-// locked/touched, and whether output is captured to a per-run log file.
-func cmdExecute(args []string, stdout, stderr io.Writer, mode executionMode) (exitCode int, retErr error) {
-	fs := flag.NewFlagSet("decomk "+mode.Name, flag.ContinueOnError)
-	fs.SetOutput(stderr)
-	var f commonFlags
-
-	addCommonFlags(fs, &f)
-	if err := fs.Parse(args); err != nil {
-		if errors.Is(err, flag.ErrHelp) {
-			return 0, nil
-		}
-		return 2, err
-	}
-	actionArgs := fs.Args()
-	// Intent: Require explicit action selection for both plan and run so decomk
-	// does not silently fall back to config-derived/no-arg target behavior.
-	// Source: DI-gusab (TODO-takoh)
-	if len(actionArgs) == 0 {
-		return 2, fmt.Errorf("decomk %s requires at least one action arg", mode.Name)
-	}
-
-	// Intent: Keep privilege escalation out of decomk core by requiring run mode
-	// to already execute as root (stage-0 performs any needed sudo re-exec).
-	// Source: DI-kataj (TODO-jirin)
-	if !mode.DryRun && os.Geteuid() != 0 {
-		return 1, fmt.Errorf("decomk run must execute as root; rerun via stage-0 bootstrap or root shell")
-	}
-
-	// Root-run behavior is an execution concern (how decomk invokes make), not part
-	// of the resolved config/expansion model. Keep it out of resolvedPlan so plan
-	// resolution stays purely about "which tokens/tuples/targets" rather than
-	// "which user launches make".
-	//
-	// Intent: Keep plan resolution deterministic and reusable (plan/run) while
-	// stage-0 owns root escalation policy.
-	// Source: DI-kataj (TODO-jirin)
-
-	if err := applyStartDir(f.startDir); err != nil {
-		return 1, err
+// Intent: Let lifecycle hooks choose between a one-time bootstrap and quick
+// steady-state convergence.
+// Source: DI-valik (TODO-jirin)
+func skipForBootstrapState(markerPath string, bootstrapOnly bool) (bool, string) {
+	bootstrapped := fileExists(markerPath)
+	switch {
+	case bootstrapOnly && bootstrapped:
+		return true, "container already bootstrapped (" + markerPath + "); -bootstrap-only"
+	case !bootstrapOnly && !bootstrapped:
+		return true, "container has not bootstrapped yet (no " + markerPath + "); -converge-only"
 	}
+	return false, ""
+}
 
-	plan, err := resolvePlanFromFlags(f)
-	if err != nil {
-		return 1, err
-	}
-	if plan == nil {
-		return 1, fmt.Errorf("internal error: resolvePlanFromFlags returned nil plan")
+// writeBootstrapMarker records the first successful run at markerPath. An
+// existing marker is left untouched so it keeps the first-boot time.
+func writeBootstrapMarker(markerPath string, now time.Time) error {
+	if fileExists(markerPath) {
+		return nil
 	}
-	if plan.Makefile == "" {
-		return 1, fmt.Errorf("no Makefile found; use -makefile to set an explicit path")
+	content := "bootstrappedAt=" + now.UTC().Format(time.RFC3339) + "\nversion=" + decomkVersion + "\n"
+	if err := state.AtomicWrite(markerPath, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("write bootstrap marker %s: %w", markerPath, err)
 	}
+	return nil
+}
 
-	// Intent: Resolve passthrough tuples and build one canonical env tuple stream
-	// once per invocation so env.sh and make receive the same effective values.
-	// Source: DI-vojik (TODO-jirin)
-	incomingEnvList := os.Environ()
-	incomingEnv := envMapFromList(incomingEnvList)
-	resolvedTuples, err := resolveTuplePassThroughs(plan.Tuples, incomingEnv)
+// readTargetGraph reads the plan's make rule database and orders targets for
+// per-target make invocations.
+func readTargetGraph(plan *resolvedPlan, makeTuples, makeEnv, targets []string) (*targetGraph, error) {
+	rules, err := cachedMakeRules(plan.Home, plan.StampDir, planMakefiles(plan), makeTuples, makeEnv)
 	if err != nil {
-		return 1, err
-	}
-	plan.Tuples = resolvedTuples
-
-	targets, targetSource := selectTargets(plan.Tuples, actionArgs)
-	cookedTuples := canonicalEnvTuples(plan, targets, incomingEnv)
-	makeCmd := []string{"make"}
-
-	if mode.DryRun {
-		if err := printPlan(stdout, plan, actionArgs, targets, targetSource); err != nil {
-			return 1, err
-		}
-		if err := writeLine(stdout); err != nil {
-			return 1, err
-		}
-		if err := writeLine(stdout, "env exports (dry-run; not written):"); err != nil {
-			return 1, err
-		}
-		if err := writeEnvExport(stdout, plan, cookedTuples); err != nil {
-			return 1, err
-		}
-	}
-
-	// Ensure the stamp dir exists so make can run. This does not touch any stamp
-	// files; it only ensures the directory exists.
-	if err := state.EnsureDir(plan.StampDir); err != nil {
-		return 1, err
-	}
-
-	var lock *state.Lock
-	if mode.LockStamps {
-		// Prevent concurrent stamp mutation for the container.
-		lock, err = state.LockFile(state.StampsLockPath(plan.Home))
-		if err != nil {
-			return 1, fmt.Errorf("lock stamps: %w", err)
-		}
-		// Intent: Preserve close errors from deferred lock release so decomk never
-		// drops lock lifecycle failures.
-		// Source: DI-golak (TODO-gamuz)
-		defer func() {
-			if lock == nil {
-				return
-			}
-			if closeErr := lock.Close(); closeErr != nil {
-				wrapped := fmt.Errorf("close stamps lock: %w", closeErr)
-				if retErr == nil {
-					retErr = wrapped
-					if exitCode == 0 {
-						exitCode = 1
-					}
-					return
-				}
-				retErr = errors.Join(retErr, wrapped)
-			}
-		}()
-
-		// Normalize mtime semantics once per invocation.
-		if err := state.TouchExistingStamps(plan.StampDir, time.Now()); err != nil {
-			return 1, fmt.Errorf("touch stamps: %w", err)
-		}
-	}
-
-	if mode.WriteEnv {
-		if err := writeEnvFile(plan.EnvFile, plan, cookedTuples); err != nil {
-			return 1, err
-		}
+		return nil, err
 	}
+	graph := buildTargetGraph(rules, targets)
+	return &graph, nil
+}
 
-	makeTuples, makeEnv := makeInvocation(incomingEnvList, cookedTuples)
-
-	out := stdout
-	errOut := stderr
-	var runLogPath string
-	var logFile *os.File
-	if mode.Log {
-		// Include sub-second resolution and pid to avoid collisions when two runs start
-		// close together (otherwise one run can clobber the other's log output).
-		runID := time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + strconv.Itoa(os.Getpid())
-		runLogDir, err := createRunLogDir(plan, runID, stderr)
-		if err != nil {
-			return 1, err
-		}
-		runLogPath = filepath.Join(runLogDir, "make.log")
-		logFile, err = os.OpenFile(runLogPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
-		if err != nil {
-			return 1, err
-		}
-		// Intent: Preserve deferred log close failures so successful runs don't hide
-		// file descriptor or fs-sync problems in audit logs.
-		// Source: DI-golak (TODO-gamuz)
-		defer func() {
-			if logFile == nil {
-				return
-			}
-			if closeErr := logFile.Close(); closeErr != nil {
-				wrapped := fmt.Errorf("close run log file %s: %w", runLogPath, closeErr)
-				if retErr == nil {
-					retErr = wrapped
-					if exitCode == 0 {
-						exitCode = 1
-					}
-					return
-				}
-				retErr = errors.Join(retErr, wrapped)
-			}
-		}()
-
-		out = io.MultiWriter(stdout, logFile)
-		errOut = io.MultiWriter(stderr, logFile)
-	}
+// runTmpVar names the per-run scratch directory decomk passes to make.
+const runTmpVar = "DECOMK_RUN_TMP"
 
-	// Makefile recipes that drop privileges (runuser/su) typically need a
-	// non-empty username. Warn early if we can't determine it so users aren't
-	// surprised by a confusing "unknown user" failure during make.
-	//
-	// Intent: Provide a clear, early signal when user-scoped recipe patterns are
-	// likely to fail in root-make mode.
-	// Source: DI-lafib (TODO-jirin)
-	remoteUser := resolveRemoteUser()
-	if remoteUser == "" {
-		if err := writeLine(errOut, "decomk: warning: DECOMK_REMOTE_USER is empty; Makefile recipes that drop privileges (runuser/su) may fail"); err != nil {
-			return 1, err
-		}
+// createRunTmpDir creates a unique per-run scratch directory under
+// <DECOMK_HOME>/tmp.
+//
+// The directory is world-writable and sticky, like /tmp, so recipes that drop
+// privileges to the remote user can still use it.
+//
+// Intent: Give recipes a private, automatically cleaned scratch dir instead
+// of littering /tmp and colliding when two runs overlap.
+// Source: DI-mukaj (TODO-jirin)
+func createRunTmpDir(home string) (string, error) {
+	root := state.RunTmpRoot(home)
+	if err := state.EnsureDir(root); err != nil {
+		return "", err
 	}
-
-	if mode.DryRun {
-		if err := writeLine(stdout); err != nil {
-			return 1, err
-		}
-		if err := writeLine(stdout, "make -n output:"); err != nil {
-			return 1, err
-		}
+	dir, err := os.MkdirTemp(root, "run-")
+	if err != nil {
+		return "", fmt.Errorf("create run tmp dir: %w", err)
 	}
-
-	makeArgv := buildMakeArgv(makeCmd, mode.MakeFlags, plan.Makefile, makeTuples, targets)
-	// Intent: Print the exact argv decomk is about to execute so operators can
-	// see/copy the concrete make invocation without reverse-engineering tuple and
-	// target ordering from code or logs.
-	// Source: DI-sugit (TODO-jirin)
-	if err := writeLine(stdout, "make command:", shellJoinArgv(makeArgv)); err != nil {
-		return 1, err
+	if err := os.Chmod(dir, 0o777|os.ModeSticky); err != nil {
+		return "", fmt.Errorf("chmod run tmp dir %s: %w", dir, err)
 	}
+	return dir, nil
+}
 
-	exitCode, runErr := makeexec.RunWithFlagsCommand(plan.StampDir, plan.Makefile, makeCmd, mode.MakeFlags, makeTuples, targets, makeEnv, out, errOut)
-	if !mode.DryRun {
-		// Intent: Use DECOMK_STAGE0_PHASE as the single phase source and let
-		// DECOMK_MOTD_PHASES decide whether/how that phase maps to a MOTD file.
-		// Source: DI-tuhul (TODO-mirut)
-		phase := strings.TrimSpace(os.Getenv("DECOMK_STAGE0_PHASE"))
-		if motdErr := writePhaseMotdSummary(plan, cookedTuples, targets, phase, exitCode, runErr, runLogPath); motdErr != nil {
-			if warnErr := writeLine(errOut, "decomk: warning:", motdErr.Error()); warnErr != nil {
-				return 1, warnErr
-			}
-		}
+// finishRunTmpDir removes a per-run scratch directory, or reports where it was
+// kept when keep is set.
+func finishRunTmpDir(dir string, keep bool, w io.Writer) error {
+	if keep {
+		return writeFormat(w, "decomk: kept %s for debugging: %s\n", runTmpVar, dir)
 	}
-	if runErr != nil {
-		if runLogPath != "" {
-			return exitCode, fmt.Errorf("make failed (exit %d); log: %s: %w", exitCode, runLogPath, runErr)
-		}
-		return exitCode, fmt.Errorf("make failed (exit %d): %w", exitCode, runErr)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("remove run tmp dir %s: %w", dir, err)
 	}
-	return 0, nil
+	return nil
 }
 
 // printPlan prints the human-readable plan header and resolved argv pieces.
@@ -602,11 +749,51 @@ func printPlan(w io.Writer, plan *resolvedPlan, actionArgs, targets []string, ta
 			return err
 		}
 	}
+	for _, overlay := range plan.WorkspaceConfigs {
+		if overlay.Skipped != "" {
+			if err := writeFormat(w, "workspaceConfig: %s skipped: %s\n", overlay.Path, overlay.Skipped); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeFormat(w, "workspaceConfig: %s (context %s)\n", overlay.Path, overlay.Key); err != nil {
+			return err
+		}
+	}
+	for _, path := range plan.DevcontainerFiles {
+		if err := writeFormat(w, "devcontainerEnv: %s\n", path); err != nil {
+			return err
+		}
+	}
+	for _, path := range plan.DotenvPaths {
+		if err := writeFormat(w, "dotenv: %s\n", path); err != nil {
+			return err
+		}
+	}
 	if len(plan.ContextKeys) > 0 {
 		if err := writeFormat(w, "contexts: %s\n", strings.Join(plan.ContextKeys, " ")); err != nil {
 			return err
 		}
 	}
+	for _, seed := range plan.Seeds {
+		if err := writeFormat(w, "context seed (%s): %s: %s\n", seed.Source, seed.Key, seed.Reason); err != nil {
+			return err
+		}
+	}
+	for _, cond := range plan.Conditions {
+		in := "seed"
+		if len(cond.Path) > 0 {
+			in = cond.Path[len(cond.Path)-1]
+		}
+		if err := writeFormat(w, "condition (%s): %s => %t\n", in, cond.Token, cond.Passed); err != nil {
+			return err
+		}
+	}
+	if plan.Profile != "" {
+		if err := writeFormat(w, "profile: %s\n", plan.Profile); err != nil {
+			return err
+		}
+	}
 	if err := writeFormat(w, "config: %s\n", strings.Join(plan.ConfigPaths, ", ")); err != nil {
 		return err
 	}
@@ -624,8 +811,65 @@ func printPlan(w io.Writer, plan *resolvedPlan, actionArgs, targets []string, ta
 	if err := writeFormat(w, "targetSource: %s\n", targetSource); err != nil {
 		return err
 	}
-	if plan.Makefile != "" {
-		if err := writeFormat(w, "makefile: %s\n", plan.Makefile); err != nil {
+	for _, makefile := range plan.Makefiles {
+		if err := writeFormat(w, "makefile: %s\n", makefile); err != nil {
+			return err
+		}
+	}
+	for _, ns := range plan.Namespaces {
+		if err := writeFormat(w, "makefile (namespace %s/): %s\n", ns.Name, ns.Path); err != nil {
+			return err
+		}
+	}
+	for _, ft := range plan.FileTuples {
+		if err := writeFormat(w, "file (%s): %s (%d bytes)\n", ft.Name, ft.Path, ft.Size); err != nil {
+			return err
+		}
+	}
+	for _, et := range plan.EnvTuples {
+		source := et.Var
+		if et.Defaulted {
+			source += " (unset; default used)"
+		}
+		if err := writeFormat(w, "env (%s): %s\n", et.Name, source); err != nil {
+			return err
+		}
+	}
+	for _, run := range plan.ExecTuples {
+		if err := writeFormat(w, "exec (%s): %s\n", run.Name, run.Command); err != nil {
+			return err
+		}
+	}
+	for _, plugin := range plan.Plugins {
+		provides := "command only"
+		if len(plugin.Provides) > 0 {
+			provides = "command, " + strings.Join(plugin.Provides, ", ")
+		}
+		if err := writeFormat(w, "plugin (%s): %s [%s]\n", plugin.Name, plugin.Path, provides); err != nil {
+			return err
+		}
+	}
+	for _, pv := range plan.PluginVars {
+		if err := writeFormat(w, "var plugin (%s): %s\n", pv.Name, pv.Plugin); err != nil {
+			return err
+		}
+	}
+	for _, d := range plan.TargetDirs {
+		if err := writeFormat(w, "target dir (%s): %s\n", d.Target, d.Dir); err != nil {
+			return err
+		}
+	}
+	telemetryURL, err := telemetryEndpoint(effectiveTupleValues(plan.Tuples), os.Getenv)
+	if err != nil {
+		return err
+	}
+	if telemetryURL != "" {
+		if err := writeFormat(w, "telemetry: %s\n", telemetryURL); err != nil {
+			return err
+		}
+	}
+	for _, extra := range plan.ExtraMakefiles {
+		if err := writeFormat(w, "makefile (generated): %s\n", extra); err != nil {
 			return err
 		}
 	}
@@ -636,7 +880,19 @@ func printPlan(w io.Writer, plan *resolvedPlan, actionArgs, targets []string, ta
 	if err := writeLine(w, "tuples:"); err != nil {
 		return err
 	}
-	for _, t := range plan.Tuples {
+	for _, t := range redactSecretTuples(plan.Tuples, plan.Secrets) {
+		if name, _, ok := resolve.SplitTuple(t); ok && plan.Secrets[name] != "" {
+			if err := writeFormat(w, "  %s  (secret)\n", t); err != nil {
+				return err
+			}
+			continue
+		}
+		if name, _, ok := resolve.SplitTuple(t); ok && plan.TupleClasses[name] != "" {
+			if err := writeFormat(w, "  %s  (%s)\n", t, plan.TupleClasses[name]); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := writeFormat(w, "  %s\n", t); err != nil {
 			return err
 		}
@@ -654,6 +910,47 @@ func printPlan(w io.Writer, plan *resolvedPlan, actionArgs, targets []string, ta
 			return err
 		}
 	}
+	if plan.ExpandTrace != nil {
+		if err := printExpandTrace(w, plan.ExpandTrace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printExpandTrace prints one line per expanded token with the chain of keys
+// it was expanded through, outermost first.
+//
+// Intent: Let users see exactly how DEFAULT -> Block10 -> FOO=x produced the
+// final make argv when debugging macro layering, inheritance, and
+// conditionals.
+// Source: DI-zigaz (TODO-jirin)
+func printExpandTrace(w io.Writer, trace *expand.Trace) error {
+	if err := writeLine(w, "expansion trace:"); err != nil {
+		return err
+	}
+	for _, entry := range trace.Entries {
+		path := "(seed)"
+		if len(entry.Path) > 0 {
+			path = strings.Join(entry.Path, " > ")
+		}
+		if err := writeFormat(w, "  %s <- %s\n", entry.Token, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeExpandTrace writes trace as indented JSON to path.
+func writeExpandTrace(path string, trace *expand.Trace) error {
+	content, err := json.MarshalIndent(trace, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode expansion trace: %w", err)
+	}
+	content = append(content, '\n')
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return fmt.Errorf("write expansion trace %s: %w", path, err)
+	}
 	return nil
 }
 
@@ -892,10 +1189,7 @@ func phaseFallbackMotdPath(home, filename string) string {
 // where /etc/motd.d cannot be updated directly.
 // Source: DI-tuhul (TODO-mirut)
 func writeRunMotdFallback(fallbackPath string, body []byte) (string, error) {
-	if err := state.EnsureParentDir(fallbackPath); err != nil {
-		return fallbackPath, fmt.Errorf("ensure fallback parent dir %s: %w", filepath.Dir(fallbackPath), err)
-	}
-	if err := os.WriteFile(fallbackPath, body, 0o644); err != nil {
+	if err := state.AtomicWrite(fallbackPath, body, 0o644); err != nil {
 		return fallbackPath, fmt.Errorf("write fallback MOTD summary %s: %w", fallbackPath, err)
 	}
 	return fallbackPath, nil
@@ -1156,7 +1450,7 @@ const defaultWorkspacesDir = "/workspaces"
 const (
 	// tuplePassThroughValue is the reserved tuple value that requests environment
 	// pass-through resolution (for example `BAX=$`).
-	tuplePassThroughValue = "$"
+	tuplePassThroughValue = resolve.PassThroughValue
 
 	// autoPassThroughPrefix is the env-var namespace that decomk automatically
 	// carries into env.sh/make, in addition to resolved config tuples.
@@ -1170,6 +1464,19 @@ const (
 	// decomk-version MOTD file in addition to the runtime phase summary.
 	motdVersionPhase = "version"
 
+	// makefilesTuple is the tuple name listing configured makefiles in "-f"
+	// order (see resolveMakefiles).
+	makefilesTuple = "DECOMK_MAKEFILES"
+
+	// inheritMakeflagsEnv, when non-empty in decomk's environment, keeps an
+	// inherited MAKEFLAGS/MAKELEVEL/MFLAGS in the env passed to make (see
+	// makeInvocation).
+	inheritMakeflagsEnv = "DECOMK_INHERIT_MAKEFLAGS"
+
+	// pathPrependTuple is the tuple name whose directories decomk prepends to
+	// the effective PATH (see managedPathTuples).
+	pathPrependTuple = "DECOMK_PATH_PREPEND"
+
 	// runMotdFallbackRelDir is the fallback directory (under DECOMK_HOME) used
 	// when /etc/motd.d is not writable.
 	runMotdFallbackRelDir = "stage0/failure"
@@ -1227,16 +1534,57 @@ func resolveLogRoot(flagOverride string) (logRoot string, explicit bool, err err
 //   - flagOverride (if non-empty)
 //   - DECOMK_WORKSPACES_DIR
 //   - /workspaces
+//
+// Under WSL a Windows drive path (C:\src) names its /mnt/<drive> mount (see
+// wslHostPath).
 func resolveWorkspacesDir(flagOverride string) string {
 	if flagOverride != "" {
-		return flagOverride
+		return wslHostPath(flagOverride)
 	}
 	if env := os.Getenv("DECOMK_WORKSPACES_DIR"); env != "" {
-		return env
+		return wslHostPath(env)
 	}
 	return defaultWorkspacesDir
 }
 
+// resolveConfDir determines the directory inside the config repo clone that
+// holds decomk.conf and the default Makefile.
+//
+// Precedence:
+//   - flagOverride (if non-empty)
+//   - DECOMK_CONF_PATH
+//   - "" (the clone root, <DECOMK_HOME>/conf)
+//
+// The subdirectory must be relative and must stay inside the clone.
+//
+// Intent: Support infrastructure monorepos that keep decomk config as one
+// directory instead of requiring a dedicated config repo.
+// Source: DI-hilut (TODO-jirin)
+func resolveConfDir(home, flagOverride string) (string, error) {
+	return resolveConfDirIn(state.ConfDir(home), flagOverride)
+}
+
+// resolveConfDirIn is resolveConfDir for a config repo rooted at root.
+func resolveConfDirIn(root, flagOverride string) (string, error) {
+	confPath := flagOverride
+	label := "flag -conf-path"
+	if confPath == "" {
+		confPath = os.Getenv("DECOMK_CONF_PATH")
+		label = "DECOMK_CONF_PATH"
+	}
+	if confPath == "" {
+		return root, nil
+	}
+	if filepath.IsAbs(confPath) {
+		return "", fmt.Errorf("%s must be relative to the config repo (got %q)", label, confPath)
+	}
+	clean := filepath.Clean(confPath)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s must stay inside the config repo (got %q)", label, confPath)
+	}
+	return filepath.Join(root, clean), nil
+}
+
 // resolvePlanFromFlags builds a single fully-resolved plan from the user-facing
 // flags.
 //
@@ -1259,103 +1607,359 @@ func resolvePlanFromFlags(f commonFlags) (*resolvedPlan, error) {
 		return nil, err
 	}
 
-	workspacesDir := resolveWorkspacesDir(f.workspacesDir)
-
-	// Intent: Keep decomk core deterministic by resolving/running from existing
-	// local state only. Stage-0 lifecycle tooling (for example postCreate hooks)
-	// is responsible for tool/config clone-pull bootstrap.
-	// Source: DI-lipat (TODO-fuviv)
-	explicitConfig := f.config
-	if explicitConfig == "" {
-		explicitConfig = os.Getenv("DECOMK_CONFIG")
-	}
-	if explicitConfig != "" {
-		abs, err := filepath.Abs(explicitConfig)
-		if err != nil {
-			return nil, fmt.Errorf("abs config path %q: %w", explicitConfig, err)
-		}
-		explicitConfig = abs
+	if f.profile != "" {
+		return replayProfilePlan(f, home, logRoot, logRootExplicit)
 	}
 
-	defs, configPaths, err := loadDefs(home, explicitConfig)
+	plan := &resolvedPlan{
+		Home:            home,
+		LogRoot:         logRoot,
+		LogRootExplicit: logRootExplicit,
+		StampDir:        state.StampDir(home),
+		EnvFile:         state.EnvFile(home),
+	}
+	explicitConfig, err := plan.loadConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := plan.selectContexts(f); err != nil {
+		return nil, err
+	}
+	rest, err := plan.expandContexts(f)
+	if err != nil {
+		return nil, err
+	}
+	// Config-relative files (@file: tuples and tmpl: templates) resolve
+	// against the explicit config file's directory when there is one.
+	configDir := plan.ConfDir
+	if explicitConfig != "" {
+		configDir = filepath.Dir(explicitConfig)
+	}
+	if err := plan.resolveTuples(f, rest, configDir); err != nil {
+		return nil, err
+	}
+	if err := plan.resolveMakefiles(f, explicitConfig, configDir); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// replayProfilePlan is resolvePlanFromFlags for -profile: it loads the saved
+// plan and reruns the plugins.
+func replayProfilePlan(f commonFlags, home, logRoot string, logRootExplicit bool) (*resolvedPlan, error) {
+	if f.traceExpand {
+		return nil, fmt.Errorf("-trace-expand cannot be used with -profile (a profile stores already-expanded tokens)")
+	}
+	plan, err := resolvePlanFromProfile(home, logRoot, logRootExplicit, f.profile, resolveAgeIdentity(f.ageIdentity))
 	if err != nil {
 		return nil, err
 	}
+	if f.inert {
+		return plan, nil
+	}
+	// Plugins and var plugins describe the machine, like computedVars,
+	// so replay rediscovers and reruns them instead of saving them.
+	plugins, err := discoverPlugins(state.PluginsDir(home), os.Getenv("PATH"))
+	if err != nil {
+		return nil, err
+	}
+	pluginVars, pluginWarnings, err := runVarPlugins(home, effectiveTupleValues(plan.Tuples)[varPluginsTuple], execTuplesDisabled(f.noExecTuples), plugins)
+	if err != nil {
+		return nil, err
+	}
+	plan.Plugins, plan.PluginVars = plugins, pluginVars
+	plan.Warnings = append(plan.Warnings, pluginWarnings...)
+	return plan, nil
+}
+
+// loadConfig installs the config repo from -conf-src/-conf-oci when one is
+// set, then loads the config into plan. It returns the absolute -config
+// (DECOMK_CONFIG) path, or "" when none is set.
+func (plan *resolvedPlan) loadConfig(f commonFlags) (string, error) {
+	// Intent: Keep decomk core deterministic by resolving/running from existing
+	// local state only. Stage-0 lifecycle tooling (for example postCreate hooks)
+	// is responsible for tool/config clone-pull bootstrap.
+	// Source: DI-lipat (TODO-fuviv)
+	explicitConfig := f.config
+	if explicitConfig == "" {
+		explicitConfig = os.Getenv("DECOMK_CONFIG")
+	}
+	if explicitConfig != "" {
+		abs, err := filepath.Abs(explicitConfig)
+		if err != nil {
+			return "", fmt.Errorf("abs config path %q: %w", explicitConfig, err)
+		}
+		explicitConfig = abs
+	}
+
+	confSrc := f.confSrc
+	if confSrc == "" {
+		confSrc = os.Getenv("DECOMK_CONF_SRC")
+	}
+	confOCI := f.confOCI
+	if confOCI == "" {
+		confOCI = os.Getenv("DECOMK_CONF_OCI")
+	}
+	confRoot := f.confRoot
+	if confRoot == "" {
+		confRoot = state.ConfDir(plan.Home)
+		client := &http.Client{Timeout: confTarballTimeout}
+		switch {
+		case confSrc != "" && confOCI != "":
+			return "", fmt.Errorf("-conf-src (DECOMK_CONF_SRC) and -conf-oci (DECOMK_CONF_OCI) cannot both be set")
+		case confSrc != "":
+			if err := installConfTarball(client, plan.Home, confSrc); err != nil {
+				return "", err
+			}
+		case confOCI != "":
+			if err := installConfOCI(client, plan.Home, confOCI); err != nil {
+				return "", err
+			}
+		}
+	}
+	confDir, err := resolveConfDirIn(confRoot, f.confPath)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := cachedLoadDefs(plan.Home, confDir, explicitConfig)
+	if err != nil {
+		return "", err
+	}
+	plan.ConfDir = confDir
+	plan.Defs, plan.Recipes, plan.Docs, plan.Tags, plan.ConfigPaths = cfg.Defs, cfg.Recipes, cfg.Docs, cfg.Tags, cfg.Paths
+	plan.Allow, plan.LowerTuples = cfg.Defs[contexts.AllowKey], cfg.LowerTuples
+	return explicitConfig, nil
+}
 
+// selectContexts picks the context keys to expand: the explicit context, or
+// the keys matching the discovered workspaces plus the DEFAULT of any
+// trusted workspace config overlay, which it merges into plan's definitions.
+func (plan *resolvedPlan) selectContexts(f commonFlags) error {
 	// If the user explicitly sets a context, do not scan workspaces.
 	explicitContext := f.context
 	if explicitContext == "" {
 		explicitContext = os.Getenv("DECOMK_CONTEXT")
 	}
-	var (
-		workspaceRepos []workspaceRepo
-		contextKeys    []string
-	)
+	var contextSeeds []contextSeed
 	if explicitContext != "" {
-		key, err := selectContextKey(defs, explicitContext)
+		key, err := selectContextKey(plan.Defs, explicitContext)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if f.context != "" {
+			contextSeeds = []contextSeed{{Key: key, Source: seedSourceFlag, Reason: "-context " + key}}
+		} else {
+			contextSeeds = []contextSeed{{Key: key, Source: seedSourceEnv, Reason: "DECOMK_CONTEXT=" + key}}
 		}
-		contextKeys = []string{key}
 	} else {
-		workspaceRepos, err = discoverWorkspaces(workspacesDir)
-		if err != nil {
-			return nil, err
+		workspaceRepos := f.workspaceList
+		if workspaceRepos == nil {
+			var err error
+			workspaceRepos, err = cachedDiscoverWorkspaces(plan.Home, resolveWorkspacesDir(f.workspacesDir))
+			if err != nil {
+				return err
+			}
+		}
+		plan.WorkspaceRepos = workspaceRepos
+		contextSeeds = workspaceContextSeeds(plan.Defs, workspaceRepos)
+		if owners := resolveWorkspaceConfigOwners(f.workspaceConfigOwners); len(owners) > 0 {
+			defs, recipes, _, workspaceConfigs, err := applyWorkspaceConfigs(plan.Defs, plan.Recipes, workspaceRepos, owners)
+			if err != nil {
+				return err
+			}
+			plan.Defs, plan.Recipes, plan.WorkspaceConfigs = defs, recipes, workspaceConfigs
+			for _, overlay := range workspaceConfigs {
+				plan.LowerTuples = mergeLowerTuples(plan.LowerTuples, overlay.LowerTuples)
+				if overlay.Key != "" {
+					plan.ConfigPaths = append(plan.ConfigPaths, overlay.Path)
+					contextSeeds = append(contextSeeds, contextSeed{Key: overlay.Key, Source: seedSourceWorkspaceConfig, Reason: "DEFAULT of " + overlay.Path})
+				}
+			}
 		}
-		contextKeys = contextKeysForWorkspaces(defs, workspaceRepos)
 	}
 
-	seed := seedTokensForContexts(defs, contextKeys)
-	expanded, err := expand.ExpandTokens(expand.Defs(defs), seed, expand.Options{MaxDepth: f.maxExpDepth})
+	plan.Seeds = seedContexts(plan.Defs, contextSeeds, f.noDefault || os.Getenv("DECOMK_NO_DEFAULT") != "")
+	if len(plan.Seeds) == 0 {
+		return fmt.Errorf("no context to expand: DEFAULT is left out (-no-default/DECOMK_NO_DEFAULT) and no -context, DECOMK_CONTEXT, or workspace context matched")
+	}
+	plan.ContextKeys = seedKeys(plan.Seeds)
+	return nil
+}
+
+// expandContexts expands plan's context keys and pulls out the toolchain and
+// template tokens, returning the remaining tokens.
+func (plan *resolvedPlan) expandContexts(f commonFlags) ([]string, error) {
+	opts := expand.Options{
+		MaxDepth:   f.maxExpDepth,
+		MaxTokens:  f.maxExpTokens,
+		WarnTokens: f.warnExpTokens,
+		Warn:       func(msg string) { plan.Warnings = append(plan.Warnings, msg) },
+		// Conditions may test the platform, but nothing else computed per
+		// run: the rest depends on the expansion.
+		Values:     platformVars(),
+		Conditions: &plan.Conditions,
+	}
+	if f.traceExpand {
+		opts.Trace = &expand.Trace{}
+		plan.ExpandTrace = opts.Trace
+	}
+	expanded, err := expand.ExpandTokens(expand.Defs(plan.Defs), plan.ContextKeys, opts)
+	if err != nil {
+		return nil, err
+	}
+	plan.Expanded = expanded
+	if f.reportUnused || os.Getenv("DECOMK_REPORT_UNUSED") != "" {
+		plan.Warnings = append(plan.Warnings, unusedKeyWarnings(plan.Defs, plan.ContextKeys)...)
+	}
+	// Intent: Pull version-manager tokens out before tuple partitioning so they
+	// become generated install targets and env tuples instead of being rejected
+	// as bare targets.
+	// Source: DI-zisok (TODO-jirin)
+	toolchains, rest, err := toolchain.Extract(expanded)
 	if err != nil {
 		return nil, err
 	}
-	tuples, targets := resolve.Partition(expanded)
+	templates, rest, err := recipetmpl.Extract(rest)
+	if err != nil {
+		return nil, err
+	}
+	plan.Toolchains, plan.Templates = toolchains, templates
+	return rest, nil
+}
+
+// resolveTuples layers the .env and devcontainer tuples under the expanded
+// tokens rest, then resolves the tuples' @file:, @env:, $(exec:...) and
+// age-encrypted values and runs the var plugins.
+func (plan *resolvedPlan) resolveTuples(f commonFlags, rest []string, configDir string) error {
+	// Intent: Put .env tuples ahead of every config token so any decomk.conf
+	// assignment of the same name wins.
+	// Source: DI-rifad (TODO-jirin)
+	dotenvPaths, err := resolveDotenvPaths(f.dotenv, plan.WorkspaceRepos)
+	if err != nil {
+		return err
+	}
+	dotenvTokens, err := loadDotenvTuples(dotenvPaths)
+	if err != nil {
+		return err
+	}
+	plan.DotenvPaths = dotenvPaths
+	// Intent: Layer devcontainer env below .env, so per-developer values beat
+	// the repo's shared devcontainer.json and config beats both.
+	// Source: DI-gimon (TODO-jirin)
+	devcontainerTokens, devcontainerFiles, devcontainerWarnings, err := loadDevcontainerEnvTuples(plan.WorkspaceRepos, resolveDevcontainerEnvNames(f.devcontainerEnv), envMapFromList(os.Environ()))
+	if err != nil {
+		return err
+	}
+	plan.DevcontainerFiles = devcontainerFiles
+	plan.Warnings = append(plan.Warnings, devcontainerWarnings...)
+	rest = append(append(devcontainerTokens, dotenvTokens...), rest...)
+	rest, tupleClasses := resolve.StripClasses(rest)
+	tuples, targets := resolve.Partition(rest)
 	// Intent: Enforce tuple-only config output after macro expansion so target
 	// selection happens exclusively through explicit action args.
 	// Source: DI-gusab (TODO-takoh)
 	if len(targets) > 0 {
-		return nil, fmt.Errorf("invalid config: expanded non-tuple tokens %v; decomk.conf RHS tokens must be tuple assignments (NAME=value) or defined keys", targets)
+		return fmt.Errorf("invalid config: expanded non-tuple tokens %v; decomk.conf RHS tokens must be tuple assignments (NAME=value) or defined keys", targets)
 	}
-
-	stampDir := state.StampDir(home)
-	envFile := state.EnvFile(home)
-
-	makefile := f.makefile
-	if makefile != "" {
-		abs, err := filepath.Abs(makefile)
-		if err != nil {
-			return nil, fmt.Errorf("abs makefile path %q: %w", makefile, err)
+	// Read @file: and @env: values and run $(exec:...) commands after
+	// Partition so overridden assignments are never read or executed, and
+	// before anything below reads tuple values.
+	tuples, plan.FileTuples, err = resolveFileTuples(tuples, configDir)
+	if err != nil {
+		return err
+	}
+	for _, ft := range plan.FileTuples {
+		if tupleClasses[ft.Name] == "" {
+			tupleClasses[ft.Name] = resolve.ClassEnvOnly
 		}
-		makefile = abs
 	}
-	if makefile == "" {
-		makefile = findDefaultMakefile(home, explicitConfig)
+	tuples, plan.EnvTuples, err = resolveEnvTuples(tuples, envMapFromList(os.Environ()))
+	if err != nil {
+		return err
+	}
+	// Intent: Let update -check preview an unreviewed config commit without
+	// running, decrypting, or fetching anything that commit names.
+	// Source: DI-jipih (TODO-jirin)
+	if f.inert {
+		plan.TupleClasses = tupleClasses
+		plan.Tuples = tuples
+		return nil
+	}
+	tuples, plan.ExecTuples, err = evalExecTuples(tuples, execTuplesDisabled(f.noExecTuples))
+	if err != nil {
+		return err
+	}
+	tuples, plan.Secrets, err = decryptSecretTuples(tuples, resolveAgeIdentity(f.ageIdentity))
+	if err != nil {
+		return err
+	}
+	plan.TupleClasses = markSecretsEnvOnly(tupleClasses, plan.Secrets)
+	plan.Plugins, err = discoverPlugins(state.PluginsDir(plan.Home), os.Getenv("PATH"))
+	if err != nil {
+		return err
+	}
+	pluginVars, pluginWarnings, err := runVarPlugins(plan.Home, effectiveTupleValues(tuples)[varPluginsTuple], execTuplesDisabled(f.noExecTuples), plan.Plugins)
+	if err != nil {
+		return err
 	}
-	if makefile != "" {
-		abs, err := filepath.Abs(makefile)
+	plan.PluginVars = pluginVars
+	plan.Warnings = append(plan.Warnings, pluginWarnings...)
+	plan.Tuples = tuples
+	return nil
+}
+
+// resolveMakefiles adds the toolchain and template tuples to plan, renders
+// its templates, and resolves the configured makefiles, namespaces and
+// target dirs along with the generated makefiles they need.
+func (plan *resolvedPlan) resolveMakefiles(f commonFlags, explicitConfig, configDir string) error {
+	if len(plan.Toolchains) > 0 {
+		dataDir := func(manager string) string { return state.ToolchainDataDir(plan.Home, manager) }
+		pathPrepend := effectiveTupleValues(plan.Tuples)[pathPrependTuple]
+		plan.Tuples = append(plan.Tuples, toolchain.Tuples(plan.Toolchains, dataDir, pathPrepend)...)
+		plan.ExtraMakefiles = append(plan.ExtraMakefiles, state.ToolchainsMakefile(plan.Home))
+	}
+	// Intent: Include inline recipes as a generated fragment so one-liner targets
+	// are selectable like Makefile targets without editing the config repo.
+	// Source: DI-zafor (TODO-jirin)
+	if len(plan.Recipes) > 0 {
+		plan.ExtraMakefiles = append(plan.ExtraMakefiles, state.RecipesMakefile(plan.Home))
+	}
+	// Intent: Render template calls while resolving, so a missing template or
+	// wrong argument count fails plan before make runs.
+	// Source: DI-lubug (TODO-jirin)
+	if len(plan.Templates) > 0 {
+		templatesMakefile, err := recipetmpl.RenderMakefile(filepath.Join(configDir, recipetmpl.Dir), plan.Templates)
 		if err != nil {
-			return nil, fmt.Errorf("abs makefile path %q: %w", makefile, err)
+			return err
 		}
-		makefile = abs
+		plan.TemplatesMakefile = templatesMakefile
+		plan.Tuples = append(plan.Tuples, recipetmpl.Tuples(plan.Templates)...)
+		plan.ExtraMakefiles = append(plan.ExtraMakefiles, state.TemplatesMakefile(plan.Home))
 	}
-	if makefile != "" && !fileExists(makefile) {
-		return nil, fmt.Errorf("makefile not found: %s", makefile)
+	if len(makeOnlyNames(plan.TupleClasses)) > 0 {
+		plan.ExtraMakefiles = append(plan.ExtraMakefiles, state.UnexportMakefile(plan.Home))
 	}
 
-	return &resolvedPlan{
-		Home:            home,
-		LogRoot:         logRoot,
-		LogRootExplicit: logRootExplicit,
-		WorkspaceRepos:  workspaceRepos,
-		ContextKeys:     seed,
-		ConfigPaths:     configPaths,
-		StampDir:        stampDir,
-		EnvFile:         envFile,
-		Makefile:        makefile,
-		Expanded:        expanded,
-		Tuples:          tuples,
-	}, nil
+	remote := newRemoteMakefileFetcher(plan.Home)
+	remote.offline = f.inert
+	makefiles, namespaces, err := resolveMakefiles(f.makefile, effectiveTupleValues(plan.Tuples), plan.ConfDir, explicitConfig, remote)
+	if err != nil {
+		return err
+	}
+	plan.Makefiles, plan.Namespaces = makefiles, namespaces
+	if len(namespaces) > 0 {
+		plan.ExtraMakefiles = append(plan.ExtraMakefiles, state.NamespacesMakefile(plan.Home))
+	}
+	targetDirs, err := resolveTargetDirs(effectiveTupleValues(plan.Tuples)[targetDirsTuple], plan.WorkspaceRepos)
+	if err != nil {
+		return err
+	}
+	plan.TargetDirs = targetDirs
+	if len(targetDirs) > 0 {
+		plan.ExtraMakefiles = append(plan.ExtraMakefiles, state.TargetDirsMakefile(plan.Home))
+	}
+	return nil
 }
 
 // workspaceRepo describes a workspace repo directory that may drive context
@@ -1377,6 +1981,12 @@ type workspaceRepo struct {
 // identity selection; if git metadata is missing, decomk falls back to using the
 // directory basename as an identity hint.
 func discoverWorkspaces(workspacesDir string) ([]workspaceRepo, error) {
+	return discoverWorkspacesWith(workspacesDir, inspectWorkspaceRepo)
+}
+
+// discoverWorkspacesWith is discoverWorkspaces with inspect deriving each
+// workspace's identity from its root.
+func discoverWorkspacesWith(workspacesDir string, inspect func(root string) workspaceRepo) ([]workspaceRepo, error) {
 	if workspacesDir == "" {
 		workspacesDir = defaultWorkspacesDir
 	}
@@ -1398,7 +2008,7 @@ func discoverWorkspaces(workspacesDir string) ([]workspaceRepo, error) {
 			continue
 		}
 		root := filepath.Join(workspacesDir, name)
-		repos = append(repos, inspectWorkspaceRepo(root))
+		repos = append(repos, inspect(root))
 	}
 	sort.Slice(repos, func(i, j int) bool { return repos[i].Root < repos[j].Root })
 	return repos, nil
@@ -1452,11 +2062,16 @@ func inspectWorkspaceRepo(root string) workspaceRepo {
 //   - git@host:owner/repo(.git)
 //   - owner/repo(.git)
 //   - /some/path/to/repo(.git) (best-effort; last two path segments)
+//   - C:\some\path\to\repo(.git) (a Windows path, as from a WSL workspace
+//     on a Windows drive; treated like the path above)
 func parseOwnerRepo(originURL string) (ownerRepo, repoName string) {
 	s := strings.TrimSpace(originURL)
 	if s == "" {
 		return "", ""
 	}
+	if translated, ok := windowsPathToWSL(s, defaultWSLMountRoot); ok {
+		s = translated
+	}
 	s = strings.TrimSuffix(s, ".git")
 
 	// Handle scp-like syntax: git@host:owner/repo
@@ -1507,64 +2122,146 @@ func gitOutput(dir string, args ...string) (string, error) {
 //  1. config repo decomk.conf (lowest; optional)
 //  2. explicit -config / DECOMK_CONFIG (highest; optional)
 //
+// (loadDefsWithLocal adds the local override file between the two.)
+//
+// The highest-precedence source is the root config: only it may set
+// DECOMK_ALLOW, and when it does, every lower source may define only the keys
+// and recipes the root defines or the allow-list matches (see
+// checkLayerAllowed).
+//
 // Each source is loaded via contexts.LoadTree so it can also include a sibling
-// decomk.d/*.conf directory.
-func loadDefs(home, explicitConfig string) (defs contexts.Defs, paths []string, err error) {
-	// Precedence: config repo (lowest) -> explicit override (highest).
-	var sources []string
+// decomk.d/*.conf directory. Inline recipes and key docs follow the same
+// precedence; tags from every source are combined. The result's Paths are the
+// sources loaded; its Merges is nil, since merge modes only matter while
+// layering.
+//
+// confDir is the config repo directory holding decomk.conf (see
+// resolveConfDir).
+func loadDefs(confDir, explicitConfig string) (contexts.Config, error) {
+	return loadDefsWithLocal(confDir, "", explicitConfig)
+}
+
+// loadDefsWithLocal is loadDefs with the local override file at localConfig
+// (see state.LocalConfigPath) layered between the config repo and the
+// explicit config. It is skipped when localConfig is empty or missing.
+//
+// Local keys append to the keys built from the config repo unless they
+// declare a merge mode, so `DEFAULT: EDITOR=nvim` overrides one tuple rather
+// than the whole DEFAULT; local modes do not carry over to the explicit
+// config. The local file is never the root config (see checkLayerAllowed).
+//
+// Intent: Persist small personal tweaks without editing shared repos, while
+// an explicit -config keeps the last word.
+// Source: DI-fafuh (TODO-jirin)
+func loadDefsWithLocal(confDir, localConfig, explicitConfig string) (contexts.Config, error) {
+	// Precedence: config repo (lowest) -> local -> explicit override (highest).
+	type source struct {
+		path  string
+		local bool
+	}
+	var sources []source
 
-	if configRepo, ok := configRepoConfigPath(home); ok {
-		sources = append(sources, configRepo)
+	if configRepo, ok := configRepoConfigPath(confDir); ok {
+		sources = append(sources, source{path: configRepo})
+	}
+
+	if localConfig != "" && fileExists(localConfig) {
+		sources = append(sources, source{path: localConfig, local: true})
 	}
 
 	if explicitConfig != "" {
 		if !fileExists(explicitConfig) {
-			return nil, nil, fmt.Errorf("config file not found: %s", explicitConfig)
+			return contexts.Config{}, fmt.Errorf("config file not found: %s", explicitConfig)
 		}
-		sources = append(sources, explicitConfig)
+		sources = append(sources, source{path: explicitConfig})
 	}
 
-	if len(sources) == 0 {
-		tried := append([]string(nil), configRepoConfigCandidates(home)...)
-		return nil, nil, fmt.Errorf("no config found; tried %s; set -config/DECOMK_CONFIG or populate %s", strings.Join(tried, ", "), filepath.Join(state.ConfDir(home), "decomk.conf"))
+	if len(sources) == 0 || (len(sources) == 1 && sources[0].local) {
+		tried := append([]string(nil), configRepoConfigCandidates(confDir)...)
+		return contexts.Config{}, fmt.Errorf("no config found; tried %s; set -config/DECOMK_CONFIG or populate %s", strings.Join(tried, ", "), filepath.Join(confDir, "decomk.conf"))
 	}
 
 	// Load lowest-precedence first.
-	defs = make(contexts.Defs)
-	for _, p := range sources {
-		tree, e := contexts.LoadTree(p)
-		if e != nil {
-			return nil, nil, e
+	cfg := contexts.Config{
+		Defs:    make(contexts.Defs),
+		Recipes: make(contexts.Recipes),
+		Docs:    make(contexts.Docs),
+		Tags:    make(contexts.Tags),
+	}
+	merges := make(contexts.Merges)
+	type layer struct {
+		path    string
+		defs    contexts.Defs
+		recipes contexts.Recipes
+	}
+	var root layer
+	var lowers []layer
+	for _, src := range sources {
+		tree, err := contexts.LoadTree(src.path)
+		if err != nil {
+			return contexts.Config{}, err
+		}
+		if src.local {
+			localMerges := make(contexts.Merges, len(tree.Defs))
+			for key := range tree.Defs {
+				localMerges[key] = contexts.MergeAppend
+			}
+			cfg.Defs = contexts.Merge(cfg.Defs, tree.Defs, contexts.MergeMerges(localMerges, tree.Merges))
+			lowers = append(lowers, layer{path: src.path, defs: tree.Defs, recipes: tree.Recipes})
+		} else {
+			merges = contexts.MergeMerges(merges, tree.Merges)
+			cfg.Defs = contexts.Merge(cfg.Defs, tree.Defs, merges)
+			if root.path != "" {
+				lowers = append(lowers, root)
+			}
+			root = layer{path: src.path, defs: tree.Defs, recipes: tree.Recipes}
+		}
+		cfg.Recipes = contexts.MergeRecipes(cfg.Recipes, tree.Recipes)
+		cfg.Docs = contexts.MergeDocs(cfg.Docs, tree.Docs)
+		cfg.Tags = contexts.MergeTags(cfg.Tags, tree.Tags)
+		cfg.Paths = append(cfg.Paths, tree.Paths...)
+	}
+	for _, lower := range lowers {
+		if _, ok := lower.defs[contexts.AllowKey]; ok {
+			return contexts.Config{}, fmt.Errorf("%s: %s may only be set in the root config %s", lower.path, contexts.AllowKey, root.path)
+		}
+		if allow, ok := root.defs[contexts.AllowKey]; ok {
+			if err := checkLayerAllowed(allow, lower.path, lower.defs, lower.recipes, root.defs, root.recipes); err != nil {
+				return contexts.Config{}, err
+			}
+			if err := checkLayerTokensAllowed(allow, lower.path, lower.defs, root.defs); err != nil {
+				return contexts.Config{}, err
+			}
+			cfg.LowerTuples = mergeLowerTuples(cfg.LowerTuples, lowerTuples(lower.defs, root.defs))
 		}
-		defs = contexts.Merge(defs, tree)
 	}
 	// Intent: Keep decomk.conf tuple-only by requiring every bare RHS token to be
 	// a defined key, so config files cannot accidentally smuggle literal targets.
 	// Source: DI-gusab (TODO-takoh)
-	if err := contexts.ValidateRefs(defs); err != nil {
-		return nil, nil, err
+	if err := contexts.ValidateRefs(cfg.Defs); err != nil {
+		return contexts.Config{}, err
 	}
 
-	paths = append([]string(nil), sources...)
-	return defs, paths, nil
+	return cfg, nil
 }
 
 // configRepoConfigCandidates returns candidate decomk.conf paths inside the
 // config repo clone.
 //
-// The config repo is expected to keep decomk.conf at the root of the clone
-// directory (<DECOMK_HOME>/conf/decomk.conf). decomk intentionally does not
-// search alternate layouts (for example a nested etc/ directory) so that the
-// precedence model stays simple and predictable.
-func configRepoConfigCandidates(home string) []string {
+// The config repo is expected to keep decomk.conf at the root of confDir: the
+// clone directory (<DECOMK_HOME>/conf/decomk.conf), or the explicit
+// -conf-path subdirectory. decomk intentionally does not search alternate
+// layouts (for example a nested etc/ directory) so that the precedence model
+// stays simple and predictable.
+func configRepoConfigCandidates(confDir string) []string {
 	return []string{
-		filepath.Join(state.ConfDir(home), "decomk.conf"),
+		filepath.Join(confDir, "decomk.conf"),
 	}
 }
 
 // configRepoConfigPath returns the first existing config repo decomk.conf path.
-func configRepoConfigPath(home string) (string, bool) {
-	for _, p := range configRepoConfigCandidates(home) {
+func configRepoConfigPath(confDir string) (string, bool) {
+	for _, p := range configRepoConfigCandidates(confDir) {
 		if fileExists(p) {
 			return p, true
 		}
@@ -1610,61 +2307,140 @@ func selectContextKey(defs contexts.Defs, flagContext string) (string, error) {
 	return "", fmt.Errorf("no matching context found; tried %v", candidates)
 }
 
-// contextKeysForWorkspaces selects at most one non-DEFAULT context key for each
+// Context seed sources (see contextSeed).
+const (
+	seedSourceDefault         = "default"
+	seedSourceFlag            = "flag"
+	seedSourceEnv             = "env"
+	seedSourceWorkspace       = "workspace"
+	seedSourceWorkspaceConfig = "workspace-config"
+)
+
+// contextSeed is one config key seeded for expansion and why it was chosen.
+//
+// Intent: Show exactly which rule selected each context, so surprising plans
+// can be traced to a workspace, flag, or environment variable.
+// Source: DI-dibim (TODO-jirin)
+type contextSeed struct {
+	Key string `json:"key"`
+	// Source is default, flag, env, workspace, or workspace-config.
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// seedKeys returns the keys of seeds, in order.
+func seedKeys(seeds []contextSeed) []string {
+	keys := make([]string, 0, len(seeds))
+	for _, seed := range seeds {
+		keys = append(keys, seed.Key)
+	}
+	return keys
+}
+
+// workspaceContextSeeds selects at most one non-DEFAULT context key for each
 // discovered workspace.
 //
 // This helper is intentionally tolerant: if a workspace has no matching stanza
 // in defs, it contributes nothing. This mirrors isconf's behavior of always
 // applying DEFAULT and optionally applying host-specific stanzas only when they
 // exist.
-func contextKeysForWorkspaces(defs contexts.Defs, repos []workspaceRepo) []string {
+//
+// Candidates are tried in order: owner/repo, repo, directory basename, then the
+// owner-wide keys owner/* and owner.
+//
+// Intent: Let organizations set owner-wide defaults once while exact
+// per-repo stanzas keep winning.
+// Source: DI-minik (TODO-jirin)
+func workspaceContextSeeds(defs contexts.Defs, repos []workspaceRepo) []contextSeed {
 	seen := make(map[string]bool)
-	var keys []string
+	var seeds []contextSeed
 	for _, repo := range repos {
-		var chosen string
-		for _, c := range []string{repo.OwnerRepo, repo.RepoName, repo.Name} {
-			if c == "" {
+		type candidate struct{ key, kind string }
+		candidates := []candidate{{repo.OwnerRepo, "owner/repo"}, {repo.RepoName, "repo name"}, {repo.Name, "directory name"}}
+		if i := strings.IndexByte(repo.OwnerRepo, '/'); i > 0 {
+			owner := repo.OwnerRepo[:i]
+			candidates = append(candidates, candidate{owner + "/*", "owner wildcard"}, candidate{owner, "owner"})
+		}
+		var chosen candidate
+		for _, c := range candidates {
+			if c.key == "" {
 				continue
 			}
-			if _, ok := defs[c]; ok {
+			if _, ok := defs[c.key]; ok {
 				chosen = c
 				break
 			}
 		}
-		if chosen == "" || chosen == "DEFAULT" {
+		if chosen.key == "" || chosen.key == "DEFAULT" {
 			continue
 		}
-		if seen[chosen] {
+		if seen[chosen.key] {
 			continue
 		}
-		seen[chosen] = true
-		keys = append(keys, chosen)
+		seen[chosen.key] = true
+		where := repo.Root
+		if where == "" {
+			where = repo.Name
+		}
+		seeds = append(seeds, contextSeed{Key: chosen.key, Source: seedSourceWorkspace, Reason: fmt.Sprintf("workspace %s matched by %s", where, chosen.kind)})
 	}
-	return keys
+	return seeds
 }
 
-// seedTokensForContexts builds the initial macro token list to expand.
+// seedContexts builds the seeds to expand, deduplicating along the way.
 //
-// It always includes DEFAULT first when present, then includes each provided
-// context key in order, deduplicating along the way.
-func seedTokensForContexts(defs contexts.Defs, contextKeys []string) []string {
+// It includes DEFAULT first when present, unless noDefault, then each of
+// seeds in order.
+func seedContexts(defs contexts.Defs, seeds []contextSeed, noDefault bool) []contextSeed {
 	seen := make(map[string]bool)
-	var seed []string
-	add := func(key string) {
-		if key == "" || seen[key] {
+	var out []contextSeed
+	add := func(seed contextSeed) {
+		if seed.Key == "" || seen[seed.Key] {
 			return
 		}
-		seen[key] = true
-		seed = append(seed, key)
+		seen[seed.Key] = true
+		out = append(out, seed)
+	}
+
+	if _, ok := defs["DEFAULT"]; ok && !noDefault {
+		add(contextSeed{Key: "DEFAULT", Source: seedSourceDefault, Reason: "always seeded first (-no-default leaves it out)"})
+	}
+	for _, seed := range seeds {
+		add(seed)
+	}
+	return out
+}
+
+// contextSeedKeys returns seed (DEFAULT plus this run's contexts) followed by
+// every key in defs naming an owner/repo or owner/* context: the keys some
+// workspace can seed expansion from.
+func contextSeedKeys(defs contexts.Defs, seed []string) []string {
+	seeds := append([]string(nil), seed...)
+	for _, key := range sortedDefKeys(defs) {
+		if strings.Contains(key, "/") {
+			seeds = append(seeds, key)
+		}
 	}
+	return seeds
+}
 
-	if _, ok := defs["DEFAULT"]; ok {
-		add("DEFAULT")
+// unusedKeyWarnings returns one warning per key in defs that is not reachable
+// from contextSeedKeys, in key order. Directives (DECOMK_TOOL_REF,
+// DECOMK_ALLOW) are never reported.
+func unusedKeyWarnings(defs contexts.Defs, seed []string) []string {
+	reached := expand.Reachable(expand.Defs(defs), contextSeedKeys(defs, seed))
+	var unused []string
+	for key := range defs {
+		if !reached[key] && !contexts.IsDirective(key) {
+			unused = append(unused, key)
+		}
 	}
-	for _, key := range contextKeys {
-		add(key)
+	sort.Strings(unused)
+	warnings := make([]string, 0, len(unused))
+	for _, key := range unused {
+		warnings = append(warnings, fmt.Sprintf("config key %q is not reachable from DEFAULT, any owner/repo context, or the selected contexts", key))
 	}
-	return seed
+	return warnings
 }
 
 // fileExists reports whether path exists and is a regular file.
@@ -1695,6 +2471,13 @@ var computedVarOrder = []string{
 	"DECOMK_WORKSPACES",
 	"DECOMK_CONTEXTS",
 	"DECOMK_PACKAGES",
+	"DECOMK_FIRST_BOOT",
+	"DECOMK_BIN",
+	"DECOMK_OS",
+	"DECOMK_ARCH",
+	"DECOMK_DISTRO",
+	"DECOMK_DISTRO_VERSION",
+	"DECOMK_NPROC",
 }
 
 // resolveRemoteUser reports the non-root username that "owns" decomk's state for
@@ -1748,7 +2531,7 @@ func computedVars(plan *resolvedPlan, targets []string) map[string]string {
 	for _, repo := range plan.WorkspaceRepos {
 		workspaces = append(workspaces, repo.Name)
 	}
-	return map[string]string{
+	vars := map[string]string{
 		"DECOMK_HOME":        plan.Home,
 		"DECOMK_STAMPDIR":    plan.StampDir,
 		"DECOMK_VERSION":     decomkVersion,
@@ -1757,7 +2540,31 @@ func computedVars(plan *resolvedPlan, targets []string) map[string]string {
 		"DECOMK_WORKSPACES":  strings.Join(workspaces, " "),
 		"DECOMK_CONTEXTS":    strings.Join(plan.ContextKeys, " "),
 		"DECOMK_PACKAGES":    strings.Join(targets, " "),
+		// Intent: Let Makefiles reserve heavy operations for the first boot.
+		// Source: DI-valik (TODO-jirin)
+		"DECOMK_FIRST_BOOT": strconv.FormatBool(!fileExists(state.BootstrapMarkerPath(plan.Home))),
+		// Intent: Let recipes end with $(DECOMK_BIN) stamp $@.
+		// Source: DI-fupub (TODO-jirin)
+		"DECOMK_BIN": decomkBinPath(plan.Home),
 	}
+	for name, value := range platformVars() {
+		vars[name] = value
+	}
+	for _, pv := range plan.PluginVars {
+		if _, ok := vars[pv.Name]; !ok {
+			vars[pv.Name] = pv.Value
+		}
+	}
+	return vars
+}
+
+// decomkBinPath returns the absolute path of the running decomk binary, or
+// the installed tool path under home when it cannot be determined.
+func decomkBinPath(home string) string {
+	if path, err := os.Executable(); err == nil {
+		return path
+	}
+	return state.ToolBinPath(home)
 }
 
 // selectTargets determines which make targets decomk should pass on argv.
@@ -1766,9 +2573,13 @@ func computedVars(plan *resolvedPlan, targets []string) map[string]string {
 // Each arg is interpreted as:
 //   - an action variable name (when it matches a resolved tuple variable), or
 //   - a literal make target (fallback).
-func selectTargets(tuples, actionArgs []string) (targets []string, source string) {
+//
+// Targets matching a skip pattern (see skipTargets) are then removed and
+// returned as skipped.
+func selectTargets(tuples, actionArgs, skip []string) (targets, skipped []string, source string) {
 	effective := effectiveTupleValues(tuples)
-	return targetsFromActionArgs(actionArgs, effective), "actionArgs"
+	targets, skipped = skipTargets(targetsFromActionArgs(actionArgs, effective), skip)
+	return targets, skipped, "actionArgs"
 }
 
 // envMapFromList converts KEY=value strings into a map where the last entry for
@@ -1822,6 +2633,84 @@ func resolveTuplePassThroughs(tuples []string, incomingEnv map[string]string) ([
 	return out, nil
 }
 
+// resolveRuntimeTuples applies the invocation-time tuple rewrites that depend
+// on the incoming environment, in order:
+//   - `NAME=$` passthrough resolution
+//   - DECOMK_PATH_PREPEND managed PATH augmentation
+//
+// plan/run and shell all call this so every consumer of the canonical env
+// contract sees the same resolved tuples.
+func resolveRuntimeTuples(tuples []string, incomingEnv map[string]string) ([]string, error) {
+	resolved, err := resolveTuplePassThroughs(tuples, incomingEnv)
+	if err != nil {
+		return nil, err
+	}
+	return managedPathTuples(resolved, incomingEnv)
+}
+
+// managedPathTuples appends one resolved `PATH=...` tuple when the effective
+// tuples set DECOMK_PATH_PREPEND.
+//
+// DECOMK_PATH_PREPEND holds absolute directories separated by whitespace or
+// ':'. Each entry is cleaned, then the entries are prepended (in order) to the
+// base PATH: the effective config `PATH` tuple when one exists, otherwise the
+// incoming environment PATH. Empty and duplicate entries are dropped, with the
+// first occurrence winning, so repeated runs and overlapping contexts never
+// grow PATH.
+//
+// Intent: Keep tool bin-dir PATH policy declarative in decomk.conf so recipes
+// stop appending to PATH ad hoc and env.sh consumers see the same PATH make
+// used.
+// Source: DI-jakif (TODO-jirin)
+func managedPathTuples(tuples []string, incomingEnv map[string]string) ([]string, error) {
+	effective := effectiveTupleValues(tuples)
+	rawPrepend, ok := effective[pathPrependTuple]
+	if !ok {
+		return tuples, nil
+	}
+	prepend, err := parsePathPrepend(rawPrepend)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pathPrependTuple, err)
+	}
+
+	basePath, ok := effective["PATH"]
+	if !ok {
+		basePath = incomingEnv["PATH"]
+	}
+	entries := append(prepend, strings.Split(basePath, string(os.PathListSeparator))...)
+
+	seen := make(map[string]bool, len(entries))
+	var merged []string
+	for _, entry := range entries {
+		if entry == "" || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		merged = append(merged, entry)
+	}
+
+	out := append([]string(nil), tuples...)
+	return append(out, "PATH="+strings.Join(merged, string(os.PathListSeparator))), nil
+}
+
+// parsePathPrepend splits and normalizes a DECOMK_PATH_PREPEND value.
+//
+// No shell or variable expansion is performed, so every entry must already be
+// an absolute path.
+func parsePathPrepend(raw string) ([]string, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == os.PathListSeparator || r == ' ' || r == '\t' || r == '\n'
+	})
+	out := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !filepath.IsAbs(field) {
+			return nil, fmt.Errorf("entry %q must be an absolute path", field)
+		}
+		out = append(out, filepath.Clean(field))
+	}
+	return out, nil
+}
+
 // autoPassThroughTuples returns sorted NAME=value tuples for incoming env vars in
 // the DECOMK_* namespace.
 //
@@ -1858,6 +2747,7 @@ func autoPassThroughTuples(incomingEnv map[string]string) []string {
 //
 // The resulting order is:
 //   - incoming DECOMK_* pass-through tuples
+//   - incoming proxy variables, in both casings (see proxyPassThroughTuples)
 //   - resolved config tuples
 //   - decomk-computed tuples (last, so they override prior values)
 //
@@ -1866,6 +2756,7 @@ func autoPassThroughTuples(incomingEnv map[string]string) []string {
 func canonicalEnvTuples(plan *resolvedPlan, targets []string, incomingEnv map[string]string) []string {
 	out := make([]string, 0, len(incomingEnv)+len(plan.Tuples)+len(computedVarOrder))
 	out = append(out, autoPassThroughTuples(incomingEnv)...)
+	out = append(out, proxyPassThroughTuples(incomingEnv)...)
 	out = append(out, plan.Tuples...)
 
 	cv := computedVars(plan, targets)
@@ -1874,9 +2765,134 @@ func canonicalEnvTuples(plan *resolvedPlan, targets []string, incomingEnv map[st
 			out = append(out, name+"="+v)
 		}
 	}
+	for _, pv := range plan.PluginVars {
+		out = append(out, pv.Name+"="+cv[pv.Name])
+	}
+	return out
+}
+
+// wellKnownVars are environment/make variables commonly consumed by nested
+// build systems (autotools, sub-makes, compilers). A config tuple with one of
+// these names reaches env.sh, make argv, and the make process environment, and
+// so silently changes what those tools do inside recipes.
+var wellKnownVars = []string{"INSTALL", "CC", "CXX", "CFLAGS", "LDFLAGS", "PREFIX", "PATH", "MAKE", "SHELL"}
+
+// wellKnownVarWarnings returns one warning per distinct tuple name in tuples
+// that matches wellKnownVars, skipping makeonly tuples (per classes), which
+// stay out of environments, and envref tuples, which compose with the
+// environment on purpose.
+//
+// Intent: Surface collisions such as INSTALL=... (which autotools reads as its
+// install program) at plan time, before a run breaks a nested build.
+// Source: DI- (TODO-jirin)
+func wellKnownVarWarnings(tuples []string, classes map[string]string) []string {
+	var warnings []string
+	seen := make(map[string]bool)
+	for _, tuple := range tuples {
+		name, _, ok := resolve.SplitTuple(tuple)
+		if !ok || seen[name] || classes[name] == resolve.ClassMakeOnly || classes[name] == resolve.ClassEnvRef {
+			continue
+		}
+		seen[name] = true
+		for _, known := range wellKnownVars {
+			if name == known {
+				warnings = append(warnings, fmt.Sprintf("tuple %s shares its name with a variable nested build systems read from the environment and make command line; consider a project-prefixed name (for example MYPROJ_%s) or `makeonly %s=...`", name, name, name))
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// makeOnlyNames returns the sorted makeonly (noexport) tuple names.
+func makeOnlyNames(classes map[string]string) []string {
+	var names []string
+	for name, class := range classes {
+		if class == resolve.ClassMakeOnly {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exportedTuples returns the cooked tuples that belong in env.sh and process
+// environments: all of them except makeonly tuples, which only go on make's
+// argv.
+//
+// Intent: Keep make-internal knobs such as INSTALL out of nested builds that
+// read the same names from the environment.
+// Source: DI-purub (TODO-jirin)
+func exportedTuples(cookedTuples []string, classes map[string]string) []string {
+	out := make([]string, 0, len(cookedTuples))
+	for _, t := range cookedTuples {
+		if name, _, ok := resolve.SplitTuple(t); ok && classes[name] == resolve.ClassMakeOnly {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// makeArgvTuples returns the cooked tuples passed on make's argv: all of them
+// except envonly and envref tuples, which only go into env.sh and process
+// environments.
+// Proxy variables are treated as envonly unless classed makeonly.
+//
+// Intent: Keep values meant for scripts from overriding Makefile assignments
+// the way command-line variables do.
+// Source: DI-batub (TODO-jirin)
+func makeArgvTuples(cookedTuples []string, classes map[string]string) []string {
+	out := make([]string, 0, len(cookedTuples))
+	for _, t := range cookedTuples {
+		name, _, ok := resolve.SplitTuple(t)
+		if ok && (classes[name] == resolve.ClassEnvOnly || classes[name] == resolve.ClassEnvRef) {
+			continue
+		}
+		// Intent: Proxy URLs may embed credentials; keep them out of argv and
+		// the logged make command. Source: DI-buvam (TODO-jirin)
+		if ok && isProxyEnvName(name) && classes[name] != resolve.ClassMakeOnly {
+			continue
+		}
+		out = append(out, t)
+	}
 	return out
 }
 
+// exportedTupleValues returns the values exported tuples (per classes) set in
+// a process environment built on baseEnv. Envref values are expanded after all
+// other tuples, in tuple order, with unset references expanding to "", which
+// is what sourcing env.sh does.
+//
+// Intent: Let PATH-style values compose with the environment they land in,
+// while processes decomk starts and shells sourcing env.sh see the same value.
+// Source: DI-fubul (TODO-jirin)
+func exportedTupleValues(baseEnv, cookedTuples []string, classes map[string]string) map[string]string {
+	var literal, refs []string
+	for _, t := range exportedTuples(cookedTuples, classes) {
+		if name, _, ok := resolve.SplitTuple(t); ok && classes[name] == resolve.ClassEnvRef {
+			refs = append(refs, t)
+			continue
+		}
+		literal = append(literal, t)
+	}
+	values := effectiveTupleValues(literal)
+	if len(refs) == 0 {
+		return values
+	}
+	env := envMapFromList(baseEnv)
+	for name, value := range values {
+		env[name] = value
+	}
+	for _, t := range refs {
+		name, value, _ := resolve.SplitTuple(t)
+		value = expandEnvRefs(value, func(ref string) string { return env[ref] })
+		env[name] = value
+		values[name] = value
+	}
+	return values
+}
+
 // effectiveTupleValues returns the "last wins" values for NAME=value tuples.
 //
 // This mirrors make's command-line variable precedence: if the same variable
@@ -1947,6 +2963,122 @@ func withEnv(base []string, set map[string]string) []string {
 	return keep
 }
 
+// resolveMakefiles selects the configured makefiles, in "-f" order.
+//
+// Selection order (first match wins):
+//  1. -makefile (one path, relative to the -C start directory)
+//  2. DECOMK_MAKEFILES tuple (whitespace-separated list)
+//  3. findDefaultMakefile
+//
+// Relative DECOMK_MAKEFILES entries resolve against the explicit config's
+// directory first (matching findDefaultMakefile), then confDir. Every selected
+// file must exist. An empty result means no Makefile was found; callers decide
+// whether that is an error.
+//
+// Both -makefile and DECOMK_MAKEFILES entries may also be pinned
+// `https://...#sha256=<hex>` URLs, which remote resolves to cached local copies.
+//
+// DECOMK_MAKEFILES entries written NAMESPACE=path are returned as namespaces
+// instead of makefiles (see renderNamespacesMakefile); at least one entry must
+// stay un-namespaced.
+//
+// Intent: Let recipe libraries (base.mk, lang-go.mk, ...) be composed per
+// context instead of maintained as one monolithic Makefile.
+// Source: DI-huhig (TODO-jirin)
+func resolveMakefiles(flagMakefile string, tupleValues map[string]string, confDir, explicitConfig string, remote *remoteMakefileFetcher) ([]string, []makefileNamespace, error) {
+	if flagMakefile != "" && isRemoteMakefile(flagMakefile) {
+		path, err := remote.resolve(flagMakefile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{path}, nil, nil
+	}
+	if flagMakefile != "" {
+		abs, err := filepath.Abs(flagMakefile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("abs makefile path %q: %w", flagMakefile, err)
+		}
+		if !fileExists(abs) {
+			return nil, nil, fmt.Errorf("makefile not found: %s", abs)
+		}
+		return []string{abs}, nil, nil
+	}
+
+	if raw, ok := tupleValues[makefilesTuple]; ok {
+		entries := strings.Fields(raw)
+		if len(entries) == 0 {
+			return nil, nil, fmt.Errorf("%s is set but empty", makefilesTuple)
+		}
+		var bases []string
+		if explicitConfig != "" {
+			bases = append(bases, filepath.Dir(explicitConfig))
+		}
+		bases = append(bases, confDir)
+
+		out := make([]string, 0, len(entries))
+		var namespaces []makefileNamespace
+		seenNamespace := make(map[string]bool)
+		for _, entry := range entries {
+			namespace, entry := splitNamespaceEntry(entry)
+			var (
+				path string
+				err  error
+			)
+			if isRemoteMakefile(entry) {
+				path, err = remote.resolve(entry)
+			} else {
+				path, err = resolveMakefileEntry(entry, bases)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", makefilesTuple, err)
+			}
+			if namespace == "" {
+				out = append(out, path)
+				continue
+			}
+			if seenNamespace[namespace] {
+				return nil, nil, fmt.Errorf("%s: namespace %q is used twice", makefilesTuple, namespace)
+			}
+			seenNamespace[namespace] = true
+			namespaces = append(namespaces, makefileNamespace{Name: namespace, Path: path})
+		}
+		if len(out) == 0 {
+			return nil, nil, fmt.Errorf("%s: every entry is namespaced; list at least one makefile without a NAMESPACE= prefix", makefilesTuple)
+		}
+		return out, namespaces, nil
+	}
+
+	makefile := findDefaultMakefile(confDir, explicitConfig)
+	if makefile == "" {
+		return nil, nil, nil
+	}
+	abs, err := filepath.Abs(makefile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("abs makefile path %q: %w", makefile, err)
+	}
+	return []string{abs}, nil, nil
+}
+
+// resolveMakefileEntry resolves one DECOMK_MAKEFILES entry to an existing
+// absolute path, trying each base directory in order for relative entries.
+func resolveMakefileEntry(entry string, bases []string) (string, error) {
+	if filepath.IsAbs(entry) {
+		if !fileExists(entry) {
+			return "", fmt.Errorf("makefile not found: %s", entry)
+		}
+		return filepath.Clean(entry), nil
+	}
+	var tried []string
+	for _, base := range bases {
+		candidate := filepath.Join(base, entry)
+		if fileExists(candidate) {
+			return filepath.Abs(candidate)
+		}
+		tried = append(tried, candidate)
+	}
+	return "", fmt.Errorf("makefile %q not found; tried %s", entry, strings.Join(tried, ", "))
+}
+
 // findDefaultMakefile picks a default Makefile path when -makefile is not set.
 //
 // decomk's long-term model is that the Makefile is part of the shared "config
@@ -1955,15 +3087,15 @@ func withEnv(base []string, set map[string]string) []string {
 //
 // Selection order (first match wins):
 //  1. sibling of explicitConfig (if non-empty)
-//  2. <DECOMK_HOME>/conf/Makefile
-func findDefaultMakefile(home, explicitConfig string) string {
+//  2. <confDir>/Makefile (<DECOMK_HOME>/conf, or its -conf-path subdirectory)
+func findDefaultMakefile(confDir, explicitConfig string) string {
 	if explicitConfig != "" {
 		candidate := filepath.Join(filepath.Dir(explicitConfig), "Makefile")
 		if fileExists(candidate) {
 			return candidate
 		}
 	}
-	candidate := filepath.Join(state.ConfDir(home), "Makefile")
+	candidate := filepath.Join(confDir, "Makefile")
 	if fileExists(candidate) {
 		return candidate
 	}
@@ -1973,14 +3105,24 @@ func findDefaultMakefile(home, explicitConfig string) string {
 // makeInvocation returns the tuple list and process env slice used to invoke
 // make.
 //
-// cookedTuples is the canonical environment contract shared with env.sh export.
-func makeInvocation(baseEnv, cookedTuples []string) (tuples []string, env []string) {
-	tuples = append([]string(nil), cookedTuples...)
+// cookedTuples is the canonical environment contract shared with env.sh export;
+// makeonly tuples (per classes) go on argv but not into the process env, and
+// envonly tuples go into the process env but not on argv. A parent make's
+// MAKEFLAGS, MAKELEVEL, and MFLAGS are dropped from baseEnv unless
+// DECOMK_INHERIT_MAKEFLAGS is set.
+func makeInvocation(baseEnv, cookedTuples []string, classes map[string]string) (tuples []string, env []string) {
+	tuples = makeArgvTuples(cookedTuples, classes)
+	// Intent: Drop a parent make's MAKEFLAGS/MAKELEVEL/MFLAGS from the
+	// incoming env unless the operator opts in; config tuples still apply.
+	// Source: DI-robup (TODO-jirin)
+	if envMapFromList(baseEnv)[inheritMakeflagsEnv] == "" {
+		baseEnv = makeexec.StripInherited(baseEnv)
+	}
 	// Intent: Keep one PATH model by deriving the launcher process env from the
 	// same cooked tuple contract that drives env.sh and make argv, even when that
 	// means tuple-provided PATH values can affect launcher behavior.
 	// Source: DI-vukaz (TODO-jirin)
-	env = withEnv(baseEnv, effectiveTupleValues(cookedTuples))
+	env = withEnv(baseEnv, exportedTupleValues(baseEnv, cookedTuples, classes))
 	return tuples, env
 }
 
@@ -1990,41 +3132,13 @@ func makeInvocation(baseEnv, cookedTuples []string) (tuples []string, env []stri
 // This file is intentionally simple: it is designed to be sourced by scripts
 // and nested make invocations without requiring eval.
 func writeEnvFile(path string, plan *resolvedPlan, cookedTuples []string) error {
-	if err := state.EnsureParentDir(path); err != nil {
-		return err
-	}
-
-	tmp := path + ".tmp"
-	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
-	if err != nil {
-		return err
-	}
-	// Intent: Keep DECOMK_HOME artifacts world-readable by enforcing env.sh mode
-	// after create, independent of process umask.
-	// Source: DI-kidaj (TODO-mirut)
-	if err := f.Chmod(0o644); err != nil {
-		// Intent: Preserve close failures alongside chmod failures so env export
-		// file-permission errors are never silently dropped.
-		// Source: DI-golak (TODO-gamuz)
-		if closeErr := f.Close(); closeErr != nil {
-			return errors.Join(err, fmt.Errorf("close env export temp file after chmod failure: %w", closeErr))
-		}
+	var b bytes.Buffer
+	if err := writeEnvExport(&b, plan, cookedTuples); err != nil {
 		return err
 	}
-
-	if err := writeEnvExport(f, plan, cookedTuples); err != nil {
-		// Intent: Preserve temp-file close failures alongside export failures so
-		// env export write errors are never silently dropped.
-		// Source: DI-golak (TODO-gamuz)
-		if closeErr := f.Close(); closeErr != nil {
-			return errors.Join(err, fmt.Errorf("close env export temp file after write failure: %w", closeErr))
-		}
-		return err
-	}
-	if err := f.Close(); err != nil {
-		return err
-	}
-	return os.Rename(tmp, path)
+	// env.sh must stay world-readable; state.AtomicWrite applies the mode
+	// whatever the umask.
+	return state.AtomicWrite(path, b.Bytes(), 0o644)
 }
 
 // writeEnvExport writes the full env export file content to w.
@@ -2057,6 +3171,11 @@ func writeEnvExport(w io.Writer, plan *resolvedPlan, cookedTuples []string) erro
 	if err := writeFormat(w, "# config: %s\n", strings.Join(plan.ConfigPaths, ", ")); err != nil {
 		return err
 	}
+	if len(plan.Secrets) > 0 {
+		if err := writeFormat(w, "# secrets: %s (mode 0600; source it separately)\n", state.SecretsEnvFile(plan.Home)); err != nil {
+			return err
+		}
+	}
 	if err := writeLine(w); err != nil {
 		return err
 	}
@@ -2064,15 +3183,28 @@ func writeEnvExport(w io.Writer, plan *resolvedPlan, cookedTuples []string) erro
 	// Intent: Export the same tuple sequence used for make invocation so env.sh is
 	// the exact contract for what make and child processes receive.
 	// Source: DI-vojik (TODO-jirin)
-	for _, t := range cookedTuples {
+	// Envref exports come last so their references see every literal export,
+	// including decomk-computed values such as DECOMK_HOME.
+	var refs []string
+	for _, t := range exportedTuples(cookedTuples, plan.TupleClasses) {
 		k, v, ok := resolve.SplitTuple(t)
-		if !ok {
+		if !ok || plan.Secrets[k] != "" {
+			continue
+		}
+		if plan.TupleClasses[k] == resolve.ClassEnvRef {
+			refs = append(refs, t)
 			continue
 		}
 		if err := writeExport(w, k, v); err != nil {
 			return err
 		}
 	}
+	for _, t := range refs {
+		k, v, _ := resolve.SplitTuple(t)
+		if err := writeFormat(w, "export %s=%s\n", k, envRefQuote(v)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -2081,6 +3213,74 @@ func writeExport(w io.Writer, name, value string) error {
 	return writeFormat(w, "export %s=%s\n", name, shellQuote(value))
 }
 
+// envRefName returns the variable name referenced at s[i], which must be '$',
+// as NAME or {NAME}, and the length of the reference including the '$'. It
+// returns n=0 when s[i:] is not such a reference.
+func envRefName(s string, i int) (name string, n int) {
+	rest := s[i+1:]
+	braced := strings.HasPrefix(rest, "{")
+	if braced {
+		rest = rest[1:]
+	}
+	end := 0
+	for end < len(rest) && (rest[end] == '_' || rest[end] >= 'A' && rest[end] <= 'Z' || rest[end] >= 'a' && rest[end] <= 'z' || end > 0 && rest[end] >= '0' && rest[end] <= '9') {
+		end++
+	}
+	if end == 0 {
+		return "", 0
+	}
+	if !braced {
+		return rest[:end], 1 + end
+	}
+	if end == len(rest) || rest[end] != '}' {
+		return "", 0
+	}
+	return rest[:end], 3 + end
+}
+
+// expandEnvRefs replaces $NAME and ${NAME} references in s with lookup(NAME).
+// Any other '$' is literal.
+func expandEnvRefs(s string, lookup func(string) string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '$' {
+			if name, n := envRefName(s, i); n > 0 {
+				b.WriteString(lookup(name))
+				i += n - 1
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// envRefQuote double-quotes s for POSIX shells, leaving $NAME and ${NAME}
+// references live and escaping everything else the shell would interpret, so
+// the shell expands s exactly as expandEnvRefs does.
+func envRefQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '$':
+			if _, n := envRefName(s, i); n > 0 {
+				b.WriteString(s[i : i+n])
+				i += n - 1
+				continue
+			}
+			b.WriteString(`\$`)
+		case '"', '\\', '`':
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
 // shellQuote produces a POSIX-shell-safe single-quoted string.
 func shellQuote(s string) string {
 	if s == "" {
@@ -2090,14 +3290,79 @@ func shellQuote(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
 }
 
+// planMakefiles returns every makefile passed to make for plan, in "-f" order:
+// the configured makefiles first, then generated fragments.
+func planMakefiles(plan *resolvedPlan) []string {
+	out := append([]string(nil), plan.Makefiles...)
+	return append(out, plan.ExtraMakefiles...)
+}
+
+// writeGeneratedMakefiles (re)writes decomk-generated make fragments for plan.
+//
+// Fragments are derived entirely from the resolved config, so they are
+// rewritten on every invocation (including plan, so make -n sees the same
+// targets run would).
+func writeGeneratedMakefiles(plan *resolvedPlan) error {
+	if len(plan.Toolchains) > 0 {
+		if err := writeGeneratedMakefile(state.ToolchainsMakefile(plan.Home), toolchain.RenderMakefile(plan.Toolchains)); err != nil {
+			return err
+		}
+	}
+	if len(plan.Recipes) > 0 {
+		if err := writeGeneratedMakefile(state.RecipesMakefile(plan.Home), contexts.RenderRecipes(plan.Recipes)); err != nil {
+			return err
+		}
+	}
+	if len(plan.Templates) > 0 {
+		if err := writeGeneratedMakefile(state.TemplatesMakefile(plan.Home), plan.TemplatesMakefile); err != nil {
+			return err
+		}
+	}
+	if len(plan.Namespaces) > 0 {
+		if err := writeGeneratedMakefile(state.NamespacesMakefile(plan.Home), renderNamespacesMakefile(plan.Namespaces)); err != nil {
+			return err
+		}
+	}
+	if len(plan.TargetDirs) > 0 {
+		if err := writeGeneratedMakefile(state.TargetDirsMakefile(plan.Home), renderTargetDirsMakefile(plan.TargetDirs)); err != nil {
+			return err
+		}
+	}
+	if names := makeOnlyNames(plan.TupleClasses); len(names) > 0 {
+		if err := writeGeneratedMakefile(state.UnexportMakefile(plan.Home), renderUnexportMakefile(names)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderUnexportMakefile renders a make fragment that unexports names, so
+// makeonly tuples given on make's command line stay out of recipe
+// environments.
+func renderUnexportMakefile(names []string) []byte {
+	return []byte("# generated by decomk from decomk.conf makeonly tuples; do not edit\nunexport " + strings.Join(names, " ") + "\n")
+}
+
+// writeGeneratedMakefile atomically writes one generated make fragment.
+func writeGeneratedMakefile(path string, content []byte) error {
+	if err := state.AtomicWrite(path, content, 0o644); err != nil {
+		return fmt.Errorf("write generated makefile %s: %w", path, err)
+	}
+	return nil
+}
+
 // buildMakeArgv renders the exact argv decomk passes to exec.Command for make.
 //
-// Ordering is intentionally identical to makeexec.RunWithFlagsCommand:
-// command prefix, then flags, optional "-f <makefile>", tuples, and targets.
-func buildMakeArgv(command, flags []string, makefile string, tuples, targets []string) []string {
+// Ordering is intentionally identical to makeexec.RunMakefilesCommand:
+// command prefix, then flags, one "-f <makefile>" per makefile, tuples, and
+// targets.
+func buildMakeArgv(command, flags []string, makefiles []string, tuples, targets []string) []string {
 	argv := append([]string(nil), command...)
 	argv = append(argv, flags...)
-	if makefile != "" {
+	for _, makefile := range makefiles {
+		if makefile == "" {
+			continue
+		}
 		argv = append(argv, "-f", makefile)
 	}
 	argv = append(argv, tuples...)
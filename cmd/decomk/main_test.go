@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/resolve"
 	"github.com/stevegt/decomk/state"
 )
 
@@ -34,20 +39,20 @@ func TestLoadDefs_Precedence_ConfigRepoThenExplicit(t *testing.T) {
 		t.Fatalf("WriteFile(explicit decomk.conf): %v", err)
 	}
 
-	defs, paths, err := loadDefs(home, explicit)
+	cfg, err := loadDefs(state.ConfDir(home), explicit)
 	if err != nil {
 		t.Fatalf("loadDefs() error: %v", err)
 	}
 
 	// Precedence is "last wins": config repo < explicit.
-	if got, want := defs["A"], []string{"FOO=configA"}; !reflect.DeepEqual(got, want) {
+	if got, want := cfg.Defs["A"], []string{"FOO=configA"}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("A tokens: got %#v want %#v", got, want)
 	}
-	if got, want := defs["B"], []string{"BAR=explicitB"}; !reflect.DeepEqual(got, want) {
+	if got, want := cfg.Defs["B"], []string{"BAR=explicitB"}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("B tokens: got %#v want %#v", got, want)
 	}
 
-	if got, want := paths, []string{configRepoConfig, explicit}; !reflect.DeepEqual(got, want) {
+	if got, want := cfg.Paths, []string{configRepoConfig, explicit}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("paths: got %#v want %#v", got, want)
 	}
 }
@@ -64,7 +69,7 @@ func TestLoadDefs_RejectsBareUnknownToken(t *testing.T) {
 		t.Fatalf("WriteFile(config repo decomk.conf): %v", err)
 	}
 
-	_, _, err := loadDefs(home, "")
+	_, err := loadDefs(state.ConfDir(home), "")
 	if err == nil {
 		t.Fatalf("loadDefs() expected error, got nil")
 	}
@@ -113,7 +118,7 @@ func TestSelectTargets(t *testing.T) {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			gotTargets, gotSource := selectTargets(tc.tuples, tc.actionArgs)
+			gotTargets, _, gotSource := selectTargets(tc.tuples, tc.actionArgs, nil)
 			if gotSource != tc.wantSource {
 				t.Fatalf("source: got %q want %q", gotSource, tc.wantSource)
 			}
@@ -221,7 +226,7 @@ func TestBuildMakeArgv_Order(t *testing.T) {
 	got := buildMakeArgv(
 		[]string{"make"},
 		[]string{"-n"},
-		"/tmp/Makefile",
+		[]string{"/tmp/Makefile"},
 		[]string{"FOO=bar", "BAR=baz"},
 		[]string{"Block00_base", "Block10_common"},
 	)
@@ -323,7 +328,7 @@ func TestSelectTargets_PassthroughInstallTuple(t *testing.T) {
 		t.Fatalf("resolveTuplePassThroughs(): %v", err)
 	}
 
-	gotTargets, gotSource := selectTargets(tuples, []string{"INSTALL"})
+	gotTargets, _, gotSource := selectTargets(tuples, []string{"INSTALL"}, nil)
 	wantTargets := []string{"install-neovim", "install-codex"}
 	if gotSource != "actionArgs" {
 		t.Fatalf("source: got %q want %q", gotSource, "actionArgs")
@@ -333,6 +338,35 @@ func TestSelectTargets_PassthroughInstallTuple(t *testing.T) {
 	}
 }
 
+func TestComputedVarsMatchReservedTupleNames(t *testing.T) {
+	t.Parallel()
+
+	// Config tuples are rejected for exactly the names computedVars sets, plus
+	// the run-only scratch and artifacts dirs.
+	got := []string{runTmpVar, runArtifactsVar}
+	for name := range computedVars(&resolvedPlan{}, nil) {
+		got = append(got, name)
+	}
+	want := append([]string(nil), contexts.ComputedTupleNames...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("computedVars names: got %q want %q", got, want)
+	}
+}
+
+func TestWellKnownVarWarnings(t *testing.T) {
+	t.Parallel()
+
+	warnings := wellKnownVarWarnings([]string{"INSTALL=jq", "FOO=bar", "CC=clang", "INSTALL=yq", "MYPROJ_CC=gcc", "PREFIX=/opt"}, map[string]string{"PREFIX": resolve.ClassMakeOnly})
+	if len(warnings) != 2 {
+		t.Fatalf("wellKnownVarWarnings(): got %q want 2 warnings", warnings)
+	}
+	if !strings.Contains(warnings[0], "tuple INSTALL") || !strings.Contains(warnings[1], "tuple CC") {
+		t.Fatalf("wellKnownVarWarnings(): got %q", warnings)
+	}
+}
+
 func TestCanonicalEnvTuplesAndMakeInvocationParity(t *testing.T) {
 	t.Parallel()
 
@@ -380,6 +414,7 @@ func TestCanonicalEnvTuplesAndMakeInvocationParity(t *testing.T) {
 	makeTuples, makeEnv := makeInvocation(
 		[]string{"PATH=/usr/bin", "DECOMK_CONF_URI=base-uri"},
 		cookedTuples,
+		nil,
 	)
 	if !reflect.DeepEqual(makeTuples, cookedTuples) {
 		t.Fatalf("make tuples: got %#v want %#v", makeTuples, cookedTuples)
@@ -392,6 +427,123 @@ func TestCanonicalEnvTuplesAndMakeInvocationParity(t *testing.T) {
 	}
 }
 
+func TestCmdPlan_NoExportTuples(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: noexport INSTALL=hello FOO=bar\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("hello:\n\t@echo install=$$INSTALL\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan([]string{
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"INSTALL",
+	}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	outText := stdout.String()
+	for _, needle := range []string{
+		"  INSTALL=hello  (makeonly)\n",
+		"export FOO='bar'\n",
+		"-f " + state.UnexportMakefile(home),
+		" INSTALL=hello FOO=bar ",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+	if strings.Contains(outText, "export INSTALL=") {
+		t.Fatalf("noexport tuple was exported:\n%s", outText)
+	}
+	if strings.Contains(stderr.String(), "tuple INSTALL") {
+		t.Fatalf("noexport tuple triggered well-known-variable warning: %q", stderr.String())
+	}
+	content, err := os.ReadFile(state.UnexportMakefile(home))
+	if err != nil {
+		t.Fatalf("ReadFile(unexport makefile): %v", err)
+	}
+	if !strings.Contains(string(content), "\nunexport INSTALL\n") {
+		t.Fatalf("unexport makefile: got %q", content)
+	}
+
+	_, makeEnv := makeInvocation([]string{"INSTALL=outer"}, []string{"INSTALL=hello", "FOO=bar"}, map[string]string{"INSTALL": resolve.ClassMakeOnly})
+	if got := envMapFromList(makeEnv); got["INSTALL"] != "outer" || got["FOO"] != "bar" {
+		t.Fatalf("makeInvocation() env: got %q", makeEnv)
+	}
+}
+
+func TestMakeInvocation_StripsInheritedMakeflags(t *testing.T) {
+	t.Parallel()
+
+	parent := []string{"PATH=/usr/bin", "MAKEFLAGS=n -j4 --jobserver-auth=3,4", "MAKELEVEL=1", "MFLAGS=-n", "MAKEFILES=x.mk"}
+	_, makeEnv := makeInvocation(parent, []string{"FOO=bar"}, nil)
+	if got, want := makeEnv, []string{"PATH=/usr/bin", "MAKEFILES=x.mk", "FOO=bar"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("makeInvocation() env: got %q want %q", got, want)
+	}
+
+	_, makeEnv = makeInvocation(parent, []string{"MAKEFLAGS=-k"}, nil)
+	if got := envMapFromList(makeEnv); got["MAKEFLAGS"] != "-k" || got["MAKELEVEL"] != "" {
+		t.Fatalf("makeInvocation(config MAKEFLAGS) env: got %q", makeEnv)
+	}
+
+	_, makeEnv = makeInvocation(append(parent, "DECOMK_INHERIT_MAKEFLAGS=1"), nil, nil)
+	if got := envMapFromList(makeEnv); got["MAKEFLAGS"] != "n -j4 --jobserver-auth=3,4" || got["MAKELEVEL"] != "1" || got["MFLAGS"] != "-n" {
+		t.Fatalf("makeInvocation(passthrough) env: got %q", makeEnv)
+	}
+}
+
+func TestCmdPlan_EnvOnlyTuples(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: envonly GREETING=hi makeonly INSTALL=hello\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("hello:\n\t@echo $$GREETING\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan([]string{
+		"-home", t.TempDir(),
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"INSTALL",
+	}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	outText := stdout.String()
+	for _, needle := range []string{
+		"  GREETING=hi  (envonly)\n",
+		"  INSTALL=hello  (makeonly)\n",
+		"export GREETING='hi'\n",
+		" INSTALL=hello DECOMK_HOME=",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+	_, makeCommand, _ := strings.Cut(outText, "make command:")
+	if strings.Contains(makeCommand, "GREETING=hi") {
+		t.Fatalf("envonly tuple was passed on make argv:\n%s", outText)
+	}
+}
+
 func TestWriteEnvExport_IncludesDecomkVersion(t *testing.T) {
 	t.Parallel()
 
@@ -416,6 +568,55 @@ func TestWriteEnvExport_IncludesDecomkVersion(t *testing.T) {
 	}
 }
 
+func TestEnvRefTuples_EnvFileMatchesMakeEnv(t *testing.T) {
+	t.Parallel()
+
+	plan := &resolvedPlan{
+		Home:         "/tmp/decomk-home",
+		StampDir:     "/tmp/decomk-home/stamps",
+		ConfigPaths:  []string{"/tmp/decomk-home/conf/decomk.conf"},
+		Tuples:       []string{"PATH=$DECOMK_HOME/bin:${PATH}", "ODD=$1 \"q\" `x` \\ $ ${} $(id)", "LIT=$HOME"},
+		TupleClasses: map[string]string{"PATH": resolve.ClassEnvRef, "ODD": resolve.ClassEnvRef},
+	}
+	cookedTuples := canonicalEnvTuples(plan, nil, map[string]string{})
+
+	var out bytes.Buffer
+	if err := writeEnvExport(&out, plan, cookedTuples); err != nil {
+		t.Fatalf("writeEnvExport() error: %v", err)
+	}
+	got := out.String()
+	wantTail := "export PATH=\"$DECOMK_HOME/bin:${PATH}\"\nexport ODD=\"\\$1 \\\"q\\\" \\`x\\` \\\\ \\$ \\${} \\$(id)\"\n"
+	if !strings.HasSuffix(got, wantTail) {
+		t.Fatalf("writeEnvExport() tail: got\n%s\nwant suffix\n%s", got, wantTail)
+	}
+	if !strings.Contains(got, "export LIT='$HOME'\n") {
+		t.Fatalf("writeEnvExport() literal tuple not single-quoted:\n%s", got)
+	}
+
+	makeTuples, makeEnv := makeInvocation([]string{"PATH=/usr/bin:/bin"}, cookedTuples, plan.TupleClasses)
+	for _, tuple := range makeTuples {
+		if strings.HasPrefix(tuple, "PATH=") || strings.HasPrefix(tuple, "ODD=") {
+			t.Fatalf("makeInvocation() argv carries envref tuple %q", tuple)
+		}
+	}
+	env := envMapFromList(makeEnv)
+	if want := "/tmp/decomk-home/bin:/usr/bin:/bin"; env["PATH"] != want {
+		t.Fatalf("makeInvocation() PATH: got %q want %q", env["PATH"], want)
+	}
+
+	// Sourcing env.sh must produce the same values decomk puts in make's env.
+	script := got + "printf '%s\\n' \"$PATH\" \"$ODD\"\n"
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Env = []string{"PATH=/usr/bin:/bin"}
+	sourced, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("sourcing env export: %v", err)
+	}
+	if want := env["PATH"] + "\n" + env["ODD"] + "\n"; string(sourced) != want {
+		t.Fatalf("sourced env export: got %q want %q", sourced, want)
+	}
+}
+
 func TestWriteEnvFile_EnsuresWorldReadableMode(t *testing.T) {
 	t.Parallel()
 
@@ -802,6 +1003,109 @@ func TestCmdRun_StampDirAndIdempotentTarget(t *testing.T) {
 	if _, err := os.Stat(unexpectedLocalStampPath); !os.IsNotExist(err) {
 		t.Fatalf("unexpected local stamp path exists: %s (err=%v)", unexpectedLocalStampPath, err)
 	}
+
+	if _, err := os.Stat(state.BootstrapMarkerPath(home)); err != nil {
+		t.Fatalf("Stat(bootstrap marker) after run: %v", err)
+	}
+	if status, healthy := checkHealth(state.LastRunPath(home), time.Hour, true, time.Now()); !healthy {
+		t.Fatalf("checkHealth() after run: got %q want healthy", status)
+	}
+	if summary, err := readStatusFile(state.StatusPath(home)); err != nil || summary.Result != "ok" || summary.Pending != statusPendingFalse {
+		t.Fatalf("readStatusFile() after run: got %+v, %v want result ok, pending false", summary, err)
+	}
+}
+
+func TestBootstrapMarkerAndSkips(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	marker := state.BootstrapMarkerPath(home)
+	if got := computedVars(&resolvedPlan{Home: home}, nil)["DECOMK_FIRST_BOOT"]; got != "true" {
+		t.Fatalf("DECOMK_FIRST_BOOT before marker: got %q want %q", got, "true")
+	}
+	if skip, _ := skipForBootstrapState(marker, true); skip {
+		t.Fatalf("skipForBootstrapState(bootstrap-only, no marker): got skip")
+	}
+	if skip, reason := skipForBootstrapState(marker, false); !skip || !strings.Contains(reason, "-converge-only") {
+		t.Fatalf("skipForBootstrapState(converge-only, no marker): got %v %q want skip", skip, reason)
+	}
+
+	first := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := writeBootstrapMarker(marker, first); err != nil {
+		t.Fatalf("writeBootstrapMarker(): %v", err)
+	}
+	if err := writeBootstrapMarker(marker, first.Add(time.Hour)); err != nil {
+		t.Fatalf("writeBootstrapMarker(second): %v", err)
+	}
+	content, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("ReadFile(marker): %v", err)
+	}
+	if got, want := string(content), "bootstrappedAt=2026-01-02T03:04:05Z\n"; !strings.HasPrefix(got, want) {
+		t.Fatalf("marker: got %q want prefix %q", got, want)
+	}
+	if got := computedVars(&resolvedPlan{Home: home}, nil)["DECOMK_FIRST_BOOT"]; got != "false" {
+		t.Fatalf("DECOMK_FIRST_BOOT after marker: got %q want %q", got, "false")
+	}
+	if skip, reason := skipForBootstrapState(marker, true); !skip || !strings.Contains(reason, "-bootstrap-only") {
+		t.Fatalf("skipForBootstrapState(bootstrap-only, marker): got %v %q want skip", skip, reason)
+	}
+	if skip, _ := skipForBootstrapState(marker, false); skip {
+		t.Fatalf("skipForBootstrapState(converge-only, marker): got skip")
+	}
+}
+
+func TestCmdPlan_ConvergeOnlySkipsBeforeBootstrap(t *testing.T) {
+	t.Parallel()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan([]string{"-home", t.TempDir(), "-converge-only", "INSTALL"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-converge-only) got code %d err %v want 0 nil", code, err)
+	}
+	if got, want := stdout.String(), "decomk: skipping plan: container has not bootstrapped yet"; !strings.HasPrefix(got, want) {
+		t.Fatalf("cmdPlan(-converge-only) stdout: got %q want prefix %q", got, want)
+	}
+
+	code, err = cmdPlan([]string{"-bootstrap-only", "-converge-only", "INSTALL"}, &stdout, &stderr)
+	if code != 2 || err == nil {
+		t.Fatalf("cmdPlan(both flags) got code %d err %v want 2 non-nil", code, err)
+	}
+}
+
+func TestRunTmpDirLifecycle(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	first, err := createRunTmpDir(home)
+	if err != nil {
+		t.Fatalf("createRunTmpDir() error: %v", err)
+	}
+	second, err := createRunTmpDir(home)
+	if err != nil {
+		t.Fatalf("createRunTmpDir() second error: %v", err)
+	}
+	if first == second || filepath.Dir(first) != state.RunTmpRoot(home) {
+		t.Fatalf("createRunTmpDir(): got %q and %q want distinct dirs under %s", first, second, state.RunTmpRoot(home))
+	}
+	if info, err := os.Stat(first); err != nil || info.Mode().Perm() != 0o777 || info.Mode()&os.ModeSticky == 0 {
+		t.Fatalf("run tmp dir mode: got %v (err=%v) want sticky 0777", info.Mode(), err)
+	}
+
+	var out bytes.Buffer
+	if err := finishRunTmpDir(first, true, &out); err != nil {
+		t.Fatalf("finishRunTmpDir(keep) error: %v", err)
+	}
+	if _, err := os.Stat(first); err != nil || !strings.Contains(out.String(), "kept DECOMK_RUN_TMP for debugging: "+first) {
+		t.Fatalf("finishRunTmpDir(keep): stat err=%v out=%q", err, out.String())
+	}
+	if err := finishRunTmpDir(second, false, &out); err != nil {
+		t.Fatalf("finishRunTmpDir() error: %v", err)
+	}
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Fatalf("finishRunTmpDir(): %s still exists (err=%v)", second, err)
+	}
 }
 
 func TestRenderRunMotdBody_Success(t *testing.T) {
@@ -1207,3 +1511,623 @@ func TestWriteRunMotdSummary_PrimaryAndFallback(t *testing.T) {
 		}
 	})
 }
+
+func TestManagedPathTuples(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		tuples  []string
+		env     map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "no prepend leaves tuples untouched",
+			tuples: []string{"FOO=bar"},
+			env:    map[string]string{"PATH": "/usr/bin:/bin"},
+			want:   []string{"FOO=bar"},
+		},
+		{
+			name:   "prepends cleaned entries and dedupes against incoming PATH",
+			tuples: []string{"DECOMK_PATH_PREPEND=/opt/go/bin/ /usr/local/bin:/opt/go/bin"},
+			env:    map[string]string{"PATH": "/usr/local/bin:/usr/bin::/bin"},
+			want: []string{
+				"DECOMK_PATH_PREPEND=/opt/go/bin/ /usr/local/bin:/opt/go/bin",
+				"PATH=/opt/go/bin:/usr/local/bin:/usr/bin:/bin",
+			},
+		},
+		{
+			name:   "config PATH tuple is the base when present",
+			tuples: []string{"PATH=/cfg/bin", "DECOMK_PATH_PREPEND=/tools/bin"},
+			env:    map[string]string{"PATH": "/usr/bin"},
+			want: []string{
+				"PATH=/cfg/bin",
+				"DECOMK_PATH_PREPEND=/tools/bin",
+				"PATH=/tools/bin:/cfg/bin",
+			},
+		},
+		{
+			name:    "relative entries fail fast",
+			tuples:  []string{"DECOMK_PATH_PREPEND=bin"},
+			env:     map[string]string{"PATH": "/usr/bin"},
+			wantErr: `DECOMK_PATH_PREPEND: entry "bin" must be an absolute path`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := managedPathTuples(tc.tuples, tc.env)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("managedPathTuples() error: got %v want substring %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("managedPathTuples() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("managedPathTuples(): got %#v want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCmdPlan_ToolchainTokensGenerateMakefileAndTuples(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: mise:python@3.12\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("all:\n\t@echo all\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	args := []string{
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"DECOMK_TOOLCHAIN_TARGETS",
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdPlan() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdPlan() code: got %d want 0", code)
+	}
+
+	generated := state.ToolchainsMakefile(home)
+	if _, err := os.Stat(generated); err != nil {
+		t.Fatalf("Stat(generated makefile): %v", err)
+	}
+	outText := stdout.String()
+	for _, needle := range []string{
+		"makefile (generated): " + generated,
+		"MISE_PYTHON_VERSION=3.12",
+		"-f " + makefilePath + " -f " + generated,
+		"mise install 'python@3.12'",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+}
+
+func TestCmdPlan_TraceExpand(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: Block10\nBlock10: FOO=x\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("all:\n\t@echo all\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan([]string{
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"-trace-expand",
+		"all",
+	}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-trace-expand): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "expansion trace:\n  FOO=x <- DEFAULT > Block10\n") {
+		t.Fatalf("stdout missing expansion trace:\n%s", stdout.String())
+	}
+
+	_, err = cmdPlan([]string{"-home", home, "-profile", "any", "-trace-expand", "all"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "-trace-expand cannot be used with -profile") {
+		t.Fatalf("cmdPlan(-trace-expand -profile) error: got %v", err)
+	}
+}
+
+func TestCmdPlan_WarnsOnLargeExpansion(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: A=1 B=2 C=3\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("all:\n\t@echo all\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	args := []string{
+		"-home", t.TempDir(),
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"-warn-expand-tokens", "2",
+		"all",
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-warn-expand-tokens): code=%d err=%v", code, err)
+	}
+	if !strings.Contains(stderr.String(), "decomk: warning: expansion produced more than 2 tokens") {
+		t.Fatalf("stderr missing expansion warning: %q", stderr.String())
+	}
+
+	args = append([]string{"-max-expand-tokens", "2"}, args...)
+	_, err = cmdPlan(args, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "max expansion size exceeded (2 tokens)") {
+		t.Fatalf("cmdPlan(-max-expand-tokens 2) error: got %v", err)
+	}
+}
+
+func TestCmdPlan_InlineRecipesGenerateMakefile(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	conf := "DEFAULT: FOO=bar\nrecipe install-jq: echo installing jq && touch $@\n"
+	if err := os.WriteFile(configPath, []byte(conf), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("all:\n\t@echo all\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	args := []string{
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"install-jq",
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdPlan() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdPlan() code: got %d want 0", code)
+	}
+
+	generated := state.RecipesMakefile(home)
+	content, err := os.ReadFile(generated)
+	if err != nil {
+		t.Fatalf("ReadFile(generated makefile): %v", err)
+	}
+	if got, want := string(content), "install-jq:\n\techo installing jq && touch $@\n"; !strings.Contains(got, want) {
+		t.Fatalf("generated makefile: got %q want substring %q", got, want)
+	}
+	outText := stdout.String()
+	for _, needle := range []string{
+		"makefile (generated): " + generated,
+		"-f " + makefilePath + " -f " + generated,
+		"echo installing jq && touch install-jq",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+}
+
+func TestCmdPlan_TemplateTokensGenerateMakefile(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: tmpl:github-release(cli/cli,gh)\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("all:\n\t@echo all\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(confDir, "templates"), 0o755); err != nil {
+		t.Fatalf("Mkdir(templates): %v", err)
+	}
+	template := "# params: REPO BIN\n{{.Target}}:\n\techo fetching {{.BIN}} from {{.REPO}}\n\ttouch $@\n"
+	if err := os.WriteFile(filepath.Join(confDir, "templates", "github-release.mk"), []byte(template), 0o600); err != nil {
+		t.Fatalf("WriteFile(template): %v", err)
+	}
+
+	args := []string{
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"DECOMK_TEMPLATE_TARGETS",
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdPlan() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdPlan() code: got %d want 0", code)
+	}
+
+	generated := state.TemplatesMakefile(home)
+	outText := stdout.String()
+	for _, needle := range []string{
+		"makefile (generated): " + generated,
+		"DECOMK_TEMPLATE_TARGETS=tmpl-github-release-cli-cli-gh",
+		"-f " + makefilePath + " -f " + generated,
+		"echo fetching gh from cli/cli",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(confDir, "templates", "github-release.mk")); err != nil {
+		t.Fatalf("Remove(template): %v", err)
+	}
+	if _, err := cmdPlan(args, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "read template") {
+		t.Fatalf("cmdPlan(missing template) error: got %v", err)
+	}
+}
+
+func TestResolveConfDir(t *testing.T) {
+	home := "/var/decomk"
+
+	t.Setenv("DECOMK_CONF_PATH", "")
+	if got, err := resolveConfDir(home, ""); err != nil || got != "/var/decomk/conf" {
+		t.Fatalf("resolveConfDir(default): got %q, %v", got, err)
+	}
+	if got, err := resolveConfDir(home, "bootstrap/decomk/"); err != nil || got != "/var/decomk/conf/bootstrap/decomk" {
+		t.Fatalf("resolveConfDir(flag): got %q, %v", got, err)
+	}
+
+	t.Setenv("DECOMK_CONF_PATH", "infra")
+	if got, err := resolveConfDir(home, ""); err != nil || got != "/var/decomk/conf/infra" {
+		t.Fatalf("resolveConfDir(env): got %q, %v", got, err)
+	}
+
+	for _, bad := range []string{"/abs", "../escape", "a/../../b"} {
+		if _, err := resolveConfDir(home, bad); err == nil {
+			t.Fatalf("resolveConfDir(%q): expected error", bad)
+		}
+	}
+}
+
+func TestLoadDefsAndMakefile_ConfSubdirectory(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	confDir := filepath.Join(state.ConfDir(home), "bootstrap")
+	if err := os.MkdirAll(confDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll(confDir): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(confDir, "decomk.conf"), []byte("DEFAULT: FOO=sub\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(decomk.conf): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(confDir, "Makefile"), []byte("all:\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(Makefile): %v", err)
+	}
+
+	cfg, err := loadDefs(confDir, "")
+	if err != nil {
+		t.Fatalf("loadDefs() error: %v", err)
+	}
+	if got, want := cfg.Defs["DEFAULT"], []string{"FOO=sub"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("DEFAULT tokens: got %#v want %#v", got, want)
+	}
+	if got, want := cfg.Paths, []string{filepath.Join(confDir, "decomk.conf")}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("paths: got %#v want %#v", got, want)
+	}
+	if got, want := findDefaultMakefile(confDir, ""), filepath.Join(confDir, "Makefile"); got != want {
+		t.Fatalf("findDefaultMakefile(): got %q want %q", got, want)
+	}
+}
+
+func TestResolveMakefiles(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	explicitDir := t.TempDir()
+	write := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte("all:\n"), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+	write(filepath.Join(confDir, "Makefile"))
+	write(filepath.Join(confDir, "base.mk"))
+	write(filepath.Join(confDir, "lang-go.mk"))
+	write(filepath.Join(explicitDir, "lang-go.mk"))
+	explicitConfig := filepath.Join(explicitDir, "decomk.conf")
+
+	got, _, err := resolveMakefiles("", nil, confDir, "", nil)
+	if err != nil {
+		t.Fatalf("resolveMakefiles(default) error: %v", err)
+	}
+	if want := []string{filepath.Join(confDir, "Makefile")}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveMakefiles(default): got %#v want %#v", got, want)
+	}
+
+	tuples := map[string]string{"DECOMK_MAKEFILES": "base.mk  lang-go.mk"}
+	got, _, err = resolveMakefiles("", tuples, confDir, explicitConfig, nil)
+	if err != nil {
+		t.Fatalf("resolveMakefiles(tuple) error: %v", err)
+	}
+	want := []string{filepath.Join(confDir, "base.mk"), filepath.Join(explicitDir, "lang-go.mk")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveMakefiles(tuple): got %#v want %#v", got, want)
+	}
+
+	_, _, err = resolveMakefiles("", map[string]string{"DECOMK_MAKEFILES": "missing.mk"}, confDir, "", nil)
+	if err == nil || !strings.Contains(err.Error(), `DECOMK_MAKEFILES: makefile "missing.mk" not found`) {
+		t.Fatalf("resolveMakefiles(missing) error: got %v", err)
+	}
+
+	tuples = map[string]string{"DECOMK_MAKEFILES": "base.mk go=lang-go.mk"}
+	got, namespaces, err := resolveMakefiles("", tuples, confDir, "", nil)
+	if err != nil {
+		t.Fatalf("resolveMakefiles(namespace) error: %v", err)
+	}
+	if want := []string{filepath.Join(confDir, "base.mk")}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveMakefiles(namespace): got %#v want %#v", got, want)
+	}
+	if want := []makefileNamespace{{Name: "go", Path: filepath.Join(confDir, "lang-go.mk")}}; !reflect.DeepEqual(namespaces, want) {
+		t.Fatalf("resolveMakefiles(namespace) namespaces: got %#v want %#v", namespaces, want)
+	}
+	for raw, wantErr := range map[string]string{
+		"base.mk go=lang-go.mk go=base.mk": `namespace "go" is used twice`,
+		"go=lang-go.mk":                    "every entry is namespaced",
+	} {
+		_, _, err = resolveMakefiles("", map[string]string{"DECOMK_MAKEFILES": raw}, confDir, "", nil)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("resolveMakefiles(%q) error: got %v want substring %q", raw, err, wantErr)
+		}
+	}
+}
+
+func TestCmdPlan_MakefilesTuplePassesOrderedFlags(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: DECOMK_MAKEFILES='base.mk extra.mk'\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(confDir, "base.mk"), []byte("all: extra\n\t@echo base\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(base.mk): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(confDir, "extra.mk"), []byte("extra:\n\t@echo extra-marker\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(extra.mk): %v", err)
+	}
+
+	args := []string{
+		"-home", t.TempDir(),
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"all",
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdPlan() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdPlan() code: got %d want 0", code)
+	}
+	outText := stdout.String()
+	needle := "-f " + filepath.Join(confDir, "base.mk") + " -f " + filepath.Join(confDir, "extra.mk")
+	if !strings.Contains(outText, needle) {
+		t.Fatalf("stdout missing ordered -f flags %q:\n%s", needle, outText)
+	}
+	if !strings.Contains(outText, "echo extra-marker") {
+		t.Fatalf("stdout missing make -n output from second makefile:\n%s", outText)
+	}
+}
+
+func TestCmdPlan_NamespacedMakefilesDoNotCollide(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	conf := "DEFAULT: FOO=bar DECOMK_MAKEFILES='base.mk go=go.mk py=py.mk'\n"
+	files := map[string]string{
+		"decomk.conf": conf,
+		"base.mk":     "all: go/install py/install\n\t@echo base\n",
+		"go.mk":       "install:\n\techo go-install\n\ttouch $@\n",
+		"py.mk":       "install:\n\techo py-install $(FOO)\n\ttouch $@\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(confDir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+	}
+
+	home := t.TempDir()
+	args := []string{
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"all",
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdPlan() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdPlan() code: got %d want 0", code)
+	}
+	outText := stdout.String()
+	for _, needle := range []string{
+		"makefile (namespace go/): " + filepath.Join(confDir, "go.mk"),
+		"makefile (generated): " + state.NamespacesMakefile(home),
+		"-f " + filepath.Join(confDir, "base.mk") + " -f " + state.NamespacesMakefile(home),
+		"echo go-install",
+		"echo py-install bar",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+}
+
+func TestWorkspaceContextSeeds_OwnerFallback(t *testing.T) {
+	t.Parallel()
+
+	defs := contexts.Defs{
+		"DEFAULT":   {"FOO=bar"},
+		"acme/*":    {"ORG=acme"},
+		"acme/app":  {"APP=1"},
+		"globex":    {"ORG=globex"},
+		"initech/*": {"ORG=initech"},
+		"initech":   {"ORG=bare"},
+	}
+	repos := []workspaceRepo{
+		{Name: "app", OwnerRepo: "acme/app", RepoName: "app"},
+		{Name: "tools", OwnerRepo: "acme/tools", RepoName: "tools"},
+		{Name: "web", OwnerRepo: "globex/web", RepoName: "web"},
+		{Name: "svc", OwnerRepo: "initech/svc", RepoName: "svc"},
+		{Name: "local", RepoName: "local"},
+	}
+	seeds := workspaceContextSeeds(defs, repos)
+	got := seedKeys(seeds)
+	want := []string{"acme/app", "acme/*", "globex", "initech/*"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("workspaceContextSeeds(): got %q want %q", got, want)
+	}
+	if want := "workspace tools matched by owner wildcard"; seeds[1].Source != seedSourceWorkspace || seeds[1].Reason != want {
+		t.Fatalf("workspaceContextSeeds()[1]: got %+v want reason %q", seeds[1], want)
+	}
+}
+
+func TestCmdPlan_SeedsAndNoDefault(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	files := map[string]string{
+		configPath:                         "DEFAULT: BASE=1\nother: OTHER=1\n",
+		filepath.Join(confDir, "Makefile"): "all:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath}
+
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdPlan(append(append([]string(nil), args...), "-context", "other", "all"), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-context other): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	for _, want := range []string{"context seed (default): DEFAULT: always seeded first", "context seed (flag): other: -context other", "BASE=", "OTHER="} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("cmdPlan(-context other): stdout missing %q:\n%s", want, stdout.String())
+		}
+	}
+
+	stdout.Reset()
+	if code, err := cmdPlan(append(append([]string(nil), args...), "-no-default", "-context", "other", "all"), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-no-default): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if strings.Contains(stdout.String(), "BASE=") || strings.Contains(stdout.String(), "(default)") || !strings.Contains(stdout.String(), "contexts: other\n") {
+		t.Fatalf("cmdPlan(-no-default): stdout seeds DEFAULT:\n%s", stdout.String())
+	}
+
+	code, err := cmdPlan(append(append([]string(nil), args...), "-no-default", "all"), &stdout, &stderr)
+	if code != 1 || err == nil || !strings.Contains(err.Error(), "no context to expand") {
+		t.Fatalf("cmdPlan(-no-default, no context): got code %d, err %v want 1, no context to expand", code, err)
+	}
+}
+
+func TestCmdPlan_ReportUnusedKeys(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	conf := "DECOMK_TOOL_REF: v1.2.3\n" +
+		"Block00: A=1\n" +
+		"Block10: B=2\n" +
+		"Dead: C=3\n" +
+		"acme/app: Block10\n" +
+		"other: D=4\n" +
+		"DEFAULT: Block00 ?A=2 -> Block20\n" +
+		"Block20: E=5\n"
+	files := map[string]string{
+		configPath:                         conf,
+		filepath.Join(confDir, "Makefile"): "all:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath, "all"}
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdPlan(args, &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if strings.Contains(stderr.String(), "not reachable") {
+		t.Fatalf("cmdPlan() without -report-unused warned: %q", stderr.String())
+	}
+
+	stderr.Reset()
+	if code, err := cmdPlan(append([]string{"-report-unused"}, args...), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-report-unused): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	for _, key := range []string{"Dead", "other"} {
+		if !strings.Contains(stderr.String(), "config key \""+key+"\" is not reachable") {
+			t.Fatalf("stderr missing warning for %s: %q", key, stderr.String())
+		}
+	}
+	if got := strings.Count(stderr.String(), "is not reachable"); got != 2 {
+		t.Fatalf("unused warnings: got %d want 2 (%q)", got, stderr.String())
+	}
+
+	stderr.Reset()
+	if code, err := cmdPlan(append([]string{"-report-unused", "-context", "other"}, args...), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-context other): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if strings.Contains(stderr.String(), `"other"`) {
+		t.Fatalf("selected context reported unused: %q", stderr.String())
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// makeDatabaseNoopGoal is the goal make is asked to build while printing its
+// rule database. It is defined on make's stdin with an empty recipe, so the
+// Makefile's default goal (and any `+` recipe lines) never runs.
+const makeDatabaseNoopGoal = ".decomk-db-noop"
+
+// makeTargetVarPattern matches the rest of a make database line of the form
+// "target: VAR := value" (a target-specific variable, not a rule).
+var makeTargetVarPattern = regexp.MustCompile(`^\s*[^\s:=]+\s*(::=|:=|\+=|\?=|!=|=)`)
+
+// makeRecipeFromPattern matches the make database comment that locates the
+// recipe of the rule above it.
+var makeRecipeFromPattern = regexp.MustCompile(`^#\s+recipe to execute \(from '(.*)', line (\d+)\):$`)
+
+// makeRules is the rule graph read from make's printed database.
+type makeRules struct {
+	// Prereqs maps each explicit target to its normal and order-only
+	// prerequisites.
+	Prereqs map[string][]string
+	// Patterns are pattern rules (targets containing '%'), in database order.
+	Patterns []makePatternRule
+	// RecipeFrom maps each explicit target that has a recipe to the
+	// "file:line" the recipe was read from.
+	RecipeFrom map[string]string
+	// Commands maps each explicit target that has a recipe to its recipe
+	// lines, without the recipe prefix.
+	Commands map[string][]string
+	// Vars holds the variables set by makefiles (not the environment or the
+	// command line), with := variables already expanded.
+	Vars map[string]string
+}
+
+// makePatternRule is one pattern rule; '%' in Prereqs stands for the stem.
+type makePatternRule struct {
+	Target  string
+	Prereqs []string
+	// RecipeFrom is the "file:line" of the rule's recipe, if it has one.
+	RecipeFrom string
+	// Commands are the rule's recipe lines, without the recipe prefix.
+	Commands []string
+}
+
+// makeDatabase returns make's printed rule database for makefiles, evaluated
+// in dir with tuples and env, without running any recipe.
+func makeDatabase(dir string, makefiles, tuples, env []string) (string, error) {
+	args := []string{"-pRrq"}
+	for _, makefile := range makefiles {
+		args = append(args, "-f", makefile)
+	}
+	args = append(args, "-f", "-")
+	args = append(args, tuples...)
+	args = append(args, makeDatabaseNoopGoal)
+
+	cmd := exec.Command("make", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdin = strings.NewReader(makeDatabaseNoopGoal + ": ;\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// -q exits 1 when the goal is out of date; the database is complete.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return "", fmt.Errorf("read make rule database: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	return stdout.String(), nil
+}
+
+// parseMakeDatabase extracts rules from `make -p` output.
+func parseMakeDatabase(database string) makeRules {
+	rules := makeRules{
+		Prereqs:    make(map[string][]string),
+		RecipeFrom: make(map[string]string),
+		Commands:   make(map[string][]string),
+		Vars:       make(map[string]string),
+	}
+	inRules := false
+	notTarget := false
+	// fromMakefile is set by the origin comment above a makefile variable.
+	fromMakefile := false
+	// The variables section comes first, so .RECIPEPREFIX is known before
+	// any recipe line.
+	recipePrefix := "\t"
+	// lastTarget is the explicit target of the most recent rule line, and
+	// lastPattern the index of the most recent pattern rule (or -1).
+	lastTarget, lastPattern := "", -1
+	scanner := bufio.NewScanner(strings.NewReader(database))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "# Implicit Rules":
+			inRules = true
+			continue
+		case strings.HasPrefix(line, "# Finished Make data base"):
+			inRules = false
+			continue
+		case line == "# Not a target:":
+			notTarget = true
+			continue
+		}
+		if !inRules {
+			if fromMakefile {
+				for _, op := range []string{" := ", " = "} {
+					if name, value, ok := strings.Cut(line, op); ok {
+						rules.Vars[name] = value
+						break
+					}
+				}
+			}
+			fromMakefile = strings.HasPrefix(line, "# makefile")
+			if prefix := rules.Vars[".RECIPEPREFIX"]; prefix != "" {
+				recipePrefix = prefix[:1]
+			}
+			continue
+		}
+		if command, ok := strings.CutPrefix(line, recipePrefix); ok {
+			if lastPattern >= 0 {
+				rules.Patterns[lastPattern].Commands = append(rules.Patterns[lastPattern].Commands, command)
+			} else if lastTarget != "" {
+				rules.Commands[lastTarget] = append(rules.Commands[lastTarget], command)
+			}
+			continue
+		}
+		if m := makeRecipeFromPattern.FindStringSubmatch(line); m != nil {
+			from := m[1] + ":" + m[2]
+			if lastPattern >= 0 {
+				rules.Patterns[lastPattern].RecipeFrom = from
+			} else if lastTarget != "" {
+				rules.RecipeFrom[lastTarget] = from
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 || makeTargetVarPattern.MatchString(line[colon+1:]) {
+			continue
+		}
+		lastTarget, lastPattern = "", -1
+		if notTarget {
+			notTarget = false
+			continue
+		}
+		name := line[:colon]
+		var prereqs []string
+		for _, field := range strings.Fields(strings.TrimLeft(line[colon+1:], ":")) {
+			if field != "|" {
+				prereqs = append(prereqs, field)
+			}
+		}
+		if strings.Contains(name, "%") {
+			rules.Patterns = append(rules.Patterns, makePatternRule{Target: name, Prereqs: prereqs})
+			lastPattern = len(rules.Patterns) - 1
+			continue
+		}
+		rules.Prereqs[name] = append(rules.Prereqs[name], prereqs...)
+		lastTarget = name
+	}
+	return rules
+}
+
+// Defines reports whether name is an explicit target or matches a pattern
+// rule.
+func (r makeRules) Defines(name string) bool {
+	if _, ok := r.Prereqs[name]; ok {
+		return true
+	}
+	for _, pattern := range r.Patterns {
+		if _, ok := patternStem(pattern.Target, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRecipe reports whether name has an explicit recipe or matches a pattern
+// rule that has one.
+func (r makeRules) hasRecipe(name string) bool {
+	if len(r.Commands[name]) > 0 {
+		return true
+	}
+	for _, pattern := range r.Patterns {
+		if _, ok := patternStem(pattern.Target, name); ok && len(pattern.Commands) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PrereqsOf returns name's explicit prerequisites plus those of every pattern
+// rule it matches (with the stem substituted). Counting every matching
+// pattern over-approximates make's choice of one, which only makes callers
+// more conservative.
+func (r makeRules) PrereqsOf(name string) []string {
+	prereqs := append([]string(nil), r.Prereqs[name]...)
+	for _, pattern := range r.Patterns {
+		stem, ok := patternStem(pattern.Target, name)
+		if !ok {
+			continue
+		}
+		for _, prereq := range pattern.Prereqs {
+			prereqs = append(prereqs, strings.Replace(prereq, "%", stem, 1))
+		}
+	}
+	return prereqs
+}
+
+// Closure returns target and everything it transitively depends on.
+func (r makeRules) Closure(target string) map[string]bool {
+	seen := map[string]bool{}
+	stack := []string{target}
+	for len(stack) > 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		stack = append(stack, r.PrereqsOf(name)...)
+	}
+	return seen
+}
+
+// patternStem returns the stem when name matches pattern (exactly one '%'
+// standing for a non-empty stem).
+func patternStem(pattern, name string) (string, bool) {
+	i := strings.IndexByte(pattern, '%')
+	if i < 0 {
+		return "", false
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMakeDatabase(t *testing.T) {
+	t.Parallel()
+
+	database := `# GNU Make 4.3
+# Variables
+
+FOO = bar
+
+# Implicit Rules
+
+stamp-%: src-%
+	touch $@
+
+# 1 implicit rules, 0 (0.0%) terminal.
+# Files
+
+# Not a target:
+Makefile:
+
+install-jq: Block00 | tools
+#  recipe to execute (from 'Makefile', line 4):
+	touch $@
+
+Block00:
+	touch $@
+
+install-jq: VAR := value
+
+clean::
+	rm -f *
+
+# Finished Make data base on Thu Jan  1 00:00:00 1970
+
+after-finish:
+`
+	rules := parseMakeDatabase(database)
+	want := map[string][]string{
+		"Block00":    nil,
+		"clean":      nil,
+		"install-jq": {"Block00", "tools"},
+	}
+	if !reflect.DeepEqual(rules.Prereqs, want) {
+		t.Fatalf("parseMakeDatabase() prereqs: got %q want %q", rules.Prereqs, want)
+	}
+	if got, want := rules.RecipeFrom, map[string]string{"install-jq": "Makefile:4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseMakeDatabase() recipe locations: got %q want %q", got, want)
+	}
+	if got, want := rules.Patterns, []makePatternRule{{Target: "stamp-%", Prereqs: []string{"src-%"}, Commands: []string{"touch $@"}}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseMakeDatabase() patterns: got %#v want %#v", got, want)
+	}
+	if !rules.Defines("stamp-foo") || rules.Defines("stamp-") || rules.Defines("after-finish") {
+		t.Fatalf("Defines(): stamp-foo should match; stamp- and after-finish should not")
+	}
+	if got, want := rules.PrereqsOf("stamp-foo"), []string{"src-foo"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("PrereqsOf(stamp-foo): got %q want %q", got, want)
+	}
+	closure := rules.Closure("install-jq")
+	for _, name := range []string{"install-jq", "Block00", "tools"} {
+		if !closure[name] {
+			t.Fatalf("Closure(install-jq): missing %q in %v", name, closure)
+		}
+	}
+}
+
+func TestParseMakeDatabase_RecipePrefixAndVars(t *testing.T) {
+	t.Parallel()
+
+	database := `# Variables
+
+# environment
+HOME = /root
+# makefile (from 'Makefile', line 3)
+.SHELLFLAGS := -euo pipefail -c
+# makefile (from 'Makefile', line 4)
+.RECIPEPREFIX := >
+
+# Implicit Rules
+
+# Files
+
+phase-check:
+#  recipe to execute (from 'Makefile', line 7):
+>@if [[ "$${PHASE:-}" != "x" ]]; then \
+>  echo "got '$${PHASE:-<unset>}'"; \
+>fi
+>@touch $@
+
+# Finished Make data base
+`
+	rules := parseMakeDatabase(database)
+	if got, want := rules.Vars, map[string]string{".SHELLFLAGS": "-euo pipefail -c", ".RECIPEPREFIX": ">"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseMakeDatabase() vars: got %q want %q", got, want)
+	}
+	if got, want := len(rules.Prereqs), 1; got != want {
+		t.Fatalf("parseMakeDatabase() targets: got %q, recipe lines were parsed as rules", rules.Prereqs)
+	}
+	commands := rules.Commands["phase-check"]
+	if len(commands) != 4 || commands[3] != "@touch $@" {
+		t.Fatalf("parseMakeDatabase() commands: got %q", commands)
+	}
+}
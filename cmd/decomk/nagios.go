@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// Monitoring plugin states and their exit codes.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+// nagiosStateNames maps a monitoring plugin exit code to its name.
+var nagiosStateNames = map[int]string{
+	nagiosOK:       "OK",
+	nagiosWarning:  "WARNING",
+	nagiosCritical: "CRITICAL",
+	nagiosUnknown:  "UNKNOWN",
+}
+
+// nagiosCheck evaluates home's run state as a monitoring plugin and returns
+// its exit code and output line: a failed last run is CRITICAL, pending
+// targets or a last success older than maxAge are WARNING, a last success
+// older than critAge is CRITICAL, and missing or unreadable state is UNKNOWN.
+// The perfdata reports the pending target count (when checkPending) and the
+// hours since the last successful run.
+//
+// Intent: Plug decomk into Nagios, Icinga, and Sensu checks for pet VMs
+// managed isconf-style.
+// Source: DI-vajok (TODO-jirin)
+func nagiosCheck(home string, maxAge, critAge time.Duration, checkPending bool, now time.Time) (int, string) {
+	record, err := readLastRun(state.LastRunPath(home))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nagiosLine(nagiosUnknown, "no recorded run", nil)
+		}
+		return nagiosLine(nagiosUnknown, err.Error(), nil)
+	}
+
+	var perfdata []string
+	var problems []string
+	code := nagiosOK
+	raise := func(to int, problem string) {
+		code = max(code, to)
+		problems = append(problems, problem)
+	}
+
+	if record.ExitCode != 0 {
+		raise(nagiosCritical, fmt.Sprintf("last run failed (exit %d) at %s", record.ExitCode, record.FinishedAt.UTC().Format(time.RFC3339)))
+	}
+	if checkPending && record.ExitCode == 0 {
+		pending, err := pendingTargets(record)
+		if err != nil {
+			return nagiosLine(nagiosUnknown, err.Error(), nil)
+		}
+		perfdata = append(perfdata, fmt.Sprintf("pending=%d;0;;0;%d", len(pending), len(record.Targets)))
+		if len(pending) > 0 {
+			raise(nagiosWarning, "targets pending: "+strings.Join(pending, " "))
+		}
+	}
+
+	success, ok := lastSuccess(home, record)
+	if !ok {
+		raise(nagiosCritical, "no successful run recorded")
+	} else {
+		age := now.Sub(success)
+		perfdata = append(perfdata, fmt.Sprintf("hours_since_success=%.2f;%s;%s;0", age.Hours(), nagiosThreshold(maxAge), nagiosThreshold(critAge)))
+		switch {
+		case critAge > 0 && age > critAge:
+			raise(nagiosCritical, fmt.Sprintf("last success is older than %s", critAge))
+		case maxAge > 0 && age > maxAge:
+			raise(nagiosWarning, fmt.Sprintf("last success is older than %s", maxAge))
+		}
+	}
+
+	summary := "last run succeeded at " + record.FinishedAt.UTC().Format(time.RFC3339)
+	if len(problems) > 0 {
+		summary = strings.Join(problems, "; ")
+	}
+	return nagiosLine(code, summary, perfdata)
+}
+
+// nagiosLine formats a monitoring plugin output line for code.
+func nagiosLine(code int, summary string, perfdata []string) (int, string) {
+	line := "DECOMK " + nagiosStateNames[code] + " - " + summary
+	if len(perfdata) > 0 {
+		line += " | " + strings.Join(perfdata, " ")
+	}
+	return code, line
+}
+
+// nagiosThreshold renders d in hours as a perfdata threshold, or "" when
+// the threshold is disabled.
+func nagiosThreshold(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%g", d.Hours())
+}
+
+// pendingTargets replays record's make arguments with -q once per target and
+// returns the targets make reports out of date.
+func pendingTargets(record *lastRun) ([]string, error) {
+	n := len(record.MakeArgs) - len(record.Targets)
+	if n < 0 {
+		return nil, fmt.Errorf("last run record lists more targets than make arguments")
+	}
+	args := record.MakeArgs[:n]
+	var pending []string
+	for _, target := range dedupeStrings(record.Targets) {
+		out, err := makeQuestion(record.StampDir, append(append([]string(nil), args...), target), nil)
+		if err != nil {
+			return nil, err
+		}
+		if out {
+			pending = append(pending, target)
+		}
+	}
+	return pending, nil
+}
+
+// lastSuccess returns when the newest successful run finished: the last run
+// itself, or else the newest successful run in the timings history.
+func lastSuccess(home string, record *lastRun) (time.Time, bool) {
+	if record.ExitCode == 0 {
+		return record.FinishedAt, true
+	}
+	records, err := readTimingRecords(state.TimingsPath(home))
+	if err != nil {
+		return time.Time{}, false
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].ExitCode == 0 {
+			return records[i].FinishedAt, true
+		}
+	}
+	return time.Time{}, false
+}
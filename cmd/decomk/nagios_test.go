@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestNagiosCheck(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	home := t.TempDir()
+	stampDir := t.TempDir()
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(makefilePath, []byte("Block00 Block10:\n\ttouch $@\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	targets := []string{"Block00", "Block10"}
+	record := lastRun{
+		FinishedAt: now.Add(-2 * time.Hour),
+		Targets:    targets,
+		StampDir:   stampDir,
+		MakeArgs:   lastRunMakeArgs([]string{makefilePath}, nil, targets),
+	}
+
+	check := func(maxAge, critAge time.Duration, wantCode int, want string) {
+		t.Helper()
+		code, line := nagiosCheck(home, maxAge, critAge, true, now)
+		if code != wantCode || line != want {
+			t.Fatalf("nagiosCheck(%s, %s): got %d %q want %d %q", maxAge, critAge, code, line, wantCode, want)
+		}
+	}
+
+	check(time.Hour, 0, nagiosUnknown, "DECOMK UNKNOWN - no recorded run")
+
+	if err := writeLastRun(state.LastRunPath(home), record); err != nil {
+		t.Fatalf("writeLastRun(): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(stampDir, "Block00"), nil, 0o644); err != nil {
+		t.Fatalf("WriteFile(stamp): %v", err)
+	}
+	check(24*time.Hour, 0, nagiosWarning, "DECOMK WARNING - targets pending: Block10 | pending=1;0;;0;2 hours_since_success=2.00;24;;0")
+
+	if err := os.WriteFile(filepath.Join(stampDir, "Block10"), nil, 0o644); err != nil {
+		t.Fatalf("WriteFile(stamp): %v", err)
+	}
+	check(24*time.Hour, 0, nagiosOK, "DECOMK OK - last run succeeded at 2026-10-16T10:00:00Z | pending=0;0;;0;2 hours_since_success=2.00;24;;0")
+	check(time.Hour, 90*time.Minute, nagiosCritical, "DECOMK CRITICAL - last success is older than 1h30m0s | pending=0;0;;0;2 hours_since_success=2.00;1;1.5;0")
+
+	// A failed run reports the newest success from the timings history.
+	if err := appendTimingRecord(state.TimingsPath(home), timingRecord{FinishedAt: now.Add(-5 * time.Hour)}, timingHistory); err != nil {
+		t.Fatalf("appendTimingRecord(): %v", err)
+	}
+	record.ExitCode = 2
+	if err := writeLastRun(state.LastRunPath(home), record); err != nil {
+		t.Fatalf("writeLastRun(): %v", err)
+	}
+	check(0, 0, nagiosCritical, "DECOMK CRITICAL - last run failed (exit 2) at 2026-10-16T10:00:00Z | hours_since_success=5.00;;;0")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// makeNamespaceForceTarget is the phony prerequisite that makes every
+// namespace wrapper run its sub-make, which then decides from its own stamps.
+const makeNamespaceForceTarget = ".decomk-namespace-force"
+
+// makeNamespacePattern matches a namespaced DECOMK_MAKEFILES entry
+// (NAMESPACE=path).
+var makeNamespacePattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)=(.+)$`)
+
+// makefileNamespace is a makefile whose targets decomk exposes as
+// NAMESPACE/TARGET.
+type makefileNamespace struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// splitNamespaceEntry splits a DECOMK_MAKEFILES entry into its namespace
+// and makefile. Entries without a namespace (including URLs, whose scheme
+// keeps them from matching) return an empty namespace.
+func splitNamespaceEntry(entry string) (namespace, makefile string) {
+	if m := makeNamespacePattern.FindStringSubmatch(entry); m != nil {
+		return m[1], m[2]
+	}
+	return "", entry
+}
+
+// renderNamespacesMakefile renders the make fragment that maps NAMESPACE/TARGET
+// to TARGET of a sub-make run in the NAMESPACE stamp subdirectory.
+//
+// Intent: Let recipe libraries that reuse target names (install, setup) be
+// composed without one silently overriding the other; make has no
+// namespaces, so each library runs as its own sub-make with its own stamps.
+// Source: DI-jozul (TODO-jirin)
+func renderNamespacesMakefile(namespaces []makefileNamespace) []byte {
+	var b strings.Builder
+	b.WriteString("# generated by decomk from DECOMK_MAKEFILES namespaces; do not edit\n")
+	b.WriteString(".PHONY: " + makeNamespaceForceTarget + "\n")
+	b.WriteString(makeNamespaceForceTarget + ":\n\n")
+	for _, ns := range namespaces {
+		// The "target: prereq ; recipe" form works whatever .RECIPEPREFIX the
+		// configured makefiles set. `+` runs the recipe even under make -n, so
+		// plan shows the sub-make's own dry run; tuples reach it through
+		// MAKEFLAGS.
+		fmt.Fprintf(&b, "%s/%%: %s ; +mkdir -p %s && $(MAKE) --no-print-directory -C %s -f %s $*\n", ns.Name, makeNamespaceForceTarget, ns.Name, ns.Name, makeEscapeArg(ns.Path))
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitNamespaceEntry(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string][2]string{
+		"base.mk":                                  {"", "base.mk"},
+		"go=lang-go.mk":                            {"go", "lang-go.mk"},
+		"py.3=/abs/lang-py.mk":                     {"py.3", "/abs/lang-py.mk"},
+		"https://example.com/x.mk?a=b#sha256=00":   {"", "https://example.com/x.mk?a=b#sha256=00"},
+		"net=https://example.com/net.mk#sha256=00": {"net", "https://example.com/net.mk#sha256=00"},
+		"../shared/go=1.mk":                        {"", "../shared/go=1.mk"},
+	}
+	for entry, want := range cases {
+		namespace, makefile := splitNamespaceEntry(entry)
+		if namespace != want[0] || makefile != want[1] {
+			t.Fatalf("splitNamespaceEntry(%q): got (%q, %q) want (%q, %q)", entry, namespace, makefile, want[0], want[1])
+		}
+	}
+}
+
+func TestRenderNamespacesMakefile(t *testing.T) {
+	t.Parallel()
+
+	got := string(renderNamespacesMakefile([]makefileNamespace{{Name: "go", Path: "/conf/it's $HOME.mk"}}))
+	want := "go/%: .decomk-namespace-force ; +mkdir -p go && $(MAKE) --no-print-directory -C go -f '/conf/it'\"'\"'s $$HOME.mk' $*\n"
+	if !strings.HasSuffix(got, want) || !strings.Contains(got, ".PHONY: .decomk-namespace-force\n") {
+		t.Fatalf("renderNamespacesMakefile(): got %q want suffix %q", got, want)
+	}
+}
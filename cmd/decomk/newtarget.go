@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/stage0"
+)
+
+var (
+	// newTargetPlainTemplate is the new-target skeleton with a TODO recipe.
+	//
+	//go:embed templates/newtarget.plain.mk.tmpl
+	newTargetPlainTemplate string
+
+	// newTargetAptTemplate installs apt packages.
+	//
+	//go:embed templates/newtarget.apt.mk.tmpl
+	newTargetAptTemplate string
+
+	// newTargetGitCloneTemplate clones or fast-forwards a git repo.
+	//
+	//go:embed templates/newtarget.git-clone.mk.tmpl
+	newTargetGitCloneTemplate string
+
+	// newTargetDownloadTemplate downloads one file and installs it as an
+	// executable.
+	//
+	//go:embed templates/newtarget.download.mk.tmpl
+	newTargetDownloadTemplate string
+)
+
+// newTargetTemplates maps -template names to their sources.
+var newTargetTemplates = map[string]string{
+	"plain":     newTargetPlainTemplate,
+	"apt":       newTargetAptTemplate,
+	"git-clone": newTargetGitCloneTemplate,
+	"download":  newTargetDownloadTemplate,
+}
+
+// newTargetNamePattern restricts target names to plain make target names.
+var newTargetNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// newTargetRecipePrefixPattern matches a .RECIPEPREFIX assignment.
+var newTargetRecipePrefixPattern = regexp.MustCompile(`^\s*\.RECIPEPREFIX\s*:{0,2}=\s*(\S)`)
+
+// newTargetData is what a new-target template sees. URL, Dest, and Packages
+// are already shell-quoted and make-escaped.
+type newTargetData struct {
+	Name     string
+	P        string
+	Packages string
+	URL      string
+	Dest     string
+}
+
+// cmdNewTarget appends a stamp target to the config repo Makefile and
+// optionally adds it to a tuple's target list in decomk.conf.
+//
+// Intent: Lower the barrier for contributors unfamiliar with make by
+// generating targets that already follow the stamp idiom.
+// Source: DI-fabak (TODO-jirin)
+func cmdNewTarget(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk new-target", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var confRoot, confPath, templateName, packages, url, dest, contextKey, varName string
+	fs.StringVar(&confRoot, "conf-dir", ".", "config repo checkout to edit")
+	fs.StringVar(&confPath, "conf-path", "", "relative subdirectory of the config repo holding decomk.conf and Makefile (also DECOMK_CONF_PATH)")
+	fs.StringVar(&templateName, "template", "plain", "recipe template: plain, apt, git-clone, or download")
+	fs.StringVar(&packages, "packages", "", "apt packages to install, space- or comma-separated (apt; default NAME)")
+	fs.StringVar(&url, "url", "", "repository or file URL (git-clone, download)")
+	fs.StringVar(&dest, "dest", "", "clone directory or installed file (default /opt/NAME for git-clone, /usr/local/bin/NAME for download)")
+	fs.StringVar(&contextKey, "context", "", "decomk.conf key to add NAME to (see -var)")
+	fs.StringVar(&varName, "var", "INSTALL", "tuple of the -context key whose target list gets NAME")
+
+	// Accept NAME before the flags, as in the usage line.
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if name == "" && fs.NArg() == 1 {
+		name = fs.Arg(0)
+	} else if fs.NArg() != 0 || name == "" {
+		return 2, fmt.Errorf("usage: decomk new-target NAME [flags]")
+	}
+	if !newTargetNamePattern.MatchString(name) {
+		return 2, fmt.Errorf("invalid target name %q (allowed: letters, numbers, '.', '_', '-')", name)
+	}
+
+	data := newTargetData{Name: name}
+	switch templateName {
+	case "plain":
+	case "apt":
+		if packages == "" {
+			packages = name
+		}
+		var quoted []string
+		for _, pkg := range strings.FieldsFunc(packages, func(r rune) bool { return r == ',' || r == ' ' }) {
+			quoted = append(quoted, makeEscapeArg(pkg))
+		}
+		data.Packages = strings.Join(quoted, " ")
+	case "git-clone", "download":
+		if url == "" {
+			return 2, fmt.Errorf("-template %s requires -url", templateName)
+		}
+		if dest == "" {
+			dest = filepath.Join("/opt", name)
+			if templateName == "download" {
+				dest = filepath.Join("/usr/local/bin", name)
+			}
+		}
+		data.URL, data.Dest = makeEscapeArg(url), makeEscapeArg(dest)
+	default:
+		return 2, fmt.Errorf("unknown -template %q (expected plain, apt, git-clone, or download)", templateName)
+	}
+
+	confRoot, err := filepath.Abs(confRoot)
+	if err != nil {
+		return 1, fmt.Errorf("abs config repo path: %w", err)
+	}
+	confDir, err := resolveConfDirIn(confRoot, confPath)
+	if err != nil {
+		return 2, err
+	}
+	makefilePath := filepath.Join(confDir, "Makefile")
+	makefile, err := os.ReadFile(makefilePath)
+	if err != nil {
+		return 1, fmt.Errorf("read config repo Makefile: %w", err)
+	}
+	data.P = makefileRecipePrefix(makefile)
+	if makefileDefinesTarget(makefile, data.P, name) {
+		return 1, fmt.Errorf("%s already defines target %q", makefilePath, name)
+	}
+	rendered, err := stage0.RenderTemplate("newtarget."+templateName, newTargetTemplates[templateName], data)
+	if err != nil {
+		return 1, err
+	}
+
+	// Edit decomk.conf first so a bad -context leaves both files untouched.
+	if contextKey != "" {
+		confFile := filepath.Join(confDir, "decomk.conf")
+		conf, err := os.ReadFile(confFile)
+		if err != nil {
+			return 1, fmt.Errorf("read decomk.conf: %w", err)
+		}
+		edited, tuple, err := addTargetToContext(conf, contextKey, varName, name)
+		if err != nil {
+			return 1, fmt.Errorf("%s: %w", confFile, err)
+		}
+		if err := stage0.WriteFileAtomic(confFile, edited, 0o644); err != nil {
+			return 1, err
+		}
+		if err := writeFormat(stdout, "new-target: set %s in %s (%s)\n", tuple, contextKey, confFile); err != nil {
+			return 1, err
+		}
+	}
+
+	if len(makefile) > 0 && !bytes.HasSuffix(makefile, []byte("\n")) {
+		makefile = append(makefile, '\n')
+	}
+	if err := stage0.WriteFileAtomic(makefilePath, append(makefile, rendered...), 0o644); err != nil {
+		return 1, err
+	}
+	if err := writeFormat(stdout, "new-target: appended %s (%s template) to %s\n", name, templateName, makefilePath); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// makeEscapeArg shell-quotes s and escapes '$' for make.
+func makeEscapeArg(s string) string {
+	return strings.ReplaceAll(shellQuote(s), "$", "$$")
+}
+
+// makefileRecipePrefix returns the recipe prefix the Makefile sets with
+// .RECIPEPREFIX, or a tab.
+func makefileRecipePrefix(makefile []byte) string {
+	prefix := "\t"
+	for _, line := range strings.Split(string(makefile), "\n") {
+		if m := newTargetRecipePrefixPattern.FindStringSubmatch(line); m != nil {
+			prefix = m[1]
+		}
+	}
+	return prefix
+}
+
+// makefileDefinesTarget reports whether a rule line of makefile names target;
+// recipePrefix marks the recipe lines to skip.
+func makefileDefinesTarget(makefile []byte, recipePrefix, target string) bool {
+	for _, line := range strings.Split(string(makefile), "\n") {
+		if line == "" || strings.HasPrefix(line, recipePrefix) || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		rest := line[colon+1:]
+		if colon <= 0 || strings.ContainsAny(line[:colon], "=$") || strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ":=") || makeTargetVarPattern.MatchString(rest) {
+			continue
+		}
+		for _, name := range strings.Fields(line[:colon]) {
+			if name == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addTargetToContext adds target to the whitespace-separated value of the
+// last varName tuple written under key, or adds a varName=target line to the
+// key when it has none. It returns the edited config and the new tuple.
+func addTargetToContext(conf []byte, key, varName, target string) ([]byte, string, error) {
+	keyLine := regexp.MustCompile(`^\s*` + regexp.QuoteMeta(key) + `:(\s|$)`)
+	anyKeyLine := regexp.MustCompile(`^\s*(recipe\s+)?[^\s=:]+\s*:(\s|$)`)
+	tupleToken := regexp.MustCompile(`(^|\s)` + regexp.QuoteMeta(varName) + `=('[^']*'|[^\s']*)`)
+
+	lines := strings.Split(string(conf), "\n")
+	start := -1
+	for i, line := range lines {
+		if keyLine.MatchString(line) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return nil, "", fmt.Errorf("no key %q", key)
+	}
+	// The key's tokens run until the next key or recipe line; comments and
+	// blank lines in between do not end them.
+	last, tupleLine := start, -1
+	for i := start; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if i > start && anyKeyLine.MatchString(lines[i]) {
+			break
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		last = i
+		if tupleToken.MatchString(lines[i]) {
+			tupleLine = i
+		}
+	}
+
+	var tuple string
+	if tupleLine < 0 {
+		tuple = varName + "=" + target
+		lines = append(lines[:last+1], append([]string{"  " + tuple}, lines[last+1:]...)...)
+	} else {
+		matches := tupleToken.FindAllStringSubmatchIndex(lines[tupleLine], -1)
+		m := matches[len(matches)-1]
+		value := strings.Trim(lines[tupleLine][m[4]:m[5]], "'")
+		words := strings.Fields(value)
+		if value == "$" {
+			return nil, "", fmt.Errorf("%s=$ in key %q is an environment passthrough; add %s to it by hand", varName, key, target)
+		}
+		for _, word := range words {
+			if word == target {
+				return nil, "", fmt.Errorf("%s in key %q already lists %s", varName, key, target)
+			}
+		}
+		tuple = varName + "='" + strings.Join(append(words, target), " ") + "'"
+		line := lines[tupleLine]
+		lines[tupleLine] = line[:m[4]-len(varName)-1] + tuple + line[m[5]:]
+	}
+	edited := []byte(strings.Join(lines, "\n"))
+	cfg, err := contexts.Parse(bytes.NewReader(edited))
+	if err != nil {
+		return nil, "", fmt.Errorf("edited config does not parse: %w", err)
+	}
+	if _, ok := cfg.Defs[key]; !ok {
+		return nil, "", fmt.Errorf("no key %q", key)
+	}
+	return edited, tuple, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddTargetToContext(t *testing.T) {
+	t.Parallel()
+
+	conf := "DEFAULT:\n  INSTALL='a b'\n  FOO=1\n\n# note\nowner/repo: DEFAULT INSTALL=c\n  BAR=2\n\nother: FOO=3\n"
+	cases := []struct {
+		key, varName string
+		want         string
+		wantTuple    string
+		wantErr      string
+	}{
+		{key: "DEFAULT", varName: "INSTALL", wantTuple: "INSTALL='a b new'", want: "DEFAULT:\n  INSTALL='a b new'\n  FOO=1\n"},
+		{key: "owner/repo", varName: "INSTALL", wantTuple: "INSTALL='c new'", want: "owner/repo: DEFAULT INSTALL='c new'\n"},
+		{key: "owner/repo", varName: "postCreate", wantTuple: "postCreate=new", want: "  BAR=2\n  postCreate=new\n\nother:"},
+		{key: "other", varName: "INSTALL", wantTuple: "INSTALL=new", want: "other: FOO=3\n  INSTALL=new\n"},
+		{key: "missing", varName: "INSTALL", wantErr: `no key "missing"`},
+	}
+	for _, tc := range cases {
+		got, tuple, err := addTargetToContext([]byte(conf), tc.key, tc.varName, "new")
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("addTargetToContext(%s): got err %v want %q", tc.key, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("addTargetToContext(%s, %s): %v", tc.key, tc.varName, err)
+		}
+		if tuple != tc.wantTuple || !strings.Contains(string(got), tc.want) {
+			t.Fatalf("addTargetToContext(%s, %s): got tuple %q and\n%s\nwant tuple %q and substring %q", tc.key, tc.varName, tuple, got, tc.wantTuple, tc.want)
+		}
+	}
+	if _, _, err := addTargetToContext([]byte("DEFAULT: INSTALL=new\n"), "DEFAULT", "INSTALL", "new"); err == nil {
+		t.Fatalf("addTargetToContext(already listed): expected error")
+	}
+	if _, _, err := addTargetToContext([]byte("DEFAULT: INSTALL=$\n"), "DEFAULT", "INSTALL", "new"); err == nil {
+		t.Fatalf("addTargetToContext(passthrough): expected error")
+	}
+}
+
+func TestMakefileRecipePrefixAndTargets(t *testing.T) {
+	t.Parallel()
+
+	makefile := []byte(".RECIPEPREFIX := >\nX := a:b\nall hello: dep\n>echo 'jq: here'\nvar-target: FOO = 1\n")
+	prefix := makefileRecipePrefix(makefile)
+	if prefix != ">" {
+		t.Fatalf("makefileRecipePrefix(): got %q want %q", prefix, ">")
+	}
+	if got := makefileRecipePrefix([]byte("all:\n\ttrue\n")); got != "\t" {
+		t.Fatalf("makefileRecipePrefix(default): got %q want tab", got)
+	}
+	for target, want := range map[string]bool{"all": true, "hello": true, "jq": false, "X": false, "var-target": false} {
+		if got := makefileDefinesTarget(makefile, prefix, target); got != want {
+			t.Fatalf("makefileDefinesTarget(%s): got %v want %v", target, got, want)
+		}
+	}
+}
+
+func TestCmdNewTarget(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	makefilePath := filepath.Join(dir, "Makefile")
+	confPath := filepath.Join(dir, "decomk.conf")
+	if err := os.WriteFile(makefilePath, []byte(".RECIPEPREFIX := >\nall:\n>@true"), 0o644); err != nil {
+		t.Fatalf("WriteFile(Makefile): %v", err)
+	}
+	if err := os.WriteFile(confPath, []byte("DEFAULT: INSTALL=all\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(decomk.conf): %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdNewTarget([]string{"jq", "-conf-dir", dir, "-template", "download", "-url", "https://example.com/jq?v=$V", "-context", "DEFAULT"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdNewTarget(): code=%d err=%v", code, err)
+	}
+	makefile, err := os.ReadFile(makefilePath)
+	if err != nil {
+		t.Fatalf("ReadFile(Makefile): %v", err)
+	}
+	for _, want := range []string{
+		">@true\n\n# jq: download",
+		"\njq:\n>tmp=",
+		"'https://example.com/jq?v=$$V'",
+		"'/usr/local/bin/jq'",
+		"\n>touch $@\n",
+	} {
+		if !strings.Contains(string(makefile), want) {
+			t.Fatalf("Makefile missing %q:\n%s", want, makefile)
+		}
+	}
+	conf, err := os.ReadFile(confPath)
+	if err != nil {
+		t.Fatalf("ReadFile(decomk.conf): %v", err)
+	}
+	if got, want := string(conf), "DEFAULT: INSTALL='all jq'\n"; got != want {
+		t.Fatalf("decomk.conf: got %q want %q", got, want)
+	}
+
+	code, err = cmdNewTarget([]string{"-conf-dir", dir, "jq"}, &stdout, &stderr)
+	if err == nil || code != 1 || !strings.Contains(err.Error(), `already defines target "jq"`) {
+		t.Fatalf("cmdNewTarget(existing): code=%d err=%v", code, err)
+	}
+	if code, err := cmdNewTarget([]string{"x", "-conf-dir", dir, "-template", "git-clone"}, &stdout, &stderr); err == nil || code != 2 {
+		t.Fatalf("cmdNewTarget(git-clone without -url): code=%d err=%v", code, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// nonInteractiveEnv turns on -non-interactive when set to a non-empty value.
+const nonInteractiveEnv = "DECOMK_NON_INTERACTIVE"
+
+// promptClasses match output from a recipe that tried to read an answer it
+// could not get without a terminal.
+var promptClasses = []retryClass{
+	{Name: "prompt", Pattern: regexp.MustCompile(`(?i)(/dev/tty: no such device or address|no such device or address.*/dev/tty|could not read (username|password)|a terminal is required|no tty present|inappropriate ioctl for device|EOF when reading a line|host key verification failed)`)},
+}
+
+// withNonInteractiveEnv returns env with DEBIAN_FRONTEND=noninteractive
+// added, unless env already sets DEBIAN_FRONTEND.
+func withNonInteractiveEnv(env []string) []string {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "DEBIAN_FRONTEND=") {
+			return env
+		}
+	}
+	return append(append([]string(nil), env...), "DEBIAN_FRONTEND=noninteractive")
+}
+
+// guardPrompts calls run, passing it stdout and stderr. When nonInteractive
+// is set, run is expected to start make without a terminal (see
+// makeexec.RunMakefilesCommandFilesNoTTY), and a failure after output that
+// shows a recipe tried to prompt is reported as such, naming label and the
+// line.
+//
+// Intent: Make recipes that unexpectedly prompt fail fast with an error that
+// says why, instead of hanging container creation.
+// Source: DI-modof (TODO-jirin)
+func guardPrompts(nonInteractive bool, label string, stdout, stderr io.Writer, run func(stdout, stderr io.Writer) (int, error)) (int, error) {
+	if !nonInteractive {
+		return run(stdout, stderr)
+	}
+	// One matcher per stream: make's two streams may be written concurrently.
+	outMatch := &failureMatcher{classes: promptClasses}
+	errMatch := &failureMatcher{classes: promptClasses}
+	exitCode, err := run(io.MultiWriter(stdout, outMatch), io.MultiWriter(stderr, errMatch))
+	if err == nil {
+		return exitCode, nil
+	}
+	for _, m := range []*failureMatcher{errMatch, outMatch} {
+		m.flush()
+		if m.Class != "" {
+			return exitCode, fmt.Errorf("%s tried to prompt for input (%s), but -non-interactive runs recipes without a terminal; preseed the answer in config or pass it on the command line: %w", label, m.Line, err)
+		}
+	}
+	return exitCode, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/makeexec"
+)
+
+func TestWithNonInteractiveEnv(t *testing.T) {
+	t.Parallel()
+
+	env := withNonInteractiveEnv([]string{"PATH=/bin"})
+	if got, want := strings.Join(env, " "), "PATH=/bin DEBIAN_FRONTEND=noninteractive"; got != want {
+		t.Fatalf("withNonInteractiveEnv(): got %q want %q", got, want)
+	}
+	env = withNonInteractiveEnv([]string{"DEBIAN_FRONTEND=readline"})
+	if got, want := strings.Join(env, " "), "DEBIAN_FRONTEND=readline"; got != want {
+		t.Fatalf("withNonInteractiveEnv(set): got %q want %q", got, want)
+	}
+}
+
+func TestGuardPrompts(t *testing.T) {
+	t.Parallel()
+
+	makeErr := errors.New("exit status 2")
+	run := func(output string, err error) func(stdout, stderr io.Writer) (int, error) {
+		return func(stdout, stderr io.Writer) (int, error) {
+			if _, writeErr := io.WriteString(stderr, output); writeErr != nil {
+				return 1, writeErr
+			}
+			if err != nil {
+				return 2, err
+			}
+			return 0, nil
+		}
+	}
+
+	_, err := guardPrompts(true, "tools", io.Discard, io.Discard, run("/bin/sh: 1: cannot open /dev/tty: No such device or address\n", makeErr))
+	if err == nil || !errors.Is(err, makeErr) || !strings.Contains(err.Error(), "tools tried to prompt for input (/bin/sh: 1: cannot open /dev/tty") {
+		t.Fatalf("guardPrompts(tty): got %v want a prompt error wrapping make's", err)
+	}
+
+	_, err = guardPrompts(true, "tools", io.Discard, io.Discard, run("cc: error: missing.c\n", makeErr))
+	if err != makeErr {
+		t.Fatalf("guardPrompts(compile error): got %v want %v", err, makeErr)
+	}
+
+	_, err = guardPrompts(false, "tools", io.Discard, io.Discard, run("fatal: could not read Username\n", makeErr))
+	if err != makeErr {
+		t.Fatalf("guardPrompts(interactive): got %v want %v", err, makeErr)
+	}
+
+	if code, err := guardPrompts(true, "tools", io.Discard, io.Discard, run("Inappropriate ioctl for device, using defaults\n", nil)); code != 0 || err != nil {
+		t.Fatalf("guardPrompts(success): got %d, %v want 0, nil", code, err)
+	}
+}
+
+func TestGuardPrompts_NoTTYMake(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	makefile := filepath.Join(dir, "Makefile")
+	writeFileFixture(t, makefile, []byte("ask:\n\t@read answer < /dev/tty\n"), 0o644)
+	_, err := guardPrompts(true, "ask", io.Discard, io.Discard, func(stdout, stderr io.Writer) (int, error) {
+		return makeexec.RunMakefilesCommandFilesNoTTY(dir, []string{makefile}, []string{"make"}, nil, nil, []string{"ask"}, withNonInteractiveEnv(nil), nil, stdout, stderr)
+	})
+	if err == nil || !strings.Contains(err.Error(), "ask tried to prompt for input") {
+		t.Fatalf("guardPrompts(make): got %v want a prompt error", err)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// -o values.
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// addOutputFlag defines the -o flag shared by commands with structured output.
+func addOutputFlag(fs *flag.FlagSet, format *string) {
+	fs.StringVar(format, "o", outputText, "output format: text, json, or yaml")
+}
+
+// checkOutputFormat validates a -o value.
+func checkOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON, outputYAML:
+		return nil
+	default:
+		return fmt.Errorf("invalid -o %q (expected text, json, or yaml)", format)
+	}
+}
+
+// writeStructured writes v as indented JSON or as YAML.
+//
+// YAML is rendered from v's JSON encoding, so both formats share field names
+// and order.
+//
+// Intent: Let devcontainer and Kubernetes pipelines consume decomk state in
+// the format they already use, without adding a YAML dependency.
+// Source: DI-nilaf (TODO-jirin)
+func writeStructured(w io.Writer, format string, v any) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if format == outputJSON {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("encode %s output: %w", format, err)
+	}
+	if format == outputJSON {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	decoder := json.NewDecoder(&buf)
+	decoder.UseNumber()
+	node, err := decodeOrderedJSON(decoder)
+	if err != nil {
+		return fmt.Errorf("encode yaml output: %w", err)
+	}
+	var b strings.Builder
+	writeYAMLValue(&b, node, 0)
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// yamlEntry is one key of a decoded JSON object, in input order.
+type yamlEntry struct {
+	Key   string
+	Value any
+}
+
+// decodeOrderedJSON decodes the next JSON value, keeping object keys in order
+// as []yamlEntry. Arrays decode as []any and scalars as string, json.Number,
+// bool, or nil.
+func decodeOrderedJSON(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+	switch delim {
+	case '{':
+		entries := []yamlEntry{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, yamlEntry{Key: fmt.Sprint(key), Value: value})
+		}
+		_, err := decoder.Token()
+		return entries, err
+	case '[':
+		items := []any{}
+		for decoder.More() {
+			item, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err := decoder.Token()
+		return items, err
+	default:
+		return nil, fmt.Errorf("unexpected %q", delim)
+	}
+}
+
+// writeYAMLValue writes node in block style at indent. Top-level scalars and
+// empty collections are written on their own line.
+func writeYAMLValue(b *strings.Builder, node any, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch v := node.(type) {
+	case []yamlEntry:
+		if len(v) == 0 {
+			b.WriteString(pad + "{}\n")
+			return
+		}
+		for _, entry := range v {
+			b.WriteString(pad + yamlScalar(entry.Key) + ":")
+			if isYAMLBlock(entry.Value) {
+				b.WriteString("\n")
+				writeYAMLValue(b, entry.Value, indent+2)
+				continue
+			}
+			b.WriteString(" " + yamlInline(entry.Value) + "\n")
+		}
+	case []any:
+		if len(v) == 0 {
+			b.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range v {
+			if !isYAMLBlock(item) {
+				b.WriteString(pad + "- " + yamlInline(item) + "\n")
+				continue
+			}
+			// A nested block starts on the dash line: render it one level
+			// deeper, then overwrite its first indent with the dash.
+			var nested strings.Builder
+			writeYAMLValue(&nested, item, indent+2)
+			b.WriteString(pad + "- " + nested.String()[indent+2:])
+		}
+	default:
+		b.WriteString(pad + yamlInline(v) + "\n")
+	}
+}
+
+// isYAMLBlock reports whether node is a non-empty object or array.
+func isYAMLBlock(node any) bool {
+	switch v := node.(type) {
+	case []yamlEntry:
+		return len(v) > 0
+	case []any:
+		return len(v) > 0
+	}
+	return false
+}
+
+// yamlInline renders a scalar or empty collection on one line.
+func yamlInline(node any) string {
+	switch v := node.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlScalar(v)
+	case []yamlEntry:
+		return "{}"
+	case []any:
+		return "[]"
+	default:
+		return yamlScalar(fmt.Sprint(v))
+	}
+}
+
+// yamlScalar returns s as a plain YAML scalar when that reads back as the
+// same string, else double-quoted with JSON escapes (valid YAML).
+func yamlScalar(s string) string {
+	if yamlPlainSafe(s) {
+		return s
+	}
+	quoted, err := json.Marshal(s)
+	if err != nil {
+		return `""`
+	}
+	return string(quoted)
+}
+
+// yamlPlainSafe reports whether s can be written unquoted: it starts with a
+// letter, "_" or "/", uses only letters, digits, and "_./=@+-", and is not a
+// word YAML reads as a boolean or null.
+func yamlPlainSafe(s string) bool {
+	if s == "" {
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "null", "yes", "no", "on", "off", "y", "n":
+		return false
+	}
+	for i, r := range s {
+		if i == 0 && !unicode.IsLetter(r) && r != '_' && r != '/' {
+			return false
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_./=@+-", r) {
+			return false
+		}
+	}
+	return true
+}
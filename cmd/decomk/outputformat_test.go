@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteStructured_YAML(t *testing.T) {
+	t.Parallel()
+
+	type item struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	value := struct {
+		Home    string            `json:"home"`
+		Count   int               `json:"count"`
+		Healthy bool              `json:"healthy"`
+		Empty   []string          `json:"empty"`
+		Classes map[string]string `json:"classes"`
+		Items   []item            `json:"items"`
+		Nested  [][]string        `json:"nested"`
+		Odd     []string          `json:"odd"`
+		Missing *item             `json:"missing"`
+	}{
+		Home:    "/var/decomk",
+		Count:   3,
+		Healthy: true,
+		Empty:   []string{},
+		Classes: map[string]string{"B": "envonly", "A": "makeonly"},
+		Items:   []item{{Name: "one", Tags: []string{"x"}}, {Name: "two", Tags: nil}},
+		Nested:  [][]string{{"a", "b"}},
+		Odd:     []string{"", "yes", "1.5", "a b", "FOO=bar", "x: y", "line\nbreak", "-lead"},
+	}
+	var b bytes.Buffer
+	if err := writeStructured(&b, outputYAML, value); err != nil {
+		t.Fatalf("writeStructured(): %v", err)
+	}
+	want := `home: /var/decomk
+count: 3
+healthy: true
+empty: []
+classes:
+  A: makeonly
+  B: envonly
+items:
+  - name: one
+    tags:
+      - x
+  - name: two
+    tags: null
+nested:
+  - - a
+    - b
+odd:
+  - ""
+  - "yes"
+  - "1.5"
+  - "a b"
+  - FOO=bar
+  - "x: y"
+  - "line\nbreak"
+  - "-lead"
+missing: null
+`
+	if got := b.String(); got != want {
+		t.Fatalf("writeStructured(yaml): got\n%s\nwant\n%s", got, want)
+	}
+
+	b.Reset()
+	if err := writeStructured(&b, outputJSON, []int{1}); err != nil {
+		t.Fatalf("writeStructured(json): %v", err)
+	}
+	if got, want := b.String(), "[\n  1\n]\n"; got != want {
+		t.Fatalf("writeStructured(json): got %q want %q", got, want)
+	}
+	if err := checkOutputFormat("xml"); err == nil {
+		t.Fatalf("checkOutputFormat(xml): got nil want error")
+	}
+}
+
+func TestCmdPlan_OutputFormats(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	files := map[string]string{
+		configPath:                         "DEFAULT: INSTALL='a b' makeonly MODE=fast\n",
+		filepath.Join(confDir, "Makefile"): "a b:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath}
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdPlan(append(args, "-o", "yaml", "INSTALL"), &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-o yaml): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	for _, needle := range []string{"contexts:\n  - DEFAULT\n", "tuples:\n  - \"INSTALL=a b\"\n  - MODE=fast\n", "tupleClasses:\n  MODE: makeonly\n", "targets:\n  - a\n  - b\n"} {
+		if !strings.Contains(stdout.String(), needle) {
+			t.Fatalf("cmdPlan(-o yaml) missing %q:\n%s", needle, stdout.String())
+		}
+	}
+	if strings.Contains(stdout.String(), "make -n") || strings.Contains(stdout.String(), "env exports") {
+		t.Fatalf("cmdPlan(-o yaml) printed text plan:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code, err := cmdPlan(append(args, "-o", "json", "INSTALL"), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-o json): code=%d err=%v", code, err)
+	}
+	if !strings.Contains(stdout.String(), "\"targets\": [\n    \"a\",\n    \"b\"\n  ]") {
+		t.Fatalf("cmdPlan(-o json):\n%s", stdout.String())
+	}
+
+	if code, err := cmdPlan(append(args, "-o", "xml", "INSTALL"), &stdout, &stderr); code != 2 || err == nil {
+		t.Fatalf("cmdPlan(-o xml): got code %d err %v want 2 non-nil", code, err)
+	}
+}
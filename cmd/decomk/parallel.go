@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/stevegt/decomk/makeexec"
+	"github.com/stevegt/decomk/state"
+)
+
+// targetRun is one top-level target's make invocation in a parallel run.
+type targetRun struct {
+	Target   string
+	LogPath  string
+	ExitCode int
+	Err      error
+	Elapsed  time.Duration
+	// SkippedFor names the failed (or skipped) target this one depends on,
+	// or the target that was running when decomk was interrupted; a skipped
+	// target never runs.
+	SkippedFor string
+}
+
+// failed reports whether the run failed or was skipped.
+func (r targetRun) failed() bool { return r.Err != nil || r.SkippedFor != "" }
+
+// parallelMake describes the make invocations a parallel run shares; only the
+// goal differs between them.
+type parallelMake struct {
+	Dir       string
+	Makefiles []string
+	Command   []string
+	Flags     []string
+	Tuples    []string
+	Env       []string
+	// LogDir receives one <target>.log per invocation.
+	LogDir string
+	// Jobs bounds how many invocations run at once.
+	Jobs int
+	// LogFormat, when set, renders each target log line (see -log-format).
+	LogFormat *template.Template
+	RunID     string
+	// Events, when set, receives target-started and target-finished events.
+	Events *eventBroker
+
+	// ExtraFiles are passed to every invocation as fds 3, 4, ... (the
+	// -trace-shell log).
+	ExtraFiles []*os.File
+	// AutoStamp touches each successful target's stamp (see -auto-stamp).
+	AutoStamp bool
+	// Retry reruns a target's make invocation after a retryable failure.
+	Retry retryPolicy
+	// Heartbeat reports a target whose make has been silent, on the status
+	// output and in its log.
+	Heartbeat heartbeatPolicy
+	// NonInteractive runs make without a terminal (see -non-interactive).
+	NonInteractive bool
+}
+
+// targetGraph orders the resolved top-level targets for per-target make
+// invocations.
+type targetGraph struct {
+	// Order is a topological order of Deps that keeps argument order among
+	// targets that do not depend on each other.
+	Order []string
+	// Deps maps a target to the other top-level targets it depends on. A
+	// target runs only after they succeed and is skipped if one fails.
+	Deps map[string][]string
+	// After maps a target to earlier targets (in Order) that share a
+	// prerequisite with it. It waits for them to finish, so two makes never
+	// race to build the shared file, but still runs if they fail.
+	After map[string][]string
+	// Phony holds the targets declared .PHONY.
+	Phony map[string]bool
+}
+
+// buildTargetGraph derives the dependency and ordering edges among targets
+// from rules.
+//
+// Intent: Parallelize real-world target sets that have some dependencies
+// between them, and fail fast instead of attempting dependents of a failed
+// target.
+// Source: DI-rovid (TODO-jirin)
+func buildTargetGraph(rules makeRules, targets []string) targetGraph {
+	closures := make(map[string]map[string]bool, len(targets))
+	for _, target := range targets {
+		closures[target] = rules.Closure(target)
+	}
+	graph := targetGraph{Deps: map[string][]string{}, After: map[string][]string{}, Phony: map[string]bool{}}
+	for _, name := range rules.Prereqs[".PHONY"] {
+		graph.Phony[name] = true
+	}
+	for _, target := range targets {
+		for _, other := range targets {
+			if other != target && closures[target][other] {
+				graph.Deps[target] = append(graph.Deps[target], other)
+			}
+		}
+	}
+
+	// Kahn's algorithm, always taking the earliest ready target in argument
+	// order. make drops circular dependencies, so a cycle here is broken the
+	// same way: by ignoring the remaining edges of the earliest stuck target.
+	placed := map[string]bool{}
+	for len(graph.Order) < len(targets) {
+		next := ""
+		for _, target := range targets {
+			if placed[target] {
+				continue
+			}
+			ready := true
+			for _, dep := range graph.Deps[target] {
+				if !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = target
+				break
+			}
+		}
+		if next == "" {
+			for _, target := range targets {
+				if !placed[target] {
+					next = target
+					break
+				}
+			}
+			var kept []string
+			for _, dep := range graph.Deps[next] {
+				if placed[dep] {
+					kept = append(kept, dep)
+				}
+			}
+			graph.Deps[next] = kept
+		}
+		placed[next] = true
+		graph.Order = append(graph.Order, next)
+	}
+
+	for i, target := range graph.Order {
+		for _, earlier := range graph.Order[:i] {
+			if closures[target][earlier] || closures[earlier][target] {
+				continue
+			}
+			for name := range closures[target] {
+				if closures[earlier][name] {
+					graph.After[target] = append(graph.After[target], earlier)
+					break
+				}
+			}
+		}
+	}
+	return graph
+}
+
+// runTargetsParallel runs one make invocation per target in graph, at most
+// p.Jobs at a time, and reports progress lines on status. Each invocation's
+// output goes only to its own log, so concurrent recipes do not interleave on
+// the terminal.
+//
+// The returned exit code and error describe the first failed target in
+// graph order; the error also names skipped targets.
+//
+// Intent: Let embarrassingly parallel bootstrap blocks run concurrently even
+// when their recipes were not written for make -j.
+// Source: DI-kagir (TODO-jirin)
+func runTargetsParallel(p parallelMake, graph targetGraph, status statusOutput) ([]targetRun, int, error) {
+	if err := state.EnsureDir(p.LogDir); err != nil {
+		return nil, 1, err
+	}
+	// statusMu serializes status lines from this goroutine with heartbeat
+	// notices from the running targets.
+	var statusMu sync.Mutex
+	var reportErr error
+	report := func(color func(palette, string) string, format string, values ...any) {
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		if err := status.line(color, fmt.Sprintf(format, values...)); err != nil && reportErr == nil {
+			reportErr = err
+		}
+	}
+	notice := func(text string) error {
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		return status.line(palette.yellow, text)
+	}
+
+	runs := make([]targetRun, len(graph.Order))
+	index := make(map[string]int, len(graph.Order))
+	for i, target := range graph.Order {
+		index[target] = i
+		runs[i] = targetRun{Target: target, LogPath: filepath.Join(p.LogDir, state.SafeComponent(target)+".log")}
+	}
+	started := make([]bool, len(runs))
+	finished := make([]bool, len(runs))
+	done := make(chan int)
+	running, remaining := 0, len(runs)
+	// interrupted names the first target whose make was stopped by a
+	// forwarded signal; no further targets start after it.
+	interrupted := ""
+	for remaining > 0 {
+		for progress := true; progress; {
+			progress = false
+			for i := range runs {
+				if started[i] {
+					continue
+				}
+				ready := true
+				if interrupted != "" {
+					runs[i].SkippedFor = interrupted
+				}
+				for _, dep := range graph.Deps[runs[i].Target] {
+					if runs[i].SkippedFor != "" {
+						break
+					}
+					j := index[dep]
+					if finished[j] && runs[j].failed() {
+						runs[i].SkippedFor = dep
+						break
+					}
+					ready = ready && finished[j]
+				}
+				if runs[i].SkippedFor != "" {
+					started[i], finished[i] = true, true
+					remaining--
+					progress = true
+					if interrupted != "" {
+						report(palette.yellow, "decomk: [%s] skipped: run interrupted", runs[i].Target)
+					} else {
+						report(palette.yellow, "decomk: [%s] skipped: prerequisite target %s failed", runs[i].Target, runs[i].SkippedFor)
+					}
+					p.Events.publish(runEvent{Event: eventTargetFinished, Target: runs[i].Target, Status: timingStatusSkipped})
+					continue
+				}
+				for _, earlier := range graph.After[runs[i].Target] {
+					ready = ready && finished[index[earlier]]
+				}
+				if !ready || running >= max(p.Jobs, 1) {
+					continue
+				}
+				started[i] = true
+				running++
+				report(nil, "decomk: [%s] started; log: %s", runs[i].Target, runs[i].LogPath)
+				p.Events.publish(runEvent{Event: eventTargetStarted, Target: runs[i].Target})
+				go func(i int) {
+					start := time.Now()
+					runs[i].ExitCode, runs[i].Err = runTargetLogged(p, runs[i].Target, runs[i].LogPath, notice)
+					if runs[i].Err == nil && p.AutoStamp && !graph.Phony[runs[i].Target] {
+						if err := autoStamp(p.Dir, runs[i].Target, time.Now()); err != nil {
+							runs[i].ExitCode, runs[i].Err = 1, err
+						}
+					}
+					runs[i].Elapsed = time.Since(start)
+					done <- i
+				}(i)
+			}
+		}
+		if remaining == 0 {
+			break
+		}
+		i := <-done
+		finished[i] = true
+		running--
+		remaining--
+		var interrupt *makeexec.InterruptedError
+		if interrupted == "" && errors.As(runs[i].Err, &interrupt) {
+			interrupted = runs[i].Target
+		}
+		outcome := timingStatusOK
+		if runs[i].Err != nil {
+			outcome = timingStatusFailed
+		}
+		p.Events.publish(runEvent{Event: eventTargetFinished, Target: runs[i].Target, Status: outcome, ExitCode: &runs[i].ExitCode, ElapsedSeconds: runs[i].Elapsed.Seconds()})
+		if runs[i].Err != nil {
+			report(palette.red, "decomk: [%s] failed (exit %d) after %s", runs[i].Target, runs[i].ExitCode, runs[i].Elapsed.Round(time.Millisecond))
+		} else {
+			report(palette.green, "decomk: [%s] done in %s", runs[i].Target, runs[i].Elapsed.Round(time.Millisecond))
+		}
+	}
+
+	var failed, skipped []string
+	exitCode := 0
+	var firstErr error
+	for _, run := range runs {
+		switch {
+		case run.SkippedFor != "":
+			skipped = append(skipped, run.Target)
+		case run.Err != nil:
+			failed = append(failed, run.Target+" (log: "+run.LogPath+")")
+			if firstErr == nil {
+				exitCode, firstErr = run.ExitCode, run.Err
+			}
+		}
+	}
+	if firstErr != nil {
+		msg := "targets failed: " + strings.Join(failed, ", ")
+		if len(skipped) > 0 {
+			msg += "; skipped: " + strings.Join(skipped, ", ")
+		}
+		return runs, exitCode, fmt.Errorf("%s: %w", msg, firstErr)
+	}
+	if reportErr != nil {
+		return runs, 1, reportErr
+	}
+	return runs, 0, nil
+}
+
+// autoStamp creates or touches target's stamp in stampDir after its make
+// invocation succeeded. Targets that are not paths inside stampDir (absolute
+// file targets) are left to their recipes.
+//
+// Intent: Let simple recipes skip the trailing touch $@, so a forgotten stamp
+// cannot make a target rerun on every run.
+// Source: DI-ruroh (TODO-jirin)
+func autoStamp(stampDir, target string, now time.Time) error {
+	if !filepath.IsLocal(target) {
+		return nil
+	}
+	return touchStamp(filepath.Join(stampDir, target), now)
+}
+
+// runTargetLogged runs make for one target with all output in logPath.
+// Heartbeat notices go to the log and to notice.
+func runTargetLogged(p parallelMake, target, logPath string, notice func(string) error) (int, error) {
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return 1, err
+	}
+	logOut := newLogLineWriter(logFile, p.LogFormat, logLine{RunID: p.RunID, Target: target, Stream: "stdout"})
+	logErr := newLogLineWriter(logFile, p.LogFormat, logLine{RunID: p.RunID, Target: target, Stream: "stderr"})
+	notify := func(text string) error {
+		if err := writeLine(logErr, text); err != nil {
+			return err
+		}
+		return notice(text)
+	}
+	exitCode, runErr := runWithRetry(p.Retry, logErr, "make "+target, func(output io.Writer) (int, error) {
+		return runWatched(p.Heartbeat, target, notify, io.MultiWriter(logOut, output), io.MultiWriter(logErr, output), func(stdout, stderr io.Writer) (int, error) {
+			return guardPrompts(p.NonInteractive, target, stdout, stderr, func(stdout, stderr io.Writer) (int, error) {
+				if p.NonInteractive {
+					return makeexec.RunMakefilesCommandFilesNoTTY(p.Dir, p.Makefiles, p.Command, p.Flags, p.Tuples, []string{target}, p.Env, p.ExtraFiles, stdout, stderr)
+				}
+				return makeexec.RunMakefilesCommandFiles(p.Dir, p.Makefiles, p.Command, p.Flags, p.Tuples, []string{target}, p.Env, p.ExtraFiles, stdout, stderr)
+			})
+		})
+	})
+	if flushErr := flushLogWriters(logOut, logErr); flushErr != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("flush target log %s: %w", logPath, flushErr))
+		if exitCode == 0 {
+			exitCode = 1
+		}
+	}
+	if closeErr := logFile.Close(); closeErr != nil {
+		wrapped := fmt.Errorf("close target log %s: %w", logPath, closeErr)
+		if runErr == nil {
+			return 1, wrapped
+		}
+		return exitCode, errors.Join(runErr, wrapped)
+	}
+	return exitCode, runErr
+}
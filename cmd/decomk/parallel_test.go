@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildTargetGraph(t *testing.T) {
+	t.Parallel()
+
+	rules := makeRules{Prereqs: map[string][]string{
+		"app":   {"base", "tools"},
+		"base":  {"x"},
+		"tools": nil,
+		"docs":  {"x"},
+		"lint":  nil,
+		"x":     nil,
+	}}
+	graph := buildTargetGraph(rules, []string{"app", "docs", "base", "lint"})
+	if got, want := graph.Order, []string{"docs", "base", "app", "lint"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("buildTargetGraph() order: got %q want %q", got, want)
+	}
+	if got, want := graph.Deps, map[string][]string{"app": {"base"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("buildTargetGraph() deps: got %q want %q", got, want)
+	}
+	// base and app share x with docs (which comes first); app already waits
+	// for base through Deps.
+	if got, want := graph.After, map[string][]string{"base": {"docs"}, "app": {"docs"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("buildTargetGraph() after: got %q want %q", got, want)
+	}
+
+	cyclic := makeRules{Prereqs: map[string][]string{"a": {"b"}, "b": {"a"}}}
+	if got, want := buildTargetGraph(cyclic, []string{"a", "b"}).Order, []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("buildTargetGraph(cycle) order: got %q want %q", got, want)
+	}
+}
+
+func TestRunTargetsParallel(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	// a and b each wait for the other to start, so they only succeed when run
+	// concurrently.
+	stampDir := t.TempDir()
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	makefile := strings.Join([]string{
+		"a b:",
+		"\ttouch $@.started; for i in $$(seq 100); do [ -f $(if $(filter a,$@),b,a).started ] && break; sleep 0.05; done; [ -f $(if $(filter a,$@),b,a).started ]",
+		"\ttouch $@",
+		"fail:",
+		"\techo failing; exit 3",
+		"after-fail: fail",
+		"\ttouch $@",
+		"independent:",
+		"\ttouch $@",
+		"",
+	}, "\n")
+	if err := os.WriteFile(makefilePath, []byte(makefile), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	p := parallelMake{
+		Dir:       stampDir,
+		Makefiles: []string{makefilePath},
+		Command:   []string{"make"},
+		Env:       os.Environ(),
+		LogDir:    filepath.Join(t.TempDir(), "targets"),
+		Jobs:      2,
+	}
+
+	var out bytes.Buffer
+	graph := buildTargetGraph(makeRules{Prereqs: map[string][]string{"a": nil, "b": nil}}, []string{"a", "b"})
+	runs, code, err := runTargetsParallel(p, graph, statusOutput{term: &out})
+	if err != nil || code != 0 {
+		t.Fatalf("runTargetsParallel(a b): got code %d err %v want 0 nil\n%s", code, err, out.String())
+	}
+	for _, run := range runs {
+		if _, err := os.Stat(filepath.Join(stampDir, run.Target)); err != nil {
+			t.Fatalf("Stat(stamp %s): %v", run.Target, err)
+		}
+		if !strings.Contains(out.String(), "decomk: ["+run.Target+"] done in ") {
+			t.Fatalf("progress missing done line for %s:\n%s", run.Target, out.String())
+		}
+	}
+
+	// after-fail depends on fail and is skipped; independent still runs. The
+	// terminal copy of the progress lines is colored and the log copy plain.
+	p.LogDir = filepath.Join(t.TempDir(), "targets")
+	out.Reset()
+	var logOut bytes.Buffer
+	graph = buildTargetGraph(makeRules{Prereqs: map[string][]string{"after-fail": {"fail"}, "fail": nil, "independent": nil}}, []string{"after-fail", "fail", "independent"})
+	runs, code, err = runTargetsParallel(p, graph, statusOutput{term: &out, log: &logOut, colors: palette{enabled: true}})
+	if err == nil || code != 2 {
+		t.Fatalf("runTargetsParallel(fail): got code %d err %v want 2 non-nil", code, err)
+	}
+	byTarget := map[string]targetRun{}
+	for _, run := range runs {
+		byTarget[run.Target] = run
+	}
+	failLog := byTarget["fail"].LogPath
+	if got, want := err.Error(), "targets failed: fail (log: "+failLog+"); skipped: after-fail: "; !strings.HasPrefix(got, want) {
+		t.Fatalf("runTargetsParallel(fail) error: got %q want prefix %q", got, want)
+	}
+	if !strings.Contains(out.String(), ansiRed+"decomk: [fail] failed (exit 2)") {
+		t.Fatalf("terminal progress missing red failed line:\n%q", out.String())
+	}
+	if got := logOut.String(); strings.Contains(got, "\x1b") || !strings.Contains(got, "decomk: [fail] failed (exit 2)") {
+		t.Fatalf("log progress: got %q want plain failed line", got)
+	}
+	if got := byTarget["after-fail"].SkippedFor; got != "fail" {
+		t.Fatalf("after-fail SkippedFor: got %q want %q", got, "fail")
+	}
+	if _, err := os.Stat(byTarget["after-fail"].LogPath); !os.IsNotExist(err) {
+		t.Fatalf("skipped target ran: log %s exists (err=%v)", byTarget["after-fail"].LogPath, err)
+	}
+	if _, err := os.Stat(filepath.Join(stampDir, "independent")); err != nil {
+		t.Fatalf("independent target did not run: %v", err)
+	}
+	content, err := os.ReadFile(failLog)
+	if err != nil {
+		t.Fatalf("ReadFile(target log): %v", err)
+	}
+	if !strings.Contains(string(content), "failing") {
+		t.Fatalf("target log: got %q want recipe output", content)
+	}
+	if !strings.Contains(out.String(), "decomk: [after-fail] skipped: prerequisite target fail failed") {
+		t.Fatalf("progress missing skip line:\n%s", out.String())
+	}
+}
+
+func TestRunTargetsParallel_AutoStamp(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	stampDir := t.TempDir()
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	makefile := strings.Join([]string{
+		".PHONY: always",
+		"plain:",
+		"\techo plain",
+		"always:",
+		"\techo always",
+		"broken:",
+		"\texit 1",
+		"",
+	}, "\n")
+	if err := os.WriteFile(makefilePath, []byte(makefile), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	p := parallelMake{
+		Dir:       stampDir,
+		Makefiles: []string{makefilePath},
+		Command:   []string{"make"},
+		Env:       os.Environ(),
+		LogDir:    filepath.Join(t.TempDir(), "targets"),
+		Jobs:      1,
+	}
+	p.AutoStamp = true
+
+	graph := buildTargetGraph(makeRules{Prereqs: map[string][]string{".PHONY": {"always"}}}, []string{"plain", "always", "broken"})
+	var out bytes.Buffer
+	if _, code, err := runTargetsParallel(p, graph, statusOutput{term: &out}); err == nil || code != 2 {
+		t.Fatalf("runTargetsParallel(): got code %d err %v want 2 non-nil", code, err)
+	}
+	for target, want := range map[string]bool{"plain": true, "always": false, "broken": false} {
+		if got := fileExists(filepath.Join(stampDir, target)); got != want {
+			t.Fatalf("stamp %s exists: got %v want %v", target, got, want)
+		}
+	}
+}
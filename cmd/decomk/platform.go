@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// osReleasePaths are where the distro identification lives, in lookup order.
+var osReleasePaths = []string{"/etc/os-release", "/usr/lib/os-release"}
+
+// platformVars returns the DECOMK_* platform variables computedVars exports:
+// the Go names of the OS and architecture (linux, amd64, arm64), the distro ID
+// and VERSION_ID from os-release (empty when there is none), and the number of
+// CPUs decomk may use.
+//
+// Intent: Give recipes and conditional config one consistent source of
+// platform facts instead of fragile uname and os-release shell snippets.
+// Source: DI-marar (TODO-jirin)
+func platformVars() map[string]string {
+	var release map[string]string
+	for _, path := range osReleasePaths {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			release = parseOSRelease(string(content))
+			break
+		}
+	}
+	return map[string]string{
+		"DECOMK_OS":             runtime.GOOS,
+		"DECOMK_ARCH":           runtime.GOARCH,
+		"DECOMK_DISTRO":         release["ID"],
+		"DECOMK_DISTRO_VERSION": release["VERSION_ID"],
+		"DECOMK_NPROC":          strconv.Itoa(runtime.NumCPU()),
+	}
+}
+
+// parseOSRelease reads os-release(5) content: KEY=value lines whose values
+// may be single- or double-quoted.
+func parseOSRelease(content string) map[string]string {
+	out := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		out[key] = value
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	t.Parallel()
+
+	got := parseOSRelease("# comment\nNAME=\"Ubuntu\"\nID=ubuntu\nVERSION_ID=\"24.04\"\nPRETTY_NAME='Ubuntu 24.04 LTS'\nbogus\n")
+	want := map[string]string{"NAME": "Ubuntu", "ID": "ubuntu", "VERSION_ID": "24.04", "PRETTY_NAME": "Ubuntu 24.04 LTS"}
+	if len(got) != len(want) {
+		t.Fatalf("parseOSRelease(): got %q want %q", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("parseOSRelease()[%s]: got %q want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestComputedVarsIncludePlatform(t *testing.T) {
+	t.Parallel()
+
+	vars := computedVars(&resolvedPlan{}, nil)
+	if vars["DECOMK_OS"] != runtime.GOOS || vars["DECOMK_ARCH"] != runtime.GOARCH {
+		t.Fatalf("computedVars(): got OS %q ARCH %q want %q %q", vars["DECOMK_OS"], vars["DECOMK_ARCH"], runtime.GOOS, runtime.GOARCH)
+	}
+	if n, err := strconv.Atoi(vars["DECOMK_NPROC"]); err != nil || n < 1 {
+		t.Fatalf("computedVars()[DECOMK_NPROC]: got %q want a positive integer", vars["DECOMK_NPROC"])
+	}
+	tuples := strings.Join(canonicalEnvTuples(&resolvedPlan{}, nil, nil), "\n") + "\n"
+	for name := range platformVars() {
+		if !strings.Contains(tuples, name+"="+vars[name]+"\n") {
+			t.Fatalf("canonicalEnvTuples(): missing %s=%s in %q", name, vars[name], tuples)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/resolve"
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	// pluginPrefix starts the file name of every exec plugin.
+	pluginPrefix = "decomk-"
+	// pluginInfoArg asks a plugin which hooks it provides.
+	pluginInfoArg = "decomk-plugin-info"
+	// pluginProtocolEnv tells a plugin which protocol version invoked it.
+	pluginProtocolEnv = "DECOMK_PLUGIN_PROTOCOL"
+	pluginProtocol    = "1"
+)
+
+// Hooks an exec plugin may provide, named in its provides= line. A plugin is
+// invoked with "decomk-<hook>" as its only argument to run one.
+const (
+	pluginHookVars    = "vars"
+	pluginHookPreRun  = "pre-run"
+	pluginHookPostRun = "post-run"
+)
+
+// execPlugin is one decomk-<name> executable.
+type execPlugin struct {
+	Name string
+	Path string
+	// Provides are the hooks the plugin declared, in pluginHook order; empty
+	// for a subcommand-only plugin.
+	Provides []string
+	Summary  string
+}
+
+// provides reports whether p declared hook.
+func (p execPlugin) provides(hook string) bool {
+	for _, h := range p.Provides {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+// findPluginPath returns the executable decomk-<name> in pluginsDir, or else
+// the first one in path (a PATH value).
+func findPluginPath(pluginsDir, path, name string) (string, bool) {
+	dirs := append([]string{pluginsDir}, filepath.SplitList(path)...)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		candidate := filepath.Join(dir, pluginPrefix+name)
+		if isExecutableFile(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// isExecutableFile reports whether path is a regular file with an execute
+// bit set.
+func isExecutableFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0
+}
+
+// discoverPlugins returns the decomk-<name> executables in pluginsDir and on
+// path, sorted by name, each with the hooks it declares. pluginsDir shadows
+// path, and an earlier path entry shadows a later one.
+//
+// Intent: Let sites add commands, variables, and run hooks without forking
+// the tool repo, with every registration visible in plan output.
+// Source: DI-nobug (TODO-jirin)
+func discoverPlugins(pluginsDir, path string) ([]execPlugin, error) {
+	seen := make(map[string]bool)
+	var plugins []execPlugin
+	for i, dir := range append([]string{pluginsDir}, filepath.SplitList(path)...) {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			// Stale PATH entries are common; only the plugins dir must be
+			// readable when it exists.
+			if i == 0 && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("plugins: %w", err)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), pluginPrefix)
+			if !ok || name == "" || seen[name] {
+				continue
+			}
+			candidate := filepath.Join(dir, entry.Name())
+			if !isExecutableFile(candidate) {
+				continue
+			}
+			seen[name] = true
+			plugin := execPlugin{Name: name, Path: candidate}
+			plugin.Provides, plugin.Summary = queryPluginInfo(candidate)
+			plugins = append(plugins, plugin)
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins, nil
+}
+
+// queryPluginInfo runs path with pluginInfoArg and reads its provides= and
+// summary= lines. A plugin that fails or prints neither is subcommand-only.
+func queryPluginInfo(path string) (provides []string, summary string) {
+	cmd := exec.Command(path, pluginInfoArg)
+	cmd.Env = withEnv(os.Environ(), map[string]string{pluginProtocolEnv: pluginProtocol})
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, ""
+	}
+	declared := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, ok := resolve.SplitTuple(strings.TrimSpace(line))
+		if !ok {
+			continue
+		}
+		switch key {
+		case "provides":
+			for _, hook := range strings.Fields(value) {
+				declared[hook] = true
+			}
+		case "summary":
+			summary = value
+		}
+	}
+	for _, hook := range []string{pluginHookVars, pluginHookPreRun, pluginHookPostRun} {
+		if declared[hook] {
+			provides = append(provides, hook)
+		}
+	}
+	return provides, summary
+}
+
+// pluginHookArgv returns the argv that runs hook in each plugin providing it.
+func pluginHookArgv(plugins []execPlugin, hook string) [][]string {
+	var argvs [][]string
+	for _, plugin := range plugins {
+		if plugin.provides(hook) {
+			argvs = append(argvs, []string{plugin.Path, "decomk-" + hook})
+		}
+	}
+	return argvs
+}
+
+// runPluginCommand runs decomk-<name> as `decomk <name> args...`, with
+// decomk's stdin, stdout, and stderr, and returns its exit code. ok is false
+// when no such plugin exists.
+func runPluginCommand(home, name string, args []string, stdout, stderr io.Writer) (exitCode int, ok bool, err error) {
+	path, ok := findPluginPath(state.PluginsDir(home), os.Getenv("PATH"), name)
+	if !ok {
+		return 0, false, nil
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = withEnv(os.Environ(), map[string]string{
+		pluginProtocolEnv: pluginProtocol,
+		"DECOMK_HOME":     home,
+		"DECOMK_BIN":      decomkBinPath(home),
+	})
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true, nil
+	}
+	if err != nil {
+		return 1, true, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	return 0, true, nil
+}
+
+// runPluginHooks runs hook in every plugin providing it, in name order, with
+// env and output to stdout and stderr, and stops at the first failure.
+func runPluginHooks(plugins []execPlugin, hook string, env []string, stdout, stderr io.Writer) error {
+	for _, argv := range pluginHookArgv(plugins, hook) {
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		cmd.Env = withEnv(env, map[string]string{pluginProtocolEnv: pluginProtocol})
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %s: %w", hook, argv[0], err)
+		}
+	}
+	return nil
+}
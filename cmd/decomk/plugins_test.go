@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// writePlugin writes an executable shell script named decomk-<name> to dir.
+func writePlugin(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("MkdirAll(): %v", err)
+	}
+	path := filepath.Join(dir, pluginPrefix+name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	return path
+}
+
+// hookPluginScript answers decomk-plugin-info with provides and otherwise
+// runs body.
+func hookPluginScript(provides, body string) string {
+	return "if [ \"$1\" = decomk-plugin-info ]; then echo provides=" + provides + "; echo summary=test plugin; exit 0; fi\n" + body
+}
+
+func TestDiscoverPlugins(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	pathDir := t.TempDir()
+	homeFoo := writePlugin(t, state.PluginsDir(home), "foo", hookPluginScript("\"post-run vars bogus\"", "exit 0\n"))
+	writePlugin(t, pathDir, "foo", hookPluginScript("pre-run", "exit 0\n"))
+	pathBar := writePlugin(t, pathDir, "bar", "exit 1\n")
+	if err := os.WriteFile(filepath.Join(pathDir, pluginPrefix+"baz"), []byte("not executable\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	plugins, err := discoverPlugins(state.PluginsDir(home), filepath.Join(home, "missing")+string(filepath.ListSeparator)+pathDir)
+	if err != nil {
+		t.Fatalf("discoverPlugins(): %v", err)
+	}
+	if len(plugins) != 2 {
+		t.Fatalf("discoverPlugins(): got %+v want bar and foo", plugins)
+	}
+	if bar := plugins[0]; bar.Name != "bar" || bar.Path != pathBar || len(bar.Provides) != 0 {
+		t.Fatalf("discoverPlugins()[0]: got %+v want subcommand-only bar at %s", bar, pathBar)
+	}
+	if foo := plugins[1]; foo.Path != homeFoo || strings.Join(foo.Provides, " ") != "vars post-run" || foo.Summary != "test plugin" {
+		t.Fatalf("discoverPlugins()[1]: got %+v want the plugins-dir foo providing vars post-run", foo)
+	}
+}
+
+func TestRunPluginCommand(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	writePlugin(t, state.PluginsDir(home), "hello", "echo \"hello $1 from $DECOMK_HOME protocol $DECOMK_PLUGIN_PROTOCOL\"\nexit 7\n")
+
+	var stdout, stderr bytes.Buffer
+	code, ok, err := runPluginCommand(home, "hello", []string{"world"}, &stdout, &stderr)
+	if err != nil || !ok || code != 7 {
+		t.Fatalf("runPluginCommand(): got %d, %v, %v want 7, true, nil", code, ok, err)
+	}
+	if want := "hello world from " + home + " protocol 1\n"; stdout.String() != want {
+		t.Fatalf("runPluginCommand() stdout: got %q want %q", stdout.String(), want)
+	}
+	if _, ok, err := runPluginCommand(home, "missing", nil, &stdout, &stderr); ok || err != nil {
+		t.Fatalf("runPluginCommand(missing): got %v, %v want false, nil", ok, err)
+	}
+}
+
+func TestPluginHooks(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	dir := state.PluginsDir(home)
+	writePlugin(t, dir, "a", hookPluginScript("\"vars pre-run\"", "case \"$1\" in\ndecomk-vars) echo CLOUD=aws ;;\ndecomk-pre-run) echo \"pre $DECOMK_TARGETS\" ;;\nesac\n"))
+	writePlugin(t, dir, "b", hookPluginScript("pre-run", "echo nope >&2\nexit 1\n"))
+	plugins, err := discoverPlugins(dir, "")
+	if err != nil {
+		t.Fatalf("discoverPlugins(): %v", err)
+	}
+
+	vars, _, err := runVarPlugins(home, "", false, plugins)
+	if err != nil || len(vars) != 1 || vars[0].Name != "CLOUD" || vars[0].Value != "aws" {
+		t.Fatalf("runVarPlugins(): got %+v, %v want CLOUD=aws", vars, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = runPluginHooks(plugins, pluginHookPreRun, []string{"DECOMK_TARGETS=tools"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), pluginPrefix+"b") {
+		t.Fatalf("runPluginHooks(): got %v want the failure of plugin b", err)
+	}
+	if stdout.String() != "pre tools\n" || stderr.String() != "nope\n" {
+		t.Fatalf("runPluginHooks() output: got %q, %q want %q, %q", stdout.String(), stderr.String(), "pre tools\n", "nope\n")
+	}
+	if err := runPluginHooks(plugins, pluginHookPostRun, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("runPluginHooks(post-run): got %v want nil (no plugin provides it)", err)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/recipetmpl"
+	"github.com/stevegt/decomk/state"
+	"github.com/stevegt/decomk/toolchain"
+)
+
+const (
+	profileSubcommandSave   = "save"
+	profileSubcommandList   = "list"
+	profileSubcommandShow   = "show"
+	profileSubcommandTiming = "timing"
+)
+
+// savedProfile is the on-disk snapshot of a resolved plan.
+//
+// It records everything plan/run need to rebuild the make invocation without
+// rescanning workspaces or reloading decomk.conf. Runtime inputs (incoming
+// environment passthroughs, PATH, computed vars) are still applied at replay
+// time, exactly as for a freshly resolved plan.
+//
+// Intent: Let operators reproduce "it worked yesterday" runs by replaying the
+// exact contexts, tuples, and targets that were resolved at save time, even
+// after workspaces or the conf repo change.
+// Source: DI-gohig (TODO-jirin)
+type savedProfile struct {
+	Name          string            `json:"name"`
+	SavedAt       string            `json:"savedAt"`
+	DecomkVersion string            `json:"decomkVersion"`
+	Workspaces    []string          `json:"workspaces,omitempty"`
+	ContextKeys   []string          `json:"contextKeys"`
+	ConfigPaths   []string          `json:"configPaths"`
+	ConfDir       string            `json:"confDir"`
+	Makefiles     []string          `json:"makefiles"`
+	Toolchains    []toolchain.Spec  `json:"toolchains,omitempty"`
+	Templates     []recipetmpl.Call `json:"templates,omitempty"`
+	Recipes       contexts.Recipes  `json:"recipes,omitempty"`
+	Tags          contexts.Tags     `json:"tags,omitempty"`
+	Expanded      []string          `json:"expanded"`
+	Tuples        []string          `json:"tuples"`
+	TupleClasses  map[string]string `json:"tupleClasses,omitempty"`
+	ActionArgs    []string          `json:"actionArgs,omitempty"`
+	Targets       []string          `json:"targets,omitempty"`
+
+	// Namespaces are the namespaced DECOMK_MAKEFILES entries.
+	Namespaces []makefileNamespace `json:"namespaces,omitempty"`
+	// TargetDirs are the resolved DECOMK_TARGET_DIRS entries.
+	TargetDirs []targetDir `json:"targetDirs,omitempty"`
+	// TemplatesMakefile is the rendered template fragment, saved so replay
+	// does not need the conf repo's templates directory.
+	TemplatesMakefile string `json:"templatesMakefile,omitempty"`
+	// Allow and LowerTuples keep the root config's DECOMK_ALLOW check on the
+	// targets replay selects.
+	Allow       []string            `json:"allow,omitempty"`
+	LowerTuples map[string][]string `json:"lowerTuples,omitempty"`
+}
+
+// cmdProfile dispatches `decomk profile` subcommands.
+func cmdProfile(args []string, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		return 2, fmt.Errorf("profile subcommand required\n\n%s", profileUsage())
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		if err := writeLine(stdout, profileUsage()); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	case profileSubcommandSave:
+		return cmdProfileSave(args[1:], stdout, stderr)
+	case profileSubcommandList:
+		return cmdProfileList(args[1:], stdout, stderr)
+	case profileSubcommandShow:
+		return cmdProfileShow(args[1:], stdout, stderr)
+	case profileSubcommandTiming:
+		return cmdProfileTiming(args[1:], stdout, stderr)
+	default:
+		return 2, fmt.Errorf("unknown profile subcommand: %s\n\n%s", args[0], profileUsage())
+	}
+}
+
+func profileUsage() string {
+	return `decomk profile - save and inspect resolved plan snapshots and run timings
+
+Usage:
+  decomk profile save [flags] NAME [ARGS...]
+  decomk profile list [-home <dir>]
+  decomk profile show [-home <dir>] NAME
+  decomk profile timing [-home <dir>] [-top N]
+
+Subcommands:
+  save
+      Resolve contexts/tuples like plan (same flags) and save them as
+      <DECOMK_HOME>/profiles/NAME.json. Optional ARGS are stored as the
+      profile's default action args.
+  list
+      List saved profile names.
+  show
+      Print one saved profile as JSON.
+  timing
+      Report the last run's slowest targets with the change since earlier
+      runs, and each target's share of the run. Runs are timed per target
+      with decomk run -parallel N (or when a run builds one target).
+
+Replay a profile with:
+  decomk plan -profile NAME [ARGS...]
+  decomk run -profile NAME [ARGS...]
+ARGS default to the action args stored in the profile.
+`
+}
+
+// cmdProfileSave resolves a plan and writes it as a named profile.
+func cmdProfileSave(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk profile save", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+	addCommonFlags(fs, &f)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() < 1 {
+		return 2, fmt.Errorf("profile save requires a NAME\n\n%s", profileUsage())
+	}
+	if f.profile != "" {
+		return 2, fmt.Errorf("profile save does not accept -profile; copy the profile file instead")
+	}
+	name := fs.Arg(0)
+	if err := validateProfileName(name); err != nil {
+		return 2, err
+	}
+	actionArgs := fs.Args()[1:]
+
+	if err := applyStartDir(f.startDir); err != nil {
+		return 1, err
+	}
+	plan, err := resolvePlanFromFlags(f)
+	if err != nil {
+		return 1, err
+	}
+
+	profile := savedProfile{
+		Name:              name,
+		SavedAt:           time.Now().UTC().Format(time.RFC3339),
+		DecomkVersion:     decomkVersion,
+		ContextKeys:       plan.ContextKeys,
+		ConfigPaths:       plan.ConfigPaths,
+		ConfDir:           plan.ConfDir,
+		Makefiles:         plan.Makefiles,
+		Namespaces:        plan.Namespaces,
+		TargetDirs:        plan.TargetDirs,
+		Toolchains:        plan.Toolchains,
+		Templates:         plan.Templates,
+		TemplatesMakefile: string(plan.TemplatesMakefile),
+		Recipes:           plan.Recipes,
+		Tags:              plan.Tags,
+		Expanded:          plan.Expanded,
+		Tuples:            encryptedSecretTuples(plan.Tuples, plan.Secrets),
+		TupleClasses:      plan.TupleClasses,
+		ActionArgs:        actionArgs,
+		Allow:             plan.Allow,
+		LowerTuples:       plan.LowerTuples,
+	}
+	for _, repo := range plan.WorkspaceRepos {
+		profile.Workspaces = append(profile.Workspaces, repo.Root)
+	}
+	if len(actionArgs) > 0 {
+		profile.Targets = targetsFromActionArgs(actionArgs, effectiveTupleValues(plan.Tuples))
+	}
+
+	content, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return 1, fmt.Errorf("encode profile %s: %w", name, err)
+	}
+	content = append(content, '\n')
+	path := state.ProfilePath(plan.Home, name)
+	if err := state.AtomicWrite(path, content, 0o644); err != nil {
+		return 1, fmt.Errorf("write profile %s: %w", path, err)
+	}
+	if err := writeFormat(stdout, "saved profile %s: %s\n", name, path); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// cmdProfileList prints saved profile names, one per line.
+func cmdProfileList(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk profile list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("profile list does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	entries, err := os.ReadDir(state.ProfilesDir(home))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 1, fmt.Errorf("read profiles dir: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		profile, err := loadProfile(filepath.Join(state.ProfilesDir(home), entry.Name()))
+		if err != nil {
+			return 1, err
+		}
+		names = append(names, profile.Name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeLine(stdout, name); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// cmdProfileShow prints one saved profile file.
+func cmdProfileShow(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk profile show", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 1 {
+		return 2, fmt.Errorf("profile show requires exactly one NAME\n\n%s", profileUsage())
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	path := state.ProfilePath(home, fs.Arg(0))
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 1, fmt.Errorf("profile %q not found (%s)", fs.Arg(0), path)
+		}
+		return 1, fmt.Errorf("read profile %s: %w", path, err)
+	}
+	if _, err := stdout.Write(content); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// validateProfileName keeps profile names to a simple, file-friendly form.
+func validateProfileName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.' || r == '_' || r == '-':
+		default:
+			return fmt.Errorf("invalid profile name %q (allowed: letters, numbers, '.', '_', '-')", name)
+		}
+	}
+	return nil
+}
+
+// loadProfile reads and decodes one profile file.
+func loadProfile(path string) (*savedProfile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read profile %s: %w", path, err)
+	}
+	var profile savedProfile
+	if err := json.Unmarshal(content, &profile); err != nil {
+		return nil, fmt.Errorf("decode profile %s: %w", path, err)
+	}
+	return &profile, nil
+}
+
+// resolvePlanFromProfile rebuilds a resolvedPlan from a saved profile instead
+// of scanning workspaces and loading decomk.conf.
+//
+// Generated make fragments are re-derived from the saved toolchains and
+// recipes, and the saved rendered template fragment is reused, so replay does
+// not depend on the current conf repo.
+//
+// identity decrypts the ENC[age:...] values the profile stores.
+func resolvePlanFromProfile(home, logRoot string, logRootExplicit bool, name, identity string) (*resolvedPlan, error) {
+	if err := validateProfileName(name); err != nil {
+		return nil, err
+	}
+	path := state.ProfilePath(home, name)
+	if !fileExists(path) {
+		return nil, fmt.Errorf("profile %q not found (%s); save one with `decomk profile save %s`", name, path, name)
+	}
+	profile, err := loadProfile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var extraMakefiles []string
+	if len(profile.Toolchains) > 0 {
+		extraMakefiles = append(extraMakefiles, state.ToolchainsMakefile(home))
+	}
+	if len(profile.Recipes) > 0 {
+		extraMakefiles = append(extraMakefiles, state.RecipesMakefile(home))
+	}
+	if len(profile.Templates) > 0 {
+		extraMakefiles = append(extraMakefiles, state.TemplatesMakefile(home))
+	}
+	if len(profile.Namespaces) > 0 {
+		extraMakefiles = append(extraMakefiles, state.NamespacesMakefile(home))
+	}
+	if len(profile.TargetDirs) > 0 {
+		extraMakefiles = append(extraMakefiles, state.TargetDirsMakefile(home))
+	}
+	if len(makeOnlyNames(profile.TupleClasses)) > 0 {
+		extraMakefiles = append(extraMakefiles, state.UnexportMakefile(home))
+	}
+	tuples, secrets, err := decryptSecretTuples(profile.Tuples, identity)
+	if err != nil {
+		return nil, err
+	}
+	return &resolvedPlan{
+		Home:              home,
+		LogRoot:           logRoot,
+		LogRootExplicit:   logRootExplicit,
+		ContextKeys:       profile.ContextKeys,
+		ConfigPaths:       profile.ConfigPaths,
+		ConfDir:           profile.ConfDir,
+		StampDir:          state.StampDir(home),
+		EnvFile:           state.EnvFile(home),
+		Makefiles:         profile.Makefiles,
+		Namespaces:        profile.Namespaces,
+		TargetDirs:        profile.TargetDirs,
+		ExtraMakefiles:    extraMakefiles,
+		Toolchains:        profile.Toolchains,
+		Templates:         profile.Templates,
+		TemplatesMakefile: []byte(profile.TemplatesMakefile),
+		Recipes:           profile.Recipes,
+		Tags:              profile.Tags,
+		Expanded:          profile.Expanded,
+		Tuples:            tuples,
+		TupleClasses:      markSecretsEnvOnly(profile.TupleClasses, secrets),
+		Secrets:           secrets,
+		Profile:           profile.Name,
+		ProfileActionArgs: profile.ActionArgs,
+		Allow:             profile.Allow,
+		LowerTuples:       profile.LowerTuples,
+	}, nil
+}
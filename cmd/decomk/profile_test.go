@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestProfileSave_ReplaysAfterConfigChanges(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: FOO=yesterday INSTALL=hello\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("hello:\n\t@echo hello\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdProfile([]string{
+		"save",
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"good-day", "INSTALL",
+	}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("profile save: code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if !fileExists(state.ProfilePath(home, "good-day")) {
+		t.Fatalf("profile file missing: %s", state.ProfilePath(home, "good-day"))
+	}
+
+	// Config drift after the save must not affect replay.
+	if err := os.WriteFile(configPath, []byte("DEFAULT: FOO=today\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath drift): %v", err)
+	}
+
+	stdout.Reset()
+	code, err = cmdPlan([]string{"-home", home, "-profile", "good-day"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("plan -profile: code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	outText := stdout.String()
+	for _, needle := range []string{
+		"profile: good-day\n",
+		"actionArgs: INSTALL\n",
+		"FOO=yesterday",
+		"echo hello",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("plan -profile stdout missing %q:\n%s", needle, outText)
+		}
+	}
+	if strings.Contains(outText, "FOO=today") {
+		t.Fatalf("plan -profile used drifted config:\n%s", outText)
+	}
+
+	stdout.Reset()
+	code, err = cmdProfile([]string{"list", "-home", home}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("profile list: code=%d err=%v", code, err)
+	}
+	if got, want := stdout.String(), "good-day\n"; got != want {
+		t.Fatalf("profile list: got %q want %q", got, want)
+	}
+}
+
+func TestProfileSave_ReplaysTags(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: INSTALL='install-vnc install-jq'\ntag gui: install-vnc\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("install-vnc install-jq:\n\t@true\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdProfile([]string{
+		"save",
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"-makefile", makefilePath,
+		"p1", "INSTALL",
+	}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("profile save: code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+
+	stdout.Reset()
+	code, err = cmdPlan([]string{"-home", home, "-profile", "p1", "-tags", "gui", "INSTALL"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("plan -profile -tags: code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "skipped targets (-tags): install-jq\n") {
+		t.Fatalf("plan -profile -tags stdout missing the untagged target:\n%s", stdout.String())
+	}
+}
+
+func TestPlan_UnknownProfile(t *testing.T) {
+	t.Parallel()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	_, err := cmdPlan([]string{"-home", t.TempDir(), "-profile", "missing", "INSTALL"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), `profile "missing" not found`) {
+		t.Fatalf("cmdPlan(-profile missing) error: got %v", err)
+	}
+}
+
+func TestValidateProfileName(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"ok", "good-day_1.2"} {
+		if err := validateProfileName(name); err != nil {
+			t.Fatalf("validateProfileName(%q) error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".hidden", "a/b", "has space"} {
+		if err := validateProfileName(name); err == nil {
+			t.Fatalf("validateProfileName(%q): expected error", name)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gitProxyEnv, when non-empty in decomk's environment, routes the git
+// commands decomk runs itself (init, self-update) through the detected proxy
+// (see gitProxyEnvList).
+const gitProxyEnv = "DECOMK_GIT_PROXY"
+
+// proxyEnvNames are the proxy variables decomk detects, in their canonical
+// uppercase form. Each is also carried under its lowercase name.
+var proxyEnvNames = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+
+// isProxyEnvName reports whether name is a proxy variable in either casing.
+func isProxyEnvName(name string) bool {
+	for _, proxyName := range proxyEnvNames {
+		if name == proxyName || name == strings.ToLower(proxyName) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizedProxyEnv returns the proxy variables set in env under both their
+// uppercase and lowercase names.
+//
+// When both casings are set, the uppercase value wins; empty values count as
+// unset.
+func normalizedProxyEnv(env map[string]string) map[string]string {
+	out := make(map[string]string)
+	for _, name := range proxyEnvNames {
+		lower := strings.ToLower(name)
+		value := env[name]
+		if value == "" {
+			value = env[lower]
+		}
+		if value == "" {
+			continue
+		}
+		out[name] = value
+		out[lower] = value
+	}
+	return out
+}
+
+// proxyPassThroughTuples returns sorted NAME=value tuples for the normalized
+// proxy variables in incomingEnv.
+//
+// Intent: Keep proxy settings alive across env scrubbing (runuser -l, sudo
+// env_reset, fresh login shells that source env.sh) and across tools that
+// read only one casing, such as curl ignoring uppercase HTTP_PROXY.
+// Source: DI-buvam (TODO-jirin)
+func proxyPassThroughTuples(incomingEnv map[string]string) []string {
+	proxies := normalizedProxyEnv(incomingEnv)
+	out := make([]string, 0, len(proxies))
+	for name, value := range proxies {
+		out = append(out, name+"="+value)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// gitProxyEnvList returns the env entries that route one of decomk's own git
+// commands through the proxy, or nil when DECOMK_GIT_PROXY is unset.
+//
+// The entries are the normalized proxy variables plus http.proxy (HTTPS_PROXY,
+// else HTTP_PROXY), passed as GIT_CONFIG_COUNT/KEY/VALUE after any config
+// entries already in env so it overrides a stale http.proxy in gitconfig.
+// Passing config through the environment instead of "git -c" keeps proxy
+// credentials out of argv and error messages.
+func gitProxyEnvList(env map[string]string) []string {
+	if env[gitProxyEnv] == "" {
+		return nil
+	}
+	proxies := normalizedProxyEnv(env)
+	names := make([]string, 0, len(proxies))
+	for name := range proxies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]string, 0, len(names)+3)
+	for _, name := range names {
+		out = append(out, name+"="+proxies[name])
+	}
+
+	proxy := proxies["HTTPS_PROXY"]
+	if proxy == "" {
+		proxy = proxies["HTTP_PROXY"]
+	}
+	if proxy == "" {
+		return out
+	}
+	count, err := strconv.Atoi(env["GIT_CONFIG_COUNT"])
+	if err != nil || count < 0 {
+		count = 0
+	}
+	index := strconv.Itoa(count)
+	return append(out,
+		"GIT_CONFIG_COUNT="+strconv.Itoa(count+1),
+		"GIT_CONFIG_KEY_"+index+"=http.proxy",
+		"GIT_CONFIG_VALUE_"+index+"="+proxy,
+	)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	// remoteMakefileMaxBytes caps remote Makefile downloads so a misconfigured
+	// URL cannot fill the state volume.
+	remoteMakefileMaxBytes = 4 << 20
+
+	// remoteMakefileTimeout bounds one remote Makefile download.
+	remoteMakefileTimeout = 30 * time.Second
+
+	// remoteMakefilePinPrefix is the URL fragment prefix that pins content.
+	remoteMakefilePinPrefix = "sha256="
+)
+
+// remoteMakefileFetcher downloads pinned HTTPS makefiles into a
+// content-addressed cache.
+//
+// Intent: Let lightweight consumers run decomk against a published Makefile
+// without cloning a config repo, while keeping runs reproducible and
+// tamper-evident through mandatory sha256 pins.
+// Source: DI-torij (TODO-jirin)
+type remoteMakefileFetcher struct {
+	client   *http.Client
+	cacheDir string
+	// offline resolves an uncached reference to the reference itself
+	// instead of downloading it.
+	offline bool
+}
+
+// newRemoteMakefileFetcher returns a fetcher caching under
+// <DECOMK_HOME>/cache/makefiles.
+func newRemoteMakefileFetcher(home string) *remoteMakefileFetcher {
+	return &remoteMakefileFetcher{
+		client:   &http.Client{Timeout: remoteMakefileTimeout},
+		cacheDir: state.MakefileCacheDir(home),
+	}
+}
+
+// isRemoteMakefile reports whether a makefile reference is a URL rather than a
+// local path.
+func isRemoteMakefile(ref string) bool {
+	return strings.Contains(ref, "://")
+}
+
+// parseRemoteMakefileRef splits `https://...#sha256=<hex>` into the download
+// URL and the lower-case hex digest pin.
+func parseRemoteMakefileRef(ref string) (downloadURL, pin string, err error) {
+	return parsePinnedURL("makefile", ref)
+}
+
+// parsePinnedURL is parseRemoteMakefileRef for any pinned download; what names
+// the download in errors.
+func parsePinnedURL(what, ref string) (downloadURL, pin string, err error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", "", fmt.Errorf("parse %s URL %q: %w", what, ref, err)
+	}
+	if u.Scheme != "https" {
+		return "", "", fmt.Errorf("%s URL %q must use https", what, ref)
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("%s URL %q is missing a host", what, ref)
+	}
+	fragment := u.Fragment
+	u.Fragment = ""
+	downloadURL = u.String()
+	if fragment == "" {
+		return downloadURL, "", nil
+	}
+	if !strings.HasPrefix(fragment, remoteMakefilePinPrefix) {
+		return "", "", fmt.Errorf("%s URL %q has unsupported fragment %q (expected #sha256=<hex>)", what, ref, fragment)
+	}
+	pin = strings.ToLower(strings.TrimPrefix(fragment, remoteMakefilePinPrefix))
+	if len(pin) != sha256.Size*2 {
+		return "", "", fmt.Errorf("%s URL %q has invalid sha256 pin %q", what, ref, pin)
+	}
+	if _, err := hex.DecodeString(pin); err != nil {
+		return "", "", fmt.Errorf("%s URL %q has invalid sha256 pin %q", what, ref, pin)
+	}
+	return downloadURL, pin, nil
+}
+
+// resolve returns a local path for a pinned remote makefile reference,
+// downloading it only when no verified cached copy exists and f is not
+// offline.
+func (f *remoteMakefileFetcher) resolve(ref string) (string, error) {
+	downloadURL, pin, err := parseRemoteMakefileRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if pin != "" {
+		cached := filepath.Join(f.cacheDir, pin+".mk")
+		ok, err := cachedDigestMatches(cached, pin)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return cached, nil
+		}
+	}
+
+	if f.offline {
+		return ref, nil
+	}
+	body, err := f.download(downloadURL)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+	if pin == "" {
+		return "", fmt.Errorf("makefile URL %s is not pinned; append #sha256=%s after verifying its contents", downloadURL, digest)
+	}
+	if digest != pin {
+		return "", fmt.Errorf("makefile URL %s: sha256 mismatch: pinned %s, downloaded %s", downloadURL, pin, digest)
+	}
+
+	cached := filepath.Join(f.cacheDir, pin+".mk")
+	if err := state.AtomicWrite(cached, body, 0o644); err != nil {
+		return "", fmt.Errorf("cache makefile %s: %w", cached, err)
+	}
+	return cached, nil
+}
+
+// download fetches url with a size cap and a non-2xx status check.
+func (f *remoteMakefileFetcher) download(downloadURL string) (body []byte, err error) {
+	resp, err := f.client.Get(downloadURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch makefile %s: %w", downloadURL, err)
+	}
+	// Intent: Preserve response-body close failures instead of dropping them.
+	// Source: DI-golak (TODO-gamuz)
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			wrapped := fmt.Errorf("close makefile response %s: %w", downloadURL, closeErr)
+			if err == nil {
+				err = wrapped
+				return
+			}
+			err = errors.Join(err, wrapped)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("fetch makefile %s: HTTP %s", downloadURL, resp.Status)
+	}
+	body, err = io.ReadAll(io.LimitReader(resp.Body, remoteMakefileMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read makefile %s: %w", downloadURL, err)
+	}
+	if len(body) > remoteMakefileMaxBytes {
+		return nil, fmt.Errorf("fetch makefile %s: larger than %d bytes", downloadURL, remoteMakefileMaxBytes)
+	}
+	return body, nil
+}
+
+// cachedDigestMatches reports whether path exists and hashes to pin.
+//
+// A missing file is not an error (it just needs downloading); a file with the
+// wrong digest is treated the same way so a corrupted cache self-heals.
+func cachedDigestMatches(path, pin string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read cached makefile %s: %w", path, err)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]) == pin, nil
+}
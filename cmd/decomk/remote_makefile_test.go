@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseRemoteMakefileRef(t *testing.T) {
+	t.Parallel()
+
+	pin := strings.Repeat("ab", 32)
+	gotURL, gotPin, err := parseRemoteMakefileRef("https://example.com/mk/Makefile?x=1#sha256=" + strings.ToUpper(pin))
+	if err != nil {
+		t.Fatalf("parseRemoteMakefileRef() error: %v", err)
+	}
+	if gotURL != "https://example.com/mk/Makefile?x=1" || gotPin != pin {
+		t.Fatalf("parseRemoteMakefileRef(): got (%q, %q)", gotURL, gotPin)
+	}
+
+	for _, bad := range []string{
+		"http://example.com/Makefile#sha256=" + pin,
+		"https://example.com/Makefile#md5=abc",
+		"https://example.com/Makefile#sha256=1234",
+	} {
+		if _, _, err := parseRemoteMakefileRef(bad); err == nil {
+			t.Fatalf("parseRemoteMakefileRef(%q): expected error", bad)
+		}
+	}
+}
+
+func TestRemoteMakefileFetcher_PinnedDownloadAndCache(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("all:\n\t@echo remote\n")
+	sum := sha256.Sum256(content)
+	pin := hex.EncodeToString(sum[:])
+
+	var hits atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if _, err := w.Write(content); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	fetcher := &remoteMakefileFetcher{client: server.Client(), cacheDir: filepath.Join(t.TempDir(), "makefiles")}
+
+	path, err := fetcher.resolve(server.URL + "/Makefile#sha256=" + pin)
+	if err != nil {
+		t.Fatalf("resolve() error: %v", err)
+	}
+	if got, want := path, filepath.Join(fetcher.cacheDir, pin+".mk"); got != want {
+		t.Fatalf("resolve() path: got %q want %q", got, want)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(cached): %v", err)
+	}
+	if string(got) != string(content) {
+		t.Fatalf("cached content: got %q want %q", got, content)
+	}
+
+	// A verified cache hit must not touch the network.
+	if _, err := fetcher.resolve(server.URL + "/Makefile#sha256=" + pin); err != nil {
+		t.Fatalf("second resolve() error: %v", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("server hits: got %d want 1", got)
+	}
+}
+
+func TestRemoteMakefileFetcher_RejectsUnpinnedAndMismatch(t *testing.T) {
+	t.Parallel()
+
+	content := []byte("all:\n")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(content); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	fetcher := &remoteMakefileFetcher{client: server.Client(), cacheDir: t.TempDir()}
+
+	_, err := fetcher.resolve(server.URL + "/Makefile")
+	if err == nil || !strings.Contains(err.Error(), "#sha256="+digest) {
+		t.Fatalf("resolve(unpinned) error: got %v want pin hint with %s", err, digest)
+	}
+
+	wrong := strings.Repeat("0", 64)
+	_, err = fetcher.resolve(server.URL + "/Makefile#sha256=" + wrong)
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("resolve(mismatch) error: got %v", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(fetcher.cacheDir, wrong+".mk")); !os.IsNotExist(statErr) {
+		t.Fatalf("mismatched download was cached (err=%v)", statErr)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/state"
+)
+
+// noResolveCacheEnv disables the resolution cache when set to a non-empty
+// value.
+const noResolveCacheEnv = "DECOMK_NO_RESOLVE_CACHE"
+
+// makeDatabaseFromPattern matches the origin comments in make's printed
+// database, which name every makefile that contributed a variable or recipe.
+var makeDatabaseFromPattern = regexp.MustCompile(`\(from '([^']*)', line \d+\)`)
+
+// resolveCacheEnabled reports whether the resolution cache under home may be
+// used. Without a home there is nowhere to keep it: state.ResolveCacheDir("")
+// would be relative to the working directory.
+func resolveCacheEnabled(home string) bool {
+	return home != "" && os.Getenv(noResolveCacheEnv) == ""
+}
+
+// defsCacheVersion changes whenever defsCache gains a field, so caches
+// written without it are reloaded instead of trusted.
+const defsCacheVersion = 2
+
+// defsCache is the cached loadDefs result for one set of config file contents.
+type defsCache struct {
+	Version int              `json:"version"`
+	Key     string           `json:"key"`
+	Defs    contexts.Defs    `json:"defs"`
+	Recipes contexts.Recipes `json:"recipes"`
+	Docs    contexts.Docs    `json:"docs"`
+	Tags    contexts.Tags    `json:"tags"`
+	Paths   []string         `json:"paths"`
+	// LowerTuples is contexts.Config.LowerTuples.
+	LowerTuples map[string][]string `json:"lowerTuples,omitempty"`
+}
+
+// workspacesCache maps a workspace key (see workspaceCacheKey) to the
+// inspected repo.
+type workspacesCache map[string]workspaceRepo
+
+// makeRulesCache is the cached rule database for one make invocation.
+type makeRulesCache struct {
+	Key string `json:"key"`
+	// Inputs maps every makefile the database came from to its content hash.
+	Inputs map[string]string `json:"inputs"`
+	Rules  makeRules         `json:"rules"`
+}
+
+// cachedLoadDefs is loadDefsWithLocal, with home's local override file,
+// backed by <DECOMK_HOME>/cache/resolve/defs.json and keyed by the paths and
+// contents of every config file it would read.
+//
+// Intent: Make repeated plan/status-style calls from lifecycle hooks and
+// prompts skip re-parsing unchanged config, without ever serving a result
+// for content that changed.
+// Source: DI-dusot (TODO-jirin)
+func cachedLoadDefs(home, confDir, explicitConfig string) (contexts.Config, error) {
+	localConfig := state.LocalConfigPath(home)
+	key, ok := defsCacheKey(confDir, localConfig, explicitConfig)
+	if !ok || !resolveCacheEnabled(home) {
+		return loadDefsWithLocal(confDir, localConfig, explicitConfig)
+	}
+	path := filepath.Join(state.ResolveCacheDir(home), "defs.json")
+	var cache defsCache
+	if readResolveCache(path, &cache) && cache.Version == defsCacheVersion && cache.Key == key {
+		return contexts.Config{Defs: cache.Defs, Recipes: cache.Recipes, Docs: cache.Docs, Tags: cache.Tags, Paths: cache.Paths, LowerTuples: cache.LowerTuples}, nil
+	}
+	cfg, err := loadDefsWithLocal(confDir, localConfig, explicitConfig)
+	if err != nil {
+		return contexts.Config{}, err
+	}
+	if err := writeResolveCache(path, defsCache{Version: defsCacheVersion, Key: key, Defs: cfg.Defs, Recipes: cfg.Recipes, Docs: cfg.Docs, Tags: cfg.Tags, Paths: cfg.Paths, LowerTuples: cfg.LowerTuples}); err != nil {
+		return contexts.Config{}, err
+	}
+	return cfg, nil
+}
+
+// defsCacheKey hashes the config files loadDefsWithLocal would read for
+// confDir, localConfig, and explicitConfig. It returns ok=false when they
+// cannot all be read, leaving the error to loadDefsWithLocal.
+func defsCacheKey(confDir, localConfig, explicitConfig string) (string, bool) {
+	var sources []string
+	if configRepo, ok := configRepoConfigPath(confDir); ok {
+		sources = append(sources, configRepo)
+	}
+	if fileExists(localConfig) {
+		sources = append(sources, localConfig)
+	}
+	if explicitConfig != "" {
+		sources = append(sources, explicitConfig)
+	}
+	if len(sources) == 0 {
+		return "", false
+	}
+	h := sha256.New()
+	for _, source := range sources {
+		files, err := configTreeFiles(source)
+		if err != nil {
+			return "", false
+		}
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return "", false
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00", file, len(content))
+			h.Write(content)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// configTreeFiles returns the files contexts.LoadTree reads for path, in
+// load order: path, then <basename>.d/*.conf.
+func configTreeFiles(path string) ([]string, error) {
+	files := []string{path}
+	dDir := filepath.Join(filepath.Dir(path), strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+".d")
+	entries, err := os.ReadDir(dDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return files, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".conf" {
+			files = append(files, filepath.Join(dDir, entry.Name()))
+		}
+	}
+	sort.Strings(files[1:])
+	return files, nil
+}
+
+// cachedDiscoverWorkspaces is discoverWorkspaces backed by
+// <DECOMK_HOME>/cache/resolve/workspaces.json, which records each inspected
+// workspace under a hash of its root and .git/config contents.
+func cachedDiscoverWorkspaces(home, workspacesDir string) ([]workspaceRepo, error) {
+	if !resolveCacheEnabled(home) {
+		return discoverWorkspaces(workspacesDir)
+	}
+	path := filepath.Join(state.ResolveCacheDir(home), "workspaces.json")
+	cache := make(workspacesCache)
+	readResolveCache(path, &cache)
+	fresh := make(workspacesCache)
+	repos, err := discoverWorkspacesWith(workspacesDir, func(root string) workspaceRepo {
+		key, ok := workspaceCacheKey(root)
+		if !ok {
+			return inspectWorkspaceRepo(root)
+		}
+		repo, hit := cache[key]
+		if !hit {
+			repo = inspectWorkspaceRepo(root)
+		}
+		fresh[key] = repo
+		return repo
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(fresh) > 0 && !sameWorkspacesCache(cache, fresh) {
+		if err := writeResolveCache(path, fresh); err != nil {
+			return nil, err
+		}
+	}
+	return repos, nil
+}
+
+// workspaceCacheKey hashes root and its .git/config, which holds the origin
+// URL inspectWorkspaceRepo reads. It returns ok=false for worktrees and other
+// layouts without a .git/config file, which are always inspected.
+func workspaceCacheKey(root string) (string, bool) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", false
+	}
+	content, err := os.ReadFile(filepath.Join(abs, ".git", "config"))
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(append([]byte(abs+"\x00"), content...))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// sameWorkspacesCache reports whether a and b hold the same keys.
+func sameWorkspacesCache(a, b workspacesCache) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedMakeRules returns the parsed make rule database for the invocation
+// (see makeDatabase), from <DECOMK_HOME>/cache/resolve/makedb.json when the
+// invocation and the contents of every makefile the database came from are
+// unchanged.
+//
+// Makefiles whose rules depend on more than their text, the invocation, and
+// the environment (for example $(shell date)) can be served stale rules; set
+// DECOMK_NO_RESOLVE_CACHE=1 for those.
+func cachedMakeRules(home, dir string, makefiles, tuples, env []string) (makeRules, error) {
+	if !resolveCacheEnabled(home) {
+		database, err := makeDatabase(dir, makefiles, tuples, env)
+		if err != nil {
+			return makeRules{}, err
+		}
+		return parseMakeDatabase(database), nil
+	}
+	key := makeRulesCacheKey(dir, makefiles, tuples, env)
+	path := filepath.Join(state.ResolveCacheDir(home), "makedb.json")
+	var cache makeRulesCache
+	if readResolveCache(path, &cache) && cache.Key == key && fileHashesMatch(cache.Inputs) {
+		return cache.Rules, nil
+	}
+	database, err := makeDatabase(dir, makefiles, tuples, env)
+	if err != nil {
+		return makeRules{}, err
+	}
+	rules := parseMakeDatabase(database)
+	inputs := make(map[string]string)
+	for _, file := range makefiles {
+		inputs[file] = ""
+	}
+	for _, m := range makeDatabaseFromPattern.FindAllStringSubmatch(database, -1) {
+		file := m[1]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		// make reads "-f -" from a temp copy it deletes on exit.
+		if fileExists(file) {
+			inputs[file] = ""
+		}
+	}
+	for file := range inputs {
+		sum, err := fileSHA256(file)
+		if err != nil {
+			// An input that cannot be hashed cannot be validated later, so
+			// the rules are not cached.
+			return rules, nil
+		}
+		inputs[file] = sum
+	}
+	if err := writeResolveCache(path, makeRulesCache{Key: key, Inputs: inputs, Rules: rules}); err != nil {
+		return makeRules{}, err
+	}
+	return rules, nil
+}
+
+// makeRulesCacheKey hashes everything a make invocation is given besides the
+// makefile contents: its directory, makefiles, tuples, and sorted environment.
+func makeRulesCacheKey(dir string, makefiles, tuples, env []string) string {
+	sortedEnv := append([]string(nil), env...)
+	sort.Strings(sortedEnv)
+	h := sha256.New()
+	for _, group := range [][]string{{dir}, makefiles, tuples, sortedEnv} {
+		for _, s := range group {
+			fmt.Fprintf(h, "%s\x00", s)
+		}
+		h.Write([]byte{0xff})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fileHashesMatch reports whether every file in hashes still has the recorded
+// content hash.
+func fileHashesMatch(hashes map[string]string) bool {
+	if len(hashes) == 0 {
+		return false
+	}
+	for file, want := range hashes {
+		if got, err := fileSHA256(file); err != nil || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// readResolveCache decodes the cache file at path into v, reporting whether it
+// could. A missing or corrupt cache is a miss, not an error.
+func readResolveCache(path string, v any) bool {
+	content, err := os.ReadFile(path)
+	return err == nil && json.Unmarshal(content, v) == nil
+}
+
+// writeResolveCache replaces the cache file at path with v.
+func writeResolveCache(path string, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode resolve cache: %w", err)
+	}
+	if err := state.AtomicWrite(path, content, 0o644); err != nil {
+		return fmt.Errorf("write resolve cache %s: %w", path, err)
+	}
+	return nil
+}
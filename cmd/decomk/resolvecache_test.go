@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// rewriteResolveCache decodes the cache file at path into v, applies edit, and
+// writes it back, so tests can tell a cache hit from a recomputation.
+func rewriteResolveCache(t *testing.T, path string, v any, edit func()) {
+	t.Helper()
+
+	if !readResolveCache(path, v) {
+		t.Fatalf("readResolveCache(%s): no cache", path)
+	}
+	edit()
+	if err := writeResolveCache(path, v); err != nil {
+		t.Fatalf("writeResolveCache(%s): %v", path, err)
+	}
+}
+
+func TestCachedLoadDefs(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	config := filepath.Join(t.TempDir(), "decomk.conf")
+	if err := os.WriteFile(config, []byte("DEFAULT: FOO=1\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := cachedLoadDefs(home, state.ConfDir(home), config); err != nil {
+		t.Fatalf("cachedLoadDefs() error: %v", err)
+	}
+
+	path := filepath.Join(state.ResolveCacheDir(home), "defs.json")
+	var cache defsCache
+	rewriteResolveCache(t, path, &cache, func() { cache.Defs["DEFAULT"] = []string{"FOO=cached"} })
+	cfg, err := cachedLoadDefs(home, state.ConfDir(home), config)
+	if err != nil {
+		t.Fatalf("cachedLoadDefs(hit) error: %v", err)
+	}
+	if got, want := cfg.Defs["DEFAULT"], []string{"FOO=cached"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("cachedLoadDefs(hit) DEFAULT: got %q want %q", got, want)
+	}
+
+	// A new drop-in file changes the key.
+	if err := os.MkdirAll(filepath.Join(filepath.Dir(config), "decomk.d"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(config), "decomk.d", "10.conf"), []byte("DEFAULT: FOO=2\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg, err = cachedLoadDefs(home, state.ConfDir(home), config)
+	if err != nil {
+		t.Fatalf("cachedLoadDefs(changed) error: %v", err)
+	}
+	if got, want := cfg.Defs["DEFAULT"], []string{"FOO=2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("cachedLoadDefs(changed) DEFAULT: got %q want %q", got, want)
+	}
+}
+
+func TestCachedDiscoverWorkspaces(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not available: %v", err)
+	}
+	home := t.TempDir()
+	workspaces := t.TempDir()
+	root := filepath.Join(workspaces, "app")
+	if output, err := exec.Command("git", "init", "-q", root).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, output)
+	}
+	writeOrigin := func(url string) {
+		if output, err := exec.Command("git", "-C", root, "config", "remote.origin.url", url).CombinedOutput(); err != nil {
+			t.Fatalf("git config: %v\n%s", err, output)
+		}
+	}
+	writeOrigin("https://github.com/acme/app.git")
+
+	repos, err := cachedDiscoverWorkspaces(home, workspaces)
+	if err != nil {
+		t.Fatalf("cachedDiscoverWorkspaces() error: %v", err)
+	}
+	if len(repos) != 1 || repos[0].OwnerRepo != "acme/app" {
+		t.Fatalf("cachedDiscoverWorkspaces(): got %#v", repos)
+	}
+
+	path := filepath.Join(state.ResolveCacheDir(home), "workspaces.json")
+	cache := make(workspacesCache)
+	rewriteResolveCache(t, path, &cache, func() {
+		for key, repo := range cache {
+			repo.OwnerRepo = "cached/app"
+			cache[key] = repo
+		}
+	})
+	if repos, err = cachedDiscoverWorkspaces(home, workspaces); err != nil || repos[0].OwnerRepo != "cached/app" {
+		t.Fatalf("cachedDiscoverWorkspaces(hit): got %#v, %v", repos, err)
+	}
+
+	writeOrigin("https://github.com/other/app.git")
+	if repos, err = cachedDiscoverWorkspaces(home, workspaces); err != nil || repos[0].OwnerRepo != "other/app" {
+		t.Fatalf("cachedDiscoverWorkspaces(changed): got %#v, %v", repos, err)
+	}
+}
+
+func TestCachedMakeRules(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	dir := t.TempDir()
+	makefile := filepath.Join(dir, "Makefile")
+	included := filepath.Join(dir, "inc.mk")
+	if err := os.WriteFile(makefile, []byte("include inc.mk\nall: a\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(included, []byte("a:\n\ttouch $@\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	env := []string{"PATH=" + os.Getenv("PATH")}
+
+	rules, err := cachedMakeRules(home, dir, []string{makefile}, nil, env)
+	if err != nil {
+		t.Fatalf("cachedMakeRules() error: %v", err)
+	}
+	if !rules.hasRecipe("a") {
+		t.Fatalf("cachedMakeRules(): no recipe for a: %#v", rules)
+	}
+
+	path := filepath.Join(state.ResolveCacheDir(home), "makedb.json")
+	var cache makeRulesCache
+	rewriteResolveCache(t, path, &cache, func() { cache.Rules.Prereqs["cached"] = nil })
+	if _, ok := cache.Inputs[included]; !ok {
+		t.Fatalf("cache inputs miss included makefile: %#v", cache.Inputs)
+	}
+	if rules, err = cachedMakeRules(home, dir, []string{makefile}, nil, env); err != nil || !rules.Defines("cached") {
+		t.Fatalf("cachedMakeRules(hit): got %#v, %v", rules.Prereqs, err)
+	}
+
+	// Changing an included makefile invalidates the entry.
+	if err := os.WriteFile(included, []byte("a:\n\ttouch $@\nb:\n\ttouch $@\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if rules, err = cachedMakeRules(home, dir, []string{makefile}, nil, env); err != nil || rules.Defines("cached") || !rules.hasRecipe("b") {
+		t.Fatalf("cachedMakeRules(changed): got %#v, %v", rules.Prereqs, err)
+	}
+}
+
+func TestResolveCacheEnabled_RequiresHome(t *testing.T) {
+	t.Setenv(noResolveCacheEnv, "")
+
+	if resolveCacheEnabled("") {
+		t.Fatalf("resolveCacheEnabled(\"\"): got true want false (the cache would land in the working directory)")
+	}
+	if !resolveCacheEnabled(t.TempDir()) {
+		t.Fatalf("resolveCacheEnabled(home): got false want true")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// resourceUsage is what a run's make process tree consumed, as recorded in
+// the timings history.
+type resourceUsage struct {
+	UserSeconds   float64 `json:"userSeconds"`
+	SystemSeconds float64 `json:"systemSeconds"`
+	// PeakRSSBytes is the largest resident set of any single process in the
+	// tree.
+	PeakRSSBytes int64 `json:"peakRSSBytes"`
+	// WrittenBytes is what the tree wrote to block devices.
+	WrittenBytes int64 `json:"writtenBytes"`
+	// CgroupPeakMemoryBytes is the container's peak memory (cgroup v2
+	// memory.peak), which also covers earlier runs and other processes.
+	CgroupPeakMemoryBytes int64 `json:"cgroupPeakMemoryBytes,omitempty"`
+	// CgroupWrittenBytes is what the whole container wrote during the run
+	// (cgroup v2 io.stat wbytes).
+	CgroupWrittenBytes int64 `json:"cgroupWrittenBytes,omitempty"`
+}
+
+// usageSnapshot is the resource counters at one point in a run.
+type usageSnapshot struct {
+	children syscall.Rusage
+	// cgroupDir is the process's cgroup v2 directory, or "" without one.
+	cgroupDir string
+	// cgroupWritten is valid when haveCgroupIO is set.
+	cgroupWritten int64
+	haveCgroupIO  bool
+}
+
+// takeUsageSnapshot reads the counters of decomk's reaped children and, when
+// available, of its cgroup.
+func takeUsageSnapshot() (usageSnapshot, error) {
+	var s usageSnapshot
+	if err := syscall.Getrusage(syscall.RUSAGE_CHILDREN, &s.children); err != nil {
+		return s, fmt.Errorf("read child resource usage: %w", err)
+	}
+	dir, err := ownCgroupDir()
+	if err != nil || dir == "" {
+		return s, err
+	}
+	s.cgroupDir = dir
+	s.cgroupWritten, s.haveCgroupIO, err = cgroupWrittenBytes(dir)
+	return s, err
+}
+
+// usageBetween returns what the children reaped between before and after
+// consumed.
+//
+// make and its recipes are reaped by the time make returns, so the delta of
+// decomk's RUSAGE_CHILDREN counters around the make phase is the make process
+// tree's usage, for one invocation or many parallel ones. Peak RSS is not a
+// counter: it is the largest child so far, which is the make tree unless an
+// earlier child (git, make -p) was larger.
+//
+// Intent: Show teams what bootstrap actually costs in CPU, memory, and disk
+// writes so they can right-size devcontainer resources.
+// Source: DI-mifug (TODO-jirin)
+func usageBetween(before, after usageSnapshot) (resourceUsage, error) {
+	u := resourceUsage{
+		UserSeconds:   timevalSeconds(after.children.Utime) - timevalSeconds(before.children.Utime),
+		SystemSeconds: timevalSeconds(after.children.Stime) - timevalSeconds(before.children.Stime),
+		// Linux reports ru_maxrss in KiB and ru_oublock in 512-byte blocks.
+		PeakRSSBytes: int64(after.children.Maxrss) * 1024,
+		WrittenBytes: (int64(after.children.Oublock) - int64(before.children.Oublock)) * 512,
+	}
+	if after.cgroupDir == "" || after.cgroupDir != before.cgroupDir {
+		return u, nil
+	}
+	if before.haveCgroupIO && after.haveCgroupIO {
+		u.CgroupWrittenBytes = after.cgroupWritten - before.cgroupWritten
+	}
+	peak, err := os.ReadFile(filepath.Join(after.cgroupDir, "memory.peak"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return u, nil
+		}
+		return u, fmt.Errorf("read cgroup memory peak: %w", err)
+	}
+	u.CgroupPeakMemoryBytes, err = strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+	if err != nil {
+		return u, fmt.Errorf("parse %s: %w", filepath.Join(after.cgroupDir, "memory.peak"), err)
+	}
+	return u, nil
+}
+
+// ownCgroupDir returns decomk's cgroup v2 directory, or "" when the process
+// is not in a cgroup v2 hierarchy.
+func ownCgroupDir() (string, error) {
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("read cgroup membership: %w", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			dir := filepath.Join(cgroupRoot, path)
+			if !fileExists(filepath.Join(dir, "cgroup.controllers")) {
+				// A hybrid host whose v2 hierarchy is not mounted here.
+				return "", nil
+			}
+			return dir, nil
+		}
+	}
+	return "", nil
+}
+
+// cgroupWrittenBytes sums the wbytes of every device in dir's io.stat. It
+// returns ok=false when the io controller is not enabled for dir.
+func cgroupWrittenBytes(dir string) (int64, bool, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "io.stat"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("read cgroup io stats: %w", err)
+	}
+	var total int64
+	for _, line := range strings.Split(string(content), "\n") {
+		for _, field := range strings.Fields(line) {
+			value, ok := strings.CutPrefix(field, "wbytes=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, false, fmt.Errorf("parse %s: %w", filepath.Join(dir, "io.stat"), err)
+			}
+			total += n
+		}
+	}
+	return total, true, nil
+}
+
+// timevalSeconds converts tv to seconds.
+func timevalSeconds(tv syscall.Timeval) float64 {
+	return float64(tv.Sec) + float64(tv.Usec)/1e6
+}
+
+// formatUsage renders u for the end-of-run summary and `profile timing`.
+func formatUsage(u resourceUsage) string {
+	text := fmt.Sprintf("cpu %s (user %s, sys %s), peak rss %s, written %s",
+		formatSeconds(u.UserSeconds+u.SystemSeconds), formatSeconds(u.UserSeconds), formatSeconds(u.SystemSeconds),
+		humanBytes(u.PeakRSSBytes), humanBytes(u.WrittenBytes))
+	if u.CgroupPeakMemoryBytes > 0 {
+		text += ", container peak memory " + humanBytes(u.CgroupPeakMemoryBytes)
+	}
+	if u.CgroupWrittenBytes > 0 {
+		text += ", container written " + humanBytes(u.CgroupWrittenBytes)
+	}
+	return text
+}
+
+// finishUsage returns the usage since start, which takeUsageSnapshot returned
+// with startErr. The usage is nil if the child counters could not be read; it
+// is returned along with an error about unreadable cgroup stats.
+func finishUsage(start usageSnapshot, startErr error) (*resourceUsage, error) {
+	if startErr != nil {
+		return nil, startErr
+	}
+	end, err := takeUsageSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	u, err := usageBetween(start, end)
+	return &u, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUsageBetween(t *testing.T) {
+	t.Parallel()
+
+	cgroup := t.TempDir()
+	writeFileFixture(t, filepath.Join(cgroup, "memory.peak"), []byte("2147483648\n"), 0o644)
+	before := usageSnapshot{cgroupDir: cgroup, cgroupWritten: 1000, haveCgroupIO: true}
+	before.children.Utime = syscall.Timeval{Sec: 1}
+	before.children.Oublock = 10
+	after := usageSnapshot{cgroupDir: cgroup, cgroupWritten: 5000, haveCgroupIO: true}
+	after.children.Utime = syscall.Timeval{Sec: 3, Usec: 500000}
+	after.children.Stime = syscall.Timeval{Sec: 1}
+	after.children.Maxrss = 2048
+	after.children.Oublock = 30
+
+	got, err := usageBetween(before, after)
+	if err != nil {
+		t.Fatalf("usageBetween(): %v", err)
+	}
+	want := resourceUsage{UserSeconds: 2.5, SystemSeconds: 1, PeakRSSBytes: 2 << 20, WrittenBytes: 20 * 512, CgroupPeakMemoryBytes: 2 << 30, CgroupWrittenBytes: 4000}
+	if got != want {
+		t.Fatalf("usageBetween(): got %+v want %+v", got, want)
+	}
+	if got, want := formatUsage(got), "cpu 3.5s (user 2.5s, sys 1s), peak rss 2.0 MiB, written 10.0 KiB, container peak memory 2.0 GiB, container written 3.9 KiB"; got != want {
+		t.Fatalf("formatUsage(): got %q want %q", got, want)
+	}
+}
+
+func TestCgroupWrittenBytes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if _, ok, err := cgroupWrittenBytes(dir); ok || err != nil {
+		t.Fatalf("cgroupWrittenBytes(no io.stat): got ok=%v err=%v want false, nil", ok, err)
+	}
+	writeFileFixture(t, filepath.Join(dir, "io.stat"), []byte("8:0 rbytes=1 wbytes=100 rios=1 wios=2\n259:0 rbytes=5 wbytes=23\n"), 0o644)
+	if n, ok, err := cgroupWrittenBytes(dir); n != 123 || !ok || err != nil {
+		t.Fatalf("cgroupWrittenBytes(): got %d, %v, %v want 123, true, nil", n, ok, err)
+	}
+}
+
+func TestFinishUsage_CountsChildren(t *testing.T) {
+	t.Parallel()
+
+	start, startErr := takeUsageSnapshot()
+	cmd := exec.Command("sh", "-c", "while :; do :; done")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := cmd.Process.Signal(os.Kill); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Fatalf("Wait(): got %v want killed", err)
+	}
+	usage, err := finishUsage(start, startErr)
+	if usage == nil {
+		t.Fatalf("finishUsage(): got nil usage, %v", err)
+	}
+	if cpu := usage.UserSeconds + usage.SystemSeconds; cpu <= 0 {
+		t.Fatalf("finishUsage(): got cpu %v want > 0", cpu)
+	}
+	if usage.PeakRSSBytes <= 0 {
+		t.Fatalf("finishUsage(): got peak rss %d want > 0", usage.PeakRSSBytes)
+	}
+}
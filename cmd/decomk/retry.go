@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stevegt/decomk/makeexec"
+)
+
+const (
+	// retryTuple is how many times a make invocation that failed with a
+	// retryable error is rerun.
+	retryTuple = "DECOMK_RETRY"
+	// retryPatternsTuple adds ';'-separated class=regex failure patterns.
+	retryPatternsTuple = "DECOMK_RETRY_PATTERNS"
+	// defaultRetries applies when retryTuple is unset.
+	defaultRetries = 2
+	// defaultRetryDelay is the wait before the first retry; each later retry
+	// waits one more multiple of it.
+	defaultRetryDelay = 10 * time.Second
+)
+
+// retryClass is a named kind of transient failure and the output lines that
+// identify it.
+type retryClass struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// builtinRetryClasses are the failure classes retried without any config.
+var builtinRetryClasses = []retryClass{
+	{Name: "network", Pattern: regexp.MustCompile(`(?i)(connection (timed out|reset|refused)|could not resolve host|temporary failure (in name resolution|resolving)|network is unreachable|TLS handshake timeout|i/o timeout|operation timed out)`)},
+	{Name: "apt-lock", Pattern: regexp.MustCompile(`(?i)(could not get lock /var/lib/(dpkg|apt)|unable to acquire the dpkg frontend lock)`)},
+	{Name: "http-5xx", Pattern: regexp.MustCompile(`(HTTP/[0-9.]+ 5\d\d|returned error: 5\d\d|\b50[234] (Bad Gateway|Service Unavailable|Gateway Time-?out))`)},
+}
+
+// retryPolicy is when and how often decomk reruns a failed make invocation.
+type retryPolicy struct {
+	Retries int
+	Delay   time.Duration
+	Classes []retryClass
+}
+
+// parseRetryPolicy reads DECOMK_RETRY and DECOMK_RETRY_PATTERNS from tuples;
+// config patterns are checked after the built-in classes.
+func parseRetryPolicy(tuples []string) (retryPolicy, error) {
+	policy := retryPolicy{Retries: defaultRetries, Delay: defaultRetryDelay}
+	policy.Classes = append(policy.Classes, builtinRetryClasses...)
+	values := effectiveTupleValues(tuples)
+	if raw, ok := values[retryTuple]; ok {
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("invalid %s %q (expected a non-negative integer)", retryTuple, raw)
+		}
+		policy.Retries = n
+	}
+	for _, entry := range strings.Split(values[retryPatternsTuple], ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, pattern, found := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || pattern == "" {
+			return policy, fmt.Errorf("invalid %s entry %q (expected class=regex)", retryPatternsTuple, entry)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s entry %q: %w", retryPatternsTuple, entry, err)
+		}
+		policy.Classes = append(policy.Classes, retryClass{Name: name, Pattern: re})
+	}
+	return policy, nil
+}
+
+// failureMatcher records the first output line written to it that matches a
+// retry class. It is safe for concurrent use, since make's stdout and stderr
+// are copied into the same matcher from separate goroutines.
+type failureMatcher struct {
+	classes []retryClass
+	mu      sync.Mutex
+	pending []byte
+	// Class and Line are the first match, if any.
+	Class string
+	Line  string
+}
+
+func (m *failureMatcher) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, p...)
+	for {
+		i := bytes.IndexByte(m.pending, '\n')
+		if i < 0 {
+			break
+		}
+		m.match(string(m.pending[:i]))
+		m.pending = m.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush checks a trailing partial line.
+func (m *failureMatcher) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pending) > 0 {
+		m.match(string(m.pending))
+		m.pending = nil
+	}
+}
+
+func (m *failureMatcher) match(line string) {
+	if m.Class != "" {
+		return
+	}
+	line = strings.TrimSpace(finalRedraw(line))
+	for _, class := range m.classes {
+		if class.Pattern.MatchString(line) {
+			m.Class, m.Line = class.Name, line
+			return
+		}
+	}
+}
+
+// runWithRetry calls run, passing a writer that must receive the attempt's
+// make output, and calls it again while it fails with output matching a
+// retry class, up to policy.Retries more times. It reports each retry to w
+// with label naming what is retried.
+//
+// Intent: Ride out transient infrastructure failures at container start
+// without blanket-retrying deterministic recipe bugs.
+// Source: DI-kagin (TODO-jirin)
+func runWithRetry(policy retryPolicy, w io.Writer, label string, run func(output io.Writer) (int, error)) (int, error) {
+	for attempt := 1; ; attempt++ {
+		matcher := &failureMatcher{classes: policy.Classes}
+		exitCode, err := run(matcher)
+		matcher.flush()
+		var interrupted *makeexec.InterruptedError
+		if err == nil || attempt > policy.Retries || matcher.Class == "" || errors.As(err, &interrupted) {
+			return exitCode, err
+		}
+		delay := policy.Delay * time.Duration(attempt)
+		msg := fmt.Sprintf("decomk: %s failed with a retryable %s error (%s); retrying in %s (retry %d of %d)", label, matcher.Class, matcher.Line, delay, attempt, policy.Retries)
+		if writeErr := writeLine(w, msg); writeErr != nil {
+			return exitCode, errors.Join(err, writeErr)
+		}
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/stevegt/decomk/makeexec"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := parseRetryPolicy(nil)
+	if err != nil || policy.Retries != defaultRetries || len(policy.Classes) != len(builtinRetryClasses) {
+		t.Fatalf("parseRetryPolicy(nil): got %+v, %v want the defaults", policy, err)
+	}
+
+	policy, err = parseRetryPolicy([]string{"DECOMK_RETRY=0", "DECOMK_RETRY_PATTERNS=proxy=407 Proxy Authentication; mirror=Hash Sum mismatch"})
+	if err != nil {
+		t.Fatalf("parseRetryPolicy(): %v", err)
+	}
+	if policy.Retries != 0 || len(policy.Classes) != len(builtinRetryClasses)+2 || policy.Classes[len(policy.Classes)-1].Name != "mirror" {
+		t.Fatalf("parseRetryPolicy(): got %+v want 0 retries and the proxy and mirror classes", policy)
+	}
+
+	for _, tuple := range []string{"DECOMK_RETRY=-1", "DECOMK_RETRY=many", "DECOMK_RETRY_PATTERNS=noequals", "DECOMK_RETRY_PATTERNS=bad=("} {
+		if _, err := parseRetryPolicy([]string{tuple}); err == nil {
+			t.Fatalf("parseRetryPolicy(%s): got nil want error", tuple)
+		}
+	}
+}
+
+func TestRunWithRetry(t *testing.T) {
+	t.Parallel()
+
+	policy := retryPolicy{Retries: 2, Classes: builtinRetryClasses}
+	cases := []struct {
+		name      string
+		output    string
+		err       error
+		wantCalls int
+	}{
+		{name: "network", output: "curl: (6) Could not resolve host: example.com\n", err: errors.New("exit status 2"), wantCalls: 3},
+		{name: "deterministic", output: "cp: cannot stat 'x': No such file or directory\n", err: errors.New("exit status 2"), wantCalls: 1},
+		{name: "interrupted", output: "Connection timed out", err: &makeexec.InterruptedError{Signal: syscall.SIGINT, Err: errors.New("exit status 2")}, wantCalls: 1},
+		{name: "success", output: "Connection refused, using the cache\n", wantCalls: 1},
+	}
+	for _, tc := range cases {
+		var stderr bytes.Buffer
+		calls := 0
+		_, err := runWithRetry(policy, &stderr, "make", func(output io.Writer) (int, error) {
+			calls++
+			if _, err := io.WriteString(output, tc.output); err != nil {
+				return 1, err
+			}
+			if tc.err != nil {
+				return 2, tc.err
+			}
+			return 0, nil
+		})
+		if calls != tc.wantCalls || !errors.Is(err, tc.err) {
+			t.Fatalf("runWithRetry(%s): got %d calls, %v want %d calls, %v", tc.name, calls, err, tc.wantCalls, tc.err)
+		}
+		if want := "decomk: make failed with a retryable network error (curl: (6) Could not resolve host: example.com); retrying in 0s (retry 1 of 2)"; tc.name == "network" && !strings.HasPrefix(stderr.String(), want) {
+			t.Fatalf("runWithRetry(network) output: got %q want prefix %q", stderr.String(), want)
+		}
+	}
+}
+
+func TestFailureMatcher_ConcurrentWriters(t *testing.T) {
+	t.Parallel()
+
+	matcher := &failureMatcher{classes: builtinRetryClasses}
+	var wg sync.WaitGroup
+	for _, line := range []string{"compiling\n", "curl: (7) Connection refused\n"} {
+		wg.Add(1)
+		go func(line string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := io.WriteString(matcher, line); err != nil {
+					t.Errorf("Write(): %v", err)
+					return
+				}
+			}
+		}(line)
+	}
+	wg.Wait()
+	matcher.flush()
+	if got, want := matcher.Class, "network"; got != want {
+		t.Fatalf("failureMatcher.Class: got %q want %q", got, want)
+	}
+}
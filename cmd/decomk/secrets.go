@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/resolve"
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	// secretValuePrefix and secretValueSuffix wrap an encrypted tuple value:
+	// NAME=ENC[age:<base64 of binary age ciphertext>].
+	secretValuePrefix = "ENC[age:"
+	secretValueSuffix = "]"
+
+	// secretRedacted replaces decrypted values in human and editor output.
+	secretRedacted = "<redacted>"
+)
+
+// resolveAgeIdentity returns the age identity (key) file used to decrypt
+// ENC[age:...] values.
+//
+// Precedence: flagOverride (if non-empty), then DECOMK_AGE_IDENTITY, then
+// SOPS_AGE_KEY_FILE, so a key already set up for sops works unchanged.
+func resolveAgeIdentity(flagOverride string) string {
+	for _, candidate := range []string{flagOverride, os.Getenv("DECOMK_AGE_IDENTITY"), os.Getenv("SOPS_AGE_KEY_FILE")} {
+		if candidate != "" {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// isSecretValue reports whether a tuple value is ENC[age:...] ciphertext.
+func isSecretValue(value string) bool {
+	return strings.HasPrefix(value, secretValuePrefix) && strings.HasSuffix(value, secretValueSuffix)
+}
+
+// decryptSecretTuples decrypts every ENC[age:...] tuple value with identity.
+//
+// It returns the tuples with plaintext values, plus the ciphertext of each
+// decrypted name (so profiles can store it instead of the plaintext). An
+// encrypted value with no identity configured is an error.
+//
+// Intent: Keep secrets in the config repo as ciphertext and hold plaintext
+// only in memory and the 0600 secrets env file.
+// Source: DI-karaj (TODO-jirin)
+func decryptSecretTuples(tuples []string, identity string) ([]string, map[string]string, error) {
+	out := make([]string, 0, len(tuples))
+	var secrets map[string]string
+	for _, tuple := range tuples {
+		name, value, ok := resolve.SplitTuple(tuple)
+		if !ok || !isSecretValue(value) {
+			out = append(out, tuple)
+			continue
+		}
+		if identity == "" {
+			return nil, nil, fmt.Errorf("%s is encrypted but no age identity is set; use -age-identity, DECOMK_AGE_IDENTITY, or SOPS_AGE_KEY_FILE", name)
+		}
+		plaintext, err := ageDecrypt(strings.TrimSuffix(strings.TrimPrefix(value, secretValuePrefix), secretValueSuffix), identity)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decrypt %s: %w", name, err)
+		}
+		if secrets == nil {
+			secrets = make(map[string]string)
+		}
+		secrets[name] = value
+		out = append(out, name+"="+plaintext)
+	}
+	return out, secrets, nil
+}
+
+// ageDecrypt decodes payload and decrypts it with the age CLI.
+//
+// Trailing newlines are trimmed, so `echo value | age -e ...` round-trips.
+func ageDecrypt(payload, identity string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("decode ciphertext: %w", err)
+	}
+	cmd := exec.Command("age", "--decrypt", "--identity", identity)
+	cmd.Stdin = bytes.NewReader(ciphertext)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	plaintext, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("age --decrypt: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("age --decrypt: %w", err)
+	}
+	return strings.TrimRight(string(plaintext), "\n"), nil
+}
+
+// markSecretsEnvOnly returns classes with every secret name classed envonly,
+// so decrypted values never reach make's argv or the logged make command.
+func markSecretsEnvOnly(classes map[string]string, secrets map[string]string) map[string]string {
+	if len(secrets) == 0 {
+		return classes
+	}
+	out := make(map[string]string, len(classes)+len(secrets))
+	for name, class := range classes {
+		out[name] = class
+	}
+	for name := range secrets {
+		out[name] = resolve.ClassEnvOnly
+	}
+	return out
+}
+
+// redactSecretTuples returns tuples with secret values replaced by
+// secretRedacted.
+func redactSecretTuples(tuples []string, secrets map[string]string) []string {
+	if len(secrets) == 0 {
+		return tuples
+	}
+	out := make([]string, 0, len(tuples))
+	for _, tuple := range tuples {
+		if name, _, ok := resolve.SplitTuple(tuple); ok {
+			if _, secret := secrets[name]; secret {
+				tuple = name + "=" + secretRedacted
+			}
+		}
+		out = append(out, tuple)
+	}
+	return out
+}
+
+// encryptedSecretTuples returns tuples with secret values restored to their
+// ENC[age:...] ciphertext, for storage.
+func encryptedSecretTuples(tuples []string, secrets map[string]string) []string {
+	if len(secrets) == 0 {
+		return tuples
+	}
+	out := make([]string, 0, len(tuples))
+	for _, tuple := range tuples {
+		if name, _, ok := resolve.SplitTuple(tuple); ok {
+			if ciphertext, secret := secrets[name]; secret {
+				tuple = name + "=" + ciphertext
+			}
+		}
+		out = append(out, tuple)
+	}
+	return out
+}
+
+// writeSecretsEnvFile writes the decrypted secrets from cookedTuples as export
+// lines to <DECOMK_HOME>/secrets.env with mode 0600, or removes a stale file
+// when plan has no secrets.
+func writeSecretsEnvFile(plan *resolvedPlan, cookedTuples []string) error {
+	path := state.SecretsEnvFile(plan.Home)
+	if len(plan.Secrets) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale secrets env file: %w", err)
+		}
+		return nil
+	}
+	values := effectiveTupleValues(cookedTuples)
+	names := make([]string, 0, len(plan.Secrets))
+	for name := range plan.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	b.WriteString("# generated by decomk from encrypted decomk.conf values; do not edit\n")
+	for _, name := range names {
+		if err := writeExport(&b, name, values[name]); err != nil {
+			return err
+		}
+	}
+	if err := state.AtomicWrite(path, b.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("write secrets env file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// installFakeAge puts an `age` on PATH that checks its identity file and
+// "decrypts" by copying stdin, so ciphertext in tests is just base64 plaintext.
+func installFakeAge(t *testing.T) string {
+	t.Helper()
+	binDir := t.TempDir()
+	script := `#!/usr/bin/env bash
+set -euo pipefail
+if [[ "${1:-}" != "--decrypt" || "${2:-}" != "--identity" || ! -f "${3:-}" ]]; then
+  echo "age: bad invocation: $*" >&2
+  exit 1
+fi
+cat
+`
+	if err := os.WriteFile(filepath.Join(binDir, "age"), []byte(script), 0o755); err != nil {
+		t.Fatalf("WriteFile(fake age): %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	identity := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(identity, []byte("AGE-SECRET-KEY-FAKE\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(identity): %v", err)
+	}
+	return identity
+}
+
+func fakeSecretValue(plaintext string) string {
+	return secretValuePrefix + base64.StdEncoding.EncodeToString([]byte(plaintext+"\n")) + secretValueSuffix
+}
+
+func TestDecryptSecretTuples(t *testing.T) {
+	identity := installFakeAge(t)
+
+	ciphertext := fakeSecretValue("s3cret")
+	got, secrets, err := decryptSecretTuples([]string{"A=1", "TOKEN=" + ciphertext}, identity)
+	if err != nil {
+		t.Fatalf("decryptSecretTuples() error: %v", err)
+	}
+	if strings.Join(got, "|") != "A=1|TOKEN=s3cret" || secrets["TOKEN"] != ciphertext || len(secrets) != 1 {
+		t.Fatalf("decryptSecretTuples(): got %q, %q", got, secrets)
+	}
+	if got := encryptedSecretTuples(got, secrets); got[1] != "TOKEN="+ciphertext {
+		t.Fatalf("encryptedSecretTuples(): got %q", got)
+	}
+	if got := redactSecretTuples([]string{"A=1", "TOKEN=s3cret"}, secrets); got[1] != "TOKEN="+secretRedacted {
+		t.Fatalf("redactSecretTuples(): got %q", got)
+	}
+
+	if _, _, err := decryptSecretTuples([]string{"TOKEN=" + ciphertext}, ""); err == nil || !strings.Contains(err.Error(), "no age identity is set") {
+		t.Fatalf("decryptSecretTuples(no identity) error: got %v", err)
+	}
+	if _, _, err := decryptSecretTuples([]string{"TOKEN=ENC[age:!!]"}, identity); err == nil || !strings.Contains(err.Error(), "decrypt TOKEN: decode ciphertext") {
+		t.Fatalf("decryptSecretTuples(bad base64) error: got %v", err)
+	}
+	if _, _, err := decryptSecretTuples([]string{"TOKEN=" + ciphertext}, filepath.Join(t.TempDir(), "missing")); err == nil || !strings.Contains(err.Error(), "age: bad invocation") {
+		t.Fatalf("decryptSecretTuples(missing identity) error: got %v", err)
+	}
+}
+
+func TestCmdPlan_SecretTuplesStayOutOfOutput(t *testing.T) {
+	identity := installFakeAge(t)
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	files := map[string]string{
+		configPath:                         "DEFAULT: FOO=bar TOKEN=" + fakeSecretValue("s3cret") + "\n",
+		filepath.Join(confDir, "Makefile"): "all:\n\t@echo token-set\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	home := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code, err := cmdPlan([]string{"-home", home, "-workspaces", t.TempDir(), "-config", configPath, "-age-identity", identity, "all"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	outText := stdout.String()
+	if strings.Contains(outText, "s3cret") {
+		t.Fatalf("plan output leaked the secret:\n%s", outText)
+	}
+	for _, needle := range []string{
+		"  TOKEN=" + secretRedacted + "  (secret)\n",
+		"# secrets: " + state.SecretsEnvFile(home) + " (mode 0600; source it separately)\n",
+	} {
+		if !strings.Contains(outText, needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, outText)
+		}
+	}
+
+	_, err = cmdPlan([]string{"-home", home, "-workspaces", t.TempDir(), "-config", configPath, "all"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "TOKEN is encrypted but no age identity is set") {
+		t.Fatalf("cmdPlan(no identity) error: got %v", err)
+	}
+}
+
+func TestWriteSecretsEnvFile(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	plan := &resolvedPlan{Home: home, Secrets: map[string]string{"TOKEN": "ENC[age:x]"}}
+	if err := writeSecretsEnvFile(plan, []string{"FOO=bar", "TOKEN=it's"}); err != nil {
+		t.Fatalf("writeSecretsEnvFile() error: %v", err)
+	}
+	path := state.SecretsEnvFile(home)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat(secrets.env): %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("secrets.env mode: got %v want 0600", info.Mode().Perm())
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(secrets.env): %v", err)
+	}
+	if want := "export TOKEN='it'\"'\"'s'\n"; !strings.HasSuffix(string(content), want) || strings.Contains(string(content), "FOO") {
+		t.Fatalf("secrets.env: got %q want suffix %q", content, want)
+	}
+
+	plan.Secrets = nil
+	if err := writeSecretsEnvFile(plan, nil); err != nil {
+		t.Fatalf("writeSecretsEnvFile(no secrets) error: %v", err)
+	}
+	if fileExists(path) {
+		t.Fatalf("stale secrets.env was not removed")
+	}
+}
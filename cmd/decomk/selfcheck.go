@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime/debug"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/expand"
+	"github.com/stevegt/decomk/resolve"
+	"github.com/stevegt/decomk/toolchain"
+)
+
+// selfcheckConfig is a canned decomk.conf that exercises every config token
+// kind: macros, quoted tuples, toolchain tokens, and inline recipes.
+const selfcheckConfig = `# decomk --selfcheck canned config
+DEFAULT: BASE INSTALL='selfcheck-a selfcheck-b'
+BASE: FOO=bar
+  mise:python@3.12
+recipe selfcheck-a: echo selfcheck && touch $@
+`
+
+// cmdSelfcheck verifies that this binary can parse, expand, and partition a
+// canned config, then prints its version and commit.
+//
+// It is hidden from usage: stage-0 runs it against a freshly built binary
+// before promoting it over the installed one.
+//
+// Intent: Catch broken tool builds before they replace a working binary, so
+// one bad commit cannot brick every container that updates decomk.
+// Source: DI-dahup (TODO-jirin)
+func cmdSelfcheck(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk --selfcheck", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("--selfcheck does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if err := runSelfcheck(); err != nil {
+		return 1, fmt.Errorf("selfcheck failed: %w", err)
+	}
+	if err := writeFormat(stdout, "decomk selfcheck ok: version=%s commit=%s\n", decomkVersion, buildCommit()); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// runSelfcheck runs the canned config through the same parse/expand/partition
+// path plan and run use, and checks the results.
+func runSelfcheck() error {
+	cfg, err := contexts.Parse(strings.NewReader(selfcheckConfig))
+	if err != nil {
+		return fmt.Errorf("parse canned config: %w", err)
+	}
+	if err := contexts.ValidateRefs(cfg.Defs); err != nil {
+		return fmt.Errorf("validate canned config: %w", err)
+	}
+	expanded, err := expand.ExpandTokens(expand.Defs(cfg.Defs), []string{"DEFAULT"}, expand.Options{})
+	if err != nil {
+		return fmt.Errorf("expand canned config: %w", err)
+	}
+	toolchains, rest, err := toolchain.Extract(expanded)
+	if err != nil {
+		return fmt.Errorf("extract toolchains: %w", err)
+	}
+	tuples, bare := resolve.Partition(rest)
+	if len(bare) > 0 {
+		return fmt.Errorf("unexpected bare tokens %v", bare)
+	}
+
+	want := []string{"FOO=bar", "INSTALL=selfcheck-a selfcheck-b"}
+	if !reflect.DeepEqual(tuples, want) {
+		return fmt.Errorf("tuples: got %q want %q", tuples, want)
+	}
+	targets := targetsFromActionArgs([]string{"INSTALL"}, effectiveTupleValues(tuples))
+	if got, want := strings.Join(targets, " "), "selfcheck-a selfcheck-b"; got != want {
+		return fmt.Errorf("targets: got %q want %q", got, want)
+	}
+	if len(toolchains) != 1 || toolchains[0].Target() != "mise-python-3.12" {
+		return fmt.Errorf("toolchains: got %#v", toolchains)
+	}
+	if got, want := cfg.Recipes["selfcheck-a"], "echo selfcheck && touch $@"; got != want {
+		return fmt.Errorf("recipe: got %q want %q", got, want)
+	}
+	return nil
+}
+
+// buildCommit returns the VCS revision stamped into the binary, or "unknown"
+// (for example for `go install module@version` builds, which carry no VCS
+// metadata).
+func buildCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	revision := ""
+	modified := false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "unknown"
+	}
+	if modified {
+		return revision + "+dirty"
+	}
+	return revision
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun_Selfcheck(t *testing.T) {
+	t.Parallel()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	if code := run([]string{"decomk", "--selfcheck"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("run(--selfcheck) code: got %d want 0 (stderr=%q)", code, stderr.String())
+	}
+	if got, want := stdout.String(), "decomk selfcheck ok: version="+decomkVersion+" commit="; !strings.HasPrefix(got, want) {
+		t.Fatalf("run(--selfcheck) stdout: got %q want prefix %q", got, want)
+	}
+	if strings.Contains(usage(), "selfcheck") {
+		t.Fatalf("usage() exposes hidden selfcheck:\n%s", usage())
+	}
+}
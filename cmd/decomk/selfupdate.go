@@ -0,0 +1,797 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/stage0"
+	"github.com/stevegt/decomk/state"
+	"github.com/stevegt/envi"
+)
+
+const (
+	selfUpdateSubcommandList     = "list"
+	selfUpdateSubcommandRollback = "rollback"
+
+	// toolArchivePrefix is the file-name prefix stage-0 uses for archived
+	// binaries; each has a matching "<name>.info" metadata file.
+	toolArchivePrefix = "decomk-"
+
+	// selfcheckOKPrefix starts the line `decomk --selfcheck` prints on success.
+	selfcheckOKPrefix = "decomk selfcheck ok: "
+
+	// defaultToolArchiveKeep matches stage-0's DECOMK_TOOL_ARCHIVE_KEEP default.
+	defaultToolArchiveKeep = 5
+)
+
+// toolArchiveEntry is one archived tool binary and its recorded metadata.
+type toolArchiveEntry struct {
+	Name    string
+	Path    string
+	Version string
+	Commit  string
+	Source  string
+	SHA256  string
+}
+
+// toolUpdateOptions selects what updateTool builds and which binary it replaces.
+type toolUpdateOptions struct {
+	Home    string
+	ToolURI string
+	// ToolRef, when set, replaces the version/ref in ToolURI. When empty,
+	// updateTool uses the config repo's DECOMK_TOOL_REF pin, if any.
+	ToolRef   string
+	Target    string
+	CheckOnly bool
+	// RequireSigned refuses to build a git tool checkout that is not signed
+	// by a key in the allowed-signers file (see verifyToolSignature).
+	RequireSigned bool
+}
+
+// toolUpdateResult reports what updateTool found and did.
+type toolUpdateResult struct {
+	// Available reports whether the freshly built binary differs from Target.
+	Available bool
+	// Applied reports whether Target was replaced.
+	Applied bool
+	// Selfcheck is the candidate's `--selfcheck` line (version and commit).
+	Selfcheck string
+}
+
+// cmdSelfUpdate dispatches `decomk self-update` subcommands. Without a
+// subcommand it updates the installed binary from DECOMK_TOOL_URI.
+//
+// Intent: Let operators inspect the stage-0 binary archive and restore a
+// last-known-good build after a bad tool update with one command.
+// Source: DI-mozaf (TODO-jirin)
+func cmdSelfUpdate(args []string, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelpArg(args[0])) {
+		return cmdSelfUpdateApply(args, stdout, stderr)
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		if err := writeLine(stdout, selfUpdateUsage()); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	case selfUpdateSubcommandList:
+		return cmdSelfUpdateList(args[1:], stdout, stderr)
+	case selfUpdateSubcommandRollback:
+		return cmdSelfUpdateRollback(args[1:], stdout, stderr)
+	default:
+		return 2, fmt.Errorf("unknown self-update subcommand: %s\n\n%s", args[0], selfUpdateUsage())
+	}
+}
+
+func isHelpArg(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
+
+func selfUpdateUsage() string {
+	return `decomk self-update - update and manage installed decomk tool binaries
+
+Usage:
+  decomk self-update [-home <dir>] [-tool-uri <uri>] [-tool-ref <ref>] [-target <path>] [-check] [-require-signed-tool]
+  decomk self-update list [-home <dir>]
+  decomk self-update rollback [-home <dir>] [-target <path>]
+
+Without a subcommand, rebuild decomk from the tool URI (-tool-uri, else
+DECOMK_TOOL_URI, else the stage-0 default) into
+<DECOMK_HOME>/stage0/tool-staging, require it to pass --selfcheck, archive it,
+and replace the installed binary (default: the running executable). With
+-check, only report whether the rebuilt binary differs from the installed one.
+A DECOMK_TOOL_REF pin in decomk.conf (or -tool-ref) replaces the version or
+ref in the tool URI. With -require-signed-tool (or DECOMK_REQUIRE_SIGNED_TOOL),
+refuse to build unless the git tool checkout, or the tag it was fetched by, is
+SSH-signed by a key in <DECOMK_HOME>/decomk/allowed_signers (or
+DECOMK_TOOL_ALLOWED_SIGNERS).
+Builds of a git checkout or an exact go: version are cached per commit and
+platform under <DECOMK_HOME>/decomk/bin/cache and reused instead of rebuilt.
+
+Subcommands:
+  list
+      List binaries archived by stage-0 under <DECOMK_HOME>/decomk/bin/archive,
+      newest first, with the version and commit each was built from.
+  rollback
+      Replace the installed binary (default: the running executable) with the
+      archived binary preceding it. Repeated rollbacks step further back.
+`
+}
+
+// cmdSelfUpdateApply rebuilds the tool and replaces the installed binary when
+// the rebuilt one differs.
+//
+// Intent: Make tool updates an explicit operator action with a dry -check
+// mode, instead of only a side effect of container lifecycle hooks.
+// Source: DI-bovit (TODO-jirin)
+func cmdSelfUpdateApply(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk self-update", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts toolUpdateOptions
+	var homeFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	fs.StringVar(&opts.ToolURI, "tool-uri", "", "tool source URI, go:... or git:... (overrides DECOMK_TOOL_URI)")
+	fs.StringVar(&opts.ToolRef, "tool-ref", "", "module version or git ref to build (overrides the config repo's DECOMK_TOOL_REF pin)")
+	fs.StringVar(&opts.Target, "target", "", "installed binary to replace (default: the running executable)")
+	fs.BoolVar(&opts.CheckOnly, "check", false, "report whether an update is available without applying it")
+	fs.BoolVar(&opts.RequireSigned, "require-signed-tool", false, "refuse tool commits not signed by a key in the allowed signers file (also DECOMK_REQUIRE_SIGNED_TOOL)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("unknown self-update subcommand: %s\n\n%s", fs.Arg(0), selfUpdateUsage())
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	opts.Home = home
+
+	result, err := updateTool(opts, stderr)
+	if err != nil {
+		return 1, err
+	}
+	if err := reportToolUpdate(stdout, opts, result); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// reportToolUpdate prints one summary line for a finished updateTool call.
+func reportToolUpdate(w io.Writer, opts toolUpdateOptions, result toolUpdateResult) error {
+	built := strings.TrimPrefix(result.Selfcheck, selfcheckOKPrefix)
+	switch {
+	case result.Applied:
+		return writeFormat(w, "updated %s (%s)\n", opts.Target, built)
+	case result.Available:
+		return writeFormat(w, "update available for %s (%s)\n", opts.Target, built)
+	default:
+		return writeFormat(w, "%s is up to date (%s)\n", opts.Target, built)
+	}
+}
+
+// updateTool builds decomk from opts.ToolURI into the staging dir, gates it on
+// --selfcheck, and, unless opts.CheckOnly is set, archives it and replaces
+// opts.Target when it differs. Empty ToolURI and Target fields are filled in
+// from DECOMK_TOOL_URI and the running executable.
+//
+// This mirrors stage-0's install_decomk/promote_decomk/archive_decomk so both
+// paths leave the same staging and archive layout behind.
+func updateTool(opts toolUpdateOptions, buildOutput io.Writer) (result toolUpdateResult, err error) {
+	if opts.ToolURI == "" {
+		opts.ToolURI = envi.String("DECOMK_TOOL_URI", stage0.DefaultToolURI)
+	}
+	// Intent: Build the tool version the config repo was written for, so
+	// config and tool versions stay compatible across the fleet.
+	// Source: DI-dalos (TODO-jirin)
+	if opts.ToolRef == "" {
+		if opts.ToolRef, err = configToolRef(opts.Home); err != nil {
+			return result, err
+		}
+	}
+	if opts.ToolRef != "" {
+		if opts.ToolURI, err = pinToolURI(opts.ToolURI, opts.ToolRef); err != nil {
+			return result, err
+		}
+	}
+	if opts.Target == "" {
+		if opts.Target, err = runningExecutable(); err != nil {
+			return result, err
+		}
+	}
+	signers := ""
+	if opts.RequireSigned || os.Getenv(requireSignedToolEnv) != "" {
+		if !strings.HasPrefix(opts.ToolURI, "git:") {
+			return result, fmt.Errorf("-require-signed-tool needs a git: tool URI (signatures cannot be checked for %s)", opts.ToolURI)
+		}
+		signers = toolAllowedSigners(opts.Home)
+	}
+	keep, err := toolArchiveKeep()
+	if err != nil {
+		return result, err
+	}
+
+	if err := state.EnsureDir(opts.Home); err != nil {
+		return result, err
+	}
+	lock, err := state.LockFile(state.ToolLockPath(opts.Home))
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if closeErr := lock.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}()
+
+	stageDir := state.ToolStagingDir(opts.Home)
+	if err := os.RemoveAll(stageDir); err != nil {
+		return result, fmt.Errorf("reset %s: %w", stageDir, err)
+	}
+	if err := state.EnsureDir(stageDir); err != nil {
+		return result, err
+	}
+	cacheEntry, cached, err := buildTool(opts.Home, opts.ToolURI, stageDir, signers, buildOutput)
+	if err != nil {
+		return result, err
+	}
+
+	candidate := filepath.Join(stageDir, "decomk")
+	out, err := exec.Command(candidate, "--selfcheck").Output()
+	if err != nil {
+		err = fmt.Errorf("new binary %s failed --selfcheck; keeping %s: %w", candidate, opts.Target, err)
+		if cached {
+			// Drop the bad build so the next update rebuilds it.
+			if rmErr := os.Remove(cacheEntry); rmErr != nil && !os.IsNotExist(rmErr) {
+				err = errors.Join(err, fmt.Errorf("remove cached tool build: %w", rmErr))
+			}
+		}
+		return result, err
+	}
+	result.Selfcheck = strings.TrimSpace(string(out))
+	if cacheEntry != "" && !cached {
+		if err := cacheToolBuild(cacheEntry, candidate); err != nil {
+			return result, err
+		}
+	}
+
+	candidateDigest, err := fileSHA256(candidate)
+	if err != nil {
+		return result, err
+	}
+	targetDigest := ""
+	if fileExists(opts.Target) {
+		if targetDigest, err = fileSHA256(opts.Target); err != nil {
+			return result, err
+		}
+	}
+	result.Available = candidateDigest != targetDigest
+	if !result.Available || opts.CheckOnly {
+		return result, nil
+	}
+
+	if err := archiveToolBinary(state.ToolArchiveDir(opts.Home), candidate, candidateDigest, result.Selfcheck, opts.ToolURI, keep, time.Now()); err != nil {
+		return result, err
+	}
+	if err := replaceExecutable(opts.Target, candidate); err != nil {
+		return result, err
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// configToolRef returns the DECOMK_TOOL_REF pin from the config decomk plan
+// and run would load (config repo, then DECOMK_CONFIG), or "" when there is
+// no config or no pin.
+func configToolRef(home string) (string, error) {
+	confDir, err := resolveConfDir(home, "")
+	if err != nil {
+		return "", err
+	}
+	explicitConfig := os.Getenv("DECOMK_CONFIG")
+	if _, ok := configRepoConfigPath(confDir); !ok && explicitConfig == "" {
+		return "", nil
+	}
+	cfg, err := loadDefs(confDir, explicitConfig)
+	if err != nil {
+		return "", fmt.Errorf("read %s pin: %w", contexts.ToolRefKey, err)
+	}
+	return contexts.ToolRef(cfg.Defs)
+}
+
+// pinToolURI replaces the version in a go:module@version URI, or the ref in a
+// git:url?ref=... URI, with ref.
+func pinToolURI(uri, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(uri, "go:"):
+		spec := strings.TrimPrefix(uri, "go:")
+		if i := strings.LastIndexByte(spec, '@'); i >= 0 {
+			spec = spec[:i]
+		}
+		return "go:" + spec + "@" + ref, nil
+	case strings.HasPrefix(uri, "git:"):
+		repoURL, _, err := parseToolGitURI(uri)
+		if err != nil {
+			return "", err
+		}
+		return "git:" + repoURL + "?ref=" + ref, nil
+	default:
+		return "", fmt.Errorf("invalid tool URI %q (expected go:... or git:...)", uri)
+	}
+}
+
+// buildTool installs decomk from toolURI into stageDir, sending go/git output
+// to w. When signers is set, a git checkout must pass verifyToolSignature
+// against it before anything is built.
+//
+// A source pinned to one commit (a git checkout, or a go: URI with an exact
+// version) for the same platform is built once: cacheEntry is where that
+// build is cached, and cached reports that it was copied from there instead
+// of rebuilt. cacheEntry is "" for sources that cannot be cached.
+func buildTool(home, toolURI, stageDir, signers string, w io.Writer) (cacheEntry string, cached bool, err error) {
+	var dir, pkg string
+	switch {
+	case strings.HasPrefix(toolURI, "go:"):
+		pkg = strings.TrimPrefix(toolURI, "go:")
+		if pkg == "" {
+			return "", false, fmt.Errorf("go source URI must include module@version after go:")
+		}
+		cacheEntry = toolBuildCachePath(home, goToolCacheKey(pkg))
+	case strings.HasPrefix(toolURI, "git:"):
+		repoURL, ref, err := parseToolGitURI(toolURI)
+		if err != nil {
+			return "", false, err
+		}
+		if repoURL, err = resolveGitSource(home, repoURL, w); err != nil {
+			return "", false, err
+		}
+		dir, pkg = state.ToolSrcDir(home), "./cmd/decomk"
+		if err := syncToolRepo(w, repoURL, dir, ref); err != nil {
+			return "", false, err
+		}
+		if signers != "" {
+			if err := verifyToolSignature(dir, ref, signers); err != nil {
+				return "", false, fmt.Errorf("refusing to build unsigned tool update: %w", err)
+			}
+		}
+		head, err := gitOutput(dir, "rev-parse", "--verify", "HEAD")
+		if err != nil {
+			return "", false, fmt.Errorf("resolve tool HEAD: %w", err)
+		}
+		cacheEntry = toolBuildCachePath(home, head)
+	default:
+		return "", false, fmt.Errorf("invalid tool URI %q (expected go:... or git:...)", toolURI)
+	}
+	if cached, err = restoreCachedTool(cacheEntry, stageDir); err != nil || cached {
+		return cacheEntry, cached, err
+	}
+	env := append(toolBuildEnv(home), "GOBIN="+stageDir)
+	return cacheEntry, false, runToolCommand(w, dir, env, "go", "install", pkg)
+}
+
+// parseToolGitURI splits git:<repo-url>[?ref=<git-ref>] the way stage-0's
+// parse_git_uri does.
+func parseToolGitURI(uri string) (repoURL, ref string, err error) {
+	repoURL = strings.TrimPrefix(uri, "git:")
+	query := ""
+	if i := strings.IndexByte(repoURL, '?'); i >= 0 {
+		repoURL, query = repoURL[:i], repoURL[i+1:]
+	}
+	if repoURL == "" {
+		return "", "", fmt.Errorf("git source URI is missing repository URL: %s", uri)
+	}
+	for _, pair := range strings.Split(query, "&") {
+		if key, value, ok := strings.Cut(pair, "="); ok && key == "ref" {
+			ref = value
+		}
+	}
+	return repoURL, ref, nil
+}
+
+// syncToolRepo clones or fast-forwards the tool source clone, then checks out
+// ref when one is given.
+func syncToolRepo(w io.Writer, repoURL, dir, ref string) error {
+	if dirExists(filepath.Join(dir, ".git")) {
+		status, err := exec.Command("git", "-C", dir, "status", "--porcelain", "--untracked-files=normal").Output()
+		if err != nil {
+			return fmt.Errorf("git status in %s: %w", dir, err)
+		}
+		if len(bytes.TrimSpace(status)) > 0 {
+			return fmt.Errorf("git repo has uncommitted changes: %s", dir)
+		}
+		if err := runToolCommand(w, "", nil, "git", "-C", dir, "remote", "set-url", "origin", repoURL); err != nil {
+			return err
+		}
+		if ref == "" {
+			return runToolCommand(w, "", nil, "git", "-C", dir, "pull", "--ff-only")
+		}
+	} else {
+		if _, err := os.Lstat(dir); err == nil {
+			return fmt.Errorf("path exists but is not a git repo: %s", dir)
+		}
+		if err := state.EnsureParentDir(dir); err != nil {
+			return err
+		}
+		if err := runToolCommand(w, "", nil, "git", "clone", repoURL, dir); err != nil {
+			return err
+		}
+		if ref == "" {
+			return nil
+		}
+	}
+	// Fetching the ref by name covers branches, tags, and commit ids alike.
+	if err := runToolCommand(w, "", nil, "git", "-C", dir, "fetch", "--prune", "origin", ref); err != nil {
+		return err
+	}
+	return runToolCommand(w, "", nil, "git", "-C", dir, "checkout", "--detach", "FETCH_HEAD")
+}
+
+// runToolCommand runs one build step with its output sent to w.
+func runToolCommand(w io.Writer, dir string, env []string, name string, args ...string) error {
+	if name == "git" {
+		env = append(gitProxyEnvList(envMapFromList(os.Environ())), env...)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// toolArchiveKeep reads DECOMK_TOOL_ARCHIVE_KEEP with stage-0's default and
+// validation.
+func toolArchiveKeep() (int, error) {
+	raw := envi.String("DECOMK_TOOL_ARCHIVE_KEEP", strconv.Itoa(defaultToolArchiveKeep))
+	keep, err := strconv.Atoi(raw)
+	if err != nil || keep < 0 {
+		return 0, fmt.Errorf("invalid DECOMK_TOOL_ARCHIVE_KEEP=%s (expected a non-negative integer)", raw)
+	}
+	return keep, nil
+}
+
+// archiveToolBinary copies binary into the tool archive with an .info file,
+// skipping the copy when the newest entry already has the same digest, then
+// prunes the archive to keep entries. keep == 0 disables archiving.
+func archiveToolBinary(dir, binary, digest, selfcheckOut, source string, keep int, now time.Time) error {
+	if keep == 0 {
+		return nil
+	}
+	if err := state.EnsureDir(dir); err != nil {
+		return err
+	}
+	entries, err := listToolArchive(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 || entries[0].SHA256 != digest {
+		content, err := os.ReadFile(binary)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", binary, err)
+		}
+		name := toolArchivePrefix + now.UTC().Format("20060102T150405Z") + "-" + strconv.Itoa(os.Getpid())
+		if err := state.AtomicWrite(filepath.Join(dir, name), content, 0o755); err != nil {
+			return fmt.Errorf("archive %s: %w", binary, err)
+		}
+		var info strings.Builder
+		for _, field := range strings.Fields(strings.TrimPrefix(selfcheckOut, selfcheckOKPrefix)) {
+			info.WriteString(field + "\n")
+		}
+		info.WriteString("source=" + source + "\n")
+		info.WriteString("sha256=" + digest + "\n")
+		if err := state.AtomicWrite(filepath.Join(dir, name+".info"), []byte(info.String()), 0o644); err != nil {
+			return fmt.Errorf("write archive info for %s: %w", name, err)
+		}
+		if entries, err = listToolArchive(dir); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries[min(keep, len(entries)):] {
+		for _, path := range []string{entry.Path, entry.Path + ".info"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("prune tool archive: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// autoUpdateAndReexec updates the running binary and, when it changed, execs
+// the new binary with the same arguments minus -auto-update. It returns only
+// when no update was applied or on error.
+//
+// Intent: Keep plan/run predictable by default and re-exec into a freshly
+// updated binary only when the operator opts in with -auto-update.
+// Source: DI-bovit (TODO-jirin)
+func autoUpdateAndReexec(home string, stdout, stderr io.Writer) error {
+	target, err := runningExecutable()
+	if err != nil {
+		return err
+	}
+	opts := toolUpdateOptions{Home: home, Target: target}
+	result, err := updateTool(opts, stderr)
+	if err != nil {
+		return fmt.Errorf("auto-update: %w", err)
+	}
+	if !result.Applied {
+		return nil
+	}
+	if err := reportToolUpdate(stdout, opts, result); err != nil {
+		return err
+	}
+	argv := append([]string{target}, stripAutoUpdateFlag(os.Args[1:])...)
+	// decomk hook adds -auto-update itself, so stripping argv is not enough.
+	env := append(os.Environ(), autoUpdatedEnv+"=1")
+	if err := syscall.Exec(target, argv, env); err != nil {
+		return fmt.Errorf("re-exec %s: %w", target, err)
+	}
+	return nil
+}
+
+// stripAutoUpdateFlag drops -auto-update (in any flag spelling) from args up to
+// a "--" terminator, so a re-exec'd binary never updates twice.
+func stripAutoUpdateFlag(args []string) []string {
+	var out []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		if strings.HasPrefix(arg, "-") && name == "auto-update" {
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// runningExecutable returns the resolved path of the running decomk binary.
+func runningExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("resolve running executable: %w", err)
+	}
+	path, err := filepath.EvalSymlinks(exe)
+	if err != nil {
+		return "", fmt.Errorf("resolve running executable %s: %w", exe, err)
+	}
+	return path, nil
+}
+
+// cmdSelfUpdateList prints the tool archive, newest first.
+func cmdSelfUpdateList(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk self-update list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("self-update list does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	entries, err := listToolArchive(state.ToolArchiveDir(home))
+	if err != nil {
+		return 1, err
+	}
+	for _, entry := range entries {
+		if err := writeFormat(stdout, "%s version=%s commit=%s source=%s\n", entry.Name, entry.Version, entry.Commit, entry.Source); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// cmdSelfUpdateRollback restores the archived binary preceding the installed
+// one.
+func cmdSelfUpdateRollback(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk self-update rollback", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag, target string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	fs.StringVar(&target, "target", "", "installed binary to replace (default: the running executable)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("self-update rollback does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	if target == "" {
+		if target, err = runningExecutable(); err != nil {
+			return 1, err
+		}
+	}
+
+	entries, err := listToolArchive(state.ToolArchiveDir(home))
+	if err != nil {
+		return 1, err
+	}
+	currentDigest, err := fileSHA256(target)
+	if err != nil {
+		return 1, err
+	}
+	candidate, err := selectRollbackEntry(entries, currentDigest)
+	if err != nil {
+		return 1, err
+	}
+
+	// Re-verify before restoring: the archive copy must be intact and still
+	// pass its own selfcheck.
+	digest, err := fileSHA256(candidate.Path)
+	if err != nil {
+		return 1, err
+	}
+	if candidate.SHA256 != "" && digest != candidate.SHA256 {
+		return 1, fmt.Errorf("archived binary %s: sha256 mismatch: recorded %s, found %s", candidate.Path, candidate.SHA256, digest)
+	}
+	if out, err := exec.Command(candidate.Path, "--selfcheck").CombinedOutput(); err != nil {
+		return 1, fmt.Errorf("archived binary %s failed --selfcheck: %w: %s", candidate.Path, err, strings.TrimSpace(string(out)))
+	}
+
+	if err := replaceExecutable(target, candidate.Path); err != nil {
+		return 1, err
+	}
+	if err := writeFormat(stdout, "rolled back %s to %s (version=%s commit=%s)\n", target, candidate.Name, candidate.Version, candidate.Commit); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// listToolArchive reads archive entries from dir, newest first.
+//
+// A missing archive directory yields no entries. Binaries without an .info
+// file are skipped because their origin is unknown.
+func listToolArchive(dir string) ([]toolArchiveEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read tool archive %s: %w", dir, err)
+	}
+	var entries []toolArchiveEntry
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || !strings.HasPrefix(name, toolArchivePrefix) || filepath.Ext(name) != ".info" {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".info")
+		entry := toolArchiveEntry{Name: base, Path: filepath.Join(dir, base)}
+		if !fileExists(entry.Path) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("read tool archive info %s: %w", name, err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "version":
+				entry.Version = value
+			case "commit":
+				entry.Commit = value
+			case "source":
+				entry.Source = value
+			case "sha256":
+				entry.SHA256 = value
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read tool archive info %s: %w", name, err)
+		}
+		entries = append(entries, entry)
+	}
+	// Names embed a sortable UTC timestamp.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name > entries[j].Name })
+	return entries, nil
+}
+
+// selectRollbackEntry picks the archive entry older than the one matching the
+// current binary digest, or the newest entry when the current binary is not
+// archived (for example a manual install).
+func selectRollbackEntry(entries []toolArchiveEntry, currentDigest string) (toolArchiveEntry, error) {
+	if len(entries) == 0 {
+		return toolArchiveEntry{}, fmt.Errorf("no archived decomk binaries to roll back to")
+	}
+	for i, entry := range entries {
+		if entry.SHA256 != currentDigest {
+			continue
+		}
+		if i+1 >= len(entries) {
+			return toolArchiveEntry{}, fmt.Errorf("installed binary is the oldest archived build (%s); nothing older to roll back to", entry.Name)
+		}
+		return entries[i+1], nil
+	}
+	return entries[0], nil
+}
+
+// replaceExecutable atomically replaces target with a copy of source.
+func replaceExecutable(target, source string) (err error) {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", source, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".decomk-rollback-*")
+	if err != nil {
+		return fmt.Errorf("create temp file next to %s: %w", target, err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		if err == nil {
+			return
+		}
+		if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			err = errors.Join(err, fmt.Errorf("remove temp file %s: %w", tmpPath, removeErr))
+		}
+	}()
+	if _, err := tmp.Write(content); err != nil {
+		return errors.Join(fmt.Errorf("write %s: %w", tmpPath, err), tmp.Close())
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmpPath, err)
+	}
+	if err := os.Chmod(tmpPath, 0o755); err != nil {
+		return fmt.Errorf("chmod %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		return fmt.Errorf("replace %s: %w", target, err)
+	}
+	return nil
+}
+
+// fileSHA256 returns the lower-case hex sha256 of path's contents.
+func fileSHA256(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", path, err)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
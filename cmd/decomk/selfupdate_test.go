@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// writeArchivedTool writes one fake archived binary plus its .info file and
+// returns the binary's sha256.
+func writeArchivedTool(t *testing.T, dir, name, commit string) string {
+	t.Helper()
+
+	binary := []byte("#!/bin/sh\necho \"decomk selfcheck ok: version=v-" + commit + " commit=" + commit + "\"\n")
+	if err := os.WriteFile(filepath.Join(dir, name), binary, 0o755); err != nil {
+		t.Fatalf("WriteFile(%s): %v", name, err)
+	}
+	digest, err := fileSHA256(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("fileSHA256(%s): %v", name, err)
+	}
+	info := "version=v-" + commit + "\ncommit=" + commit + "\nsource=go:example.com/decomk@stable\nsha256=" + digest + "\n"
+	if err := os.WriteFile(filepath.Join(dir, name+".info"), []byte(info), 0o644); err != nil {
+		t.Fatalf("WriteFile(%s.info): %v", name, err)
+	}
+	return digest
+}
+
+func TestSelfUpdateRollback_StepsBackThroughArchive(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	archiveDir := state.ToolArchiveDir(home)
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll(archive): %v", err)
+	}
+	writeArchivedTool(t, archiveDir, "decomk-20261001T000000Z-1", "aaa")
+	writeArchivedTool(t, archiveDir, "decomk-20261002T000000Z-1", "bbb")
+	writeArchivedTool(t, archiveDir, "decomk-20261003T000000Z-1", "ccc")
+
+	// The installed binary is the newest archived build.
+	target := filepath.Join(t.TempDir(), "decomk")
+	newest, err := os.ReadFile(filepath.Join(archiveDir, "decomk-20261003T000000Z-1"))
+	if err != nil {
+		t.Fatalf("ReadFile(newest): %v", err)
+	}
+	if err := os.WriteFile(target, newest, 0o755); err != nil {
+		t.Fatalf("WriteFile(target): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdSelfUpdate([]string{"list", "-home", home}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("self-update list: code=%d err=%v", code, err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "decomk-20261003T000000Z-1 version=v-ccc commit=ccc") {
+		t.Fatalf("self-update list: got %q", stdout.String())
+	}
+
+	for _, wantCommit := range []string{"bbb", "aaa"} {
+		stdout.Reset()
+		code, err := cmdSelfUpdate([]string{"rollback", "-home", home, "-target", target}, &stdout, &stderr)
+		if err != nil || code != 0 {
+			t.Fatalf("self-update rollback: code=%d err=%v", code, err)
+		}
+		if !strings.Contains(stdout.String(), "commit="+wantCommit) {
+			t.Fatalf("rollback stdout: got %q want commit=%s", stdout.String(), wantCommit)
+		}
+		got, err := os.ReadFile(target)
+		if err != nil {
+			t.Fatalf("ReadFile(target): %v", err)
+		}
+		if !strings.Contains(string(got), "commit="+wantCommit) {
+			t.Fatalf("target content after rollback: got %q want commit %s", got, wantCommit)
+		}
+	}
+
+	_, err = cmdSelfUpdate([]string{"rollback", "-home", home, "-target", target}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "nothing older to roll back to") {
+		t.Fatalf("rollback past oldest: got %v", err)
+	}
+}
+
+func TestSelfUpdateRollback_RejectsTamperedArchive(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	archiveDir := state.ToolArchiveDir(home)
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll(archive): %v", err)
+	}
+	writeArchivedTool(t, archiveDir, "decomk-20261001T000000Z-1", "aaa")
+	if err := os.WriteFile(filepath.Join(archiveDir, "decomk-20261001T000000Z-1"), []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatalf("WriteFile(tampered): %v", err)
+	}
+	target := filepath.Join(t.TempDir(), "decomk")
+	if err := os.WriteFile(target, []byte("current"), 0o755); err != nil {
+		t.Fatalf("WriteFile(target): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	_, err := cmdSelfUpdate([]string{"rollback", "-home", home, "-target", target}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("rollback(tampered) error: got %v", err)
+	}
+	got, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("ReadFile(target): %v", err)
+	}
+	if string(got) != "current" {
+		t.Fatalf("target modified despite failed rollback: %q", got)
+	}
+}
+
+// installFakeGo puts a fake `go` on PATH whose `install` writes a decomk
+// script answering --selfcheck with FAKE_DECOMK_COMMIT (and exit code
+// FAKE_DECOMK_SELFCHECK_RC) into GOBIN.
+func installFakeGo(t *testing.T) {
+	t.Helper()
+
+	binDir := t.TempDir()
+	script := `#!/usr/bin/env bash
+set -euo pipefail
+if [[ "${1:-}" != "install" ]]; then
+  echo "unexpected fake go invocation: $*" >&2
+  exit 1
+fi
+cat >"$GOBIN/decomk" <<EOS
+#!/usr/bin/env bash
+echo "decomk selfcheck ok: version=v0.0.2 commit=${FAKE_DECOMK_COMMIT}"
+exit ${FAKE_DECOMK_SELFCHECK_RC:-0}
+EOS
+chmod +x "$GOBIN/decomk"
+`
+	if err := os.WriteFile(filepath.Join(binDir, "go"), []byte(script), 0o755); err != nil {
+		t.Fatalf("WriteFile(fake go): %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestSelfUpdate_CheckThenApply(t *testing.T) {
+	installFakeGo(t)
+	t.Setenv("FAKE_DECOMK_COMMIT", "newcommit")
+	t.Setenv("DECOMK_TOOL_URI", "go:example.com/decomk@stable")
+
+	home := t.TempDir()
+	target := filepath.Join(t.TempDir(), "decomk")
+	if err := os.WriteFile(target, []byte("old"), 0o755); err != nil {
+		t.Fatalf("WriteFile(target): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdSelfUpdate([]string{"-home", home, "-target", target, "-check"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("self-update -check: code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if want := "update available for " + target + " (version=v0.0.2 commit=newcommit)\n"; stdout.String() != want {
+		t.Fatalf("self-update -check stdout: got %q want %q", stdout.String(), want)
+	}
+	if got, err := os.ReadFile(target); err != nil || string(got) != "old" {
+		t.Fatalf("target after -check: got %q err=%v want unchanged", got, err)
+	}
+	if entries, err := listToolArchive(state.ToolArchiveDir(home)); err != nil || len(entries) != 0 {
+		t.Fatalf("archive after -check: got %#v err=%v want empty", entries, err)
+	}
+
+	stdout.Reset()
+	code, err = cmdSelfUpdate([]string{"-home", home, "-target", target}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("self-update: code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "updated "+target) {
+		t.Fatalf("self-update stdout: got %q", stdout.String())
+	}
+	got, err := os.ReadFile(target)
+	if err != nil || !strings.Contains(string(got), "commit=newcommit") {
+		t.Fatalf("target after update: got %q err=%v", got, err)
+	}
+	entries, err := listToolArchive(state.ToolArchiveDir(home))
+	if err != nil {
+		t.Fatalf("listToolArchive(): %v", err)
+	}
+	if len(entries) != 1 || entries[0].Version != "v0.0.2" || entries[0].Commit != "newcommit" || entries[0].Source != "go:example.com/decomk@stable" {
+		t.Fatalf("archive after update: got %#v", entries)
+	}
+
+	stdout.Reset()
+	code, err = cmdSelfUpdate([]string{"-home", home, "-target", target}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("self-update (again): code=%d err=%v", code, err)
+	}
+	if !strings.Contains(stdout.String(), "is up to date") {
+		t.Fatalf("self-update (again) stdout: got %q want up to date", stdout.String())
+	}
+}
+
+func TestSelfUpdate_SelfcheckFailureKeepsTarget(t *testing.T) {
+	installFakeGo(t)
+	t.Setenv("FAKE_DECOMK_COMMIT", "broken")
+	t.Setenv("FAKE_DECOMK_SELFCHECK_RC", "1")
+
+	home := t.TempDir()
+	target := filepath.Join(t.TempDir(), "decomk")
+	if err := os.WriteFile(target, []byte("old"), 0o755); err != nil {
+		t.Fatalf("WriteFile(target): %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	_, err := cmdSelfUpdate([]string{"-home", home, "-target", target, "-tool-uri", "go:example.com/decomk@main"}, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "failed --selfcheck") {
+		t.Fatalf("self-update(broken build) error: got %v", err)
+	}
+	if got, err := os.ReadFile(target); err != nil || string(got) != "old" {
+		t.Fatalf("target after failed update: got %q err=%v want unchanged", got, err)
+	}
+}
+
+func TestSelfUpdate_HonorsConfigToolRef(t *testing.T) {
+	installFakeGo(t)
+	t.Setenv("FAKE_DECOMK_COMMIT", "pinned")
+	t.Setenv("DECOMK_TOOL_URI", "go:example.com/decomk@stable")
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	if err := os.WriteFile(configPath, []byte("DECOMK_TOOL_REF: v0.4.2\nDEFAULT: FOO=bar\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(decomk.conf): %v", err)
+	}
+	t.Setenv("DECOMK_CONFIG", configPath)
+
+	home := t.TempDir()
+	target := filepath.Join(t.TempDir(), "decomk")
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdSelfUpdate([]string{"-home", home, "-target", target}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("self-update: code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	entries, err := listToolArchive(state.ToolArchiveDir(home))
+	if err != nil {
+		t.Fatalf("listToolArchive(): %v", err)
+	}
+	if len(entries) != 1 || entries[0].Source != "go:example.com/decomk@v0.4.2" {
+		t.Fatalf("archive source: got %#v want pinned go:example.com/decomk@v0.4.2", entries)
+	}
+}
+
+func TestPinToolURI(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{uri: "go:github.com/stevegt/decomk/cmd/decomk@stable", want: "go:github.com/stevegt/decomk/cmd/decomk@v0.4.2"},
+		{uri: "go:example.com/decomk", want: "go:example.com/decomk@v0.4.2"},
+		{uri: "git:https://example.com/decomk.git?ref=main", want: "git:https://example.com/decomk.git?ref=v0.4.2"},
+		{uri: "git:https://example.com/decomk.git", want: "git:https://example.com/decomk.git?ref=v0.4.2"},
+	}
+	for _, tt := range tests {
+		got, err := pinToolURI(tt.uri, "v0.4.2")
+		if err != nil {
+			t.Fatalf("pinToolURI(%q) error: %v", tt.uri, err)
+		}
+		if got != tt.want {
+			t.Fatalf("pinToolURI(%q): got %q want %q", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestStripAutoUpdateFlag(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{args: []string{"run", "-auto-update", "INSTALL"}, want: []string{"run", "INSTALL"}},
+		{args: []string{"plan", "--auto-update=true", "-C", "x", "INSTALL"}, want: []string{"plan", "-C", "x", "INSTALL"}},
+		{args: []string{"run", "--", "-auto-update"}, want: []string{"run", "--", "-auto-update"}},
+		{args: []string{"run", "auto-update"}, want: []string{"run", "auto-update"}},
+	}
+	for _, tt := range tests {
+		got := stripAutoUpdateFlag(tt.args)
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Fatalf("stripAutoUpdateFlag(%q): got %q want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestParseToolGitURI(t *testing.T) {
+	t.Parallel()
+
+	repoURL, ref, err := parseToolGitURI("git:https://example.com/decomk.git?ref=testing")
+	if err != nil {
+		t.Fatalf("parseToolGitURI() error: %v", err)
+	}
+	if repoURL != "https://example.com/decomk.git" || ref != "testing" {
+		t.Fatalf("parseToolGitURI(): got (%q, %q)", repoURL, ref)
+	}
+	if _, _, err := parseToolGitURI("git:?ref=x"); err == nil {
+		t.Fatalf("parseToolGitURI(missing url): expected error")
+	}
+}
+
+func TestSelfUpdate_ReusesCachedBuildForExactVersion(t *testing.T) {
+	installFakeGo(t)
+	home := t.TempDir()
+	target := filepath.Join(t.TempDir(), "decomk")
+
+	t.Setenv("FAKE_DECOMK_COMMIT", "first")
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdSelfUpdate([]string{"-home", home, "-target", target, "-tool-uri", "go:example.com/decomk@v0.4.2"}, &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("self-update: code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+
+	// A rebuild would now report commit=second; the cache still has first.
+	t.Setenv("FAKE_DECOMK_COMMIT", "second")
+	stdout.Reset()
+	if code, err := cmdSelfUpdate([]string{"-home", home, "-target", target, "-tool-uri", "go:example.com/decomk@v0.4.2", "-check"}, &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("self-update -check (cached): code=%d err=%v", code, err)
+	}
+	if want := target + " is up to date (version=v0.0.2 commit=first)\n"; stdout.String() != want {
+		t.Fatalf("self-update -check (cached) stdout: got %q want %q", stdout.String(), want)
+	}
+
+	// A moving version is rebuilt every time.
+	stdout.Reset()
+	if code, err := cmdSelfUpdate([]string{"-home", home, "-target", target, "-tool-uri", "go:example.com/decomk@stable", "-check"}, &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("self-update -check (stable): code=%d err=%v", code, err)
+	}
+	if !strings.Contains(stdout.String(), "commit=second") {
+		t.Fatalf("self-update -check (stable) stdout: got %q want a fresh build", stdout.String())
+	}
+}
+
+func TestToolBuildEnv(t *testing.T) {
+	t.Setenv("GOFLAGS", "-mod=mod")
+	t.Setenv("GOMODCACHE", "")
+
+	home := t.TempDir()
+	got := strings.Join(toolBuildEnv(home), "\n")
+	if want := "GOFLAGS=-mod=mod -trimpath\nGOMODCACHE=" + state.GoModCacheDir(home); got != want {
+		t.Fatalf("toolBuildEnv(): got %q want %q", got, want)
+	}
+
+	t.Setenv("GOFLAGS", "-trimpath")
+	t.Setenv("GOMODCACHE", "/srv/gomod")
+	if got := strings.Join(toolBuildEnv(home), "\n"); got != "GOFLAGS=-trimpath" {
+		t.Fatalf("toolBuildEnv(explicit): got %q want %q", got, "GOFLAGS=-trimpath")
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// JSON-RPC 2.0 error codes used by serve-stdio.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// rpcMaxRequestBytes bounds one request line.
+const rpcMaxRequestBytes = 4 * 1024 * 1024
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// rpcArgsParams carries CLI-style args (flags, then action args).
+type rpcArgsParams struct {
+	Args []string `json:"args"`
+}
+
+// rpcExplainParams names the tuple or context key to explain.
+type rpcExplainParams struct {
+	Args []string `json:"args"`
+	Name string   `json:"name"`
+}
+
+// rpcStatusParams mirrors the decomk healthz flags.
+type rpcStatusParams struct {
+	Home        string `json:"home"`
+	MaxAge      string `json:"maxAge"`
+	SkipPending bool   `json:"skipPending"`
+}
+
+// rpcResolveResult is a resolved plan as data.
+type rpcResolveResult struct {
+	Home           string            `json:"home"`
+	Profile        string            `json:"profile,omitempty"`
+	Workspaces     []string          `json:"workspaces,omitempty"`
+	Contexts       []string          `json:"contexts"`
+	Seeds          []contextSeed     `json:"seeds,omitempty"`
+	ConfigPaths    []string          `json:"configPaths"`
+	Makefiles      []string          `json:"makefiles"`
+	ExtraMakefiles []string          `json:"extraMakefiles,omitempty"`
+	Tuples         []string          `json:"tuples"`
+	TupleClasses   map[string]string `json:"tupleClasses,omitempty"`
+	ActionArgs     []string          `json:"actionArgs,omitempty"`
+	Targets        []string          `json:"targets,omitempty"`
+	Warnings       []string          `json:"warnings,omitempty"`
+}
+
+// rpcCommandResult is the outcome of a plan or run request.
+type rpcCommandResult struct {
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// rpcStatusResult is the healthz verdict plus the record it was based on.
+type rpcStatusResult struct {
+	Healthy bool     `json:"healthy"`
+	Status  string   `json:"status"`
+	LastRun *lastRun `json:"lastRun,omitempty"`
+	// Pending reports whether targets still need to run, when checked.
+	Pending *bool `json:"pending,omitempty"`
+	// Lock is the invocation holding the stamps lock, those queued behind
+	// it, and the runs in progress, when any.
+	Lock *stampsLockStatus `json:"lock,omitempty"`
+}
+
+// cmdServeStdio serves JSON-RPC 2.0 requests, one per line on stdin, until
+// stdin closes. Responses are written one per line to stdout.
+//
+// Intent: Let editor extensions drive decomk as a long-lived child process
+// with structured responses instead of scraping CLI text.
+// Source: DI-gozub (TODO-jirin)
+func cmdServeStdio(args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk serve-stdio", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("serve-stdio does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), rpcMaxRequestBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		response, ok := handleRPCLine(line)
+		if !ok {
+			continue
+		}
+		encoded, err := json.Marshal(response)
+		if err != nil {
+			return 1, fmt.Errorf("encode response: %w", err)
+		}
+		if err := writeLine(stdout, string(encoded)); err != nil {
+			return 1, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 1, fmt.Errorf("read requests: %w", err)
+	}
+	return 0, nil
+}
+
+// handleRPCLine decodes and dispatches one request line. ok is false for
+// notifications (requests without an id), which get no response.
+func handleRPCLine(line []byte) (rpcResponse, bool) {
+	response := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	var request rpcRequest
+	if err := json.Unmarshal(line, &request); err != nil {
+		response.Error = &rpcError{Code: rpcParseError, Message: "parse error: " + err.Error()}
+		return response, true
+	}
+	if len(request.ID) > 0 {
+		response.ID = request.ID
+	}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		response.Error = &rpcError{Code: rpcInvalidRequest, Message: `invalid request: expected "jsonrpc":"2.0" and a method`}
+		return response, true
+	}
+
+	result, err := dispatchRPC(request.Method, request.Params)
+	if len(request.ID) == 0 {
+		return response, false
+	}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		response.Error = rpcErr
+		return response, true
+	}
+	response.Result = result
+	return response, true
+}
+
+// dispatchRPC runs one method. The working directory is restored afterwards,
+// so a -C in one request does not affect the next.
+func dispatchRPC(method string, params json.RawMessage) (result any, retErr error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.Chdir(wd); err != nil {
+			retErr = errors.Join(retErr, fmt.Errorf("restore working directory %s: %w", wd, err))
+		}
+	}()
+
+	switch method {
+	case "resolve":
+		var p rpcArgsParams
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		return rpcResolve(p.Args)
+	case "plan", "run":
+		var p rpcArgsParams
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		for _, arg := range p.Args {
+			if name := strings.TrimLeft(arg, "-"); name == "auto-update" || strings.HasPrefix(name, "auto-update=") {
+				return nil, &rpcError{Code: rpcInvalidParams, Message: "-auto-update is not supported by serve-stdio (the re-exec would replace the server)"}
+			}
+		}
+		var stdout, stderr bytes.Buffer
+		command := cmdPlan
+		if method == "run" {
+			command = cmdRun
+		}
+		code, err := command(p.Args, &stdout, &stderr)
+		result := rpcCommandResult{ExitCode: code, Stdout: stdout.String(), Stderr: stderr.String()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		return result, nil
+	case "status":
+		var p rpcStatusParams
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		return rpcStatus(p)
+	case "explain":
+		var p rpcExplainParams
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Name == "" {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "explain requires a name"}
+		}
+		return rpcExplain(p.Args, p.Name)
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + method}
+	}
+}
+
+// decodeRPCParams decodes params (an object, or absent) into v.
+func decodeRPCParams(params json.RawMessage, v any) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+// rpcPlan parses plan-style args and resolves them like decomk plan does,
+// without running make.
+func rpcPlan(args []string, traceExpand bool) (*resolvedPlan, []string, error) {
+	fs := flag.NewFlagSet("decomk serve-stdio", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var f commonFlags
+	addCommonFlags(fs, &f)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, &rpcError{Code: rpcInvalidParams, Message: "invalid args: " + err.Error()}
+	}
+	f.traceExpand = f.traceExpand || traceExpand
+	if err := applyStartDir(f.startDir); err != nil {
+		return nil, nil, err
+	}
+	plan, err := resolvePlanFromFlags(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	tuples, err := resolveRuntimeTuples(plan.Tuples, envMapFromList(os.Environ()))
+	if err != nil {
+		return nil, nil, err
+	}
+	plan.Tuples = tuples
+	actionArgs := fs.Args()
+	if len(actionArgs) == 0 {
+		actionArgs = plan.ProfileActionArgs
+	}
+	return plan, actionArgs, nil
+}
+
+func rpcResolve(args []string) (rpcResolveResult, error) {
+	plan, actionArgs, err := rpcPlan(args, false)
+	if err != nil {
+		return rpcResolveResult{}, err
+	}
+	var targets []string
+	if len(actionArgs) > 0 {
+		if err := checkSelectedTargetsAllowed(plan.Allow, plan.LowerTuples, plan.Tuples, actionArgs); err != nil {
+			return rpcResolveResult{}, err
+		}
+		targets, _, _ = selectTargets(plan.Tuples, actionArgs, nil)
+	}
+	return newResolveResult(plan, actionArgs, targets), nil
+}
+
+// newResolveResult returns plan as data, with secrets redacted; decomk plan
+// -o json|yaml prints the same shape.
+func newResolveResult(plan *resolvedPlan, actionArgs, targets []string) rpcResolveResult {
+	result := rpcResolveResult{
+		Home:           plan.Home,
+		Profile:        plan.Profile,
+		Contexts:       plan.ContextKeys,
+		Seeds:          plan.Seeds,
+		ConfigPaths:    plan.ConfigPaths,
+		Makefiles:      plan.Makefiles,
+		ExtraMakefiles: plan.ExtraMakefiles,
+		Tuples:         redactSecretTuples(plan.Tuples, plan.Secrets),
+		TupleClasses:   plan.TupleClasses,
+		ActionArgs:     actionArgs,
+		Targets:        targets,
+		Warnings:       append(plan.Warnings, wellKnownVarWarnings(plan.Tuples, plan.TupleClasses)...),
+	}
+	for _, repo := range plan.WorkspaceRepos {
+		result.Workspaces = append(result.Workspaces, repo.Name)
+	}
+	return result
+}
+
+func rpcStatus(p rpcStatusParams) (rpcStatusResult, error) {
+	maxAge := defaultHealthzMaxAge
+	if p.MaxAge != "" {
+		d, err := time.ParseDuration(p.MaxAge)
+		if err != nil {
+			return rpcStatusResult{}, &rpcError{Code: rpcInvalidParams, Message: "invalid maxAge: " + err.Error()}
+		}
+		maxAge = d
+	}
+	home, err := state.Home(p.Home)
+	if err != nil {
+		return rpcStatusResult{}, err
+	}
+	return newStatusResult(home, maxAge, !p.SkipPending), nil
+}
+
+// newStatusResult runs checkHealth on home's run record and returns the
+// verdict with the record and the stamps lock holder and queue, if any;
+// decomk healthz -o json|yaml prints the same shape.
+func newStatusResult(home string, maxAge time.Duration, checkPending bool) rpcStatusResult {
+	path := state.LastRunPath(home)
+	status, healthy, pending := evaluateHealth(path, maxAge, checkPending, time.Now())
+	result := rpcStatusResult{Healthy: healthy, Status: status, Pending: pending}
+	if record, err := readLastRun(path); err == nil {
+		result.LastRun = record
+	}
+	if lock, err := readStampsLockStatus(home); err == nil && (lock.Holder != nil || len(lock.Queued) > 0 || len(lock.Running) > 0) {
+		result.Lock = &lock
+	}
+	return result
+}
+
+// rpcExplain explains name (see explainName) under plan-style args.
+func rpcExplain(args []string, name string) (explainResult, error) {
+	for _, arg := range args {
+		if strings.TrimLeft(arg, "-") == "profile" || strings.HasPrefix(strings.TrimLeft(arg, "-"), "profile=") {
+			return explainResult{}, &rpcError{Code: rpcInvalidParams, Message: "explain cannot use -profile (a profile stores already-expanded tokens)"}
+		}
+	}
+	plan, _, err := rpcPlan(args, true)
+	if err != nil {
+		return explainResult{}, err
+	}
+	result, ok := explainName(plan, name)
+	if !ok {
+		return explainResult{}, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("no context key or tuple named %q", name)}
+	}
+	return result, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveLines runs cmdServeStdio over requests and decodes its responses.
+func serveLines(t *testing.T, requests ...string) []map[string]any {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code, err := cmdServeStdio(nil, strings.NewReader(strings.Join(requests, "\n")+"\n"), &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdServeStdio(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	var responses []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var response map[string]any
+		if err := json.Unmarshal([]byte(line), &response); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", line, err)
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func rpcErrorCode(t *testing.T, response map[string]any) int {
+	t.Helper()
+	rpcErr, ok := response["error"].(map[string]any)
+	if !ok {
+		t.Fatalf("response has no error: %v", response)
+	}
+	return int(rpcErr["code"].(float64))
+}
+
+func TestCmdServeStdio_Errors(t *testing.T) {
+	t.Parallel()
+
+	responses := serveLines(t,
+		`{not json`,
+		`{"jsonrpc":"2.0","id":1,"method":"frobnicate"}`,
+		`{"jsonrpc":"2.0","method":"frobnicate"}`,
+		`{"jsonrpc":"2.0","id":"b","method":"resolve","params":{"argz":[]}}`,
+		`{"id":3,"method":"resolve"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"explain","params":{}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"run","params":{"args":["-auto-update","all"]}}`,
+	)
+	want := []struct {
+		id   any
+		code int
+	}{
+		{nil, rpcParseError},
+		{float64(1), rpcMethodNotFound},
+		// The notification gets no response.
+		{"b", rpcInvalidParams},
+		{float64(3), rpcInvalidRequest},
+		{float64(4), rpcInvalidParams},
+		{float64(5), rpcInvalidParams},
+	}
+	if len(responses) != len(want) {
+		t.Fatalf("responses: got %d want %d: %v", len(responses), len(want), responses)
+	}
+	for i, w := range want {
+		if got := responses[i]["id"]; got != w.id {
+			t.Fatalf("response %d id: got %v want %v", i, got, w.id)
+		}
+		if got := rpcErrorCode(t, responses[i]); got != w.code {
+			t.Fatalf("response %d code: got %d want %d", i, got, w.code)
+		}
+	}
+}
+
+func TestCmdServeStdio_Status(t *testing.T) {
+	t.Parallel()
+
+	params, err := json.Marshal(rpcStatusParams{Home: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Marshal(): %v", err)
+	}
+	responses := serveLines(t, `{"jsonrpc":"2.0","id":1,"method":"status","params":`+string(params)+`}`)
+	result, ok := responses[0]["result"].(map[string]any)
+	if !ok {
+		t.Fatalf("status: got %v", responses[0])
+	}
+	if result["healthy"] != false || !strings.Contains(result["status"].(string), "no recorded run") {
+		t.Fatalf("status on empty home: got %v", result)
+	}
+}
+
+func TestCmdServeStdio_ResolveAndExplain(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	conf := "TOOLS: INSTALL=hello\nDEFAULT: TOOLS FOO=bar FOO=baz\n"
+	if err := os.WriteFile(configPath, []byte(conf), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("hello:\n\t@true\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+	args := []string{"-home", home, "-workspaces", t.TempDir(), "-config", configPath, "-makefile", makefilePath}
+	resolveParams, err := json.Marshal(rpcArgsParams{Args: append(args, "INSTALL")})
+	if err != nil {
+		t.Fatalf("Marshal(resolve): %v", err)
+	}
+	explainParams, err := json.Marshal(rpcExplainParams{Args: args, Name: "FOO"})
+	if err != nil {
+		t.Fatalf("Marshal(explain): %v", err)
+	}
+
+	responses := serveLines(t,
+		`{"jsonrpc":"2.0","id":1,"method":"resolve","params":`+string(resolveParams)+`}`,
+		`{"jsonrpc":"2.0","id":2,"method":"explain","params":`+string(explainParams)+`}`,
+	)
+	var resolved rpcResolveResult
+	var explained explainResult
+	for i, into := range []any{&resolved, &explained} {
+		raw, err := json.Marshal(responses[i]["result"])
+		if err != nil {
+			t.Fatalf("Marshal(result %d): %v", i, err)
+		}
+		if err := json.Unmarshal(raw, into); err != nil {
+			t.Fatalf("Unmarshal(result %d): %v (response %v)", i, err, responses[i])
+		}
+	}
+
+	if got, want := strings.Join(resolved.Targets, " "), "hello"; got != want {
+		t.Fatalf("resolve targets: got %q want %q", got, want)
+	}
+	if got := strings.Join(resolved.Tuples, " "); !strings.Contains(got, "INSTALL=hello") {
+		t.Fatalf("resolve tuples: got %q", got)
+	}
+	if len(explained.Derivations) != 2 {
+		t.Fatalf("explain FOO derivations: got %+v", explained.Derivations)
+	}
+	if explained.Value == nil || *explained.Value != "baz" {
+		t.Fatalf("explain FOO value: got %v want baz", explained.Value)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	// shellFallback is the shell decomk launches when $SHELL is unset.
+	shellFallback = "/bin/sh"
+
+	// shellMarkerVar is exported into the interactive shell so nested tooling
+	// (and operators) can tell they are inside a `decomk shell` session.
+	shellMarkerVar = "DECOMK_SHELL"
+)
+
+// cmdShell resolves the same env tuple contract as plan/run and launches an
+// interactive shell with that environment applied.
+//
+// The shell starts in the stamp directory (make's working directory) so recipe
+// snippets can be pasted and debugged in the same cwd make would use. Any
+// positional args are passed through to the shell unchanged, which allows
+// one-shot use such as `decomk shell -- -c 'env | grep DECOMK_'`.
+//
+// Intent: Let operators debug recipes interactively under exactly the
+// environment make sees, without hand-sourcing env.sh or guessing which
+// contexts were applied.
+// Source: DI-bumil (TODO-jirin)
+func cmdShell(args []string, stdin io.Reader, stdout, stderr io.Writer) (exitCode int, retErr error) {
+	fs := flag.NewFlagSet("decomk shell", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+
+	addCommonFlags(fs, &f)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	shellArgs := fs.Args()
+
+	if err := applyStartDir(f.startDir); err != nil {
+		return 1, err
+	}
+
+	plan, err := resolvePlanFromFlags(f)
+	if err != nil {
+		return 1, err
+	}
+	if plan == nil {
+		return 1, fmt.Errorf("internal error: resolvePlanFromFlags returned nil plan")
+	}
+
+	incomingEnvList := os.Environ()
+	incomingEnv := envMapFromList(incomingEnvList)
+	resolvedTuples, err := resolveRuntimeTuples(plan.Tuples, incomingEnv)
+	if err != nil {
+		return 1, err
+	}
+	plan.Tuples = resolvedTuples
+
+	// No action args are selected in a shell session, so DECOMK_PACKAGES is
+	// intentionally empty; everything else matches the make environment.
+	cookedTuples := canonicalEnvTuples(plan, nil, incomingEnv)
+	shellEnv := withEnv(incomingEnvList, exportedTupleValues(incomingEnvList, cookedTuples, plan.TupleClasses))
+	shellPath := resolveShellPath(incomingEnv)
+	overrides := map[string]string{shellMarkerVar: "1"}
+	// Intent: Set the bash prompt after ~/.bashrc runs, since most .bashrc
+	// files assign PS1 and would otherwise hide the context prefix.
+	// Source: DI-rukor (TODO-jirin)
+	if filepath.Base(shellPath) == "bash" {
+		rcPath, err := writeShellRC(plan.ContextKeys)
+		if err != nil {
+			return 1, err
+		}
+		defer func() {
+			if err := os.Remove(rcPath); err != nil {
+				retErr = errors.Join(retErr, fmt.Errorf("remove shell rc file: %w", err))
+			}
+		}()
+		shellArgs = append([]string{"--rcfile", rcPath}, shellArgs...)
+	} else {
+		overrides["PS1"] = shellPrompt(plan.ContextKeys, incomingEnv["PS1"])
+	}
+	shellEnv = withEnv(shellEnv, overrides)
+
+	if err := state.EnsureDir(plan.StampDir); err != nil {
+		return 1, err
+	}
+
+	if err := writeFormat(stderr, "decomk shell: %s in %s (contexts: %s); exit to return\n", shellPath, plan.StampDir, strings.Join(plan.ContextKeys, " ")); err != nil {
+		return 1, err
+	}
+
+	cmd := exec.Command(shellPath, shellArgs...)
+	cmd.Dir = plan.StampDir
+	cmd.Env = shellEnv
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// A non-zero exit from the user's last shell command is not a decomk
+			// failure; pass the code through without an extra error message.
+			return exitErr.ExitCode(), nil
+		}
+		return 1, fmt.Errorf("run shell %s: %w", shellPath, err)
+	}
+	return 0, nil
+}
+
+// resolveShellPath picks the shell to launch from $SHELL, falling back to
+// /bin/sh when it is unset.
+func resolveShellPath(env map[string]string) string {
+	if shell := strings.TrimSpace(env["SHELL"]); shell != "" {
+		return shell
+	}
+	return shellFallback
+}
+
+// shellPrompt returns the PS1 value for a `decomk shell` session in a shell
+// other than bash (see shellRC): the active context keys in parentheses,
+// followed by the caller's existing prompt (or a plain "$ " when none is set).
+func shellPrompt(contextKeys []string, existing string) string {
+	if existing == "" {
+		existing = `\$ `
+	}
+	return shellPromptPrefix(contextKeys) + existing
+}
+
+// shellPromptPrefix returns the part of the prompt that names the active
+// context keys.
+func shellPromptPrefix(contextKeys []string) string {
+	return "(decomk:" + strings.Join(contextKeys, " ") + ") "
+}
+
+// shellRC returns the bash rc file for a `decomk shell` session: it sources
+// ~/.bashrc, as an interactive bash would without --rcfile, and then puts the
+// context prefix in front of whatever prompt that left.
+func shellRC(contextKeys []string) []byte {
+	var b strings.Builder
+	b.WriteString("# generated by decomk shell; do not edit\n")
+	b.WriteString("if [ -f ~/.bashrc ]; then . ~/.bashrc; fi\n")
+	b.WriteString(`if [ -z "${PS1-}" ]; then PS1='\$ '; fi` + "\n")
+	b.WriteString("PS1=" + shellQuote(shellPromptPrefix(contextKeys)) + `"$PS1"` + "\n")
+	return []byte(b.String())
+}
+
+// writeShellRC writes shellRC to a new temp file and returns its path; the
+// caller removes it when the shell exits.
+func writeShellRC(contextKeys []string) (string, error) {
+	f, err := os.CreateTemp("", "decomk-shell-*.bashrc")
+	if err != nil {
+		return "", fmt.Errorf("create shell rc file: %w", err)
+	}
+	if _, err := f.Write(shellRC(contextKeys)); err != nil {
+		return "", errors.Join(fmt.Errorf("write shell rc file: %w", err), f.Close(), os.Remove(f.Name()))
+	}
+	if err := f.Close(); err != nil {
+		return "", errors.Join(fmt.Errorf("close shell rc file: %w", err), os.Remove(f.Name()))
+	}
+	return f.Name(), nil
+}
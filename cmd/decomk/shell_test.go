@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestCmdShell_AppliesResolvedEnvInStampDir(t *testing.T) {
+	home := t.TempDir()
+	workspacesDir := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: FOO=from-conf\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	t.Setenv("SHELL", "/bin/sh")
+	t.Setenv("PS1", "")
+
+	args := []string{
+		"-home", home,
+		"-workspaces", workspacesDir,
+		"-config", configPath,
+		"--",
+		"-c", `printf '%s|%s|%s|%s\n' "$FOO" "$DECOMK_CONTEXTS" "$DECOMK_SHELL" "$(pwd)"; printf '%s\n' "$PS1"`,
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdShell(args, strings.NewReader(""), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdShell() error: %v (stderr=%q)", err, stderr.String())
+	}
+	if code != 0 {
+		t.Fatalf("cmdShell() code: got %d want 0 (stderr=%q)", code, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("stdout lines: got %q want 2 lines", stdout.String())
+	}
+	if got, want := lines[0], "from-conf|DEFAULT|1|"+state.StampDir(home); got != want {
+		t.Fatalf("shell env line: got %q want %q", got, want)
+	}
+	if got, want := lines[1], `(decomk:DEFAULT) \$`; got != want {
+		t.Fatalf("PS1: got %q want %q", got, want)
+	}
+}
+
+func TestCmdShell_BashPromptSurvivesBashrc(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+	userHome := t.TempDir()
+	writeFileFixture(t, filepath.Join(userHome, ".bashrc"), []byte("PS1='custom> '\n"), 0o644)
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	writeFileFixture(t, configPath, []byte("DEFAULT: FOO=bar\n"), 0o600)
+	t.Setenv("HOME", userHome)
+	t.Setenv("SHELL", bash)
+	t.Setenv("PS1", "")
+
+	args := []string{
+		"-home", t.TempDir(),
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"--",
+		"-i", "-c", `printf '%s\n' "$PS1"`,
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdShell(args, strings.NewReader(""), &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdShell(): got code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if got, want := stdout.String(), "(decomk:DEFAULT) custom> \n"; got != want {
+		t.Fatalf("PS1 after .bashrc: got %q want %q", got, want)
+	}
+}
+
+func TestCmdShell_PassesThroughExitCode(t *testing.T) {
+	home := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	if err := os.WriteFile(configPath, []byte("DEFAULT:\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	t.Setenv("SHELL", "/bin/sh")
+
+	args := []string{
+		"-home", home,
+		"-workspaces", t.TempDir(),
+		"-config", configPath,
+		"--",
+		"-c", "exit 7",
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code, err := cmdShell(args, strings.NewReader(""), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("cmdShell() error: %v", err)
+	}
+	if code != 7 {
+		t.Fatalf("cmdShell() code: got %d want 7", code)
+	}
+}
+
+func TestShellPrompt(t *testing.T) {
+	t.Parallel()
+
+	if got, want := shellPrompt([]string{"DEFAULT", "owner/repo"}, "$ "), "(decomk:DEFAULT owner/repo) $ "; got != want {
+		t.Fatalf("shellPrompt(): got %q want %q", got, want)
+	}
+	if got, want := shellPrompt(nil, ""), `(decomk:) \$ `; got != want {
+		t.Fatalf("shellPrompt(empty): got %q want %q", got, want)
+	}
+}
+
+func TestShellRC(t *testing.T) {
+	t.Parallel()
+
+	want := "# generated by decomk shell; do not edit\n" +
+		"if [ -f ~/.bashrc ]; then . ~/.bashrc; fi\n" +
+		`if [ -z "${PS1-}" ]; then PS1='\$ '; fi` + "\n" +
+		`PS1='(decomk:DEFAULT it'"'"'s) '"$PS1"` + "\n"
+	if got := string(shellRC([]string{"DEFAULT", "it's"})); got != want {
+		t.Fatalf("shellRC():\n got %q\nwant %q", got, want)
+	}
+}
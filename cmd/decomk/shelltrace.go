@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	// shellTraceVar is the make variable holding the shell flags
+	// -trace-shell prepends to .SHELLFLAGS.
+	shellTraceVar = "DECOMK_SHELL_TRACE"
+
+	// shellTraceFD is the file descriptor make and its recipe shells inherit
+	// the trace log on (the first exec.Cmd.ExtraFiles entry).
+	shellTraceFD = "3"
+)
+
+// renderShellTraceMakefile renders the fragment that turns on xtrace for every
+// recipe shell. It must come after the Makefiles so it wraps the .SHELLFLAGS
+// they set.
+func renderShellTraceMakefile() []byte {
+	return []byte("# generated by decomk for run -trace-shell; do not edit\n" +
+		shellTraceVar + " := -x\n" +
+		".SHELLFLAGS := $(" + shellTraceVar + ") $(.SHELLFLAGS)\n")
+}
+
+// openShellTrace writes the -trace-shell fragment and creates trace.log in
+// runLogDir. Callers pass the returned file to make as shellTraceFD, add the
+// fragment after the plan's makefiles, and set BASH_XTRACEFD.
+//
+// Intent: Record exactly which commands recipes ran without editing the
+// Makefile or mixing the trace into make.log.
+// Source: DI-suzaf (TODO-jirin)
+func openShellTrace(home, runLogDir string) (fragment string, trace *os.File, err error) {
+	fragment = state.ShellTraceMakefile(home)
+	if err := writeGeneratedMakefile(fragment, renderShellTraceMakefile()); err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(runLogDir, "trace.log")
+	trace, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL|os.O_APPEND, 0o600)
+	if err != nil {
+		return "", nil, fmt.Errorf("create shell trace log: %w", err)
+	}
+	return fragment, trace, nil
+}
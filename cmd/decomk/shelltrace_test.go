@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/makeexec"
+)
+
+func TestShellTraceMakefile_TracesToFD(t *testing.T) {
+	t.Parallel()
+
+	for _, tool := range []string{"make", "bash"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " not installed")
+		}
+	}
+
+	stampDir := t.TempDir()
+	workDir := t.TempDir()
+	generated := t.TempDir()
+	files := map[string]string{
+		filepath.Join(stampDir, "Makefile"): "SHELL := bash\n.SHELLFLAGS := -eu -o pipefail -c\n" +
+			"plain:\n\techo plain-ran\n\ttouch $@\n" +
+			"indir: plain\n\techo indir-ran\n\ttouch \"$$DECOMK_STAMP\"\n",
+		filepath.Join(generated, "targetdirs.mk"): string(renderTargetDirsMakefile([]targetDir{{Target: "indir", Dir: workDir}})),
+		filepath.Join(generated, "shelltrace.mk"): string(renderShellTraceMakefile()),
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+	traceFile, err := os.Create(filepath.Join(t.TempDir(), "trace.log"))
+	if err != nil {
+		t.Fatalf("Create(trace.log): %v", err)
+	}
+	t.Cleanup(func() {
+		if err := traceFile.Close(); err != nil {
+			t.Errorf("Close(trace.log): %v", err)
+		}
+	})
+
+	makefiles := []string{filepath.Join(stampDir, "Makefile"), filepath.Join(generated, "targetdirs.mk"), filepath.Join(generated, "shelltrace.mk")}
+	env := append(os.Environ(), "BASH_XTRACEFD="+shellTraceFD)
+	var stdout, stderr bytes.Buffer
+	code, err := makeexec.RunMakefilesCommandFiles(stampDir, makefiles, []string{"make"}, []string{"-s"}, nil, []string{"indir"}, env, []*os.File{traceFile}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("make indir: code=%d err=%v\n%s", code, err, stderr.String())
+	}
+	if got, want := stdout.String(), "plain-ran\nindir-ran\n"; got != want {
+		t.Fatalf("make stdout: got %q want %q", got, want)
+	}
+	if strings.Contains(stderr.String(), "+ ") {
+		t.Fatalf("trace leaked to stderr: %q", stderr.String())
+	}
+	trace, err := os.ReadFile(traceFile.Name())
+	if err != nil {
+		t.Fatalf("ReadFile(trace.log): %v", err)
+	}
+	for _, needle := range []string{"+ echo plain-ran\n", "+ cd " + workDir + "\n", "+ echo indir-ran\n"} {
+		if !strings.Contains(string(trace), needle) {
+			t.Fatalf("trace.log missing %q:\n%s", needle, trace)
+		}
+	}
+}
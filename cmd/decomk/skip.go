@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+)
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// checkSkipPatterns validates -skip values as path.Match patterns.
+func checkSkipPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid -skip %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// skipTargets returns targets without those matching any of patterns (exact
+// names or path.Match globs), and the targets it removed.
+//
+// Skipping only removes goals: make still builds a skipped target when a
+// kept target depends on it.
+//
+// Intent: Let operators temporarily skip a known-broken block without
+// editing config.
+// Source: DI-sogov (TODO-jirin)
+func skipTargets(targets, patterns []string) (kept, skipped []string) {
+	if len(patterns) == 0 {
+		return targets, nil
+	}
+	for _, target := range targets {
+		matched := false
+		for _, pattern := range patterns {
+			if ok, err := path.Match(pattern, target); err == nil && ok {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			skipped = append(skipped, target)
+			continue
+		}
+		kept = append(kept, target)
+	}
+	return kept, skipped
+}
+
+// filterTargetsByTags applies a -tags expression to targets and returns the
+// targets it keeps and those it drops.
+//
+// expr is a comma-separated list of tag names. When it names any tag without
+// a leading "!", only targets carrying at least one of those tags are kept;
+// "!tag" terms then drop targets carrying that tag. An empty expr keeps every
+// target. Tag names not defined by any tag line are an error, so a typo does
+// not silently select nothing.
+//
+// Intent: Give a middle ground between running everything and hand-listing
+// targets, with the grouping kept in config next to the targets themselves.
+// Source: DI-pamuj (TODO-jirin)
+func filterTargetsByTags(targets []string, tags contexts.Tags, expr string) (kept, dropped []string, err error) {
+	if strings.TrimSpace(expr) == "" {
+		return targets, nil, nil
+	}
+	var include, exclude []string
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		name, negated := strings.CutPrefix(term, "!")
+		if name == "" {
+			return nil, nil, fmt.Errorf("invalid -tags %q: empty tag name", expr)
+		}
+		if _, ok := tags[name]; !ok {
+			return nil, nil, fmt.Errorf("invalid -tags %q: unknown tag %q", expr, name)
+		}
+		if negated {
+			exclude = append(exclude, name)
+		} else {
+			include = append(include, name)
+		}
+	}
+	hasTag := func(target string, names []string) bool {
+		for _, name := range names {
+			if slices.Contains(tags[name], target) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, target := range targets {
+		if (len(include) > 0 && !hasTag(target, include)) || hasTag(target, exclude) {
+			dropped = append(dropped, target)
+			continue
+		}
+		kept = append(kept, target)
+	}
+	return kept, dropped, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/contexts"
+)
+
+func TestSelectTargets_Skip(t *testing.T) {
+	t.Parallel()
+
+	tuples := []string{"INSTALL=Block00 Block10 tools/jq tools/vnc"}
+	targets, skipped, _ := selectTargets(tuples, []string{"INSTALL"}, []string{"Block10", "tools/*"})
+	if want := []string{"Block00"}; !reflect.DeepEqual(targets, want) {
+		t.Fatalf("selectTargets() targets: got %q want %q", targets, want)
+	}
+	if want := []string{"Block10", "tools/jq", "tools/vnc"}; !reflect.DeepEqual(skipped, want) {
+		t.Fatalf("selectTargets() skipped: got %q want %q", skipped, want)
+	}
+
+	if err := checkSkipPatterns([]string{"Block[0"}); err == nil {
+		t.Fatalf("checkSkipPatterns(Block[0): got nil want error")
+	}
+}
+
+func TestCmdPlan_Skip(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	files := map[string]string{
+		configPath:                         "DEFAULT: INSTALL='Block00 Block10'\n",
+		filepath.Join(confDir, "Makefile"): "Block00 Block10:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	base := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath}
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdPlan(append(base, "-skip", "Block1*", "INSTALL"), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-skip): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "skipped targets (-skip): Block10\n") {
+		t.Fatalf("cmdPlan(-skip) output missing skipped line:\n%s", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "targets:\n  Block00\n\n") {
+		t.Fatalf("cmdPlan(-skip) output: want only Block00 targeted:\n%s", stdout.String())
+	}
+
+	code, err := cmdPlan(append(base, "-skip", "Block00", "-skip", "Block10", "INSTALL"), &stdout, &stderr)
+	if err == nil || code != 2 || !strings.Contains(err.Error(), "-skip removed every target") {
+		t.Fatalf("cmdPlan(-skip all): got code=%d err=%v want 2 and a removed-every-target error", code, err)
+	}
+}
+
+func TestFilterTargetsByTags(t *testing.T) {
+	t.Parallel()
+
+	tags := contexts.Tags{
+		"core": {"Block00", "install-vnc"},
+		"gui":  {"install-vnc", "install-novnc"},
+	}
+	targets := []string{"Block00", "Block10", "install-vnc", "install-novnc"}
+	cases := []struct {
+		expr        string
+		kept, drops []string
+	}{
+		{expr: "", kept: targets},
+		{expr: "gui", kept: []string{"install-vnc", "install-novnc"}, drops: []string{"Block00", "Block10"}},
+		{expr: "!gui", kept: []string{"Block00", "Block10"}, drops: []string{"install-vnc", "install-novnc"}},
+		{expr: "!gui, core", kept: []string{"Block00"}, drops: []string{"Block10", "install-vnc", "install-novnc"}},
+	}
+	for _, tc := range cases {
+		kept, dropped, err := filterTargetsByTags(targets, tags, tc.expr)
+		if err != nil {
+			t.Fatalf("filterTargetsByTags(%q): %v", tc.expr, err)
+		}
+		if !reflect.DeepEqual(kept, tc.kept) || !reflect.DeepEqual(dropped, tc.drops) {
+			t.Fatalf("filterTargetsByTags(%q): got %q, %q want %q, %q", tc.expr, kept, dropped, tc.kept, tc.drops)
+		}
+	}
+
+	for _, expr := range []string{"gi", "core,", "!"} {
+		if _, _, err := filterTargetsByTags(targets, tags, expr); err == nil {
+			t.Fatalf("filterTargetsByTags(%q): got nil want error", expr)
+		}
+	}
+}
+
+func TestCmdPlan_Tags(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	files := map[string]string{
+		configPath:                         "DEFAULT: INSTALL='Block00 install-vnc'\ntag gui: install-vnc\n",
+		filepath.Join(confDir, "Makefile"): "Block00 install-vnc:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	base := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath}
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdPlan(append(base, "-tags", "!gui", "INSTALL"), &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("cmdPlan(-tags): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "skipped targets (-tags): install-vnc\n") {
+		t.Fatalf("cmdPlan(-tags) output missing skipped line:\n%s", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "targets:\n  Block00\n\n") {
+		t.Fatalf("cmdPlan(-tags) output: want only Block00 targeted:\n%s", stdout.String())
+	}
+
+	code, err := cmdPlan(append(base, "-tags", "nosuch", "INSTALL"), &stdout, &stderr)
+	if err == nil || code != 2 || !strings.Contains(err.Error(), `unknown tag "nosuch"`) {
+		t.Fatalf("cmdPlan(-tags nosuch): got code=%d err=%v want 2 and an unknown-tag error", code, err)
+	}
+}
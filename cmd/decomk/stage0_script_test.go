@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -9,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/stevegt/decomk/stage0"
+	"github.com/stevegt/decomk/state"
 )
 
 func TestStage0ScriptFailNoBootPolicy(t *testing.T) {
@@ -105,6 +108,211 @@ func TestStage0ScriptFailNoBootPolicy(t *testing.T) {
 	})
 }
 
+func TestStage0ScriptSelfcheckGatesToolUpdate(t *testing.T) {
+	t.Run("failed selfcheck without previous binary fails", func(t *testing.T) {
+		scriptPath, baseEnv := writeStage0ScriptFixture(t)
+		env := cloneEnvMap(baseEnv)
+		env["DECOMK_FAIL_NOBOOT"] = "true"
+		env["FAKE_DECOMK_SELFCHECK_RC"] = "1"
+
+		exitCode, output := runStage0Script(t, scriptPath, env)
+		if exitCode == 0 {
+			t.Fatalf("exit code: got 0 want non-zero\noutput:\n%s", output)
+		}
+		if !strings.Contains(output, "new binary failed --selfcheck and no previous decomk binary is installed") {
+			t.Fatalf("output missing selfcheck failure message:\n%s", output)
+		}
+	})
+
+	t.Run("failed selfcheck keeps previous binary", func(t *testing.T) {
+		scriptPath, baseEnv := writeStage0ScriptFixture(t)
+		previous := filepath.Join(baseEnv["GOBIN"], "decomk")
+		if err := os.MkdirAll(baseEnv["GOBIN"], 0o755); err != nil {
+			t.Fatalf("MkdirAll(GOBIN): %v", err)
+		}
+		if err := os.WriteFile(previous, []byte("#!/usr/bin/env bash\necho \"previous decomk $*\"\n"), 0o755); err != nil {
+			t.Fatalf("WriteFile(previous decomk): %v", err)
+		}
+		env := cloneEnvMap(baseEnv)
+		env["DECOMK_FAIL_NOBOOT"] = "true"
+		env["FAKE_DECOMK_SELFCHECK_RC"] = "1"
+
+		exitCode, output := runStage0Script(t, scriptPath, env)
+		if exitCode != 0 {
+			t.Fatalf("exit code: got %d want 0\noutput:\n%s", exitCode, output)
+		}
+		for _, needle := range []string{
+			"new binary failed --selfcheck; keeping previous binary " + previous,
+			"previous decomk run TEST_ACTION",
+		} {
+			if !strings.Contains(output, needle) {
+				t.Fatalf("output missing %q:\n%s", needle, output)
+			}
+		}
+	})
+
+	t.Run("passing selfcheck promotes new binary", func(t *testing.T) {
+		scriptPath, baseEnv := writeStage0ScriptFixture(t)
+		env := cloneEnvMap(baseEnv)
+		env["DECOMK_FAIL_NOBOOT"] = "true"
+		env["FAKE_DECOMK_RC"] = "0"
+
+		exitCode, output := runStage0Script(t, scriptPath, env)
+		if exitCode != 0 {
+			t.Fatalf("exit code: got %d want 0\noutput:\n%s", exitCode, output)
+		}
+		if !strings.Contains(output, "fake decomk success") {
+			t.Fatalf("output missing promoted binary run:\n%s", output)
+		}
+		if !fileExists(filepath.Join(baseEnv["GOBIN"], "decomk")) {
+			t.Fatalf("promoted binary missing from GOBIN")
+		}
+
+		// The promoted build is archived once; an identical rebuild is not.
+		if exitCode, output := runStage0Script(t, scriptPath, env); exitCode != 0 {
+			t.Fatalf("second run exit code: got %d want 0\noutput:\n%s", exitCode, output)
+		}
+		entries, err := listToolArchive(filepath.Join(baseEnv["DECOMK_HOME"], "decomk", "bin", "archive"))
+		if err != nil {
+			t.Fatalf("listToolArchive(): %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("archive entries: got %d want 1 (%#v)", len(entries), entries)
+		}
+		if got := entries[0]; got.Version != "v0.0.1" || got.Commit != "fakecommit" || got.Source != baseEnv["DECOMK_TOOL_URI"] || got.SHA256 == "" {
+			t.Fatalf("archive entry metadata: got %#v", got)
+		}
+	})
+}
+
+func TestStage0ScriptCachesToolBuild(t *testing.T) {
+	scriptPath, baseEnv := writeStage0ScriptFixture(t)
+	home := baseEnv["DECOMK_HOME"]
+	goLog := filepath.Join(t.TempDir(), "go.log")
+	env := cloneEnvMap(baseEnv)
+	env["FAKE_GO_LOG"] = goLog
+	env["GOOS"] = "linux"
+	env["GOARCH"] = "amd64"
+	env["GOFLAGS"] = ""
+	env["GOMODCACHE"] = ""
+
+	installs := func() []string {
+		t.Helper()
+		content, err := os.ReadFile(goLog)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("ReadFile(go log): %v", err)
+		}
+		if len(content) == 0 {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+
+	// Miss: build with -trimpath and the shared module cache, then cache it
+	// where decomk self-update looks.
+	if exitCode, output := runStage0Script(t, scriptPath, env); exitCode != 0 {
+		t.Fatalf("first run exit code: got %d want 0\noutput:\n%s", exitCode, output)
+	}
+	want := "install example.com/fake/decomk@v0.0.1 GOFLAGS=-trimpath GOMODCACHE=" + state.GoModCacheDir(home)
+	if got := installs(); len(got) != 1 || got[0] != want {
+		t.Fatalf("go installs after miss: got %q want [%q]", got, want)
+	}
+	t.Setenv("GOOS", "linux")
+	t.Setenv("GOARCH", "amd64")
+	entry := toolBuildCachePath(home, goToolCacheKey("example.com/fake/decomk@v0.0.1"))
+	if !fileExists(entry) {
+		t.Fatalf("cache entry %s missing after miss", entry)
+	}
+
+	// Hit: reuse the cached build without running go install.
+	exitCode, output := runStage0Script(t, scriptPath, env)
+	if exitCode != 0 {
+		t.Fatalf("second run exit code: got %d want 0\noutput:\n%s", exitCode, output)
+	}
+	if !strings.Contains(output, "using cached build "+entry) {
+		t.Fatalf("second run output missing cache hit:\n%s", output)
+	}
+	if got := installs(); len(got) != 1 {
+		t.Fatalf("go installs after hit: got %q want one", got)
+	}
+
+	// Another platform misses.
+	env["GOARCH"] = "arm64"
+	if exitCode, output := runStage0Script(t, scriptPath, env); exitCode != 0 {
+		t.Fatalf("arm64 run exit code: got %d want 0\noutput:\n%s", exitCode, output)
+	}
+	if got := installs(); len(got) != 2 {
+		t.Fatalf("go installs after platform change: got %q want two", got)
+	}
+}
+
+func TestStage0ScriptRequireSignedTool(t *testing.T) {
+	for _, tool := range []string{"git", "ssh-keygen"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " not installed")
+		}
+	}
+
+	keys := t.TempDir()
+	trusted, trustedPub := sshKeyFixture(t, keys, "trusted")
+	other, _ := sshKeyFixture(t, keys, "other")
+	signers := filepath.Join(keys, "allowed_signers")
+	writeFileFixture(t, signers, []byte("test@example.com "+trustedPub+"\n"), 0o644)
+
+	cases := []struct {
+		name   string
+		commit []string
+		tag    bool
+		want   string
+	}{
+		{name: "trusted commit", commit: []string{"-c", "gpg.format=ssh", "-c", "user.signingkey=" + trusted, "commit", "-q", "-S", "--allow-empty", "-m", "trusted"}, want: "fake decomk success"},
+		{name: "trusted tag", commit: []string{"commit", "-q", "--allow-empty", "-m", "unsigned"}, tag: true, want: "fake decomk success"},
+		{name: "untrusted commit", commit: []string{"-c", "gpg.format=ssh", "-c", "user.signingkey=" + other, "commit", "-q", "-S", "--allow-empty", "-m", "other"}, want: "refusing to build unsigned tool update and no previous decomk binary is installed"},
+		{name: "unsigned commit", commit: []string{"commit", "-q", "--allow-empty", "-m", "unsigned"}, want: "is not signed with an SSH key"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := t.TempDir()
+			runGitFixture(t, upstream, "init", "-q", "-b", "main")
+			runGitFixture(t, upstream, tc.commit...)
+			uri := "git:" + upstream
+			if tc.tag {
+				runGitFixture(t, upstream, "-c", "gpg.format=ssh", "-c", "user.signingkey="+trusted, "tag", "-s", "-m", "release", "v1.0.0")
+				uri += "?ref=v1.0.0"
+			}
+
+			scriptPath, baseEnv := writeStage0ScriptFixture(t)
+			goLog := filepath.Join(t.TempDir(), "go.log")
+			env := cloneEnvMap(baseEnv)
+			env["DECOMK_FAIL_NOBOOT"] = "true"
+			env["DECOMK_TOOL_URI"] = uri
+			env["DECOMK_REQUIRE_SIGNED_TOOL"] = "1"
+			env["DECOMK_TOOL_ALLOWED_SIGNERS"] = signers
+			env["FAKE_GO_LOG"] = goLog
+
+			exitCode, output := runStage0Script(t, scriptPath, env)
+			if !strings.Contains(output, tc.want) {
+				t.Fatalf("output missing %q (exit code %d):\n%s", tc.want, exitCode, output)
+			}
+			built := fileExists(goLog)
+			if wantBuilt := tc.want == "fake decomk success"; built != wantBuilt || (exitCode == 0) != wantBuilt {
+				t.Fatalf("go install ran: got %v want %v (exit code %d)\noutput:\n%s", built, wantBuilt, exitCode, output)
+			}
+		})
+	}
+
+	t.Run("go URI", func(t *testing.T) {
+		scriptPath, baseEnv := writeStage0ScriptFixture(t)
+		env := cloneEnvMap(baseEnv)
+		env["DECOMK_FAIL_NOBOOT"] = "true"
+		env["DECOMK_REQUIRE_SIGNED_TOOL"] = "1"
+		exitCode, output := runStage0Script(t, scriptPath, env)
+		if exitCode == 0 || !strings.Contains(output, "DECOMK_REQUIRE_SIGNED_TOOL needs a git: tool URI") {
+			t.Fatalf("exit code %d, output missing git: URI error:\n%s", exitCode, output)
+		}
+	})
+}
+
 func writeStage0ScriptFixture(t *testing.T) (string, map[string]string) {
 	t.Helper()
 
@@ -141,6 +349,12 @@ if [[ "$cmd" == "env" ]]; then
     GOPATH)
       printf '%s\n' "${GOPATH:-}"
       ;;
+    GOOS)
+      printf '%s\n' "${GOOS:-linux}"
+      ;;
+    GOARCH)
+      printf '%s\n' "${GOARCH:-amd64}"
+      ;;
     *)
       exit 1
       ;;
@@ -148,11 +362,18 @@ if [[ "$cmd" == "env" ]]; then
   exit 0
 fi
 if [[ "$cmd" == "install" ]]; then
+  if [[ -n "${FAKE_GO_LOG:-}" ]]; then
+    printf 'install %s GOFLAGS=%s GOMODCACHE=%s\n' "${2:-}" "${GOFLAGS:-}" "${GOMODCACHE:-}" >>"$FAKE_GO_LOG"
+  fi
   target="${GOBIN:-${GOPATH}/bin}"
   mkdir -p "$target"
   cat >"$target/decomk" <<'EOS'
 #!/usr/bin/env bash
 set -euo pipefail
+if [[ "${1:-}" == "--selfcheck" ]]; then
+  echo "decomk selfcheck ok: version=v0.0.1 commit=fakecommit"
+  exit "${FAKE_DECOMK_SELFCHECK_RC:-0}"
+fi
 rc="${FAKE_DECOMK_RC:-0}"
 if [[ "$rc" == "0" ]]; then
   echo "fake decomk success"
@@ -245,3 +466,185 @@ func cloneEnvMap(input map[string]string) map[string]string {
 	}
 	return out
 }
+
+func TestStage0ScriptConfSubmodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not available: %v", err)
+	}
+
+	root := t.TempDir()
+	gitEnv := append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		// Local-path submodules need the file protocol, which newer git
+		// disables for submodules by default.
+		"GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=protocol.file.allow", "GIT_CONFIG_VALUE_0=always",
+	)
+	git := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = gitEnv
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	libRepo := filepath.Join(root, "lib")
+	confRepo := filepath.Join(root, "confrepo")
+	for _, dir := range []string{libRepo, confRepo} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("MkdirAll(%s): %v", dir, err)
+		}
+		git(dir, "init", "-q")
+	}
+	if err := os.WriteFile(filepath.Join(libRepo, "recipes.mk"), []byte("lib-target:\n\ttouch $@\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(recipes.mk): %v", err)
+	}
+	git(libRepo, "add", "-A")
+	git(libRepo, "commit", "-qm", "lib")
+	if err := os.WriteFile(filepath.Join(confRepo, "decomk.conf"), []byte("DEFAULT: TEST_ACTION='echo ok'\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(decomk.conf): %v", err)
+	}
+	git(confRepo, "submodule", "add", "-q", libRepo, "lib")
+	git(confRepo, "add", "-A")
+	git(confRepo, "commit", "-qm", "conf")
+
+	for _, tc := range []struct {
+		setting string
+		wantLib bool
+	}{
+		{setting: "", wantLib: false},
+		{setting: "true", wantLib: true},
+	} {
+		t.Run("DECOMK_CONF_SUBMODULES="+tc.setting, func(t *testing.T) {
+			scriptPath, baseEnv := writeStage0ScriptFixture(t)
+			confDir := filepath.Join(baseEnv["DECOMK_HOME"], "conf")
+			if err := os.RemoveAll(confDir); err != nil {
+				t.Fatalf("RemoveAll(confDir): %v", err)
+			}
+			env := cloneEnvMap(baseEnv)
+			env["DECOMK_FAIL_NOBOOT"] = "true"
+			env["DECOMK_CONF_URI"] = "git:" + confRepo
+			env["DECOMK_CONF_SUBMODULES"] = tc.setting
+			env["GIT_CONFIG_COUNT"] = "1"
+			env["GIT_CONFIG_KEY_0"] = "protocol.file.allow"
+			env["GIT_CONFIG_VALUE_0"] = "always"
+
+			exitCode, output := runStage0Script(t, scriptPath, env)
+			if exitCode != 0 {
+				t.Fatalf("exit code: got %d want 0\noutput:\n%s", exitCode, output)
+			}
+			if got := fileExists(filepath.Join(confDir, "lib", "recipes.mk")); got != tc.wantLib {
+				t.Fatalf("submodule checked out: got %v want %v\noutput:\n%s", got, tc.wantLib, output)
+			}
+		})
+	}
+}
+
+func TestStage0ScriptSyncsReposConcurrently(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skipf("git not available: %v", err)
+	}
+
+	root := t.TempDir()
+	gitEnv := append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	repos := map[string]string{"tool": "go.mod", "conf": "decomk.conf"}
+	for name, file := range repos {
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("MkdirAll(%s): %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte("DEFAULT: TEST_ACTION='echo ok'\n"), 0o644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", file, err)
+		}
+		for _, args := range [][]string{{"init", "-q"}, {"add", "-A"}, {"commit", "-qm", name}} {
+			cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+			cmd.Env = gitEnv
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		jobs     string
+		confRepo string
+		wantRC   bool
+		want     []string
+	}{
+		{name: "default limit", jobs: "", confRepo: filepath.Join(root, "conf"), want: []string{"syncing 2 repo(s), up to 4 at a time", "[tool] synced in", "[conf] synced in"}},
+		{name: "one at a time", jobs: "1", confRepo: filepath.Join(root, "conf"), want: []string{"up to 1 at a time", "[tool] synced in", "[conf] synced in"}},
+		{name: "failed sync waits for the others", jobs: "", confRepo: filepath.Join(root, "missing"), wantRC: true, want: []string{"[tool] synced in", "[conf] sync failed", "1 repo sync job(s) failed"}},
+		{name: "invalid limit", jobs: "0", confRepo: filepath.Join(root, "conf"), wantRC: true, want: []string{"invalid DECOMK_GIT_JOBS=0"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			scriptPath, baseEnv := writeStage0ScriptFixture(t)
+			if err := os.RemoveAll(filepath.Join(baseEnv["DECOMK_HOME"], "conf")); err != nil {
+				t.Fatalf("RemoveAll(conf): %v", err)
+			}
+			env := cloneEnvMap(baseEnv)
+			env["DECOMK_FAIL_NOBOOT"] = "true"
+			env["DECOMK_TOOL_URI"] = "git:" + filepath.Join(root, "tool")
+			env["DECOMK_CONF_URI"] = "git:" + tc.confRepo
+			env["DECOMK_GIT_JOBS"] = tc.jobs
+
+			exitCode, output := runStage0Script(t, scriptPath, env)
+			if (exitCode != 0) != tc.wantRC {
+				t.Fatalf("exit code: got %d want failure=%v\noutput:\n%s", exitCode, tc.wantRC, output)
+			}
+			for _, needle := range tc.want {
+				if !strings.Contains(output, needle) {
+					t.Fatalf("output missing %q:\n%s", needle, output)
+				}
+			}
+			if !tc.wantRC && !fileExists(filepath.Join(baseEnv["DECOMK_HOME"], "src", "decomk", "go.mod")) {
+				t.Fatalf("tool repo not synced\noutput:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestStage0ScriptConfBundle(t *testing.T) {
+	for _, tool := range []string{"git", "curl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available: %v", tool, err)
+		}
+	}
+
+	root := t.TempDir()
+	bundle := filepath.Join(root, "conf.bundle")
+	commit := newBundleRepo(t, filepath.Join(root, "confrepo"), bundle)
+	commit("decomk.conf")
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer server.Close()
+
+	scriptPath, baseEnv := writeStage0ScriptFixture(t)
+	confDir := filepath.Join(baseEnv["DECOMK_HOME"], "conf")
+	if err := os.RemoveAll(confDir); err != nil {
+		t.Fatalf("RemoveAll(confDir): %v", err)
+	}
+	env := cloneEnvMap(baseEnv)
+	env["DECOMK_FAIL_NOBOOT"] = "true"
+	env["DECOMK_CONF_URI"] = "git:" + server.URL + "/conf.bundle"
+
+	// The second run picks up a commit published in a refreshed bundle.
+	for _, name := range []string{"decomk.conf", "Makefile"} {
+		if name != "decomk.conf" {
+			commit(name)
+		}
+		exitCode, output := runStage0Script(t, scriptPath, env)
+		if exitCode != 0 {
+			t.Fatalf("exit code: got %d want 0\noutput:\n%s", exitCode, output)
+		}
+		if !strings.Contains(output, "downloading bundle "+server.URL+"/conf.bundle") {
+			t.Fatalf("output missing bundle download line:\n%s", output)
+		}
+		if !fileExists(filepath.Join(confDir, name)) {
+			t.Fatalf("%s not synced from the bundle\noutput:\n%s", name, output)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCmdStamp_ResolvesAgainstStampDir(t *testing.T) {
+	stampDir := t.TempDir()
+	t.Setenv("DECOMK_STAMPDIR", stampDir)
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdStamp([]string{"Block00", "tools/jq"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdStamp(): got %d, %v want 0, nil", code, err)
+	}
+	for _, name := range []string{"Block00", "tools/jq"} {
+		if !fileExists(filepath.Join(stampDir, name)) {
+			t.Fatalf("stamp %s not created in %s", name, stampDir)
+		}
+	}
+
+	if code, err := cmdStamp(nil, &stdout, &stderr); err == nil || code != 2 {
+		t.Fatalf("cmdStamp(no targets): got %d, %v want 2 and an error", code, err)
+	}
+}
+
+func TestUnstampedTargets(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	makefile := filepath.Join(dir, "Makefile")
+	content := "" +
+		".PHONY: always\n" +
+		"stamped:\n\ttouch $@\n" +
+		"forgot:\n\techo done\n" +
+		"always:\n\techo always\n" +
+		"group: stamped\n" +
+		"pattern-%:\n\techo $*\n"
+	if err := os.WriteFile(makefile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stampDir := filepath.Join(dir, "stamps")
+	if err := os.MkdirAll(stampDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stampDir, "stamped"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &resolvedPlan{Home: t.TempDir(), StampDir: stampDir, Makefiles: []string{makefile}}
+	got, err := unstampedTargets(plan, []string{"stamped", "forgot", "always", "group", "pattern-x"}, nil, os.Environ())
+	if err != nil {
+		t.Fatalf("unstampedTargets(): %v", err)
+	}
+	want := []string{"forgot", "pattern-x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unstampedTargets(): got %q want %q", got, want)
+	}
+}
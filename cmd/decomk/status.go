@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/resolve"
+	"github.com/stevegt/decomk/state"
+)
+
+// Status file pending values.
+const (
+	statusPendingTrue    = "true"
+	statusPendingFalse   = "false"
+	statusPendingUnknown = "unknown"
+)
+
+// statusSummary is the content of the status file: one KEY=value line per
+// field, so a prompt can source it or grep one line.
+type statusSummary struct {
+	// Result is ok or failed.
+	Result     string    `json:"result"`
+	ExitCode   int       `json:"exitCode"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Pending is true when targets still need to run (make -q, or a failed
+	// run), false when they converged, and unknown when nobody checked.
+	Pending   string    `json:"pending"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// statusReport is what `decomk status` reports.
+type statusReport struct {
+	// Status is nil when no run was recorded.
+	Status *statusSummary    `json:"status,omitempty"`
+	Lock   *stampsLockStatus `json:"lock,omitempty"`
+}
+
+// newStatusSummary summarizes record as of now; pending is nil when unknown.
+func newStatusSummary(record *lastRun, pending *bool, now time.Time) statusSummary {
+	summary := statusSummary{Result: "ok", ExitCode: record.ExitCode, FinishedAt: record.FinishedAt, Pending: statusPendingUnknown, UpdatedAt: now}
+	if record.ExitCode != 0 {
+		summary.Result = "failed"
+	}
+	switch {
+	case pending == nil:
+	case *pending:
+		summary.Pending = statusPendingTrue
+	default:
+		summary.Pending = statusPendingFalse
+	}
+	return summary
+}
+
+// writeStatusFile replaces the status file at path with summary.
+//
+// Intent: Let shell prompts and tmux status lines show decomk state by
+// reading one small file instead of running decomk.
+// Source: DI-bumiz (TODO-jirin)
+func writeStatusFile(path string, summary statusSummary) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "result=%s\n", summary.Result)
+	fmt.Fprintf(&b, "exitCode=%d\n", summary.ExitCode)
+	fmt.Fprintf(&b, "finishedAt=%s\n", summary.FinishedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "finishedAtUnix=%d\n", summary.FinishedAt.Unix())
+	fmt.Fprintf(&b, "pending=%s\n", summary.Pending)
+	fmt.Fprintf(&b, "updatedAt=%s\n", summary.UpdatedAt.UTC().Format(time.RFC3339))
+	if err := state.AtomicWrite(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("write status %s: %w", path, err)
+	}
+	return nil
+}
+
+// readStatusFile loads the status file at path.
+func readStatusFile(path string) (statusSummary, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return statusSummary{}, err
+	}
+	var summary statusSummary
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, ok := resolve.SplitTuple(strings.TrimSpace(line))
+		if !ok {
+			continue
+		}
+		switch key {
+		case "result":
+			summary.Result = value
+		case "exitCode":
+			summary.ExitCode, err = strconv.Atoi(value)
+		case "finishedAt":
+			summary.FinishedAt, err = time.Parse(time.RFC3339, value)
+		case "pending":
+			summary.Pending = value
+		case "updatedAt":
+			summary.UpdatedAt, err = time.Parse(time.RFC3339, value)
+		}
+		if err != nil {
+			return statusSummary{}, fmt.Errorf("decode status %s: %s: %w", path, key, err)
+		}
+	}
+	return summary, nil
+}
+
+// refreshStatusFile rewrites the status file at path from a healthz check of
+// record, unless the file already describes a newer run.
+func refreshStatusFile(path string, record *lastRun, pending bool, now time.Time) error {
+	current, err := readStatusFile(path)
+	if err == nil && current.FinishedAt.After(record.FinishedAt.Truncate(time.Second)) {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeStatusFile(path, newStatusSummary(record, &pending, now))
+}
+
+// cmdStatus reports the last run, whether targets are pending, and the runs
+// in progress or waiting.
+//
+// Like env, history, and logs, it only reads files that runs replace
+// atomically, and it never takes a lock, resolves config, or runs make, so it
+// answers immediately while a run is in progress.
+//
+// Intent: Let dashboards and prompts poll decomk state safely while a run is
+// in progress.
+// Source: DI-visos (TODO-jirin)
+func cmdStatus(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk status", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag, output string
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	addOutputFlag(fs, &output)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("status does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if err := checkOutputFormat(output); err != nil {
+		return 2, err
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	report, err := readStatusReport(home)
+	if err != nil {
+		return 1, err
+	}
+	if output != outputText {
+		if err := writeStructured(stdout, output, report); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	return 0, writeStatusReport(stdout, report, time.Now())
+}
+
+// readStatusReport reads home's status file, falling back to the last run
+// record when the status file predates it or is missing, and the lock holders.
+func readStatusReport(home string) (statusReport, error) {
+	var report statusReport
+	summary, err := readStatusFile(state.StatusPath(home))
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+	record, recordErr := readLastRun(state.LastRunPath(home))
+	if recordErr != nil && !os.IsNotExist(recordErr) {
+		return report, recordErr
+	}
+	switch {
+	case record != nil && (err != nil || summary.FinishedAt.Before(record.FinishedAt.Truncate(time.Second))):
+		summary = newStatusSummary(record, nil, record.FinishedAt)
+		report.Status = &summary
+	case err == nil:
+		report.Status = &summary
+	}
+	lock, err := readStampsLockStatus(home)
+	if err != nil {
+		return report, err
+	}
+	if lock.Holder != nil || len(lock.Queued) > 0 || len(lock.Running) > 0 {
+		report.Lock = &lock
+	}
+	return report, nil
+}
+
+// writeStatusReport writes report as text, with ages relative to now.
+func writeStatusReport(w io.Writer, report statusReport, now time.Time) error {
+	if report.Status == nil {
+		if err := writeLine(w, "last run: none recorded"); err != nil {
+			return err
+		}
+	} else {
+		s := report.Status
+		if err := writeFormat(w, "last run: %s (exit %d) at %s, %s ago\n", s.Result, s.ExitCode, s.FinishedAt.UTC().Format(time.RFC3339), formatSeconds(now.Sub(s.FinishedAt).Seconds())); err != nil {
+			return err
+		}
+		if err := writeFormat(w, "pending: %s (as of %s)\n", s.Pending, s.UpdatedAt.UTC().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	if report.Lock == nil {
+		return writeLine(w, "running: none")
+	}
+	for _, holder := range report.Lock.Running {
+		if err := writeFormat(w, "running: %s (%s) for %s\n", holderPID(holder), holder.Command, formatSeconds(now.Sub(holder.Since).Seconds())); err != nil {
+			return err
+		}
+	}
+	if holder := report.Lock.Holder; holder != nil {
+		if err := writeFormat(w, "stamps lock: %s (%s) for %s\n", holderPID(*holder), holder.Command, formatSeconds(now.Sub(holder.Since).Seconds())); err != nil {
+			return err
+		}
+	}
+	for _, waiter := range report.Lock.Queued {
+		if err := writeFormat(w, "queued: %s (%s) for %s\n", holderPID(waiter), waiter.Command, formatSeconds(now.Sub(waiter.Since).Seconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestStatusFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "status")
+	finished := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	record := &lastRun{FinishedAt: finished, ExitCode: 2}
+	pending := true
+	if err := writeStatusFile(path, newStatusSummary(record, &pending, finished)); err != nil {
+		t.Fatalf("writeStatusFile(): %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(status): %v", err)
+	}
+	want := "result=failed\nexitCode=2\nfinishedAt=2026-10-16T09:00:00Z\nfinishedAtUnix=1792141200\npending=true\nupdatedAt=2026-10-16T09:00:00Z\n"
+	if string(content) != want {
+		t.Fatalf("writeStatusFile(): got %q want %q", content, want)
+	}
+
+	// A healthz check of the same run replaces it.
+	checked := finished.Add(time.Hour)
+	if err := refreshStatusFile(path, record, false, checked); err != nil {
+		t.Fatalf("refreshStatusFile(): %v", err)
+	}
+	summary, err := readStatusFile(path)
+	if err != nil || summary.Pending != statusPendingFalse || !summary.UpdatedAt.Equal(checked) {
+		t.Fatalf("readStatusFile(): got %+v, %v want pending false updated at %s", summary, err, checked)
+	}
+
+	// A check of an older run leaves a newer run's status alone.
+	older := &lastRun{FinishedAt: finished.Add(-time.Hour)}
+	if err := refreshStatusFile(path, older, true, checked.Add(time.Minute)); err != nil {
+		t.Fatalf("refreshStatusFile(older): %v", err)
+	}
+	content, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(status): %v", err)
+	}
+	if !strings.Contains(string(content), "result=failed\n") || !strings.Contains(string(content), "pending=false\n") {
+		t.Fatalf("refreshStatusFile(older): got %q want the newer run's status", content)
+	}
+
+	summary = newStatusSummary(&lastRun{FinishedAt: finished}, nil, finished)
+	if summary.Result != "ok" || summary.Pending != statusPendingUnknown {
+		t.Fatalf("newStatusSummary(nil pending): got %+v want result ok, pending unknown", summary)
+	}
+}
+
+func TestReadOnlyQueriesDuringRun(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	finished := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	if err := writeStatusFile(state.StatusPath(home), newStatusSummary(&lastRun{FinishedAt: finished}, nil, finished)); err != nil {
+		t.Fatalf("writeStatusFile(): %v", err)
+	}
+	if err := os.WriteFile(state.EnvFile(home), []byte("export DECOMK_CONTEXT='default'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for i, exitCode := range []int{0, 2} {
+		record := timingRecord{FinishedAt: finished.Add(time.Duration(i) * time.Hour), ExitCode: exitCode, TotalSeconds: 12}
+		if err := appendTimingRecord(state.TimingsPath(home), record, timingHistory); err != nil {
+			t.Fatalf("appendTimingRecord(): %v", err)
+		}
+	}
+
+	// A run holds the stamps lock; the queries must not wait for it.
+	lock, err := state.LockFile(state.StampsLockPath(home))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := lock.Close(); err != nil {
+			t.Errorf("Close(): %v", err)
+		}
+	}()
+	if err := lock.SetHolder(state.LockHolder{PID: os.Getpid(), Command: "decomk run INSTALL", Since: finished}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		run  func([]string, *bytes.Buffer, *bytes.Buffer) (int, error)
+		want []string
+	}{
+		{"status", func(args []string, stdout, stderr *bytes.Buffer) (int, error) { return cmdStatus(args, stdout, stderr) }, []string{"last run: ok (exit 0) at 2026-10-16T09:00:00Z", "pending: unknown", "stamps lock: pid ", "(decomk run INSTALL)"}},
+		{"env", func(args []string, stdout, stderr *bytes.Buffer) (int, error) { return cmdEnv(args, stdout, stderr) }, []string{"export DECOMK_CONTEXT='default'"}},
+		{"history", func(args []string, stdout, stderr *bytes.Buffer) (int, error) {
+			return cmdHistory(args, stdout, stderr)
+		}, []string{"2026-10-16T10:00:00Z  12s  failed (exit 2)\n2026-10-16T09:00:00Z  12s  ok\n"}},
+	}
+	for _, tc := range cases {
+		var stdout, stderr bytes.Buffer
+		code, err := tc.run([]string{"-home", home}, &stdout, &stderr)
+		if err != nil || code != 0 {
+			t.Fatalf("%s: got code %d, err %v want 0, nil", tc.name, code, err)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(stdout.String(), want) {
+				t.Fatalf("%s: got %q want it to contain %q", tc.name, stdout.String(), want)
+			}
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code, err := cmdHistory([]string{"-home", home, "-n", "1", "-o", "json"}, &stdout, &stderr); err != nil || code != 0 {
+		t.Fatalf("history -n 1 -o json: got code %d, err %v want 0, nil", code, err)
+	}
+	if !strings.Contains(stdout.String(), `"exitCode": 2`) || strings.Contains(stdout.String(), `"exitCode": 0`) {
+		t.Fatalf("history -n 1 -o json: got %q want only the newest run", stdout.String())
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// targetDirsTuple is the tuple name mapping targets to the directory their
+// recipes run in (see resolveTargetDirs).
+const targetDirsTuple = "DECOMK_TARGET_DIRS"
+
+// targetDir is a target whose recipe lines run in Dir instead of the stamp
+// dir.
+type targetDir struct {
+	Target string `json:"target"`
+	Dir    string `json:"dir"`
+}
+
+// resolveTargetDirs parses a DECOMK_TARGET_DIRS value: whitespace-separated
+// TARGET=DIR entries, where DIR is an absolute path or the name of a
+// discovered workspace (its directory basename or parsed repo name), which
+// resolves to that workspace's root.
+//
+// DIR does not need to exist yet; an earlier target may create it.
+func resolveTargetDirs(raw string, repos []workspaceRepo) ([]targetDir, error) {
+	var out []targetDir
+	seen := make(map[string]bool)
+	for _, entry := range strings.Fields(raw) {
+		target, dir, ok := strings.Cut(entry, "=")
+		if !ok || target == "" || dir == "" || strings.ContainsAny(target, "%:") {
+			return nil, fmt.Errorf("%s: entry %q must be TARGET=DIR", targetDirsTuple, entry)
+		}
+		if seen[target] {
+			return nil, fmt.Errorf("%s: target %q listed more than once", targetDirsTuple, target)
+		}
+		seen[target] = true
+
+		if filepath.IsAbs(dir) {
+			out = append(out, targetDir{Target: target, Dir: filepath.Clean(dir)})
+			continue
+		}
+		root := ""
+		for _, repo := range repos {
+			if repo.Name == dir || repo.RepoName == dir {
+				root = repo.Root
+				break
+			}
+		}
+		if root == "" {
+			return nil, fmt.Errorf("%s: %s=%s: not an absolute path or a discovered workspace name", targetDirsTuple, target, dir)
+		}
+		out = append(out, targetDir{Target: target, Dir: root})
+	}
+	return out, nil
+}
+
+// renderTargetDirsMakefile renders the make fragment that runs each target's
+// recipe lines in its directory.
+//
+// Each target gets private (so not inherited by prerequisites) target-specific
+// variables: .SHELLFLAGS makes the shell cd to DECOMK_TARGET_DIR before
+// evaluating the recipe line, and DECOMK_STAMP holds the absolute stamp path
+// the recipe should touch, since $@ is relative to the stamp dir. .SHELLFLAGS
+// is recursive so a later -trace-shell fragment can set its
+// DECOMK_SHELL_TRACE prefix.
+//
+// Intent: Let repo-local setup steps run in the repo without cd boilerplate
+// while make, and so stamps, stay in the global stamp dir.
+// Source: DI-giriz (TODO-jirin)
+func renderTargetDirsMakefile(dirs []targetDir) []byte {
+	var b strings.Builder
+	b.WriteString("# generated by decomk from " + targetDirsTuple + "; do not edit\n")
+	for _, d := range dirs {
+		fmt.Fprintf(&b, "%s: private export DECOMK_TARGET_DIR := %s\n", d.Target, makeEscapeValue(d.Dir))
+		fmt.Fprintf(&b, "%s: private export DECOMK_STAMP = $(CURDIR)/$@\n", d.Target)
+		fmt.Fprintf(&b, "%s: private .SHELLFLAGS = $(%s) -c 'cd \"$$DECOMK_TARGET_DIR\" && eval \"$$0\"'\n", d.Target, shellTraceVar)
+	}
+	return []byte(b.String())
+}
+
+// makeEscapeValue escapes s for use as the literal value of a make variable
+// assignment.
+func makeEscapeValue(s string) string {
+	return strings.NewReplacer("$", "$$", "#", `\#`).Replace(s)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestResolveTargetDirs(t *testing.T) {
+	t.Parallel()
+
+	repos := []workspaceRepo{{Root: "/workspaces/app-checkout", Name: "app-checkout", RepoName: "app"}}
+	got, err := resolveTargetDirs("setup=app tools=/opt/tools/../tools", repos)
+	if err != nil {
+		t.Fatalf("resolveTargetDirs() error: %v", err)
+	}
+	want := []targetDir{{Target: "setup", Dir: "/workspaces/app-checkout"}, {Target: "tools", Dir: "/opt/tools"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveTargetDirs(): got %+v want %+v", got, want)
+	}
+
+	for raw, wantErr := range map[string]string{
+		"setup":               "must be TARGET=DIR",
+		"%.o=/tmp":            "must be TARGET=DIR",
+		"a=/x a=/y":           `target "a" listed more than once`,
+		"setup=missing":       "not an absolute path or a discovered workspace name",
+		"setup=relative/path": "not an absolute path or a discovered workspace name",
+	} {
+		if _, err := resolveTargetDirs(raw, repos); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("resolveTargetDirs(%q) error: got %v want substring %q", raw, err, wantErr)
+		}
+	}
+}
+
+func TestRenderTargetDirsMakefile_RunsRecipeInDir(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	stampDir := t.TempDir()
+	workDir := filepath.Join(t.TempDir(), "work #1")
+	if err := os.Mkdir(workDir, 0o755); err != nil {
+		t.Fatalf("Mkdir(workDir): %v", err)
+	}
+	fragment := filepath.Join(t.TempDir(), "targetdirs.mk")
+	if err := os.WriteFile(fragment, renderTargetDirsMakefile([]targetDir{{Target: "setup", Dir: workDir}}), 0o600); err != nil {
+		t.Fatalf("WriteFile(fragment): %v", err)
+	}
+	makefile := filepath.Join(stampDir, "Makefile")
+	if err := os.WriteFile(makefile, []byte("dep:\n\tpwd > dep.pwd\n\ttouch $@\nsetup: dep\n\tpwd > setup.pwd\n\ttouch \"$$DECOMK_STAMP\"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(Makefile): %v", err)
+	}
+
+	cmd := exec.Command("make", "-f", makefile, "-f", fragment, "setup")
+	cmd.Dir = stampDir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("make setup: %v\n%s", err, out.String())
+	}
+	for path, want := range map[string]string{
+		filepath.Join(workDir, "setup.pwd"): workDir,
+		filepath.Join(stampDir, "dep.pwd"):  stampDir,
+		filepath.Join(stampDir, "setup"):    "",
+		filepath.Join(stampDir, "dep"):      "",
+	} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile(%s): %v\n%s", path, err, out.String())
+		}
+		if got := strings.TrimSpace(string(content)); got != want {
+			t.Fatalf("%s: got %q want %q", path, got, want)
+		}
+	}
+}
+
+func TestCmdPlan_TargetDirs(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	workspaces := t.TempDir()
+	app := filepath.Join(workspaces, "app")
+	if err := os.Mkdir(app, 0o755); err != nil {
+		t.Fatalf("Mkdir(app): %v", err)
+	}
+	files := map[string]string{
+		configPath:                         "DEFAULT: DECOMK_TARGET_DIRS=setup=app\n",
+		filepath.Join(confDir, "Makefile"): "setup:\n\tgo mod download\n\ttouch \"$$DECOMK_STAMP\"\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	home := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code, err := cmdPlan([]string{"-home", home, "-workspaces", workspaces, "-config", configPath, "setup"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	for _, needle := range []string{
+		"target dir (setup): " + app + "\n",
+		"makefile (generated): " + state.TargetDirsMakefile(home) + "\n",
+		"go mod download",
+	} {
+		if !strings.Contains(stdout.String(), needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// runLocks are the locks an invocation holds while make runs: the run lock
+// (shared, or exclusive for the whole container) and one exclusive lock per
+// target it may build.
+type runLocks struct {
+	locks []*state.Lock
+	// Waited are the holders of conflicting locks lockRun waited for.
+	Waited []state.LockHolder
+}
+
+// Close releases every held lock.
+func (l *runLocks) Close() error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	for _, lock := range l.locks {
+		errs = append(errs, lock.Close())
+	}
+	l.locks = nil
+	return errors.Join(errs...)
+}
+
+// lockRequest is one lock lockRun needs.
+type lockRequest struct {
+	Path   string
+	Shared bool
+	// Desc names what the lock guards in waiting messages.
+	Desc string
+}
+
+// runLockRequests returns the locks a run of targets needs: the run lock
+// shared and each target's lock, or, with whole (gc, or a run whose targets
+// are unknown), the run lock exclusively.
+func runLockRequests(home string, targets []string, whole bool) []lockRequest {
+	if whole {
+		return []lockRequest{{Path: state.RunLockPath(home), Desc: "every running decomk run"}}
+	}
+	requests := []lockRequest{{Path: state.RunLockPath(home), Shared: true, Desc: "a run that locked the whole container"}}
+	sorted := dedupeStrings(targets)
+	sort.Strings(sorted)
+	for _, target := range sorted {
+		requests = append(requests, lockRequest{Path: state.TargetLockPath(home, target), Desc: "target " + target})
+	}
+	return requests
+}
+
+// lockRun takes the stamps lock and then the run locks for targets, or for
+// the whole container (see runLockRequests). It returns with both held; callers release the stamps
+// lock once setup is done and keep the run locks while make runs.
+//
+// Run locks are only ever tried without waiting while the stamps lock is
+// held. On a conflict lockRun releases everything, reports what it waits for
+// to w, waits for that lock alone, and starts over, so no process waits while
+// holding the stamps lock and lock order cannot deadlock.
+//
+// Intent: Let invocations with disjoint target sets run concurrently while
+// conflicting ones still serialize.
+// Source: DI-fubuv (TODO-jirin)
+func lockRun(home string, self state.LockHolder, targets []string, whole bool, w io.Writer) (*state.Lock, *runLocks, error) {
+	requests := runLockRequests(home, targets, whole)
+	var waited []state.LockHolder
+	for {
+		stamps, err := lockStamps(home, self.Command, w)
+		if err != nil {
+			return nil, nil, err
+		}
+		held, conflict, err := tryLockRequests(requests, self)
+		if err != nil {
+			return nil, nil, errors.Join(err, held.Close(), stamps.Close())
+		}
+		if conflict == nil {
+			for _, lock := range held.locks {
+				if err := reportReclaimed(w, lock); err != nil {
+					return nil, nil, errors.Join(err, held.Close(), stamps.Close())
+				}
+			}
+			held.Waited = waited
+			return stamps, held, nil
+		}
+		if err := errors.Join(held.Close(), stamps.Close()); err != nil {
+			return nil, nil, err
+		}
+		if holder, ok, err := state.ReadLockHolder(conflict.Path); err == nil && ok {
+			waited = append(waited, holder)
+		}
+		if err := writeLine(w, lockConflictMessage(*conflict, time.Now())); err != nil {
+			return nil, nil, err
+		}
+		lock, err := waitLockRequest(*conflict)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := lock.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// tryLockRequests takes each request without waiting and records holder as
+// the holder of the exclusive ones. It stops at the first request another
+// process holds and returns it as conflict, with the locks taken so far.
+func tryLockRequests(requests []lockRequest, holder state.LockHolder) (*runLocks, *lockRequest, error) {
+	held := &runLocks{}
+	holder.Since = time.Now()
+	for i, request := range requests {
+		var lock *state.Lock
+		var ok bool
+		var err error
+		if request.Shared {
+			lock, ok, err = state.TryLockFileShared(request.Path)
+		} else {
+			lock, ok, err = state.TryLockFile(request.Path)
+		}
+		if err != nil {
+			return held, nil, fmt.Errorf("lock %s: %w", request.Desc, err)
+		}
+		if !ok {
+			return held, &requests[i], nil
+		}
+		held.locks = append(held.locks, lock)
+		if !request.Shared {
+			if err := lock.SetHolder(holder); err != nil {
+				return held, nil, err
+			}
+		}
+	}
+	return held, nil, nil
+}
+
+// waitLockRequest takes request, waiting as long as it takes.
+func waitLockRequest(request lockRequest) (*state.Lock, error) {
+	if request.Shared {
+		return state.LockFileShared(request.Path)
+	}
+	return state.LockFile(request.Path)
+}
+
+// lockConflictMessage describes the lock a run waits for and, when recorded,
+// the run holding it.
+func lockConflictMessage(conflict lockRequest, now time.Time) string {
+	msg := "decomk: waiting for " + conflict.Desc
+	if holder, ok, err := state.ReadLockHolder(conflict.Path); err == nil && ok {
+		msg += fmt.Sprintf(", locked by %s (%s) for %s", holderPID(holder), holder.Command, formatSeconds(now.Sub(holder.Since).Seconds()))
+	}
+	return msg
+}
+
+// runLockTargets returns the targets a run locks: every target with a recipe
+// in the make closure of targets, plus extra (forced or interrupted targets
+// whose stamps the run deletes).
+func runLockTargets(plan *resolvedPlan, targets, extra, makeTuples, makeEnv []string) ([]string, error) {
+	rules, err := cachedMakeRules(plan.Home, plan.StampDir, planMakefiles(plan), makeTuples, makeEnv)
+	if err != nil {
+		return nil, err
+	}
+	closure := recipeClosure(rules, targets)
+	return dedupeStrings(append(closure, extra...)), nil
+}
+
+// touchTargetStamps updates the mtime of the existing stamps of targets, the
+// per-run counterpart of state.TouchExistingStamps that leaves other runs'
+// stamps alone.
+func touchTargetStamps(stampDir string, targets []string, now time.Time) error {
+	for _, target := range targets {
+		if !filepath.IsLocal(target) || strings.HasPrefix(filepath.Base(target), ".") {
+			continue
+		}
+		path := filepath.Join(stampDir, target)
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := os.Chtimes(path, now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropSatisfiedTargets re-checks targets with make -q after lockRun waited
+// for the runs in waited, and returns the targets still out of date,
+// reporting each up-to-date one to w as satisfied by those runs.
+//
+// Intent: Stop racing lifecycle hooks from redoing, or re-logging, work
+// another run just finished.
+// Source: DI-tuvik (TODO-jirin)
+func dropSatisfiedTargets(plan *resolvedPlan, targets []string, waited []state.LockHolder, makeTuples, makeEnv []string, w io.Writer) ([]string, error) {
+	var by []string
+	for _, holder := range waited {
+		if holder.RunID != "" {
+			by = append(by, "run "+holder.RunID)
+		} else {
+			by = append(by, fmt.Sprintf("%s (%s)", holderPID(holder), holder.Command))
+		}
+	}
+	satisfied := make(map[string]bool)
+	for _, target := range dedupeStrings(targets) {
+		pending, err := makeQuestion(plan.StampDir, lastRunMakeArgs(planMakefiles(plan), makeTuples, []string{target}), makeEnv)
+		if err != nil {
+			return nil, err
+		}
+		if pending {
+			continue
+		}
+		satisfied[target] = true
+		if err := writeLine(w, "decomk:", target, "satisfied by", strings.Join(dedupeStrings(by), ", ")); err != nil {
+			return nil, err
+		}
+	}
+	var remaining []string
+	for _, target := range targets {
+		if !satisfied[target] {
+			remaining = append(remaining, target)
+		}
+	}
+	return remaining, nil
+}
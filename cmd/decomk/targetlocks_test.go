@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestLockRun_DisjointTargetsRunConcurrently(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	first, firstHeld, err := lockRun(home, state.LockHolder{PID: os.Getpid(), Command: "decomk run A"}, []string{"Block00", "Block10"}, false, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("lockRun(first): %v", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close(first stamps lock): %v", err)
+	}
+	defer func() {
+		if err := firstHeld.Close(); err != nil {
+			t.Errorf("Close(first run locks): %v", err)
+		}
+	}()
+
+	// Disjoint targets do not wait for the first run.
+	second, secondHeld, err := lockRun(home, state.LockHolder{PID: os.Getpid(), Command: "decomk run B"}, []string{"Block20"}, false, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("lockRun(second): %v", err)
+	}
+	status, err := readStampsLockStatus(home)
+	if err != nil {
+		t.Fatalf("readStampsLockStatus(): %v", err)
+	}
+	if len(status.Running) != 1 || status.Running[0].Command != "decomk run A" {
+		t.Fatalf("readStampsLockStatus() running: got %+v want the first run (both runs share this pid)", status.Running)
+	}
+	if err := errors.Join(second.Close(), secondHeld.Close()); err != nil {
+		t.Fatalf("Close(second): %v", err)
+	}
+}
+
+func TestLockRun_ConflictingTargetsWait(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		targets    []string
+		whole      bool
+		wantOutput string
+		wantWaited int
+	}{
+		{name: "target", targets: []string{"Block10", "Block00"}, wantOutput: "decomk: waiting for target Block00, locked by pid " + strconv.Itoa(os.Getpid()) + " (decomk run A)", wantWaited: 1},
+		{name: "whole", whole: true, wantOutput: "decomk: waiting for every running decomk run"},
+	}
+	for _, tc := range cases {
+		home := t.TempDir()
+		first, firstHeld, err := lockRun(home, state.LockHolder{PID: os.Getpid(), Command: "decomk run A", RunID: "run-a"}, []string{"Block00"}, false, &bytes.Buffer{})
+		if err != nil {
+			t.Fatalf("%s: lockRun(first): %v", tc.name, err)
+		}
+		if err := first.Close(); err != nil {
+			t.Fatalf("%s: Close(first stamps lock): %v", tc.name, err)
+		}
+
+		var stderr bytes.Buffer
+		type result struct {
+			stamps *state.Lock
+			held   *runLocks
+			err    error
+		}
+		done := make(chan result, 1)
+		go func() {
+			stamps, held, err := lockRun(home, state.LockHolder{PID: os.Getpid(), Command: "decomk run B"}, tc.targets, tc.whole, &stderr)
+			done <- result{stamps, held, err}
+		}()
+
+		select {
+		case got := <-done:
+			t.Fatalf("%s: second lockRun did not wait for the first run: %+v", tc.name, got)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if err := firstHeld.Close(); err != nil {
+			t.Fatalf("%s: Close(first run locks): %v", tc.name, err)
+		}
+		got := <-done
+		if got.err != nil {
+			t.Fatalf("%s: second lockRun: %v", tc.name, got.err)
+		}
+		if len(got.held.Waited) != tc.wantWaited || (tc.wantWaited > 0 && got.held.Waited[0].RunID != "run-a") {
+			t.Fatalf("%s: second lockRun waited: got %+v want %d holder(s) of run-a", tc.name, got.held.Waited, tc.wantWaited)
+		}
+		if err := errors.Join(got.held.Close(), got.stamps.Close()); err != nil {
+			t.Fatalf("%s: Close(second): %v", tc.name, err)
+		}
+		if !strings.HasPrefix(stderr.String(), tc.wantOutput) {
+			t.Fatalf("%s: second lockRun output: got %q want prefix %q", tc.name, stderr.String(), tc.wantOutput)
+		}
+	}
+}
+
+func TestLockConflictMessage(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	since := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	lock, err := state.LockFile(state.TargetLockPath(home, "Block00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := lock.Close(); err != nil {
+			t.Errorf("Close(): %v", err)
+		}
+	}()
+	if err := lock.SetHolder(state.LockHolder{PID: os.Getpid(), Command: "decomk run INSTALL", Since: since}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := runLockRequests(home, []string{"Block00"}, false)[1]
+	got := lockConflictMessage(request, since.Add(90*time.Second))
+	want := "decomk: waiting for target Block00, locked by pid " + strconv.Itoa(os.Getpid()) + " (decomk run INSTALL) for 1m30s"
+	if got != want {
+		t.Fatalf("lockConflictMessage(): got %q want %q", got, want)
+	}
+}
+
+func TestTouchTargetStamps(t *testing.T) {
+	t.Parallel()
+
+	stampDir := t.TempDir()
+	old := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"mine", "theirs"} {
+		path := filepath.Join(stampDir, name)
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := old.Add(time.Hour)
+	if err := touchTargetStamps(stampDir, []string{"mine", "missing", "../escape"}, now); err != nil {
+		t.Fatalf("touchTargetStamps(): %v", err)
+	}
+	for name, want := range map[string]time.Time{"mine": now, "theirs": old} {
+		info, err := os.Stat(filepath.Join(stampDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(want) {
+			t.Fatalf("%s mtime: got %v want %v", name, info.ModTime(), want)
+		}
+	}
+}
+
+func TestDropSatisfiedTargets(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	makefile := filepath.Join(dir, "Makefile")
+	if err := os.WriteFile(makefile, []byte("done todo:\n\ttouch $@\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stampDir := filepath.Join(dir, "stamps")
+	if err := os.MkdirAll(stampDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stampDir, "done"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &resolvedPlan{Home: t.TempDir(), StampDir: stampDir, Makefiles: []string{makefile}}
+	waited := []state.LockHolder{{PID: 42, Command: "decomk run INSTALL", RunID: "20261016T090000.000000000Z-42"}}
+	var stdout bytes.Buffer
+	got, err := dropSatisfiedTargets(plan, []string{"done", "todo"}, waited, nil, os.Environ(), &stdout)
+	if err != nil {
+		t.Fatalf("dropSatisfiedTargets(): %v", err)
+	}
+	if want := []string{"todo"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dropSatisfiedTargets(): got %q want %q", got, want)
+	}
+	if got, want := stdout.String(), "decomk: done satisfied by run 20261016T090000.000000000Z-42\n"; got != want {
+		t.Fatalf("dropSatisfiedTargets() output: got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// targetList is what `decomk targets` reports.
+type targetList struct {
+	// Targets are the explicit Makefile targets, sorted.
+	Targets []string `json:"targets"`
+	// Actions are the tuple names usable as plan/run action args: every
+	// whitespace-separated word of their value is a Makefile target.
+	Actions []string `json:"actions"`
+	// Cached is true when the list came from the targets cache.
+	Cached bool `json:"cached"`
+}
+
+// targetsCache is the cached targetList for one invocation key.
+type targetsCache struct {
+	Key     string       `json:"key"`
+	Inputs  []inputStamp `json:"inputs"`
+	Targets []string     `json:"targets"`
+	Actions []string     `json:"actions"`
+}
+
+// inputStamp identifies one version of a file the list was computed from.
+type inputStamp struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// cmdTargets lists the Makefile targets and action tuple names of the
+// resolved plan, from the targets cache when none of its inputs changed.
+//
+// Intent: Back shell completion and IDE pickers without paying for full
+// resolution and a make database read on every keystroke.
+// Source: DI-jajit (TODO-jirin)
+func cmdTargets(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk targets", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+	addCommonFlags(fs, &f)
+	var output string
+	var asJSON, refresh bool
+	addOutputFlag(fs, &output)
+	fs.BoolVar(&asJSON, "json", false, "same as -o json")
+	fs.BoolVar(&refresh, "refresh", false, "ignore the targets cache and resolve again")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("targets does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if asJSON {
+		output = outputJSON
+	}
+	if err := checkOutputFormat(output); err != nil {
+		return 2, err
+	}
+	if err := applyStartDir(f.startDir); err != nil {
+		return 1, err
+	}
+	home, err := state.Home(f.home)
+	if err != nil {
+		return 1, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return 1, err
+	}
+	key := targetsCacheKey(args, cwd, os.Environ())
+	cachePath := state.TargetsCachePath(home)
+
+	var list targetList
+	if cached, ok := readTargetsCache(cachePath, key); ok && !refresh {
+		list = targetList{Targets: cached.Targets, Actions: cached.Actions, Cached: true}
+	} else {
+		plan, rules, err := planMakeRules(f)
+		if err != nil {
+			return 1, err
+		}
+		list = listTargets(plan, rules)
+		entry := targetsCache{Key: key, Inputs: planInputStamps(plan), Targets: list.Targets, Actions: list.Actions}
+		if err := writeTargetsCache(cachePath, entry); err != nil {
+			if warnErr := writeLine(stderr, "decomk: warning:", err.Error()); warnErr != nil {
+				return 1, warnErr
+			}
+		}
+	}
+
+	if output != outputText {
+		if err := writeStructured(stdout, output, list); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	// One name per line, for `compgen -W "$(decomk targets)"`.
+	for _, name := range dedupeStrings(append(append([]string(nil), list.Actions...), list.Targets...)) {
+		if err := writeLine(stdout, name); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// listTargets returns the explicit targets of rules, without special targets
+// and the makefiles themselves, and the tuple names of plan that expand to
+// targets only.
+func listTargets(plan *resolvedPlan, rules makeRules) targetList {
+	makefiles := make(map[string]bool)
+	for _, makefile := range planMakefiles(plan) {
+		makefiles[makefile] = true
+	}
+	list := targetList{Targets: []string{}, Actions: []string{}}
+	for name := range rules.Prereqs {
+		if strings.HasPrefix(name, ".") || makefiles[name] {
+			continue
+		}
+		list.Targets = append(list.Targets, name)
+	}
+	sort.Strings(list.Targets)
+	for name, value := range effectiveTupleValues(plan.Tuples) {
+		words := strings.Fields(value)
+		if len(words) == 0 || strings.HasPrefix(name, "DECOMK_") {
+			continue
+		}
+		actionable := true
+		for _, word := range words {
+			if !rules.Defines(word) {
+				actionable = false
+				break
+			}
+		}
+		if actionable {
+			list.Actions = append(list.Actions, name)
+		}
+	}
+	sort.Strings(list.Actions)
+	return list
+}
+
+// targetsCacheKey identifies an invocation: its args, working directory, and
+// DECOMK_* environment, which together select the config and contexts.
+func targetsCacheKey(args []string, cwd string, environ []string) string {
+	var env []string
+	for _, entry := range environ {
+		if strings.HasPrefix(entry, "DECOMK_") {
+			env = append(env, entry)
+		}
+	}
+	sort.Strings(env)
+	parts := append(append([]string{cwd}, args...), env...)
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// planInputStamps stamps the config files, makefiles, and workspace configs
+// plan was resolved from. Files that cannot be stat'ed are left out.
+func planInputStamps(plan *resolvedPlan) []inputStamp {
+	paths := append(append([]string(nil), plan.ConfigPaths...), plan.Makefiles...)
+	for _, ns := range plan.Namespaces {
+		paths = append(paths, ns.Path)
+	}
+	for _, overlay := range plan.WorkspaceConfigs {
+		if overlay.Skipped == "" {
+			paths = append(paths, overlay.Path)
+		}
+	}
+	var stamps []inputStamp
+	for _, path := range dedupeStrings(paths) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		stamps = append(stamps, inputStamp{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return stamps
+}
+
+// readTargetsCache returns the cache at path when it was written for key and
+// every input is unchanged.
+func readTargetsCache(path, key string) (targetsCache, bool) {
+	var cache targetsCache
+	content, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(content, &cache) != nil || cache.Key != key || len(cache.Inputs) == 0 {
+		return targetsCache{}, false
+	}
+	for _, input := range cache.Inputs {
+		info, err := os.Stat(input.Path)
+		if err != nil || info.Size() != input.Size || !info.ModTime().Equal(input.ModTime) {
+			return targetsCache{}, false
+		}
+	}
+	return cache, true
+}
+
+// writeTargetsCache replaces the cache at path with cache.
+func writeTargetsCache(path string, cache targetsCache) error {
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("encode targets cache: %w", err)
+	}
+	if err := state.AtomicWrite(path, content, 0o644); err != nil {
+		return fmt.Errorf("write targets cache %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCmdTargets(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "decomk.conf")
+	makefilePath := filepath.Join(dir, "Makefile")
+	files := map[string]string{
+		configPath:   "DEFAULT: INSTALL='Block00 Block10' GREETING=hello DECOMK_MAKEFILES=" + makefilePath + "\n",
+		makefilePath: ".PHONY: all\nall: Block00 Block10\nBlock00:\n\t@true\nBlock10: Block00\n\t@true\nstamp-%:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+	home := t.TempDir()
+	args := []string{"-home", home, "-workspaces", t.TempDir(), "-config", configPath, "-json"}
+
+	list := func(extra ...string) targetList {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		if code, err := cmdTargets(append(append([]string(nil), args...), extra...), &stdout, &stderr); err != nil || code != 0 {
+			t.Fatalf("cmdTargets(%q): code=%d err=%v (stderr=%q)", extra, code, err, stderr.String())
+		}
+		var got targetList
+		if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+			t.Fatalf("cmdTargets() output %q: %v", stdout.String(), err)
+		}
+		return got
+	}
+
+	want := targetList{Targets: []string{"Block00", "Block10", "all"}, Actions: []string{"INSTALL"}}
+	if got := list(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("cmdTargets(): got %+v want %+v", got, want)
+	}
+	want.Cached = true
+	if got := list(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("cmdTargets() second call: got %+v want %+v", got, want)
+	}
+
+	// A changed Makefile invalidates the cache.
+	if err := os.WriteFile(makefilePath, []byte(files[makefilePath]+"Block20:\n\t@true\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(Makefile): %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(makefilePath, later, later); err != nil {
+		t.Fatalf("Chtimes(Makefile): %v", err)
+	}
+	want = targetList{Targets: []string{"Block00", "Block10", "Block20", "all"}, Actions: []string{"INSTALL"}}
+	if got := list(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("cmdTargets() after Makefile change: got %+v want %+v", got, want)
+	}
+	if got := list("-refresh"); got.Cached {
+		t.Fatalf("cmdTargets(-refresh): got a cached list want a fresh one")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// telemetryURLTuple names the endpoint that receives run reports.
+	telemetryURLTuple = "DECOMK_TELEMETRY_URL"
+	// telemetryOptIn enables reporting (1/true/yes/on) or disables it
+	// (0/false/no/off). The environment value wins over a tuple.
+	telemetryOptIn = "DECOMK_TELEMETRY"
+	// telemetryTimeout bounds how long a report can delay the end of a run.
+	telemetryTimeout = 5 * time.Second
+	// telemetrySchema versions the report format.
+	telemetrySchema = 1
+)
+
+// telemetryReport is the anonymous body posted after a run: durations,
+// counts, target names, and platform facts, never paths or env values.
+type telemetryReport struct {
+	Schema        int            `json:"schema"`
+	Version       string         `json:"version"`
+	OS            string         `json:"os"`
+	Arch          string         `json:"arch"`
+	Distro        string         `json:"distro,omitempty"`
+	DistroVersion string         `json:"distroVersion,omitempty"`
+	NProc         int            `json:"nproc"`
+	ExitCode      int            `json:"exitCode"`
+	TotalSeconds  float64        `json:"totalSeconds"`
+	TargetsOK     int            `json:"targetsOk"`
+	TargetsFailed int            `json:"targetsFailed"`
+	TargetsSkip   int            `json:"targetsSkipped"`
+	Targets       []targetTiming `json:"targets,omitempty"`
+}
+
+// telemetryEndpoint returns the endpoint to report to, or "" when reporting
+// is off. tuples are the config's effective values; getenv reads the
+// environment.
+//
+// Intent: Let platform teams measure fleet-wide bootstrap performance without
+// collecting logs from every container.
+// Source: DI-sovut (TODO-jirin)
+func telemetryEndpoint(tuples map[string]string, getenv func(string) string) (string, error) {
+	optIn := strings.TrimSpace(getenv(telemetryOptIn))
+	if optIn == "" {
+		optIn = strings.TrimSpace(tuples[telemetryOptIn])
+	}
+	switch strings.ToLower(optIn) {
+	case "", "0", "false", "no", "off":
+		return "", nil
+	case "1", "true", "yes", "on":
+	default:
+		return "", fmt.Errorf("invalid %s %q (expected on or off)", telemetryOptIn, optIn)
+	}
+	raw := strings.TrimSpace(tuples[telemetryURLTuple])
+	if raw == "" {
+		return "", fmt.Errorf("%s is on but no %s tuple names an endpoint", telemetryOptIn, telemetryURLTuple)
+	}
+	endpoint, err := url.Parse(raw)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return "", fmt.Errorf("invalid %s %q (expected an http or https URL)", telemetryURLTuple, raw)
+	}
+	return raw, nil
+}
+
+// newTelemetryReport builds the report for a run from its timing record and
+// platform variables (see platformVars).
+func newTelemetryReport(timing timingRecord, platform map[string]string) telemetryReport {
+	report := telemetryReport{
+		Schema:        telemetrySchema,
+		Version:       decomkVersion,
+		OS:            platform["DECOMK_OS"],
+		Arch:          platform["DECOMK_ARCH"],
+		Distro:        platform["DECOMK_DISTRO"],
+		DistroVersion: platform["DECOMK_DISTRO_VERSION"],
+		ExitCode:      timing.ExitCode,
+		TotalSeconds:  timing.TotalSeconds,
+		Targets:       timing.Targets,
+	}
+	if n, err := strconv.Atoi(platform["DECOMK_NPROC"]); err == nil {
+		report.NProc = n
+	}
+	for _, target := range timing.Targets {
+		switch target.Status {
+		case timingStatusOK:
+			report.TargetsOK++
+		case timingStatusFailed:
+			report.TargetsFailed++
+		case timingStatusSkipped:
+			report.TargetsSkip++
+		}
+	}
+	return report
+}
+
+// postTelemetry posts report as JSON to endpoint and checks for a 2xx status.
+func postTelemetry(client *http.Client, endpoint string, report telemetryReport) (err error) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("encode telemetry report: %w", err)
+	}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("post telemetry: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close telemetry response: %w", closeErr))
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post telemetry to %s: HTTP %s", endpoint, resp.Status)
+	}
+	return nil
+}
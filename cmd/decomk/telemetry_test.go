@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTelemetryEndpoint(t *testing.T) {
+	t.Parallel()
+
+	const endpoint = "https://metrics.example.com/decomk"
+	cases := []struct {
+		tuples  map[string]string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{tuples: map[string]string{telemetryURLTuple: endpoint}},
+		{tuples: map[string]string{telemetryURLTuple: endpoint, telemetryOptIn: "on"}, want: endpoint},
+		{tuples: map[string]string{telemetryURLTuple: endpoint}, env: map[string]string{telemetryOptIn: "1"}, want: endpoint},
+		{tuples: map[string]string{telemetryURLTuple: endpoint, telemetryOptIn: "on"}, env: map[string]string{telemetryOptIn: "off"}},
+		{tuples: map[string]string{telemetryOptIn: "yes"}, wantErr: true},
+		{tuples: map[string]string{telemetryURLTuple: "/var/log/x", telemetryOptIn: "yes"}, wantErr: true},
+		{tuples: map[string]string{telemetryURLTuple: endpoint, telemetryOptIn: "maybe"}, wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := telemetryEndpoint(c.tuples, func(key string) string { return c.env[key] })
+		if got != c.want || (err != nil) != c.wantErr {
+			t.Fatalf("telemetryEndpoint(%v, %v): got %q, %v want %q (error %v)", c.tuples, c.env, got, err, c.want, c.wantErr)
+		}
+	}
+}
+
+func TestPostTelemetry(t *testing.T) {
+	t.Parallel()
+
+	var received telemetryReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	timing := newTimingRecord(time.Now(), 2, 3*time.Second, []string{"Block00", "Block10"}, []targetRun{
+		{Target: "Block00", Elapsed: time.Second},
+		{Target: "Block10", Elapsed: 2 * time.Second, Err: errors.New("exit status 2"), ExitCode: 2},
+	})
+	platform := map[string]string{"DECOMK_OS": "linux", "DECOMK_ARCH": "amd64", "DECOMK_DISTRO": "ubuntu", "DECOMK_DISTRO_VERSION": "24.04", "DECOMK_NPROC": "8"}
+	report := newTelemetryReport(timing, platform)
+	if report.TargetsOK != 1 || report.TargetsFailed != 1 || report.NProc != 8 || report.ExitCode != 2 {
+		t.Fatalf("newTelemetryReport(): got %+v want 1 ok, 1 failed, nproc 8, exit 2", report)
+	}
+	if err := postTelemetry(server.Client(), server.URL, report); err != nil {
+		t.Fatalf("postTelemetry(): %v", err)
+	}
+	if !reflect.DeepEqual(received, report) {
+		t.Fatalf("postTelemetry() body: got %+v want %+v", received, report)
+	}
+
+	err := postTelemetry(server.Client(), server.URL+"/missing", report)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Fatalf("postTelemetry(404): got %v want an HTTP status error", err)
+	}
+}
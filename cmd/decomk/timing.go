@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+const (
+	// timingHistory is how many runs the timings file keeps.
+	timingHistory = 50
+	// defaultTimingTop is how many of the slowest targets `profile timing`
+	// lists.
+	defaultTimingTop = 10
+	// timingBarWidth is the width of a 100% bar in the breakdown.
+	timingBarWidth = 40
+)
+
+// Target timing statuses.
+const (
+	timingStatusOK      = "ok"
+	timingStatusFailed  = "failed"
+	timingStatusSkipped = "skipped"
+)
+
+// timingRecord is one run's wall-clock timing, stored as one line of the
+// timings file.
+type timingRecord struct {
+	FinishedAt   time.Time `json:"finishedAt"`
+	ExitCode     int       `json:"exitCode"`
+	TotalSeconds float64   `json:"totalSeconds"`
+	// Targets has one entry per top-level target when the run timed them
+	// individually; it is empty when several targets shared one make
+	// invocation.
+	Targets []targetTiming `json:"targets,omitempty"`
+	// Usage is what the run's make process tree consumed.
+	Usage *resourceUsage `json:"usage,omitempty"`
+}
+
+// targetTiming is one top-level target's wall-clock time within a run.
+type targetTiming struct {
+	Target  string  `json:"target"`
+	Seconds float64 `json:"seconds"`
+	Status  string  `json:"status"`
+}
+
+// newTimingRecord builds a run's timing record from the per-target runs, or,
+// when the run used one make invocation, from its total if that invocation
+// built a single target.
+//
+// Intent: Show where provisioning time goes and whether it is regressing,
+// without a separate profiling mode.
+// Source: DI-gutaj (TODO-jirin)
+func newTimingRecord(finishedAt time.Time, exitCode int, total time.Duration, targets []string, runs []targetRun) timingRecord {
+	record := timingRecord{FinishedAt: finishedAt, ExitCode: exitCode, TotalSeconds: total.Seconds()}
+	for _, run := range runs {
+		timing := targetTiming{Target: run.Target, Seconds: run.Elapsed.Seconds(), Status: timingStatusOK}
+		switch {
+		case run.SkippedFor != "":
+			timing.Status = timingStatusSkipped
+		case run.Err != nil:
+			timing.Status = timingStatusFailed
+		}
+		record.Targets = append(record.Targets, timing)
+	}
+	if distinct := dedupeStrings(targets); len(runs) == 0 && len(distinct) == 1 {
+		status := timingStatusOK
+		if exitCode != 0 {
+			status = timingStatusFailed
+		}
+		record.Targets = []targetTiming{{Target: distinct[0], Seconds: record.TotalSeconds, Status: status}}
+	}
+	return record
+}
+
+// appendTimingRecord adds record to the timings file at path, keeping only the
+// newest keep records.
+func appendTimingRecord(path string, record timingRecord, keep int) error {
+	records, err := readTimingRecords(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	records = append(records, record)
+	if len(records) > keep {
+		records = records[len(records)-keep:]
+	}
+	var content bytes.Buffer
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("encode timing record: %w", err)
+		}
+		content.Write(line)
+		content.WriteByte('\n')
+	}
+	if err := state.AtomicWrite(path, content.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write timings %s: %w", path, err)
+	}
+	return nil
+}
+
+// readTimingRecords loads the timings file at path, oldest first.
+func readTimingRecords(path string) ([]timingRecord, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []timingRecord
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var record timingRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("decode timings %s:%d: %w", path, i+1, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// cmdProfileTiming reports the last run's slowest targets, their trend against
+// earlier runs, and each target's share of the run.
+func cmdProfileTiming(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk profile timing", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var homeFlag, output string
+	var top int
+	fs.StringVar(&homeFlag, "home", "", "decomk home directory (overrides DECOMK_HOME)")
+	fs.IntVar(&top, "top", defaultTimingTop, "number of slowest targets to list (0 lists all)")
+	addOutputFlag(fs, &output)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 0 {
+		return 2, fmt.Errorf("profile timing does not accept positional args: %q", strings.Join(fs.Args(), " "))
+	}
+	if top < 0 {
+		return 2, fmt.Errorf("invalid -top %d (expected a non-negative integer)", top)
+	}
+	if err := checkOutputFormat(output); err != nil {
+		return 2, err
+	}
+	home, err := state.Home(homeFlag)
+	if err != nil {
+		return 1, err
+	}
+	path := state.TimingsPath(home)
+	records, err := readTimingRecords(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 1, fmt.Errorf("no recorded run timings (%s); run decomk run first", path)
+		}
+		return 1, err
+	}
+	if len(records) == 0 {
+		return 1, fmt.Errorf("no recorded run timings (%s); run decomk run first", path)
+	}
+	if output != outputText {
+		// Structured output is the whole recorded history, oldest first.
+		if err := writeStructured(stdout, output, records); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	if err := writeTimingReport(stdout, records, top); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// writeTimingReport writes the report for the newest record in records.
+//
+// A target's previous time comes from the most recent earlier run that timed
+// it, so runs of other actions do not break its trend.
+func writeTimingReport(w io.Writer, records []timingRecord, top int) error {
+	last := records[len(records)-1]
+	if err := writeFormat(w, "last run: %s, exit %d, total %s\n", last.FinishedAt.UTC().Format(time.RFC3339), last.ExitCode, formatSeconds(last.TotalSeconds)); err != nil {
+		return err
+	}
+	if last.Usage != nil {
+		if err := writeLine(w, "resources:", formatUsage(*last.Usage)); err != nil {
+			return err
+		}
+	}
+	if len(records) > 1 {
+		previous := records[len(records)-2]
+		if err := writeFormat(w, "previous run: %s, total %s (%s)\n", previous.FinishedAt.UTC().Format(time.RFC3339), formatSeconds(previous.TotalSeconds), formatDelta(last.TotalSeconds, previous.TotalSeconds)); err != nil {
+			return err
+		}
+	}
+	if len(last.Targets) == 0 {
+		return writeLine(w, "no per-target timings: the run built several targets in one make invocation (use decomk run -parallel N)")
+	}
+
+	slowest := append([]targetTiming(nil), last.Targets...)
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].Seconds > slowest[j].Seconds })
+	if top > 0 && top < len(slowest) {
+		slowest = slowest[:top]
+	}
+	if err := writeLine(w, "slowest targets:"); err != nil {
+		return err
+	}
+	for _, timing := range slowest {
+		trend := "no previous run"
+		if previous, ok := previousTargetSeconds(records[:len(records)-1], timing.Target); ok {
+			trend = "previous " + formatSeconds(previous) + ", " + formatDelta(timing.Seconds, previous)
+		}
+		if err := writeFormat(w, "  %10s  %-7s  %s  (%s)\n", formatSeconds(timing.Seconds), timing.Status, timing.Target, trend); err != nil {
+			return err
+		}
+	}
+
+	// Concurrent targets overlap, so shares are of their summed time rather
+	// than the run's wall-clock total.
+	sum := 0.0
+	for _, timing := range last.Targets {
+		sum += timing.Seconds
+	}
+	if err := writeLine(w, "breakdown (share of summed target time):"); err != nil {
+		return err
+	}
+	for _, timing := range last.Targets {
+		share := 0.0
+		if sum > 0 {
+			share = timing.Seconds / sum
+		}
+		bar := strings.Repeat("#", int(share*timingBarWidth+0.5))
+		if err := writeFormat(w, "  %-*s %5.1f%%  %s\n", timingBarWidth, bar, share*100, timing.Target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previousTargetSeconds returns target's time in the newest of records that
+// timed it.
+func previousTargetSeconds(records []timingRecord, target string) (float64, bool) {
+	for i := len(records) - 1; i >= 0; i-- {
+		for _, timing := range records[i].Targets {
+			if timing.Target == target {
+				return timing.Seconds, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// formatSeconds renders seconds as a duration rounded for reading.
+func formatSeconds(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	if d >= time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// formatDelta renders the change from previous to current, with a percentage
+// when previous is non-zero.
+func formatDelta(current, previous float64) string {
+	delta := current - previous
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+	}
+	text := sign + formatSeconds(max(delta, -delta))
+	if previous > 0 {
+		text += fmt.Sprintf(" %s%.0f%%", sign, max(delta, -delta)/previous*100)
+	}
+	return text
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+func TestNewTimingRecord(t *testing.T) {
+	t.Parallel()
+
+	finished := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	runs := []targetRun{
+		{Target: "Block00", Elapsed: 2 * time.Second},
+		{Target: "Block10", Elapsed: time.Second, Err: errors.New("exit status 2"), ExitCode: 2},
+		{Target: "Block20", SkippedFor: "Block10"},
+	}
+	record := newTimingRecord(finished, 2, 3*time.Second, []string{"Block00", "Block10", "Block20"}, runs)
+	want := []targetTiming{
+		{Target: "Block00", Seconds: 2, Status: timingStatusOK},
+		{Target: "Block10", Seconds: 1, Status: timingStatusFailed},
+		{Target: "Block20", Seconds: 0, Status: timingStatusSkipped},
+	}
+	if !reflect.DeepEqual(record.Targets, want) {
+		t.Fatalf("newTimingRecord(runs).Targets: got %+v want %+v", record.Targets, want)
+	}
+
+	record = newTimingRecord(finished, 0, 5*time.Second, []string{"Block00", "Block00"}, nil)
+	want = []targetTiming{{Target: "Block00", Seconds: 5, Status: timingStatusOK}}
+	if !reflect.DeepEqual(record.Targets, want) {
+		t.Fatalf("newTimingRecord(one target).Targets: got %+v want %+v", record.Targets, want)
+	}
+
+	record = newTimingRecord(finished, 0, 5*time.Second, []string{"Block00", "Block10"}, nil)
+	if len(record.Targets) != 0 || record.TotalSeconds != 5 {
+		t.Fatalf("newTimingRecord(one invocation): got %+v", record)
+	}
+}
+
+func TestAppendTimingRecord_KeepsNewest(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "timings.jsonl")
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		record := timingRecord{FinishedAt: start.Add(time.Duration(i) * time.Hour), TotalSeconds: float64(i)}
+		if err := appendTimingRecord(path, record, 3); err != nil {
+			t.Fatalf("appendTimingRecord(%d): %v", i, err)
+		}
+	}
+	records, err := readTimingRecords(path)
+	if err != nil {
+		t.Fatalf("readTimingRecords(): %v", err)
+	}
+	var got []float64
+	for _, record := range records {
+		got = append(got, record.TotalSeconds)
+	}
+	if want := []float64{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("readTimingRecords() totals: got %v want %v", got, want)
+	}
+}
+
+func TestCmdProfileTiming_YAMLHistory(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	record := timingRecord{
+		FinishedAt:   time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC),
+		TotalSeconds: 2.5,
+		Targets:      []targetTiming{{Target: "Block00", Seconds: 2.5, Status: timingStatusOK}},
+	}
+	if err := appendTimingRecord(state.TimingsPath(home), record, timingHistory); err != nil {
+		t.Fatalf("appendTimingRecord(): %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	code, err := cmdProfileTiming([]string{"-home", home, "-o", "yaml"}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdProfileTiming(-o yaml): code=%d err=%v", code, err)
+	}
+	want := `- finishedAt: "2026-10-16T09:00:00Z"
+  exitCode: 0
+  totalSeconds: 2.5
+  targets:
+    - target: Block00
+      seconds: 2.5
+      status: ok
+`
+	if got := stdout.String(); got != want {
+		t.Fatalf("cmdProfileTiming(-o yaml): got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteTimingReport(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	records := []timingRecord{
+		{FinishedAt: start, TotalSeconds: 40, Targets: []targetTiming{
+			{Target: "install-go", Seconds: 20, Status: timingStatusOK},
+		}},
+		{FinishedAt: start.Add(time.Hour), TotalSeconds: 10, Targets: []targetTiming{
+			{Target: "install-node", Seconds: 10, Status: timingStatusOK},
+		}},
+		{FinishedAt: start.Add(2 * time.Hour), ExitCode: 1, TotalSeconds: 45, Targets: []targetTiming{
+			{Target: "install-jq", Seconds: 5, Status: timingStatusFailed},
+			{Target: "install-go", Seconds: 30, Status: timingStatusOK},
+			{Target: "install-node", Seconds: 5, Status: timingStatusOK},
+			{Target: "install-rust", Seconds: 0, Status: timingStatusSkipped},
+		}},
+	}
+	var out bytes.Buffer
+	if err := writeTimingReport(&out, records, 3); err != nil {
+		t.Fatalf("writeTimingReport(): %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"last run: 2026-10-16T11:00:00Z, exit 1, total 45s",
+		"previous run: 2026-10-16T10:00:00Z, total 10s (+35s +350%)",
+		"slowest targets:",
+		"         30s  ok       install-go  (previous 20s, +10s +50%)",
+		"          5s  failed   install-jq  (no previous run)",
+		"          5s  ok       install-node  (previous 10s, -5s -50%)",
+		"breakdown (share of summed target time):",
+		"  " + strings.Repeat("#", 5) + strings.Repeat(" ", timingBarWidth-5) + "  12.5%  install-jq",
+		"  " + strings.Repeat("#", 30) + strings.Repeat(" ", timingBarWidth-30) + "  75.0%  install-go",
+	}
+	if len(lines) != 11 {
+		t.Fatalf("writeTimingReport(): got %d lines want 11:\n%s", len(lines), out.String())
+	}
+	for i, wantLine := range want {
+		if lines[i] != wantLine {
+			t.Fatalf("writeTimingReport() line %d: got %q want %q", i, lines[i], wantLine)
+		}
+	}
+}
+
+func TestWriteTimingReport_NoPerTargetTimes(t *testing.T) {
+	t.Parallel()
+
+	records := []timingRecord{{FinishedAt: time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC), TotalSeconds: 90}}
+	var out bytes.Buffer
+	if err := writeTimingReport(&out, records, 0); err != nil {
+		t.Fatalf("writeTimingReport(): %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "total 1m30s\n") || !strings.Contains(got, "no per-target timings") {
+		t.Fatalf("writeTimingReport(): got %q", got)
+	}
+}
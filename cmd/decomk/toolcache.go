@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// toolBuildCacheKeep is how many cached tool builds survive pruning.
+const toolBuildCacheKeep = 10
+
+// exactModuleVersion matches a go module version that names one commit: a
+// release tag or a pseudo-version, but not a branch or query like @stable.
+var exactModuleVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+incompatible)?$`)
+
+// toolBuildEnv returns the environment for `go install` of the tool:
+// -trimpath, so builds of one commit are identical wherever the source
+// lives, and a module cache under home that survives container rebuilds.
+// GOFLAGS is extended rather than replaced, and an explicit GOMODCACHE wins.
+func toolBuildEnv(home string) []string {
+	goflags := os.Getenv("GOFLAGS")
+	if !strings.Contains(" "+goflags+" ", " -trimpath ") {
+		goflags = strings.TrimSpace(goflags + " -trimpath")
+	}
+	env := []string{"GOFLAGS=" + goflags}
+	if os.Getenv("GOMODCACHE") == "" {
+		env = append(env, "GOMODCACHE="+state.GoModCacheDir(home))
+	}
+	return env
+}
+
+// toolBuildCachePath returns where the build of source key for the target
+// platform is cached, or "" when key is empty (the source is not pinned to
+// one commit).
+func toolBuildCachePath(home, key string) string {
+	if key == "" {
+		return ""
+	}
+	goos, goarch := os.Getenv("GOOS"), os.Getenv("GOARCH")
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	return filepath.Join(state.ToolBuildCacheDir(home), state.SafeComponent(key)+"-"+goos+"-"+goarch)
+}
+
+// goToolCacheKey returns the cache key for a go: URI payload
+// (package@version), or "" when the version is a branch or query whose
+// commit is only known after go resolves it.
+func goToolCacheKey(payload string) string {
+	i := strings.LastIndexByte(payload, '@')
+	if i < 0 || !exactModuleVersion.MatchString(payload[i+1:]) {
+		return ""
+	}
+	return payload
+}
+
+// restoreCachedTool copies the cached build at entry into stageDir and
+// reports whether there was one.
+func restoreCachedTool(entry, stageDir string) (bool, error) {
+	if entry == "" {
+		return false, nil
+	}
+	content, err := os.ReadFile(entry)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read cached tool build: %w", err)
+	}
+	if err := state.AtomicWrite(filepath.Join(stageDir, "decomk"), content, 0o755); err != nil {
+		return false, fmt.Errorf("stage cached tool build: %w", err)
+	}
+	// The modification time orders entries for pruning.
+	now := time.Now()
+	if err := os.Chtimes(entry, now, now); err != nil {
+		return false, fmt.Errorf("touch cached tool build: %w", err)
+	}
+	return true, nil
+}
+
+// cacheToolBuild stores binary as the cache entry, then prunes the cache to
+// its toolBuildCacheKeep most recently used builds.
+//
+// Intent: Reuse the binary built for a commit and platform instead of
+// rebuilding it on every container start that runs self-update.
+// Source: DI-kosid (TODO-jirin)
+func cacheToolBuild(entry, binary string) error {
+	content, err := os.ReadFile(binary)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", binary, err)
+	}
+	if err := state.AtomicWrite(entry, content, 0o755); err != nil {
+		return fmt.Errorf("cache tool build: %w", err)
+	}
+	dir := filepath.Dir(entry)
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read tool build cache: %w", err)
+	}
+	type cached struct {
+		path    string
+		modTime time.Time
+	}
+	var builds []cached
+	for _, de := range dirEntries {
+		if strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return fmt.Errorf("stat tool build cache entry: %w", err)
+		}
+		if info.Mode().IsRegular() {
+			builds = append(builds, cached{filepath.Join(dir, de.Name()), info.ModTime()})
+		}
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].modTime.After(builds[j].modTime) })
+	for _, build := range builds[min(toolBuildCacheKeep, len(builds)):] {
+		if err := os.Remove(build.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("prune tool build cache: %w", err)
+		}
+	}
+	return nil
+}
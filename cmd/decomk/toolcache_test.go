@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolBuildCache(t *testing.T) {
+	t.Setenv("GOOS", "linux")
+	t.Setenv("GOARCH", "amd64")
+
+	home := t.TempDir()
+	key := goToolCacheKey("example.com/decomk@v0.4.2")
+	entry := toolBuildCachePath(home, key)
+	if want := filepath.Join(home, "decomk", "bin", "cache", "example.com%2Fdecomk@v0.4.2-linux-amd64"); entry != want {
+		t.Fatalf("toolBuildCachePath(): got %q want %q", entry, want)
+	}
+
+	// Miss: nothing is staged.
+	stageDir := t.TempDir()
+	cached, err := restoreCachedTool(entry, stageDir)
+	if err != nil || cached {
+		t.Fatalf("restoreCachedTool(miss): got cached=%v err=%v want false, nil", cached, err)
+	}
+	if fileExists(filepath.Join(stageDir, "decomk")) {
+		t.Fatalf("restoreCachedTool(miss) staged a binary")
+	}
+
+	// Hit: the cached build is staged as the candidate.
+	built := filepath.Join(t.TempDir(), "decomk")
+	writeFileFixture(t, built, []byte("build v0.4.2 linux/amd64"), 0o755)
+	if err := cacheToolBuild(entry, built); err != nil {
+		t.Fatalf("cacheToolBuild(): %v", err)
+	}
+	cached, err = restoreCachedTool(entry, stageDir)
+	if err != nil || !cached {
+		t.Fatalf("restoreCachedTool(hit): got cached=%v err=%v want true, nil", cached, err)
+	}
+	content, err := os.ReadFile(filepath.Join(stageDir, "decomk"))
+	if err != nil {
+		t.Fatalf("ReadFile(staged): %v", err)
+	}
+	if got, want := string(content), "build v0.4.2 linux/amd64"; got != want {
+		t.Fatalf("staged binary: got %q want %q", got, want)
+	}
+
+	// Platform mismatch: the same source for another GOARCH misses.
+	t.Setenv("GOARCH", "arm64")
+	other := toolBuildCachePath(home, key)
+	if other == entry {
+		t.Fatalf("toolBuildCachePath(arm64): got %q, same as amd64", other)
+	}
+	cached, err = restoreCachedTool(other, t.TempDir())
+	if err != nil || cached {
+		t.Fatalf("restoreCachedTool(arm64): got cached=%v err=%v want false, nil", cached, err)
+	}
+
+	// Moving versions are never cached.
+	if got := toolBuildCachePath(home, goToolCacheKey("example.com/decomk@stable")); got != "" {
+		t.Fatalf("toolBuildCachePath(@stable): got %q want \"\"", got)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sshKeyFixture generates an unencrypted ed25519 key in dir and returns the
+// private key path and the public key line.
+func sshKeyFixture(t *testing.T, dir, name string) (key, pub string) {
+	t.Helper()
+	key = filepath.Join(dir, name)
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	content, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatalf("ReadFile(%s.pub): %v", key, err)
+	}
+	return key, strings.TrimSpace(string(content))
+}
+
+func TestVerifyToolSignature(t *testing.T) {
+	t.Parallel()
+	for _, tool := range []string{"git", "ssh-keygen"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " not installed")
+		}
+	}
+
+	keys := t.TempDir()
+	trusted, trustedPub := sshKeyFixture(t, keys, "trusted")
+	other, _ := sshKeyFixture(t, keys, "other")
+	signers := filepath.Join(keys, "allowed_signers")
+	writeFileFixture(t, signers, []byte("test@example.com "+trustedPub+"\n"), 0o644)
+
+	upstream := t.TempDir()
+	runGitFixture(t, upstream, "init", "-q", "-b", "main")
+	runGitFixture(t, upstream, "-c", "gpg.format=ssh", "-c", "user.signingkey="+trusted, "commit", "-q", "-S", "--allow-empty", "-m", "trusted")
+
+	clone := filepath.Join(t.TempDir(), "decomk")
+	runGitFixture(t, filepath.Dir(clone), "clone", "-q", upstream, clone)
+	if err := verifyToolSignature(clone, "", signers); err != nil {
+		t.Fatalf("verifyToolSignature(trusted commit): %v", err)
+	}
+
+	runGitFixture(t, upstream, "-c", "gpg.format=ssh", "-c", "user.signingkey="+other, "commit", "-q", "-S", "--allow-empty", "-m", "other")
+	runGitFixture(t, clone, "pull", "-q", "--ff-only")
+	err := verifyToolSignature(clone, "", signers)
+	if err == nil || !strings.Contains(err.Error(), "is not signed by a key in") {
+		t.Fatalf("verifyToolSignature(untrusted commit): got %v want an untrusted-key error", err)
+	}
+
+	runGitFixture(t, upstream, "commit", "-q", "--allow-empty", "-m", "unsigned")
+	runGitFixture(t, clone, "pull", "-q", "--ff-only")
+	err = verifyToolSignature(clone, "", signers)
+	if err == nil || !strings.Contains(err.Error(), "is not signed with an SSH key") {
+		t.Fatalf("verifyToolSignature(unsigned commit): got %v want an unsigned error", err)
+	}
+
+	// A signed tag vouches for the unsigned commit it was fetched by.
+	runGitFixture(t, upstream, "-c", "gpg.format=ssh", "-c", "user.signingkey="+trusted, "tag", "-s", "-m", "release", "v1.0.0")
+	runGitFixture(t, clone, "fetch", "-q", "origin", "v1.0.0")
+	runGitFixture(t, clone, "checkout", "-q", "--detach", "FETCH_HEAD")
+	if err := verifyToolSignature(clone, "v1.0.0", signers); err != nil {
+		t.Fatalf("verifyToolSignature(signed tag): %v", err)
+	}
+
+	if err := verifyToolSignature(clone, "", filepath.Join(keys, "missing")); err == nil {
+		t.Fatalf("verifyToolSignature(missing signers file): got nil want error")
+	}
+}
+
+func TestUpdateTool_RequireSignedRejectsGoURI(t *testing.T) {
+	t.Parallel()
+
+	opts := toolUpdateOptions{Home: t.TempDir(), ToolURI: "go:example.com/decomk@stable", Target: "/nonexistent/decomk", RequireSigned: true}
+	_, err := updateTool(opts, nil)
+	if err == nil || !strings.Contains(err.Error(), "needs a git: tool URI") {
+		t.Fatalf("updateTool(go: URI, RequireSigned): got %v want a git: URI error", err)
+	}
+}
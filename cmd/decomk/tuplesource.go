@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stevegt/decomk/resolve"
+)
+
+const (
+	// envTuplePrefix marks a tuple value taken from decomk's environment:
+	// NAME=@env:OTHER or NAME=@env:OTHER:default.
+	envTuplePrefix = "@env:"
+
+	// fileTuplePrefix marks a tuple value read from a file in the config
+	// tree: NAME=@file:relative/path.
+	fileTuplePrefix = "@file:"
+
+	// maxFileTupleBytes caps the size of one @file: value.
+	maxFileTupleBytes = 64 << 10
+)
+
+// envTuple records one tuple whose value was taken from the environment.
+type envTuple struct {
+	Name      string
+	Var       string
+	Defaulted bool
+}
+
+// resolveEnvTuples replaces every NAME=@env:OTHER[:default] tuple with the
+// value of OTHER in env.
+//
+// When OTHER is unset the default is used (an empty default is allowed with a
+// trailing ":"); with no default, an unset OTHER is an error. A set but empty
+// OTHER is used as-is.
+//
+// Intent: Forward selected host or devcontainer variables into the tuple set
+// under names config controls, instead of recipes reading the ambient
+// environment.
+// Source: DI-zajij (TODO-jirin)
+func resolveEnvTuples(tuples []string, env map[string]string) ([]string, []envTuple, error) {
+	out := make([]string, 0, len(tuples))
+	var sourced []envTuple
+	for _, tuple := range tuples {
+		name, value, ok := resolve.SplitTuple(tuple)
+		if !ok || !strings.HasPrefix(value, envTuplePrefix) {
+			out = append(out, tuple)
+			continue
+		}
+		other, fallback, hasDefault := strings.Cut(strings.TrimPrefix(value, envTuplePrefix), ":")
+		if !resolve.IsIdent(other) {
+			return nil, nil, fmt.Errorf("%s: %s%s: %q is not a variable name", name, envTuplePrefix, other, other)
+		}
+		envValue, set := env[other]
+		switch {
+		case set:
+			value = envValue
+		case hasDefault:
+			value = fallback
+		default:
+			return nil, nil, fmt.Errorf("%s: %s is not set and %s%s has no default", name, other, envTuplePrefix, other)
+		}
+		sourced = append(sourced, envTuple{Name: name, Var: other, Defaulted: !set})
+		out = append(out, name+"="+value)
+	}
+	return out, sourced, nil
+}
+
+// fileTuple records one tuple whose value was read from a file.
+type fileTuple struct {
+	Name string
+	Path string
+	Size int
+}
+
+// resolveFileTuples replaces every NAME=@file:PATH tuple with the contents of
+// PATH, resolved against baseDir (the explicit config's directory, else the
+// conf dir).
+//
+// PATH must stay inside baseDir after following symlinks. Files larger than
+// maxFileTupleBytes or containing NUL bytes are errors, and one trailing
+// newline is dropped so ordinary text files give single-line values.
+//
+// Intent: Let long or multiline values (certificates, JSON) live as
+// reviewable files in the config repo, without letting config pull arbitrary
+// host files into the environment.
+// Source: DI-kolam (TODO-jirin)
+func resolveFileTuples(tuples []string, baseDir string) ([]string, []fileTuple, error) {
+	out := make([]string, 0, len(tuples))
+	var files []fileTuple
+	for _, tuple := range tuples {
+		name, value, ok := resolve.SplitTuple(tuple)
+		if !ok || !strings.HasPrefix(value, fileTuplePrefix) {
+			out = append(out, tuple)
+			continue
+		}
+		path, content, err := readFileTuple(baseDir, strings.TrimPrefix(value, fileTuplePrefix))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files = append(files, fileTuple{Name: name, Path: path, Size: len(content)})
+		out = append(out, name+"="+content)
+	}
+	return out, files, nil
+}
+
+// readFileTuple reads one @file: value.
+func readFileTuple(baseDir, rel string) (string, string, error) {
+	if rel == "" || filepath.IsAbs(rel) {
+		return "", "", fmt.Errorf("%s%s: path must be relative to %s", fileTuplePrefix, rel, baseDir)
+	}
+	if !filepath.IsLocal(rel) {
+		return "", "", fmt.Errorf("%s%s: resolves outside %s", fileTuplePrefix, rel, baseDir)
+	}
+	base, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return "", "", fmt.Errorf("resolve config dir: %w", err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(base, rel))
+	if err != nil {
+		return "", "", fmt.Errorf("%s%s: %w", fileTuplePrefix, rel, err)
+	}
+	if inside, err := filepath.Rel(base, path); err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("%s%s: resolves outside %s", fileTuplePrefix, rel, baseDir)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", "", fmt.Errorf("%s: not a regular file", path)
+	}
+	if info.Size() > maxFileTupleBytes {
+		return "", "", fmt.Errorf("%s: %d bytes exceeds the %d-byte limit", path, info.Size(), maxFileTupleBytes)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	if bytes.IndexByte(content, 0) >= 0 {
+		return "", "", fmt.Errorf("%s: contains NUL bytes", path)
+	}
+	text := strings.TrimSuffix(string(content), "\n")
+	return path, strings.TrimSuffix(text, "\r"), nil
+}
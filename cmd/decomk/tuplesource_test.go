@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveEnvTuples(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{"HOST_USER": "alice", "EMPTY": ""}
+	got, sourced, err := resolveEnvTuples([]string{
+		"A=plain",
+		"USER_NAME=@env:HOST_USER",
+		"E=@env:EMPTY:fallback",
+		"D=@env:MISSING:x:y",
+		"Z=@env:MISSING:",
+	}, env)
+	if err != nil {
+		t.Fatalf("resolveEnvTuples(): %v", err)
+	}
+	want := []string{"A=plain", "USER_NAME=alice", "E=", "D=x:y", "Z="}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("resolveEnvTuples(): got %q want %q", got, want)
+	}
+	if len(sourced) != 4 || sourced[0] != (envTuple{Name: "USER_NAME", Var: "HOST_USER"}) || !sourced[2].Defaulted {
+		t.Fatalf("resolveEnvTuples() sourced: got %+v", sourced)
+	}
+
+	for value, want := range map[string]string{
+		"@env:MISSING": "MISSING is not set",
+		"@env:1BAD":    "is not a variable name",
+		"@env:":        "is not a variable name",
+	} {
+		_, _, err := resolveEnvTuples([]string{"X=" + value}, env)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("resolveEnvTuples(%s): got %v want %q", value, err, want)
+		}
+	}
+}
+
+func TestCmdPlan_EnvTuples(t *testing.T) {
+	t.Setenv("DECOMK_TEST_HOST_REGION", "eu-west")
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	files := map[string]string{
+		configPath:                         "base: REGION=@env:DECOMK_TEST_UNSET_X\nDEFAULT: base REGION=@env:DECOMK_TEST_HOST_REGION ZONE=@env:DECOMK_TEST_UNSET_Y:a\n",
+		filepath.Join(confDir, "Makefile"): "all:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath, "all"}
+	var stdout, stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	for _, needle := range []string{
+		"env (REGION): DECOMK_TEST_HOST_REGION\n",
+		"env (ZONE): DECOMK_TEST_UNSET_Y (unset; default used)\n",
+		"  REGION=eu-west\n",
+		"  ZONE=a\n",
+	} {
+		if !strings.Contains(stdout.String(), needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, stdout.String())
+		}
+	}
+}
+
+func TestResolveFileTuples(t *testing.T) {
+	t.Parallel()
+
+	base := t.TempDir()
+	outside := t.TempDir()
+	files := map[string]string{
+		filepath.Join(base, "certs", "ca.pem"): "line1\nline2\r\n",
+		filepath.Join(base, "nul.bin"):         "a\x00b",
+		filepath.Join(base, "big.txt"):         strings.Repeat("x", maxFileTupleBytes+1),
+		filepath.Join(outside, "secret"):       "nope\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll(%s): %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(base, "link")); err != nil {
+		t.Fatalf("Symlink(): %v", err)
+	}
+
+	got, read, err := resolveFileTuples([]string{"A=plain", "CA=@file:certs/ca.pem"}, base)
+	if err != nil {
+		t.Fatalf("resolveFileTuples(): %v", err)
+	}
+	if want := []string{"A=plain", "CA=line1\nline2"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("resolveFileTuples(): got %q want %q", got, want)
+	}
+	if len(read) != 1 || read[0].Name != "CA" || read[0].Size != len("line1\nline2") {
+		t.Fatalf("resolveFileTuples() files: got %+v", read)
+	}
+
+	for value, want := range map[string]string{
+		"@file:../x":       "resolves outside",
+		"@file:link":       "resolves outside",
+		"@file:/etc/hosts": "must be relative",
+		"@file:nul.bin":    "contains NUL bytes",
+		"@file:big.txt":    "exceeds the 65536-byte limit",
+		"@file:missing":    "no such file",
+		"@file:certs":      "not a regular file",
+	} {
+		_, _, err := resolveFileTuples([]string{"X=" + value}, base)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("resolveFileTuples(%s): got %v want %q", value, err, want)
+		}
+	}
+}
+
+func TestCmdPlan_FileTuplesDefaultEnvOnly(t *testing.T) {
+	t.Parallel()
+
+	confDir := t.TempDir()
+	configPath := filepath.Join(confDir, "decomk.conf")
+	files := map[string]string{
+		configPath:                          "DEFAULT: CA=@file:ca.pem makeonly TOKEN=@file:token.txt\n",
+		filepath.Join(confDir, "ca.pem"):    "BEGIN\nEND\n",
+		filepath.Join(confDir, "token.txt"): "abc\n",
+		filepath.Join(confDir, "Makefile"):  "all:\n\t@true\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", path, err)
+		}
+	}
+
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath, "all"}
+	var stdout, stderr bytes.Buffer
+	code, err := cmdPlan(args, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("cmdPlan(): code=%d err=%v (stderr=%q)", code, err, stderr.String())
+	}
+	for _, needle := range []string{
+		"file (CA): " + filepath.Join(confDir, "ca.pem") + " (9 bytes)\n",
+		"(envonly)",
+		"  TOKEN=abc  (makeonly)\n",
+	} {
+		if !strings.Contains(stdout.String(), needle) {
+			t.Fatalf("stdout missing %q:\n%s", needle, stdout.String())
+		}
+	}
+}
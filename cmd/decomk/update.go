@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/stevegt/decomk/stage0"
+	"github.com/stevegt/decomk/state"
+	"github.com/stevegt/envi"
+)
+
+// cmdUpdate fetches the config repo and the git tool repo, and either reports
+// what a fast-forward would bring in (-check) or applies it.
+//
+// With -check nothing but the clones' remote-tracking refs changes: decomk
+// prints each repo's incoming commits and how the resolved plan for ARGS
+// would change with the fetched config. Both plans resolve inert: $(exec:...)
+// values print as written, ENC[age:...] values stay encrypted, and no var
+// plugin or remote makefile download runs.
+//
+// Intent: Let operators review upstream config and tool changes, including
+// their effect on the plan, before allowing the fast-forward that stage-0
+// would otherwise apply unseen.
+// Source: DI-zodiz (TODO-jirin)
+func cmdUpdate(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk update", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+	var check, requireSigned bool
+	var toolURI string
+	addCommonFlags(fs, &f)
+	fs.BoolVar(&check, "check", false, "fetch without merging and report incoming commits and plan changes")
+	fs.StringVar(&toolURI, "tool-uri", "", "tool source URI, go:... or git:... (overrides DECOMK_TOOL_URI)")
+	fs.BoolVar(&requireSigned, "require-signed-tool", false, "refuse tool commits not signed by a key in the allowed signers file (also DECOMK_REQUIRE_SIGNED_TOOL)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	actionArgs := fs.Args()
+	if f.profile != "" {
+		return 2, fmt.Errorf("update cannot be used with -profile (a profile does not read the config repo)")
+	}
+	home, err := state.Home(f.home)
+	if err != nil {
+		return 1, err
+	}
+	if toolURI == "" {
+		toolURI = envi.String("DECOMK_TOOL_URI", stage0.DefaultToolURI)
+	}
+
+	confRoot := state.ConfDir(home)
+	if !isGitRepoRoot(confRoot) {
+		if err := writeFormat(stdout, "config repo %s: not a git clone; nothing to fetch\n", confRoot); err != nil {
+			return 1, err
+		}
+	} else {
+		// A ?ref= in DECOMK_CONF_URI pins the config repo like the tool's.
+		confRef := ""
+		if uri := os.Getenv("DECOMK_CONF_URI"); strings.HasPrefix(uri, "git:") {
+			if _, confRef, err = parseToolGitURI(uri); err != nil {
+				return 1, err
+			}
+		}
+		incoming, err := fetchIncoming(confRoot, confRef)
+		if err != nil {
+			return 1, fmt.Errorf("config repo %s: %w", confRoot, err)
+		}
+		if err := reportIncoming(stdout, "config repo "+confRoot, incoming); err != nil {
+			return 1, err
+		}
+		if check && incoming.changed() {
+			if err := reportPlanChanges(stdout, f, incoming, actionArgs); err != nil {
+				return 1, err
+			}
+		}
+		if !check && incoming.changed() {
+			if err := applyIncoming(confRoot, incoming); err != nil {
+				return 1, fmt.Errorf("config repo %s: %w", confRoot, err)
+			}
+			if err := writeFormat(stdout, "config repo %s: updated to %s\n", confRoot, shortRev(incoming.Rev)); err != nil {
+				return 1, err
+			}
+		}
+	}
+
+	if check {
+		if err := reportToolIncoming(stdout, home, toolURI); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	opts := toolUpdateOptions{Home: home, ToolURI: toolURI, RequireSigned: requireSigned}
+	if opts.Target, err = runningExecutable(); err != nil {
+		return 1, err
+	}
+	result, err := updateTool(opts, stderr)
+	if err != nil {
+		return 1, err
+	}
+	if err := reportToolUpdate(stdout, opts, result); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// incomingCommits is what a clone would fast-forward to.
+type incomingCommits struct {
+	// Upstream names what was fetched: a pinned ref, origin/<branch>, or
+	// origin/HEAD.
+	Upstream string
+	// Head and Rev are the current and fetched commit ids.
+	Head, Rev string
+	// Pinned reports that Upstream is a pinned ref, which stage-0 checks out
+	// rather than merging.
+	Pinned bool
+	// FastForward reports whether Rev contains Head.
+	FastForward bool
+	// Commits are the one-line logs of Head..Rev, newest first.
+	Commits []string
+}
+
+// changed reports whether updating would move the clone.
+func (in incomingCommits) changed() bool { return in.Head != in.Rev }
+
+// fetchIncoming fetches origin into the clone at dir, changing only its
+// remote-tracking refs, and returns the commits a stage-0 sync would bring
+// in: ref when set, else the current branch's counterpart on origin, else
+// origin's default branch.
+func fetchIncoming(dir, ref string) (incomingCommits, error) {
+	var in incomingCommits
+	if ref != "" {
+		if err := runGitCommand(dir, "fetch", "--prune", "origin", ref); err != nil {
+			return in, err
+		}
+		in.Upstream, in.Pinned = ref, true
+	} else {
+		if err := runGitCommand(dir, "fetch", "--prune", "origin"); err != nil {
+			return in, err
+		}
+		in.Upstream = "origin/HEAD"
+		if branch, err := gitOutput(dir, "symbolic-ref", "--quiet", "--short", "HEAD"); err == nil && branch != "" {
+			in.Upstream = "origin/" + branch
+		}
+	}
+	rev := in.Upstream
+	if in.Pinned {
+		rev = "FETCH_HEAD"
+	}
+	var err error
+	if in.Rev, err = gitOutput(dir, "rev-parse", "--verify", rev+"^{commit}"); err != nil {
+		return in, fmt.Errorf("resolve %s: %w", in.Upstream, err)
+	}
+	if in.Head, err = gitOutput(dir, "rev-parse", "--verify", "HEAD"); err != nil {
+		return in, fmt.Errorf("resolve HEAD: %w", err)
+	}
+	if in.FastForward, err = gitIsAncestor(dir, in.Head, in.Rev); err != nil {
+		return in, err
+	}
+	log, err := gitOutput(dir, "log", "--oneline", "--no-decorate", in.Head+".."+in.Rev)
+	if err != nil {
+		return in, fmt.Errorf("git log %s..%s: %w", shortRev(in.Head), shortRev(in.Rev), err)
+	}
+	if log != "" {
+		in.Commits = strings.Split(log, "\n")
+	}
+	return in, nil
+}
+
+// gitIsAncestor reports whether commit a is an ancestor of (or equal to) b.
+func gitIsAncestor(dir, a, b string) (bool, error) {
+	err := exec.Command("git", "-C", dir, "merge-base", "--is-ancestor", a, b).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("git merge-base --is-ancestor: %w", err)
+	}
+	return true, nil
+}
+
+// reportIncoming prints the incoming commits for the clone named label.
+func reportIncoming(w io.Writer, label string, in incomingCommits) error {
+	switch {
+	case !in.changed():
+		return writeFormat(w, "%s: up to date with %s (%s)\n", label, in.Upstream, shortRev(in.Head))
+	case len(in.Commits) == 0:
+		return writeFormat(w, "%s: %s moves the checkout from %s back to %s; no incoming commits\n", label, in.Upstream, shortRev(in.Head), shortRev(in.Rev))
+	}
+	note := ""
+	if !in.FastForward && !in.Pinned {
+		note = "; not a fast-forward, so the update will fail until the clone is reconciled"
+	}
+	if err := writeFormat(w, "%s: %d incoming commits from %s (%s..%s)%s\n", label, len(in.Commits), in.Upstream, shortRev(in.Head), shortRev(in.Rev), note); err != nil {
+		return err
+	}
+	for _, commit := range in.Commits {
+		if err := writeLine(w, "  "+commit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyIncoming moves the clean clone at dir to in.Rev the way stage-0 does:
+// a detached checkout for a pinned ref, else a fast-forward merge.
+func applyIncoming(dir string, in incomingCommits) error {
+	status, err := gitOutput(dir, "status", "--porcelain", "--untracked-files=normal")
+	if err != nil {
+		return fmt.Errorf("git status: %w", err)
+	}
+	if status != "" {
+		return fmt.Errorf("git repo has uncommitted changes")
+	}
+	if in.Pinned {
+		return runGitCommand(dir, "checkout", "--detach", in.Rev)
+	}
+	return runGitCommand(dir, "merge", "--ff-only", in.Rev)
+}
+
+// reportPlanChanges resolves the plan for actionArgs against the current
+// config checkout and against a snapshot of in.Rev, and prints the contexts,
+// tuples, and targets that would be added or removed.
+func reportPlanChanges(w io.Writer, f commonFlags, in incomingCommits, actionArgs []string) (err error) {
+	snapshot, err := os.MkdirTemp("", "decomk-update-*")
+	if err != nil {
+		return fmt.Errorf("create config snapshot dir: %w", err)
+	}
+	defer func() {
+		if rmErr := os.RemoveAll(snapshot); rmErr != nil {
+			err = errors.Join(err, fmt.Errorf("remove config snapshot %s: %w", snapshot, rmErr))
+		}
+	}()
+	home, err := state.Home(f.home)
+	if err != nil {
+		return err
+	}
+	archive := filepath.Join(snapshot, "conf.tar.gz")
+	if err := runGitCommand(state.ConfDir(home), "archive", "--format=tar.gz", "-o", archive, in.Rev); err != nil {
+		return err
+	}
+	upstreamRoot := filepath.Join(snapshot, "conf")
+	if err := unpackTarball(archive, upstreamRoot); err != nil {
+		return fmt.Errorf("unpack config snapshot: %w", err)
+	}
+
+	// Both sides resolve inert so the diff compares like with like and the
+	// unreviewed commit cannot run commands, read secrets, or hit the network.
+	f.inert = true
+	before, err := planSummaryLines(f, actionArgs)
+	if err != nil {
+		return writeLine(w, "plan changes: the current config does not resolve:", err.Error())
+	}
+	upstream := f
+	upstream.confRoot = upstreamRoot
+	after, err := planSummaryLines(upstream, actionArgs)
+	if err != nil {
+		return writeLine(w, "plan changes: the incoming config does not resolve:", err.Error())
+	}
+	removed, added := diffLines(before, after)
+	if len(removed) == 0 && len(added) == 0 {
+		return writeLine(w, "plan changes: none")
+	}
+	if err := writeLine(w, "plan changes:"); err != nil {
+		return err
+	}
+	for _, line := range removed {
+		if err := writeLine(w, "  - "+line); err != nil {
+			return err
+		}
+	}
+	for _, line := range added {
+		if err := writeLine(w, "  + "+line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planSummaryLines resolves the plan for f and actionArgs and lists its
+// contexts, tuples (secrets redacted), and targets, one per line.
+func planSummaryLines(f commonFlags, actionArgs []string) ([]string, error) {
+	plan, err := resolvePlanFromFlags(f)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, key := range plan.ContextKeys {
+		lines = append(lines, "context "+key)
+	}
+	for _, tuple := range redactSecretTuples(plan.Tuples, plan.Secrets) {
+		lines = append(lines, "tuple "+tuple)
+	}
+	if err := checkSelectedTargetsAllowed(plan.Allow, plan.LowerTuples, plan.Tuples, actionArgs); err != nil {
+		return nil, err
+	}
+	targets, _, _ := selectTargets(plan.Tuples, actionArgs, nil)
+	for _, target := range targets {
+		lines = append(lines, "target "+target)
+	}
+	return lines, nil
+}
+
+// diffLines returns the lines of before missing from after, and the lines of
+// after missing from before, each in their original order.
+func diffLines(before, after []string) (removed, added []string) {
+	inBefore := make(map[string]bool, len(before))
+	for _, line := range before {
+		inBefore[line] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	for _, line := range after {
+		inAfter[line] = true
+		if !inBefore[line] {
+			added = append(added, line)
+		}
+	}
+	for _, line := range before {
+		if !inAfter[line] {
+			removed = append(removed, line)
+		}
+	}
+	return removed, added
+}
+
+// reportToolIncoming prints the incoming commits of the git tool source
+// clone, or why there is nothing to fetch.
+func reportToolIncoming(w io.Writer, home, toolURI string) error {
+	if !strings.HasPrefix(toolURI, "git:") {
+		return writeFormat(w, "tool %s: not a git source; use decomk self-update -check to compare builds\n", toolURI)
+	}
+	srcDir := state.ToolSrcDir(home)
+	if !isGitRepoRoot(srcDir) {
+		return writeFormat(w, "tool repo %s: not cloned yet; decomk self-update clones it\n", srcDir)
+	}
+	_, ref, err := parseToolGitURI(toolURI)
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		if ref, err = configToolRef(home); err != nil {
+			return err
+		}
+	}
+	incoming, err := fetchIncoming(srcDir, ref)
+	if err != nil {
+		return fmt.Errorf("tool repo %s: %w", srcDir, err)
+	}
+	return reportIncoming(w, "tool repo "+srcDir, incoming)
+}
+
+// shortRev abbreviates a commit id for display.
+func shortRev(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
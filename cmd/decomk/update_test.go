@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// runGitFixture runs git in dir with a fixed identity, failing the test on
+// error, and returns its trimmed output.
+func runGitFixture(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestCmdUpdate_CheckReportsIncomingCommitsAndPlanChanges(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	upstream := t.TempDir()
+	runGitFixture(t, upstream, "init", "-q", "-b", "main")
+	writeFileFixture(t, filepath.Join(upstream, "decomk.conf"), []byte("DEFAULT: NODE=20 TOOLS=base\n"), 0o644)
+	runGitFixture(t, upstream, "add", "decomk.conf")
+	runGitFixture(t, upstream, "commit", "-q", "-m", "initial")
+
+	home := t.TempDir()
+	confRoot := state.ConfDir(home)
+	runGitFixture(t, home, "clone", "-q", upstream, confRoot)
+	head := runGitFixture(t, confRoot, "rev-parse", "HEAD")
+
+	writeFileFixture(t, filepath.Join(upstream, "decomk.conf"), []byte("DEFAULT: NODE=22 TOOLS='base tools'\n"), 0o644)
+	runGitFixture(t, upstream, "commit", "-q", "-am", "Bump node")
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdUpdate([]string{"-check", "-home", home, "-context", "DEFAULT", "-tool-uri", "go:example.com/decomk@stable", "TOOLS"}, &stdout, &stderr)
+	if code != 0 || err != nil {
+		t.Fatalf("cmdUpdate(-check): got %d, %v want 0, nil\n%s", code, err, stderr.String())
+	}
+	got := stdout.String()
+	for _, want := range []string{
+		"config repo " + confRoot + ": 1 incoming commits from origin/main",
+		"Bump node\n",
+		"  - tuple NODE=20\n",
+		"  + tuple NODE=22\n",
+		"  + target tools\n",
+		"tool go:example.com/decomk@stable: not a git source",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("cmdUpdate(-check) output: got %q want it to contain %q", got, want)
+		}
+	}
+	if got := runGitFixture(t, confRoot, "rev-parse", "HEAD"); got != head {
+		t.Fatalf("HEAD after -check: got %s want unchanged %s", got, head)
+	}
+
+	incoming, err := fetchIncoming(confRoot, "")
+	if err != nil {
+		t.Fatalf("fetchIncoming(): %v", err)
+	}
+	if err := applyIncoming(confRoot, incoming); err != nil {
+		t.Fatalf("applyIncoming(): %v", err)
+	}
+	if got := runGitFixture(t, confRoot, "rev-parse", "HEAD"); got != incoming.Rev {
+		t.Fatalf("HEAD after applyIncoming: got %s want %s", got, incoming.Rev)
+	}
+}
+
+func TestCmdUpdate_CheckDoesNotRunIncomingCommands(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	upstream := t.TempDir()
+	runGitFixture(t, upstream, "init", "-q", "-b", "main")
+	writeFileFixture(t, filepath.Join(upstream, "decomk.conf"), []byte("DEFAULT: TOOLS=base\n"), 0o644)
+	runGitFixture(t, upstream, "add", "decomk.conf")
+	runGitFixture(t, upstream, "commit", "-q", "-m", "initial")
+
+	home := t.TempDir()
+	runGitFixture(t, home, "clone", "-q", upstream, state.ConfDir(home))
+
+	pwned := filepath.Join(t.TempDir(), "PWNED")
+	conf := fmt.Sprintf("DEFAULT: TOOLS=base PWN='$(exec:touch %s)' DECOMK_VAR_PLUGINS='touch %s'\n", pwned, pwned)
+	writeFileFixture(t, filepath.Join(upstream, "decomk.conf"), []byte(conf), 0o644)
+	runGitFixture(t, upstream, "commit", "-q", "-am", "Add exec tuple")
+
+	var stdout, stderr bytes.Buffer
+	code, err := cmdUpdate([]string{"-check", "-home", home, "-context", "DEFAULT", "-tool-uri", "go:example.com/decomk@stable", "TOOLS"}, &stdout, &stderr)
+	if code != 0 || err != nil {
+		t.Fatalf("cmdUpdate(-check): got %d, %v want 0, nil\n%s", code, err, stderr.String())
+	}
+	if _, err := os.Stat(pwned); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("stat %s after -check: got %v want not exist (an incoming command ran)", pwned, err)
+	}
+	if want := "  + tuple PWN=$(exec:touch " + pwned + ")\n"; !strings.Contains(stdout.String(), want) {
+		t.Fatalf("cmdUpdate(-check) output: got %q want it to contain %q", stdout.String(), want)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	t.Parallel()
+
+	removed, added := diffLines([]string{"a", "b", "c"}, []string{"c", "d", "a"})
+	if got, want := strings.Join(removed, ","), "b"; got != want {
+		t.Fatalf("diffLines() removed: got %q want %q", got, want)
+	}
+	if got, want := strings.Join(added, ","), "d"; got != want {
+		t.Fatalf("diffLines() added: got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+	"github.com/stevegt/decomk/resolve"
+	"github.com/stevegt/decomk/state"
+)
+
+// varPluginsTuple lists ';'-separated shell commands run as var plugins after
+// the executables in state.VarPluginsDir; a command cannot itself contain ';'.
+const varPluginsTuple = "DECOMK_VAR_PLUGINS"
+
+// pluginVar is one variable a var plugin printed.
+type pluginVar struct {
+	Name  string
+	Value string
+	// Plugin is the executable path or configured command that printed it.
+	Plugin string
+}
+
+// runVarPlugins runs the executables in state.VarPluginsDir(home) in name
+// order, then the vars hook of each exec plugin providing it, then each
+// command in configured (the DECOMK_VAR_PLUGINS value) with /bin/sh -c, and
+// returns the KEY=VALUE lines they print, last assignment of each name
+// winning. Blank and '#' lines are ignored; any other line that is
+// not a tuple, or a plugin that exits non-zero, is an error. Names decomk
+// computes itself are skipped with a warning. When disabled is true
+// (-no-exec-tuples), configured commands are an error.
+//
+// Intent: Let sites derive values such as cloud region or GPU presence once
+// per run, with the same precedence as decomk's own computed variables,
+// without patching decomk.
+// Source: DI-jonoh (TODO-jirin)
+func runVarPlugins(home, configured string, disabled bool, plugins []execPlugin) ([]pluginVar, []string, error) {
+	// labels[i] names argvs[i] in plan output and errors.
+	var argvs [][]string
+	var labels []string
+	entries, err := os.ReadDir(state.VarPluginsDir(home))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("var plugins: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(state.VarPluginsDir(home), entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("var plugin %s: %w", path, err)
+		}
+		if info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0 {
+			argvs = append(argvs, []string{path})
+			labels = append(labels, path)
+		}
+	}
+	for _, argv := range pluginHookArgv(plugins, pluginHookVars) {
+		argvs = append(argvs, argv)
+		labels = append(labels, argv[0])
+	}
+	for _, command := range strings.Split(configured, ";") {
+		command = strings.TrimSpace(command)
+		if command == "" {
+			continue
+		}
+		if disabled {
+			return nil, nil, fmt.Errorf("%s: commands are disabled by -no-exec-tuples/DECOMK_NO_EXEC_TUPLES", varPluginsTuple)
+		}
+		argvs = append(argvs, []string{"/bin/sh", "-c", command})
+		labels = append(labels, command)
+	}
+
+	reserved := make(map[string]bool)
+	for _, name := range contexts.ComputedTupleNames {
+		reserved[name] = true
+	}
+	var vars []pluginVar
+	var warnings []string
+	index := make(map[string]int)
+	for i, argv := range argvs {
+		label := labels[i]
+		output, err := runVarPlugin(argv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("var plugin %s: %w", label, err)
+		}
+		for n, line := range strings.Split(output, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name, value, ok := resolve.SplitTuple(line)
+			if !ok {
+				return nil, nil, fmt.Errorf("var plugin %s: output line %d: expected KEY=VALUE, got %q", label, n+1, line)
+			}
+			if reserved[name] {
+				warnings = append(warnings, fmt.Sprintf("var plugin %s: ignoring %s, which decomk computes itself", label, name))
+				continue
+			}
+			if j, ok := index[name]; ok {
+				vars[j] = pluginVar{Name: name, Value: value, Plugin: label}
+				continue
+			}
+			index[name] = len(vars)
+			vars = append(vars, pluginVar{Name: name, Value: value, Plugin: label})
+		}
+	}
+	return vars, warnings, nil
+}
+
+// runVarPlugin runs one var plugin and returns its stdout.
+func runVarPlugin(argv []string) (string, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return string(output), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/state"
+)
+
+// writeVarPlugin writes an executable shell script into home's plugins dir.
+func writeVarPlugin(t *testing.T, home, name, script string) {
+	t.Helper()
+	dir := state.VarPluginsDir(home)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("MkdirAll(): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+}
+
+func TestRunVarPlugins(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	writeVarPlugin(t, home, "10-cloud", "echo '# cloud facts'\necho REGION=us-east-1\necho GPU=none\n")
+	writeVarPlugin(t, home, "20-gpu", "echo GPU=nvidia\necho DECOMK_OS=plan9\n")
+	if err := os.WriteFile(filepath.Join(state.VarPluginsDir(home), "README"), []byte("not a plugin\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	vars, warnings, err := runVarPlugins(home, "echo ZONE=b; ", false, nil)
+	if err != nil {
+		t.Fatalf("runVarPlugins(): %v", err)
+	}
+	var got []string
+	for _, pv := range vars {
+		got = append(got, pv.Name+"="+pv.Value)
+	}
+	if want := "REGION=us-east-1 GPU=nvidia ZONE=b"; strings.Join(got, " ") != want {
+		t.Fatalf("runVarPlugins(): got %q want %q", got, want)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "DECOMK_OS") {
+		t.Fatalf("runVarPlugins() warnings: got %q want one about DECOMK_OS", warnings)
+	}
+
+	plan := &resolvedPlan{PluginVars: vars}
+	if cv := computedVars(plan, nil); cv["GPU"] != "nvidia" || cv["DECOMK_OS"] == "plan9" {
+		t.Fatalf("computedVars(): got GPU %q DECOMK_OS %q", cv["GPU"], cv["DECOMK_OS"])
+	}
+	tuples := canonicalEnvTuples(plan, nil, nil)
+	if last := tuples[len(tuples)-1]; last != "ZONE=b" {
+		t.Fatalf("canonicalEnvTuples(): last tuple got %q want %q", last, "ZONE=b")
+	}
+
+	if _, _, err := runVarPlugins(home, "echo ZONE=b", true, nil); err == nil {
+		t.Fatalf("runVarPlugins(disabled): got nil want error")
+	}
+	if _, _, err := runVarPlugins(t.TempDir(), "echo not a tuple", false, nil); err == nil || !strings.Contains(err.Error(), "KEY=VALUE") {
+		t.Fatalf("runVarPlugins(bad output): got %v want a KEY=VALUE error", err)
+	}
+	if _, _, err := runVarPlugins(t.TempDir(), "echo oops >&2 && exit 3", false, nil); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("runVarPlugins(failing): got %v want the plugin's stderr", err)
+	}
+}
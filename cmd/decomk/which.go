@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/stevegt/decomk/expand"
+	"github.com/stevegt/decomk/resolve"
+)
+
+// cmdWhich reports where make reads the recipe of TARGET from and which
+// config keys name it in a tuple.
+//
+// Intent: Show where a target comes from when several -f makefiles,
+// generated fragments, or decomk.d layers are in play.
+// Source: DI-nonol (TODO-jirin)
+func cmdWhich(args []string, stdout, stderr io.Writer) (int, error) {
+	fs := flag.NewFlagSet("decomk which", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var f commonFlags
+	addCommonFlags(fs, &f)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, err
+	}
+	if fs.NArg() != 1 {
+		return 2, fmt.Errorf("usage: decomk which [flags] TARGET")
+	}
+	target := fs.Arg(0)
+	if err := applyStartDir(f.startDir); err != nil {
+		return 1, err
+	}
+	plan, rules, err := planMakeRules(f)
+	if err != nil {
+		return 1, err
+	}
+	if err := writeWhich(stdout, plan, rules, target); err != nil {
+		return 1, err
+	}
+	if !rules.Defines(target) {
+		return 1, fmt.Errorf("no Makefile defines target %q", target)
+	}
+	return 0, nil
+}
+
+// writeWhich prints the recipe location of target, the inline decomk.conf
+// recipe it was rendered from (if any), and the keys that reference it.
+func writeWhich(w io.Writer, plan *resolvedPlan, rules makeRules, target string) error {
+	location := "not defined by any Makefile"
+	if _, ok := rules.Prereqs[target]; ok {
+		location = "no recipe (rule without commands)"
+		if from, ok := rules.RecipeFrom[target]; ok {
+			location = from
+		}
+	} else {
+		for _, pattern := range rules.Patterns {
+			if _, ok := patternStem(pattern.Target, target); ok {
+				location = "pattern " + pattern.Target
+				if pattern.RecipeFrom != "" {
+					location = pattern.RecipeFrom + " (pattern " + pattern.Target + ")"
+				}
+				break
+			}
+		}
+	}
+	if err := writeFormat(w, "%s: %s\n", target, location); err != nil {
+		return err
+	}
+	if command, ok := plan.Recipes[target]; ok {
+		if err := writeFormat(w, "  decomk.conf recipe: %s\n", command); err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedDefKeys(plan.Defs) {
+		if names := tuplesNamingTarget(plan.Defs[key], target); len(names) > 0 {
+			if err := writeFormat(w, "  context %s: %s\n", key, strings.Join(names, ", ")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tuplesNamingTarget returns the names of the tuples among tokens (including
+// classed and conditional ones) whose value lists target.
+func tuplesNamingTarget(tokens []string, target string) []string {
+	var names []string
+	for _, token := range tokens {
+		if cond, ok := expand.ParseCondition(token); ok {
+			token = cond.Then
+		}
+		_, tuple := resolve.SplitClass(token)
+		name, value, ok := resolve.SplitTuple(tuple)
+		if !ok {
+			continue
+		}
+		for _, field := range splitTargetList(value) {
+			if field == target {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
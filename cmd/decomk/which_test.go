@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCmdWhich(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "decomk.conf")
+	basePath := filepath.Join(dir, "base.mk")
+	extraPath := filepath.Join(dir, "extra.mk")
+	conf := "TOOLS: INSTALL='install-jq hello'\n" +
+		"DEFAULT: TOOLS ?GPU=1 -> EXTRA=stamp-cuda DECOMK_MAKEFILES='" + basePath + " " + extraPath + "'\n" +
+		"recipe install-jq: touch $@\n"
+	if err := os.WriteFile(configPath, []byte(conf), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(basePath, []byte("# base\nhello: dep\n\t@echo hi\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(base.mk): %v", err)
+	}
+	if err := os.WriteFile(extraPath, []byte("dep:\n\ttouch $@\nstamp-%:\n\ttouch $@\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(extra.mk): %v", err)
+	}
+	args := []string{"-home", t.TempDir(), "-workspaces", t.TempDir(), "-config", configPath}
+
+	cases := []struct {
+		target   string
+		wantCode int
+		want     string
+	}{
+		{target: "hello", want: "hello: " + basePath + ":3\n  context TOOLS: INSTALL\n"},
+		{target: "dep", want: "dep: " + extraPath + ":2\n"},
+		{target: "stamp-go", want: "stamp-go: " + extraPath + ":4 (pattern stamp-%)\n"},
+		{target: "install-jq", want: "install-jq: " + filepath.Join(args[1], "generated", "recipes.mk") + ":4\n  decomk.conf recipe: touch $@\n  context TOOLS: INSTALL\n"},
+		{target: "stamp-cuda", want: "stamp-cuda: " + extraPath + ":4 (pattern stamp-%)\n  context DEFAULT: EXTRA\n"},
+		{target: "nope", wantCode: 1, want: "nope: not defined by any Makefile\n"},
+	}
+	for _, tc := range cases {
+		var stdout, stderr bytes.Buffer
+		code, err := cmdWhich(append(args, tc.target), &stdout, &stderr)
+		if code != tc.wantCode || (err != nil) != (tc.wantCode != 0) {
+			t.Fatalf("cmdWhich(%s): code=%d err=%v want code %d", tc.target, code, err, tc.wantCode)
+		}
+		if got := stdout.String(); got != tc.want {
+			t.Fatalf("cmdWhich(%s): got %q want %q", tc.target, got, tc.want)
+		}
+	}
+}
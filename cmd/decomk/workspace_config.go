@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stevegt/decomk/contexts"
+)
+
+// workspaceConfigKeyPrefix prefixes the synthetic context key a trusted
+// workspace overlay's DEFAULT is renamed to. decomk.conf keys cannot contain
+// ':', so the synthetic keys never collide with shared ones.
+const workspaceConfigKeyPrefix = "workspace:"
+
+// workspaceConfigCandidates are the overlay paths checked, in order, relative
+// to a workspace root.
+var workspaceConfigCandidates = []string{
+	filepath.Join(".decomk", "decomk.conf"),
+	"decomk.conf",
+}
+
+// workspaceConfig describes one workspace-local decomk.conf overlay found
+// during resolution.
+type workspaceConfig struct {
+	Path string
+	// Key is the synthetic context key the overlay's DEFAULT was loaded as;
+	// empty when the overlay was skipped.
+	Key string
+	// Skipped explains why an untrusted overlay was not applied.
+	Skipped string
+	// LowerTuples are the tuples the overlay sets under the root config's
+	// DECOMK_ALLOW list (see lowerTuples); nil without one.
+	LowerTuples map[string][]string
+}
+
+// resolveWorkspaceConfigOwners returns the trusted owner allowlist.
+//
+// Precedence:
+//   - flagOverride (if non-empty)
+//   - DECOMK_WORKSPACE_CONFIG_OWNERS
+//
+// The value is a comma- or space-separated list of GitHub owners; "*" trusts
+// every workspace. An empty list disables workspace overlays.
+func resolveWorkspaceConfigOwners(flagOverride string) []string {
+	raw := flagOverride
+	if raw == "" {
+		raw = os.Getenv("DECOMK_WORKSPACE_CONFIG_OWNERS")
+	}
+	return strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// findWorkspaceConfig returns the first overlay candidate present in root.
+func findWorkspaceConfig(root string) (string, bool) {
+	for _, candidate := range workspaceConfigCandidates {
+		path := filepath.Join(root, candidate)
+		if fileExists(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// workspaceOwnerTrusted reports whether repo's owner is in owners, with a
+// reason when it is not.
+func workspaceOwnerTrusted(repo workspaceRepo, owners []string) (bool, string) {
+	owner := ""
+	ok := false
+	if i := strings.IndexByte(repo.OwnerRepo, '/'); i > 0 {
+		owner, ok = repo.OwnerRepo[:i], true
+	}
+	for _, trusted := range owners {
+		if trusted == "*" || (ok && strings.EqualFold(trusted, owner)) {
+			return true, ""
+		}
+	}
+	if !ok {
+		return false, "workspace has no GitHub owner; only \"*\" in DECOMK_WORKSPACE_CONFIG_OWNERS trusts it"
+	}
+	return false, fmt.Sprintf("owner %q is not in DECOMK_WORKSPACE_CONFIG_OWNERS", owner)
+}
+
+// applyWorkspaceConfigs loads workspace-local decomk.conf overlays for repos
+// whose owner is trusted and merges them into defs and recipes.
+//
+// Each overlay's DEFAULT key is renamed to workspace:<name>; the returned keys
+// are meant to be seeded after all other contexts so overlay tuples take
+// precedence. Other overlay keys and recipes are added as-is but may not
+// redefine shared ones, since that would change other workspaces' contexts,
+// and must match the root config's DECOMK_ALLOW list when it has one, as must
+// every target any overlay token introduces, DEFAULT's included.
+//
+// Intent: Let application repos request extra tools without touching the
+// shared config repo, while keeping untrusted checkouts from introducing
+// targets that run as root.
+// Source: DI-gosar (TODO-jirin)
+func applyWorkspaceConfigs(defs contexts.Defs, recipes contexts.Recipes, repos []workspaceRepo, owners []string) (contexts.Defs, contexts.Recipes, []string, []workspaceConfig, error) {
+	var keys []string
+	var overlays []workspaceConfig
+	for _, repo := range repos {
+		path, ok := findWorkspaceConfig(repo.Root)
+		if !ok {
+			continue
+		}
+		if trusted, reason := workspaceOwnerTrusted(repo, owners); !trusted {
+			overlays = append(overlays, workspaceConfig{Path: path, Skipped: reason})
+			continue
+		}
+
+		overlay, err := contexts.LoadFile(path)
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("workspace config: %w", err)
+		}
+		if _, ok := overlay.Defs["DEFAULT"]; !ok {
+			return nil, nil, nil, nil, fmt.Errorf("workspace config %s: missing DEFAULT key (it holds the workspace's tokens)", path)
+		}
+		if _, ok := overlay.Defs[contexts.AllowKey]; ok {
+			return nil, nil, nil, nil, fmt.Errorf("workspace config %s: %s may only be set in the root config", path, contexts.AllowKey)
+		}
+		key := workspaceConfigKeyPrefix + repo.Name
+		renamed := make(contexts.Defs, len(overlay.Defs))
+		for _, name := range sortedDefKeys(overlay.Defs) {
+			if name == "DEFAULT" {
+				renamed[key] = overlay.Defs[name]
+				continue
+			}
+			if _, exists := defs[name]; exists {
+				return nil, nil, nil, nil, fmt.Errorf("workspace config %s: key %q is already defined by the shared config", path, name)
+			}
+			renamed[name] = overlay.Defs[name]
+		}
+		for name := range overlay.Recipes {
+			if _, exists := recipes[name]; exists {
+				return nil, nil, nil, nil, fmt.Errorf("workspace config %s: recipe %q is already defined by the shared config", path, name)
+			}
+		}
+		var lower map[string][]string
+		// The overlay's DEFAULT is its reason to exist, so its name is exempt
+		// from the root allow-list; its tokens, and everything else the
+		// overlay adds, are not.
+		if allow, ok := defs[contexts.AllowKey]; ok {
+			layer := "workspace config " + path
+			extra := make(contexts.Defs, len(renamed))
+			for name, tokens := range renamed {
+				if name != key {
+					extra[name] = tokens
+				}
+			}
+			if err := checkLayerAllowed(allow, layer, extra, overlay.Recipes, nil, nil); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			if err := checkLayerTokensAllowed(allow, layer, renamed, defs); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			lower = lowerTuples(renamed, defs)
+		}
+		defs = contexts.Merge(defs, renamed, nil)
+		recipes = contexts.MergeRecipes(recipes, overlay.Recipes)
+		keys = append(keys, key)
+		overlays = append(overlays, workspaceConfig{Path: path, Key: key, LowerTuples: lower})
+	}
+	if len(keys) > 0 {
+		if err := contexts.ValidateRefs(defs); err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("workspace config: %w", err)
+		}
+	}
+	return defs, recipes, keys, overlays, nil
+}
+
+// sortedDefKeys returns defs' keys in sorted order.
+func sortedDefKeys(defs contexts.Defs) []string {
+	keys := make([]string, 0, len(defs))
+	for key := range defs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stevegt/decomk/contexts"
+)
+
+// writeWorkspaceConfig writes rel (relative to a fresh workspace root) with
+// content and returns the workspace root.
+func writeWorkspaceConfig(t *testing.T, parent, name, rel, content string) string {
+	t.Helper()
+
+	root := filepath.Join(parent, name)
+	path := filepath.Join(root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("MkdirAll(%s): %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile(%s): %v", path, err)
+	}
+	return root
+}
+
+func TestApplyWorkspaceConfigs_TrustGate(t *testing.T) {
+	t.Parallel()
+
+	parent := t.TempDir()
+	trustedRoot := writeWorkspaceConfig(t, parent, "app", filepath.Join(".decomk", "decomk.conf"),
+		"DEFAULT: TOOLS INSTALL='install-jq'\nTOOLS: JQ_VERSION=1.7\nrecipe install-jq: echo jq && touch $@\n")
+	untrustedRoot := writeWorkspaceConfig(t, parent, "fork", "decomk.conf", "DEFAULT: INSTALL=pwn\n")
+	repos := []workspaceRepo{
+		{Root: trustedRoot, Name: "app", OwnerRepo: "acme/app"},
+		{Root: untrustedRoot, Name: "fork", OwnerRepo: "mallory/fork"},
+		{Root: filepath.Join(parent, "plain"), Name: "plain"},
+	}
+	defs := contexts.Defs{"DEFAULT": {"INSTALL=base"}}
+
+	gotDefs, gotRecipes, keys, overlays, err := applyWorkspaceConfigs(defs, contexts.Recipes{}, repos, []string{"ACME"})
+	if err != nil {
+		t.Fatalf("applyWorkspaceConfigs() error: %v", err)
+	}
+	if want := []string{"workspace:app"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys: got %q want %q", keys, want)
+	}
+	if got, want := gotDefs["workspace:app"], []string{"TOOLS", "INSTALL=install-jq"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("workspace:app tokens: got %q want %q", got, want)
+	}
+	if got, want := gotDefs["DEFAULT"], []string{"INSTALL=base"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("shared DEFAULT changed: got %q want %q", got, want)
+	}
+	if gotRecipes["install-jq"] == "" {
+		t.Fatalf("overlay recipe missing: %#v", gotRecipes)
+	}
+	if len(overlays) != 2 || overlays[1].Key != "" || !strings.Contains(overlays[1].Skipped, `owner "mallory"`) {
+		t.Fatalf("overlays: got %#v", overlays)
+	}
+}
+
+func TestApplyWorkspaceConfigs_RejectsSharedKeyRedefinition(t *testing.T) {
+	t.Parallel()
+
+	root := writeWorkspaceConfig(t, t.TempDir(), "app", "decomk.conf", "DEFAULT: COMMON\nCOMMON: FOO=hijack\n")
+	defs := contexts.Defs{"DEFAULT": {"COMMON"}, "COMMON": {"FOO=bar"}}
+	_, _, _, _, err := applyWorkspaceConfigs(defs, contexts.Recipes{}, []workspaceRepo{{Root: root, Name: "app"}}, []string{"*"})
+	if err == nil || !strings.Contains(err.Error(), `key "COMMON" is already defined by the shared config`) {
+		t.Fatalf("applyWorkspaceConfigs(redefine) error: got %v", err)
+	}
+}
+
+func TestCmdPlan_WorkspaceConfigOverridesSharedTuples(t *testing.T) {
+	t.Parallel()
+
+	workspacesDir := t.TempDir()
+	writeWorkspaceConfig(t, workspacesDir, "app", "decomk.conf", "DEFAULT: FOO=local\n")
+	configPath := filepath.Join(t.TempDir(), "decomk.conf")
+	makefilePath := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(configPath, []byte("DEFAULT: FOO=shared\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(configPath): %v", err)
+	}
+	if err := os.WriteFile(makefilePath, []byte("all:\n\t@true\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile(makefilePath): %v", err)
+	}
+
+	for _, tc := range []struct {
+		owners string
+		want   []string
+	}{
+		{owners: "", want: []string{"FOO=shared"}},
+		{owners: "*", want: []string{"workspaceConfig: " + filepath.Join(workspacesDir, "app", "decomk.conf") + " (context workspace:app)", "FOO=local"}},
+	} {
+		var stdout bytes.Buffer
+		var stderr bytes.Buffer
+		code, err := cmdPlan([]string{
+			"-home", t.TempDir(),
+			"-workspaces", workspacesDir,
+			"-config", configPath,
+			"-makefile", makefilePath,
+			"-workspace-config-owners", tc.owners,
+			"all",
+		}, &stdout, &stderr)
+		if err != nil || code != 0 {
+			t.Fatalf("cmdPlan(owners=%q): code=%d err=%v (stderr=%q)", tc.owners, code, err, stderr.String())
+		}
+		tuples := stdout.String()[strings.Index(stdout.String(), "tuples:"):]
+		for _, needle := range tc.want {
+			if !strings.Contains(stdout.String(), needle) {
+				t.Fatalf("cmdPlan(owners=%q) stdout missing %q:\n%s", tc.owners, needle, stdout.String())
+			}
+		}
+		if tc.owners == "*" && strings.Contains(tuples, "FOO=shared") {
+			t.Fatalf("cmdPlan(owners=*) kept the overridden shared tuple:\n%s", stdout.String())
+		}
+	}
+}
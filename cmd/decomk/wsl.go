@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// wslOSReleasePath holds the kernel release, which names Microsoft under
+	// WSL.
+	wslOSReleasePath = "/proc/sys/kernel/osrelease"
+
+	// wslConfPath is WSL's per-distro config, which may move the drive
+	// automount root.
+	wslConfPath = "/etc/wsl.conf"
+
+	// defaultWSLMountRoot is where WSL mounts Windows drives by default.
+	defaultWSLMountRoot = "/mnt/"
+)
+
+// runningInWSL reports whether decomk runs under Windows Subsystem for Linux.
+func runningInWSL() bool {
+	osrelease, err := os.ReadFile(wslOSReleasePath)
+	if err != nil {
+		osrelease = nil
+	}
+	return detectWSL(os.Getenv, string(osrelease))
+}
+
+// detectWSL reports WSL from its interop environment variables or a kernel
+// release naming Microsoft.
+func detectWSL(getenv func(string) string, osrelease string) bool {
+	if getenv("WSL_DISTRO_NAME") != "" || getenv("WSL_INTEROP") != "" {
+		return true
+	}
+	return strings.Contains(strings.ToLower(osrelease), "microsoft")
+}
+
+// wslMountRoot returns the drive automount root from /etc/wsl.conf
+// ([automount] root = ...), or /mnt/.
+func wslMountRoot() string {
+	content, err := os.ReadFile(wslConfPath)
+	if err != nil {
+		return defaultWSLMountRoot
+	}
+	return parseWSLMountRoot(string(content))
+}
+
+// parseWSLMountRoot reads the [automount] root setting from wsl.conf content.
+func parseWSLMountRoot(content string) string {
+	section := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != "automount" || strings.ToLower(strings.TrimSpace(key)) != "root" {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if strings.HasPrefix(value, "/") {
+			return strings.TrimSuffix(value, "/") + "/"
+		}
+	}
+	return defaultWSLMountRoot
+}
+
+// windowsPathToWSL translates a Windows drive path (`C:\src\repo` or
+// `C:/src/repo`) to where WSL mounts it under mountRoot (`/mnt/c/src/repo`).
+// ok is false for anything else.
+func windowsPathToWSL(p, mountRoot string) (string, bool) {
+	if len(p) < 3 || p[1] != ':' || (p[2] != '\\' && p[2] != '/') {
+		return p, false
+	}
+	drive := p[0] | 0x20
+	if drive < 'a' || drive > 'z' {
+		return p, false
+	}
+	rest := strings.ReplaceAll(p[3:], `\`, "/")
+	return path.Join(mountRoot, string(drive), rest), true
+}
+
+// wslHostPath returns p translated by windowsPathToWSL when decomk runs under
+// WSL, and p unchanged otherwise.
+//
+// Intent: Let users on WSL pass Windows-style paths (for example a workspaces
+// dir copied from Explorer) and get the /mnt/<drive> mount decomk can read.
+// Source: DI-rulaf (TODO-jirin)
+func wslHostPath(p string) string {
+	if !runningInWSL() {
+		return p
+	}
+	if translated, ok := windowsPathToWSL(p, wslMountRoot()); ok {
+		return translated
+	}
+	return p
+}
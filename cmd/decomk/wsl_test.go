@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestDetectWSL(t *testing.T) {
+	t.Parallel()
+
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	cases := []struct {
+		vars      map[string]string
+		osrelease string
+		want      bool
+	}{
+		{vars: map[string]string{"WSL_DISTRO_NAME": "Ubuntu"}, want: true},
+		{vars: map[string]string{"WSL_INTEROP": "/run/WSL/1_interop"}, want: true},
+		{osrelease: "5.15.153.1-microsoft-standard-WSL2\n", want: true},
+		{osrelease: "6.8.0-45-generic\n", want: false},
+	}
+	for _, c := range cases {
+		if got := detectWSL(env(c.vars), c.osrelease); got != c.want {
+			t.Fatalf("detectWSL(%v, %q): got %v want %v", c.vars, c.osrelease, got, c.want)
+		}
+	}
+}
+
+func TestWindowsPathToWSL(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in, mountRoot string
+		want          string
+		ok            bool
+	}{
+		{in: `C:\src\repo`, mountRoot: "/mnt/", want: "/mnt/c/src/repo", ok: true},
+		{in: `d:/Users/me/workspaces/`, mountRoot: "/mnt/", want: "/mnt/d/Users/me/workspaces", ok: true},
+		{in: `E:\`, mountRoot: "/", want: "/e", ok: true},
+		{in: "/already/posix", mountRoot: "/mnt/", want: "/already/posix"},
+		{in: "C:", mountRoot: "/mnt/", want: "C:"},
+		{in: `1:\x`, mountRoot: "/mnt/", want: `1:\x`},
+	}
+	for _, c := range cases {
+		got, ok := windowsPathToWSL(c.in, c.mountRoot)
+		if got != c.want || ok != c.ok {
+			t.Fatalf("windowsPathToWSL(%q, %q): got %q, %v want %q, %v", c.in, c.mountRoot, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestParseWSLMountRoot(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"":                               defaultWSLMountRoot,
+		"[automount]\nroot = /\n":        "/",
+		"[boot]\nroot=/x\n[automount]\n": defaultWSLMountRoot,
+		"[Automount]\nenabled=true\nroot=\"/win\"\n": "/win/",
+	}
+	for content, want := range cases {
+		if got := parseWSLMountRoot(content); got != want {
+			t.Fatalf("parseWSLMountRoot(%q): got %q want %q", content, got, want)
+		}
+	}
+}
+
+func TestParseOwnerRepo_WindowsPath(t *testing.T) {
+	t.Parallel()
+
+	ownerRepo, repoName := parseOwnerRepo(`C:\Users\me\repos\tool.git`)
+	if ownerRepo != "repos/tool" || repoName != "tool" {
+		t.Fatalf("parseOwnerRepo(): got %q, %q want %q, %q", ownerRepo, repoName, "repos/tool", "tool")
+	}
+}
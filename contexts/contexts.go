@@ -11,11 +11,30 @@
 //
 // Supported syntax:
 //   - Whole-line comments start with '#'.
+//   - Doc comments start with "##" and document the key line directly below
+//     them (see Docs).
 //   - Key lines are of the form:   key: token token token
+//   - A key may inherit other keys:   key: inherits PARENT...; token token
+//     (see splitInherits).
+//   - A key may declare how later layers merge with it:
+//     key: merge append; token token (see Merges).
+//   - Conditional tokens:   ?NAME=value -> TOKEN, or ?(EXPR) -> TOKEN where
+//     a ?( group is one token through its closing ")" (see
+//     expand.ParseCondition).
+//   - Class-prefixed tuples:   makeonly NAME=value, envonly NAME=value,
+//     envref NAME=value (noexport is an alias for makeonly; see
+//     resolve.ClassMakeOnly).
 //   - Continuation lines append more tokens to the most recent key.
 //   - Tokens are whitespace-separated shell-words; single quotes may be used
 //     to include spaces inside a token (quotes are removed while parsing).
 //   - Backslash escapes the next rune when not in single quotes.
+//   - Inline recipe lines are of the form:   recipe name: shell command
+//     (see Recipes).
+//   - Tag lines are of the form:   tag name: target target (see Tags).
+//   - The reserved key DECOMK_TOOL_REF pins the decomk tool version
+//     (see ToolRefKey).
+//   - The reserved key DECOMK_ALLOW lists what lower-precedence layers may
+//     define (see AllowKey).
 //
 // Deliberate non-features (MVP):
 //   - No inline comments (only whole-line comments).
@@ -25,32 +44,220 @@ package contexts
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"unicode"
 
+	"github.com/stevegt/decomk/expand"
+	"github.com/stevegt/decomk/recipetmpl"
 	"github.com/stevegt/decomk/resolve"
+	"github.com/stevegt/decomk/toolchain"
 )
 
 // Defs maps a context/macro name to its token list.
 type Defs map[string][]string
 
+// Recipes maps an inline recipe target name to its single shell command line.
+//
+// A recipe line has the form:
+//
+//	recipe install-jq: curl -fsSL -o /usr/local/bin/jq https://... && touch $@
+//
+// The command is kept verbatim (no token splitting or quote removal) and is
+// rendered as the only recipe line of a make target, so make variables such as
+// $@ work as usual. Recipes must fit on one line; continuation lines are not
+// accepted after a recipe line.
+//
+// Intent: Let one-liner targets live next to the contexts that use them instead
+// of requiring a config-repo Makefile edit, while keeping the syntax small
+// enough that decomk.conf does not grow into a second Makefile dialect.
+// Source: DI-zafor (TODO-jirin)
+type Recipes map[string]string
+
+// Docs maps a key to its documentation: the "##" comment lines directly above
+// its key line, with the "##" markers removed and lines joined by newlines.
+//
+//	## Tools every workspace gets.
+//	## Add per-repo tools to the repo's own key instead.
+//	DEFAULT: Block00_base INSTALL=...
+//
+// A blank line, a plain '#' comment, or any other line between the doc lines
+// and the key line drops them. Undocumented keys are absent. A later
+// definition of a key replaces its doc only when it has one of its own, so
+// overlay files can redefine a key without repeating its documentation.
+//
+// Intent: Make config repos self-documenting so users can learn what a context
+// or macro is for from the CLI.
+// Source: DI-zinug (TODO-jirin)
+type Docs map[string]string
+
+// Tags maps a tag name to the make targets it is attached to, in first-seen
+// order:
+//
+//	tag gui: install-vnc install-novnc
+//
+// Continuation lines add more targets. Unlike keys, a tag is never replaced:
+// every tag line for a name, in any file or decomk.d layer, adds to it.
+//
+// Intent: Give target selection a middle ground between running everything
+// and hand-listing targets (see decomk plan/run -tags).
+// Source: DI-pamuj (TODO-jirin)
+type Tags map[string][]string
+
+// docPrefix starts a doc comment line.
+const docPrefix = "##"
+
+// recipePrefix starts an inline recipe line.
+const recipePrefix = "recipe"
+
+// tagPrefix starts a tag line.
+const tagPrefix = "tag"
+
+// MergeMode says how a later layer's definition of a key combines with the
+// definition built from earlier layers.
+type MergeMode string
+
+// Merge modes.
+const (
+	// MergeReplace drops the earlier tokens (the default).
+	MergeReplace MergeMode = "replace"
+	// MergeAppend adds the later tokens after the earlier ones.
+	MergeAppend MergeMode = "append"
+	// MergePrepend adds the later tokens before the earlier ones.
+	MergePrepend MergeMode = "prepend"
+	// MergeUniqueAppend is MergeAppend without tokens already present.
+	MergeUniqueAppend MergeMode = "unique-append"
+)
+
+// mergeModes lists the valid merge modes, for error messages.
+var mergeModes = []MergeMode{MergeReplace, MergeAppend, MergePrepend, MergeUniqueAppend}
+
+// Merges maps a key to the merge mode declared on its key line:
+//
+//	INSTALL: merge unique-append; Block20_go
+//
+// A layer's mode applies when Merge combines that layer's definition with the
+// earlier layers, and carries over to later layers that define the key
+// without declaring a mode (see MergeMerges). Within one file the last
+// definition of a key still replaces earlier ones, mode included.
+//
+// Intent: Let overlays extend list-valued keys safely instead of copying and
+// replacing them wholesale.
+// Source: DI-fifas (TODO-jirin)
+type Merges map[string]MergeMode
+
+// Config is what a config file, or a set of layered config files, defines.
+type Config struct {
+	Defs    Defs
+	Recipes Recipes
+	Docs    Docs
+	Tags    Tags
+	Merges  Merges
+	// Paths are the config sources loaded, lowest precedence first. LoadFile
+	// and LoadTree set it to the path they load (a decomk.d directory is part
+	// of its base file's source); Parse leaves it empty.
+	Paths []string
+	// LowerTuples maps each tuple name a source below the root assigns, while
+	// the root sets AllowKey, to the words the root's own assignments of that
+	// name hold; targets selected through such a tuple must be one of those
+	// words or be Allowed. Loaders that layer sources set it; Parse, LoadFile,
+	// and LoadTree leave it nil.
+	LowerTuples map[string][]string
+}
+
+// ToolRefKey is the reserved key a config repo uses to pin the decomk tool
+// version it requires, for example:
+//
+//	DECOMK_TOOL_REF: v0.4.2
+//
+// It is a directive for `decomk self-update`, not a context or macro: it must
+// hold exactly one token and other keys may not reference it.
+//
+// Intent: Let the config repo pin the tool version it was written for so
+// config and tool versions stay compatible across the fleet.
+// Source: DI-dalos (TODO-jirin)
+const ToolRefKey = "DECOMK_TOOL_REF"
+
+// AllowKey is the reserved key the root (highest-precedence) config uses to
+// list, as path.Match patterns, the keys, inline recipes and generated
+// targets lower-precedence layers may define, for example:
+//
+//	DECOMK_ALLOW: Block* install-*
+//
+// Like ToolRefKey it is a directive, not a context or macro: other keys may
+// not reference it. Callers enforce it across layers (see Allowed).
+//
+// Intent: Let an image-baked root config bound what a config repo or
+// repo-local overlay can add, so enabling overlays does not hand them
+// arbitrary root-run targets.
+// Source: DI-jajoh (TODO-jirin)
+const AllowKey = "DECOMK_ALLOW"
+
+// IsDirective reports whether key is a reserved directive (ToolRefKey or
+// AllowKey) rather than a context or macro.
+func IsDirective(key string) bool {
+	return key == ToolRefKey || key == AllowKey
+}
+
+// Allowed reports whether name matches one of the AllowKey patterns in allow.
+func Allowed(allow []string, name string) bool {
+	for _, pattern := range allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ComputedTupleNames are the DECOMK_* variables decomk computes for every run.
+// Config tuples may not set them: the computed value would silently replace the
+// configured one in env.sh and on make's argv.
+//
+// Intent: Fail at parse time, pointing at the offending line, instead of
+// leaving users to wonder why their value never shows up.
+// Source: DI-rakib (TODO-jirin)
+var ComputedTupleNames = []string{
+	"DECOMK_HOME",
+	"DECOMK_STAMPDIR",
+	"DECOMK_VERSION",
+	"DECOMK_REMOTE_USER",
+	"DECOMK_MAKE_USER",
+	"DECOMK_WORKSPACES",
+	"DECOMK_CONTEXTS",
+	"DECOMK_PACKAGES",
+	"DECOMK_RUN_TMP",
+	"DECOMK_ARTIFACTS_DIR",
+	"DECOMK_FIRST_BOOT",
+	"DECOMK_BIN",
+	"DECOMK_OS",
+	"DECOMK_ARCH",
+	"DECOMK_DISTRO",
+	"DECOMK_DISTRO_VERSION",
+	"DECOMK_NPROC",
+}
+
 // LoadTree loads a base config file and any sibling *.conf files in a matching
 // "<basename>.d" directory (e.g., decomk.conf + decomk.d/*.conf).
 //
 // Layering/precedence:
 //   - The base file is loaded first.
 //   - Then sibling *.conf files are loaded in lexical order by filename.
-//   - Later definitions override earlier ones by key (last definition wins).
-func LoadTree(path string) (Defs, error) {
-	base, err := LoadFile(path)
+//   - Later definitions override earlier ones by key (last definition wins),
+//     unless the key's merge mode says otherwise (see Merges).
+//
+// Inline recipes and docs follow the same layering rule.
+func LoadTree(path string) (Config, error) {
+	cfg, err := LoadFile(path)
 	if err != nil {
-		return nil, err
+		return Config{}, err
 	}
 
 	dir := filepath.Dir(path)
@@ -61,17 +268,17 @@ func LoadTree(path string) (Defs, error) {
 	if err != nil {
 		// If the directory doesn't exist, that's fine; return just the base file.
 		if os.IsNotExist(err) {
-			return base, nil
+			return cfg, nil
 		}
-		return nil, fmt.Errorf("stat %q: %w", dDir, err)
+		return Config{}, fmt.Errorf("stat %q: %w", dDir, err)
 	}
 	if !info.IsDir() {
-		return nil, fmt.Errorf("%q exists but is not a directory", dDir)
+		return Config{}, fmt.Errorf("%q exists but is not a directory", dDir)
 	}
 
 	entries, err := os.ReadDir(dDir)
 	if err != nil {
-		return nil, fmt.Errorf("readdir %q: %w", dDir, err)
+		return Config{}, fmt.Errorf("readdir %q: %w", dDir, err)
 	}
 
 	var names []string
@@ -86,23 +293,25 @@ func LoadTree(path string) (Defs, error) {
 	}
 	sort.Strings(names)
 
-	defs := base
 	for _, name := range names {
-		p := filepath.Join(dDir, name)
-		part, err := LoadFile(p)
+		part, err := LoadFile(filepath.Join(dDir, name))
 		if err != nil {
-			return nil, err
+			return Config{}, err
 		}
-		defs = Merge(defs, part)
+		cfg.Merges = MergeMerges(cfg.Merges, part.Merges)
+		cfg.Defs = Merge(cfg.Defs, part.Defs, cfg.Merges)
+		cfg.Recipes = MergeRecipes(cfg.Recipes, part.Recipes)
+		cfg.Docs = MergeDocs(cfg.Docs, part.Docs)
+		cfg.Tags = MergeTags(cfg.Tags, part.Tags)
 	}
-	return defs, nil
+	return cfg, nil
 }
 
 // LoadFile loads and parses a single config file.
-func LoadFile(path string) (defs Defs, err error) {
+func LoadFile(path string) (cfg Config, err error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("open %q: %w", path, err)
+		return Config{}, fmt.Errorf("open %q: %w", path, err)
 	}
 	// Intent: Preserve file close failures while parsing decomk.conf so I/O errors
 	// are never dropped during context resolution.
@@ -118,28 +327,70 @@ func LoadFile(path string) (defs Defs, err error) {
 		}
 	}()
 
-	defs, err = Parse(f)
+	cfg, err = Parse(f)
+	var syntaxErrs SyntaxErrors
+	if errors.As(err, &syntaxErrs) {
+		for _, e := range syntaxErrs {
+			e.Path = path
+		}
+		return Config{}, syntaxErrs
+	}
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", path, err)
+		return Config{}, fmt.Errorf("%s: %w", path, err)
 	}
-	return defs, nil
+	cfg.Paths = []string{path}
+	return cfg, nil
 }
 
 // Parse parses decomk.conf content from r.
-func Parse(r io.Reader) (Defs, error) {
+//
+// A malformed line does not stop parsing: Parse reads to the end and returns
+// every bad line as a SyntaxErrors value.
+//
+// Each key's tokens, continuation lines included, go through
+// toolchain.Qualify, so `DEFAULT: mise:python@3.12 node@20` defines two mise
+// tokens. Inheritance stays within the key as this file writes it; it does not
+// reach tokens a merge appends from another file.
+//
+// Intent: Show every mistake in a large config at once, each with its line
+// text and, where known, a caret under the bad column, instead of one terse
+// line number per run.
+// Source: DI-vuhup (TODO-jirin)
+func Parse(r io.Reader) (Config, error) {
 	defs := make(Defs)
+	recipes := make(Recipes)
+	docs := make(Docs)
+	tags := make(Tags)
+	merges := make(Merges)
 
 	scanner := bufio.NewScanner(r)
 	// Allow moderately long lines for large token lists.
 	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 
-	var currentKey string
+	var currentKey, currentRecipe, currentTag string
+	// afterError is set after a malformed recipe or tag line, whose
+	// continuation lines belong to nothing but are not worth reporting too.
+	afterError := false
+	// doc holds the "##" lines read since the last other line.
+	var doc []string
+	var errs SyntaxErrors
 	for lineNum := 1; scanner.Scan(); lineNum++ {
 		raw := strings.TrimRight(scanner.Text(), "\r")
+		// fail records err for this line; part is the text of raw that err's
+		// offset, if any, is relative to.
+		fail := func(part string, err error) {
+			errs = append(errs, newSyntaxError(lineNum, raw, part, err))
+		}
 
 		// Leading whitespace is ignored. Any non-empty, non-comment line that is
 		// not a key line is treated as a continuation of the previous key.
 		trimLeft := strings.TrimLeftFunc(raw, unicode.IsSpace)
+		if text, ok := strings.CutPrefix(trimLeft, docPrefix); ok {
+			doc = append(doc, strings.TrimSpace(text))
+			continue
+		}
+		pendingDoc := doc
+		doc = nil
 		if trimLeft == "" {
 			continue
 		}
@@ -147,52 +398,205 @@ func Parse(r io.Reader) (Defs, error) {
 			continue
 		}
 
+		if name, command, ok, err := splitRecipeLine(trimLeft); ok || err != nil {
+			if err != nil {
+				fail(trimLeft, err)
+				currentKey, currentRecipe, currentTag, afterError = "", "", "", true
+				continue
+			}
+			// Within a single file, the last definition of a recipe wins.
+			recipes[name] = command
+			currentKey, currentRecipe, currentTag, afterError = "", name, "", false
+			continue
+		}
+
+		if name, rest, ok, err := splitTagLine(trimLeft); ok || err != nil {
+			if err != nil {
+				fail(trimLeft, err)
+				currentKey, currentRecipe, currentTag, afterError = "", "", "", true
+				continue
+			}
+			currentKey, currentRecipe, currentTag, afterError = "", "", name, false
+			targets, err := lineTokens(rest)
+			if err != nil {
+				fail(rest, err)
+				continue
+			}
+			tags[name] = appendNew(tags[name], targets...)
+			continue
+		}
+
 		if key, rest, ok := splitKeyLine(trimLeft); ok {
-			currentKey = key
-			toks, err := splitTokens(rest)
+			currentKey, currentRecipe, currentTag, afterError = key, "", "", false
+			mode, rest, err := splitMerge(rest)
+			if err != nil {
+				fail(rest, fmt.Errorf("key %q: %w", key, err))
+				continue
+			}
+			if mode != "" {
+				merges[key] = mode
+			} else {
+				delete(merges, key)
+			}
+			parents, rest, err := splitInherits(rest)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+				fail(rest, fmt.Errorf("key %q: %w", key, err))
+				continue
+			}
+			toks, err := lineTokens(rest)
+			if err != nil {
+				fail(rest, err)
+				continue
 			}
+			toks = append(parents, toks...)
 			// Within a single file, the last definition of a key wins.
 			defs[currentKey] = toks
+			if text := strings.TrimSpace(strings.Join(pendingDoc, "\n")); text != "" {
+				docs[currentKey] = text
+			}
 			continue
 		}
 
 		// Continuation line.
-		if currentKey == "" {
-			return nil, fmt.Errorf("line %d: continuation line without a preceding key", lineNum)
+		if afterError {
+			continue
+		}
+		if currentRecipe != "" {
+			fail(trimLeft, fmt.Errorf("continuation line after recipe %q; inline recipes must fit on one line", currentRecipe))
+			continue
 		}
-		toks, err := splitTokens(trimLeft)
+		if currentKey == "" && currentTag == "" {
+			fail(trimLeft, errors.New("continuation line without a preceding key"))
+			continue
+		}
+		toks, err := lineTokens(trimLeft)
 		if err != nil {
-			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			fail(trimLeft, err)
+			continue
+		}
+		if currentTag != "" {
+			tags[currentTag] = appendNew(tags[currentTag], toks...)
+			continue
 		}
 		defs[currentKey] = append(defs[currentKey], toks...)
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return Config{}, err
+	}
+	if len(errs) > 0 {
+		return Config{}, errs
 	}
-	return defs, nil
+	for key, toks := range defs {
+		defs[key] = toolchain.Qualify(toks)
+	}
+	return Config{Defs: defs, Recipes: recipes, Docs: docs, Tags: tags, Merges: merges}, nil
 }
 
-// Merge returns a new Defs where overlay keys replace base keys.
+// Merge returns a new Defs where overlay keys replace base keys, or combine
+// with them as merges says (see Merges; a nil merges replaces every key).
 //
 // The returned map owns its slices (callers can mutate it without affecting the
 // inputs).
-func Merge(base, overlay Defs) Defs {
+func Merge(base, overlay Defs, merges Merges) Defs {
 	out := make(Defs, len(base)+len(overlay))
 	for k, v := range base {
 		out[k] = append([]string(nil), v...)
 	}
 	for k, v := range overlay {
-		out[k] = append([]string(nil), v...)
+		switch merges[k] {
+		case MergeAppend:
+			out[k] = append(out[k], v...)
+		case MergePrepend:
+			out[k] = append(append([]string(nil), v...), out[k]...)
+		case MergeUniqueAppend:
+			out[k] = appendNew(out[k], v...)
+		default:
+			out[k] = append([]string(nil), v...)
+		}
 	}
 	return out
 }
 
+// MergeMerges returns a new Merges where overlay modes replace base modes;
+// keys overlay declares no mode for keep their base mode.
+func MergeMerges(base, overlay Merges) Merges {
+	out := make(Merges, len(base)+len(overlay))
+	for key, mode := range base {
+		out[key] = mode
+	}
+	for key, mode := range overlay {
+		out[key] = mode
+	}
+	return out
+}
+
+// MergeRecipes returns a new Recipes where overlay names replace base names.
+func MergeRecipes(base, overlay Recipes) Recipes {
+	out := make(Recipes, len(base)+len(overlay))
+	for name, command := range base {
+		out[name] = command
+	}
+	for name, command := range overlay {
+		out[name] = command
+	}
+	return out
+}
+
+// MergeTags returns a new Tags holding the targets of both, base first (see
+// Tags: layers add to a tag instead of replacing it).
+func MergeTags(base, overlay Tags) Tags {
+	out := make(Tags, len(base)+len(overlay))
+	for name, targets := range base {
+		out[name] = append([]string(nil), targets...)
+	}
+	for name, targets := range overlay {
+		out[name] = appendNew(out[name], targets...)
+	}
+	return out
+}
+
+// MergeDocs returns a new Docs where overlay docs replace base docs.
+func MergeDocs(base, overlay Docs) Docs {
+	out := make(Docs, len(base)+len(overlay))
+	for key, doc := range base {
+		out[key] = doc
+	}
+	for key, doc := range overlay {
+		out[key] = doc
+	}
+	return out
+}
+
+// RenderRecipes renders recipes as a make fragment, one target per recipe in
+// name order.
+//
+// Recipe targets run in decomk's stamp directory like any other target, so a
+// command that ends with `touch $@` installs once until its stamp is deleted.
+func RenderRecipes(recipes Recipes) []byte {
+	names := make([]string, 0, len(recipes))
+	for name := range recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString("# generated by decomk from decomk.conf recipe lines; do not edit\n")
+	for _, name := range names {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(":\n\t")
+		b.WriteString(recipes[name])
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
+
 // ValidateRefs checks that every non-tuple RHS token is a known key.
 //
 // This enforces decomk.conf's tuple/macro-only model:
 //   - `NAME=value` tokens are tuple assignments,
+//   - `mise:`/`asdf:` tokens are toolchain requests (see package toolchain),
+//   - `tmpl:` tokens are recipe template calls (see package recipetmpl),
 //   - any other RHS token must be a key present in defs.
 //
 // A bare token that is neither a tuple nor a defined key is rejected with a
@@ -209,20 +613,304 @@ func ValidateRefs(defs Defs) error {
 	sort.Strings(keys)
 
 	for _, key := range keys {
+		if key == ToolRefKey {
+			if _, err := ToolRef(defs); err != nil {
+				return err
+			}
+			continue
+		}
+		if key == AllowKey {
+			for _, pattern := range defs[key] {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid pattern %q in %s: %w", pattern, AllowKey, err)
+				}
+			}
+			continue
+		}
 		tokens := defs[key]
 		for _, token := range tokens {
+			// Intent: Validate a conditional's guarded token like any other RHS
+			// token, so typos fail at load time even when the guard is false.
+			// Source: DI-viraj (TODO-jirin)
+			if cond, ok := expand.ParseCondition(token); ok {
+				token = cond.Then
+			}
+			if token == ToolRefKey {
+				return fmt.Errorf("invalid token %q in key %q: %s is a reserved tool pin, not a macro", token, key, ToolRefKey)
+			}
+			if token == AllowKey {
+				return fmt.Errorf("invalid token %q in key %q: %s is a reserved allow-list, not a macro", token, key, AllowKey)
+			}
 			if _, _, ok := resolve.SplitTuple(token); ok {
 				continue
 			}
+			if class, tuple := resolve.SplitClass(token); class != "" {
+				if _, _, ok := resolve.SplitTuple(tuple); ok {
+					continue
+				}
+				return fmt.Errorf("invalid token %q in key %q: %s must be followed by a tuple (NAME=value)", token, key, class)
+			}
+			// Intent: Accept version-manager tokens as a third RHS token kind
+			// while still rejecting malformed ones at load time.
+			// Source: DI-zisok (TODO-jirin)
+			if toolchain.IsToken(token) {
+				if _, err := toolchain.Parse(token); err != nil {
+					return fmt.Errorf("invalid token %q in key %q: %w", token, key, err)
+				}
+				continue
+			}
+			// Intent: Accept recipe template calls as RHS tokens, validating
+			// their syntax at load time; the template files are checked when
+			// the plan renders them.
+			// Source: DI-lubug (TODO-jirin)
+			if recipetmpl.IsToken(token) {
+				if _, err := recipetmpl.Parse(token); err != nil {
+					return fmt.Errorf("invalid token %q in key %q: %w", token, key, err)
+				}
+				continue
+			}
 			if _, ok := defs[token]; ok {
 				continue
 			}
+			if err := toolchain.CheckPrefix(token); err != nil {
+				return fmt.Errorf("invalid token %q in key %q: %w", token, key, err)
+			}
 			return fmt.Errorf("invalid token %q in key %q: bare RHS tokens must be tuple assignments (NAME=value) or defined keys", token, key)
 		}
 	}
 	return nil
 }
 
+// ToolRef returns the tool version pinned by ToolRefKey, or "" when defs does
+// not pin one.
+func ToolRef(defs Defs) (string, error) {
+	tokens, ok := defs[ToolRefKey]
+	if !ok {
+		return "", nil
+	}
+	if len(tokens) != 1 {
+		return "", fmt.Errorf("%s must have exactly one token (a git ref or module version), got %q", ToolRefKey, tokens)
+	}
+	if _, _, isTuple := resolve.SplitTuple(tokens[0]); isTuple || toolchain.IsToken(tokens[0]) || recipetmpl.IsToken(tokens[0]) {
+		return "", fmt.Errorf("%s must be a git ref or module version, got %q", ToolRefKey, tokens[0])
+	}
+	return tokens[0], nil
+}
+
+// splitInherits splits an optional "inherits PARENT...;" prefix off a key
+// line's token text, returning the parent keys and the remaining text.
+//
+// Parents become the key's leading tokens, so they expand before its own
+// tokens; since resolve.Partition keeps only the last assignment of each tuple
+// NAME, the key's own tuples override inherited ones.
+//
+// The prefix is only recognized when a ';' follows, so a macro that happens to
+// be named "inherits" keeps working as an ordinary token.
+//
+// Intent: Make context overrides explicit and deterministic instead of relying
+// on concatenation order.
+// Source: DI-vosib (TODO-jirin)
+func splitInherits(rest string) (parents []string, remainder string, err error) {
+	const keyword = "inherits"
+	if !strings.HasPrefix(rest, keyword) || len(rest) == len(keyword) || !isSpace(rune(rest[len(keyword)])) {
+		return nil, rest, nil
+	}
+	semi := strings.IndexByte(rest, ';')
+	if semi < 0 {
+		return nil, rest, nil
+	}
+	parents = strings.Fields(rest[len(keyword):semi])
+	if len(parents) == 0 {
+		return nil, "", fmt.Errorf("inherits requires at least one parent key before ';'")
+	}
+	for _, parent := range parents {
+		if strings.ContainsAny(parent, "='") {
+			return nil, "", fmt.Errorf("inherits parent %q must be a key name", parent)
+		}
+	}
+	return parents, strings.TrimSpace(rest[semi+1:]), nil
+}
+
+// splitMerge splits an optional "merge MODE;" prefix off a key line's token
+// text, returning the mode ("" when absent) and the remaining text, which may
+// still start with an inherits prefix.
+//
+// Like splitInherits, the prefix is only recognized when a ';' follows.
+func splitMerge(rest string) (mode MergeMode, remainder string, err error) {
+	const keyword = "merge"
+	if !strings.HasPrefix(rest, keyword) || len(rest) == len(keyword) || !isSpace(rune(rest[len(keyword)])) {
+		return "", rest, nil
+	}
+	semi := strings.IndexByte(rest, ';')
+	if semi < 0 {
+		return "", rest, nil
+	}
+	mode = MergeMode(strings.TrimSpace(rest[len(keyword):semi]))
+	if !slices.Contains(mergeModes, mode) {
+		return "", "", fmt.Errorf("invalid merge mode %q (expected one of %v)", mode, mergeModes)
+	}
+	return mode, strings.TrimSpace(rest[semi+1:]), nil
+}
+
+// lineTokens splits the token text of a key or continuation line and applies
+// the token-level rules: conditional and class keywords are joined with the
+// tokens they apply to, and computed variables are rejected.
+func lineTokens(s string) ([]string, error) {
+	toks, err := splitTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	if toks, err = joinConditions(toks); err != nil {
+		return nil, err
+	}
+	if toks, err = joinClasses(toks); err != nil {
+		return nil, err
+	}
+	if err := checkComputedTuples(toks); err != nil {
+		return nil, err
+	}
+	return toks, nil
+}
+
+// joinClasses merges a class keyword such as `noexport` with the tuple that
+// follows it into one token (see resolve.SplitClass).
+func joinClasses(toks []string) ([]string, error) {
+	var out []string
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		if !resolve.IsClass(tok) {
+			out = append(out, tok)
+			continue
+		}
+		if i+1 >= len(toks) {
+			return nil, fmt.Errorf("%s must be followed by a tuple (NAME=value)", tok)
+		}
+		if _, _, ok := resolve.SplitTuple(toks[i+1]); !ok {
+			return nil, fmt.Errorf("%s must be followed by a tuple (NAME=value), got %q", tok, toks[i+1])
+		}
+		out = append(out, tok+" "+toks[i+1])
+		i++
+	}
+	return out, nil
+}
+
+// joinConditions merges the three-word conditional forms `?NAME=value -> TOKEN`
+// and `?(EXPR) -> TOKEN` into one token (see expand.ParseCondition) and rejects malformed
+// conditionals. An already-joined (quoted) conditional is kept as-is.
+func joinConditions(toks []string) ([]string, error) {
+	var out []string
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		if !strings.HasPrefix(tok, "?") {
+			out = append(out, tok)
+			continue
+		}
+		if i+2 < len(toks) && toks[i+1] == "->" {
+			tok = tok + " -> " + toks[i+2]
+			i += 2
+		}
+		if err := expand.CheckCondition(tok); err != nil {
+			return nil, err
+		}
+		out = append(out, tok)
+	}
+	return out, nil
+}
+
+// checkComputedTuples rejects tuples (including conditionally guarded ones)
+// that set one of ComputedTupleNames.
+func checkComputedTuples(toks []string) error {
+	for _, tok := range toks {
+		if cond, ok := expand.ParseCondition(tok); ok {
+			tok = cond.Then
+		}
+		_, tok = resolve.SplitClass(tok)
+		name, _, ok := resolve.SplitTuple(tok)
+		if !ok {
+			continue
+		}
+		for _, computed := range ComputedTupleNames {
+			if name == computed {
+				return fmt.Errorf("tuple %q sets %s, which decomk computes for every run and would silently override; use a different variable name", tok, name)
+			}
+		}
+	}
+	return nil
+}
+
+// splitRecipeLine parses an inline recipe line of the form
+// "recipe name: command".
+//
+// It returns ok=false (and no error) for lines that do not start with the
+// recipe keyword, so they can be parsed as key or continuation lines. Lines
+// that do start with it must be well-formed.
+func splitRecipeLine(line string) (name, command string, ok bool, err error) {
+	rest, found := strings.CutPrefix(line, recipePrefix)
+	if !found || rest == "" || !isSpace(rune(rest[0])) {
+		return "", "", false, nil
+	}
+	head, command, found := strings.Cut(rest, ":")
+	if !found {
+		return "", "", false, fmt.Errorf("recipe line must be \"recipe <name>: <command>\"")
+	}
+	name = strings.TrimSpace(head)
+	if !isRecipeName(name) {
+		return "", "", false, fmt.Errorf("invalid recipe name %q (allowed: letters, numbers, '.', '_', '-')", name)
+	}
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return "", "", false, fmt.Errorf("recipe %q has an empty command", name)
+	}
+	return name, command, true, nil
+}
+
+// splitTagLine parses a tag line of the form "tag name: target...".
+//
+// Like splitRecipeLine, it returns ok=false (and no error) for lines that do
+// not start with the tag keyword.
+func splitTagLine(line string) (name, rest string, ok bool, err error) {
+	after, found := strings.CutPrefix(line, tagPrefix)
+	if !found || after == "" || !isSpace(rune(after[0])) {
+		return "", "", false, nil
+	}
+	head, rest, found := strings.Cut(after, ":")
+	if !found {
+		return "", "", false, fmt.Errorf("tag line must be \"tag <name>: <target>...\"")
+	}
+	name = strings.TrimSpace(head)
+	if !isRecipeName(name) {
+		return "", "", false, fmt.Errorf("invalid tag name %q (allowed: letters, numbers, '.', '_', '-')", name)
+	}
+	return name, rest, true, nil
+}
+
+// appendNew appends the values not already in list.
+func appendNew(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// isRecipeName reports whether s is a plain make target name that needs no
+// quoting (and cannot smuggle make syntax such as ':' or '=').
+func isRecipeName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.' || r == '_' || r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // splitKeyLine parses a key definition line of the form "key: tokens...".
 //
 // It returns ok=false if the line should be treated as a continuation line.
@@ -258,7 +946,10 @@ func isSpace(r rune) bool {
 // splitTokens splits a line into tokens using a minimal, explicit quoting rule:
 // single quotes keep everything literal (including spaces), and are removed.
 //
-// Backslash escapes the next rune when not in single quotes.
+// Backslash escapes the next rune when not in single quotes, and an unquoted
+// $(exec:...) group (see resolve.ExecPrefix) is kept verbatim through its
+// matching ")", spaces included, as is a token starting with a ?( guard (see
+// expand.ScanGuard).
 //
 // This is intentionally simpler than a full POSIX shell parser because the
 // output tokens are passed directly to exec.Command (no shell evaluation).
@@ -268,6 +959,10 @@ func splitTokens(s string) ([]string, error) {
 
 	inSingle := false
 	escape := false
+	skipTo := 0
+	// open is the offset of the backslash or quote that set escape or
+	// inSingle.
+	open := 0
 
 	flush := func() {
 		if b.Len() == 0 {
@@ -277,7 +972,10 @@ func splitTokens(s string) ([]string, error) {
 		b.Reset()
 	}
 
-	for _, r := range s {
+	for i, r := range s {
+		if i < skipTo {
+			continue
+		}
 		if escape {
 			b.WriteRune(r)
 			escape = false
@@ -294,10 +992,24 @@ func splitTokens(s string) ([]string, error) {
 		}
 
 		switch {
+		case b.Len() == 0 && strings.HasPrefix(s[i:], "?("):
+			n, ok := expand.ScanGuard(s[i:])
+			if !ok {
+				return nil, &offsetError{i, errors.New("unterminated ?( group")}
+			}
+			b.WriteString(s[i : i+n])
+			skipTo = i + n
+		case strings.HasPrefix(s[i:], resolve.ExecPrefix):
+			n, ok := resolve.ScanExec(s[i:])
+			if !ok {
+				return nil, &offsetError{i, fmt.Errorf("unterminated %s group", resolve.ExecPrefix)}
+			}
+			b.WriteString(s[i : i+n])
+			skipTo = i + n
 		case r == '\\':
-			escape = true
+			escape, open = true, i
 		case r == '\'':
-			inSingle = true
+			inSingle, open = true, i
 		case isSpace(r):
 			flush()
 		default:
@@ -306,10 +1018,10 @@ func splitTokens(s string) ([]string, error) {
 	}
 
 	if escape {
-		return nil, fmt.Errorf("dangling backslash escape")
+		return nil, &offsetError{open, errors.New("dangling backslash escape")}
 	}
 	if inSingle {
-		return nil, fmt.Errorf("unterminated single-quoted string")
+		return nil, &offsetError{open, errors.New("unterminated single-quoted string")}
 	}
 	flush()
 	return tokens, nil
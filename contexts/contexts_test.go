@@ -1,6 +1,10 @@
 package contexts
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -20,19 +24,169 @@ DEFAULT: Block00_base
 grokker: DEFAULT Block20_go
 `
 
-	defs, err := Parse(strings.NewReader(in))
+	cfg, err := Parse(strings.NewReader(in))
 	if err != nil {
 		t.Fatalf("Parse() error: %v", err)
 	}
 
-	if got, want := strings.Join(defs["DEFAULT"], "|"), "Block00_base|Block10_common|FOO=bar baz"; got != want {
+	if got, want := strings.Join(cfg.Defs["DEFAULT"], "|"), "Block00_base|Block10_common|FOO=bar baz"; got != want {
 		t.Fatalf("DEFAULT tokens: got %q want %q", got, want)
 	}
-	if got, want := strings.Join(defs["grokker"], "|"), "DEFAULT|Block20_go"; got != want {
+	if got, want := strings.Join(cfg.Defs["grokker"], "|"), "DEFAULT|Block20_go"; got != want {
 		t.Fatalf("grokker tokens: got %q want %q", got, want)
 	}
 }
 
+func TestParse_Inherits(t *testing.T) {
+	t.Parallel()
+
+	in := `DEFAULT: FOO=base BAR=1
+grokker: inherits DEFAULT; FOO=override
+  BAZ=2
+inherits: FOO=macro
+legacy: inherits DEFAULT
+`
+	cfg, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got, want := strings.Join(cfg.Defs["grokker"], "|"), "DEFAULT|FOO=override|BAZ=2"; got != want {
+		t.Fatalf("grokker tokens: got %q want %q", got, want)
+	}
+	// Without ';', "inherits" is an ordinary macro reference.
+	if got, want := strings.Join(cfg.Defs["legacy"], "|"), "inherits|DEFAULT"; got != want {
+		t.Fatalf("legacy tokens: got %q want %q", got, want)
+	}
+
+	for _, bad := range []string{"x: inherits ; FOO=1\n", "x: inherits FOO=1; BAR=2\n"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Fatalf("Parse(%q): expected error", bad)
+		}
+	}
+}
+
+func TestLoadTree_MergeModes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"decomk.conf": `INSTALL: merge unique-append; Block00_base Block10_common
+PATHS: merge prepend; /usr/bin
+TOOLS: merge append; inherits BASE; go
+BASE: FOO=1
+OTHER: a
+`,
+		"decomk.d/10-team.conf": `INSTALL: Block10_common Block20_go
+PATHS: /opt/bin
+TOOLS: node
+OTHER: b
+`,
+		"decomk.d/20-reset.conf": `TOOLS: merge replace; rust
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := LoadTree(filepath.Join(dir, "decomk.conf"))
+	if err != nil {
+		t.Fatalf("LoadTree() error: %v", err)
+	}
+	want := map[string]string{
+		"INSTALL": "Block00_base|Block10_common|Block20_go",
+		"PATHS":   "/opt/bin|/usr/bin",
+		"TOOLS":   "rust",
+		"OTHER":   "b",
+	}
+	for key, tokens := range want {
+		if got := strings.Join(cfg.Defs[key], "|"); got != tokens {
+			t.Fatalf("%s tokens: got %q want %q", key, got, tokens)
+		}
+	}
+	if cfg.Merges["TOOLS"] != MergeReplace || cfg.Merges["INSTALL"] != MergeUniqueAppend {
+		t.Fatalf("merges: got %v want TOOLS replace, INSTALL unique-append", cfg.Merges)
+	}
+	if want := []string{filepath.Join(dir, "decomk.conf")}; !reflect.DeepEqual(cfg.Paths, want) {
+		t.Fatalf("paths: got %q want %q", cfg.Paths, want)
+	}
+
+	// Before the reset, TOOLS appended the later layer after its parents.
+	if got := Merge(Defs{"TOOLS": {"BASE", "go"}}, Defs{"TOOLS": {"node"}}, Merges{"TOOLS": MergeAppend})["TOOLS"]; strings.Join(got, "|") != "BASE|go|node" {
+		t.Fatalf("Merge(append): got %q", got)
+	}
+
+	for _, bad := range []string{"x: merge sideways; FOO=1\n", "x: merge ; FOO=1\n"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "invalid merge mode") {
+			t.Fatalf("Parse(%q): got %v want invalid merge mode", bad, err)
+		}
+	}
+	// Without ';', "merge" is an ordinary macro reference.
+	cfg, err = Parse(strings.NewReader("x: merge append\n"))
+	if err != nil || strings.Join(cfg.Defs["x"], "|") != "merge|append" {
+		t.Fatalf("Parse(no ';'): got %q, %v", cfg.Defs["x"], err)
+	}
+}
+
+func TestParse_Conditionals(t *testing.T) {
+	t.Parallel()
+
+	in := `DEFAULT: GPU=0 ?GPU=1 -> Block_gpu
+  '?GPU=0 -> CPU_ONLY=1'
+Block_gpu: CUDA=12
+`
+	cfg, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got, want := strings.Join(cfg.Defs["DEFAULT"], "|"), "GPU=0|?GPU=1 -> Block_gpu|?GPU=0 -> CPU_ONLY=1"; got != want {
+		t.Fatalf("DEFAULT tokens: got %q want %q", got, want)
+	}
+	if err := ValidateRefs(cfg.Defs); err != nil {
+		t.Fatalf("ValidateRefs() error: %v", err)
+	}
+
+	if _, err := Parse(strings.NewReader("DEFAULT: ?GPU=1 Block_gpu\n")); err == nil {
+		t.Fatalf("Parse(missing ->): expected error")
+	}
+	err = ValidateRefs(Defs{"DEFAULT": {"?GPU=1 -> Missing"}})
+	if err == nil || !strings.Contains(err.Error(), `invalid token "Missing"`) {
+		t.Fatalf("ValidateRefs(unknown guarded token) error: got %v", err)
+	}
+
+	cfg, err = Parse(strings.NewReader(`DEFAULT: ?(GPU == "1" && DECOMK_ARCH != 'arm64') -> Block_gpu ?(A)->B=1` + "\n"))
+	if err != nil {
+		t.Fatalf("Parse(expression) error: %v", err)
+	}
+	if got, want := strings.Join(cfg.Defs["DEFAULT"], "|"), `?(GPU == "1" && DECOMK_ARCH != 'arm64') -> Block_gpu|?(A)->B=1`; got != want {
+		t.Fatalf("DEFAULT tokens: got %q want %q", got, want)
+	}
+	for _, in := range []string{"DEFAULT: ?(GPU == -> X\n", "DEFAULT: ?(GPU ==) -> X\n"} {
+		if _, err := Parse(strings.NewReader(in)); err == nil {
+			t.Fatalf("Parse(%q): expected error", in)
+		}
+	}
+}
+
+func TestParse_ExecGroupIsOneToken(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse(strings.NewReader("DEFAULT: GOVER=$(exec:go env GOVERSION) ARCH=x$(exec:uname -m | tr -d '()')y OTHER=1\n"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got, want := strings.Join(cfg.Defs["DEFAULT"], "|"), "GOVER=$(exec:go env GOVERSION)|ARCH=x$(exec:uname -m | tr -d '()')y|OTHER=1"; got != want {
+		t.Fatalf("DEFAULT tokens: got %q want %q", got, want)
+	}
+	if _, err := Parse(strings.NewReader("DEFAULT: GOVER=$(exec:go env\n")); err == nil || !strings.Contains(err.Error(), "unterminated $(exec: group") {
+		t.Fatalf("Parse(unterminated exec) error: got %v", err)
+	}
+}
+
 func TestParse_ContinuationWithoutKeyIsError(t *testing.T) {
 	t.Parallel()
 
@@ -44,6 +198,44 @@ func TestParse_ContinuationWithoutKeyIsError(t *testing.T) {
 	}
 }
 
+func TestParse_ComputedTupleIsError(t *testing.T) {
+	t.Parallel()
+
+	for _, conf := range []string{
+		"DEFAULT: FOO=bar\n  DECOMK_HOME=/tmp/x\n",
+		"DEFAULT: FOO=bar\nBlock: ?FOO=bar -> DECOMK_PACKAGES=x\n",
+	} {
+		_, err := Parse(strings.NewReader(conf))
+		if err == nil || !strings.Contains(err.Error(), "line 2: tuple") {
+			t.Fatalf("Parse(%q) error: got %v", conf, err)
+		}
+	}
+	if _, err := Parse(strings.NewReader("DEFAULT: DECOMK_MAKEFILES=base.mk\n")); err != nil {
+		t.Fatalf("Parse(DECOMK_MAKEFILES) error: %v", err)
+	}
+}
+
+func TestParse_NoExportTuples(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse(strings.NewReader("DEFAULT: noexport INSTALL='a b' FOO=bar\n  noexport CC=gcc\n"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got, want := strings.Join(cfg.Defs["DEFAULT"], "|"), "noexport INSTALL=a b|FOO=bar|noexport CC=gcc"; got != want {
+		t.Fatalf("DEFAULT: got %q want %q", got, want)
+	}
+	if err := ValidateRefs(cfg.Defs); err != nil {
+		t.Fatalf("ValidateRefs() error: %v", err)
+	}
+
+	for _, conf := range []string{"DEFAULT: noexport\n", "DEFAULT: noexport Block\nBlock: FOO=1\n", "DEFAULT: noexport DECOMK_HOME=/x\n"} {
+		if _, err := Parse(strings.NewReader(conf)); err == nil {
+			t.Fatalf("Parse(%q): expected error", conf)
+		}
+	}
+}
+
 func TestParse_UnterminatedQuoteIsError(t *testing.T) {
 	t.Parallel()
 
@@ -66,12 +258,12 @@ DEFAULT:
   http://example.com/also-ok
 `
 
-	defs, err := Parse(strings.NewReader(in))
+	cfg, err := Parse(strings.NewReader(in))
 	if err != nil {
 		t.Fatalf("Parse() error: %v", err)
 	}
 
-	if got, want := strings.Join(defs["DEFAULT"], "|"), "URL=https://example.com/path|http://example.com/also-ok"; got != want {
+	if got, want := strings.Join(cfg.Defs["DEFAULT"], "|"), "URL=https://example.com/path|http://example.com/also-ok"; got != want {
 		t.Fatalf("DEFAULT tokens: got %q want %q", got, want)
 	}
 }
@@ -104,3 +296,285 @@ func TestValidateRefs(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateRefs_ToolchainTokens(t *testing.T) {
+	t.Parallel()
+
+	if err := ValidateRefs(Defs{"DEFAULT": {"mise:python@3.12", "asdf:nodejs@20.11.1"}}); err != nil {
+		t.Fatalf("ValidateRefs() error: %v", err)
+	}
+	err := ValidateRefs(Defs{"DEFAULT": {"mise:python"}})
+	if err == nil || !strings.Contains(err.Error(), `invalid token "mise:python" in key "DEFAULT"`) {
+		t.Fatalf("ValidateRefs(malformed) error: got %v", err)
+	}
+	cfg, err := Parse(strings.NewReader("DEFAULT: mise:python@3.12 node@20\n"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got, want := cfg.Defs["DEFAULT"], []string{"mise:python@3.12", "mise:node@20"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Parse(mise:python@3.12 node@20) DEFAULT: got %q want %q", got, want)
+	}
+	if err := ValidateRefs(cfg.Defs); err != nil {
+		t.Fatalf("ValidateRefs(inherited prefix) error: %v", err)
+	}
+	cfg, err = Parse(strings.NewReader("DEFAULT: FOO=1 node@20\n"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	err = ValidateRefs(cfg.Defs)
+	if err == nil || !strings.Contains(err.Error(), `"node@20" has no manager prefix`) {
+		t.Fatalf("ValidateRefs(unprefixed) error: got %v", err)
+	}
+	if err := ValidateRefs(Defs{"DEFAULT": {"node@20"}, "node@20": {"FOO=1"}}); err != nil {
+		t.Fatalf("ValidateRefs(key named node@20) error: %v", err)
+	}
+}
+
+func TestValidateRefs_TemplateTokens(t *testing.T) {
+	t.Parallel()
+
+	if err := ValidateRefs(Defs{"DEFAULT": {"tmpl:github-release(cli/cli,gh)", "?GPU=1 -> tmpl:apt(nvtop)"}}); err != nil {
+		t.Fatalf("ValidateRefs() error: %v", err)
+	}
+	err := ValidateRefs(Defs{"DEFAULT": {"tmpl:github-release"}})
+	if err == nil || !strings.Contains(err.Error(), `invalid token "tmpl:github-release" in key "DEFAULT"`) {
+		t.Fatalf("ValidateRefs(malformed) error: got %v", err)
+	}
+}
+
+func TestToolRef(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse(strings.NewReader("DECOMK_TOOL_REF: v0.4.2\nDEFAULT: FOO=bar\n"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if err := ValidateRefs(cfg.Defs); err != nil {
+		t.Fatalf("ValidateRefs() error: %v", err)
+	}
+	if got, err := ToolRef(cfg.Defs); err != nil || got != "v0.4.2" {
+		t.Fatalf("ToolRef(): got %q, %v want %q", got, err, "v0.4.2")
+	}
+	if got, err := ToolRef(Defs{"DEFAULT": {"FOO=bar"}}); err != nil || got != "" {
+		t.Fatalf("ToolRef(unpinned): got %q, %v want empty", got, err)
+	}
+
+	for _, defs := range []Defs{
+		{ToolRefKey: {"v1", "v2"}},
+		{ToolRefKey: {"REF=v1"}},
+		{ToolRefKey: {"v1"}, "DEFAULT": {ToolRefKey}},
+	} {
+		if err := ValidateRefs(defs); err == nil {
+			t.Fatalf("ValidateRefs(%v): expected error", defs)
+		}
+	}
+}
+
+func TestAllowKey(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Parse(strings.NewReader("DECOMK_ALLOW: Block* install-jq\nDEFAULT: FOO=bar\n"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if err := ValidateRefs(cfg.Defs); err != nil {
+		t.Fatalf("ValidateRefs() error: %v", err)
+	}
+	allow := cfg.Defs[AllowKey]
+	for name, want := range map[string]bool{"Block10_tools": true, "install-jq": true, "install-yq": false, "DEFAULT": false} {
+		if got := Allowed(allow, name); got != want {
+			t.Fatalf("Allowed(%q): got %v want %v", name, got, want)
+		}
+	}
+	if !IsDirective(AllowKey) || !IsDirective(ToolRefKey) || IsDirective("DEFAULT") {
+		t.Fatalf("IsDirective: wrong classification")
+	}
+
+	for _, defs := range []Defs{
+		{AllowKey: {"Block["}},
+		{AllowKey: {"Block*"}, "DEFAULT": {AllowKey}},
+	} {
+		if err := ValidateRefs(defs); err == nil {
+			t.Fatalf("ValidateRefs(%v): expected error", defs)
+		}
+	}
+}
+
+func TestParse_Recipes(t *testing.T) {
+	t.Parallel()
+
+	in := `
+DEFAULT: FOO=bar
+recipe install-jq: curl -fsSL -o jq 'https://example.com/jq' && touch $@
+recipe  say-hi :echo hi
+OTHER: DEFAULT
+`
+	cfg, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got, want := cfg.Recipes["install-jq"], "curl -fsSL -o jq 'https://example.com/jq' && touch $@"; got != want {
+		t.Fatalf("install-jq recipe: got %q want %q", got, want)
+	}
+	if got, want := cfg.Recipes["say-hi"], "echo hi"; got != want {
+		t.Fatalf("say-hi recipe: got %q want %q", got, want)
+	}
+	if _, ok := cfg.Defs["recipe install-jq"]; ok {
+		t.Fatalf("recipe line parsed as a key: %#v", cfg.Defs)
+	}
+	if got, want := strings.Join(cfg.Defs["OTHER"], "|"), "DEFAULT"; got != want {
+		t.Fatalf("OTHER tokens: got %q want %q", got, want)
+	}
+
+	got := string(RenderRecipes(cfg.Recipes))
+	for _, needle := range []string{
+		"install-jq:\n\tcurl -fsSL -o jq 'https://example.com/jq' && touch $@\n",
+		"say-hi:\n\techo hi\n",
+	} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("RenderRecipes() missing %q:\n%s", needle, got)
+		}
+	}
+}
+
+func TestParse_RecipeErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in      string
+		wantErr string
+	}{
+		{in: "recipe install-jq\n", wantErr: `recipe line must be "recipe <name>: <command>"`},
+		{in: "recipe a=b: echo\n", wantErr: `invalid recipe name "a=b"`},
+		{in: "recipe empty:\n", wantErr: `recipe "empty" has an empty command`},
+		{in: "recipe one: echo a\n  echo b\n", wantErr: `line 2: continuation line after recipe "one"`},
+	}
+	for _, tc := range cases {
+		_, err := Parse(strings.NewReader(tc.in))
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("Parse(%q) error: got %v want substring %q", tc.in, err, tc.wantErr)
+		}
+	}
+}
+
+func TestParse_Tags(t *testing.T) {
+	t.Parallel()
+
+	in := `
+DEFAULT: FOO=bar
+tag gui: install-vnc install-novnc
+  install-vnc install-xfce
+tag core: Block00
+tagline: FOO=baz
+`
+	cfg, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	want := Tags{
+		"gui":  {"install-vnc", "install-novnc", "install-xfce"},
+		"core": {"Block00"},
+	}
+	if !reflect.DeepEqual(cfg.Tags, want) {
+		t.Fatalf("tags: got %#v want %#v", cfg.Tags, want)
+	}
+	if got, want := strings.Join(cfg.Defs["tagline"], "|"), "FOO=baz"; got != want {
+		t.Fatalf("tagline tokens: got %q want %q", got, want)
+	}
+
+	merged := MergeTags(want, Tags{"gui": {"install-novnc", "install-x11"}, "net": {"install-curl"}})
+	if got, want := merged["gui"], []string{"install-vnc", "install-novnc", "install-xfce", "install-x11"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("MergeTags() gui: got %q want %q", got, want)
+	}
+	if got := merged["net"]; !reflect.DeepEqual(got, []string{"install-curl"}) {
+		t.Fatalf("MergeTags() net: got %q want [install-curl]", got)
+	}
+
+	for in, wantErr := range map[string]string{
+		"tag gui install-vnc\n":  `tag line must be "tag <name>: <target>..."`,
+		"tag a=b: install-vnc\n": `invalid tag name "a=b"`,
+	} {
+		if _, err := Parse(strings.NewReader(in)); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("Parse(%q) error: got %v want substring %q", in, err, wantErr)
+		}
+	}
+}
+
+func TestParse_Docs(t *testing.T) {
+	t.Parallel()
+
+	in := `
+## Tools every workspace gets.
+##
+##   Add per-repo tools to the repo's key.
+DEFAULT: FOO=bar
+
+## Dropped by the blank line.
+
+UNDOCUMENTED: FOO=baz
+## Dropped by the plain comment.
+# plain
+PLAIN: FOO=qux
+##Tight markers work too.
+  Block00: FOO=1
+## Not for the recipe.
+recipe hi: echo hi
+LATE: FOO=2
+`
+	cfg, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	want := Docs{
+		"DEFAULT": "Tools every workspace gets.\n\nAdd per-repo tools to the repo's key.",
+		"Block00": "Tight markers work too.",
+	}
+	if !reflect.DeepEqual(cfg.Docs, want) {
+		t.Fatalf("docs: got %#v want %#v", cfg.Docs, want)
+	}
+
+	merged := MergeDocs(want, Docs{"DEFAULT": "Overridden."})
+	if got := merged["DEFAULT"]; got != "Overridden." {
+		t.Fatalf("MergeDocs() DEFAULT: got %q", got)
+	}
+	if got := merged["Block00"]; got != "Tight markers work too." {
+		t.Fatalf("MergeDocs() Block00: got %q", got)
+	}
+}
+
+func TestLoadFile_CollectsSyntaxErrors(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "decomk.conf")
+	content := "DEFAULT: FOO='bar\n" +
+		"recipe broken\n" +
+		"  echo orphaned\n" +
+		"Block:\tBAR=x \\\n" +
+		"Other: BAZ=1\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	_, err := LoadFile(path)
+	var errs SyntaxErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadFile() error: got %v want SyntaxErrors", err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("LoadFile() errors: got %d want 3 (the orphaned continuation is not reported)\n%v", len(errs), err)
+	}
+	want := path + ": line 1, column 14: unterminated single-quoted string\n" +
+		"  1 | DEFAULT: FOO='bar\n" +
+		"    |              ^"
+	if got := errs[0].Error(); got != want {
+		t.Fatalf("errs[0]: got\n%s\nwant\n%s", got, want)
+	}
+	if got, want := errs[1].Error(), path+": line 2: recipe line must be"; !strings.HasPrefix(got, want) {
+		t.Fatalf("errs[1]: got %q want prefix %q", got, want)
+	}
+	want = path + ": line 4, column 14: dangling backslash escape\n" +
+		"  4 | Block:\tBAR=x \\\n" +
+		"    |       \t      ^"
+	if got := errs[2].Error(); got != want {
+		t.Fatalf("errs[2]: got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package contexts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SyntaxError is one malformed line of a config file.
+type SyntaxError struct {
+	// Path is the file the line came from; it is empty for Parse, and LoadFile
+	// fills it in.
+	Path string
+	// Line is the 1-based line number.
+	Line int
+	// Column is the 1-based rune column the error points at, or 0 when the
+	// error concerns the line as a whole.
+	Column int
+	// Text is the line as written.
+	Text string
+	Err  error
+}
+
+// Error renders e as a header line followed by the line text and, when
+// Column is known, a caret under it:
+//
+//	decomk.conf: line 3, column 14: unterminated single-quoted string
+//	  3 | DEFAULT: FOO='bar
+//	    |              ^
+func (e *SyntaxError) Error() string {
+	var b strings.Builder
+	if e.Path != "" {
+		b.WriteString(e.Path + ": ")
+	}
+	fmt.Fprintf(&b, "line %d", e.Line)
+	if e.Column > 0 {
+		fmt.Fprintf(&b, ", column %d", e.Column)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	gutter := strconv.Itoa(e.Line)
+	fmt.Fprintf(&b, "\n  %s | %s", gutter, e.Text)
+	if e.Column > 0 {
+		b.WriteString("\n  " + strings.Repeat(" ", len(gutter)) + " | ")
+		// Tabs are copied so the caret lines up however they render.
+		for i, r := range []rune(e.Text) {
+			if i == e.Column-1 {
+				break
+			}
+			if r == '\t' {
+				b.WriteByte('\t')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteByte('^')
+	}
+	return b.String()
+}
+
+func (e *SyntaxError) Unwrap() error { return e.Err }
+
+// SyntaxErrors is every malformed line Parse found in one file, in line
+// order.
+type SyntaxErrors []*SyntaxError
+
+func (errs SyntaxErrors) Error() string {
+	parts := make([]string, len(errs))
+	for i, e := range errs {
+		parts[i] = e.Error()
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (errs SyntaxErrors) Unwrap() []error {
+	out := make([]error, len(errs))
+	for i, e := range errs {
+		out[i] = e
+	}
+	return out
+}
+
+// offsetError is an error at a byte offset of the text being tokenized.
+type offsetError struct {
+	offset int
+	err    error
+}
+
+func (e *offsetError) Error() string { return e.err.Error() }
+func (e *offsetError) Unwrap() error { return e.err }
+
+// newSyntaxError returns err as a SyntaxError for line lineNum. part is the
+// text of line that err was found in: a suffix of line, possibly without
+// trailing whitespace, which an offsetError's offset is relative to.
+func newSyntaxError(lineNum int, line, part string, err error) *SyntaxError {
+	e := &SyntaxError{Line: lineNum, Text: line, Err: err}
+	if oe, ok := err.(*offsetError); ok {
+		start := len(line) - len(part)
+		if !strings.HasSuffix(line, part) {
+			start = len(strings.TrimRightFunc(line, unicode.IsSpace)) - len(part)
+		}
+		e.Column = utf8.RuneCountInString(line[:start+oe.offset]) + 1
+		e.Err = oe.err
+	}
+	return e
+}
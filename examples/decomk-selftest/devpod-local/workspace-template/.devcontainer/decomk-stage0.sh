@@ -40,13 +40,21 @@ DECOMK_CONF_URI="${DECOMK_CONF_URI:-}"
 DECOMK_REMOTE_USER="${DECOMK_REMOTE_USER:-}"
 DECOMK_REMOTE_UID="${DECOMK_REMOTE_UID:-}"
 DECOMK_FAIL_NOBOOT="${DECOMK_FAIL_NOBOOT:-false}"
+DECOMK_TOOL_ARCHIVE_KEEP="${DECOMK_TOOL_ARCHIVE_KEEP:-5}"
+DECOMK_CONF_SUBMODULES="${DECOMK_CONF_SUBMODULES:-false}"
+DECOMK_GIT_JOBS="${DECOMK_GIT_JOBS:-4}"
+DECOMK_REQUIRE_SIGNED_TOOL="${DECOMK_REQUIRE_SIGNED_TOOL:-}"
+DECOMK_TOOL_ALLOWED_SIGNERS="${DECOMK_TOOL_ALLOWED_SIGNERS:-$DECOMK_HOME/decomk/allowed_signers}"
 DECOMK_STAGE0_PHASE="$stage0_phase"
 
-export DECOMK_HOME DECOMK_LOG_DIR DECOMK_TOOL_URI DECOMK_CONF_URI DECOMK_REMOTE_USER DECOMK_REMOTE_UID DECOMK_FAIL_NOBOOT
+export DECOMK_HOME DECOMK_LOG_DIR DECOMK_TOOL_URI DECOMK_CONF_URI DECOMK_REMOTE_USER DECOMK_REMOTE_UID DECOMK_FAIL_NOBOOT DECOMK_TOOL_ARCHIVE_KEEP DECOMK_CONF_SUBMODULES DECOMK_GIT_JOBS
+export DECOMK_REQUIRE_SIGNED_TOOL DECOMK_TOOL_ALLOWED_SIGNERS
 export DECOMK_STAGE0_PHASE
 
 stage0_runtime_log=""
 stage0_fail_no_boot=""
+stage0_conf_submodules=""
+stage0_git_jobs=""
 stage0_error_step="startup"
 stage0_error_active=0
 stage0_failure_dir="$DECOMK_HOME/stage0/failure"
@@ -61,7 +69,14 @@ timestamp_utc() {
 }
 
 normalize_fail_no_boot() {
-  local raw="$1"
+  normalize_bool_setting DECOMK_FAIL_NOBOOT "$1"
+}
+
+# normalize_bool_setting prints true/false for a boolean setting value, or dies
+# naming the setting when the value is not recognized.
+normalize_bool_setting() {
+  local name="$1"
+  local raw="$2"
   local normalized="${raw,,}"
   case "$normalized" in
     ""|0|false|no|off)
@@ -71,7 +86,7 @@ normalize_fail_no_boot() {
       printf '%s' "true"
       ;;
     *)
-      die "invalid DECOMK_FAIL_NOBOOT=$raw (expected one of: true,false,1,0,yes,no,on,off)"
+      die "invalid $name=$raw (expected one of: true,false,1,0,yes,no,on,off)"
       ;;
   esac
 }
@@ -218,6 +233,21 @@ clear_stage0_failure_artifacts() {
   fi
 }
 
+# Intent: Export HTTP_PROXY/HTTPS_PROXY/NO_PROXY in both casings before any
+# git or go operation, since curl (and so git over https) ignores uppercase
+# HTTP_PROXY and some images set only one casing; sudo -E then carries both.
+# Source: DI-buvam (TODO-jirin)
+normalize_proxy_env() {
+  local upper lower value
+  for upper in HTTP_PROXY HTTPS_PROXY NO_PROXY; do
+    lower="${upper,,}"
+    value="${!upper:-${!lower:-}}"
+    if [[ -n "$value" ]]; then
+      export "$upper=$value" "$lower=$value"
+    fi
+  done
+}
+
 require_root_stage0() {
   if [[ "$(id -u)" -eq 0 ]]; then
     return 0
@@ -373,6 +403,40 @@ checkout_git_ref() {
   die "git ref not found in $repo_dir: $git_ref"
 }
 
+# Intent: Accept git bundles as tool/conf sources so air-gapped environments
+# can distribute repos through artifact stores instead of live git remotes.
+# Source: DI-jaduz (TODO-jirin)
+#
+# resolve_git_source prints the git remote to sync repo_url from: repo_url
+# itself, or, for an http(s) URL ending in .bundle, a fresh download of the
+# bundle under $DECOMK_HOME/cache/bundles (the path decomk self-update uses).
+# git reads a local .bundle path as a remote on its own.
+resolve_git_source() {
+  local repo_url="$1"
+  case "${repo_url,,}" in
+    http://*.bundle|https://*.bundle)
+      ;;
+    *)
+      printf '%s' "$repo_url"
+      return 0
+      ;;
+  esac
+
+  local bundle_dir="$DECOMK_HOME/cache/bundles"
+  local digest
+  digest="$(printf '%s' "$repo_url" | sha256sum)" || return 1
+  local bundle_path="$bundle_dir/${digest:0:16}.bundle"
+  mkdir -p "$bundle_dir" || return 1
+  echo "decomk bootstrap: downloading bundle $repo_url" >&2
+  if ! curl -fsSL -o "$bundle_path.download" "$repo_url"; then
+    rm -f "$bundle_path.download"
+    die "failed to download bundle: $repo_url"
+    return
+  fi
+  mv -f "$bundle_path.download" "$bundle_path" || return 1
+  printf '%s' "$bundle_path"
+}
+
 sync_git_repo() {
   local repo_url="$1"
   local repo_dir="$2"
@@ -381,6 +445,7 @@ sync_git_repo() {
   if [[ -z "$repo_url" ]]; then
     return 0
   fi
+  repo_url="$(resolve_git_source "$repo_url")"
 
   if [[ -d "$repo_dir/.git" ]]; then
     require_clean_git_repo "$repo_dir"
@@ -465,28 +530,271 @@ resolve_go_command() {
   die "go command not found after root escalation (PATH=$PATH); install golang or ensure sudo secure_path includes go"
 }
 
+# Intent: Build the tool into a staging GOBIN and promote it only after its
+# hidden --selfcheck passes, so a broken tool commit keeps the previous binary
+# instead of bricking the container.
+# Source: DI-dahup (TODO-jirin)
 install_decomk() {
+  local stage_dir="$DECOMK_HOME/stage0/tool-staging"
+  rm -rf "$stage_dir"
+  mkdir -p "$stage_dir"
+
+  local build_dir="" install_spec="" cache_key=""
   case "$DECOMK_TOOL_URI" in
     go:*)
-      local install_spec="${DECOMK_TOOL_URI#go:}"
+      install_spec="${DECOMK_TOOL_URI#go:}"
       if [[ -z "$install_spec" ]]; then
         die "go source URI must include module@version after go:"
       fi
-      "$stage0_go_cmd" install "$install_spec"
+      if [[ -n "$DECOMK_REQUIRE_SIGNED_TOOL" ]]; then
+        die "DECOMK_REQUIRE_SIGNED_TOOL needs a git: tool URI (signatures cannot be checked for $DECOMK_TOOL_URI)"
+      fi
+      cache_key="$(go_tool_cache_key "$install_spec")"
       ;;
     git:*)
-      local parsed tool_repo_url tool_git_ref
-      mapfile -t parsed < <(parse_git_uri "$DECOMK_TOOL_URI")
-      tool_repo_url="${parsed[0]}"
-      tool_git_ref="${parsed[1]:-}"
-      local tool_src_dir="$DECOMK_HOME/src/decomk"
-      sync_git_repo "$tool_repo_url" "$tool_src_dir" "$tool_git_ref"
-      (cd "$tool_src_dir" && "$stage0_go_cmd" install ./cmd/decomk)
+      # sync_repos has already synced the tool repo.
+      build_dir="$DECOMK_HOME/src/decomk"
+      install_spec="./cmd/decomk"
+      if [[ -n "$DECOMK_REQUIRE_SIGNED_TOOL" ]]; then
+        local parsed
+        mapfile -t parsed < <(parse_git_uri "$DECOMK_TOOL_URI")
+        if ! verify_tool_signature "$build_dir" "${parsed[1]:-}" "$DECOMK_TOOL_ALLOWED_SIGNERS"; then
+          keep_previous_decomk "refusing to build unsigned tool update"
+          return
+        fi
+      fi
+      cache_key="$(git -C "$build_dir" rev-parse --verify HEAD)"
       ;;
     *)
       die "invalid DECOMK_TOOL_URI=$DECOMK_TOOL_URI (expected go:... or git:...)"
       ;;
   esac
+
+  local cache_entry=""
+  if [[ -n "$cache_key" ]]; then
+    cache_entry="$(tool_build_cache_path "$cache_key")"
+  fi
+  if [[ -n "$cache_entry" && -f "$cache_entry" ]]; then
+    echo "decomk bootstrap: using cached build $cache_entry"
+    cp "$cache_entry" "$stage_dir/decomk"
+    touch "$cache_entry"
+    promote_decomk "$stage_dir/decomk" "$cache_entry" cached
+    return
+  fi
+
+  local goflags="${GOFLAGS:-}"
+  if [[ " $goflags " != *" -trimpath "* ]]; then
+    goflags="${goflags:+$goflags }-trimpath"
+  fi
+  if ! (cd "${build_dir:-.}" && GOBIN="$stage_dir" GOFLAGS="$goflags" GOMODCACHE="${GOMODCACHE:-$DECOMK_HOME/cache/gomod}" "$stage0_go_cmd" install "$install_spec"); then
+    keep_previous_decomk "build of ${build_dir:-$install_spec} failed"
+    return
+  fi
+  promote_decomk "$stage_dir/decomk" "$cache_entry"
+}
+
+# Intent: Build only tool code a trusted key vouched for when
+# DECOMK_REQUIRE_SIGNED_TOOL is set, matching decomk self-update
+# -require-signed-tool: the checked-out commit, or the annotated tag named by
+# the URI's ref when it points at that commit, must carry an SSH signature by
+# a key in the allowed-signers file.
+# Source: DI-banuz (TODO-jirin)
+verify_tool_signature() {
+  local repo_dir="$1"
+  local git_ref="$2"
+  local signers="$3"
+  if [[ ! -f "$signers" ]]; then
+    echo "decomk bootstrap: DECOMK_REQUIRE_SIGNED_TOOL: allowed signers file not found: $signers" >&2
+    return 1
+  fi
+  if verify_ssh_signed "$repo_dir" commit HEAD "$signers"; then
+    return 0
+  fi
+  if [[ -z "$git_ref" ]]; then
+    return 1
+  fi
+  local head tag
+  head="$(git -C "$repo_dir" rev-parse --verify HEAD)"
+  # checkout_git_ref checks tags out by name, or from FETCH_HEAD when it had
+  # to fetch the ref.
+  for tag in "refs/tags/$git_ref" FETCH_HEAD; do
+    if [[ "$(git -C "$repo_dir" cat-file -t "$tag" 2>/dev/null)" != tag ]]; then
+      continue
+    fi
+    if [[ "$(git -C "$repo_dir" rev-parse --verify "$tag^{commit}")" != "$head" ]]; then
+      continue
+    fi
+    verify_ssh_signed "$repo_dir" tag "$tag" "$signers"
+    return
+  done
+  return 1
+}
+
+# verify_ssh_signed checks that the commit or tag rev carries an SSH signature
+# by a principal in signers. Only SSH signatures count: a GPG signature would
+# be checked against the keyring rather than the signers file.
+verify_ssh_signed() {
+  local repo_dir="$1"
+  local kind="$2"
+  local rev="$3"
+  local signers="$4"
+  local raw
+  if ! raw="$(git -C "$repo_dir" cat-file "$kind" "$rev")"; then
+    return 1
+  fi
+  if [[ "$raw" != *"-----BEGIN SSH SIGNATURE-----"* ]]; then
+    echo "decomk bootstrap: $kind $rev in $repo_dir is not signed with an SSH key" >&2
+    return 1
+  fi
+  if ! git -C "$repo_dir" -c "gpg.ssh.allowedSignersFile=$signers" -c gpg.minTrustLevel=fully "verify-$kind" "$rev" >&2; then
+    echo "decomk bootstrap: $kind $rev in $repo_dir is not signed by a key in $signers" >&2
+    return 1
+  fi
+}
+
+# go_tool_cache_key prints the cache key for a go: URI payload
+# (module@version), or nothing when the version is a branch or query such as
+# @stable whose commit is only known after go resolves it. Keys are escaped
+# like url.PathEscape, which for module paths only touches '/'.
+go_tool_cache_key() {
+  local spec="$1"
+  local version="${spec##*@}"
+  if [[ "$spec" != *@* || ! "$version" =~ ^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+incompatible)?$ ]]; then
+    return 0
+  fi
+  if [[ ! "$spec" =~ ^[A-Za-z0-9._~/@+-]+$ ]]; then
+    return 0
+  fi
+  printf '%s' "${spec//\//%2F}"
+}
+
+# Intent: Share decomk self-update's build cache
+# (<DECOMK_HOME>/decomk/bin/cache/<source>-<GOOS>-<GOARCH>) so a container
+# start against an unchanged tool commit does not rebuild it.
+# Source: DI-kosid (TODO-jirin)
+tool_build_cache_path() {
+  local key="$1"
+  local goos goarch
+  goos="$("$stage0_go_cmd" env GOOS)" || return 1
+  goarch="$("$stage0_go_cmd" env GOARCH)" || return 1
+  printf '%s/decomk/bin/cache/%s-%s-%s' "$DECOMK_HOME" "$key" "$goos" "$goarch"
+}
+
+# cache_tool_build stores a build that passed --selfcheck in the tool build
+# cache, then prunes the cache to its ten most recently used builds like
+# decomk self-update does.
+cache_tool_build() {
+  local binary="$1"
+  local entry="$2"
+  local cache_dir
+  cache_dir="$(dirname "$entry")"
+  mkdir -p "$cache_dir"
+  local tmp
+  tmp="$cache_dir/.$(basename "$entry").tmp.$$"
+  cp "$binary" "$tmp"
+  mv -f "$tmp" "$entry"
+
+  local -a builds
+  mapfile -t builds < <(find "$cache_dir" -maxdepth 1 -type f ! -name '.*' -printf '%T@ %p\n' | sort -rn | cut -d' ' -f2-)
+  local i
+  for (( i = 10; i < ${#builds[@]}; i++ )); do
+    rm -f "${builds[i]}"
+  done
+}
+
+# promote_decomk moves a freshly built binary into the Go bin dir after it
+# passes --selfcheck. A cache entry, when given, receives a fresh build that
+# passed, or is dropped when it is the cached build that failed ($3=cached).
+promote_decomk() {
+  local candidate="$1"
+  local cache_entry="${2:-}"
+  local from_cache="${3:-}"
+  if [[ ! -x "$candidate" ]]; then
+    keep_previous_decomk "build produced no binary at $candidate"
+    return
+  fi
+  local selfcheck_out
+  if ! selfcheck_out="$("$candidate" --selfcheck)"; then
+    if [[ -n "$from_cache" ]]; then
+      # Drop the bad build so the next start rebuilds it.
+      rm -f "$cache_entry"
+    fi
+    keep_previous_decomk "new binary failed --selfcheck"
+    return
+  fi
+  echo "$selfcheck_out"
+  if [[ -n "$cache_entry" && -z "$from_cache" ]]; then
+    cache_tool_build "$candidate" "$cache_entry"
+  fi
+
+  archive_decomk "$candidate" "$selfcheck_out"
+
+  local go_bin_dir
+  go_bin_dir="$(resolve_go_bin_dir)" || die "could not resolve Go bin dir (GOBIN/GOPATH) for decomk install"
+  mkdir -p "$go_bin_dir"
+  mv -f "$candidate" "$go_bin_dir/decomk"
+}
+
+# Intent: Keep the last few promoted binaries with their version/commit so
+# `decomk self-update rollback` can restore a last-known-good build.
+# Source: DI-mozaf (TODO-jirin)
+archive_decomk() {
+  local binary="$1"
+  local selfcheck_out="$2"
+  local archive_dir="$DECOMK_HOME/decomk/bin/archive"
+  if [[ ! "$DECOMK_TOOL_ARCHIVE_KEEP" =~ ^[0-9]+$ ]]; then
+    die "invalid DECOMK_TOOL_ARCHIVE_KEEP=$DECOMK_TOOL_ARCHIVE_KEEP (expected a non-negative integer)"
+  fi
+  if [[ "$DECOMK_TOOL_ARCHIVE_KEEP" -eq 0 ]]; then
+    return 0
+  fi
+  mkdir -p "$archive_dir"
+
+  local digest
+  digest="$(sha256sum "$binary")"
+  digest="${digest%% *}"
+
+  local -a entries
+  mapfile -t entries < <(find "$archive_dir" -maxdepth 1 -name 'decomk-*.info' -printf '%f\n' | sort)
+  if [[ ${#entries[@]} -gt 0 ]] && grep -qx "sha256=$digest" "$archive_dir/${entries[${#entries[@]}-1]}"; then
+    return 0
+  fi
+
+  local name
+  name="decomk-$(date -u +%Y%m%dT%H%M%SZ)-$$"
+  cp "$binary" "$archive_dir/$name"
+  {
+    printf '%s\n' "${selfcheck_out#decomk selfcheck ok: }" | tr ' ' '\n'
+    printf 'source=%s\n' "$DECOMK_TOOL_URI"
+    printf 'sha256=%s\n' "$digest"
+  } >"$archive_dir/$name.info"
+
+  mapfile -t entries < <(find "$archive_dir" -maxdepth 1 -name 'decomk-*.info' -printf '%f\n' | sort)
+  local excess=$(( ${#entries[@]} - DECOMK_TOOL_ARCHIVE_KEEP ))
+  local i
+  for (( i = 0; i < excess; i++ )); do
+    rm -f "$archive_dir/${entries[i]%.info}" "$archive_dir/${entries[i]}"
+  done
+}
+
+# keep_previous_decomk continues with the installed binary after a failed
+# update, or fails when there is nothing to fall back to.
+keep_previous_decomk() {
+  local reason="$1"
+  local previous
+  if previous="$(resolve_decomk_binary)"; then
+    echo "decomk bootstrap: warning: $reason; keeping previous binary $previous" >&2
+    return 0
+  fi
+  die "$reason and no previous decomk binary is installed"
+}
+
+sync_tool_repo() {
+  local parsed tool_repo_url tool_git_ref
+  mapfile -t parsed < <(parse_git_uri "$DECOMK_TOOL_URI")
+  tool_repo_url="${parsed[0]}"
+  tool_git_ref="${parsed[1]:-}"
+  sync_git_repo "$tool_repo_url" "$DECOMK_HOME/src/decomk" "$tool_git_ref"
 }
 
 sync_conf_repo() {
@@ -501,6 +809,13 @@ sync_conf_repo() {
       conf_repo_url="${parsed[0]}"
       conf_git_ref="${parsed[1]:-}"
       sync_git_repo "$conf_repo_url" "$DECOMK_HOME/conf" "$conf_git_ref"
+      # Intent: Check out vendored recipe libraries (git submodules) on
+      # opt-in so they are present when make runs, without adding submodule
+      # fetches to conf repos that do not need them.
+      # Source: DI-pitif (TODO-jirin)
+      if [[ "$stage0_conf_submodules" == "true" ]]; then
+        git -C "$DECOMK_HOME/conf" submodule update --init --recursive --jobs "$stage0_git_jobs"
+      fi
       ;;
     *)
       die "invalid DECOMK_CONF_URI=$DECOMK_CONF_URI (expected git:...)"
@@ -508,6 +823,89 @@ sync_conf_repo() {
   esac
 }
 
+# Intent: Sync the git tool repo and the conf repo concurrently, at most
+# DECOMK_GIT_JOBS at a time, to cut cold-start latency on fresh containers
+# where each clone waits on the network; each job's output is prefixed with
+# its repo label so the shared log stays readable.
+# Source: DI-vasop (TODO-jirin)
+sync_repos() {
+  local -a labels=() jobs=()
+  if [[ "$DECOMK_TOOL_URI" == git:* ]]; then
+    labels+=("tool")
+    jobs+=("sync_tool_repo")
+  fi
+  if [[ -n "$DECOMK_CONF_URI" ]]; then
+    labels+=("conf")
+    jobs+=("sync_conf_repo")
+  fi
+  if [[ ${#jobs[@]} -eq 0 ]]; then
+    return 0
+  fi
+  echo "decomk bootstrap: syncing ${#jobs[@]} repo(s), up to $stage0_git_jobs at a time"
+
+  # Jobs start in order; when the limit is reached the oldest running job is
+  # waited on first, which needs no bash 5.1 wait -n -p.
+  local -a pids=()
+  local failed=0 i
+  for i in "${!jobs[@]}"; do
+    if [[ ${#pids[@]} -ge $stage0_git_jobs ]]; then
+      if ! wait "${pids[0]}"; then
+        failed=$((failed + 1))
+      fi
+      pids=("${pids[@]:1}")
+    fi
+    run_git_job "${labels[i]}" "${jobs[i]}" &
+    pids+=("$!")
+  done
+  for i in "${pids[@]}"; do
+    if ! wait "$i"; then
+      failed=$((failed + 1))
+    fi
+  done
+  if [[ $failed -gt 0 ]]; then
+    die "$failed repo sync job(s) failed"
+  fi
+}
+
+# run_git_job runs the sync function job in the background job it is called
+# from, prefixing its output with label and reporting how long it took.
+run_git_job() {
+  local label="$1"
+  local job="$2"
+  local start="$SECONDS"
+  local rc
+  # The job reports its own failure; the ERR trap belongs to the main shell.
+  trap - ERR
+  set +e
+  (set -e; "$job") 2>&1 | prefix_lines "decomk bootstrap: [$label] "
+  rc="$?"
+  set -e
+  if [[ $rc -ne 0 ]]; then
+    echo "decomk bootstrap: [$label] sync failed after $((SECONDS - start))s (rc=$rc)" >&2
+    return "$rc"
+  fi
+  echo "decomk bootstrap: [$label] synced in $((SECONDS - start))s"
+}
+
+# prefix_lines copies stdin to stdout with prefix before every line.
+prefix_lines() {
+  local prefix="$1"
+  local line
+  while IFS= read -r line || [[ -n "$line" ]]; do
+    printf '%s%s\n' "$prefix" "$line"
+  done
+}
+
+# normalize_git_jobs prints the DECOMK_GIT_JOBS limit, or dies when it is not
+# a positive integer.
+normalize_git_jobs() {
+  local raw="$1"
+  if [[ ! "$raw" =~ ^[0-9]+$ ]] || [[ "$raw" -eq 0 ]]; then
+    die "invalid DECOMK_GIT_JOBS=$raw (expected a positive integer)"
+  fi
+  printf '%s' "$((10#$raw))"
+}
+
 resolve_decomk_binary() {
   local go_bin_dir
   go_bin_dir="$(resolve_go_bin_dir)"
@@ -523,11 +921,16 @@ resolve_decomk_binary() {
 }
 
 stage0_fail_no_boot="$(normalize_fail_no_boot "$DECOMK_FAIL_NOBOOT")"
+stage0_conf_submodules="$(normalize_bool_setting DECOMK_CONF_SUBMODULES "$DECOMK_CONF_SUBMODULES")"
+stage0_git_jobs="$(normalize_git_jobs "$DECOMK_GIT_JOBS")"
 trap 'stage0_error_handler "$?" "$LINENO"' ERR
 
 stage0_error_step="validate-remote-identity"
 validate_remote_identity_before_escalation
 
+stage0_error_step="normalize-proxy-env"
+normalize_proxy_env
+
 stage0_error_step="require-root"
 require_root_stage0 "$@"
 
@@ -543,15 +946,21 @@ setup_runtime_log
 stage0_error_step="stage0-identity"
 emit_stage0_identity_marker
 
+stage0_error_step="sync-repos"
+sync_repos
+
 stage0_error_step="install-decomk"
 install_decomk
 
-stage0_error_step="sync-conf-repo"
-sync_conf_repo
-
+# Intent: Honor DECOMK_CONF_PATH (config subdirectory inside the conf repo
+# clone) so the availability check matches where decomk reads decomk.conf.
+# Source: DI-hilut (TODO-jirin)
 stage0_error_step="validate-conf-availability"
-if [[ -z "$DECOMK_CONF_URI" ]] && [[ ! -f "$DECOMK_HOME/conf/decomk.conf" ]]; then
-  die "no DECOMK_CONF_URI and no $DECOMK_HOME/conf/decomk.conf; skipping decomk run"
+stage0_conf_dir="$DECOMK_HOME/conf${DECOMK_CONF_PATH:+/$DECOMK_CONF_PATH}"
+# DECOMK_CONF_SRC (a pinned config tarball) and DECOMK_CONF_OCI (a pinned OCI
+# artifact) are unpacked by decomk itself.
+if [[ -z "$DECOMK_CONF_URI" ]] && [[ -z "${DECOMK_CONF_SRC:-}${DECOMK_CONF_OCI:-}" ]] && [[ ! -f "$stage0_conf_dir/decomk.conf" ]]; then
+  die "no DECOMK_CONF_URI, DECOMK_CONF_SRC, or DECOMK_CONF_OCI, and no $stage0_conf_dir/decomk.conf; skipping decomk run"
 fi
 
 stage0_error_step="resolve-decomk-binary"
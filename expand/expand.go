@@ -8,6 +8,12 @@
 // The implementation adds guardrails that are easy to unit test:
 //   - cycle detection
 //   - maximum expansion depth
+//   - maximum total output size (with an earlier warning threshold)
+//
+// Conditional tokens of the form `?NAME=value -> TOKEN` include TOKEN (expanded
+// like any other token) only when the most recent NAME=... tuple emitted so far
+// has exactly that value, and `?(EXPR) -> TOKEN` only when EXPR (see Expr)
+// holds over those tuples; see ParseCondition.
 //
 // Expansion is intentionally not "Makefile evaluation":
 //   - no variable interpolation
@@ -18,6 +24,8 @@ package expand
 import (
 	"fmt"
 	"strings"
+
+	"github.com/stevegt/decomk/resolve"
 )
 
 // Defs maps a macro name to a list of tokens.
@@ -29,6 +37,64 @@ type Defs map[string][]string
 type Options struct {
 	// MaxDepth limits recursive expansion depth. If zero, a default is used.
 	MaxDepth int
+
+	// MaxTokens limits the total number of output tokens. If zero,
+	// DefaultMaxTokens is used.
+	MaxTokens int
+	// WarnTokens is the output size past which Warn is called once. If zero,
+	// DefaultWarnTokens is used.
+	WarnTokens int
+	// Warn, when non-nil, receives a message when the output grows past
+	// WarnTokens.
+	Warn func(msg string)
+
+	// Trace, when non-nil, receives one entry per output token describing how
+	// it was derived.
+	Trace *Trace
+
+	// Values seeds the variables conditions see before any tuple is emitted
+	// (for example platform facts); emitted tuples override them.
+	Values map[string]string
+	// Conditions, when non-nil, receives one entry per conditional token
+	// evaluated, in evaluation order.
+	Conditions *[]ConditionResult
+}
+
+// ConditionResult is one evaluation of a conditional token.
+type ConditionResult struct {
+	Token  string `json:"token"`
+	Passed bool   `json:"passed"`
+	// Path lists the macro keys the token was found through, outermost first.
+	Path []string `json:"path"`
+}
+
+// Default output size limits for ExpandTokens.
+//
+// Intent: Fail pathological configs (many contexts multiplying shared blocks)
+// with a clear error instead of producing a make argv that exceeds OS limits.
+// Source: DI-gomis (TODO-jirin)
+const (
+	DefaultMaxTokens  = 10000
+	DefaultWarnTokens = 2000
+)
+
+// Trace records the derivation of every token ExpandTokens produced, in output
+// order.
+//
+// Intent: Let users see exactly how DEFAULT -> Block10 -> FOO=x produced the
+// final make argv when debugging macro layering.
+// Source: DI-zigaz (TODO-jirin)
+type Trace struct {
+	Entries []TraceEntry `json:"entries"`
+}
+
+// TraceEntry is the derivation of one output token.
+type TraceEntry struct {
+	Token string `json:"token"`
+	// Path lists the macro keys (and passing conditional tokens) the token was
+	// expanded through, outermost first. It is empty for seed tokens that are
+	// not macros.
+	Path []string `json:"path"`
 }
 
 // ExpandTokens expands any macro tokens found in tokens.
@@ -37,14 +103,53 @@ func ExpandTokens(defs Defs, tokens []string, opts Options) ([]string, error) {
 	if maxDepth <= 0 {
 		maxDepth = 64
 	}
+	maxTokens := opts.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = DefaultMaxTokens
+	}
+	warnTokens := opts.WarnTokens
+	if warnTokens <= 0 {
+		warnTokens = DefaultWarnTokens
+	}
+	emitted := 0
 
 	visiting := make(map[string]bool, len(defs))
 	var stack []string
+	// values tracks the latest value of each tuple emitted so far, in output
+	// order, for conditional tokens.
+	values := make(map[string]string, len(opts.Values))
+	for name, value := range opts.Values {
+		values[name] = value
+	}
+
+	// emit records a literal output token.
+	emit := func(out []string, tok string) ([]string, error) {
+		emitted++
+		if emitted > maxTokens {
+			from := "seed tokens"
+			if len(stack) > 0 {
+				from = strings.Join(stack, " -> ")
+			}
+			return nil, fmt.Errorf("max expansion size exceeded (%d tokens) at %q from %s; check for contexts repeating large blocks", maxTokens, tok, from)
+		}
+		if emitted == warnTokens+1 && opts.Warn != nil {
+			opts.Warn(fmt.Sprintf("expansion produced more than %d tokens; large expansions may exceed make argv limits", warnTokens))
+		}
+		_, tuple := resolve.SplitClass(tok)
+		if name, value, ok := resolve.SplitTuple(tuple); ok {
+			values[name] = value
+		}
+		if opts.Trace != nil {
+			opts.Trace.Entries = append(opts.Trace.Entries, TraceEntry{Token: tok, Path: append([]string{}, stack...)})
+		}
+		return append(out, tok), nil
+	}
 
 	// expandKey expands one macro name into a flat token list.
 	// It carries an explicit recursion depth counter so callers can enforce a
 	// hard limit on expansion complexity.
 	var expandKey func(key string, depth int) ([]string, error)
+	var expandList func(tokens []string, depth int) ([]string, error)
 	expandKey = func(key string, depth int) ([]string, error) {
 		if depth > maxDepth {
 			return nil, fmt.Errorf("max expansion depth exceeded (%d) while expanding %q", maxDepth, key)
@@ -63,35 +168,162 @@ func ExpandTokens(defs Defs, tokens []string, opts Options) ([]string, error) {
 		visiting[key] = true
 		stack = append(stack, key)
 
+		out, err := expandList(body, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		stack = stack[:len(stack)-1]
+		visiting[key] = false
+		return out, nil
+	}
+
+	// expandList expands a token list whose macros sit at the given depth.
+	expandList = func(tokens []string, depth int) ([]string, error) {
 		var out []string
-		for _, tok := range body {
+		for _, tok := range tokens {
+			conditional := false
+			if cond, ok := ParseCondition(tok); ok {
+				passed, err := cond.Eval(values)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", strings.Join(append(append([]string(nil), stack...), tok), " -> "), err)
+				}
+				if opts.Conditions != nil {
+					*opts.Conditions = append(*opts.Conditions, ConditionResult{Token: tok, Passed: passed, Path: append([]string{}, stack...)})
+				}
+				if !passed {
+					continue
+				}
+				// The passing conditional shows up in trace paths and cycle
+				// reports like a macro key.
+				stack = append(stack, tok)
+				conditional = true
+				tok = cond.Then
+			}
 			if _, isMacro := defs[tok]; isMacro {
-				expanded, err := expandKey(tok, depth+1)
+				expanded, err := expandKey(tok, depth)
 				if err != nil {
 					return nil, err
 				}
 				out = append(out, expanded...)
-				continue
+			} else {
+				var err error
+				if out, err = emit(out, tok); err != nil {
+					return nil, err
+				}
+			}
+			if conditional {
+				stack = stack[:len(stack)-1]
 			}
-			out = append(out, tok)
 		}
-
-		stack = stack[:len(stack)-1]
-		visiting[key] = false
 		return out, nil
 	}
 
-	var out []string
-	for _, tok := range tokens {
-		if _, isMacro := defs[tok]; isMacro {
-			expanded, err := expandKey(tok, 1)
-			if err != nil {
-				return nil, err
-			}
-			out = append(out, expanded...)
+	return expandList(tokens, 1)
+}
+
+// Reachable returns the keys of defs reachable from seeds without evaluating
+// conditions: a key is reachable if it is a seed or appears in the body of a
+// reachable key, directly or as the guarded token of a conditional.
+//
+// Intent: Find config stanzas that no context can ever expand, so maintainers
+// can prune them.
+// Source: DI-pokig (TODO-jirin)
+func Reachable(defs Defs, seeds []string) map[string]bool {
+	reached := make(map[string]bool)
+	queue := append([]string(nil), seeds...)
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		if _, ok := defs[key]; !ok || reached[key] {
 			continue
 		}
-		out = append(out, tok)
+		reached[key] = true
+		for _, tok := range defs[key] {
+			if cond, ok := ParseCondition(tok); ok {
+				tok = cond.Then
+			}
+			queue = append(queue, tok)
+		}
+	}
+	return reached
+}
+
+// Condition is a parsed conditional token: `?NAME=value -> TOKEN` or
+// `?(EXPR) -> TOKEN`.
+type Condition struct {
+	// Guard is the text between "?" and "->", for example "GPU=1" or
+	// `(GPU == "1" && DECOMK_ARCH == "amd64")`.
+	Guard string
+	// Then is the guarded token.
+	Then string
+
+	// name and value are the exact-match test of the ?NAME=value form; expr
+	// is set for the ?(EXPR) form.
+	name, value string
+	expr        *Expr
+}
+
+// Eval reports whether c's guard holds over values.
+func (c Condition) Eval(values map[string]string) (bool, error) {
+	if c.expr != nil {
+		return c.expr.Eval(values)
+	}
+	return values[c.name] == c.value, nil
+}
+
+// ParseCondition splits a conditional token `?NAME=value -> TOKEN` or
+// `?(EXPR) -> TOKEN` into its guard and guarded token. Whitespace around "->"
+// is optional. It returns ok=false for tokens that are not conditionals,
+// including ones whose expression does not parse (see CheckCondition).
+//
+// Intent: Let a single context include optional blocks driven by previously
+// resolved variables, for example GPU blocks only when GPU=1.
+// Source: DI-viraj (TODO-jirin)
+func ParseCondition(tok string) (Condition, bool) {
+	cond, err := parseCondition(tok)
+	return cond, err == nil
+}
+
+// CheckCondition returns why tok, which starts with "?", is not a valid
+// conditional token, or nil.
+func CheckCondition(tok string) error {
+	_, err := parseCondition(tok)
+	return err
+}
+
+func parseCondition(tok string) (Condition, error) {
+	errShape := fmt.Errorf("invalid conditional token %q (expected ?NAME=value -> TOKEN or ?(EXPR) -> TOKEN)", tok)
+	if !strings.HasPrefix(tok, "?") {
+		return Condition{}, errShape
+	}
+	var cond Condition
+	var rest string
+	if n, ok := ScanGuard(tok); ok {
+		expr, err := ParseExpr(tok[2 : n-1])
+		if err != nil {
+			return Condition{}, fmt.Errorf("invalid conditional token %q: %w", tok, err)
+		}
+		cond.Guard, cond.expr = tok[1:n], expr
+		rest, ok = strings.CutPrefix(strings.TrimSpace(tok[n:]), "->")
+		if !ok {
+			return Condition{}, errShape
+		}
+	} else {
+		guard, then, found := strings.Cut(tok[1:], "->")
+		if !found {
+			return Condition{}, errShape
+		}
+		name, value, isTuple := resolve.SplitTuple(strings.TrimSpace(guard))
+		if !isTuple {
+			return Condition{}, errShape
+		}
+		cond.Guard, cond.name, cond.value = strings.TrimSpace(guard), name, value
+		rest = then
+	}
+	cond.Then = strings.TrimSpace(rest)
+	if cond.Then == "" || strings.ContainsAny(cond.Then, " \t") {
+		return Condition{}, errShape
 	}
-	return out, nil
+	return cond, nil
 }
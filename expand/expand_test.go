@@ -95,3 +95,214 @@ func TestExpandTokens_MaxDepth(t *testing.T) {
 		t.Fatalf("ExpandTokens() expected error, got nil")
 	}
 }
+
+func TestExpandTokens_MaxTokens(t *testing.T) {
+	t.Parallel()
+
+	defs := Defs{
+		"DEFAULT": {"block", "block", "block"},
+		"block":   {"A=1", "B=2"},
+	}
+
+	var warnings []string
+	opts := Options{
+		MaxTokens:  5,
+		WarnTokens: 3,
+		Warn:       func(msg string) { warnings = append(warnings, msg) },
+	}
+	_, err := ExpandTokens(defs, []string{"DEFAULT"}, opts)
+	if err == nil || !strings.Contains(err.Error(), "max expansion size exceeded (5 tokens)") || !strings.Contains(err.Error(), "DEFAULT -> block") {
+		t.Fatalf("ExpandTokens() error: got %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "more than 3 tokens") {
+		t.Fatalf("warnings: got %q", warnings)
+	}
+
+	opts.MaxTokens = 6
+	warnings = nil
+	out, err := ExpandTokens(defs, []string{"DEFAULT"}, opts)
+	if err != nil {
+		t.Fatalf("ExpandTokens(MaxTokens=6) error: %v", err)
+	}
+	if len(out) != 6 || len(warnings) != 1 {
+		t.Fatalf("ExpandTokens(MaxTokens=6): got %d tokens, %d warnings", len(out), len(warnings))
+	}
+}
+
+func TestExpandTokens_Conditionals(t *testing.T) {
+	t.Parallel()
+
+	defs := Defs{
+		"DEFAULT":   {"GPU=0", "?GPU=1 -> Block_gpu", "gpuhost"},
+		"gpuhost":   {"GPU=1", "?GPU=1 -> Block_gpu", "?GPU=0 -> NOGPU=1"},
+		"Block_gpu": {"CUDA=12"},
+	}
+
+	out, err := ExpandTokens(defs, []string{"DEFAULT"}, Options{})
+	if err != nil {
+		t.Fatalf("ExpandTokens() error: %v", err)
+	}
+	if got, want := strings.Join(out, "|"), "GPU=0|GPU=1|CUDA=12"; got != want {
+		t.Fatalf("out: got %q want %q", got, want)
+	}
+}
+
+func TestExpandTokens_Trace(t *testing.T) {
+	t.Parallel()
+
+	defs := Defs{
+		"DEFAULT":   {"Block10", "GPU=1", "?GPU=1 -> Block_gpu"},
+		"Block10":   {"FOO=x"},
+		"Block_gpu": {"CUDA=12"},
+	}
+
+	trace := &Trace{}
+	out, err := ExpandTokens(defs, []string{"DEFAULT", "BAR=y"}, Options{Trace: trace})
+	if err != nil {
+		t.Fatalf("ExpandTokens() error: %v", err)
+	}
+	if got, want := len(trace.Entries), len(out); got != want {
+		t.Fatalf("trace entries: got %d want %d", got, want)
+	}
+	var lines []string
+	for _, entry := range trace.Entries {
+		lines = append(lines, entry.Token+" <- "+strings.Join(entry.Path, " > "))
+	}
+	want := []string{
+		"FOO=x <- DEFAULT > Block10",
+		"GPU=1 <- DEFAULT",
+		"CUDA=12 <- DEFAULT > ?GPU=1 -> Block_gpu > Block_gpu",
+		"BAR=y <- ",
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Fatalf("trace: got\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestReachable(t *testing.T) {
+	t.Parallel()
+
+	defs := Defs{
+		"DEFAULT": {"Block00", "A=1", "?GPU=1 -> Block20"},
+		"Block00": {"Block10"},
+		"Block10": {"B=2", "DEFAULT"},
+		"Block20": {"C=3"},
+		"Dead":    {"Block00"},
+		"Orphan":  {"D=4"},
+	}
+	got := Reachable(defs, []string{"DEFAULT", "missing"})
+	want := map[string]bool{"DEFAULT": true, "Block00": true, "Block10": true, "Block20": true}
+	if len(got) != len(want) {
+		t.Fatalf("Reachable(): got %v want %v", got, want)
+	}
+	for key := range want {
+		if !got[key] {
+			t.Fatalf("Reachable(): missing %q in %v", key, got)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	t.Parallel()
+
+	cond, ok := ParseCondition("?GPU=1->Block_gpu")
+	if passed, err := cond.Eval(map[string]string{"GPU": "1"}); !ok || cond.Guard != "GPU=1" || cond.Then != "Block_gpu" || !passed || err != nil {
+		t.Fatalf("ParseCondition(): got (%+v, %v), Eval() %v, %v", cond, ok, passed, err)
+	}
+	cond, ok = ParseCondition(`?(GPU == "1" && ARCH != "arm64") -> Block_gpu`)
+	if !ok || cond.Guard != `(GPU == "1" && ARCH != "arm64")` || cond.Then != "Block_gpu" {
+		t.Fatalf("ParseCondition(expr): got (%+v, %v)", cond, ok)
+	}
+	if passed, err := cond.Eval(map[string]string{"GPU": "1", "ARCH": "amd64"}); !passed || err != nil {
+		t.Fatalf("Condition.Eval(): got %v, %v want true, nil", passed, err)
+	}
+	for _, tok := range []string{"GPU=1", "?GPU -> X", "?GPU=1 ->", "?GPU=1 -> A B", "?(GPU ==) -> X", "?(GPU) X"} {
+		if _, ok := ParseCondition(tok); ok {
+			t.Fatalf("ParseCondition(%q): got ok, want not a conditional", tok)
+		}
+	}
+	if err := CheckCondition("?(GPU ==) -> X"); err == nil || !strings.Contains(err.Error(), "unexpected end") {
+		t.Fatalf("CheckCondition(): got %v want the expression error", err)
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]string{"GPU": "1", "OS": "linux", "VER": "22.04", "EMPTY": "", "OFF": "false"}
+	cases := map[string]bool{
+		`GPU`:                            true,
+		`EMPTY || OFF || UNSET`:          false,
+		`!OFF && OS == "linux"`:          true,
+		`OS != 'linux' || GPU == 1`:      true,
+		`VER >= 22.04 && VER < 24.4`:     true,
+		`VER > "22.4"`:                   false,
+		`1.10 > 1.9`:                     true,
+		`OS =~ "^(linux|darwin)$"`:       true,
+		`!(GPU == "1" && OS == "plan9")`: true,
+		`"a\"b" == 'a"b'`:                true,
+	}
+	for src, want := range cases {
+		expr, err := ParseExpr(src)
+		if err != nil {
+			t.Fatalf("ParseExpr(%s): %v", src, err)
+		}
+		if got, err := expr.Eval(values); err != nil || got != want {
+			t.Fatalf("Eval(%s): got %v, %v want %v", src, got, err, want)
+		}
+	}
+
+	expr, err := ParseExpr(`OS < 2`)
+	if err != nil {
+		t.Fatalf("ParseExpr(): %v", err)
+	}
+	if _, err := expr.Eval(values); err == nil {
+		t.Fatalf("Eval(OS < 2): got nil want an ordering error")
+	}
+	for _, src := range []string{``, `GPU ==`, `(GPU`, `GPU GPU`, `OS =~ GPU`, `OS =~ "("`, `"open`, `GPU $ 1`} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Fatalf("ParseExpr(%q): got nil want error", src)
+		}
+	}
+}
+
+func TestScanGuard(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]int{
+		`?(A == "x)") -> B`: 12,
+		`?((A) || B)`:       11,
+		`?(A`:               0,
+		`?A=1`:              0,
+	}
+	for s, want := range cases {
+		if got, ok := ScanGuard(s); got != want || ok != (want > 0) {
+			t.Fatalf("ScanGuard(%q): got %d, %v want %d", s, got, ok, want)
+		}
+	}
+}
+
+func TestExpandTokens_ExprConditionals(t *testing.T) {
+	t.Parallel()
+
+	defs := Defs{
+		"DEFAULT":   {"GPU=1", `?(GPU == "1" && DECOMK_ARCH == "amd64") -> Block_gpu`, `?(DECOMK_OS != "linux") -> OTHER=1`},
+		"Block_gpu": {"CUDA=12"},
+	}
+	var conditions []ConditionResult
+	got, err := ExpandTokens(defs, []string{"DEFAULT"}, Options{Values: map[string]string{"DECOMK_ARCH": "amd64", "DECOMK_OS": "linux"}, Conditions: &conditions})
+	if err != nil {
+		t.Fatalf("ExpandTokens(): %v", err)
+	}
+	if strings.Join(got, " ") != "GPU=1 CUDA=12" {
+		t.Fatalf("ExpandTokens(): got %q want %q", got, "GPU=1 CUDA=12")
+	}
+	if len(conditions) != 2 || !conditions[0].Passed || conditions[1].Passed || strings.Join(conditions[0].Path, " ") != "DEFAULT" {
+		t.Fatalf("ExpandTokens() conditions: got %+v", conditions)
+	}
+
+	defs["DEFAULT"] = []string{`?(GPU > 1) -> X=1`, "GPU=many", `?(GPU > 1) -> Y=1`}
+	if _, err := ExpandTokens(defs, []string{"DEFAULT"}, Options{}); err == nil || !strings.Contains(err.Error(), "DEFAULT ->") {
+		t.Fatalf("ExpandTokens(ordering non-numbers): got %v want an error naming the key", err)
+	}
+}
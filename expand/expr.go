@@ -0,0 +1,333 @@
+package expand
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a parsed guard expression: a side-effect-free boolean expression
+// over variable values.
+//
+// Grammar, loosest binding first:
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" expr ")" | operand [ op operand ]
+//	op      = "==" | "!=" | "<" | "<=" | ">" | ">=" | "=~"
+//	operand = NAME | "string" | 'string' | number
+//
+// A NAME is the variable's value ("" when unset). A lone operand is true
+// unless it is "", "0", or "false". "<" and friends compare dotted numbers
+// (24.04, 1.22.3) component by component and are an error for anything else;
+// "=~" matches the left operand against a quoted regexp.
+//
+// Intent: Keep config logic auditable and shell-free while allowing more than
+// one exact-match test per conditional.
+// Source: DI-mutif (TODO-jirin)
+type Expr struct {
+	src  string
+	root *exprNode
+}
+
+// exprNode is one node of an Expr tree. Leaves are "var" and "lit"; "truth"
+// tests one operand; the rest combine Left and Right.
+type exprNode struct {
+	Op          string
+	Left, Right *exprNode
+	Text        string
+	re          *regexp.Regexp
+}
+
+// ParseExpr parses src as a guard expression.
+func ParseExpr(src string) (*Expr, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	p := &exprParser{toks: toks}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the expression as written.
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates e against values.
+func (e *Expr) Eval(values map[string]string) (bool, error) {
+	ok, err := e.root.eval(values)
+	if err != nil {
+		return false, fmt.Errorf("expression %q: %w", e.src, err)
+	}
+	return ok, nil
+}
+
+func (n *exprNode) value(values map[string]string) string {
+	if n.Op == "var" {
+		return values[n.Text]
+	}
+	return n.Text
+}
+
+func (n *exprNode) eval(values map[string]string) (bool, error) {
+	switch n.Op {
+	case "||", "&&":
+		left, err := n.Left.eval(values)
+		if err != nil || left == (n.Op == "||") {
+			return left, err
+		}
+		return n.Right.eval(values)
+	case "!":
+		ok, err := n.Left.eval(values)
+		return !ok, err
+	case "truth":
+		v := n.Left.value(values)
+		return v != "" && v != "0" && v != "false", nil
+	case "==":
+		return n.Left.value(values) == n.Right.value(values), nil
+	case "!=":
+		return n.Left.value(values) != n.Right.value(values), nil
+	case "=~":
+		return n.re.MatchString(n.Left.value(values)), nil
+	}
+	left, right := n.Left.value(values), n.Right.value(values)
+	cmp, ok := compareDotted(left, right)
+	if !ok {
+		return false, fmt.Errorf("%s needs numbers on both sides, got %q and %q", n.Op, left, right)
+	}
+	switch n.Op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+// compareDotted compares two dotted numbers such as 24.04 and 1.22.3, a
+// missing component counting as 0. ok is false unless both are dotted
+// numbers.
+func compareDotted(a, b string) (int, bool) {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		var err error
+		if i < len(as) {
+			if x, err = strconv.Atoi(as[i]); err != nil || x < 0 {
+				return 0, false
+			}
+		}
+		if i < len(bs) {
+			if y, err = strconv.Atoi(bs[i]); err != nil || y < 0 {
+				return 0, false
+			}
+		}
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// exprToken is one lexed token; kind is "name", "lit", or "op".
+type exprToken struct {
+	kind string
+	text string
+}
+
+// exprOps are the operator tokens, longest first so "<=" wins over "<".
+var exprOps = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")"}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && c == '"' && j+1 < len(src) {
+					j++
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			toks = append(toks, exprToken{kind: "lit", text: b.String()})
+			i = j + 1
+		case c == '_' || isLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || isLetter(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			toks = append(toks, exprToken{kind: "name", text: src[i:j]})
+			i = j
+		case isDigit(c):
+			j := i
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, exprToken{kind: "lit", text: src[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range exprOps {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			toks = append(toks, exprToken{kind: "op", text: op})
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// exprParser is a recursive-descent parser over lexed tokens.
+type exprParser struct {
+	toks []exprToken
+	pos  int
+}
+
+// accept consumes the next token when it is the operator op.
+func (p *exprParser) accept(op string) bool {
+	if p.pos < len(p.toks) && p.toks[p.pos].kind == "op" && p.toks[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (*exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right *exprNode
+		right, err = p.parseAnd()
+		left = &exprNode{Op: "||", Left: left, Right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right *exprNode
+		right, err = p.parseUnary()
+		left = &exprNode{Op: "&&", Left: left, Right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		return &exprNode{Op: "!", Left: operand}, err
+	}
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if !p.accept(op) {
+			continue
+		}
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		node := &exprNode{Op: op, Left: left, Right: right}
+		if op == "=~" {
+			if right.Op != "lit" {
+				return nil, fmt.Errorf("=~ needs a quoted pattern on its right")
+			}
+			if node.re, err = regexp.Compile(right.Text); err != nil {
+				return nil, err
+			}
+		}
+		return node, nil
+	}
+	return &exprNode{Op: "truth", Left: left}, nil
+}
+
+func (p *exprParser) parseOperand() (*exprNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	tok := p.toks[p.pos]
+	switch tok.kind {
+	case "name":
+		p.pos++
+		return &exprNode{Op: "var", Text: tok.text}, nil
+	case "lit":
+		p.pos++
+		return &exprNode{Op: "lit", Text: tok.text}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+// ScanGuard returns the length of the `?( ... )` group s starts with, through
+// the ")" that balances its "(" (parentheses inside quoted strings do not
+// count). ok is false when s does not start with "?(" or the group never
+// closes.
+func ScanGuard(s string) (n int, ok bool) {
+	if !strings.HasPrefix(s, "?(") {
+		return 0, false
+	}
+	depth := 0
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+	return 0, false
+}
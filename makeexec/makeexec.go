@@ -5,11 +5,66 @@
 package makeexec
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
+// InheritedVars are the variables a parent make exports to its recipes to
+// steer recursive makes. StripInherited removes them.
+var InheritedVars = []string{"MAKEFLAGS", "MAKELEVEL", "MFLAGS"}
+
+// ForwardedSignals are the signals relayed to make's process group while it
+// runs (see RunMakefilesCommandFiles).
+var ForwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// InterruptedError reports that decomk received one of ForwardedSignals while
+// make ran and relayed it to make's process group.
+type InterruptedError struct {
+	Signal os.Signal
+	// Err is make's own failure, or nil if make still exited 0.
+	Err error
+}
+
+func (e *InterruptedError) Error() string {
+	if e.Err == nil {
+		return "interrupted by " + e.Signal.String()
+	}
+	return "interrupted by " + e.Signal.String() + ": " + e.Err.Error()
+}
+
+func (e *InterruptedError) Unwrap() error { return e.Err }
+
+// StripInherited returns env without InheritedVars, so a make started from
+// inside another make's recipe (for example `decomk run` in a Makefile) does
+// not pick up the parent's flags, jobserver, or recursion level.
+//
+// Intent: Keep a parent make's -n, -k, and jobserver fds from silently
+// changing what decomk's own make run does.
+// Source: DI-robup (TODO-jirin)
+func StripInherited(env []string) []string {
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		inherited := false
+		for _, v := range InheritedVars {
+			if name == v {
+				inherited = true
+				break
+			}
+		}
+		if !inherited {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
 // Run executes "make" in dir using the given makefile, variable tuples, and
 // targets.
 //
@@ -36,13 +91,59 @@ func Run(dir, makefile string, tuples, targets []string, env []string, stdout, s
 // deterministic and testable.
 // Source: DI-kutod (TODO-jirin)
 func RunWithFlagsCommand(dir, makefile string, command []string, flags, tuples, targets []string, env []string, stdout, stderr io.Writer) (exitCode int, err error) {
+	var makefiles []string
+	if makefile != "" {
+		makefiles = []string{makefile}
+	}
+	return RunMakefilesCommand(dir, makefiles, command, flags, tuples, targets, env, stdout, stderr)
+}
+
+// RunMakefilesCommand is like RunWithFlagsCommand, but passes one "-f" flag per
+// entry in makefiles, in order.
+//
+// GNU make reads multiple -f files as if they were concatenated, so the first
+// file still supplies the default goal and later files may add targets.
+func RunMakefilesCommand(dir string, makefiles []string, command []string, flags, tuples, targets []string, env []string, stdout, stderr io.Writer) (exitCode int, err error) {
+	return RunMakefilesCommandFiles(dir, makefiles, command, flags, tuples, targets, env, nil, stdout, stderr)
+}
+
+// RunMakefilesCommandFiles is like RunMakefilesCommand, but also passes
+// extraFiles to make as file descriptors 3, 4, ... (see exec.Cmd.ExtraFiles).
+// make's recipe shells inherit them.
+//
+// make runs in its own process group. While it runs, ForwardedSignals sent to
+// decomk are relayed to the whole group instead of terminating decomk, and the
+// result is an *InterruptedError once make exits, with exit code 128+signal as a
+// shell would report.
+//
+// Intent: Stop Ctrl-C or docker stop from orphaning compilers and other recipe
+// children, and let decomk record the run and release its lock afterwards.
+// Source: DI-bobot (TODO-jirin)
+func RunMakefilesCommandFiles(dir string, makefiles []string, command []string, flags, tuples, targets []string, env []string, extraFiles []*os.File, stdout, stderr io.Writer) (exitCode int, err error) {
+	return runMake(dir, makefiles, command, flags, tuples, targets, env, extraFiles, false, stdout, stderr)
+}
+
+// RunMakefilesCommandFilesNoTTY is like RunMakefilesCommandFiles, but starts
+// make in a new session with no controlling terminal. A recipe that opens
+// /dev/tty to prompt then fails at once (ENXIO) instead of being stopped by
+// SIGTTIN, which would hang the run silently. stdin is /dev/null either way.
+func RunMakefilesCommandFilesNoTTY(dir string, makefiles []string, command []string, flags, tuples, targets []string, env []string, extraFiles []*os.File, stdout, stderr io.Writer) (exitCode int, err error) {
+	return runMake(dir, makefiles, command, flags, tuples, targets, env, extraFiles, true, stdout, stderr)
+}
+
+// runMake implements RunMakefilesCommandFiles and, when noTTY is set,
+// RunMakefilesCommandFilesNoTTY.
+func runMake(dir string, makefiles []string, command []string, flags, tuples, targets []string, env []string, extraFiles []*os.File, noTTY bool, stdout, stderr io.Writer) (exitCode int, err error) {
 	if len(command) == 0 {
 		return 1, fmt.Errorf("make command is empty")
 	}
 
 	args := []string{}
 	args = append(args, flags...)
-	if makefile != "" {
+	for _, makefile := range makefiles {
+		if makefile == "" {
+			continue
+		}
 		args = append(args, "-f", makefile)
 	}
 	args = append(args, tuples...)
@@ -54,16 +155,69 @@ func RunWithFlagsCommand(dir, makefile string, command []string, flags, tuples,
 	cmd := exec.Command(command[0], cmdArgs...)
 	cmd.Dir = dir
 	cmd.Env = env
+	cmd.ExtraFiles = extraFiles
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if noTTY {
+		// A new session is also a new process group, so signals are relayed
+		// the same way.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	}
 
-	if err := cmd.Run(); err != nil {
-		if ee, ok := err.(*exec.ExitError); ok {
-			return ee.ExitCode(), err
-		}
+	// Subscribe before starting make, so a signal arriving in between is
+	// relayed rather than killing decomk and orphaning the group.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, ForwardedSignals...)
+	defer signal.Stop(signals)
+	if err := cmd.Start(); err != nil {
 		return 1, err
 	}
-	return 0, nil
+	stop := make(chan struct{})
+	relayed := make(chan relayResult, 1)
+	go relaySignals(cmd.Process.Pid, signals, stop, relayed)
+	runErr := cmd.Wait()
+	close(stop)
+	relay := <-relayed
+
+	if relay.Signal != nil {
+		return 128 + int(relay.Signal.(syscall.Signal)), errors.Join(&InterruptedError{Signal: relay.Signal, Err: runErr}, relay.Err)
+	}
+	if runErr != nil {
+		if ee, ok := runErr.(*exec.ExitError); ok {
+			return ee.ExitCode(), errors.Join(runErr, relay.Err)
+		}
+		return 1, errors.Join(runErr, relay.Err)
+	}
+	return 0, relay.Err
+}
+
+// relayResult is the first signal relaySignals forwarded, if any, and any
+// failure to forward one.
+type relayResult struct {
+	Signal os.Signal
+	Err    error
+}
+
+// relaySignals forwards each signal from signals to process group pgid until
+// stop is closed, then reports on result.
+func relaySignals(pgid int, signals <-chan os.Signal, stop <-chan struct{}, result chan<- relayResult) {
+	var r relayResult
+	for {
+		select {
+		case sig := <-signals:
+			if r.Signal == nil {
+				r.Signal = sig
+			}
+			// ESRCH means the group already exited; Wait will return.
+			if err := syscall.Kill(-pgid, sig.(syscall.Signal)); err != nil && !errors.Is(err, syscall.ESRCH) && r.Err == nil {
+				r.Err = fmt.Errorf("forward %s to make: %w", sig, err)
+			}
+		case <-stop:
+			result <- r
+			return
+		}
+	}
 }
 
 // RunWithFlags executes "make" like Run, but prepends additional make flags
@@ -0,0 +1,112 @@
+package makeexec
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRunMakefilesCommandFiles_ForwardsSignal checks that a SIGTERM sent to
+// decomk is relayed to make's whole process group, so a recipe's background
+// child does not outlive the run.
+func TestRunMakefilesCommandFiles_ForwardsSignal(t *testing.T) {
+	// Not parallel: the test signals its own process.
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	makefile := filepath.Join(dir, "Makefile")
+	recipe := "all:\n\tsleep 60 & echo $$! > child.tmp && mv child.tmp child.pid && wait\n"
+	if err := os.WriteFile(makefile, []byte(recipe), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(pidFile); err == nil {
+				if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+					t.Errorf("kill self: %v", err)
+				}
+				return
+			}
+		}
+		t.Errorf("recipe never wrote %s", pidFile)
+	}()
+
+	exitCode, err := RunMakefilesCommandFiles(dir, []string{makefile}, []string{"make"}, nil, nil, nil, os.Environ(), nil, io.Discard, io.Discard)
+	var interrupted *InterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("RunMakefilesCommandFiles(): got err %v want *InterruptedError", err)
+	}
+	if interrupted.Signal != syscall.SIGTERM {
+		t.Fatalf("InterruptedError.Signal: got %v want %v", interrupted.Signal, syscall.SIGTERM)
+	}
+	if exitCode != 128+int(syscall.SIGTERM) {
+		t.Fatalf("RunMakefilesCommandFiles(): got exit %d want %d", exitCode, 128+int(syscall.SIGTERM))
+	}
+
+	raw, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The orphaned sleep is reparented and reaped asynchronously; allow it a
+	// moment to disappear (or linger only as a zombie).
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if !processAlive(pid) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("recipe child %d still running after make was interrupted", pid)
+		}
+	}
+}
+
+// processAlive reports whether pid exists and is not a zombie.
+func processAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return !errors.Is(err, os.ErrNotExist)
+	}
+	// The state field follows the parenthesized command name.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// TestRunMakefilesCommandFilesNoTTY_FailsTTYRead checks that a recipe that
+// opens /dev/tty fails at once instead of waiting for a terminal.
+func TestRunMakefilesCommandFilesNoTTY_FailsTTYRead(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+
+	dir := t.TempDir()
+	makefile := filepath.Join(dir, "Makefile")
+	if err := os.WriteFile(makefile, []byte("all:\n\t@read answer < /dev/tty\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr strings.Builder
+	code, err := RunMakefilesCommandFilesNoTTY(dir, []string{makefile}, []string{"make"}, nil, nil, nil, os.Environ(), nil, io.Discard, &stderr)
+	if code == 0 || err == nil {
+		t.Fatalf("RunMakefilesCommandFilesNoTTY(): got %d, %v want a failure", code, err)
+	}
+	if got := stderr.String(); !strings.Contains(got, "/dev/tty") {
+		t.Fatalf("stderr: got %q want it to mention /dev/tty", got)
+	}
+}
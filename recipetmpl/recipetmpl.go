@@ -0,0 +1,276 @@
+// Package recipetmpl turns template tokens from decomk.conf into make targets
+// rendered from the config repo's recipe template library.
+//
+// A template token has the form:
+//
+//	tmpl:<name>(<arg>,<arg>...)
+//
+// for example `tmpl:github-release(cli/cli,gh)`. The template is the file
+// templates/<name>.mk next to decomk.conf, a Go text/template that sees:
+//   - .Target: the generated target name the snippet must define
+//   - .Args: the call's arguments, in order
+//   - one field per name listed on an optional `# params: NAME...` line, bound
+//     to the argument in the same position (the call must then pass exactly
+//     that many arguments)
+//
+// Like toolchain tokens, template tokens are extracted from the expanded token
+// stream before tuple partitioning. decomk renders every call into one
+// generated make fragment per run and exports TargetsTuple listing the
+// generated targets.
+//
+// Intent: Let config repos share one recipe shape (for example installing a
+// GitHub release binary) across many tools without copy-pasting make targets.
+// Source: DI-lubug (TODO-jirin)
+package recipetmpl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	// Prefix starts every template token.
+	Prefix = "tmpl:"
+
+	// Dir is the template library directory, relative to the directory that
+	// holds decomk.conf.
+	Dir = "templates"
+
+	// Ext is the file extension of a template in Dir.
+	Ext = ".mk"
+
+	// TargetsTuple lists the generated targets so operators can select them as
+	// one action arg (`decomk run DECOMK_TEMPLATE_TARGETS`).
+	TargetsTuple = "DECOMK_TEMPLATE_TARGETS"
+
+	// paramsPrefix starts the optional template line naming its parameters.
+	paramsPrefix = "# params:"
+)
+
+// Call is one parsed template token.
+type Call struct {
+	Name string
+	Args []string
+}
+
+// IsToken reports whether token uses the template prefix.
+//
+// It does not validate the rest of the token; use Parse for that.
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Parse parses a `tmpl:<name>(<arg>,...)` token.
+func Parse(token string) (Call, error) {
+	rest, ok := strings.CutPrefix(token, Prefix)
+	if !ok {
+		return Call{}, fmt.Errorf("template token %q must start with %s", token, Prefix)
+	}
+	name, argText, ok := strings.Cut(rest, "(")
+	if !ok || !strings.HasSuffix(argText, ")") {
+		return Call{}, fmt.Errorf("template token %q must be %s<name>(<arg>,...)", token, Prefix)
+	}
+	if !isSafeName(name) {
+		return Call{}, fmt.Errorf("template token %q has invalid name %q (allowed: letters, numbers, '.', '_', '-')", token, name)
+	}
+	call := Call{Name: name}
+	argText = strings.TrimSuffix(argText, ")")
+	if argText == "" {
+		return call, nil
+	}
+	for _, arg := range strings.Split(argText, ",") {
+		if !isSafeArg(arg) {
+			return Call{}, fmt.Errorf("template token %q has invalid argument %q (allowed: letters, numbers, '.', '_', '-', '/', '@', '+', ':')", token, arg)
+		}
+		call.Args = append(call.Args, arg)
+	}
+	return call, nil
+}
+
+// String returns the token form of c.
+func (c Call) String() string {
+	return Prefix + c.Name + "(" + strings.Join(c.Args, ",") + ")"
+}
+
+// Target returns the generated make target name for c: "tmpl-<name>" followed
+// by one "-<arg>" per argument, with characters outside [A-Za-z0-9._-]
+// replaced by '-'.
+func (c Call) Target() string {
+	var b strings.Builder
+	b.WriteString("tmpl-")
+	b.WriteString(c.Name)
+	for _, arg := range c.Args {
+		b.WriteString("-")
+		for _, r := range arg {
+			if isSafeRune(r) {
+				b.WriteRune(r)
+				continue
+			}
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// Extract splits template tokens out of an expanded token list.
+//
+// It returns the parsed calls (deduplicated, first occurrence wins) and the
+// remaining tokens in their original order. Two different calls that map to
+// the same target name are an error.
+func Extract(tokens []string) (calls []Call, rest []string, err error) {
+	seen := make(map[string]Call)
+	for _, token := range tokens {
+		if !IsToken(token) {
+			rest = append(rest, token)
+			continue
+		}
+		call, err := Parse(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		target := call.Target()
+		if prior, ok := seen[target]; ok {
+			if prior.String() != call.String() {
+				return nil, nil, fmt.Errorf("template tokens %s and %s both generate target %s", prior, call, target)
+			}
+			continue
+		}
+		seen[target] = call
+		calls = append(calls, call)
+	}
+	return calls, rest, nil
+}
+
+// Tuples returns the tuple listing the generated targets, or nil without
+// calls.
+func Tuples(calls []Call) []string {
+	if len(calls) == 0 {
+		return nil
+	}
+	targets := make([]string, 0, len(calls))
+	for _, call := range calls {
+		targets = append(targets, call.Target())
+	}
+	return []string{TargetsTuple + "=" + strings.Join(targets, " ")}
+}
+
+// RenderMakefile renders every call with the templates in dir into one make
+// fragment.
+//
+// A missing template, an argument count that does not match its params line,
+// or a rendered snippet that does not define its target is an error.
+func RenderMakefile(dir string, calls []Call) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("# generated by decomk from decomk.conf tmpl: tokens; do not edit\n")
+	parsed := make(map[string]*template.Template)
+	params := make(map[string][]string)
+	for _, call := range calls {
+		tpl, ok := parsed[call.Name]
+		if !ok {
+			path := filepath.Join(dir, call.Name+Ext)
+			source, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("%s: read template: %w", call, err)
+			}
+			tpl, err = template.New(call.Name + Ext).Option("missingkey=error").Parse(string(source))
+			if err != nil {
+				return nil, fmt.Errorf("%s: parse template %s: %w", call, path, err)
+			}
+			parsed[call.Name] = tpl
+			params[call.Name] = templateParams(string(source))
+		}
+
+		data := map[string]any{"Target": call.Target(), "Args": call.Args}
+		if names := params[call.Name]; names != nil {
+			if len(names) != len(call.Args) {
+				return nil, fmt.Errorf("%s: template %s takes %d arguments (%s), got %d", call, call.Name, len(names), strings.Join(names, ", "), len(call.Args))
+			}
+			for i, name := range names {
+				data[name] = call.Args[i]
+			}
+		}
+		var rendered bytes.Buffer
+		if err := tpl.Execute(&rendered, data); err != nil {
+			return nil, fmt.Errorf("%s: render template: %w", call, err)
+		}
+		if !definesTarget(rendered.String(), call.Target()) {
+			return nil, fmt.Errorf("%s: template %s does not define target {{.Target}}", call, call.Name)
+		}
+		b.WriteString("\n")
+		b.Write(rendered.Bytes())
+		if !bytes.HasSuffix(rendered.Bytes(), []byte("\n")) {
+			b.WriteString("\n")
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// templateParams returns the names on the first `# params:` line of source,
+// or nil when it has none.
+func templateParams(source string) []string {
+	for _, line := range strings.Split(source, "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), paramsPrefix); ok {
+			return append([]string{}, strings.Fields(rest)...)
+		}
+	}
+	return nil
+}
+
+// definesTarget reports whether some line of snippet starts a rule for
+// target.
+func definesTarget(snippet, target string) bool {
+	for _, line := range strings.Split(snippet, "\n") {
+		names, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		for _, name := range strings.Fields(names) {
+			if name == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isSafeName reports whether s is a non-empty shell/make-safe identifier.
+func isSafeName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !isSafeRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSafeRune reports whether r may appear in a template name or target.
+func isSafeRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case r == '.' || r == '_' || r == '-':
+		return true
+	}
+	return false
+}
+
+// isSafeArg reports whether s is a non-empty argument that needs no shell or
+// make quoting.
+func isSafeArg(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !isSafeRune(r) && !strings.ContainsRune("/@+:", r) {
+			return false
+		}
+	}
+	return true
+}
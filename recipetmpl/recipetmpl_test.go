@@ -0,0 +1,108 @@
+package recipetmpl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		token   string
+		want    Call
+		wantErr string
+	}{
+		{token: "tmpl:github-release(cli/cli,gh)", want: Call{Name: "github-release", Args: []string{"cli/cli", "gh"}}},
+		{token: "tmpl:apt-update()", want: Call{Name: "apt-update"}},
+		{token: "tmpl:github-release", wantErr: "must be tmpl:<name>(<arg>,...)"},
+		{token: "tmpl:bad name(x)", wantErr: "invalid name"},
+		{token: "tmpl:x(a,,b)", wantErr: `invalid argument ""`},
+		{token: "tmpl:x(a;rm)", wantErr: "invalid argument"},
+		{token: "mise:go@1.22", wantErr: "must start with tmpl:"},
+	}
+	for _, tc := range cases {
+		got, err := Parse(tc.token)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("Parse(%q) error: got %v want substring %q", tc.token, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Parse(%q) error: %v", tc.token, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("Parse(%q): got %#v want %#v", tc.token, got, tc.want)
+		}
+		if got.String() != tc.token {
+			t.Fatalf("Parse(%q).String(): got %q", tc.token, got.String())
+		}
+	}
+}
+
+func TestExtractAndTuples(t *testing.T) {
+	t.Parallel()
+
+	calls, rest, err := Extract([]string{"FOO=bar", "tmpl:gh-bin(cli/cli,gh)", "mise:go@1.22", "tmpl:gh-bin(cli/cli,gh)", "tmpl:apt(jq)"})
+	if err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	if got, want := rest, []string{"FOO=bar", "mise:go@1.22"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rest: got %#v want %#v", got, want)
+	}
+	if got, want := Tuples(calls), []string{"DECOMK_TEMPLATE_TARGETS=tmpl-gh-bin-cli-cli-gh tmpl-apt-jq"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tuples(): got %#v want %#v", got, want)
+	}
+
+	_, _, err = Extract([]string{"tmpl:gh-bin(cli/cli)", "tmpl:gh-bin(cli-cli)"})
+	if err == nil || !strings.Contains(err.Error(), "both generate target tmpl-gh-bin-cli-cli") {
+		t.Fatalf("Extract(collision) error: got %v", err)
+	}
+}
+
+func TestRenderMakefile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	release := "# params: REPO BIN\n{{.Target}}:\n\tgh release download -R {{.REPO}} -p '{{.BIN}}_linux_amd64' -O /usr/local/bin/{{.BIN}}\n\ttouch $@\n"
+	files := map[string]string{
+		"github-release.mk": release,
+		"apt.mk":            "{{.Target}}:\n\tapt-get install -y{{range .Args}} {{.}}{{end}}\n\ttouch $@",
+		"broken.mk":         "other:\n\ttouch $@\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+	}
+
+	calls := []Call{
+		{Name: "github-release", Args: []string{"cli/cli", "gh"}},
+		{Name: "apt", Args: []string{"jq", "curl"}},
+	}
+	got, err := RenderMakefile(dir, calls)
+	if err != nil {
+		t.Fatalf("RenderMakefile() error: %v", err)
+	}
+	want := "# generated by decomk from decomk.conf tmpl: tokens; do not edit\n" +
+		"\n# params: REPO BIN\ntmpl-github-release-cli-cli-gh:\n\tgh release download -R cli/cli -p 'gh_linux_amd64' -O /usr/local/bin/gh\n\ttouch $@\n" +
+		"\ntmpl-apt-jq-curl:\n\tapt-get install -y jq curl\n\ttouch $@\n"
+	if string(got) != want {
+		t.Fatalf("RenderMakefile(): got %q want %q", got, want)
+	}
+
+	errCases := map[string]Call{
+		"takes 2 arguments (REPO, BIN), got 1": {Name: "github-release", Args: []string{"cli/cli"}},
+		"read template":                        {Name: "missing"},
+		"does not define target":               {Name: "broken"},
+	}
+	for wantErr, call := range errCases {
+		if _, err := RenderMakefile(dir, []Call{call}); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("RenderMakefile(%s) error: got %v want substring %q", call, err, wantErr)
+		}
+	}
+}
@@ -2,7 +2,11 @@
 // into the argv pieces that decomk passes to make.
 package resolve
 
-import "unicode"
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
 
 // Partition splits tokens into make variable tuples (NAME=value) and make
 // targets (everything else).
@@ -10,10 +14,36 @@ import "unicode"
 // Variable tuples must come before targets on make's argv. This matters because
 // make treats NAME=value entries as variable assignments, and they affect
 // evaluation of subsequent targets.
+//
+// When a NAME is assigned more than once, only the last assignment is kept, at
+// its own position. This is the value make would have used anyway, but it makes
+// overrides (for example a key's own tuples over ones it inherits) explicit in
+// plan output and env exports. A trailing `NAME=$` passthrough does not
+// override: the last concrete assignment before it is kept as its fallback.
+//
+// Intent: Resolve tuple overrides deterministically instead of leaving
+// repeated assignments for make's argv ordering to settle.
+// Source: DI-vosib (TODO-jirin)
 func Partition(tokens []string) (tuples, targets []string) {
-	for _, tok := range tokens {
-		if _, _, ok := SplitTuple(tok); ok {
-			tuples = append(tuples, tok)
+	lastConcrete := make(map[string]int)
+	lastPassThrough := make(map[string]int)
+	for i, tok := range tokens {
+		if name, value, ok := SplitTuple(tok); ok {
+			if value == PassThroughValue {
+				lastPassThrough[name] = i
+			} else {
+				lastConcrete[name] = i
+				delete(lastPassThrough, name)
+			}
+		}
+	}
+	for i, tok := range tokens {
+		if name, _, ok := SplitTuple(tok); ok {
+			if concrete, ok := lastConcrete[name]; ok && concrete == i {
+				tuples = append(tuples, tok)
+			} else if passThrough, ok := lastPassThrough[name]; ok && passThrough == i {
+				tuples = append(tuples, tok)
+			}
 			continue
 		}
 		targets = append(targets, tok)
@@ -21,6 +51,83 @@ func Partition(tokens []string) (tuples, targets []string) {
 	return tuples, targets
 }
 
+// Tuple classes restrict where a tuple is delivered. Unclassed tuples go both
+// on make's argv and into env.sh and the environment of make and its recipes.
+//
+// Intent: Let configs separate make-internal knobs from values meant for
+// scripts, instead of one tuple class that causes collision and precedence
+// surprises.
+// Source: DI-batub (TODO-jirin)
+const (
+	// ClassMakeOnly tuples are passed on make's argv only.
+	ClassMakeOnly = "makeonly"
+	// ClassEnvOnly tuples are exported to env.sh and process environments only.
+	ClassEnvOnly = "envonly"
+	// ClassEnvRef tuples are delivered like ClassEnvOnly, but $NAME and
+	// ${NAME} references in their values are kept as references in env.sh and
+	// expanded against the environment for processes decomk starts.
+	ClassEnvRef = "envref"
+)
+
+// tupleClasses maps the keywords that may prefix a tuple token, separated from
+// it by one space (for example "noexport INSTALL=jq"), to their class.
+var tupleClasses = map[string]string{
+	"makeonly": ClassMakeOnly,
+	"noexport": ClassMakeOnly,
+	"envonly":  ClassEnvOnly,
+	"envref":   ClassEnvRef,
+}
+
+// SplitClass splits an optional class keyword off a tuple token. It returns
+// class="" and the token unchanged when the token has no class prefix.
+func SplitClass(token string) (class, tuple string) {
+	if sp := strings.IndexByte(token, ' '); sp > 0 {
+		if c, ok := tupleClasses[token[:sp]]; ok {
+			return c, token[sp+1:]
+		}
+	}
+	return "", token
+}
+
+// IsClass reports whether word is a tuple class keyword.
+func IsClass(word string) bool {
+	_, ok := tupleClasses[word]
+	return ok
+}
+
+// StripClasses removes class prefixes from tokens and returns the class of
+// each NAME's last assignment. Names whose last assignment has no class are
+// not in the map.
+//
+// Intent: Carry per-tuple export classes through expansion without teaching
+// every tuple consumer about class prefixes.
+// Source: DI-purub (TODO-jirin)
+func StripClasses(tokens []string) ([]string, map[string]string) {
+	out := make([]string, 0, len(tokens))
+	classes := make(map[string]string)
+	for _, tok := range tokens {
+		class, tuple := SplitClass(tok)
+		out = append(out, tuple)
+		name, value, ok := SplitTuple(tuple)
+		if !ok {
+			continue
+		}
+		switch {
+		case class != "":
+			classes[name] = class
+		case value != PassThroughValue:
+			// A plain NAME=$ passthrough keeps the class of the assignment it
+			// falls back to; a plain concrete assignment resets it.
+			delete(classes, name)
+		}
+	}
+	return out, classes
+}
+
+// PassThroughValue is the reserved tuple value that requests environment
+// pass-through resolution (for example `BAX=$`).
+const PassThroughValue = "$"
+
 // SplitTuple splits a token of the form NAME=value.
 //
 // Only a small subset of make's variable assignment syntax is supported here:
@@ -48,6 +155,11 @@ func SplitTuple(token string) (name, value string, ok bool) {
 	return name, value, true
 }
 
+// IsIdent reports whether s is a valid tuple name: [A-Za-z_][A-Za-z0-9_]*.
+func IsIdent(s string) bool {
+	return isIdent(s)
+}
+
 // isIdent reports whether s is a conservative "identifier-like" name suitable
 // for NAME=value tuples.
 func isIdent(s string) bool {
@@ -69,3 +181,58 @@ func isIdent(s string) bool {
 	}
 	return true
 }
+
+// ExecPrefix opens an explicit command substitution inside a tuple value,
+// closed by the matching ")": for example GOVER=$(exec:go env GOVERSION).
+const ExecPrefix = "$(exec:"
+
+// ScanExec returns the length of the $(exec:...) group at the start of s,
+// through its matching ")". Parentheses inside the command must balance. It
+// returns ok=false when s does not start with ExecPrefix or the group is
+// unterminated.
+func ScanExec(s string) (n int, ok bool) {
+	if !strings.HasPrefix(s, ExecPrefix) {
+		return 0, false
+	}
+	depth := 1
+	for i := len(ExecPrefix); i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// SubstituteExec replaces each $(exec:CMD) group in value with run(CMD).
+//
+// It reports whether value contained any group, and returns the first error
+// from run or an unterminated group.
+func SubstituteExec(value string, run func(command string) (string, error)) (string, bool, error) {
+	var b strings.Builder
+	found := false
+	for {
+		i := strings.Index(value, ExecPrefix)
+		if i < 0 {
+			b.WriteString(value)
+			return b.String(), found, nil
+		}
+		found = true
+		n, ok := ScanExec(value[i:])
+		if !ok {
+			return "", true, fmt.Errorf("unterminated %s group", ExecPrefix)
+		}
+		out, err := run(value[i+len(ExecPrefix) : i+n-1])
+		if err != nil {
+			return "", true, err
+		}
+		b.WriteString(value[:i])
+		b.WriteString(out)
+		value = value[i+n:]
+	}
+}
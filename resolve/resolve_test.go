@@ -54,3 +54,72 @@ func TestPartition(t *testing.T) {
 		t.Fatalf("targets: got %#v want %#v", targets, want)
 	}
 }
+
+func TestPartition_LastTupleAssignmentWins(t *testing.T) {
+	t.Parallel()
+
+	tuples, targets := Partition([]string{"FOO=base", "BAR=1", "Block10", "FOO=override", "BAR=1", "BAX=fallback", "BAX=$", "QUX=$", "QUX=set"})
+	if want := []string{"FOO=override", "BAR=1", "BAX=fallback", "BAX=$", "QUX=set"}; !reflect.DeepEqual(tuples, want) {
+		t.Fatalf("tuples: got %#v want %#v", tuples, want)
+	}
+	if want := []string{"Block10"}; !reflect.DeepEqual(targets, want) {
+		t.Fatalf("targets: got %#v want %#v", targets, want)
+	}
+}
+
+func TestStripClasses(t *testing.T) {
+	t.Parallel()
+
+	tokens := []string{"noexport INSTALL=a", "FOO=1", "noexport FOO=2", "BAR=1", "noexport BAR=2", "BAR=3", "INSTALL=$", "Block10"}
+	out, classes := StripClasses(tokens)
+	if want := []string{"INSTALL=a", "FOO=1", "FOO=2", "BAR=1", "BAR=2", "BAR=3", "INSTALL=$", "Block10"}; !reflect.DeepEqual(out, want) {
+		t.Fatalf("StripClasses() tokens: got %#v want %#v", out, want)
+	}
+	if want := map[string]string{"INSTALL": ClassMakeOnly, "FOO": ClassMakeOnly}; !reflect.DeepEqual(classes, want) {
+		t.Fatalf("StripClasses() classes: got %#v want %#v", classes, want)
+	}
+}
+
+func TestSplitClass(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in, wantClass, wantTuple string
+	}{
+		{in: "noexport A=1", wantClass: ClassMakeOnly, wantTuple: "A=1"},
+		{in: "makeonly A=1", wantClass: ClassMakeOnly, wantTuple: "A=1"},
+		{in: "envonly A=1 2", wantClass: ClassEnvOnly, wantTuple: "A=1 2"},
+		{in: "envref PATH=$HOME/bin:$PATH", wantClass: ClassEnvRef, wantTuple: "PATH=$HOME/bin:$PATH"},
+		{in: "A=envonly x", wantClass: "", wantTuple: "A=envonly x"},
+		{in: "envonly", wantClass: "", wantTuple: "envonly"},
+	}
+	for _, tc := range cases {
+		class, tuple := SplitClass(tc.in)
+		if class != tc.wantClass || tuple != tc.wantTuple {
+			t.Fatalf("SplitClass(%q): got (%q,%q) want (%q,%q)", tc.in, class, tuple, tc.wantClass, tc.wantTuple)
+		}
+	}
+}
+
+func TestSubstituteExec(t *testing.T) {
+	t.Parallel()
+
+	var ran []string
+	run := func(command string) (string, error) {
+		ran = append(ran, command)
+		return "<" + command + ">", nil
+	}
+	got, found, err := SubstituteExec("a $(exec:go env GOVERSION) b $(exec:f (x))", run)
+	if err != nil || !found || got != "a <go env GOVERSION> b <f (x)>" {
+		t.Fatalf("SubstituteExec(): got (%q, %v, %v)", got, found, err)
+	}
+	if want := []string{"go env GOVERSION", "f (x)"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("SubstituteExec() commands: got %q want %q", ran, want)
+	}
+	if got, found, err := SubstituteExec("$(shell x) $$", run); err != nil || found || got != "$(shell x) $$" {
+		t.Fatalf("SubstituteExec(no group): got (%q, %v, %v)", got, found, err)
+	}
+	if _, _, err := SubstituteExec("$(exec:f (x)", run); err == nil {
+		t.Fatalf("SubstituteExec(unterminated): expected error")
+	}
+}
@@ -10,18 +10,29 @@
 //   - /var/decomk/conf    : local clone of the shared config repo (decomk.conf + Makefile)
 //   - /var/decomk/stamps  : global stamp directory used as make's working directory
 //   - /var/decomk/env.sh  : shell-friendly resolved tuple exports for other processes to source
+//   - /var/decomk/last-run.json : outcome of the most recent run, checked by `decomk healthz`
+//   - /var/decomk/bootstrapped : marker written after the first successful run
+//   - /var/decomk/timings.jsonl : recent runs' wall-clock time per target
+//   - /var/decomk/events.sock : progress event socket while a run is in progress
+//   - /var/decomk/generated : make fragments generated from config (for example toolchains.mk)
+//   - /var/decomk/toolchains : version-manager data dirs (mise/asdf installs and shims)
+//   - /var/decomk/cache   : download caches (for example pinned remote makefiles, config tarballs, and git bundles)
+//   - /var/decomk/profiles : saved plan snapshots replayed with -profile
 //   - /var/log/decomk     : per-run logs (make output)
 package state
 
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -52,6 +63,30 @@ func ToolDir(home string) string { return filepath.Join(home, "decomk") }
 // still outside any WIP workspace repo.
 func ToolBinPath(home string) string { return filepath.Join(ToolDir(home), "bin", "decomk") }
 
+// ToolArchiveDir returns the directory where stage-0 keeps previously promoted
+// tool binaries (one decomk-<stamp> binary plus decomk-<stamp>.info each) for
+// `decomk self-update rollback`.
+func ToolArchiveDir(home string) string { return filepath.Join(ToolDir(home), "bin", "archive") }
+
+// ToolStagingDir returns the directory where a freshly built tool binary waits
+// for --selfcheck before it replaces the installed one. Stage-0 and
+// `decomk self-update` share it.
+func ToolStagingDir(home string) string { return filepath.Join(home, "stage0", "tool-staging") }
+
+// ToolAllowedSignersPath returns the ssh-keygen allowed_signers file that
+// `decomk self-update -require-signed-tool` checks tool commits against.
+func ToolAllowedSignersPath(home string) string {
+	return filepath.Join(ToolDir(home), "allowed_signers")
+}
+
+// ToolBuildCacheDir returns the directory where `decomk self-update` caches
+// tool binaries per source commit and platform, so an unchanged source is not
+// rebuilt.
+func ToolBuildCacheDir(home string) string { return filepath.Join(ToolDir(home), "bin", "cache") }
+
+// ToolSrcDir returns the clone used to build the tool from a git: tool URI.
+func ToolSrcDir(home string) string { return filepath.Join(home, "src", "decomk") }
+
 // ToolLockPath returns the lock file used to serialize tool repo updates.
 //
 // The lock path is outside ToolDir so we don't create update artifacts inside a
@@ -77,6 +112,11 @@ func ConfDir(home string) string { return filepath.Join(home, "conf") }
 // Instead we keep the lock as a sibling of ConfDir under the decomk home root.
 func ConfLockPath(home string) string { return filepath.Join(home, "conf.lock") }
 
+// ConfSrcPath returns the file recording the sha256 of the config tarball
+// unpacked into ConfDir (see decomk -conf-src). Like ConfLockPath it lives
+// beside ConfDir so replacing ConfDir replaces nothing else.
+func ConfSrcPath(home string) string { return filepath.Join(home, "conf.src") }
+
 // StampsDir returns the global stamp directory where decomk runs make.
 func StampsDir(home string) string { return filepath.Join(home, "stamps") }
 
@@ -86,6 +126,33 @@ func StampsDir(home string) string { return filepath.Join(home, "stamps") }
 // Stamps are global (container-wide), so the lock is also global.
 func StampsLockPath(home string) string { return filepath.Join(StampsDir(home), ".lock") }
 
+// StampsLockQueueDir returns the directory where invocations waiting for the
+// stamps lock register themselves, one file per process, so a waiter can tell
+// how many runs are queued ahead of it. It is hidden, so TouchExistingStamps
+// skips it.
+func StampsLockQueueDir(home string) string {
+	return filepath.Join(StampsDir(home), ".lock.queue")
+}
+
+// RunLockPath returns the lock every run holds shared while make runs, and
+// that gc (or a run that cannot tell which targets it touches) holds
+// exclusively.
+func RunLockPath(home string) string { return filepath.Join(StampsDir(home), ".lock.run") }
+
+// TargetLockDir returns the directory of per-target lock files.
+func TargetLockDir(home string) string { return filepath.Join(StampsDir(home), ".locks") }
+
+// TargetLockPath returns the lock file a run holds while make may build
+// target.
+func TargetLockPath(home, target string) string {
+	return filepath.Join(TargetLockDir(home), SafeComponent(target))
+}
+
+// InProgressPath returns the marker listing targets a signal interrupted
+// mid-recipe, which may be half-applied. It is hidden, so
+// TouchExistingStamps skips it.
+func InProgressPath(home string) string { return filepath.Join(StampsDir(home), ".inprogress") }
+
 // LogDir returns the per-run log directory under decomk's state root.
 //
 // This is the legacy/home-rooted location (<DECOMK_HOME>/log). Callers that want
@@ -93,6 +160,127 @@ func StampsLockPath(home string) string { return filepath.Join(StampsDir(home),
 // LogDir(home) when DefaultLogDir is not writable.
 func LogDir(home string) string { return filepath.Join(home, "log") }
 
+// RunTmpRoot returns the directory holding per-run scratch directories
+// (DECOMK_RUN_TMP).
+func RunTmpRoot(home string) string { return filepath.Join(home, "tmp") }
+
+// LastRunPath returns the record of the most recent `decomk run` (outcome,
+// time, and make arguments) that `decomk healthz` checks.
+func LastRunPath(home string) string { return filepath.Join(home, "last-run.json") }
+
+// EventsSocketPath returns the unix socket on which a run in progress streams
+// NDJSON progress events.
+func EventsSocketPath(home string) string { return filepath.Join(home, "events.sock") }
+
+// TimingsPath returns the per-run timing history (one JSON record per line)
+// reported by `decomk profile timing`.
+func TimingsPath(home string) string { return filepath.Join(home, "timings.jsonl") }
+
+// StatusPath returns the KEY=value summary of the last run and pending
+// targets that shell prompts read.
+func StatusPath(home string) string { return filepath.Join(home, "status") }
+
+// BootstrapMarkerPath returns the marker written after the container's first
+// successful run; its absence means the container has never bootstrapped.
+func BootstrapMarkerPath(home string) string { return filepath.Join(home, "bootstrapped") }
+
+// GeneratedDir returns the directory for decomk-generated make fragments.
+//
+// Files here are rewritten on every plan/run from the resolved config; they are
+// not meant to be edited by hand.
+func GeneratedDir(home string) string { return filepath.Join(home, "generated") }
+
+// ToolchainsMakefile returns the generated make fragment holding version-manager
+// install targets.
+func ToolchainsMakefile(home string) string {
+	return filepath.Join(GeneratedDir(home), "toolchains.mk")
+}
+
+// RecipesMakefile returns the generated make fragment holding inline
+// decomk.conf recipes.
+func RecipesMakefile(home string) string {
+	return filepath.Join(GeneratedDir(home), "recipes.mk")
+}
+
+// TemplatesMakefile returns the generated make fragment holding targets
+// rendered from the config repo's recipe templates.
+func TemplatesMakefile(home string) string {
+	return filepath.Join(GeneratedDir(home), "templates.mk")
+}
+
+// NamespacesMakefile returns the generated make fragment that exposes
+// namespaced makefiles' targets as NAMESPACE/TARGET.
+func NamespacesMakefile(home string) string {
+	return filepath.Join(GeneratedDir(home), "namespaces.mk")
+}
+
+// TargetDirsMakefile returns the generated make fragment that runs selected
+// targets' recipes in their own directories.
+func TargetDirsMakefile(home string) string {
+	return filepath.Join(GeneratedDir(home), "targetdirs.mk")
+}
+
+// ShellTraceMakefile returns the generated make fragment that turns on shell
+// xtrace for `decomk run -trace-shell`.
+func ShellTraceMakefile(home string) string {
+	return filepath.Join(GeneratedDir(home), "shelltrace.mk")
+}
+
+// UnexportMakefile returns the generated make fragment that keeps makeonly
+// tuples out of recipe environments.
+func UnexportMakefile(home string) string {
+	return filepath.Join(GeneratedDir(home), "unexport.mk")
+}
+
+// ToolchainDataDir returns the data directory decomk assigns to one version
+// manager (for example <DECOMK_HOME>/toolchains/mise).
+func ToolchainDataDir(home, manager string) string {
+	return filepath.Join(home, "toolchains", SafeComponent(manager))
+}
+
+// ProfilesDir returns the directory holding saved plan profiles.
+func ProfilesDir(home string) string { return filepath.Join(home, "profiles") }
+
+// ProfilePath returns the JSON file for one saved plan profile.
+func ProfilePath(home, name string) string {
+	return filepath.Join(ProfilesDir(home), SafeComponent(name)+".json")
+}
+
+// PluginsDir returns the directory searched for decomk-<name> exec plugins
+// before PATH.
+func PluginsDir(home string) string { return filepath.Join(home, "plugins") }
+
+// VarPluginsDir returns the directory of executables whose KEY=VALUE output
+// adds computed variables at resolve time.
+func VarPluginsDir(home string) string { return filepath.Join(PluginsDir(home), "vars.d") }
+
+// CacheDir returns the root for decomk's download caches.
+func CacheDir(home string) string { return filepath.Join(home, "cache") }
+
+// MakefileCacheDir returns the content-addressed cache for remote makefiles
+// (one <sha256>.mk file per pinned download).
+func MakefileCacheDir(home string) string { return filepath.Join(CacheDir(home), "makefiles") }
+
+// TargetsCachePath returns the cached `decomk targets` listing.
+func TargetsCachePath(home string) string { return filepath.Join(CacheDir(home), "targets.json") }
+
+// ResolveCacheDir returns the cache of resolution results (merged config,
+// workspace inspections, and the parsed make database) that lets repeated
+// invocations skip unchanged work.
+func ResolveCacheDir(home string) string { return filepath.Join(CacheDir(home), "resolve") }
+
+// ConfTarballCacheDir returns the content-addressed cache for config tarballs
+// (one <sha256>.tar.gz file per pinned download).
+func ConfTarballCacheDir(home string) string { return filepath.Join(CacheDir(home), "conf") }
+
+// GoModCacheDir returns the GOMODCACHE decomk uses when building the tool,
+// shared by every self-update under home.
+func GoModCacheDir(home string) string { return filepath.Join(CacheDir(home), "gomod") }
+
+// BundleCacheDir returns where git bundles named by http(s) tool or conf URIs
+// are downloaded before each sync.
+func BundleCacheDir(home string) string { return filepath.Join(CacheDir(home), "bundles") }
+
 // Home resolves the decomk home directory.
 //
 // Precedence:
@@ -186,12 +374,21 @@ func SafeComponent(s string) string {
 // themselves.
 func StampDir(home string) string { return StampsDir(home) }
 
+// LocalConfigPath returns the local override config that `decomk config set`
+// writes, layered between the config repo and an explicit -config.
+func LocalConfigPath(home string) string { return filepath.Join(home, "local.conf") }
+
 // EnvFile returns the env export file path.
 //
 // This file is intentionally stable so other processes can source it after
 // running decomk. It is overwritten on each invocation.
 func EnvFile(home string) string { return filepath.Join(home, "env.sh") }
 
+// SecretsEnvFile returns the path to the export file holding decrypted
+// secrets. It is written with mode 0600 and only when the config has
+// encrypted values.
+func SecretsEnvFile(home string) string { return filepath.Join(home, "secrets.env") }
+
 // EnsureDir ensures a directory exists with safe permissions.
 func EnsureDir(path string) error {
 	return os.MkdirAll(path, 0o755)
@@ -202,24 +399,232 @@ func EnsureParentDir(path string) error {
 	return EnsureDir(filepath.Dir(path))
 }
 
-// Lock is an advisory file lock held via flock(2).
+// AtomicWrite replaces path with content, creating its parent directory.
+//
+// content goes to a temp file in the same directory, which is fsynced and
+// renamed over path; the directory is then fsynced so the rename survives a
+// crash. Readers therefore see either the old or the new file, never a
+// truncated one.
+//
+// Intent: Keep a container killed mid-run from leaving truncated state that
+// confuses later runs.
+// Source: DI-bihal (TODO-jirin)
+func AtomicWrite(path string, content []byte, mode os.FileMode) (err error) {
+	dir := filepath.Dir(path)
+	if err := EnsureDir(dir); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		if err != nil {
+			if removeErr := os.Remove(tmpPath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+				err = errors.Join(err, fmt.Errorf("remove temp file %s: %w", tmpPath, removeErr))
+			}
+		}
+	}()
+
+	// Chmod rather than CreateTemp's mode, so the umask cannot narrow mode.
+	//
+	// Intent: Keep DECOMK_HOME artifacts world-readable by enforcing their mode
+	// after create, independent of process umask.
+	// Source: DI-kidaj (TODO-mirut)
+	if err := tmp.Chmod(mode); err != nil {
+		return closeAfter(tmp, err)
+	}
+	if _, err := tmp.Write(content); err != nil {
+		return closeAfter(tmp, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return closeAfter(tmp, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// closeAfter closes f after err, keeping both errors.
+//
+// Intent: Preserve temp-file close failures alongside chmod and write
+// failures so state file errors are never silently dropped.
+// Source: DI-golak (TODO-gamuz)
+func closeAfter(f *os.File, err error) error {
+	if closeErr := f.Close(); closeErr != nil {
+		return errors.Join(err, fmt.Errorf("close %s: %w", f.Name(), closeErr))
+	}
+	return err
+}
+
+// syncDir fsyncs dir so a rename into it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		return closeAfter(d, fmt.Errorf("sync %s: %w", dir, err))
+	}
+	return d.Close()
+}
+
+// Lock is an advisory file lock held via flock(2), or, on filesystems without
+// flock, via a PID file (see pidLockPath).
 //
 // This is intended to prevent concurrent decomk invocations from mutating the
 // same state directories at the same time.
 type Lock struct {
 	f *os.File
+	// pidPath is the PID file held instead of a flock, if any.
+	pidPath string
+	// reclaimed is the stale holder this lock was taken over from, if any.
+	reclaimed *StaleLock
+}
+
+// StaleLock describes a lock whose recorded holder was gone when another
+// process took it (see Lock.Reclaimed).
+type StaleLock struct {
+	Path   string
+	Holder LockHolder
+	// Reason says why the holder counts as gone, for example "pid 42 exited".
+	Reason string
+}
+
+// flock is syscall.Flock; tests replace it to simulate filesystems without
+// flock support.
+var flock = syscall.Flock
+
+const (
+	// pidLockPoll is how often a blocking PID-file lock retries.
+	pidLockPoll = 200 * time.Millisecond
+	// pidLockGrace is how long a PID file may exist without a PID.
+	pidLockGrace = 10 * time.Second
+)
+
+// LockHolder describes a process holding or waiting for a lock.
+type LockHolder struct {
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+	// Since is when the process acquired the lock, or started waiting for it.
+	Since time.Time `json:"since"`
+	// RunID identifies the holder's run (and its log directory), if any.
+	RunID string `json:"runId,omitempty"`
+	// Host is the holder's hostname; a PID only identifies a process on its
+	// own host (or container).
+	Host string `json:"host,omitempty"`
+	// Start is when the holder process started, in clock ticks since boot
+	// (0 when unknown), which tells it apart from a later process that
+	// reuses its PID.
+	Start uint64 `json:"start,omitempty"`
+}
+
+// NewLockHolder describes the current process running command.
+func NewLockHolder(command string) LockHolder {
+	pid := os.Getpid()
+	start, _ := processStart(pid)
+	return LockHolder{PID: pid, Command: command, Host: localHost(), Start: start}
+}
+
+// Remote reports whether h was recorded on another host, where its liveness
+// cannot be checked.
+func (h LockHolder) Remote() bool {
+	return h.Host != "" && h.Host != localHost()
+}
+
+// Gone returns why h no longer holds its lock, or "" when it may still: its
+// PID has exited, or now belongs to a process that started at another time.
+// Remote holders are never gone.
+//
+// Intent: Reclaim and report locks left by a killed container or process,
+// without trusting a PID that a restart may have handed to another process.
+// Source: DI-romuj (TODO-jirin)
+func (h LockHolder) Gone() string {
+	switch {
+	case h.Remote():
+		return ""
+	case !ProcessAlive(h.PID):
+		return fmt.Sprintf("pid %d exited", h.PID)
+	}
+	if start, ok := processStart(h.PID); ok && h.Start != 0 && start != h.Start {
+		return fmt.Sprintf("pid %d now belongs to another process", h.PID)
+	}
+	return ""
+}
+
+// localHost returns this host's name, or "" when it is unknown.
+func localHost() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// processStart returns when pid started, in clock ticks since boot, from
+// /proc/PID/stat. ok is false where /proc is unavailable.
+func processStart(pid int) (start uint64, ok bool) {
+	content, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, false
+	}
+	// The command name (field 2) may contain spaces and parentheses, so
+	// count fields after its closing parenthesis; starttime is field 22.
+	i := strings.LastIndexByte(string(content), ')')
+	if i < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(string(content[i+1:]))
+	if len(fields) < 20 {
+		return 0, false
+	}
+	start, err = strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return start, true
 }
 
 // LockFile opens and exclusively locks lockPath, creating it if needed.
 //
 // The lock is blocking: callers will wait until the lock becomes available.
 func LockFile(lockPath string) (*Lock, error) {
+	lock, _, err := lockFile(lockPath, syscall.LOCK_EX)
+	return lock, err
+}
+
+// TryLockFile is LockFile without waiting: ok is false (with a nil lock and
+// error) when another process holds the lock.
+func TryLockFile(lockPath string) (lock *Lock, ok bool, err error) {
+	return lockFile(lockPath, syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// LockFileShared is LockFile for a shared lock, which any number of
+// processes may hold at once but not while one holds it exclusively.
+func LockFileShared(lockPath string) (*Lock, error) {
+	lock, _, err := lockFile(lockPath, syscall.LOCK_SH)
+	return lock, err
+}
+
+// TryLockFileShared is LockFileShared without waiting (see TryLockFile).
+func TryLockFileShared(lockPath string) (lock *Lock, ok bool, err error) {
+	return lockFile(lockPath, syscall.LOCK_SH|syscall.LOCK_NB)
+}
+
+// lockFile opens lockPath and flocks it with how, reporting ok=false when a
+// non-blocking attempt finds the lock held.
+func lockFile(lockPath string, how int) (*Lock, bool, error) {
 	if err := EnsureParentDir(lockPath); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	// Intent: Keep DECOMK_HOME lock files world-readable by enforcing mode after
 	// open, independent of process umask or prior restrictive file modes.
@@ -229,20 +634,223 @@ func LockFile(lockPath string) (*Lock, error) {
 		// close errors so permission issues are diagnosable instead of silent.
 		// Source: DI-golak (TODO-gamuz)
 		if closeErr := f.Close(); closeErr != nil {
-			return nil, errors.Join(err, fmt.Errorf("close lock file after chmod failure: %w", closeErr))
+			return nil, false, errors.Join(err, fmt.Errorf("close lock file after chmod failure: %w", closeErr))
 		}
-		return nil, err
+		return nil, false, err
 	}
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+	if err := flock(int(f.Fd()), how); err != nil {
+		if flockUnsupported(err) {
+			return lockPIDFile(f, lockPath, how&syscall.LOCK_NB == 0)
+		}
 		// Intent: Never drop lock-acquire cleanup failures; preserve both lock and
 		// close errors so lockfile issues are diagnosable instead of silent.
 		// Source: DI-golak (TODO-gamuz)
 		if closeErr := f.Close(); closeErr != nil {
-			return nil, errors.Join(err, fmt.Errorf("close lock file after flock failure: %w", closeErr))
+			return nil, false, errors.Join(err, fmt.Errorf("close lock file after flock failure: %w", closeErr))
+		}
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	lock := &Lock{f: f}
+	if err := lock.reclaimStaleHolder(lockPath); err != nil {
+		return nil, false, errors.Join(err, lock.Close())
+	}
+	return lock, true, nil
+}
+
+// reclaimStaleHolder checks the just-taken lock file for a holder record. A
+// holder clears its record when it releases the lock, so a record left behind
+// belongs to a process that died holding it; reclaimStaleHolder clears it and
+// remembers it for Reclaimed.
+func (l *Lock) reclaimStaleHolder(lockPath string) error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	content := make([]byte, info.Size())
+	if _, err := l.f.ReadAt(content, 0); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read lock holder: %w", err)
+	}
+	var holder LockHolder
+	if json.Unmarshal(content, &holder) != nil || holder.PID <= 0 {
+		return nil
+	}
+	if err := l.f.Truncate(0); err != nil {
+		return fmt.Errorf("clear stale lock holder: %w", err)
+	}
+	if l.reclaimed == nil {
+		reason := holder.Gone()
+		if reason == "" {
+			reason = "the lock was free but still named this holder"
+		}
+		l.reclaimed = &StaleLock{Path: lockPath, Holder: holder, Reason: reason}
+	}
+	return nil
+}
+
+// Reclaimed returns the holder this lock was taken over from, when it found
+// the previous holder gone instead of released.
+func (l *Lock) Reclaimed() (StaleLock, bool) {
+	if l == nil || l.reclaimed == nil {
+		return StaleLock{}, false
+	}
+	return *l.reclaimed, true
+}
+
+// flockUnsupported reports whether a flock error means the filesystem (for
+// example a WSL drive mount or some network filesystems) cannot flock at all.
+func flockUnsupported(err error) bool {
+	return errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, syscall.ENOLCK) || errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EINVAL)
+}
+
+// pidLockPath returns the PID file that stands in for a flock on lockPath.
+func pidLockPath(lockPath string) string { return lockPath + ".pid" }
+
+// lockPIDFile takes the lock on lockPath, whose open file is f, by creating
+// its PID file exclusively, removing the file first when the PID it names has
+// exited. With wait it polls until the lock is free; otherwise it reports
+// ok=false at once. Shared locks are taken exclusively.
+//
+// Intent: Keep decomk working on filesystems without flock instead of failing
+// every run.
+// Source: DI-rulaf (TODO-jirin)
+func lockPIDFile(f *os.File, lockPath string, wait bool) (*Lock, bool, error) {
+	pidPath := pidLockPath(lockPath)
+	self := NewLockHolder("")
+	var reclaimed *StaleLock
+	for {
+		pf, err := os.OpenFile(pidPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, writeErr := fmt.Fprintf(pf, "%d %d %s\n", self.PID, self.Start, self.Host)
+			if closeErr := pf.Close(); writeErr == nil {
+				writeErr = closeErr
+			}
+			if writeErr != nil {
+				return nil, false, errors.Join(writeErr, os.Remove(pidPath), f.Close())
+			}
+			lock := &Lock{f: f, pidPath: pidPath, reclaimed: reclaimed}
+			if err := lock.reclaimStaleHolder(lockPath); err != nil {
+				return nil, false, errors.Join(err, lock.Close())
+			}
+			return lock, true, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, false, errors.Join(err, f.Close())
+		}
+		stale, err := pidFileStale(pidPath)
+		if err != nil {
+			return nil, false, errors.Join(err, f.Close())
+		}
+		if stale != nil {
+			if err := os.Remove(pidPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, false, errors.Join(err, f.Close())
+			}
+			stale.Path = lockPath
+			reclaimed = stale
+			continue
+		}
+		if !wait {
+			return nil, false, f.Close()
 		}
+		time.Sleep(pidLockPoll)
+	}
+}
+
+// pidFileStale returns the holder recorded in the PID file at path when it is
+// gone (see LockHolder.Gone), or nil while it may still hold the lock. The
+// file holds "PID START HOST"; files from older versions hold only the PID.
+// A file without a PID is stale once it is older than pidLockGrace, which
+// leaves its creator time to write the PID.
+func pidFileStale(path string) (*StaleLock, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
 		return nil, err
 	}
-	return &Lock{f: f}, nil
+	fields := strings.Fields(string(content))
+	var pid int
+	if len(fields) > 0 {
+		pid, err = strconv.Atoi(fields[0])
+	}
+	if len(fields) == 0 || err != nil {
+		if time.Since(info.ModTime()) > pidLockGrace {
+			return &StaleLock{Reason: "its PID file names no process"}, nil
+		}
+		return nil, nil
+	}
+	holder := LockHolder{PID: pid}
+	if len(fields) > 1 {
+		if start, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			holder.Start = start
+		}
+	}
+	if len(fields) > 2 {
+		holder.Host = fields[2]
+	}
+	if reason := holder.Gone(); reason != "" {
+		return &StaleLock{Holder: holder, Reason: reason}, nil
+	}
+	return nil, nil
+}
+
+// SetHolder records holder in the held lock file, replacing its contents, so
+// waiting processes can report who they wait for (see ReadLockHolder).
+//
+// Intent: Tell a user whose run is blocked on another run what it is waiting
+// for and roughly how long, instead of hanging silently.
+// Source: DI-zokip (TODO-jirin)
+func (l *Lock) SetHolder(holder LockHolder) error {
+	content, err := json.Marshal(holder)
+	if err != nil {
+		return fmt.Errorf("encode lock holder: %w", err)
+	}
+	if err := l.f.Truncate(0); err != nil {
+		return fmt.Errorf("record lock holder: %w", err)
+	}
+	if _, err := l.f.WriteAt(append(content, '\n'), 0); err != nil {
+		return fmt.Errorf("record lock holder: %w", err)
+	}
+	return nil
+}
+
+// ReadLockHolder returns the holder SetHolder recorded in lockPath. ok is
+// false when no holder is recorded or it is gone (see LockHolder.Gone).
+func ReadLockHolder(lockPath string) (holder LockHolder, ok bool, err error) {
+	content, err := os.ReadFile(lockPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return LockHolder{}, false, nil
+	}
+	if err != nil {
+		return LockHolder{}, false, err
+	}
+	if json.Unmarshal(content, &holder) != nil || holder.Gone() != "" {
+		return LockHolder{}, false, nil
+	}
+	return holder, true, nil
+}
+
+// ProcessAlive reports whether a process with pid exists.
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
 }
 
 // Close unlocks and closes the lock file.
@@ -253,9 +861,21 @@ func (l *Lock) Close() error {
 	// Intent: Return both unlock and close failures so lock lifecycle errors are
 	// explicit and never dropped.
 	// Source: DI-golak (TODO-gamuz)
-	unlockErr := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	var clearErr error
+	if err := l.f.Truncate(0); err != nil {
+		clearErr = fmt.Errorf("clear lock holder: %w", err)
+	}
+	var unlockErr error
+	if l.pidPath != "" {
+		unlockErr = os.Remove(l.pidPath)
+	} else {
+		unlockErr = flock(int(l.f.Fd()), syscall.LOCK_UN)
+	}
 	closeErr := l.f.Close()
 	l.f = nil
+	if clearErr != nil {
+		return errors.Join(clearErr, unlockErr, closeErr)
+	}
 
 	if unlockErr != nil && closeErr != nil {
 		return errors.Join(
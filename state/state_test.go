@@ -1,10 +1,15 @@
 package state
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestSafeComponent_IsSinglePathComponent(t *testing.T) {
@@ -80,3 +85,166 @@ func TestLockFile_EnsuresWorldReadableMode(t *testing.T) {
 		t.Fatalf("lock mode: got %04o want %04o", got, want)
 	}
 }
+
+// TestLockFile_PIDFileFallback simulates a filesystem without flock; it does
+// not run in parallel because it replaces flock.
+func TestLockFile_PIDFileFallback(t *testing.T) {
+	flock = func(int, int) error { return syscall.ENOTSUP }
+	defer func() { flock = syscall.Flock }()
+
+	lockPath := filepath.Join(t.TempDir(), ".lock")
+	lock, err := LockFile(lockPath)
+	if err != nil {
+		t.Fatalf("LockFile(): %v", err)
+	}
+	if got, err := os.ReadFile(pidLockPath(lockPath)); err != nil || strings.Fields(string(got))[0] != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("PID file: got %q, %v want %d first", got, err, os.Getpid())
+	}
+	if other, ok, err := TryLockFileShared(lockPath); err != nil || ok || other != nil {
+		t.Fatalf("TryLockFileShared(held): got %v, %v, %v want nil, false, nil", other, ok, err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Lock.Close(): %v", err)
+	}
+	if _, err := os.Stat(pidLockPath(lockPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("PID file after Close(): got %v want not exist", err)
+	}
+
+	// A PID file left by an exited process does not block.
+	if err := os.WriteFile(pidLockPath(lockPath), []byte("999999999\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile(stale PID file): %v", err)
+	}
+	lock, ok, err := TryLockFile(lockPath)
+	if err != nil || !ok {
+		t.Fatalf("TryLockFile(stale): got %v, %v want true, nil", ok, err)
+	}
+	if stale, ok := lock.Reclaimed(); !ok || stale.Path != lockPath || stale.Reason != "pid 999999999 exited" {
+		t.Fatalf("Reclaimed(): got %+v, %v want pid 999999999 exited", stale, ok)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Lock.Close(): %v", err)
+	}
+}
+
+func TestTryLockFile_RecordsHolder(t *testing.T) {
+	t.Parallel()
+
+	lockPath := filepath.Join(t.TempDir(), ".lock")
+	lock, ok, err := TryLockFile(lockPath)
+	if err != nil || !ok {
+		t.Fatalf("TryLockFile(): got %v, %v want true, nil", ok, err)
+	}
+	holder := LockHolder{PID: os.Getpid(), Command: "decomk run INSTALL", Since: time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)}
+	if err := lock.SetHolder(holder); err != nil {
+		t.Fatalf("SetHolder(): %v", err)
+	}
+
+	if other, ok, err := TryLockFile(lockPath); err != nil || ok || other != nil {
+		t.Fatalf("TryLockFile(held): got %v, %v, %v want nil, false, nil", other, ok, err)
+	}
+	got, ok, err := ReadLockHolder(lockPath)
+	if err != nil || !ok || got != holder {
+		t.Fatalf("ReadLockHolder(): got %+v, %v, %v want %+v, true, nil", got, ok, err, holder)
+	}
+
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Lock.Close(): %v", err)
+	}
+	if _, ok, err := ReadLockHolder(lockPath); err != nil || ok {
+		t.Fatalf("ReadLockHolder(released): got %v, %v want false, nil", ok, err)
+	}
+}
+
+func TestAtomicWrite(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "home")
+	path := filepath.Join(dir, "status")
+	for _, content := range []string{"result=failed\n", "result=ok\n"} {
+		if err := AtomicWrite(path, []byte(content), 0o640); err != nil {
+			t.Fatalf("AtomicWrite(%q): %v", content, err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != content {
+			t.Fatalf("ReadFile(): got %q, %v want %q, nil", got, err, content)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Fatalf("AtomicWrite(): got mode %v want %v", info.Mode().Perm(), os.FileMode(0o640))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("AtomicWrite(): got %d entries in %s want only the target (no temp files)", len(entries), dir)
+	}
+
+	// A failed write leaves the old content and no temp file behind.
+	if err := AtomicWrite(filepath.Join(path, "child"), []byte("x"), 0o644); err == nil {
+		t.Fatalf("AtomicWrite(under a file): got nil error want failure")
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "result=ok\n" {
+		t.Fatalf("ReadFile(after failure): got %q, %v want %q, nil", got, err, "result=ok\n")
+	}
+}
+
+func TestLockFile_ReclaimsStaleHolder(t *testing.T) {
+	t.Parallel()
+
+	lockPath := filepath.Join(t.TempDir(), ".lock")
+	if err := os.WriteFile(lockPath, []byte(`{"pid":999999999,"command":"decomk run INSTALL"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lock, ok, err := TryLockFile(lockPath)
+	if err != nil || !ok {
+		t.Fatalf("TryLockFile(): got %v, %v want true, nil", ok, err)
+	}
+	stale, ok := lock.Reclaimed()
+	if !ok || stale.Holder.Command != "decomk run INSTALL" || stale.Reason != "pid 999999999 exited" {
+		t.Fatalf("Reclaimed(): got %+v, %v want the exited decomk run INSTALL", stale, ok)
+	}
+	if _, ok, err := ReadLockHolder(lockPath); err != nil || ok {
+		t.Fatalf("ReadLockHolder(reclaimed): got %v, %v want false, nil", ok, err)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Lock.Close(): %v", err)
+	}
+
+	// A cleanly released lock has nothing to reclaim.
+	lock, ok, err = TryLockFile(lockPath)
+	if err != nil || !ok {
+		t.Fatalf("TryLockFile(released): got %v, %v want true, nil", ok, err)
+	}
+	if stale, ok := lock.Reclaimed(); ok {
+		t.Fatalf("Reclaimed(released): got %+v want none", stale)
+	}
+	if err := lock.Close(); err != nil {
+		t.Fatalf("Lock.Close(): %v", err)
+	}
+}
+
+func TestLockHolder_Gone(t *testing.T) {
+	t.Parallel()
+
+	self := NewLockHolder("decomk run")
+	if reason := self.Gone(); reason != "" {
+		t.Fatalf("Gone(self): got %q want \"\"", reason)
+	}
+	remote := LockHolder{PID: 999999999, Host: self.Host + "-elsewhere"}
+	if !remote.Remote() || remote.Gone() != "" {
+		t.Fatalf("Gone(remote): got remote %v, %q want true, \"\"", remote.Remote(), remote.Gone())
+	}
+	if self.Start == 0 {
+		t.Skip("process start times are unavailable without /proc")
+	}
+	reused := self
+	reused.Start++
+	if reason := reused.Gone(); reason != fmt.Sprintf("pid %d now belongs to another process", self.PID) {
+		t.Fatalf("Gone(reused PID): got %q", reason)
+	}
+}
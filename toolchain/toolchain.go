@@ -0,0 +1,283 @@
+// Package toolchain turns version-manager tokens from decomk.conf into make
+// install targets and environment tuples.
+//
+// A toolchain token has the form:
+//
+//	<manager>:<tool>@<version>
+//
+// for example `mise:python@3.12` or `asdf:nodejs@20.11.1`. Supported managers
+// are mise and asdf. An unprefixed <tool>@<version> token written right after
+// one inherits its manager (see Qualify), so `mise:python@3.12 node@20`
+// requests two mise tools. Tokens are extracted from the expanded token stream before
+// tuple partitioning, so they can be placed in any context or macro body.
+//
+// For each token decomk generates one stamp-style make target that installs the
+// requested version, and exports tuples that make the manager's shims select
+// that version:
+//   - the manager data dir (MISE_DATA_DIR / ASDF_DATA_DIR)
+//   - one version selector per tool (MISE_<TOOL>_VERSION / ASDF_<TOOL>_VERSION)
+//   - the shim directory, prepended to PATH via DECOMK_PATH_PREPEND
+//
+// Intent: Keep toolchain version policy in decomk.conf rather than scattered
+// .tool-versions handling inside recipes, while still executing installs
+// through make stamps.
+// Source: DI-zisok (TODO-jirin)
+package toolchain
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// ManagerMise is the token prefix for mise-managed tools.
+	ManagerMise = "mise"
+	// ManagerAsdf is the token prefix for asdf-managed tools.
+	ManagerAsdf = "asdf"
+
+	// TargetsTuple lists the generated install targets so operators can select
+	// them as one action arg (`decomk run DECOMK_TOOLCHAIN_TARGETS`).
+	TargetsTuple = "DECOMK_TOOLCHAIN_TARGETS"
+
+	// PathPrependTuple must match the managed-PATH tuple name consumed by
+	// cmd/decomk.
+	PathPrependTuple = "DECOMK_PATH_PREPEND"
+)
+
+// Spec is one parsed toolchain token.
+type Spec struct {
+	Manager string
+	Tool    string
+	Version string
+}
+
+// IsToken reports whether token uses a known toolchain manager prefix.
+//
+// It does not validate the rest of the token; use Parse for that.
+func IsToken(token string) bool {
+	manager, _, ok := strings.Cut(token, ":")
+	if !ok {
+		return false
+	}
+	return manager == ManagerMise || manager == ManagerAsdf
+}
+
+// Parse parses a `<manager>:<tool>@<version>` token.
+func Parse(token string) (Spec, error) {
+	manager, rest, ok := strings.Cut(token, ":")
+	if !ok || (manager != ManagerMise && manager != ManagerAsdf) {
+		return Spec{}, fmt.Errorf("toolchain token %q must start with %s: or %s:", token, ManagerMise, ManagerAsdf)
+	}
+	tool, version, ok := strings.Cut(rest, "@")
+	if !ok {
+		return Spec{}, fmt.Errorf("toolchain token %q must be <manager>:<tool>@<version>", token)
+	}
+	if !isSafeName(tool) {
+		return Spec{}, fmt.Errorf("toolchain token %q has invalid tool %q (allowed: letters, numbers, '.', '_', '-')", token, tool)
+	}
+	if !isSafeName(version) {
+		return Spec{}, fmt.Errorf("toolchain token %q has invalid version %q (allowed: letters, numbers, '.', '_', '-')", token, version)
+	}
+	return Spec{Manager: manager, Tool: tool, Version: version}, nil
+}
+
+// Extract splits toolchain tokens out of an expanded token list.
+//
+// It returns the parsed specs (deduplicated, first occurrence wins) and the
+// remaining non-toolchain tokens in their original order. Requesting two
+// different versions of the same manager/tool pair is an error, because only
+// one version can be selected through the exported version variable.
+func Extract(tokens []string) (specs []Spec, rest []string, err error) {
+	seen := make(map[string]Spec)
+	for _, token := range tokens {
+		if !IsToken(token) {
+			rest = append(rest, token)
+			continue
+		}
+		spec, err := Parse(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := spec.Manager + ":" + spec.Tool
+		if prior, ok := seen[key]; ok {
+			if prior.Version != spec.Version {
+				return nil, nil, fmt.Errorf("conflicting toolchain versions for %s: %s and %s", key, prior.Version, spec.Version)
+			}
+			continue
+		}
+		seen[key] = spec
+		specs = append(specs, spec)
+	}
+	return specs, rest, nil
+}
+
+// Qualify returns tokens with each unprefixed <tool>@<version> token that
+// follows a toolchain token, directly or through other such tokens, prefixed
+// with that token's manager: `mise:python@3.12 node@20` becomes
+// `mise:python@3.12 mise:node@20`. Any other token ends the run, and
+// unprefixed tokens outside a run are returned unchanged (see CheckPrefix).
+//
+// Intent: Accept the compact form where one prefix covers the tools listed
+// after it, while keeping each run explicit on the line that writes it.
+// Source: DI-mopin (TODO-jirin)
+func Qualify(tokens []string) []string {
+	out := make([]string, 0, len(tokens))
+	manager := ""
+	for _, token := range tokens {
+		switch {
+		case IsToken(token):
+			manager, _, _ = strings.Cut(token, ":")
+		case manager != "" && isBareTool(token):
+			token = manager + ":" + token
+		default:
+			manager = ""
+		}
+		out = append(out, token)
+	}
+	return out
+}
+
+// CheckPrefix returns an error when token has the <tool>@<version> form of a
+// toolchain token but no manager prefix, and so did not follow one for
+// Qualify to inherit, as node@20 does in `FOO=1 node@20`.
+func CheckPrefix(token string) error {
+	if !isBareTool(token) {
+		return nil
+	}
+	return fmt.Errorf("toolchain token %q has no manager prefix; prefix it (%s:%s) or list it right after a %s: or %s: token", token, ManagerMise, token, ManagerMise, ManagerAsdf)
+}
+
+// isBareTool reports whether token is <tool>@<version> without a manager.
+func isBareTool(token string) bool {
+	tool, version, ok := strings.Cut(token, "@")
+	return ok && isSafeName(tool) && isSafeName(version)
+}
+
+// Target returns the generated make target name for s.
+func (s Spec) Target() string {
+	return s.Manager + "-" + s.Tool + "-" + s.Version
+}
+
+// Tuples returns the NAME=value tuples that expose the requested versions to
+// make recipes, env.sh, and interactive shells.
+//
+// dataDir maps a manager name to its data directory. currentPathPrepend is the
+// effective DECOMK_PATH_PREPEND value before toolchains are applied; shim
+// directories are placed ahead of it.
+func Tuples(specs []Spec, dataDir func(manager string) string, currentPathPrepend string) []string {
+	if len(specs) == 0 {
+		return nil
+	}
+	var (
+		out      []string
+		managers []string
+		targets  []string
+	)
+	seenManager := make(map[string]bool)
+	for _, spec := range specs {
+		if !seenManager[spec.Manager] {
+			seenManager[spec.Manager] = true
+			managers = append(managers, spec.Manager)
+		}
+		targets = append(targets, spec.Target())
+	}
+	sort.Strings(managers)
+
+	var shims []string
+	for _, manager := range managers {
+		dir := dataDir(manager)
+		out = append(out, dataDirVar(manager)+"="+dir)
+		shims = append(shims, filepath.Join(dir, "shims"))
+	}
+	for _, spec := range specs {
+		out = append(out, versionVar(spec)+"="+spec.Version)
+	}
+	pathPrepend := strings.Join(shims, " ")
+	if strings.TrimSpace(currentPathPrepend) != "" {
+		pathPrepend += " " + currentPathPrepend
+	}
+	out = append(out, PathPrependTuple+"="+pathPrepend)
+	out = append(out, TargetsTuple+"="+strings.Join(targets, " "))
+	return out
+}
+
+// RenderMakefile renders the generated make fragment with one install target
+// per spec.
+//
+// Targets follow decomk's stamp model: they run in the stamp directory and
+// touch $@ last, so a version installs once until its stamp is deleted.
+func RenderMakefile(specs []Spec) []byte {
+	var b bytes.Buffer
+	b.WriteString("# generated by decomk from decomk.conf toolchain tokens; do not edit\n")
+	for _, spec := range specs {
+		b.WriteString("\n")
+		b.WriteString(spec.Target())
+		b.WriteString(":\n")
+		for _, line := range installRecipe(spec) {
+			b.WriteString("\t")
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+		b.WriteString("\ttouch $@\n")
+	}
+	return b.Bytes()
+}
+
+// installRecipe returns the recipe lines (without the stamp touch) that
+// install one tool version and refresh its shims.
+func installRecipe(spec Spec) []string {
+	switch spec.Manager {
+	case ManagerAsdf:
+		return []string{
+			fmt.Sprintf("asdf plugin list | grep -qx '%s' || asdf plugin add '%s'", spec.Tool, spec.Tool),
+			fmt.Sprintf("asdf install '%s' '%s'", spec.Tool, spec.Version),
+			fmt.Sprintf("asdf reshim '%s' '%s'", spec.Tool, spec.Version),
+		}
+	default:
+		return []string{
+			fmt.Sprintf("mise install '%s@%s'", spec.Tool, spec.Version),
+			"mise reshim",
+		}
+	}
+}
+
+// dataDirVar returns the manager-specific data-dir environment variable.
+func dataDirVar(manager string) string {
+	return strings.ToUpper(manager) + "_DATA_DIR"
+}
+
+// versionVar returns the manager-specific per-tool version selector variable
+// (for example MISE_PYTHON_VERSION or ASDF_NODEJS_VERSION).
+func versionVar(spec Spec) string {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(spec.Manager))
+	b.WriteString("_")
+	for _, r := range strings.ToUpper(spec.Tool) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteRune('_')
+	}
+	b.WriteString("_VERSION")
+	return b.String()
+}
+
+// isSafeName reports whether s is a non-empty shell/make-safe identifier.
+func isSafeName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.' || r == '_' || r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package toolchain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		token   string
+		want    Spec
+		wantErr string
+	}{
+		{token: "mise:python@3.12", want: Spec{Manager: "mise", Tool: "python", Version: "3.12"}},
+		{token: "asdf:nodejs@20.11.1", want: Spec{Manager: "asdf", Tool: "nodejs", Version: "20.11.1"}},
+		{token: "mise:python", wantErr: "must be <manager>:<tool>@<version>"},
+		{token: "mise:py thon@3", wantErr: "invalid tool"},
+		{token: "asdf:go@1.22;rm", wantErr: "invalid version"},
+		{token: "brew:go@1.22", wantErr: "must start with mise: or asdf:"},
+	}
+	for _, tc := range cases {
+		got, err := Parse(tc.token)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("Parse(%q) error: got %v want substring %q", tc.token, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Parse(%q) error: %v", tc.token, err)
+		}
+		if got != tc.want {
+			t.Fatalf("Parse(%q): got %#v want %#v", tc.token, got, tc.want)
+		}
+	}
+}
+
+func TestExtract_DedupesAndRejectsConflicts(t *testing.T) {
+	t.Parallel()
+
+	specs, rest, err := Extract([]string{"FOO=bar", "mise:python@3.12", "mise:node@20", "mise:python@3.12", "BAR=baz"})
+	if err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	if got, want := rest, []string{"FOO=bar", "BAR=baz"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rest: got %#v want %#v", got, want)
+	}
+	if got, want := len(specs), 2; got != want {
+		t.Fatalf("len(specs): got %d want %d", got, want)
+	}
+
+	_, _, err = Extract([]string{"mise:python@3.12", "mise:python@3.11"})
+	if err == nil || !strings.Contains(err.Error(), "conflicting toolchain versions for mise:python") {
+		t.Fatalf("Extract(conflict) error: got %v", err)
+	}
+}
+
+func TestQualify(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		in, want string
+	}{
+		{in: "mise:python@3.12 node@20", want: "mise:python@3.12 mise:node@20"},
+		{in: "asdf:nodejs@20 ruby@3.3 go@1.22 FOO=1 node@20", want: "asdf:nodejs@20 asdf:ruby@3.3 asdf:go@1.22 FOO=1 node@20"},
+		{in: "mise:python@3.12 asdf:ruby@3.3 node@20", want: "mise:python@3.12 asdf:ruby@3.3 asdf:node@20"},
+		{in: "node@20 mise:python@3.12", want: "node@20 mise:python@3.12"},
+		{in: "mise:python@3.12 FOO=a@b", want: "mise:python@3.12 FOO=a@b"},
+	}
+	for _, tc := range cases {
+		if got := strings.Join(Qualify(strings.Fields(tc.in)), " "); got != tc.want {
+			t.Fatalf("Qualify(%s): got %q want %q", tc.in, got, tc.want)
+		}
+	}
+
+	specs, _, err := Extract(Qualify(strings.Fields("mise:python@3.12 node@20")))
+	if err != nil {
+		t.Fatalf("Extract(Qualify(mise:python@3.12 node@20)) error: %v", err)
+	}
+	if got, want := specs, []Spec{{Manager: "mise", Tool: "python", Version: "3.12"}, {Manager: "mise", Tool: "node", Version: "20"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("specs: got %#v want %#v", got, want)
+	}
+}
+
+func TestTuples(t *testing.T) {
+	t.Parallel()
+
+	specs := []Spec{
+		{Manager: "mise", Tool: "python", Version: "3.12"},
+		{Manager: "asdf", Tool: "golang-ci", Version: "1.2.3"},
+	}
+	dataDir := func(manager string) string { return "/var/decomk/toolchains/" + manager }
+	got := Tuples(specs, dataDir, "/opt/bin")
+	want := []string{
+		"ASDF_DATA_DIR=/var/decomk/toolchains/asdf",
+		"MISE_DATA_DIR=/var/decomk/toolchains/mise",
+		"MISE_PYTHON_VERSION=3.12",
+		"ASDF_GOLANG_CI_VERSION=1.2.3",
+		"DECOMK_PATH_PREPEND=/var/decomk/toolchains/asdf/shims /var/decomk/toolchains/mise/shims /opt/bin",
+		"DECOMK_TOOLCHAIN_TARGETS=mise-python-3.12 asdf-golang-ci-1.2.3",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tuples():\n got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestRenderMakefile(t *testing.T) {
+	t.Parallel()
+
+	got := string(RenderMakefile([]Spec{{Manager: "mise", Tool: "python", Version: "3.12"}}))
+	for _, needle := range []string{
+		"mise-python-3.12:\n",
+		"\tmise install 'python@3.12'\n",
+		"\ttouch $@\n",
+	} {
+		if !strings.Contains(got, needle) {
+			t.Fatalf("RenderMakefile() missing %q:\n%s", needle, got)
+		}
+	}
+}